@@ -0,0 +1,132 @@
+// Command dvr-decrypt turns encrypted DVR segments back into one playable
+// FLV file. It takes segment files, or recording directories whose segments
+// it reads in order, and the key the relay sealed them with.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func main() {
+	configPath := flag.String("config", "", "Relay config to read dvr.encryption from")
+	key := flag.String("key", "", "Base64 AES key")
+	keyFile := flag.String("key-file", "", "File holding the base64 AES key")
+	keyCommand := flag.String("key-command", "", "Command printing the base64 AES key, split on spaces")
+	keyID := flag.String("key-id", "", "ID of the key; segments sealed with another ID are refused")
+	output := flag.String("o", "-", "Output FLV file, - for stdout")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: dvr-decrypt [flags] segment.flv|recording.dvr ...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	enc := config.DVREncryptionConfig{Enabled: true, KeyID: *keyID, Key: *key, KeyFile: *keyFile, KeyCommand: strings.Fields(*keyCommand)}
+	if *configPath != "" {
+		cfg, err := config.LoadFile(*configPath)
+		if err != nil {
+			fatal(err)
+		}
+		if !cfg.DVR.Encryption.Enabled {
+			fatal(fmt.Errorf("%s does not enable dvr.encryption", *configPath))
+		}
+		enc = cfg.DVR.Encryption
+	}
+	k, err := dvr.LoadKey(enc)
+	if err != nil {
+		fatal(err)
+	}
+	segments, err := segmentFiles(flag.Args())
+	if err != nil {
+		fatal(err)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fatal(err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	if err := decrypt(w, k, segments); err != nil {
+		fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// segmentFiles expands recording directories into their segments, oldest
+// first.
+func segmentFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.flv"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// decrypt writes the segments as one FLV file. Only the first segment's
+// headers are written: later sequence headers are among the recorded tags.
+func decrypt(w io.Writer, k *dvr.Key, segments []string) error {
+	if err := rtmp.WriteFLVHeader(w, true, true); err != nil {
+		return err
+	}
+	for i, path := range segments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		headers, tags, err := k.Open(data)
+		if errors.Is(err, dvr.ErrTruncated) {
+			fmt.Fprintf(os.Stderr, "dvr-decrypt: %s has no end record; writing what it holds\n", path)
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if i == 0 {
+			if _, err := w.Write(headers); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "dvr-decrypt: %v\n", err)
+	os.Exit(1)
+}
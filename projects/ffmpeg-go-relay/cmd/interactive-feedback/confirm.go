@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type confirmArgs struct {
+	Message    string `json:"message"`
+	Default    *bool  `json:"default,omitempty"`
+	TimeoutSec *int   `json:"timeoutSec,omitempty"`
+}
+
+var confirmTool = map[string]any{
+	"name":        "interactive_confirm",
+	"description": "Ask the user a yes/no question. Returns \"yes\" or \"no\".",
+	"inputSchema": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"message": map[string]any{
+				"type":        "string",
+				"description": "Question shown to the user.",
+			},
+			"default": map[string]any{
+				"type":        "boolean",
+				"description": "Answer when the user submits empty input or the prompt times out. Without it an answer is required.",
+			},
+			"timeoutSec": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Timeout in seconds (0 disables timeout).",
+			},
+		},
+		"required": []string{"message"},
+	},
+}
+
+func promptConfirm(args confirmArgs) (string, error) {
+	if strings.TrimSpace(args.Message) == "" {
+		return "", errors.New("message is required")
+	}
+
+	tty, err := openTTY()
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	hint := "[y/n]"
+	if args.Default != nil {
+		hint = "[y/N]"
+		if *args.Default {
+			hint = "[Y/n]"
+		}
+	}
+	fmt.Fprintf(tty, "%s %s ", args.Message, hint)
+
+	input, err := tty.readLine(args.TimeoutSec)
+	if (errors.Is(err, errTimeout) || (err == nil && input == "")) && args.Default != nil {
+		return yesNo(*args.Default), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(input) {
+	case "y", "yes":
+		return yesNo(true), nil
+	case "n", "no":
+		return yesNo(false), nil
+	case "":
+		return "", errors.New("empty input")
+	}
+	return "", errors.New("invalid answer")
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
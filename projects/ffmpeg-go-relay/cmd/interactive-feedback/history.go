@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted stands in for answers to interactive_secret, which are never
+// written to the history.
+const redacted = "[redacted]"
+
+// defaultHistoryLimit is how many entries feedback_history returns when the
+// caller does not say.
+const defaultHistoryLimit = 20
+
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Tool    string    `json:"tool"`
+	Message string    `json:"message"`
+	Options []string  `json:"options,omitempty"`
+	Answer  string    `json:"answer,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type historyArgs struct {
+	Query string `json:"query,omitempty"`
+	Tool  string `json:"tool,omitempty"`
+	Since string `json:"since,omitempty"`
+	Limit *int   `json:"limit,omitempty"`
+}
+
+var historyTool = map[string]any{
+	"name":        "feedback_history",
+	"description": "List earlier prompts and the user's answers, newest first, as a JSON array. Check it before asking a question the user may already have answered. Secret answers are redacted.",
+	"inputSchema": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query": map[string]any{
+				"type":        "string",
+				"description": "Only entries whose message or answer contains this text, ignoring case.",
+			},
+			"tool": map[string]any{
+				"type":        "string",
+				"description": "Only entries from this tool, e.g. interactive_confirm.",
+			},
+			"since": map[string]any{
+				"type":        "string",
+				"description": "Only entries at or after this RFC 3339 time, or within this duration of now (e.g. 2h).",
+			},
+			"limit": map[string]any{
+				"type":        "integer",
+				"minimum":     1,
+				"description": "Most entries to return (default 20).",
+			},
+		},
+	},
+}
+
+// history keeps every prompt and its outcome, appending each to a JSONL
+// file when it has one.
+type history struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	file    *os.File
+}
+
+// feedbackLog is the process's history, replaced by the file-backed one
+// when -history is set.
+var feedbackLog = &history{}
+
+// openHistory loads the entries already in path and appends new ones to it.
+func openHistory(path string) (*history, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h := &history{file: f}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "history: %s:%d: %v\n", path, line, err)
+			continue
+		}
+		h.entries = append(h.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *history) Close() error {
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
+
+// record stamps e with the current time and keeps it.
+func (h *history) record(e historyEntry) {
+	e.Time = time.Now().UTC()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	if h.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = h.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "history:", err)
+	}
+}
+
+// query returns the entries matching args as a JSON array, newest first.
+func (h *history) query(args historyArgs) (string, error) {
+	limit := defaultHistoryLimit
+	if args.Limit != nil {
+		if *args.Limit < 1 {
+			return "", fmt.Errorf("limit must be at least 1")
+		}
+		limit = *args.Limit
+	}
+	var since time.Time
+	if args.Since != "" {
+		if t, err := time.Parse(time.RFC3339, args.Since); err == nil {
+			since = t
+		} else if d, err := time.ParseDuration(args.Since); err == nil {
+			since = time.Now().Add(-d)
+		} else {
+			return "", fmt.Errorf("since %q is neither an RFC 3339 time nor a duration", args.Since)
+		}
+	}
+	query := strings.ToLower(args.Query)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	matches := []historyEntry{}
+	for i := len(h.entries) - 1; i >= 0 && len(matches) < limit; i-- {
+		e := h.entries[i]
+		if args.Tool != "" && e.Tool != args.Tool {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(e.Message), query) && !strings.Contains(strings.ToLower(e.Answer), query) {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	out, err := json.Marshal(matches)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
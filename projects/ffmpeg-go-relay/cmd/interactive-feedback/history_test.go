@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	now := time.Now().UTC()
+	h := &history{entries: []historyEntry{
+		{Time: now.Add(-3 * time.Hour), Tool: "interactive_feedback", Message: "Which branch?", Answer: "main"},
+		{Time: now.Add(-90 * time.Minute), Tool: "interactive_confirm", Message: "Deploy to staging?", Answer: "yes"},
+		{Time: now.Add(-time.Hour), Tool: "interactive_secret", Message: "API token", Answer: redacted},
+		{Time: now.Add(-time.Minute), Tool: "interactive_feedback", Message: "Release notes?", Answer: "Mention the Staging fix"},
+	}}
+	n := func(v int) *int { return &v }
+	for _, tc := range []struct {
+		name string
+		args historyArgs
+		want []string // Messages, newest first
+		err  string
+	}{
+		{"all", historyArgs{}, []string{"Release notes?", "API token", "Deploy to staging?", "Which branch?"}, ""},
+		{"limit", historyArgs{Limit: n(2)}, []string{"Release notes?", "API token"}, ""},
+		{"tool", historyArgs{Tool: "interactive_feedback"}, []string{"Release notes?", "Which branch?"}, ""},
+		{"query matches message or answer", historyArgs{Query: "STAGING"}, []string{"Release notes?", "Deploy to staging?"}, ""},
+		{"since duration", historyArgs{Since: "2h"}, []string{"Release notes?", "API token", "Deploy to staging?"}, ""},
+		{"since time", historyArgs{Since: now.Add(-75 * time.Minute).Format(time.RFC3339)}, []string{"Release notes?", "API token"}, ""},
+		{"filters combine", historyArgs{Tool: "interactive_feedback", Since: "2h", Query: "notes"}, []string{"Release notes?"}, ""},
+		{"nothing matches", historyArgs{Query: "nope"}, []string{}, ""},
+		{"zero limit", historyArgs{Limit: n(0)}, nil, "limit must be at least 1"},
+		{"bad since", historyArgs{Since: "yesterday"}, nil, `since "yesterday" is neither`},
+	} {
+		out, err := h.query(tc.args)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var entries []historyEntry
+		if err := json.Unmarshal([]byte(out), &entries); err != nil {
+			t.Fatalf("%s: %v in %s", tc.name, err, out)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Message)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHistoryFileSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.record(historyEntry{Tool: "interactive_confirm", Message: "Proceed?", Answer: "yes"})
+	h.Close()
+
+	h, err = openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if len(h.entries) != 1 || h.entries[0].Message != "Proceed?" || h.entries[0].Time.IsZero() {
+		t.Fatalf("entries = %+v, want the recorded prompt", h.entries)
+	}
+}
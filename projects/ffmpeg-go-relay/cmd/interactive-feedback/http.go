@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxMessageBytes bounds a POSTed JSON-RPC message.
+const maxMessageBytes = 1 << 20
+
+// sseServer implements the MCP HTTP with SSE transport of the 2024-11-05
+// spec: a client opens GET /sse, is told the endpoint to POST its messages
+// to, and receives every response as an SSE "message" event.
+type sseServer struct {
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+// sseSession queues the responses for one SSE stream.
+type sseSession struct {
+	out  chan []byte
+	done chan struct{}
+}
+
+// Encode queues v for the session's stream, dropping it once the client has
+// gone.
+func (s *sseSession) Encode(v any) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	select {
+	case s.out <- bytes.TrimSpace(buf.Bytes()):
+		return nil
+	case <-s.done:
+		return errors.New("session closed")
+	}
+}
+
+func serveHTTP(addr string) error {
+	srv := &sseServer{sessions: map[string]*sseSession{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", srv.handleStream)
+	mux.HandleFunc("POST /message", srv.handleMessage)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving MCP over SSE at http://%s/sse\n", ln.Addr())
+	httpSrv := &http.Server{Handler: checkOrigin(mux), ReadHeaderTimeout: 10 * time.Second}
+	return httpSrv.Serve(ln)
+}
+
+// checkOrigin rejects browser requests from pages not on a loopback host, so
+// a web page cannot drive the prompts through DNS rebinding.
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !isLoopback(u.Hostname()) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (srv *sseServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session := &sseSession{out: make(chan []byte, 16), done: make(chan struct{})}
+	srv.mu.Lock()
+	srv.sessions[id] = session
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.sessions, id)
+		srv.mu.Unlock()
+		close(session.done)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "event: endpoint\ndata: /message?sessionId=%s\n\n", id)
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-session.out:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (srv *sseServer) handleMessage(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	session := srv.sessions[r.URL.Query().Get("sessionId")]
+	srv.mu.Unlock()
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid JSON-RPC message", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	// A tool call waits on the user, so it is answered on the stream later.
+	go handleRequest(session, req)
+}
+
+func newSessionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
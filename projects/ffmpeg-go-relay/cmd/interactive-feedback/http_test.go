@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent returns the next SSE event's name and data.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestSSERoundTrip(t *testing.T) {
+	srv := &sseServer{sessions: map[string]*sseSession{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", srv.handleStream)
+	mux.HandleFunc("POST /message", srv.handleMessage)
+	ts := httptest.NewServer(checkOrigin(mux))
+	defer ts.Close()
+
+	// The deadline ends the stream if a response never comes.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/sse", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+	event, endpoint := readEvent(t, events)
+	if event != "endpoint" || !strings.HasPrefix(endpoint, "/message?sessionId=") {
+		t.Fatalf("first event = %s %q, want the message endpoint", event, endpoint)
+	}
+
+	post := func(path, body string, header http.Header) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		name, path, body string
+		header           http.Header
+		want             int
+	}{
+		{"unknown session", "/message?sessionId=nope", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, nil, http.StatusNotFound},
+		{"invalid JSON", endpoint, `{`, nil, http.StatusBadRequest},
+		{"foreign origin", endpoint, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.Header{"Origin": {"https://example.com"}}, http.StatusForbidden},
+		{"tools/list", endpoint, `{"jsonrpc":"2.0","id":7,"method":"tools/list"}`, http.Header{"Origin": {"http://localhost:3000"}}, http.StatusAccepted},
+	} {
+		if got := post(tc.path, tc.body, tc.header); got != tc.want {
+			t.Fatalf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	// Only the accepted request is answered, on the stream.
+	event, data := readEvent(t, events)
+	var got struct {
+		ID     json.RawMessage `json:"id"`
+		Result struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("%s event %q: %v", event, data, err)
+	}
+	if event != "message" || string(got.ID) != "7" || len(got.Result.Tools) == 0 || got.Result.Tools[0].Name != "interactive_feedback" {
+		t.Fatalf("got %s event %s, want the tools/list response", event, data)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -45,17 +47,47 @@ type feedbackArgs struct {
 	TimeoutSec    *int     `json:"timeoutSec,omitempty"`
 }
 
+// stdinLines carries stdin one line at a time, as MCP's stdio transport
+// sends one message per line.
+var stdinLines = readLines(os.Stdin)
+
+// responder sends JSON-RPC responses back over a transport.
+type responder interface {
+	Encode(v any) error
+}
+
 func main() {
-	decoder := json.NewDecoder(os.Stdin)
+	httpAddr := flag.String("http", "", "Serve MCP over HTTP with SSE on this address (e.g. 127.0.0.1:8765) instead of stdio")
+	historyPath := flag.String("history", "", "Append every prompt and answer to this JSONL file and load earlier ones for feedback_history (empty keeps them in memory)")
+	flag.Parse()
+
+	if *historyPath != "" {
+		h, err := openHistory(*historyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "history:", err)
+			os.Exit(1)
+		}
+		defer h.Close()
+		feedbackLog = h
+	}
+
+	if *httpAddr != "" {
+		if err := serveHTTP(*httpAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "http server:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetEscapeHTML(false)
 
-	for {
+	for line := range stdinLines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 		var req rpcRequest
-		if err := decoder.Decode(&req); err != nil {
-			if errors.Is(err, io.EOF) {
-				return
-			}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
 			fmt.Fprintln(os.Stderr, "decode error:", err)
 			continue
 		}
@@ -63,104 +95,176 @@ func main() {
 		if req.Method == "exit" && len(req.ID) == 0 {
 			return
 		}
+		handleRequest(encoder, req)
+	}
+}
 
-		switch req.Method {
-		case "initialize":
-			result := map[string]any{
-				"protocolVersion": "2024-11-05",
-				"serverInfo": map[string]any{
-					"name":    "interactive-feedback-mcp",
-					"version": "0.1.0",
-				},
-				"capabilities": map[string]any{
-					"tools": map[string]any{
-						"listChanged": false,
-					},
+func handleRequest(encoder responder, req rpcRequest) {
+	switch req.Method {
+	case "initialize":
+		result := map[string]any{
+			"protocolVersion": "2024-11-05",
+			"serverInfo": map[string]any{
+				"name":    "interactive-feedback-mcp",
+				"version": "0.1.0",
+			},
+			"capabilities": map[string]any{
+				"tools": map[string]any{
+					"listChanged": false,
 				},
-			}
-			writeResult(encoder, req.ID, result)
-		case "tools/list":
-			result := map[string]any{
-				"tools": []map[string]any{
-					{
-						"name":        "interactive_feedback",
-						"description": "Prompt the user and return their response.",
-						"inputSchema": map[string]any{
-							"type": "object",
-							"properties": map[string]any{
-								"message": map[string]any{
-									"type":        "string",
-									"description": "Prompt shown to the user.",
-								},
-								"options": map[string]any{
-									"type":        "array",
-									"items":       map[string]any{"type": "string"},
-									"description": "Optional list of choices.",
-								},
-								"allowFreeText": map[string]any{
-									"type":        "boolean",
-									"description": "Allow free text input when options are provided.",
-								},
-								"defaultOption": map[string]any{
-									"type":        "string",
-									"description": "Default option when user submits empty input.",
-								},
-								"timeoutSec": map[string]any{
-									"type":        "integer",
-									"minimum":     0,
-									"description": "Timeout in seconds (0 disables timeout).",
-								},
+			},
+		}
+		writeResult(encoder, req.ID, result)
+	case "tools/list":
+		result := map[string]any{
+			"tools": []map[string]any{
+				{
+					"name":        "interactive_feedback",
+					"description": "Prompt the user and return their response.",
+					"inputSchema": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"message": map[string]any{
+								"type":        "string",
+								"description": "Prompt shown to the user.",
+							},
+							"options": map[string]any{
+								"type":        "array",
+								"items":       map[string]any{"type": "string"},
+								"description": "Optional list of choices.",
+							},
+							"allowFreeText": map[string]any{
+								"type":        "boolean",
+								"description": "Allow free text input when options are provided.",
+							},
+							"defaultOption": map[string]any{
+								"type":        "string",
+								"description": "Default option when user submits empty input.",
+							},
+							"timeoutSec": map[string]any{
+								"type":        "integer",
+								"minimum":     0,
+								"description": "Timeout in seconds (0 disables timeout).",
 							},
-							"required": []string{"message"},
 						},
+						"required": []string{"message"},
 					},
 				},
-			}
-			writeResult(encoder, req.ID, result)
-		case "tools/call":
-			result := handleToolCall(req.Params)
-			writeResult(encoder, req.ID, result)
-		case "shutdown":
-			writeResult(encoder, req.ID, map[string]any{})
-		default:
-			writeError(encoder, req.ID, -32601, "method not found")
+				multiselectTool,
+				confirmTool,
+				secretTool,
+				historyTool,
+			},
 		}
+		writeResult(encoder, req.ID, result)
+	case "tools/call":
+		result := handleToolCall(req.Params)
+		writeResult(encoder, req.ID, result)
+	case "shutdown":
+		writeResult(encoder, req.ID, map[string]any{})
+	default:
+		writeError(encoder, req.ID, -32601, "method not found")
 	}
 }
 
+var promptMu sync.Mutex
+
 func handleToolCall(params json.RawMessage) map[string]any {
 	var call callParams
 	if err := json.Unmarshal(params, &call); err != nil {
 		return toolError("invalid params")
 	}
 
-	if call.Name != "interactive_feedback" {
-		return toolError("unknown tool")
+	if call.Name == "feedback_history" {
+		var args historyArgs
+		if err := decodeArguments(call.Arguments, &args); err != nil {
+			return toolError(err.Error())
+		}
+		answer, err := feedbackLog.query(args)
+		if err != nil {
+			return toolError(err.Error())
+		}
+		return toolText(answer)
 	}
 
-	var args feedbackArgs
-	if len(call.Arguments) > 0 {
-		if err := json.Unmarshal(call.Arguments, &args); err != nil {
-			return toolError("invalid arguments")
+	// Prompts share one terminal, so concurrent HTTP sessions take turns.
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	var answer string
+	var err error
+	entry := historyEntry{Tool: call.Name}
+	switch call.Name {
+	case "interactive_feedback":
+		var args feedbackArgs
+		if err := decodeArguments(call.Arguments, &args); err != nil {
+			return toolError(err.Error())
+		}
+		entry.Message, entry.Options = args.Message, args.Options
+		answer, err = promptFeedback(args)
+	case "interactive_multiselect":
+		var args multiselectArgs
+		if err := decodeArguments(call.Arguments, &args); err != nil {
+			return toolError(err.Error())
 		}
+		entry.Message, entry.Options = args.Message, args.Options
+		answer, err = promptMultiselect(args)
+	case "interactive_confirm":
+		var args confirmArgs
+		if err := decodeArguments(call.Arguments, &args); err != nil {
+			return toolError(err.Error())
+		}
+		entry.Message = args.Message
+		answer, err = promptConfirm(args)
+	case "interactive_secret":
+		var args secretArgs
+		if err := decodeArguments(call.Arguments, &args); err != nil {
+			return toolError(err.Error())
+		}
+		entry.Message = args.Message
+		answer, err = promptSecret(args)
+	default:
+		return toolError("unknown tool")
 	}
 
-	answer, err := promptFeedback(args)
+	entry.Answer = answer
+	if call.Name == "interactive_secret" && answer != "" {
+		entry.Answer = redacted
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	feedbackLog.record(entry)
+
 	if err != nil {
 		return toolError(err.Error())
 	}
+	return toolText(answer)
+}
+
+func toolText(text string) map[string]any {
 
 	return map[string]any{
 		"content": []map[string]any{
 			{
 				"type": "text",
-				"text": answer,
+				"text": text,
 			},
 		},
 		"isError": false,
 	}
 }
 
+func decodeArguments(raw json.RawMessage, args any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, args); err != nil {
+		return errors.New("invalid arguments")
+	}
+	return nil
+}
+
 func promptFeedback(args feedbackArgs) (string, error) {
 	if strings.TrimSpace(args.Message) == "" {
 		return "", errors.New("message is required")
@@ -174,13 +278,12 @@ func promptFeedback(args feedbackArgs) (string, error) {
 		allowFreeText = *args.AllowFreeText
 	}
 
-	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	tty, err := openTTY()
 	if err != nil {
-		return "", errors.New("/dev/tty unavailable")
+		return "", err
 	}
 	defer tty.Close()
 
-	reader := bufio.NewReader(tty)
 	fmt.Fprintln(tty, args.Message)
 	if len(args.Options) > 0 {
 		for i, opt := range args.Options {
@@ -192,35 +295,12 @@ func promptFeedback(args feedbackArgs) (string, error) {
 	}
 	fmt.Fprint(tty, "> ")
 
-	inputCh := make(chan string, 1)
-	errCh := make(chan error, 1)
-	go func() {
-		line, readErr := reader.ReadString('\n')
-		if readErr != nil && !errors.Is(readErr, io.EOF) {
-			errCh <- readErr
-			return
-		}
-		inputCh <- strings.TrimSpace(line)
-	}()
-
-	var input string
-	if args.TimeoutSec != nil && *args.TimeoutSec > 0 {
-		select {
-		case input = <-inputCh:
-		case err = <-errCh:
-			return "", err
-		case <-time.After(time.Duration(*args.TimeoutSec) * time.Second):
-			if args.DefaultOption != "" {
-				return args.DefaultOption, nil
-			}
-			return "", errors.New("timeout waiting for input")
-		}
-	} else {
-		select {
-		case input = <-inputCh:
-		case err = <-errCh:
-			return "", err
-		}
+	input, err := tty.readLine(args.TimeoutSec)
+	if errors.Is(err, errTimeout) && args.DefaultOption != "" {
+		return args.DefaultOption, nil
+	}
+	if err != nil {
+		return "", err
 	}
 
 	if input == "" && args.DefaultOption != "" {
@@ -258,6 +338,95 @@ func promptFeedback(args feedbackArgs) (string, error) {
 	return "", errors.New("invalid selection")
 }
 
+var errTimeout = errors.New("timeout waiting for input")
+
+// terminal is where a prompt is shown and answered.
+type terminal struct {
+	out    io.Writer
+	in     io.Reader
+	closer io.Closer
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func (t *terminal) Close() error {
+	if t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+// errNoConsole is returned by prompts when there is no console to ask on, as
+// under a service manager or in a container. stdin carries the MCP session,
+// so the answer cannot be read from there.
+var errNoConsole = errors.New("no console to prompt on: run the server from a terminal")
+
+// openTTY opens the console, which stays free for prompting while stdin and
+// stdout carry the MCP session.
+func openTTY() (*terminal, error) {
+	t, err := openConsole()
+	if err != nil {
+		return nil, fmt.Errorf("%w (%v)", errNoConsole, err)
+	}
+	return t, nil
+}
+
+// readLine reads one trimmed line, giving up with errTimeout after
+// timeoutSec seconds when it is set and positive.
+func (t *terminal) readLine(timeoutSec *int) (string, error) {
+	lines := make(chan string, 1)
+	go func() {
+		defer close(lines)
+		if line, err := bufio.NewReader(t.in).ReadString('\n'); line != "" || err == nil {
+			lines <- line
+		}
+	}()
+
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			return "", io.ErrUnexpectedEOF
+		}
+		return strings.TrimSpace(line), nil
+	case <-timeoutAfter(timeoutSec):
+		return "", errTimeout
+	}
+}
+
+// timeoutAfter fires after timeoutSec seconds, or never when it is unset or
+// not positive.
+func timeoutAfter(timeoutSec *int) <-chan time.Time {
+	if timeoutSec == nil || *timeoutSec <= 0 {
+		return nil
+	}
+	return time.After(time.Duration(*timeoutSec) * time.Second)
+}
+
+// readLines sends each line of r, closing the channel at the end of r or on
+// a read error.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				lines <- line
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					fmt.Fprintln(os.Stderr, "read error:", err)
+				}
+				return
+			}
+		}
+	}()
+	return lines
+}
+
 func toolError(message string) map[string]any {
 	return map[string]any{
 		"content": []map[string]any{
@@ -270,7 +439,7 @@ func toolError(message string) map[string]any {
 	}
 }
 
-func writeResult(encoder *json.Encoder, id json.RawMessage, result any) {
+func writeResult(encoder responder, id json.RawMessage, result any) {
 	if len(id) == 0 {
 		return
 	}
@@ -284,7 +453,7 @@ func writeResult(encoder *json.Encoder, id json.RawMessage, result any) {
 	}
 }
 
-func writeError(encoder *json.Encoder, id json.RawMessage, code int, message string) {
+func writeError(encoder responder, id json.RawMessage, code int, message string) {
 	if len(id) == 0 {
 		return
 	}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// recorder is a responder that keeps what it was sent.
+type recorder struct {
+	responses []rpcResponse
+}
+
+func (r *recorder) Encode(v any) error {
+	r.responses = append(r.responses, v.(rpcResponse))
+	return nil
+}
+
+func TestToolsListAdvertisesEveryTool(t *testing.T) {
+	var rec recorder
+	handleRequest(&rec, rpcRequest{ID: json.RawMessage("1"), Method: "tools/list"})
+	if len(rec.responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(rec.responses))
+	}
+	var advertised []string
+	for _, tool := range rec.responses[0].Result.(map[string]any)["tools"].([]map[string]any) {
+		advertised = append(advertised, tool["name"].(string))
+	}
+
+	// Arguments that fail to decode get every known tool to answer without
+	// prompting, and set apart the names handleToolCall does not know.
+	accepted := func(name string) bool {
+		params, _ := json.Marshal(map[string]any{"name": name, "arguments": "not an object"})
+		result := handleToolCall(params)
+		return result["content"].([]map[string]any)[0]["text"] != "unknown tool"
+	}
+	for _, name := range []string{
+		"interactive_feedback",
+		"interactive_multiselect",
+		"interactive_confirm",
+		"interactive_secret",
+		"feedback_history",
+	} {
+		if !accepted(name) {
+			t.Errorf("handleToolCall does not accept %s", name)
+		}
+		if !slices.Contains(advertised, name) {
+			t.Errorf("tools/list does not advertise %s", name)
+		}
+	}
+	for _, name := range advertised {
+		if !accepted(name) {
+			t.Errorf("tools/list advertises %s, which handleToolCall does not accept", name)
+		}
+	}
+}
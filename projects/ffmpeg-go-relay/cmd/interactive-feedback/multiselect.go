@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type multiselectArgs struct {
+	Message        string   `json:"message"`
+	Options        []string `json:"options"`
+	MinSelections  *int     `json:"minSelections,omitempty"`
+	MaxSelections  *int     `json:"maxSelections,omitempty"`
+	DefaultOptions []string `json:"defaultOptions,omitempty"`
+	TimeoutSec     *int     `json:"timeoutSec,omitempty"`
+}
+
+var multiselectTool = map[string]any{
+	"name":        "interactive_multiselect",
+	"description": "Ask the user to choose one or more of a list of options. Returns the chosen options as a JSON array, in list order.",
+	"inputSchema": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"message": map[string]any{
+				"type":        "string",
+				"description": "Prompt shown to the user.",
+			},
+			"options": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"minItems":    1,
+				"description": "Choices to select from.",
+			},
+			"minSelections": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Fewest options the user must choose (default 1).",
+			},
+			"maxSelections": map[string]any{
+				"type":        "integer",
+				"minimum":     1,
+				"description": "Most options the user may choose (default all).",
+			},
+			"defaultOptions": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "Options chosen when the user submits empty input or the prompt times out.",
+			},
+			"timeoutSec": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Timeout in seconds (0 disables timeout).",
+			},
+		},
+		"required": []string{"message", "options"},
+	},
+}
+
+func promptMultiselect(args multiselectArgs) (string, error) {
+	if strings.TrimSpace(args.Message) == "" {
+		return "", errors.New("message is required")
+	}
+	if len(args.Options) == 0 {
+		return "", errors.New("options are required")
+	}
+	minSel, maxSel := 1, len(args.Options)
+	if args.MinSelections != nil {
+		minSel = *args.MinSelections
+	}
+	if args.MaxSelections != nil {
+		maxSel = *args.MaxSelections
+	}
+	if minSel < 0 || maxSel < 1 || minSel > maxSel || minSel > len(args.Options) {
+		return "", errors.New("invalid minSelections/maxSelections")
+	}
+	defaults, err := matchOptions(args.Options, args.DefaultOptions)
+	if err != nil {
+		return "", fmt.Errorf("defaultOptions: %w", err)
+	}
+
+	tty, err := openTTY()
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	fmt.Fprintln(tty, args.Message)
+	for i, opt := range args.Options {
+		mark := " "
+		if defaults[i] {
+			mark = "x"
+		}
+		fmt.Fprintf(tty, "%d) [%s] %s\n", i+1, mark, opt)
+	}
+	switch {
+	case minSel == maxSel:
+		fmt.Fprintf(tty, "Choose %d", minSel)
+	case maxSel == len(args.Options):
+		fmt.Fprintf(tty, "Choose at least %d", minSel)
+	default:
+		fmt.Fprintf(tty, "Choose %d to %d", minSel, maxSel)
+	}
+	fmt.Fprintln(tty, ", separated by commas (numbers or names).")
+	if len(args.DefaultOptions) > 0 {
+		fmt.Fprintln(tty, "Press Enter to keep the marked options.")
+	}
+	fmt.Fprint(tty, "> ")
+
+	input, err := tty.readLine(args.TimeoutSec)
+	useDefaults := len(args.DefaultOptions) > 0 && (errors.Is(err, errTimeout) || (err == nil && input == ""))
+	if err != nil && !useDefaults {
+		return "", err
+	}
+
+	chosen := defaults
+	if !useDefaults {
+		var fields []string
+		for _, f := range strings.Split(input, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		if chosen, err = matchOptions(args.Options, fields); err != nil {
+			return "", err
+		}
+	}
+
+	var selected []string
+	for i, opt := range args.Options {
+		if chosen[i] {
+			selected = append(selected, opt)
+		}
+	}
+	if len(selected) < minSel || len(selected) > maxSel {
+		return "", fmt.Errorf("selected %d options, want %d to %d", len(selected), minSel, maxSel)
+	}
+	out, err := json.Marshal(append([]string{}, selected...))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// matchOptions marks the options each entry picks, by 1-based number or by
+// name, ignoring case.
+func matchOptions(options, entries []string) ([]bool, error) {
+	chosen := make([]bool, len(options))
+	for _, entry := range entries {
+		idx := -1
+		if n, err := strconv.Atoi(entry); err == nil && n >= 1 && n <= len(options) {
+			idx = n - 1
+		} else {
+			for i, opt := range options {
+				if strings.EqualFold(entry, opt) {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid selection %q", entry)
+		}
+		chosen[idx] = true
+	}
+	return chosen, nil
+}
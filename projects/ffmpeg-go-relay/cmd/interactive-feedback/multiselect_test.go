@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchOptions(t *testing.T) {
+	options := []string{"Red", "Green", "Blue"}
+	for _, tc := range []struct {
+		entries []string
+		want    []bool
+		err     string
+	}{
+		{nil, []bool{false, false, false}, ""},
+		{[]string{"1", "3"}, []bool{true, false, true}, ""},
+		{[]string{"green", "BLUE"}, []bool{false, true, true}, ""},
+		{[]string{"2", "Green"}, []bool{false, true, false}, ""},
+		{[]string{"0"}, nil, `invalid selection "0"`},
+		{[]string{"4"}, nil, `invalid selection "4"`},
+		{[]string{"-1"}, nil, `invalid selection "-1"`},
+		{[]string{"Purple"}, nil, `invalid selection "Purple"`},
+	} {
+		got, err := matchOptions(options, tc.entries)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("matchOptions(%q) error = %v, want %q", tc.entries, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("matchOptions(%q) = %v, %v; want %v", tc.entries, got, err, tc.want)
+		}
+	}
+}
+
+func TestPromptMultiselectBounds(t *testing.T) {
+	n := func(v int) *int { return &v }
+	options := []string{"a", "b", "c"}
+	for _, tc := range []struct {
+		name string
+		args multiselectArgs
+		want string
+	}{
+		{"no message", multiselectArgs{Options: options}, "message is required"},
+		{"no options", multiselectArgs{Message: "pick"}, "options are required"},
+		{"negative min", multiselectArgs{Message: "pick", Options: options, MinSelections: n(-1)}, "invalid minSelections/maxSelections"},
+		{"zero max", multiselectArgs{Message: "pick", Options: options, MaxSelections: n(0)}, "invalid minSelections/maxSelections"},
+		{"min above max", multiselectArgs{Message: "pick", Options: options, MinSelections: n(3), MaxSelections: n(2)}, "invalid minSelections/maxSelections"},
+		{"min above options", multiselectArgs{Message: "pick", Options: options, MinSelections: n(4), MaxSelections: n(5)}, "invalid minSelections/maxSelections"},
+		{"unknown default", multiselectArgs{Message: "pick", Options: options, DefaultOptions: []string{"d"}}, `defaultOptions: invalid selection "d"`},
+	} {
+		// Each is refused before the console is opened.
+		if _, err := promptMultiselect(tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+type secretArgs struct {
+	Message    string `json:"message"`
+	TimeoutSec *int   `json:"timeoutSec,omitempty"`
+}
+
+var secretTool = map[string]any{
+	"name":        "interactive_secret",
+	"description": "Ask the user for a password, passphrase or token without echoing it to the terminal, and return it.",
+	"inputSchema": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"message": map[string]any{
+				"type":        "string",
+				"description": "Prompt shown to the user.",
+			},
+			"timeoutSec": map[string]any{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Timeout in seconds (0 disables timeout).",
+			},
+		},
+		"required": []string{"message"},
+	},
+}
+
+var errCancelled = errors.New("cancelled by user")
+
+func promptSecret(args secretArgs) (string, error) {
+	if strings.TrimSpace(args.Message) == "" {
+		return "", errors.New("message is required")
+	}
+
+	tty, err := openTTY()
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s ", args.Message)
+	secret, err := tty.readSecret(args.TimeoutSec)
+	fmt.Fprint(tty, "\r\n")
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", errors.New("empty input")
+	}
+	return secret, nil
+}
+
+// readSecret reads a line with the terminal in raw mode, echoing a '*' per
+// character typed instead of the character. A console that is not a file
+// cannot be put in raw mode, and the secret is refused rather than echoed.
+func (t *terminal) readSecret(timeoutSec *int) (string, error) {
+	f, ok := t.in.(*os.File)
+	if !ok {
+		return "", errNoConsole
+	}
+
+	restore, err := rawMode(f)
+	if err != nil {
+		return "", fmt.Errorf("disable terminal echo: %w", err)
+	}
+	defer restore()
+
+	type result struct {
+		secret string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		secret, err := readMasked(f, t.out)
+		done <- result{secret, err}
+	}()
+	select {
+	case r := <-done:
+		return r.secret, r.err
+	case <-timeoutAfter(timeoutSec):
+		return "", errTimeout
+	}
+}
+
+// readMasked reads keys from in up to Enter, handling backspace, Ctrl-U
+// (erase all), Ctrl-C (cancel) and Ctrl-D (end of input), and writes a
+// '*' to out for each character.
+func readMasked(in io.Reader, out io.Writer) (string, error) {
+	var buf []byte
+	var key [1]byte
+	for {
+		if _, err := in.Read(key[:]); err != nil {
+			return "", err
+		}
+		switch b := key[0]; {
+		case b == '\r' || b == '\n':
+			return string(buf), nil
+		case b == 0x7f || b == '\b':
+			if len(buf) > 0 {
+				_, size := utf8.DecodeLastRune(buf)
+				buf = buf[:len(buf)-size]
+				fmt.Fprint(out, "\b \b")
+			}
+		case b == 0x15:
+			fmt.Fprint(out, strings.Repeat("\b \b", utf8.RuneCount(buf)))
+			buf = buf[:0]
+		case b == 0x03:
+			return "", errCancelled
+		case b == 0x04:
+			if len(buf) == 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+		case b < 0x20:
+			// Other control keys are ignored.
+		default:
+			buf = append(buf, b)
+			if !utf8.RuneStart(b) {
+				continue
+			}
+			fmt.Fprint(out, "*")
+		}
+	}
+}
+
+// rawMode puts the terminal f into raw mode and returns the function that
+// restores it. It works through SyscallConn, as File.Fd would switch f to
+// blocking mode and keep Close from ending a read abandoned on timeout.
+func rawMode(f *os.File) (func(), error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var state *term.State
+	if ctlErr := rc.Control(func(fd uintptr) {
+		state, err = term.MakeRaw(int(fd))
+	}); ctlErr != nil {
+		return nil, ctlErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		rc.Control(func(fd uintptr) {
+			term.Restore(int(fd), state)
+		})
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadMasked(t *testing.T) {
+	for _, tc := range []struct {
+		name, keys   string
+		want, echoed string
+		err          error
+	}{
+		{"enter", "pass\r", "pass", "****", nil},
+		{"newline", "pass\n", "pass", "****", nil},
+		{"backspace", "pasx\x7fs\r", "pass", "****\b \b*", nil},
+		{"ctrl-h", "pa\bx\r", "px", "**\b \b*", nil},
+		{"backspace on empty", "\x7fok\r", "ok", "**", nil},
+		{"multibyte backspace", "né\x7f\r", "n", "**\b \b", nil},
+		{"ctrl-u", "abc\x15ok\r", "ok", "***\b \b\b \b\b \b**", nil},
+		{"other control keys", "o\x1bk\t\r", "ok", "**", nil},
+		{"ctrl-c", "abc\x03", "", "***", errCancelled},
+		{"ctrl-d on empty", "\x04", "", "", io.ErrUnexpectedEOF},
+		{"ctrl-d with input", "ab\x04c\r", "abc", "***", nil},
+		{"end of input", "abc", "", "***", io.EOF},
+	} {
+		var out strings.Builder
+		got, err := readMasked(strings.NewReader(tc.keys), &out)
+		if !errors.Is(err, tc.err) || got != tc.want || out.String() != tc.echoed {
+			t.Errorf("%s: readMasked = %q, %v, echoed %q; want %q, %v, echoed %q", tc.name, got, err, out.String(), tc.want, tc.err, tc.echoed)
+		}
+	}
+}
+
+func TestReadSecretRefusesWithoutConsole(t *testing.T) {
+	tty := &terminal{out: io.Discard, in: strings.NewReader("hunter2\n")}
+	if _, err := tty.readSecret(nil); !errors.Is(err, errNoConsole) {
+		t.Fatalf("readSecret = %v, want errNoConsole", err)
+	}
+}
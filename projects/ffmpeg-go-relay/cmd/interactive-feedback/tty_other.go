@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// openConsole opens the controlling terminal.
+func openConsole() (*terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &terminal{out: tty, in: tty, closer: tty}, nil
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openConsole opens the console's input and screen buffers, Windows' stand-in
+// for /dev/tty.
+func openConsole() (*terminal, error) {
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &terminal{out: out, in: in, closer: consoleCloser{in, out}}, nil
+}
+
+type consoleCloser struct {
+	in, out *os.File
+}
+
+func (c consoleCloser) Close() error {
+	return errors.Join(c.in.Close(), c.out.Close())
+}
@@ -0,0 +1,381 @@
+// Command relay-loadtest starts many synthetic publishers against a relay at
+// once, each sending the generated test pattern at a target bitrate, and
+// reports how many got through, how long setup took and the throughput they
+// sustained.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/testpattern"
+)
+
+type options struct {
+	publishers int
+	ramp       time.Duration
+	duration   time.Duration
+	timeout    time.Duration
+	insecure   bool
+	pattern    testpattern.Options
+}
+
+// result is what one publisher saw.
+type result struct {
+	setup   time.Duration // Dial to NetStream.Publish.Start
+	started bool
+	err     error         // Why it failed to start or stopped early
+	sent    int64         // Media payload bytes
+	sending time.Duration // From publish start until it ended
+}
+
+// summary is the final report, printed as a table or JSON.
+type summary struct {
+	URL              string         `json:"url"`
+	Publishers       int            `json:"publishers"`
+	Started          int            `json:"started"`
+	Completed        int            `json:"completed"` // Still publishing when the run ended
+	Failed           int            `json:"failed"`
+	Dropped          int            `json:"dropped"` // Started, then ended early
+	SuccessRate      float64        `json:"success_rate"`
+	Errors           map[string]int `json:"errors,omitempty"`
+	SetupP50Ms       float64        `json:"setup_p50_ms"`
+	SetupP90Ms       float64        `json:"setup_p90_ms"`
+	SetupP99Ms       float64        `json:"setup_p99_ms"`
+	SetupMaxMs       float64        `json:"setup_max_ms"`
+	Seconds          float64        `json:"seconds"`
+	TargetKbps       int            `json:"target_kbps"`
+	TotalKbps        float64        `json:"total_kbps"` // Sum of each started publisher's average rate
+	PerPublisherKbps float64        `json:"per_publisher_kbps"`
+}
+
+func main() {
+	publishers := flag.Int("publishers", 10, "Number of concurrent publishers")
+	ramp := flag.Duration("ramp", 5*time.Second, "Spread publisher starts over this long")
+	duration := flag.Duration("duration", time.Minute, "How long each publisher sends once started")
+	bitrate := flag.Int("bitrate-kbps", 1000, "Video bitrate per publisher; 0 sends the pattern as coded")
+	size := flag.String("size", "320x240", "Test pattern size, multiples of 16")
+	fps := flag.Int("fps", 25, "Test pattern frame rate")
+	gop := flag.Duration("keyframe-interval", 2*time.Second, "Test pattern keyframe interval")
+	noAudio := flag.Bool("no-audio", false, "Leave out the silent audio track")
+	timeout := flag.Duration("timeout", 10*time.Second, "Time allowed for each publisher to connect and start publishing")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification for rtmps:// URLs")
+	asJSON := flag.Bool("json", false, "Print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: relay-loadtest [flags] rtmp://host[:port]/app/stream")
+		fmt.Fprintln(flag.CommandLine.Output(), "Publisher n uses the stream name with -n appended, e.g. stream-1.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *publishers < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	u, err := rtmp.ParseURL(flag.Arg(0))
+	if err == nil && u.Stream == "" {
+		err = fmt.Errorf("%s names no stream", flag.Arg(0))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(*size, "%dx%d", &w, &h); err != nil {
+		fatal(fmt.Errorf("bad -size %q", *size))
+	}
+	opts := options{
+		publishers: *publishers,
+		ramp:       *ramp,
+		duration:   *duration,
+		timeout:    *timeout,
+		insecure:   *insecure,
+		pattern: testpattern.Options{
+			Width: w, Height: h, FPS: *fps, KeyframeInterval: *gop,
+			Bitrate: *bitrate * 1000, NoAudio: *noAudio,
+		},
+	}
+	// Catch a bad pattern before starting anything.
+	if _, err := testpattern.New(opts.pattern); err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	began := time.Now()
+	results := run(ctx, u, opts, !*asJSON)
+	s := summarize(flag.Arg(0), results, time.Since(began), *bitrate)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(s)
+	} else {
+		printSummary(s)
+	}
+	if s.Failed > 0 || s.Dropped > 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "relay-loadtest:", err)
+	os.Exit(1)
+}
+
+// run starts the publishers, spread over the ramp, and waits for all of
+// them. Progress goes to stderr every 5s when verbose.
+func run(ctx context.Context, u *rtmp.URL, opts options, verbose bool) []*result {
+	results := make([]*result, opts.publishers)
+	var active atomic.Int64
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+	if verbose {
+		go func() {
+			t := time.NewTicker(5 * time.Second)
+			defer t.Stop()
+			last, lastAt := int64(0), time.Now()
+			for {
+				select {
+				case <-done:
+					return
+				case now := <-t.C:
+					total := sent.Load()
+					kbps := float64(total-last) * 8 / 1000 / now.Sub(lastAt).Seconds()
+					last, lastAt = total, now
+					fmt.Fprintf(os.Stderr, "%d publishing, %.0f kbit/s\n", active.Load(), kbps)
+				}
+			}
+		}()
+	}
+
+	var gap time.Duration
+	if opts.publishers > 1 {
+		gap = opts.ramp / time.Duration(opts.publishers-1)
+	}
+	for i := range results {
+		if i > 0 && gap > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(gap):
+			}
+		}
+		r := &result{}
+		results[i] = r
+		if ctx.Err() != nil {
+			r.err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publish(ctx, u, streamName(u.Stream, i+1), opts, r, &active, &sent)
+		}()
+	}
+	wg.Wait()
+	close(done)
+	return results
+}
+
+// streamName appends -n to the stream name, ahead of any query.
+func streamName(stream string, n int) string {
+	name, query, ok := strings.Cut(stream, "?")
+	name = fmt.Sprintf("%s-%d", name, n)
+	if ok {
+		name += "?" + query
+	}
+	return name
+}
+
+// publish runs one publisher for opts.duration, recording into r.
+func publish(ctx context.Context, u *rtmp.URL, stream string, opts options, r *result, active, sent *atomic.Int64) {
+	g, err := testpattern.New(opts.pattern)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	setup, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	start := time.Now()
+	var conn net.Conn
+	if u.TLS() {
+		d := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: opts.insecure}}
+		conn, err = d.DialContext(setup, "tcp", u.Address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(setup, "tcp", u.Address)
+	}
+	if err != nil {
+		r.err = fmt.Errorf("dial: %w", err)
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := setup.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := rtmp.ClientHandshake(conn, nil); err != nil {
+		r.err = fmt.Errorf("handshake: %w", err)
+		return
+	}
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(conn), conn)
+	if _, err := session.Connect(u.App, u.TCURL()); err != nil {
+		r.err = fmt.Errorf("connect: %w", err)
+		return
+	}
+	if _, err := session.Publish(stream); err != nil {
+		r.err = fmt.Errorf("publish: %w", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	r.setup, r.started = time.Since(start), true
+	active.Add(1)
+	defer active.Add(-1)
+
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := session.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	began := time.Now()
+	defer func() { r.sending = time.Since(began) }()
+	end := time.NewTimer(opts.duration)
+	defer end.Stop()
+	msgs := g.Headers()
+	for {
+		for _, msg := range msgs {
+			if wait := time.Until(began.Add(time.Duration(msg.Header.Timestamp) * time.Millisecond)); wait > 0 {
+				select {
+				case <-ctx.Done():
+					session.Unpublish(stream)
+					return
+				case <-end.C:
+					session.Unpublish(stream)
+					return
+				case err := <-closed:
+					r.err = fmt.Errorf("server closed the session: %w", err)
+					return
+				case <-time.After(wait):
+				}
+			}
+			if err := session.WriteMedia(msg); err != nil {
+				r.err = fmt.Errorf("send: %w", err)
+				return
+			}
+			r.sent += int64(len(msg.Payload))
+			sent.Add(int64(len(msg.Payload)))
+		}
+		msgs = g.Next()
+	}
+}
+
+func summarize(url string, results []*result, elapsed time.Duration, targetKbps int) *summary {
+	s := &summary{URL: url, Publishers: len(results), Errors: map[string]int{}, TargetKbps: targetKbps}
+	var setups []time.Duration
+	for _, r := range results {
+		if r.started {
+			s.Started++
+			setups = append(setups, r.setup)
+			if r.sending > 0 {
+				s.TotalKbps += float64(r.sent) * 8 / 1000 / r.sending.Seconds()
+			}
+		}
+		switch {
+		case r.err == nil:
+			s.Completed++
+		case errors.Is(r.err, context.Canceled):
+			// Interrupted before this publisher ran; not the relay's doing.
+			if r.started {
+				s.Completed++
+			}
+		case r.started:
+			s.Dropped++
+			s.Errors[errorClass(r.err)]++
+		default:
+			s.Failed++
+			s.Errors[errorClass(r.err)]++
+		}
+	}
+	s.SuccessRate = float64(s.Started) / float64(s.Publishers)
+	sort.Slice(setups, func(i, j int) bool { return setups[i] < setups[j] })
+	s.SetupP50Ms = percentile(setups, 0.50)
+	s.SetupP90Ms = percentile(setups, 0.90)
+	s.SetupP99Ms = percentile(setups, 0.99)
+	s.SetupMaxMs = percentile(setups, 1)
+	s.Seconds = elapsed.Seconds()
+	if s.Started > 0 {
+		s.PerPublisherKbps = s.TotalKbps / float64(s.Started)
+	}
+	return s
+}
+
+// errorClass groups errors for the report: the RTMP status code of a
+// refusal, or the failed step with its cause's text.
+func errorClass(err error) string {
+	var se *rtmp.StatusError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	if errors.Is(err, io.EOF) {
+		step, _, _ := strings.Cut(err.Error(), ":")
+		return step + ": connection closed"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		step, _, _ := strings.Cut(err.Error(), ":")
+		return step + ": timeout"
+	}
+	return err.Error()
+}
+
+// percentile returns the nearest-rank percentile of sorted, in milliseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+func printSummary(s *summary) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "url\t%s\n", s.URL)
+	fmt.Fprintf(tw, "publishers\t%d started of %d (%.1f%%)\n", s.Started, s.Publishers, s.SuccessRate*100)
+	fmt.Fprintf(tw, "completed\t%d\n", s.Completed)
+	fmt.Fprintf(tw, "failed to start\t%d\n", s.Failed)
+	fmt.Fprintf(tw, "dropped\t%d\n", s.Dropped)
+	classes := make([]string, 0, len(s.Errors))
+	for c := range s.Errors {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	for _, c := range classes {
+		fmt.Fprintf(tw, "  %s\t%d\n", c, s.Errors[c])
+	}
+	fmt.Fprintf(tw, "setup ms\tp50 %.1f, p90 %.1f, p99 %.1f, max %.1f\n", s.SetupP50Ms, s.SetupP90Ms, s.SetupP99Ms, s.SetupMaxMs)
+	fmt.Fprintf(tw, "throughput\t%.0f kbit/s total, %.0f kbit/s per publisher (target %d video)\n", s.TotalKbps, s.PerPublisherKbps, s.TargetKbps)
+	fmt.Fprintf(tw, "elapsed\t%.1fs\n", s.Seconds)
+	tw.Flush()
+}
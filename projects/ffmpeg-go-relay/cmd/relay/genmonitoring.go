@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+// genMonitoring implements "relay gen-monitoring": it writes a Prometheus
+// rule file and a Grafana dashboard for the metrics compiled into this binary.
+func genMonitoring(args []string) error {
+	fs := flag.NewFlagSet("gen-monitoring", flag.ExitOnError)
+	namespace := fs.String("namespace", metrics.DefaultNamespace, "Metric namespace the relay exports under")
+	out := fs.String("out", ".", "Directory to write alerts.yml and dashboard.json into")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	dashboard, err := metrics.Dashboard(*namespace)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"alerts.yml", metrics.AlertRules(*namespace)},
+		{"dashboard.json", append(dashboard, '\n')},
+	}
+	for _, f := range files {
+		path := filepath.Join(*out, f.name)
+		if err := os.WriteFile(path, f.data, 0o644); err != nil {
+			return err
+		}
+		fmt.Println("wrote", path)
+	}
+	return nil
+}
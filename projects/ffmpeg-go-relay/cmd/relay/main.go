@@ -3,66 +3,158 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"ffmpeg-go-relay/internal/accesslog"
+	"ffmpeg-go-relay/internal/alpnmux"
 	"ffmpeg-go-relay/internal/auth"
 	"ffmpeg-go-relay/internal/circuit"
+	"ffmpeg-go-relay/internal/cluster"
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dnsresponder"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/failover"
+	"ffmpeg-go-relay/internal/grace"
+	"ffmpeg-go-relay/internal/handoff"
 	"ffmpeg-go-relay/internal/httpserver"
+	"ffmpeg-go-relay/internal/journal"
 	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/logship"
+	"ffmpeg-go-relay/internal/metrics"
 	"ffmpeg-go-relay/internal/middleware"
+	"ffmpeg-go-relay/internal/packager"
 	"ffmpeg-go-relay/internal/pool"
+	"ffmpeg-go-relay/internal/postprocess"
+	"ffmpeg-go-relay/internal/preflight"
+	"ffmpeg-go-relay/internal/profiling"
+	"ffmpeg-go-relay/internal/qos"
 	"ffmpeg-go-relay/internal/relay"
 	"ffmpeg-go-relay/internal/retry"
+	"ffmpeg-go-relay/internal/rewrite"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmpt"
+	"ffmpeg-go-relay/internal/state"
+	"ffmpeg-go-relay/internal/thumbnail"
+	"ffmpeg-go-relay/internal/tlscert"
+	"ffmpeg-go-relay/internal/transcoder"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen-monitoring" {
+		if err := genMonitoring(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "gen-monitoring:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfgPath := flag.String("config", "", "Path to JSON config file")
-	listen := flag.String("listen", "", "Listen address (overrides config)")
+	listen := flag.String("listen", "", "Listen address, host:port or unix:///path (overrides config)")
 	httpAddr := flag.String("http-addr", "", "HTTP listen address for health/metrics (empty to disable)")
 	upstream := flag.String("upstream", "", "Upstream RTMP endpoint (e.g., rtmp://host/app/stream)")
 	idle := flag.Duration("idle-timeout", 0, "Idle timeout for connections (e.g., 30s)")
 	readBuf := flag.Int("read-buffer", 64*1024, "Read buffer size in bytes")
 	writeBuf := flag.Int("write-buffer", 64*1024, "Write buffer size in bytes")
+	checkConfig := flag.Bool("check-config", false, "Validate the config, resolve and dial its upstreams and load its TLS key pair, then exit")
+	printConfig := flag.Bool("print-config", false, "Print the effective config as JSON, secrets redacted, then exit")
 	flag.Parse()
 
 	log := logger.New()
 
+	// Precedence, lowest first: defaults, the config file, the environment,
+	// then flags given on the command line.
 	baseCfg := config.Default()
 	if *cfgPath != "" {
 		loaded, err := config.LoadFile(*cfgPath)
 		if err != nil {
+			if *checkConfig {
+				fmt.Printf("fail  config  %v\n", err)
+				os.Exit(1)
+			}
 			log.Fatal("failed to load config", "err", err)
 		}
 		baseCfg = loaded
 	}
-
-	if *listen != "" {
-		baseCfg.ListenAddr = *listen
+	if err := config.ApplyEnv(&baseCfg, nil); err != nil {
+		log.Fatal("invalid environment override", "err", err)
 	}
-	if *httpAddr != "" {
-		baseCfg.HTTPAddr = *httpAddr
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			if *listen != "" {
+				baseCfg.ListenAddr = *listen
+			}
+		case "http-addr":
+			baseCfg.HTTPAddr = *httpAddr
+		case "upstream":
+			if *upstream != "" {
+				baseCfg.Upstream = *upstream
+			}
+		case "idle-timeout":
+			if *idle > 0 {
+				baseCfg.IdleTimeout = config.Duration(*idle)
+			}
+		case "read-buffer":
+			if *readBuf > 0 {
+				baseCfg.ReadBuffer = *readBuf
+			}
+		case "write-buffer":
+			if *writeBuf > 0 {
+				baseCfg.WriteBuffer = *writeBuf
+			}
+		}
+	})
+
+	if *printConfig {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(baseCfg.Redact())
+		if err := baseCfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid config:", err)
+			os.Exit(1)
+		}
+		return
 	}
-	if *upstream != "" {
-		baseCfg.Upstream = *upstream
+
+	if *checkConfig {
+		report := preflight.Run(context.Background(), baseCfg, preflight.Options{})
+		report.Write(os.Stdout)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
 	}
-	if *idle > 0 {
-		baseCfg.IdleTimeout = config.Duration(*idle)
+
+	if err := baseCfg.Validate(); err != nil {
+		log.Fatal("invalid config", "err", err)
 	}
-	if *readBuf > 0 {
-		baseCfg.ReadBuffer = *readBuf
+
+	shipper, err := logship.New(baseCfg.Logging.Ship)
+	if err != nil {
+		log.Fatal("failed to start log shipping", "err", err)
 	}
-	if *writeBuf > 0 {
-		baseCfg.WriteBuffer = *writeBuf
+	if shipper != nil {
+		defer shipper.Close()
+		log = logger.NewWithWriter(io.MultiWriter(os.Stdout, shipper))
+		log.Info("log shipping enabled", "type", baseCfg.Logging.Ship.Type, "buffer_dir", baseCfg.Logging.Ship.BufferDir)
 	}
 
-	if err := baseCfg.Validate(); err != nil {
-		log.Fatal("invalid config", "err", err)
+	if baseCfg.QoS != (config.QoSConfig{}) && !qos.Supported {
+		log.Fatal("invalid config", "err", "qos DSCP marking is only supported on Linux")
 	}
 
 	upstreamEndpoints := baseCfg.Upstreams
@@ -71,11 +163,96 @@ func main() {
 			{URL: baseCfg.Upstream},
 		}
 	}
+	if baseCfg.EgressShaping.RateBytesPerSec > 0 {
+		log.Warn("egress shaping enabled - intended for staging only", "rate_bytes_per_sec", baseCfg.EgressShaping.RateBytesPerSec)
+		for i := range upstreamEndpoints {
+			if upstreamEndpoints[i].EgressShaping == nil {
+				shaping := baseCfg.EgressShaping
+				upstreamEndpoints[i].EgressShaping = &shaping
+			}
+		}
+	}
+	if baseCfg.UpstreamDialRate.PerSecond > 0 {
+		for i := range upstreamEndpoints {
+			if upstreamEndpoints[i].DialRate == nil {
+				dialRate := baseCfg.UpstreamDialRate
+				upstreamEndpoints[i].DialRate = &dialRate
+			}
+		}
+	}
+	if baseCfg.UpstreamBind != (config.UpstreamBindConfig{}) {
+		for i := range upstreamEndpoints {
+			if upstreamEndpoints[i].Bind == nil {
+				bind := baseCfg.UpstreamBind
+				upstreamEndpoints[i].Bind = &bind
+			}
+		}
+	}
+	for i := range upstreamEndpoints {
+		if upstreamEndpoints[i].DSCP == 0 {
+			upstreamEndpoints[i].DSCP = baseCfg.QoS.Class(baseCfg.QoS.Upstream)
+		}
+	}
+	for i := range upstreamEndpoints {
+		if len(upstreamEndpoints[i].Credentials) == 0 {
+			upstreamEndpoints[i].Credentials = baseCfg.UpstreamCredentials
+		}
+	}
 	upstreamPool, err := relay.NewUpstreamPool(upstreamEndpoints, baseCfg.UpstreamStrategy)
 	if err != nil {
 		log.Fatal("invalid upstream configuration", "err", err)
 	}
 
+	routes := append(baseCfg.Routes, relay.TenantRoutes(baseCfg.Tenants)...)
+	if baseCfg.EgressShaping.RateBytesPerSec > 0 {
+		for i := range routes {
+			for j := range routes[i].Upstreams {
+				if routes[i].Upstreams[j].EgressShaping == nil {
+					shaping := baseCfg.EgressShaping
+					routes[i].Upstreams[j].EgressShaping = &shaping
+				}
+			}
+		}
+	}
+	if baseCfg.UpstreamDialRate.PerSecond > 0 {
+		for i := range routes {
+			for j := range routes[i].Upstreams {
+				if routes[i].Upstreams[j].DialRate == nil {
+					dialRate := baseCfg.UpstreamDialRate
+					routes[i].Upstreams[j].DialRate = &dialRate
+				}
+			}
+		}
+	}
+	if baseCfg.UpstreamBind != (config.UpstreamBindConfig{}) {
+		for i := range routes {
+			for j := range routes[i].Upstreams {
+				if routes[i].Upstreams[j].Bind == nil {
+					bind := baseCfg.UpstreamBind
+					routes[i].Upstreams[j].Bind = &bind
+				}
+			}
+		}
+	}
+	for i := range routes {
+		for j := range routes[i].Upstreams {
+			if routes[i].Upstreams[j].DSCP == 0 {
+				routes[i].Upstreams[j].DSCP = baseCfg.QoS.Class(baseCfg.QoS.Upstream)
+			}
+		}
+	}
+	for i := range routes {
+		for j := range routes[i].Upstreams {
+			if len(routes[i].Upstreams[j].Credentials) == 0 {
+				routes[i].Upstreams[j].Credentials = baseCfg.UpstreamCredentials
+			}
+		}
+	}
+	router, err := relay.NewRouter(routes, baseCfg.UpstreamStrategy)
+	if err != nil {
+		log.Fatal("invalid route configuration", "err", err)
+	}
+
 	primaryUpstream := baseCfg.Upstream
 	if primaryUpstream == "" && len(upstreamEndpoints) > 0 {
 		primaryUpstream = upstreamEndpoints[0].URL
@@ -85,22 +262,18 @@ func main() {
 		Enabled:  baseCfg.UpstreamHealthCheck.Enabled,
 		Interval: time.Duration(baseCfg.UpstreamHealthCheck.IntervalSec) * time.Second,
 		Timeout:  time.Duration(baseCfg.UpstreamHealthCheck.TimeoutSec) * time.Second,
+		LogMode:  baseCfg.UpstreamHealthCheck.LogMode,
+		Reminder: time.Duration(baseCfg.UpstreamHealthCheck.ReminderIntervalSec) * time.Second,
 	}
 
 	var authenticator *auth.TokenAuthenticator
 	if baseCfg.Security.AuthEnabled {
 		authenticator = auth.NewTokenAuthenticator(baseCfg.Security.AuthTokens)
 	}
-
-	var tlsConfig *tls.Config
-	if baseCfg.Security.TLSEnabled {
-		cert, err := tls.LoadX509KeyPair(baseCfg.Security.TLSCert, baseCfg.Security.TLSKey)
-		if err != nil {
-			log.Fatal("failed to load TLS key pair", "err", err)
-		}
-		tlsConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
+	var playbackTokens *auth.PlaybackSigner
+	if pt := baseCfg.Security.PlaybackTokens; pt.Enabled {
+		if playbackTokens, err = auth.NewPlaybackSigner(pt.Secrets, pt.TTL.AsDuration()); err != nil {
+			log.Fatal("failed to set up playback tokens", "err", err)
 		}
 	}
 
@@ -114,6 +287,13 @@ func main() {
 	if baseCfg.ConnectionLimit.MaxTotal > 0 || baseCfg.ConnectionLimit.MaxPerIP > 0 {
 		connLimiter = middleware.NewConnectionLimiter(baseCfg.ConnectionLimit.MaxTotal, baseCfg.ConnectionLimit.MaxPerIP)
 	}
+	publishLimiter := middleware.NewPublishLimiter(baseCfg.PublishLimit.MaxStreamsPerIP, baseCfg.PublishLimit.MaxStreamsPerToken)
+
+	tenants := relay.NewTenants(baseCfg.Tenants)
+	defer tenants.Stop()
+
+	// Feeds /admin/events; publishing costs little while nobody is subscribed.
+	eventBus := events.New()
 
 	var breaker *circuit.Breaker
 	if baseCfg.CircuitBreaker.Enabled {
@@ -130,6 +310,9 @@ func main() {
 			successThresh = 1
 		}
 		breaker = circuit.New(maxFailures, resetTimeout, successThresh)
+		breaker.OnStateChange(func(from, to circuit.State) {
+			eventBus.Publish(events.Event{Type: events.TypeCircuitBreaker, From: from.String(), State: to.String()})
+		})
 	}
 
 	retryCfg := retry.Config{}
@@ -140,11 +323,196 @@ func main() {
 			InitialDelay: time.Duration(baseCfg.Retry.InitialDelaySec) * time.Second,
 			MaxDelay:     time.Duration(baseCfg.Retry.MaxDelaySec) * time.Second,
 			Multiplier:   baseCfg.Retry.Multiplier,
+			Retryable:    retry.IsRetryable,
+			Budget:       retry.NewBudget(baseCfg.Retry.BudgetRatio, baseCfg.Retry.BudgetMinRetries, baseCfg.Retry.BudgetWindow.AsDuration()),
+
+			PerAttemptTimeout: baseCfg.Retry.PerAttemptTimeout.AsDuration(),
+			Deadline:          baseCfg.Retry.Deadline.AsDuration(),
 		}
 		retryJitter = baseCfg.Retry.JitterFraction
 	}
 
-	bufPool := pool.New(baseCfg.ReadBuffer)
+	metricsReg := metrics.Default()
+
+	bufPool := pool.NewWithMetrics(baseCfg.ReadBuffer, metricsReg)
+
+	var tlsConfig *tls.Config
+	var certs *tlscert.Manager
+	if baseCfg.Security.TLSEnabled {
+		certs, err = tlscert.Load(baseCfg.Security.TLSCert, baseCfg.Security.TLSKey, tlscert.Options{
+			OCSP:          baseCfg.Security.OCSPStapling,
+			Refresh:       time.Duration(baseCfg.Security.OCSPRefresh),
+			ExpiryWarning: time.Duration(baseCfg.Security.CertExpiryWarning),
+			Metrics:       metricsReg,
+			Log:           log,
+		})
+		if err != nil {
+			log.Fatal("failed to load TLS certificate", "err", err)
+		}
+		// Staple before the listeners open so the first clients get one too.
+		if err := certs.Staple(context.Background()); err != nil {
+			log.Warn("initial OCSP staple fetch failed", "err", err)
+		}
+		tlsConfig = certs.TLSConfig()
+	}
+
+	var profiler *profiling.Sampler
+	if baseCfg.Profiling.Enabled {
+		profiler = profiling.NewSampler(baseCfg.Profiling.SampleRate, metricsReg)
+	}
+
+	chunkLimits := rtmp.ChunkLimits{
+		MaxMessageSize:   uint32(baseCfg.RTMP.MaxMessageSize),
+		MaxChunkStreams:  baseCfg.RTMP.MaxChunkStreams,
+		MaxBufferedBytes: baseCfg.RTMP.MaxBufferedBytes,
+	}
+
+	var handshakeOpts *rtmp.HandshakeOptions
+	if baseCfg.RTMP.DeterministicHandshake {
+		log.Warn("deterministic RTMP handshake enabled - intended for testing only")
+		handshakeOpts = rtmp.DeterministicHandshake()
+	}
+
+	rules := make([]rewrite.Rule, 0, len(baseCfg.RewriteRules))
+	for _, r := range baseCfg.RewriteRules {
+		rules = append(rules, rewrite.Rule{Field: r.Field, Match: r.Match, Replace: r.Replace})
+	}
+	rewriter, err := rewrite.New(rules)
+	if err != nil {
+		log.Fatal("invalid rewrite rules", "err", err)
+	}
+
+	var failoverMgr *failover.Manager
+	if len(baseCfg.Failover.Pairs) > 0 {
+		pairs := make([]failover.Pair, 0, len(baseCfg.Failover.Pairs))
+		for _, p := range baseCfg.Failover.Pairs {
+			pairs = append(pairs, failover.Pair{Primary: p.Primary, Backup: p.Backup})
+		}
+		failoverMgr = failover.NewManager(pairs, time.Duration(baseCfg.Failover.LossTimeout), func(ev failover.Event) {
+			log.Warn("publisher switchover", "stream", ev.Stream, "from", ev.From.String(), "to", ev.To.String(), "reason", ev.Reason)
+			metricsReg.RecordFailoverSwitch(ev.Reason)
+		})
+	}
+
+	// Listeners come from the previous process or systemd when there is one,
+	// so they can be handed on again on SIGUSR2.
+	sockets, err := handoff.FromEnvironment(log)
+	if err != nil {
+		log.Fatal("failed to read inherited listeners", "err", err)
+	}
+	if n := sockets.Inherited(); n > 0 {
+		log.Info("inherited listeners", "count", n)
+	}
+
+	// The journal, the state database and the DVR directory are attached
+	// below, once the process this one replaces has released them.
+	var sessionJournal *journal.Journal
+	if baseCfg.SessionJournal.Path != "" {
+		sessionJournal = journal.New(time.Duration(baseCfg.SessionJournal.FlushInterval))
+		defer sessionJournal.Close()
+	}
+
+	accessLog, err := accesslog.Open(baseCfg.AccessLog)
+	if err != nil {
+		log.Fatal("failed to open access log", "err", err)
+	}
+	defer accessLog.Close()
+
+	var stateStore *state.Store
+	if baseCfg.State.Path != "" {
+		stateStore = state.New(baseCfg.State.SaveInterval.AsDuration(), relay.ActiveSessions)
+		defer stateStore.Close()
+	}
+
+	var graceHolder *grace.Holder
+	if baseCfg.ReconnectGrace > 0 {
+		graceHolder = grace.NewHolder(time.Duration(baseCfg.ReconnectGrace))
+	}
+
+	var transcodeSwitch *transcoder.KillSwitch
+	var transcodeSlots *transcoder.Slots
+	var capabilities *transcoder.Capabilities
+	if baseCfg.Transcode.Enabled {
+		transcodeSwitch = transcoder.NewKillSwitch(baseCfg.Transcode.KillSwitch)
+		if status := transcodeSwitch.Status(); status.Disabled || len(status.DisabledTenants) > 0 {
+			log.Warn("transcoding kill switch engaged at startup", "disabled", status.Disabled, "tenants", status.DisabledTenants, "fallback", status.Fallback)
+		}
+		transcodeSlots = transcoder.NewSlots(baseCfg.Transcode.Slots)
+
+		probeCtx, cancelProbe := context.WithTimeout(context.Background(), 10*time.Second)
+		capabilities = transcoder.ProbeCapabilities(probeCtx)
+		cancelProbe()
+		var hardware []string
+		for _, e := range capabilities.FFmpeg.Encoders {
+			if e.Hardware {
+				hardware = append(hardware, e.Name)
+			}
+		}
+		log.Info("transcode capabilities probed", "ffmpeg", capabilities.FFmpeg.Version, "ffmpeg_error", capabilities.FFmpeg.Error,
+			"libav", capabilities.LibAV.Version, "hardware_encoders", hardware, "devices", capabilities.Devices)
+	}
+
+	drain := relay.NewDrain(baseCfg.Drain)
+	routeDir := cluster.New(baseCfg.Cluster, func(stream string) bool {
+		_, ok := relay.LookupStream(stream)
+		return ok
+	})
+
+	thumbnails := thumbnail.New(baseCfg.Thumbnails, log)
+	recorder, err := dvr.New(baseCfg.DVR, log)
+	if err != nil {
+		log.Fatal("failed to start dvr", "err", err)
+	}
+	postProcess, err := postprocess.New(baseCfg.DVR.PostProcess, baseCfg.DVR.Dir, log, metricsReg)
+	if err != nil {
+		log.Fatal("failed to start dvr post-processing", "err", err)
+	}
+	if postProcess != nil {
+		recorder.OnSegmentClosed(postProcess.Submit)
+	}
+	packaging := packager.New(baseCfg.Packager, log)
+	viewers := relay.NewViewers(baseCfg.Viewers, metricsReg)
+
+	attach := func(fail func(msg string, args ...any)) {
+		if err := sessionJournal.Attach(baseCfg.SessionJournal.Path, baseCfg.SessionJournal.Compress); err != nil {
+			fail("failed to open session journal", "err", err)
+		}
+		// Recordings can only be resumed when the state store keeps their
+		// manifests.
+		var recordings dvr.Index
+		if err := stateStore.Attach(baseCfg.State.Path); err != nil {
+			fail("failed to open state store", "err", err)
+		} else if stateStore != nil {
+			recordings = stateStore
+			if prev, ok := stateStore.Previous(); ok {
+				if prev.Clean {
+					log.Info("previous run stopped cleanly", "started_at", prev.StartedAt, "stopped_at", prev.StoppedAt)
+				} else {
+					log.Warn("previous run did not shut down cleanly", "started_at", prev.StartedAt, "last_saved", prev.StoppedAt, "interrupted_sessions", len(prev.Interrupted))
+				}
+			}
+			metricsReg.ResumeSessionCompletions(stateStore.Carried())
+		}
+		if err := recorder.Attach(recordings); err != nil {
+			fail("failed to start dvr", "err", err)
+		}
+		if err := postProcess.RemoveStale(); err != nil {
+			fail("failed to clean up dvr post-processing", "err", err)
+		}
+	}
+	select {
+	case <-sockets.Released():
+		attach(log.Fatal)
+	default:
+		// Started by an upgrade: the old process is still writing these
+		// while it drains, so sessions are recorded in memory until then.
+		log.Info("waiting for the previous process to release the journal, state and recordings")
+		go func() {
+			<-sockets.Released()
+			attach(log.Error)
+			log.Info("previous process released the journal, state and recordings")
+		}()
+	}
 
 	srv := relay.Server{
 		ListenAddr:          baseCfg.ListenAddr,
@@ -156,43 +524,273 @@ func main() {
 		Auth:                authenticator,
 		RateLimit:           rateLimiter,
 		ConnLimit:           connLimiter,
+		PublishLimit:        publishLimiter,
+		DuplicatePublish:    baseCfg.DuplicatePublish,
+		AVSync:              baseCfg.AVSync,
+		StreamPolicy:        baseCfg.StreamPolicy,
+		Tenants:             tenants,
 		CircuitBreaker:      breaker,
 		BufPool:             bufPool,
 		RetryConfig:         retryCfg,
 		RetryJitter:         retryJitter,
 		Transcode:           baseCfg.Transcode,
+		TranscodeProfiles:   relay.NewTranscodeProfiles(baseCfg.Transcode, baseCfg.TranscodeProfiles),
 		TLSConfig:           tlsConfig,
+		Profiler:            profiler,
+		ChunkLimits:         chunkLimits,
+		SessionQueue:        baseCfg.RTMP.SessionQueue,
+		Strict:              baseCfg.RTMP.Strict,
+		Handshake:           handshakeOpts,
+		Failover:            failoverMgr,
+		Grace:               graceHolder,
+		Rewrite:             rewriter,
+		TranscodeSwitch:     transcodeSwitch,
+		TranscodeSlots:      transcodeSlots,
+		Drain:               drain,
+		Redirect:            relay.NewRedirector(baseCfg.Redirect, routeDir, log),
+		Routes:              router,
+		Thumbnails:          thumbnails,
+		DVR:                 recorder,
+		Packager:            packaging,
+		Viewers:             viewers,
+		Journal:             sessionJournal,
+		State:               stateStore,
+		AccessLog:           accessLog,
+		Events:              eventBus,
+		Metrics:             metricsReg,
 		UpstreamPool:        upstreamPool,
 		UpstreamHealthCheck: upstreamHealthCheck,
+		UpstreamDNS:         relay.DNSRefreshConfig{Interval: baseCfg.UpstreamDNSInterval.AsDuration()},
+		OutlierDetection: relay.OutlierConfig{
+			Enabled:       baseCfg.OutlierDetection.Enabled,
+			Window:        baseCfg.OutlierDetection.Window.AsDuration(),
+			MinRequests:   baseCfg.OutlierDetection.MinRequests,
+			FailureRate:   baseCfg.OutlierDetection.FailureRate,
+			SlowThreshold: baseCfg.OutlierDetection.SlowThreshold.AsDuration(),
+			BaseEjection:  baseCfg.OutlierDetection.BaseEjection.AsDuration(),
+			MaxEjection:   baseCfg.OutlierDetection.MaxEjection.AsDuration(),
+			Ramp:          baseCfg.OutlierDetection.Ramp.AsDuration(),
+		},
+		IdleTimeouts: relay.IdleTimeouts{
+			Read:           baseCfg.IdleTimeouts.Read.AsDuration(),
+			Write:          baseCfg.IdleTimeouts.Write.AsDuration(),
+			HandshakeRead:  baseCfg.IdleTimeouts.HandshakeRead.AsDuration(),
+			HandshakeWrite: baseCfg.IdleTimeouts.HandshakeWrite.AsDuration(),
+		},
+		StateTimeouts: relay.StateTimeouts{
+			Handshaking: baseCfg.StateTimeouts.Handshaking.AsDuration(),
+			Dialing:     baseCfg.StateTimeouts.Dialing.AsDuration(),
+			Relaying:    baseCfg.StateTimeouts.Relaying.AsDuration(),
+		},
+		HappyEyeballs: relay.HappyEyeballsConfig{
+			Enabled:      baseCfg.HappyEyeballs.Enabled,
+			AttemptDelay: baseCfg.HappyEyeballs.AttemptDelay.AsDuration(),
+		},
+		DownstreamTCP: relay.TCPKeepalive{
+			Idle:        baseCfg.TCP.Downstream.Idle.AsDuration(),
+			Interval:    baseCfg.TCP.Downstream.Interval.AsDuration(),
+			Count:       baseCfg.TCP.Downstream.Count,
+			UserTimeout: baseCfg.TCP.Downstream.UserTimeout.AsDuration(),
+		},
+		UpstreamTCP: relay.TCPKeepalive{
+			Idle:        baseCfg.TCP.Upstream.Idle.AsDuration(),
+			Interval:    baseCfg.TCP.Upstream.Interval.AsDuration(),
+			Count:       baseCfg.TCP.Upstream.Count,
+			UserTimeout: baseCfg.TCP.Upstream.UserTimeout.AsDuration(),
+		},
+		Prewarm: relay.PrewarmConfig{
+			Enabled:     baseCfg.UpstreamPrewarm.Enabled,
+			PerUpstream: baseCfg.UpstreamPrewarm.PerUpstream,
+			MaxIdle:     baseCfg.UpstreamPrewarm.MaxIdle.AsDuration(),
+			Interval:    baseCfg.UpstreamPrewarm.Interval.AsDuration(),
+		},
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	// Relay sessions run under their own context, not ctx, so a signal
+	// leaves them to drain until the Shutdown deadline.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	// Cancelled on shutdown, or once an upgraded process has taken over the
+	// listeners, to stop the HTTP servers.
+	serving, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+
+	go routeDir.Run(ctx, func() cluster.LocalState {
+		conns := relay.GetActiveConnectionsList()
+		st := cluster.LocalState{Sessions: len(conns), Viewers: viewers.Total(), Draining: drain.Draining()}
+		for _, c := range conns {
+			if c.Stream != "" {
+				st.Streams = append(st.Streams, c.Stream)
+			}
+		}
+		return st
+	}, log)
+
+	dnsResponder, err := dnsresponder.New(baseCfg.DNSResponder, func(ctx context.Context) bool {
+		return relay.UpstreamReady(ctx, primaryUpstream, upstreamPool)
+	}, log)
+	if err != nil {
+		log.Fatal("failed to configure dns responder", "err", err)
+	}
+	if certs != nil {
+		go certs.Run(ctx)
+	}
+
+	statsd, err := metrics.NewStatsD(prometheus.DefaultGatherer, metrics.StatsDOptions{
+		Address:   baseCfg.Metrics.StatsD.Address,
+		DogStatsD: baseCfg.Metrics.StatsD.Flavor == "dogstatsd",
+		Prefix:    baseCfg.Metrics.StatsD.Prefix,
+		Tags:      baseCfg.Metrics.StatsD.Tags,
+		Interval:  baseCfg.Metrics.StatsD.FlushInterval.AsDuration(),
+	})
+	if err != nil {
+		log.Fatal("failed to configure statsd emitter", "err", err)
+	}
+	go statsd.Run(ctx)
+	go viewers.Run(ctx)
+
+	if dnsResponder != nil {
+		go func() {
+			if err := dnsResponder.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("dns responder error", "err", err)
+			}
+		}()
+	}
 
+	// Validate has checked the mode already.
+	sockets.UnixMode, _ = baseCfg.UnixSocketFileMode()
+	rtmpListener, err := sockets.Listen(baseCfg.ListenAddr)
+	if err != nil {
+		log.Fatal("failed to listen", "addr", baseCfg.ListenAddr, "err", err)
+	}
+	rtmpListener = qos.Listener(rtmpListener, baseCfg.QoS.Class(baseCfg.QoS.RTMPListener))
+	var httpListener net.Listener
 	if baseCfg.HTTPAddr != "" {
+		if httpListener, err = sockets.Listen(baseCfg.HTTPAddr); err != nil {
+			log.Fatal("failed to listen", "addr", baseCfg.HTTPAddr, "err", err)
+		}
+		httpListener = qos.Listener(httpListener, baseCfg.QoS.Class(baseCfg.QoS.HTTPListener))
+		if tlsConfig != nil {
+			httpTLS := tlsConfig.Clone()
+			if len(httpTLS.NextProtos) == 0 {
+				httpTLS.NextProtos = []string{"h2", "http/1.1"}
+			}
+			httpListener = tls.NewListener(httpListener, httpTLS)
+		}
+	}
+	sockets.CloseUnused()
+
+	var mux *alpnmux.Mux
+	switch {
+	case baseCfg.Security.ALPNMux:
+		mux = alpnmux.Wrap(rtmpListener, tlsConfig, log)
+		srv.Listener = mux.Default()
+		go func() {
+			if err := mux.Serve(serving); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("alpn mux error", "err", err)
+			}
+		}()
+		log.Info("serving HTTPS alongside RTMPS via ALPN", "addr", baseCfg.ListenAddr)
+	case tlsConfig != nil:
+		srv.Listener = tls.NewListener(rtmpListener, tlsConfig)
+	default:
+		srv.Listener = rtmpListener
+	}
+
+	if baseCfg.HTTPAddr != "" || mux != nil {
+		var tunnel *rtmpt.Handler
+		if baseCfg.RTMPT.Enabled {
+			tunnel = rtmpt.NewHandler(relayCtx, srv.ServeConn, log, time.Duration(baseCfg.RTMPT.IdleTimeout))
+		}
 		httpSrv := httpserver.New(baseCfg.HTTPAddr, log, &httpserver.RelayStats{
 			ConnLimiter:    connLimiter,
+			PublishLimiter: publishLimiter,
 			RateLimit:      rateLimiter,
+			Auth:           authenticator,
+			PlaybackTokens: playbackTokens,
+			Tenants:        tenants,
 			Upstream:       primaryUpstream,
 			UpstreamPool:   upstreamPool,
+			Routes:         router,
 			CircuitBreaker: breaker,
 			BufferPool:     bufPool,
+			RTMPT:          tunnel,
+			Failover:       failoverMgr,
+			Grace:          graceHolder,
+			Transcode:      transcodeSwitch,
+			TranscodeSlots: transcodeSlots,
+			Capabilities:   capabilities,
+			DNS:            dnsResponder,
+			Drain:          drain,
+			Cluster:        routeDir,
+			Thumbnails:     thumbnails,
+			DVR:            recorder,
+			Packager:       packaging,
+			Viewers:        viewers,
+			State:          stateStore,
+			Events:         eventBus,
+			Readiness:      baseCfg.Readiness,
 		}, tlsConfig)
-		go func() {
-			if err := httpSrv.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
-				log.Error("http server error", "err", err)
-			}
-		}()
+		if httpListener != nil {
+			go func() {
+				if err := httpSrv.Serve(serving, httpListener); err != nil && !errors.Is(err, context.Canceled) {
+					log.Error("http server error", "err", err)
+				}
+			}()
+		}
+		if mux != nil {
+			go func() {
+				if err := httpSrv.Serve(serving, mux.HTTP()); err != nil && !errors.Is(err, context.Canceled) {
+					log.Error("alpn http server error", "err", err)
+				}
+			}()
+		}
 	}
 
 	errs := make(chan error, 1)
 	go func() {
-		errs <- srv.Run(ctx)
+		errs <- srv.Run(relayCtx)
+	}()
+	go func() {
+		select {
+		case <-srv.Ready():
+			sockets.Ready()
+		case <-ctx.Done():
+		}
+	}()
+
+	// SIGUSR2 starts a new copy of the binary on the same listeners; once it
+	// is serving, this process stops accepting and drains like on SIGTERM.
+	upgrade := make(chan os.Signal, 1)
+	upgraded := make(chan struct{})
+	handoff.NotifyUpgrade(upgrade)
+	go func() {
+		for {
+			select {
+			case <-upgrade:
+			case <-ctx.Done():
+				return
+			}
+			log.Info("upgrade requested, starting new process")
+			pid, err := sockets.Upgrade(handoff.DefaultReadyTimeout)
+			if err != nil {
+				log.Error("upgrade failed, still serving", "err", err)
+				continue
+			}
+			log.Info("new process is serving, draining this one", "pid", pid)
+			close(upgraded)
+			return
+		}
 	}()
 
 	select {
 	case <-ctx.Done():
 		log.Info("shutting down", "reason", ctx.Err())
+		stopServing()
+	case <-upgraded:
+		stopServing()
 	case err := <-errs:
 		if err != nil && !errors.Is(err, context.Canceled) {
 			log.Error("server error", "err", err)
@@ -200,35 +798,47 @@ func main() {
 		}
 	}
 
-	// Graceful shutdown with connection draining
+	// Shutdown returns once every session has finished, so the final byte
+	// counts and termination records are in before anything is flushed.
 	drainTimeout := 10 * time.Second
-	drainInterval := time.Second
 	drainStart := time.Now()
-
 	log.Info("draining connections", "timeout", drainTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	recorder.Suspend()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Warn("sessions still running at shutdown; their final records may be missing")
+	}
+	cancelDrain()
+	stopRelay()
+	// The segments closed as sessions ended are still being processed.
+	postCtx, cancelPost := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := postProcess.Close(postCtx); err != nil {
+		log.Warn("dvr post-processing unfinished at shutdown", "err", err)
+	}
+	cancelPost()
 
-	for {
-		elapsed := time.Since(drainStart)
-		if elapsed >= drainTimeout {
-			log.Warn("drain timeout reached, forcing shutdown", "elapsed", elapsed)
-			break
-		}
-
-		// Check remaining connections if connection limiter is available
-		if connLimiter != nil {
-			total, _ := connLimiter.GetActiveConnections()
-			if total == 0 {
-				log.Info("all connections drained", "elapsed", elapsed)
-				break
-			}
-			log.Info("waiting for connections to close", "active", total, "elapsed", elapsed, "remaining", drainTimeout-elapsed)
+	if err := sessionJournal.Close(); err != nil {
+		log.Error("failed to flush session journal", "err", err)
+	}
+	if err := accessLog.Close(); err != nil {
+		log.Error("failed to close access log", "err", err)
+	}
+	if err := stateStore.Close(); err != nil {
+		log.Error("failed to save relay state", "err", err)
+	}
+	// The upgraded process, if any, can open them now.
+	sockets.Release()
+	if baseCfg.Metrics.PushGateway != "" {
+		pushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metrics.Push(pushCtx, baseCfg.Metrics.PushGateway, baseCfg.Metrics.PushJob, prometheus.DefaultGatherer); err != nil {
+			log.Error("final metrics push failed", "err", err)
 		} else {
-			// No connection tracking, just wait a bit
-			time.Sleep(drainInterval)
-			break
+			log.Info("pushed final metrics", "gateway", baseCfg.Metrics.PushGateway)
 		}
-
-		time.Sleep(drainInterval)
+		cancel()
+	}
+	if err := statsd.Close(); err != nil {
+		log.Error("final statsd flush failed", "err", err)
 	}
 
 	log.Info("shutdown complete", "total_drain_time", time.Since(drainStart))
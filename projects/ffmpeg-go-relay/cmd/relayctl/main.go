@@ -0,0 +1,274 @@
+// Command relayctl drives a running relay through its HTTP admin API, so
+// operators can script common tasks without hand-writing curl calls. Go
+// programs can use package relayclient, which relayctl is built on.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ffmpeg-go-relay/pkg/relayclient"
+)
+
+// command is one relayctl subcommand. args excludes the command name.
+type command struct {
+	usage   string
+	summary string
+	run     func(ctx context.Context, c *relayclient.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"status":       {"status", "relay status as reported by /status", runStatus},
+	"sessions":     {"sessions [kill <request_id>]", "list active sessions, or end one", runSessions},
+	"upstreams":    {"upstreams", "upstream health and weights", runUpstreams},
+	"breaker":      {"breaker [reset]", "circuit breaker state, or close it", runBreaker},
+	"transcode":    {"transcode [enable|disable [tenant]]", "transcoding kill switch state, or flip it", runTranscode},
+	"capabilities": {"capabilities", "encoders, hardware acceleration and ffmpeg/libav versions", runCapabilities},
+	"compliance":   {"compliance [request_id]", "strict-mode RTMP compliance reports", runCompliance},
+	"drain":        {"drain [start [delay]|stop]", "maintenance drain state, or start or end one", runDrain},
+	"token":        {"token <stream> [ttl]", "mint a signed playback token for a stream", runToken},
+	"version":      {"version", "relay build information", runVersion},
+}
+
+var rawJSON bool
+
+func main() {
+	addr := flag.String("addr", envOr("RELAYCTL_ADDR", "http://127.0.0.1:8080"), "Relay HTTP address (env RELAYCTL_ADDR)")
+	token := flag.String("token", os.Getenv("RELAYCTL_TOKEN"), "Publish token sent as a bearer credential, needed by token (env RELAYCTL_TOKEN)")
+	timeout := flag.Duration("timeout", 10*time.Second, "Request timeout")
+	flag.BoolVar(&rawJSON, "json", false, "Print responses as JSON instead of tables")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "relayctl: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := relayclient.New(*addr, nil).WithToken(*token)
+	if err := cmd.run(ctx, c, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "relayctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: relayctl [flags] <command> [args]")
+	fmt.Fprintln(out, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// show prints a response verbatim, indented.
+func show(ctx context.Context, c *relayclient.Client, method, path string, body any) error {
+	var raw json.RawMessage
+	if err := c.Do(ctx, method, path, body, &raw); err != nil {
+		return err
+	}
+	return printJSON(raw)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	return nil
+}
+
+func runStatus(ctx context.Context, c *relayclient.Client, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	return show(ctx, c, http.MethodGet, "/status", nil)
+}
+
+func runVersion(ctx context.Context, c *relayclient.Client, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	return show(ctx, c, http.MethodGet, "/version", nil)
+}
+
+func runSessions(ctx context.Context, c *relayclient.Client, args []string) error {
+	if len(args) > 0 {
+		if args[0] != "kill" || len(args) != 2 {
+			return errors.New("usage: sessions kill <request_id>")
+		}
+		return show(ctx, c, http.MethodDelete, "/admin/connections?request_id="+url.QueryEscape(args[1]), nil)
+	}
+
+	if rawJSON {
+		return show(ctx, c, http.MethodGet, "/admin/connections", nil)
+	}
+	connections, err := c.Connections(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].StartTime.Before(connections[j].StartTime)
+	})
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST_ID\tCLIENT\tSTATE\tAGE\tCODEC\tENCODE\tFFMPEG\tUPSTREAM")
+	for _, conn := range connections {
+		age := time.Since(conn.StartTime).Truncate(time.Second)
+		encode := "-"
+		if p := conn.Transcode; p != nil {
+			encode = fmt.Sprintf("%.1ffps %.2fx %d dropped", p.FPS, p.Speed, p.DroppedFrames)
+		}
+		usage := "-"
+		if u := conn.TranscodeUsage; u != nil {
+			usage = fmt.Sprintf("%.0f%% cpu %.0fMiB", u.CPUPercent, float64(u.RSSBytes)/(1<<20))
+		}
+		codec := conn.VideoCodec
+		if codec == "" {
+			codec = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", conn.RequestID, conn.ClientAddr, conn.State, age, codec, encode, usage, conn.Upstream)
+	}
+	return tw.Flush()
+}
+
+func runUpstreams(ctx context.Context, c *relayclient.Client, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if rawJSON {
+		return printJSON(struct {
+			Upstream  string                       `json:"upstream"`
+			Strategy  string                       `json:"upstream_strategy"`
+			Upstreams []relayclient.UpstreamStatus `json:"upstreams"`
+		}{status.Upstream, status.UpstreamStrategy, status.Upstreams})
+	}
+	if len(status.Upstreams) == 0 {
+		fmt.Println("single upstream:", status.Upstream)
+		return nil
+	}
+	fmt.Println("strategy:", status.UpstreamStrategy)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tWEIGHT\tHEALTHY\tLAST_CHECKED\tLAST_ERROR")
+	for _, u := range status.Upstreams {
+		checked := "never"
+		if u.LastCheckedUnix > 0 {
+			checked = time.Since(time.Unix(u.LastCheckedUnix, 0)).Truncate(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%t\t%s\t%s\n", u.URL, u.Weight, u.Healthy, checked, u.LastError)
+	}
+	return tw.Flush()
+}
+
+func runBreaker(ctx context.Context, c *relayclient.Client, args []string) error {
+	switch {
+	case len(args) == 0:
+		return show(ctx, c, http.MethodGet, "/admin/circuit-breaker", nil)
+	case len(args) == 1 && args[0] == "reset":
+		return show(ctx, c, http.MethodPost, "/admin/circuit-breaker/reset", nil)
+	}
+	return errors.New("usage: breaker [reset]")
+}
+
+func runTranscode(ctx context.Context, c *relayclient.Client, args []string) error {
+	if len(args) == 0 {
+		return show(ctx, c, http.MethodGet, "/admin/transcode", nil)
+	}
+	if len(args) > 2 || (args[0] != "enable" && args[0] != "disable") {
+		return errors.New("usage: transcode [enable|disable [tenant]]")
+	}
+	toggle := map[string]any{"disabled": args[0] == "disable"}
+	if len(args) == 2 {
+		toggle["tenant"] = args[1]
+	}
+	return show(ctx, c, http.MethodPost, "/admin/transcode", toggle)
+}
+
+func runCapabilities(ctx context.Context, c *relayclient.Client, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	return show(ctx, c, http.MethodGet, "/admin/capabilities", nil)
+}
+
+func runDrain(ctx context.Context, c *relayclient.Client, args []string) error {
+	switch {
+	case len(args) == 0:
+		return show(ctx, c, http.MethodGet, "/admin/drain", nil)
+	case len(args) == 1 && args[0] == "start":
+		return show(ctx, c, http.MethodPost, "/admin/drain", nil)
+	case len(args) == 2 && args[0] == "start":
+		if _, err := time.ParseDuration(args[1]); err != nil {
+			return fmt.Errorf("drain delay: %w", err)
+		}
+		return show(ctx, c, http.MethodPost, "/admin/drain", map[string]any{"after": args[1]})
+	case len(args) == 1 && args[0] == "stop":
+		return show(ctx, c, http.MethodPost, "/admin/undrain", nil)
+	}
+	return errors.New("usage: drain [start [delay]|stop]")
+}
+
+func runToken(ctx context.Context, c *relayclient.Client, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: token <stream> [ttl]")
+	}
+	body := map[string]any{"stream": args[0]}
+	if len(args) == 2 {
+		if _, err := time.ParseDuration(args[1]); err != nil {
+			return fmt.Errorf("token ttl: %w", err)
+		}
+		body["ttl"] = args[1]
+	}
+	return show(ctx, c, http.MethodPost, "/admin/playback-tokens", body)
+}
+
+func runCompliance(ctx context.Context, c *relayclient.Client, args []string) error {
+	switch len(args) {
+	case 0:
+		return show(ctx, c, http.MethodGet, "/admin/compliance", nil)
+	case 1:
+		return show(ctx, c, http.MethodGet, "/admin/compliance?request_id="+url.QueryEscape(args[0]), nil)
+	}
+	return errors.New("usage: compliance [request_id]")
+}
@@ -0,0 +1,293 @@
+// Command rtmp-probe connects to an RTMP URL, runs the handshake, connect
+// and a publish or play, and reports how long each step took along with what
+// the server negotiated. It is a relay-native stand-in for ad-hoc ffprobe
+// checks.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Probe modes.
+const (
+	modeConnect = "connect"
+	modePublish = "publish"
+	modePlay    = "play"
+)
+
+// report is everything the probe learned, printed as a table or JSON.
+type report struct {
+	URL     string `json:"url"`
+	Mode    string `json:"mode"`
+	Address string `json:"address"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Status  string `json:"status,omitempty"` // RTMP status code of a refusal
+
+	Timings []timing `json:"timings"`
+
+	ChunkSizeIn   uint32                 `json:"chunk_size_in,omitempty"`
+	ChunkSizeOut  uint32                 `json:"chunk_size_out,omitempty"`
+	WindowAckSize uint32                 `json:"window_ack_size,omitempty"`
+	PeerBandwidth uint32                 `json:"peer_bandwidth,omitempty"`
+	ServerProps   map[string]interface{} `json:"server_properties,omitempty"`
+	ConnectInfo   map[string]interface{} `json:"connect_info,omitempty"`
+	StreamStatus  map[string]interface{} `json:"stream_status,omitempty"`
+
+	// Play only: what the stream carries.
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	VideoCodec string                 `json:"video_codec,omitempty"`
+	AudioCodec string                 `json:"audio_codec,omitempty"`
+}
+
+type timing struct {
+	Step     string        `json:"step"`
+	Duration time.Duration `json:"-"`
+	Millis   float64       `json:"ms"`
+}
+
+func main() {
+	mode := flag.String("mode", modePublish, "What to negotiate after connect: connect (nothing), publish or play")
+	timeout := flag.Duration("timeout", 10*time.Second, "Time allowed for the whole probe")
+	mediaWait := flag.Duration("media-wait", 5*time.Second, "With -mode play, how long to wait for the stream's codecs")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification for rtmps:// URLs")
+	asJSON := flag.Bool("json", false, "Print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: rtmp-probe [flags] rtmp://host[:port]/app[/stream]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch *mode {
+	case modeConnect, modePublish, modePlay:
+	default:
+		fmt.Fprintf(os.Stderr, "rtmp-probe: unknown -mode %q\n", *mode)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	r := probe(ctx, flag.Arg(0), *mode, *mediaWait, *insecure)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	} else {
+		printReport(r)
+	}
+	if !r.OK {
+		os.Exit(1)
+	}
+}
+
+func probe(ctx context.Context, raw, mode string, mediaWait time.Duration, insecure bool) *report {
+	r := &report{URL: raw, Mode: mode}
+	u, err := rtmp.ParseURL(raw)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Address = u.Address
+	if mode != modeConnect && u.Stream == "" {
+		r.Error = fmt.Sprintf("-mode %s needs a stream in the URL", mode)
+		return r
+	}
+
+	err = run(ctx, r, u, mode, mediaWait, insecure)
+	if err != nil {
+		r.Error = err.Error()
+		var se *rtmp.StatusError
+		if errors.As(err, &se) {
+			r.Status = se.Code
+		}
+		return r
+	}
+	r.OK = true
+	return r
+}
+
+func run(ctx context.Context, r *report, u *rtmp.URL, mode string, mediaWait time.Duration, insecure bool) error {
+	step := func(name string, fn func() error) error {
+		start := time.Now()
+		err := fn()
+		d := time.Since(start)
+		r.Timings = append(r.Timings, timing{Step: name, Duration: d, Millis: float64(d.Microseconds()) / 1000})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	var conn net.Conn
+	if err := step("tcp", func() (err error) {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", u.Address)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.TLS() {
+		host, _, _ := net.SplitHostPort(u.Address)
+		tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+		if err := step("tls", func() error { return tc.HandshakeContext(ctx) }); err != nil {
+			return err
+		}
+		conn = tc
+	}
+	if err := step("handshake", func() error { return rtmp.ClientHandshake(conn, nil) }); err != nil {
+		return err
+	}
+
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(conn), conn)
+	defer func() {
+		r.ChunkSizeIn, r.ChunkSizeOut = session.ChunkSizes()
+		r.WindowAckSize, r.PeerBandwidth = session.WindowAckSize, session.PeerBandwidth
+	}()
+	if err := step("connect", func() error {
+		res, err := session.Connect(u.App, u.TCURL())
+		if res != nil {
+			r.ServerProps, r.ConnectInfo = res.Properties, res.Info
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	switch mode {
+	case modePublish:
+		// Nothing is sent, so the publish ends as soon as it has started.
+		err := step("publish", func() (err error) {
+			r.StreamStatus, err = session.Publish(u.Stream)
+			return err
+		})
+		if err == nil {
+			session.Unpublish(u.Stream)
+		}
+		return err
+	case modePlay:
+		if err := step("play", func() (err error) {
+			r.StreamStatus, err = session.Play(u.Stream)
+			return err
+		}); err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Now().Add(mediaWait))
+		return step("first media", func() error { return readCodecs(session, r) })
+	}
+	return nil
+}
+
+// readCodecs reads the played stream until both codecs and the metadata are
+// known. Running out of time after some media arrived is not an error:
+// streams may lack audio or metadata.
+func readCodecs(session *rtmp.ClientSession, r *report) error {
+	for r.VideoCodec == "" || r.AudioCodec == "" || r.Metadata == nil {
+		msg, err := session.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && (r.VideoCodec != "" || r.AudioCodec != "") {
+				return nil
+			}
+			return err
+		}
+		switch msg.Header.TypeID {
+		case rtmp.TypeVideo:
+			if h, err := rtmp.ParseVideoHeader(msg.Payload); err == nil {
+				r.VideoCodec = h.Codec()
+			}
+		case rtmp.TypeAudio:
+			if h, err := rtmp.ParseAudioHeader(msg.Payload); err == nil {
+				r.AudioCodec = h.Codec()
+			}
+		case rtmp.TypeAMF0Data:
+			r.Metadata = metadata(msg.Payload)
+		}
+	}
+	return nil
+}
+
+// metadata returns the properties of an onMetaData message, or an empty map
+// for other data messages so the wait for one ends.
+func metadata(payload []byte) map[string]interface{} {
+	vals, _ := rtmp.DecodeAMF0(bytes.NewReader(payload))
+	for i, v := range vals {
+		if v == "onMetaData" && i+1 < len(vals) {
+			if m, ok := vals[i+1].(map[string]interface{}); ok {
+				return m
+			}
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func printReport(r *report) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "url\t%s\n", r.URL)
+	if r.Address != "" {
+		fmt.Fprintf(tw, "address\t%s\n", r.Address)
+	}
+	var total time.Duration
+	for _, t := range r.Timings {
+		total += t.Duration
+		fmt.Fprintf(tw, "%s\t%v\n", t.Step, t.Duration.Round(10*time.Microsecond))
+	}
+	if len(r.Timings) > 0 {
+		fmt.Fprintf(tw, "total\t%v\n", total.Round(10*time.Microsecond))
+	}
+	if r.ChunkSizeIn > 0 {
+		fmt.Fprintf(tw, "chunk size\tin %d, out %d\n", r.ChunkSizeIn, r.ChunkSizeOut)
+		fmt.Fprintf(tw, "window ack size\t%d\n", r.WindowAckSize)
+		fmt.Fprintf(tw, "peer bandwidth\t%d\n", r.PeerBandwidth)
+	}
+	printMap(tw, "server", r.ServerProps)
+	printMap(tw, "connect", r.ConnectInfo)
+	printMap(tw, r.Mode, r.StreamStatus)
+	if r.VideoCodec != "" || r.AudioCodec != "" {
+		fmt.Fprintf(tw, "codecs\tvideo %s, audio %s\n", orNone(r.VideoCodec), orNone(r.AudioCodec))
+	}
+	printMap(tw, "metadata", r.Metadata)
+	if r.OK {
+		fmt.Fprintf(tw, "result\tok\n")
+	} else {
+		fmt.Fprintf(tw, "result\tFAILED: %s\n", r.Error)
+	}
+	tw.Flush()
+}
+
+func printMap(tw *tabwriter.Writer, prefix string, m map[string]interface{}) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s.%s\t%v\n", prefix, k, m[k])
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
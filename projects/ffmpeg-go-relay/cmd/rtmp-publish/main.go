@@ -0,0 +1,253 @@
+// Command rtmp-publish publishes a generated test pattern, or an FLV file, to
+// an RTMP URL in real time, so relay deployments can be smoke-tested without
+// OBS or ffmpeg on hand.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/testpattern"
+)
+
+func main() {
+	file := flag.String("file", "", "FLV file to publish instead of the test pattern")
+	loop := flag.Bool("loop", false, "Start -file over when it ends")
+	duration := flag.Duration("duration", 0, "Stop after this long; 0 runs until interrupted or -file ends")
+	size := flag.String("size", "320x240", "Test pattern size, multiples of 16")
+	fps := flag.Int("fps", 25, "Test pattern frame rate")
+	gop := flag.Duration("keyframe-interval", time.Second, "Test pattern keyframe interval")
+	bitrate := flag.Int("bitrate-kbps", 0, "Pad test pattern video up to this bitrate; 0 sends it as coded")
+	noAudio := flag.Bool("no-audio", false, "Leave out the test pattern's silent audio track")
+	timeout := flag.Duration("timeout", 10*time.Second, "Time allowed to connect and start publishing")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification for rtmps:// URLs")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: rtmp-publish [flags] rtmp://host[:port]/app/stream")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	var src source
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		src = &flvSource{f: f, r: rtmp.NewFLVReader(f), loop: *loop}
+	} else {
+		var w, h int
+		if _, err := fmt.Sscanf(*size, "%dx%d", &w, &h); err != nil {
+			fatal(fmt.Errorf("bad -size %q", *size))
+		}
+		g, err := testpattern.New(testpattern.Options{
+			Width: w, Height: h, FPS: *fps, KeyframeInterval: *gop,
+			Bitrate: *bitrate * 1000, NoAudio: *noAudio,
+		})
+		if err != nil {
+			fatal(err)
+		}
+		src = &patternSource{g: g}
+	}
+
+	target := flag.Arg(0)
+	for hops := 0; ; hops++ {
+		err := publish(ctx, target, src, *timeout, *insecure)
+		var se *rtmp.StatusError
+		if errors.As(err, &se) && se.Redirect != "" && hops < maxRedirects {
+			u, _ := rtmp.ParseURL(target)
+			target = strings.TrimRight(se.Redirect, "/") + "/" + u.Stream
+			fmt.Printf("redirected to %s\n", target)
+			continue
+		}
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+}
+
+// maxRedirects bounds how many connect redirects are followed.
+const maxRedirects = 3
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "rtmp-publish:", err)
+	os.Exit(1)
+}
+
+// source yields messages in timestamp order; io.EOF ends the publish.
+type source interface {
+	Next() ([]*rtmp.Message, error)
+}
+
+type patternSource struct {
+	g       *testpattern.Generator
+	started bool
+}
+
+func (p *patternSource) Next() ([]*rtmp.Message, error) {
+	if !p.started {
+		p.started = true
+		return p.g.Headers(), nil
+	}
+	return p.g.Next(), nil
+}
+
+// flvSource replays a file's tags. Looping shifts each pass to follow the
+// previous one so timestamps keep increasing.
+type flvSource struct {
+	f    io.ReadSeeker
+	r    *rtmp.FLVReader
+	loop bool
+
+	offset, last uint32
+}
+
+func (s *flvSource) Next() ([]*rtmp.Message, error) {
+	msg, err := s.r.ReadMessage()
+	if err == io.EOF && s.loop && s.last > 0 {
+		if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		s.r = rtmp.NewFLVReader(s.f)
+		s.offset = s.last + 40
+		msg, err = s.r.ReadMessage()
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.Header.Timestamp += s.offset
+	s.last = msg.Header.Timestamp
+	return []*rtmp.Message{msg}, nil
+}
+
+func publish(ctx context.Context, raw string, src source, timeout time.Duration, insecure bool) error {
+	u, err := rtmp.ParseURL(raw)
+	if err != nil {
+		return err
+	}
+	if u.Stream == "" {
+		return fmt.Errorf("%s names no stream", raw)
+	}
+
+	setup, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	var conn net.Conn
+	if u.TLS() {
+		d := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: insecure}}
+		conn, err = d.DialContext(setup, "tcp", u.Address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(setup, "tcp", u.Address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Setup runs on blocking reads, bounded by the deadline instead of ctx.
+	if deadline, ok := setup.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := rtmp.ClientHandshake(conn, nil); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(conn), conn)
+	if _, err := session.Connect(u.App, u.TCURL()); err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	if _, err := session.Publish(u.Stream); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	fmt.Printf("publishing %s (setup %v)\n", raw, time.Since(start).Round(time.Millisecond))
+
+	// The server's acknowledgements are read and dropped; the read failing
+	// means the server closed the session.
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := session.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	var sent int64
+	var first, latest uint32
+	began, lastReport := time.Now(), time.Now()
+	for n := 0; ; n++ {
+		msgs, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			ts := msg.Header.Timestamp
+			if n == 0 {
+				first = ts
+			}
+			// Send in real time: each message when its timestamp is due.
+			if wait := time.Until(began.Add(time.Duration(int32(ts-first)) * time.Millisecond)); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return finish(session, u.Stream, sent, began)
+				case err := <-closed:
+					return fmt.Errorf("server closed the session: %w", err)
+				case <-time.After(wait):
+				}
+			}
+			if err := session.WriteMedia(msg); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+			sent += int64(len(msg.Payload))
+			latest = ts
+		}
+		if ctx.Err() != nil {
+			return finish(session, u.Stream, sent, began)
+		}
+		if time.Since(lastReport) >= 5*time.Second {
+			lastReport = time.Now()
+			fmt.Printf("sent %s of media, %d KiB, %.0f kbit/s\n",
+				time.Duration(latest-first)*time.Millisecond, sent/1024, rate(sent, began))
+		}
+	}
+	return finish(session, u.Stream, sent, began)
+}
+
+func finish(session *rtmp.ClientSession, stream string, sent int64, began time.Time) error {
+	if err := session.Unpublish(stream); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("unpublish: %w", err)
+	}
+	fmt.Printf("done: %d KiB in %v, %.0f kbit/s\n", sent/1024, time.Since(began).Round(time.Second), rate(sent, began))
+	return nil
+}
+
+func rate(bytes int64, since time.Time) float64 {
+	return float64(bytes) * 8 / 1000 / time.Since(since).Seconds()
+}
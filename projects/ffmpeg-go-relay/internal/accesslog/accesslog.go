@@ -0,0 +1,123 @@
+// Package accesslog writes one JSON line per finished session, separate from
+// the debug log, for billing and analytics pipelines. Lines go to a file,
+// stdout, a Loki or syslog shipper, or several of these at once.
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logship"
+)
+
+// Auth results recorded for a session.
+const (
+	AuthNone     = "none"     // No authenticator applied to the session
+	AuthOK       = "ok"       // The session's token was accepted
+	AuthRejected = "rejected" // The session was refused for its token
+)
+
+// Record summarizes one finished session.
+type Record struct {
+	Time       time.Time `json:"time"` // When the session ended
+	RequestID  string    `json:"request_id"`
+	ClientAddr string    `json:"client_addr"`
+	App        string    `json:"app,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	BytesIn    int64     `json:"bytes_in"`  // Read from the client
+	BytesOut   int64     `json:"bytes_out"` // Written to the client
+	Auth       string    `json:"auth"`
+	Reason     string    `json:"reason"`
+	ErrorClass string    `json:"error_class,omitempty"` // e.g. "protocol" or "upstream_dial", for sessions that ended with an error
+	Error      string    `json:"error,omitempty"`
+}
+
+// Log is safe for concurrent use. A nil Log discards records.
+type Log struct {
+	mu      sync.Mutex
+	w       io.Writer
+	file    *os.File // nil when writing to stdout or only shipping
+	shipper *logship.Shipper
+}
+
+// Open starts the sinks in cfg. It returns nil when none is configured.
+func Open(cfg config.AccessLogConfig) (*Log, error) {
+	if cfg.Path == "" && cfg.Ship.Type == "" {
+		return nil, nil
+	}
+	l := &Log{}
+	var writers []io.Writer
+	switch cfg.Path {
+	case "":
+	case "-":
+		writers = append(writers, os.Stdout)
+	default:
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("accesslog: open: %w", err)
+		}
+		l.file = f
+		writers = append(writers, f)
+	}
+	shipper, err := logship.New(cfg.Ship)
+	if err != nil {
+		if l.file != nil {
+			l.file.Close()
+		}
+		return nil, fmt.Errorf("accesslog: %w", err)
+	}
+	if shipper != nil {
+		l.shipper = shipper
+		writers = append(writers, shipper)
+	}
+	l.w = io.MultiWriter(writers...)
+	return l, nil
+}
+
+// Write appends r as one line.
+func (l *Log) Write(r Record) error {
+	if l == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("accesslog: encode: %w", err)
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// One Write per record: the shipper treats each Write as one line.
+	if _, err := l.w.Write(line); err != nil {
+		return fmt.Errorf("accesslog: write: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the shipper and closes the file. Records written after
+// Close are dropped.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	if l.shipper != nil {
+		errs = append(errs, l.shipper.Close())
+	}
+	if l.file != nil {
+		errs = append(errs, l.file.Close())
+	}
+	l.w, l.file, l.shipper = io.Discard, nil, nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestLogAppendsOneLinePerRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(config.AccessLogConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	records := []Record{
+		{RequestID: "a", ClientAddr: "10.0.0.1:5000", Stream: "cam", Start: start, DurationMS: 60000, BytesIn: 1 << 20, BytesOut: 3500, Auth: AuthOK, Reason: "client_disconnect"},
+		{RequestID: "b", ClientAddr: "10.0.0.2:5000", Start: start, Auth: AuthRejected, Reason: "auth_failure", ErrorClass: "auth", Error: "authentication failed"},
+	}
+	for _, r := range records {
+		if err := l.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(records[0]); err != nil {
+		t.Fatalf("Write after Close = %v, want the record dropped quietly", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []Record
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, r)
+	}
+	if len(got) != 2 || got[0].BytesIn != 1<<20 || got[0].Stream != "cam" || !got[0].Start.Equal(start) {
+		t.Fatalf("records = %+v, want the two written", got)
+	}
+	if got[1].Auth != AuthRejected || got[1].ErrorClass != "auth" {
+		t.Fatalf("second record = %+v", got[1])
+	}
+}
+
+func TestOpenDisabled(t *testing.T) {
+	l, err := Open(config.AccessLogConfig{})
+	if err != nil || l != nil {
+		t.Fatalf("Open with no sinks = %v, %v; want nil, nil", l, err)
+	}
+	if err := l.Write(Record{RequestID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
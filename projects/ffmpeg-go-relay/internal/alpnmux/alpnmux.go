@@ -0,0 +1,171 @@
+// Package alpnmux shares one TLS port between RTMPS and HTTPS. Each
+// connection finishes its TLS handshake first; clients that negotiated an
+// HTTP protocol through ALPN go to the HTTP listener, everything else (RTMPS
+// encoders rarely send ALPN at all) to the default one.
+package alpnmux
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// HTTPProtocols are the ALPN identifiers routed to the HTTP listener. HTTP/2
+// is not offered: the admin and tunnel endpoints gain nothing from it, and
+// serving it would need the HTTP/2 server wired to the listener.
+var HTTPProtocols = []string{"http/1.1"}
+
+// DefaultHandshakeTimeout bounds how long a connection may take to finish its
+// TLS handshake before it is dropped.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Mux accepts TLS connections and splits them by negotiated protocol.
+type Mux struct {
+	ln      net.Listener
+	log     *logger.Logger
+	timeout time.Duration
+
+	http *connListener
+	rtmp *connListener
+}
+
+// Listen opens a TLS listener on addr that advertises HTTPProtocols through
+// ALPN. config is cloned, not modified.
+func Listen(addr string, config *tls.Config, log *logger.Logger) (*Mux, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(ln, config, log), nil
+}
+
+// Wrap is Listen on an already open TCP listener, such as one inherited
+// across an upgrade.
+func Wrap(ln net.Listener, config *tls.Config, log *logger.Logger) *Mux {
+	cfg := config.Clone()
+	plain := config.Clone()
+	for _, p := range HTTPProtocols {
+		if !slices.Contains(cfg.NextProtos, p) {
+			cfg.NextProtos = append(cfg.NextProtos, p)
+		}
+	}
+	// crypto/tls fails handshakes whose ALPN offer shares nothing with
+	// NextProtos; answer those without ALPN so they land on the default
+	// listener instead.
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(hello.SupportedProtos) > 0 && !slices.ContainsFunc(hello.SupportedProtos, func(p string) bool {
+			return slices.Contains(cfg.NextProtos, p)
+		}) {
+			return plain, nil
+		}
+		return nil, nil
+	}
+	return New(tls.NewListener(ln, cfg), log)
+}
+
+// New multiplexes ln, whose connections must be *tls.Conn.
+func New(ln net.Listener, log *logger.Logger) *Mux {
+	return &Mux{
+		ln:      ln,
+		log:     log,
+		timeout: DefaultHandshakeTimeout,
+		http:    newConnListener(ln.Addr()),
+		rtmp:    newConnListener(ln.Addr()),
+	}
+}
+
+// HTTP returns the listener for connections that negotiated HTTP.
+func (m *Mux) HTTP() net.Listener { return m.http }
+
+// Default returns the listener for every other connection.
+func (m *Mux) Default() net.Listener { return m.rtmp }
+
+// Serve accepts connections until ctx is done or the listener fails, then
+// closes both child listeners.
+func (m *Mux) Serve(ctx context.Context) error {
+	defer m.http.Close()
+	defer m.rtmp.Close()
+	go func() {
+		<-ctx.Done()
+		m.ln.Close()
+	}()
+
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go m.route(ctx, conn)
+	}
+}
+
+// route handshakes conn off the accept loop so slow clients cannot stall it.
+func (m *Mux) route(ctx context.Context, conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		m.rtmp.deliver(conn)
+		return
+	}
+	hsCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(hsCtx); err != nil {
+		m.log.Debug("tls handshake failed", "client", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		return
+	}
+	if slices.Contains(HTTPProtocols, tlsConn.ConnectionState().NegotiatedProtocol) {
+		m.http.deliver(conn)
+		return
+	}
+	m.rtmp.deliver(conn)
+}
+
+// connListener is a net.Listener fed by the Mux.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *connListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,144 @@
+package alpnmux
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay.example"},
+		DNSNames:     []string{"relay.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// accept returns the next connection from ln, failing the test on timeout.
+func accept(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+	got := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(got)
+			return
+		}
+		got <- conn
+	}()
+	select {
+	case conn, ok := <-got:
+		if !ok {
+			t.Fatal("listener closed before a connection arrived")
+		}
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection routed")
+	}
+	return nil
+}
+
+func TestMuxRoutesByALPN(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+	m, err := Listen("127.0.0.1:0", serverCfg, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serverCfg.NextProtos) != 0 {
+		t.Fatal("Listen modified the caller's tls.Config")
+	}
+	done := make(chan error, 1)
+	go func() { done <- m.Serve(ctx) }()
+	addr := m.HTTP().Addr().String()
+
+	dial := func(protos ...string) *tls.Conn {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	dial("http/1.1")
+	conn := accept(t, m.HTTP())
+	if p := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; p != "http/1.1" {
+		t.Fatalf("http conn negotiated %q", p)
+	}
+	conn.Close()
+
+	// RTMPS encoders send no ALPN, or protocols the relay does not know.
+	dial()
+	accept(t, m.Default()).Close()
+	dial("rtmp")
+	accept(t, m.Default()).Close()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+	if _, err := m.Default().Accept(); err == nil {
+		t.Fatal("Default listener still accepting after Serve returned")
+	}
+}
+
+func TestMuxDropsStalledHandshakes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := Listen("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.timeout = 50 * time.Millisecond
+	go m.Serve(ctx)
+
+	// A client that never starts TLS must not hold up the next one.
+	stalled, err := net.Dial("tcp", m.HTTP().Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	conn, err := tls.Dial("tcp", m.HTTP().Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accept(t, m.Default()).Close()
+
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err == nil {
+		t.Fatal("stalled connection was not closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("stalled connection was not closed after the handshake timeout")
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPlaybackTTL is the lifetime of playback tokens minted without one.
+const DefaultPlaybackTTL = time.Hour
+
+// minSecretLen is the shortest playback signing secret accepted, in bytes.
+const minSecretLen = 16
+
+var (
+	ErrTokenExpired = errors.New("playback token expired")
+	ErrTokenInvalid = errors.New("invalid playback token")
+)
+
+// PlaybackSigner mints and checks signed playback tokens. A token binds one
+// stream name and an expiry time with an HMAC-SHA256 signature, so it can
+// be handed to a player in a URL without revealing any publish token.
+type PlaybackSigner struct {
+	keys [][]byte // The first signs; every one verifies
+	ttl  time.Duration
+}
+
+// NewPlaybackSigner returns a signer using base64-encoded secrets. The first
+// secret signs new tokens and all of them verify, so a new secret can be put
+// first while tokens signed with the old one run out. ttl is the lifetime of
+// tokens minted without one; 0 uses DefaultPlaybackTTL.
+func NewPlaybackSigner(secrets []string, ttl time.Duration) (*PlaybackSigner, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("playback tokens need a signing secret")
+	}
+	s := &PlaybackSigner{ttl: ttl}
+	if s.ttl <= 0 {
+		s.ttl = DefaultPlaybackTTL
+	}
+	for i, secret := range secrets {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("playback secret %d is not base64: %w", i, err)
+		}
+		if len(key) < minSecretLen {
+			return nil, fmt.Errorf("playback secret %d is %d bytes, want at least %d", i, len(key), minSecretLen)
+		}
+		s.keys = append(s.keys, key)
+	}
+	return s, nil
+}
+
+// Mint returns a token for stream valid for ttl, or for the signer's
+// default lifetime when ttl is 0, and when it expires.
+func (s *PlaybackSigner) Mint(stream string, ttl time.Duration) (string, time.Time) {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	return s.Sign(stream, expires), expires
+}
+
+// Sign returns a token for stream valid until expires, to the second.
+func (s *PlaybackSigner) Sign(stream string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + base64.RawURLEncoding.EncodeToString(signature(s.keys[0], stream, exp))
+}
+
+// Verify checks that token was signed for stream and has not expired by
+// now.
+func (s *PlaybackSigner) Verify(token, stream string, now time.Time) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrTokenInvalid
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(mac, signature(key, stream, exp)) {
+			if now.Unix() >= expires {
+				return ErrTokenExpired
+			}
+			return nil
+		}
+	}
+	return ErrTokenInvalid
+}
+
+func signature(key []byte, stream, exp string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("playback\x00" + stream + "\x00" + exp))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	newSecret = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+)
+
+func TestPlaybackTokens(t *testing.T) {
+	s, err := NewPlaybackSigner([]string{oldSecret}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, expires := s.Mint("cam", 0)
+	if d := expires.Sub(now); d < DefaultPlaybackTTL-time.Second || d > DefaultPlaybackTTL {
+		t.Fatalf("token expires in %v, want %v", d, DefaultPlaybackTTL)
+	}
+
+	for _, tc := range []struct {
+		name, token, stream string
+		now                 time.Time
+		want                error
+	}{
+		{"valid", token, "cam", now, nil},
+		{"other stream", token, "cam2", now, ErrTokenInvalid},
+		{"expired", token, "cam", expires, ErrTokenExpired},
+		{"tampered expiry", "9" + token, "cam", now, ErrTokenInvalid},
+		{"no signature", strings.Split(token, ".")[0], "cam", now, ErrTokenInvalid},
+		{"empty", "", "cam", now, ErrTokenInvalid},
+	} {
+		if err := s.Verify(tc.token, tc.stream, tc.now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Verify = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestPlaybackSecretRotation(t *testing.T) {
+	old, err := NewPlaybackSigner([]string{oldSecret}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewPlaybackSigner([]string{newSecret, oldSecret}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := old.Mint("cam", 0)
+	if err := rotated.Verify(token, "cam", time.Now()); err != nil {
+		t.Fatalf("token signed with the old secret: %v", err)
+	}
+	token, _ = rotated.Mint("cam", 0)
+	if err := old.Verify(token, "cam", time.Now()); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("token signed with the new secret passed the old signer: %v", err)
+	}
+}
+
+func TestNewPlaybackSignerRejectsBadSecrets(t *testing.T) {
+	for _, secrets := range [][]string{
+		nil,
+		{"not base64!"},
+		{base64.StdEncoding.EncodeToString([]byte("short"))},
+	} {
+		if _, err := NewPlaybackSigner(secrets, 0); err == nil {
+			t.Errorf("NewPlaybackSigner(%q) succeeded", secrets)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package bond pushes one published stream to several upstream legs at once,
+// for contribution workflows that need path diversity. Every leg gets every
+// message. A leg that fails is reconnected with backoff, and one that falls
+// behind sheds media; either way it resumes at the next keyframe, with the
+// decoder configuration replayed, while the other legs carry on untouched.
+package bond
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Leg states reported in LegStatus.
+const (
+	StateConnecting = "connecting"
+	StateUp         = "up"
+	StateDown       = "down"
+)
+
+const (
+	defaultQueue    = 256
+	defaultRetryMin = time.Second
+	defaultRetryMax = 30 * time.Second
+)
+
+// ErrAllLegsDown is returned by WriteMessage once no leg is up or connecting.
+var ErrAllLegsDown = errors.New("bond: every leg is down")
+
+// Sink takes the media of one leg connection. Close may be called while
+// WriteMessage is blocked, and must unblock it.
+type Sink interface {
+	WriteMessage(msg *rtmp.Message) error
+	Close() error
+}
+
+// Leg is one upstream of a bond.
+type Leg struct {
+	Name string                                  // Reported in LegStatus, e.g. the upstream URL
+	Open func(ctx context.Context) (Sink, error) // Connects the leg; called again after each failure
+}
+
+// Config tunes a Bond. The zero value uses the defaults.
+type Config struct {
+	Queue    int           // Messages buffered per leg before it sheds media; 0 uses 256
+	RetryMin time.Duration // First reconnect delay, doubling per failure; 0 uses 1s
+	RetryMax time.Duration // Longest reconnect delay; 0 uses 30s
+
+	// OnChange, when set, is called from the leg's goroutine each time a leg
+	// connects, fails or starts or stops shedding, with the changed leg and
+	// a snapshot of all of them.
+	OnChange func(changed LegStatus, legs []LegStatus)
+}
+
+// LegStatus reports the health of one leg.
+type LegStatus struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	SinceUnix int64  `json:"since_unix"` // When the leg entered State
+	Behind    bool   `json:"behind,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Connects  int    `json:"connects"`
+	Dropped   int64  `json:"dropped"` // Messages shed while the leg was behind
+}
+
+// Healthy reports whether the leg is up and keeping pace with the publisher.
+func (s LegStatus) Healthy() bool {
+	return s.State == StateUp && !s.Behind
+}
+
+// Bond fans one stream out to its legs. WriteMessage must be called from a
+// single goroutine.
+type Bond struct {
+	legs     []*leg
+	cfg      Config
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	closeOne sync.Once
+
+	// Decoder configuration replayed ahead of the keyframe a leg resumes
+	// at. Only WriteMessage touches these.
+	meta     *rtmp.Message
+	videoSeq *rtmp.Message
+	audioSeq *rtmp.Message
+	hasVideo bool
+}
+
+type leg struct {
+	Leg
+	ch chan *rtmp.Message
+
+	mu       sync.Mutex
+	state    string
+	since    time.Time
+	lastErr  string
+	connects int
+	dropped  int64
+	behind   bool
+	fed      int // The connection WriteMessage last resumed the leg on
+}
+
+// New starts connecting every leg. The bond runs until Close or until ctx
+// is done.
+func New(ctx context.Context, legs []Leg, cfg Config) *Bond {
+	if cfg.Queue <= 0 {
+		cfg.Queue = defaultQueue
+	}
+	if cfg.RetryMin <= 0 {
+		cfg.RetryMin = defaultRetryMin
+	}
+	if cfg.RetryMax < cfg.RetryMin {
+		cfg.RetryMax = max(defaultRetryMax, cfg.RetryMin)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	b := &Bond{cfg: cfg, cancel: cancel}
+	now := time.Now()
+	for _, l := range legs {
+		b.legs = append(b.legs, &leg{
+			Leg:   l,
+			ch:    make(chan *rtmp.Message, cfg.Queue),
+			state: StateConnecting,
+			since: now,
+		})
+	}
+	for _, l := range b.legs {
+		b.wg.Add(1)
+		go b.run(ctx, l)
+	}
+	return b
+}
+
+// WriteMessage queues an audio, video or data message on every leg that is
+// up; other messages are ignored. msg is shared by the legs and must not be
+// modified or released afterwards. It fails with ErrAllLegsDown once every
+// leg has failed and is waiting to reconnect.
+func (b *Bond) WriteMessage(msg *rtmp.Message) error {
+	switch msg.Header.TypeID {
+	case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+	default:
+		return nil
+	}
+	b.remember(msg)
+	down := 0
+	for _, l := range b.legs {
+		if !b.feed(l, msg) {
+			down++
+		}
+	}
+	if down == len(b.legs) {
+		return ErrAllLegsDown
+	}
+	return nil
+}
+
+// Status returns a snapshot of every leg, in the order given to New.
+func (b *Bond) Status() []LegStatus {
+	out := make([]LegStatus, len(b.legs))
+	for i, l := range b.legs {
+		out[i] = l.status()
+	}
+	return out
+}
+
+// Close disconnects every leg and waits for them. It is safe to call more
+// than once.
+func (b *Bond) Close() error {
+	b.closeOne.Do(func() {
+		b.cancel()
+		b.wg.Wait()
+	})
+	return nil
+}
+
+// feed queues msg on l, first replaying the decoder configuration when l
+// resumes on a new connection or after shedding. Reports false when l is
+// down.
+func (b *Bond) feed(l *leg, msg *rtmp.Message) bool {
+	l.mu.Lock()
+	if l.state != StateUp {
+		down := l.state == StateDown
+		l.mu.Unlock()
+		return !down
+	}
+	wasBehind := l.behind
+	if l.fed != l.connects || l.behind {
+		if !b.isResumePoint(msg) {
+			l.mu.Unlock()
+			return true
+		}
+		replay := b.headers(msg.Header.Timestamp)
+		if cap(l.ch)-len(l.ch) <= len(replay) {
+			l.behind = true
+			l.dropped++
+			l.mu.Unlock()
+			return true
+		}
+		for _, h := range replay {
+			l.ch <- h
+		}
+		l.fed, l.behind = l.connects, false
+	}
+	select {
+	case l.ch <- msg:
+	default:
+		l.behind = true
+		l.dropped++
+	}
+	changed := l.behind != wasBehind
+	l.mu.Unlock()
+	if changed {
+		b.notify(l)
+	}
+	return true
+}
+
+// run keeps l connected until ctx is done.
+func (b *Bond) run(ctx context.Context, l *leg) {
+	defer b.wg.Done()
+	delay := b.cfg.RetryMin
+	for {
+		sink, err := l.Open(ctx)
+		if err == nil {
+			l.connected()
+			b.notify(l)
+			delay = b.cfg.RetryMin
+			// Closing the sink is what unblocks a write stuck on a stalled
+			// connection.
+			stop := context.AfterFunc(ctx, func() { sink.Close() })
+			err = l.pump(ctx, sink)
+			if stop() {
+				sink.Close()
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		l.set(StateDown, err)
+		b.notify(l)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, b.cfg.RetryMax)
+		l.set(StateConnecting, nil)
+		b.notify(l)
+	}
+}
+
+// pump writes l's queue to sink until a write fails or ctx is done.
+func (l *leg) pump(ctx context.Context, sink Sink) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-l.ch:
+			if err := sink.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// connected marks l up on a new connection, discarding anything queued for
+// the previous one.
+func (l *leg) connected() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.ch) > 0 {
+		<-l.ch
+	}
+	l.connects++
+	l.state, l.since, l.behind, l.lastErr = StateUp, time.Now(), false, ""
+}
+
+func (l *leg) set(state string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state, l.since, l.behind = state, time.Now(), false
+	if err != nil {
+		l.lastErr = err.Error()
+	}
+}
+
+func (l *leg) status() LegStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LegStatus{
+		Name:      l.Name,
+		State:     l.state,
+		SinceUnix: l.since.Unix(),
+		Behind:    l.behind,
+		LastError: l.lastErr,
+		Connects:  l.connects,
+		Dropped:   l.dropped,
+	}
+}
+
+func (b *Bond) notify(l *leg) {
+	if b.cfg.OnChange != nil {
+		b.cfg.OnChange(l.status(), b.Status())
+	}
+}
+
+func (b *Bond) remember(msg *rtmp.Message) {
+	switch {
+	case msg.Header.TypeID == rtmp.TypeAMF0Data:
+		b.meta = msg
+	case msg.IsVideoSequenceHeader():
+		b.videoSeq = msg
+	case msg.IsAACSequenceHeader():
+		b.audioSeq = msg
+	}
+	if msg.Header.TypeID == rtmp.TypeVideo {
+		b.hasVideo = true
+	}
+}
+
+// isResumePoint reports whether a leg can start taking media at msg: a video
+// keyframe, or any audio frame for audio-only streams.
+func (b *Bond) isResumePoint(msg *rtmp.Message) bool {
+	if b.hasVideo {
+		return msg.IsVideoKeyframe() && !msg.IsVideoSequenceHeader()
+	}
+	return msg.Header.TypeID == rtmp.TypeAudio && !msg.IsAACSequenceHeader()
+}
+
+// headers returns the cached decoder configuration restamped to ts.
+func (b *Bond) headers(ts uint32) []*rtmp.Message {
+	var out []*rtmp.Message
+	for _, cached := range []*rtmp.Message{b.meta, b.videoSeq, b.audioSeq} {
+		if cached == nil {
+			continue
+		}
+		replay := *cached
+		replay.Header.Timestamp = ts
+		out = append(out, &replay)
+	}
+	return out
+}
@@ -0,0 +1,197 @@
+package bond
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+type chanSink struct {
+	got       chan *rtmp.Message
+	failAfter int // Writes that succeed before every later one fails; 0 never fails
+	n         int
+}
+
+func newChanSink(failAfter int) *chanSink {
+	return &chanSink{got: make(chan *rtmp.Message, 16), failAfter: failAfter}
+}
+
+func (s *chanSink) WriteMessage(msg *rtmp.Message) error {
+	s.n++
+	if s.failAfter > 0 && s.n > s.failAfter {
+		return errors.New("broken pipe")
+	}
+	s.got <- msg
+	return nil
+}
+
+func (s *chanSink) Close() error { return nil }
+
+// sinks hands out the given sinks to successive Opens of a leg.
+func sinks(list ...*chanSink) func(context.Context) (Sink, error) {
+	var mu sync.Mutex
+	return func(context.Context) (Sink, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(list) == 0 {
+			return nil, errors.New("no more sinks")
+		}
+		s := list[0]
+		list = list[1:]
+		return s, nil
+	}
+}
+
+func video(ts uint32, key bool) *rtmp.Message {
+	frame := byte(rtmp.FrameInterframe << 4)
+	if key {
+		frame = rtmp.FrameKeyframe << 4
+	}
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+		Payload: []byte{frame | rtmp.VideoAVC, rtmp.AVCPacketNALU, 0, 0, 0},
+	}
+}
+
+func videoSeq() *rtmp.Message {
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo},
+		Payload: []byte{rtmp.FrameKeyframe<<4 | rtmp.VideoAVC, rtmp.AVCPacketSequenceHeader, 0, 0, 0},
+	}
+}
+
+// waitFor polls b until every leg satisfies ok.
+func waitFor(t *testing.T, b *Bond, ok func(LegStatus) bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		all := true
+		for _, st := range b.Status() {
+			all = all && ok(st)
+		}
+		if all {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("legs never reached the wanted state: %+v", b.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, s *chanSink, want ...*rtmp.Message) {
+	t.Helper()
+	for i, w := range want {
+		select {
+		case got := <-s.got:
+			if got.Header.Timestamp != w.Header.Timestamp || got.IsVideoKeyframe() != w.IsVideoKeyframe() ||
+				got.IsVideoSequenceHeader() != w.IsVideoSequenceHeader() {
+				t.Fatalf("message %d = %+v, want %+v", i, got.Header, w.Header)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d never arrived", i)
+		}
+	}
+	select {
+	case got := <-s.got:
+		t.Fatalf("unexpected message %+v", got.Header)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestBondFeedsEveryLeg(t *testing.T) {
+	a, b := newChanSink(0), newChanSink(0)
+	bond := New(context.Background(), []Leg{
+		{Name: "a", Open: sinks(a)},
+		{Name: "b", Open: sinks(b)},
+	}, Config{})
+	defer bond.Close()
+	waitFor(t, bond, LegStatus.Healthy)
+
+	msgs := []*rtmp.Message{videoSeq(), video(0, true), video(40, false)}
+	for _, m := range msgs {
+		if err := bond.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(t, a, msgs...)
+	receive(t, b, msgs...)
+}
+
+func TestBondResumesFailedLegAtKeyframe(t *testing.T) {
+	steady, first, second := newChanSink(0), newChanSink(2), newChanSink(0)
+	var mu sync.Mutex
+	var changes []LegStatus
+	bond := New(context.Background(), []Leg{
+		{Name: "steady", Open: sinks(steady)},
+		{Name: "flaky", Open: sinks(first, second)},
+	}, Config{RetryMin: time.Millisecond, OnChange: func(changed LegStatus, _ []LegStatus) {
+		mu.Lock()
+		changes = append(changes, changed)
+		mu.Unlock()
+	}})
+	defer bond.Close()
+	waitFor(t, bond, LegStatus.Healthy)
+
+	seq, key, inter := videoSeq(), video(0, true), video(40, false)
+	for _, m := range []*rtmp.Message{seq, key, inter} {
+		if err := bond.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(t, first, seq, key)
+	waitFor(t, bond, func(st LegStatus) bool { return st.Healthy() && (st.Name == "steady" || st.Connects == 2) })
+
+	// The reconnected leg skips to the next keyframe, preceded by the
+	// sequence header restamped to it.
+	inter2, key2 := video(80, false), video(120, true)
+	for _, m := range []*rtmp.Message{inter2, key2} {
+		if err := bond.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(t, steady, seq, key, inter, inter2, key2)
+	wantSeq := *seq
+	wantSeq.Header.Timestamp = key2.Header.Timestamp
+	receive(t, second, &wantSeq, key2)
+
+	st := bond.Status()[1]
+	if st.LastError != "" {
+		t.Fatalf("last_error = %q after reconnecting, want it cleared", st.LastError)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var states []string
+	for _, c := range changes {
+		if c.Name == "flaky" {
+			states = append(states, c.State)
+		}
+	}
+	want := []string{StateUp, StateDown, StateConnecting, StateUp}
+	if len(states) != len(want) {
+		t.Fatalf("flaky leg went through %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("flaky leg went through %v, want %v", states, want)
+		}
+	}
+}
+
+func TestBondFailsOnceEveryLegIsDown(t *testing.T) {
+	never := func(context.Context) (Sink, error) { return nil, errors.New("connection refused") }
+	bond := New(context.Background(), []Leg{{Name: "a", Open: never}, {Name: "b", Open: never}}, Config{RetryMin: time.Hour})
+	defer bond.Close()
+	waitFor(t, bond, func(st LegStatus) bool { return st.State == StateDown })
+
+	if err := bond.WriteMessage(video(0, true)); !errors.Is(err, ErrAllLegsDown) {
+		t.Fatalf("WriteMessage = %v, want ErrAllLegsDown", err)
+	}
+	if got := bond.Status()[0].LastError; got != "connection refused" {
+		t.Fatalf("last_error = %q", got)
+	}
+}
@@ -16,6 +16,17 @@ const (
 	HalfOpen            // Testing if service recovered
 )
 
+// String returns "closed", "open" or "half-open".
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
 // Breaker implements a circuit breaker pattern
 type Breaker struct {
 	mu             sync.RWMutex
@@ -26,6 +37,7 @@ type Breaker struct {
 	maxFailures    int32
 	resetTimeout   time.Duration
 	successThresh  int32 // Successes needed in half-open to close
+	onChange       func(from, to State)
 }
 
 // New creates a new circuit breaker
@@ -41,6 +53,23 @@ func New(maxFailures int32, resetTimeout time.Duration, successThresh int32) *Br
 	}
 }
 
+// OnStateChange registers fn to be called on every state transition. fn runs
+// with the breaker locked, so it must not call back into the breaker.
+func (b *Breaker) OnStateChange(fn func(from, to State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = fn
+}
+
+// setState moves the breaker to state; b.mu must be held.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	if from != state && b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
+
 // Call executes a function with circuit breaker protection
 func (b *Breaker) Call(fn func() error) error {
 	// Phase 1: Check state and prepare (under lock)
@@ -48,7 +77,7 @@ func (b *Breaker) Call(fn func() error) error {
 	if b.state == Open {
 		if time.Since(b.lastFailTime) > b.resetTimeout {
 			// Try to recover
-			b.state = HalfOpen
+			b.setState(HalfOpen)
 			atomic.StoreInt32(&b.successCount, 0)
 			atomic.StoreInt32(&b.failures, 0)
 		} else {
@@ -87,12 +116,12 @@ func (b *Breaker) recordFailure(err error) error {
 
 	if b.state == HalfOpen {
 		// Failed while testing, go back to open
-		b.state = Open
+		b.setState(Open)
 		return fmt.Errorf("circuit breaker open after failed recovery attempt: %w", err)
 	}
 
 	if atomic.LoadInt32(&b.failures) >= b.maxFailures {
-		b.state = Open
+		b.setState(Open)
 		return fmt.Errorf("circuit breaker open after %d failures: %w", b.maxFailures, err)
 	}
 
@@ -103,7 +132,7 @@ func (b *Breaker) recordSuccess() error {
 	if b.state == HalfOpen {
 		count := atomic.AddInt32(&b.successCount, 1)
 		if count >= b.successThresh {
-			b.state = Closed
+			b.setState(Closed)
 			atomic.StoreInt32(&b.failures, 0)
 			atomic.StoreInt32(&b.successCount, 0)
 		}
@@ -126,7 +155,7 @@ func (b *Breaker) State() State {
 func (b *Breaker) Reset() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.state = Closed
+	b.setState(Closed)
 	atomic.StoreInt32(&b.failures, 0)
 	atomic.StoreInt32(&b.successCount, 0)
 }
@@ -136,16 +165,8 @@ func (b *Breaker) Stats() map[string]interface{} {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	state := "closed"
-	switch b.state {
-	case Open:
-		state = "open"
-	case HalfOpen:
-		state = "half-open"
-	}
-
 	return map[string]interface{}{
-		"state":      state,
+		"state":      b.state.String(),
 		"failures":   atomic.LoadInt32(&b.failures),
 		"successes":  atomic.LoadInt32(&b.successCount),
 		"last_fail":  b.lastFailTime.Unix(),
@@ -165,3 +165,21 @@ func TestBreakerResetFailureCounter(t *testing.T) {
 		t.Errorf("expected failures 0 after success in Closed, got %v", stats["failures"])
 	}
 }
+
+func TestBreakerOnStateChange(t *testing.T) {
+	b := New(1, time.Millisecond, 1)
+	var transitions []string
+	b.OnStateChange(func(from, to State) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	})
+
+	b.Call(func() error { return fmt.Errorf("fail") })
+	time.Sleep(5 * time.Millisecond)
+	b.Call(func() error { return nil })
+	b.Reset()
+
+	want := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+}
@@ -0,0 +1,260 @@
+// Package cluster answers "which relay has this stream?" for a group of relays
+// sharing ingest traffic. Each relay knows its own sessions and finds the rest
+// in a shared Redis registry or by asking its peers, so L7 balancers and
+// playback layers can send viewers to the relay that owns a stream.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+const (
+	// DefaultCacheTTL bounds how stale a cached answer can be.
+	DefaultCacheTTL = 5 * time.Second
+
+	peerTimeout = 2 * time.Second
+)
+
+// ErrPeersUnreachable means no relay reported the stream but at least one
+// peer could not be asked, so it may still be live somewhere.
+var ErrPeersUnreachable = errors.New("cluster: could not reach every peer")
+
+// Owner is the relay currently publishing a stream.
+type Owner struct {
+	Stream     string `json:"stream"`
+	InstanceID string `json:"instance_id"`
+	URL        string `json:"url,omitempty"` // The owner's advertise_url
+	Local      bool   `json:"local"`         // True when the answering relay is the owner
+}
+
+type cacheEntry struct {
+	owner   Owner
+	found   bool
+	expires time.Time
+}
+
+// Directory resolves stream owners. Without a registry or peers it only
+// knows this relay.
+type Directory struct {
+	instanceID    string
+	advertise     string
+	advertiseRTMP string
+	peers         []string
+	ttl           time.Duration
+	local         func(stream string) bool
+	client        *http.Client
+	registry      *registry // nil asks peers instead
+	playRouting   string
+
+	mu         sync.Mutex
+	cache      map[string]cacheEntry
+	registered int
+	nodes      []Node // Other relays in the registry, as of nodesAt
+	nodesAt    time.Time
+}
+
+// Stats is a point-in-time view of the directory.
+type Stats struct {
+	InstanceID   string   `json:"instance_id"`
+	AdvertiseURL string   `json:"advertise_url,omitempty"`
+	Peers        []string `json:"peers"`
+	CachedRoutes int      `json:"cached_routes"`
+	Registry     string   `json:"registry,omitempty"`           // Redis address of the shared registry
+	Registered   int      `json:"registered_streams,omitempty"` // Local streams held in the registry
+	PlayRouting  string   `json:"play_routing,omitempty"`
+}
+
+// New creates a directory for this relay. local reports whether a stream is
+// being published here. cfg must have passed validation; the registry only
+// comes into use once Run is called.
+func New(cfg config.ClusterConfig, local func(stream string) bool) *Directory {
+	id := cfg.InstanceID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	ttl := time.Duration(cfg.RouteCacheTTL)
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	peers := make([]string, len(cfg.Peers))
+	for i, p := range cfg.Peers {
+		peers[i] = strings.TrimRight(p, "/")
+	}
+	d := &Directory{
+		instanceID:    id,
+		advertise:     cfg.AdvertiseURL,
+		advertiseRTMP: cfg.AdvertiseRTMP,
+		peers:         peers,
+		ttl:           ttl,
+		local:         local,
+		client:        &http.Client{Timeout: peerTimeout},
+		playRouting:   cfg.PlayRouting,
+		cache:         make(map[string]cacheEntry),
+	}
+	if cfg.Registry.Redis != "" {
+		// Validation has checked the URL, so this cannot fail.
+		d.registry, _ = newRegistry(cfg.Registry)
+	}
+	return d
+}
+
+// PlayRouting is how playback of a stream live on another relay is handled:
+// config.PlayRoutingRedirect, config.PlayRoutingProxy, or empty to serve
+// local streams only.
+func (d *Directory) PlayRouting() string {
+	return d.playRouting
+}
+
+// CacheTTL is how long callers may cache an answer.
+func (d *Directory) CacheTTL() time.Duration {
+	return d.ttl
+}
+
+// Lookup finds the relay publishing stream. With localOnly, neither the
+// registry nor peers are asked; relays use that mode when they query each
+// other, so lookups never recurse. found is false when no relay has the
+// stream.
+func (d *Directory) Lookup(ctx context.Context, stream string, localOnly bool) (owner Owner, found bool, err error) {
+	if d.local != nil && d.local(stream) {
+		return d.self(stream), true, nil
+	}
+	if localOnly || (d.registry == nil && len(d.peers) == 0) {
+		return Owner{}, false, nil
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	entry, cached := d.cache[stream]
+	d.mu.Unlock()
+	if cached && now.Before(entry.expires) {
+		return entry.owner, entry.found, nil
+	}
+
+	owner, found, err = d.resolve(ctx, stream)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	d.mu.Lock()
+	d.pruneLocked(now)
+	d.cache[stream] = cacheEntry{owner: owner, found: found, expires: now.Add(d.ttl)}
+	d.mu.Unlock()
+	return owner, found, nil
+}
+
+func (d *Directory) self(stream string) Owner {
+	return Owner{Stream: stream, InstanceID: d.instanceID, URL: d.advertise, Local: true}
+}
+
+// resolve asks the registry, falling back to the peers when it cannot be
+// reached, or asks the peers when there is no registry.
+func (d *Directory) resolve(ctx context.Context, stream string) (Owner, bool, error) {
+	if d.registry != nil {
+		owner, found, err := d.registry.lookup(ctx, stream)
+		if err == nil || len(d.peers) == 0 {
+			// An entry naming this relay outlived a stream that is no
+			// longer published here.
+			if found && owner.InstanceID == d.instanceID {
+				return Owner{}, false, nil
+			}
+			return owner, found, err
+		}
+	}
+	return d.askPeers(ctx, stream)
+}
+
+// askPeers queries every peer at once and returns the first owner reported.
+func (d *Directory) askPeers(ctx context.Context, stream string) (Owner, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		owner Owner
+		found bool
+		err   error
+	}
+	answers := make(chan answer, len(d.peers))
+	for _, peer := range d.peers {
+		go func() {
+			owner, found, err := d.askPeer(ctx, peer, stream)
+			answers <- answer{owner, found, err}
+		}()
+	}
+	var failed error
+	for range d.peers {
+		a := <-answers
+		switch {
+		case a.err != nil:
+			failed = a.err
+		case a.found:
+			a.owner.Local = false
+			return a.owner, true, nil
+		}
+	}
+	if failed != nil {
+		return Owner{}, false, fmt.Errorf("%w: %v", ErrPeersUnreachable, failed)
+	}
+	return Owner{}, false, nil
+}
+
+func (d *Directory) askPeer(ctx context.Context, peer, stream string) (Owner, bool, error) {
+	u := peer + "/api/route?local=1&stream=" + url.QueryEscape(stream)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var owner Owner
+		if err := json.NewDecoder(resp.Body).Decode(&owner); err != nil {
+			return Owner{}, false, fmt.Errorf("peer %s: decode route: %w", peer, err)
+		}
+		return owner, true, nil
+	case http.StatusNotFound:
+		return Owner{}, false, nil
+	}
+	return Owner{}, false, fmt.Errorf("peer %s returned %s", peer, resp.Status)
+}
+
+// pruneLocked drops expired entries so the cache cannot grow with every
+// stream name ever asked about.
+func (d *Directory) pruneLocked(now time.Time) {
+	for stream, entry := range d.cache {
+		if !now.Before(entry.expires) {
+			delete(d.cache, stream)
+		}
+	}
+}
+
+// Stats returns the directory configuration and cache size.
+func (d *Directory) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := Stats{
+		InstanceID:   d.instanceID,
+		AdvertiseURL: d.advertise,
+		Peers:        append([]string{}, d.peers...),
+		CachedRoutes: len(d.cache),
+		Registered:   d.registered,
+		PlayRouting:  d.playRouting,
+	}
+	if d.registry != nil {
+		st.Registry = d.registry.client.addr
+	}
+	return st
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// peer serves the local-only half of /api/route for a relay publishing live.
+func peer(t *testing.T, id string, live map[string]bool, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	dir := New(config.ClusterConfig{InstanceID: id, AdvertiseURL: "http://" + id + ":8080"}, func(s string) bool { return live[s] })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Query().Get("local") != "1" {
+			t.Errorf("peer queried without local=1: %s", r.URL)
+		}
+		owner, found, _ := dir.Lookup(r.Context(), r.URL.Query().Get("stream"), true)
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(owner)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLookupLocalAndPeers(t *testing.T) {
+	var hitsB, hitsC atomic.Int32
+	b := peer(t, "relay-b", map[string]bool{"match": true}, &hitsB)
+	c := peer(t, "relay-c", nil, &hitsC)
+	dir := New(config.ClusterConfig{
+		InstanceID:   "relay-a",
+		AdvertiseURL: "http://relay-a:8080",
+		Peers:        []string{b.URL + "/", c.URL},
+	}, func(s string) bool { return s == "local" })
+	ctx := context.Background()
+
+	owner, found, err := dir.Lookup(ctx, "local", false)
+	if err != nil || !found || !owner.Local || owner.InstanceID != "relay-a" {
+		t.Fatalf("local lookup = %+v, %v, %v", owner, found, err)
+	}
+	if hitsB.Load()+hitsC.Load() != 0 {
+		t.Fatal("a local stream should not query peers")
+	}
+
+	owner, found, err = dir.Lookup(ctx, "match", false)
+	if err != nil || !found {
+		t.Fatalf("peer lookup = %+v, %v, %v", owner, found, err)
+	}
+	if owner.Local || owner.InstanceID != "relay-b" || owner.URL != "http://relay-b:8080" {
+		t.Fatalf("peer owner = %+v, want relay-b", owner)
+	}
+
+	if _, found, err := dir.Lookup(ctx, "missing", false); err != nil || found {
+		t.Fatalf("missing lookup found=%v err=%v", found, err)
+	}
+	before := hitsB.Load()
+	if _, found, _ := dir.Lookup(ctx, "missing", false); found {
+		t.Fatal("cached miss turned into a hit")
+	}
+	if hitsB.Load() != before {
+		t.Fatal("a cached miss should not query peers again")
+	}
+
+	if _, found, _ := dir.Lookup(ctx, "match", true); found {
+		t.Fatal("local-only lookup must not report a peer's stream")
+	}
+}
+
+func TestLookupUnreachablePeer(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	dir := New(config.ClusterConfig{InstanceID: "relay-a", Peers: []string{down.URL}}, func(string) bool { return false })
+	_, found, err := dir.Lookup(context.Background(), "any", false)
+	if found || !errors.Is(err, ErrPeersUnreachable) {
+		t.Fatalf("Lookup = found %v, err %v; want ErrPeersUnreachable", found, err)
+	}
+	if dir.Stats().CachedRoutes != 0 {
+		t.Fatal("failed lookups must not be cached")
+	}
+}
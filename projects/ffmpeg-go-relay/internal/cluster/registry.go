@@ -0,0 +1,289 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+const (
+	// DefaultRegistryTTL is how long a registry entry outlives a relay that
+	// stops refreshing it, e.g. because it crashed.
+	DefaultRegistryTTL = 15 * time.Second
+
+	defaultKeyPrefix = "rtmp-relay:stream:"
+	defaultNodesKey  = "rtmp-relay:nodes"
+
+	// syncInterval is how often local streams are compared with the
+	// registry; new streams are registered within one interval.
+	syncInterval = time.Second
+)
+
+// ErrRegistryUnavailable means the shared registry could not be asked.
+var ErrRegistryUnavailable = errors.New("cluster: registry unavailable")
+
+// releaseScript deletes an entry only while it still names this relay, so a
+// relay finishing a stream does not remove the entry of the relay that took
+// the stream over.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// registry is the shared store of stream owners, one expiring key per live
+// stream holding the owner as JSON, and of relay loads, one field per relay
+// of a hash.
+type registry struct {
+	client   *redisClient
+	prefix   string
+	nodesKey string
+	ttl      time.Duration
+}
+
+// Node is a relay as it last reported itself to the registry.
+type Node struct {
+	InstanceID  string `json:"instance_id"`
+	URL         string `json:"url,omitempty"`      // advertise_url
+	RTMPURL     string `json:"rtmp_url,omitempty"` // advertise_rtmp
+	Sessions    int    `json:"sessions"`
+	Viewers     int    `json:"viewers"`
+	Draining    bool   `json:"draining,omitempty"`
+	UpdatedUnix int64  `json:"updated_unix"`
+}
+
+// LocalState is what Run keeps in the registry about this relay.
+type LocalState struct {
+	Streams  []string // Streams being published here
+	Sessions int
+	Viewers  int // Players of any stream here, over every protocol
+	Draining bool
+}
+
+func newRegistry(cfg config.ClusterRegistryConfig) (*registry, error) {
+	client, err := newRedisClient(cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+	r := &registry{client: client, prefix: cfg.KeyPrefix, nodesKey: cfg.NodesKey, ttl: time.Duration(cfg.TTL)}
+	if r.prefix == "" {
+		r.prefix = defaultKeyPrefix
+	}
+	if r.nodesKey == "" {
+		r.nodesKey = defaultNodesKey
+	}
+	if r.ttl <= 0 {
+		r.ttl = DefaultRegistryTTL
+	}
+	return r, nil
+}
+
+func (r *registry) key(stream string) string {
+	return r.prefix + stream
+}
+
+func (r *registry) value(owner Owner) string {
+	owner.Local = false
+	b, _ := json.Marshal(owner)
+	return string(b)
+}
+
+// register claims stream for owner, or extends the claim, for one TTL.
+func (r *registry) register(ctx context.Context, owner Owner) error {
+	_, err := r.client.do(ctx, "SET", r.key(owner.Stream), r.value(owner), "PX", strconv.FormatInt(r.ttl.Milliseconds(), 10))
+	return err
+}
+
+// release drops owner's claim on its stream.
+func (r *registry) release(ctx context.Context, owner Owner) error {
+	_, err := r.client.do(ctx, "EVAL", releaseScript, "1", r.key(owner.Stream), r.value(owner))
+	return err
+}
+
+// lookup returns the relay that claims stream.
+func (r *registry) lookup(ctx context.Context, stream string) (Owner, bool, error) {
+	reply, err := r.client.do(ctx, "GET", r.key(stream))
+	if errors.Is(err, errNil) {
+		return Owner{}, false, nil
+	}
+	if err != nil {
+		return Owner{}, false, fmt.Errorf("%w: %v", ErrRegistryUnavailable, err)
+	}
+	raw, _ := reply.(string)
+	var owner Owner
+	if err := json.Unmarshal([]byte(raw), &owner); err != nil {
+		return Owner{}, false, fmt.Errorf("%w: entry for %q: %v", ErrRegistryUnavailable, stream, err)
+	}
+	return owner, true, nil
+}
+
+// announce records node's load. Entries carry their time; readers skip
+// those older than the TTL, since hash fields do not expire.
+func (r *registry) announce(ctx context.Context, node Node) error {
+	b, _ := json.Marshal(node)
+	_, err := r.client.do(ctx, "HSET", r.nodesKey, node.InstanceID, string(b))
+	return err
+}
+
+func (r *registry) withdraw(ctx context.Context, instanceID string) error {
+	_, err := r.client.do(ctx, "HDEL", r.nodesKey, instanceID)
+	return err
+}
+
+// nodes returns the relays that reported within the TTL.
+func (r *registry) nodes(ctx context.Context) ([]Node, error) {
+	reply, err := r.client.do(ctx, "HGETALL", r.nodesKey)
+	if errors.Is(err, errNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRegistryUnavailable, err)
+	}
+	fields, _ := reply.([]any)
+	oldest := time.Now().Add(-r.ttl).Unix()
+	var nodes []Node
+	for i := 1; i < len(fields); i += 2 {
+		raw, _ := fields[i].(string)
+		var n Node
+		if err := json.Unmarshal([]byte(raw), &n); err != nil || n.UpdatedUnix < oldest {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// Run keeps this relay's live streams and load in the registry until ctx
+// ends, then removes them. Without a registry it returns at once.
+func (d *Directory) Run(ctx context.Context, state func() LocalState, log *logger.Logger) {
+	if d.registry == nil {
+		return
+	}
+	defer d.registry.client.Close()
+	refresh := d.registry.ttl / 3
+	registered := make(map[string]time.Time) // Stream -> last refreshed
+	var announced Node
+	failing := false
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		st := state()
+		err := d.sync(ctx, st.Streams, registered, refresh)
+		if err == nil {
+			err = d.announce(ctx, st, &announced, refresh)
+		}
+		switch {
+		case err != nil && !failing && ctx.Err() == nil:
+			log.Warn("cluster registry update failed", "err", err)
+			failing = true
+		case err == nil && failing:
+			log.Info("cluster registry reachable again")
+			failing = false
+		}
+
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			for stream := range registered {
+				if err := d.registry.release(releaseCtx, d.self(stream)); err != nil {
+					log.Warn("failed to release stream in cluster registry", "stream", stream, "err", err)
+					break
+				}
+			}
+			if err := d.registry.withdraw(releaseCtx, d.instanceID); err != nil {
+				log.Warn("failed to withdraw from cluster registry", "err", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync registers new streams, refreshes those due and releases those no
+// longer live. It stops at the first failure; the next sync retries.
+func (d *Directory) sync(ctx context.Context, live []string, registered map[string]time.Time, refresh time.Duration) error {
+	now := time.Now()
+	current := make(map[string]bool, len(live))
+	for _, stream := range live {
+		current[stream] = true
+		if last, ok := registered[stream]; ok && now.Sub(last) < refresh {
+			continue
+		}
+		if err := d.registry.register(ctx, d.self(stream)); err != nil {
+			return err
+		}
+		registered[stream] = now
+	}
+	for stream := range registered {
+		if current[stream] {
+			continue
+		}
+		if err := d.registry.release(ctx, d.self(stream)); err != nil {
+			return err
+		}
+		delete(registered, stream)
+	}
+	d.mu.Lock()
+	d.registered = len(registered)
+	d.mu.Unlock()
+	return nil
+}
+
+// announce reports this relay's load when it changed or the last report is
+// due for a refresh.
+func (d *Directory) announce(ctx context.Context, st LocalState, last *Node, refresh time.Duration) error {
+	now := time.Now()
+	node := Node{
+		InstanceID:  d.instanceID,
+		URL:         d.advertise,
+		RTMPURL:     d.advertiseRTMP,
+		Sessions:    st.Sessions,
+		Viewers:     st.Viewers,
+		Draining:    st.Draining,
+		UpdatedUnix: now.Unix(),
+	}
+	if last.UpdatedUnix != 0 && node.Sessions == last.Sessions && node.Viewers == last.Viewers && node.Draining == last.Draining &&
+		now.Sub(time.Unix(last.UpdatedUnix, 0)) < refresh {
+		return nil
+	}
+	if err := d.registry.announce(ctx, node); err != nil {
+		return err
+	}
+	*last = node
+	return nil
+}
+
+// Nodes returns the other relays that reported their load to the registry
+// recently, or nil without a directory or registry. Answers are reused for a second.
+func (d *Directory) Nodes(ctx context.Context) ([]Node, error) {
+	if d == nil || d.registry == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	d.mu.Lock()
+	if now.Sub(d.nodesAt) < syncInterval {
+		nodes := d.nodes
+		d.mu.Unlock()
+		return nodes, nil
+	}
+	d.mu.Unlock()
+
+	all, err := d.registry.nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(all))
+	for _, n := range all {
+		if n.InstanceID != d.instanceID {
+			nodes = append(nodes, n)
+		}
+	}
+	d.mu.Lock()
+	d.nodes, d.nodesAt = nodes, now
+	d.mu.Unlock()
+	return nodes, nil
+}
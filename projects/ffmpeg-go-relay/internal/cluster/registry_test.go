@@ -0,0 +1,272 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// fakeRedis serves the commands the registry uses from a map. Expiry is
+// ignored.
+type fakeRedis struct {
+	password string
+
+	mu     sync.Mutex
+	keys   map[string]string
+	hashes map[string]map[string]string
+	dbs    []string
+}
+
+func startFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{password: password, keys: make(map[string]string), hashes: make(map[string]map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		var out string
+		f.mu.Lock()
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == f.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			f.dbs = append(f.dbs, args[1])
+			out = "+OK\r\n"
+		case cmd == "SET":
+			f.keys[args[1]] = args[2]
+			out = "+OK\r\n"
+		case cmd == "GET":
+			v, ok := f.keys[args[1]]
+			out = "$-1\r\n"
+			if ok {
+				out = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			}
+		case cmd == "HSET":
+			if f.hashes[args[1]] == nil {
+				f.hashes[args[1]] = make(map[string]string)
+			}
+			f.hashes[args[1]][args[2]] = args[3]
+			out = ":1\r\n"
+		case cmd == "HDEL":
+			delete(f.hashes[args[1]], args[2])
+			out = ":1\r\n"
+		case cmd == "HGETALL":
+			h := f.hashes[args[1]]
+			out = fmt.Sprintf("*%d\r\n", 2*len(h))
+			for k, v := range h {
+				out += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+			}
+		case cmd == "EVAL": // Only the release script
+			out = ":0\r\n"
+			if f.keys[args[3]] == args[4] {
+				delete(f.keys, args[3])
+				out = ":1\r\n"
+			}
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		if _, err := io.WriteString(conn, out); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.keys[key]
+	return v, ok
+}
+
+// liveSet is a mutable set of locally published streams.
+type liveSet struct {
+	mu      sync.Mutex
+	streams []string
+}
+
+func (l *liveSet) set(streams ...string) {
+	l.mu.Lock()
+	l.streams = streams
+	l.mu.Unlock()
+}
+
+func (l *liveSet) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.streams...)
+}
+
+func (l *liveSet) state() LocalState {
+	streams := l.list()
+	return LocalState{Streams: streams, Sessions: len(streams)}
+}
+
+func (l *liveSet) has(stream string) bool {
+	for _, s := range l.list() {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+func registryDirectory(id, addr string, live *liveSet) *Directory {
+	return New(config.ClusterConfig{
+		InstanceID:    id,
+		AdvertiseURL:  "http://" + id + ":8080",
+		AdvertiseRTMP: "rtmp://" + id + ":1935",
+		RouteCacheTTL: config.Duration(time.Nanosecond),
+		Registry:      config.ClusterRegistryConfig{Redis: "redis://:secret@" + addr + "/3"},
+	}, live.has)
+}
+
+func waitLookup(t *testing.T, d *Directory, stream string, wantFound bool) Owner {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		owner, found, err := d.Lookup(context.Background(), stream, false)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", stream, err)
+		}
+		if found == wantFound {
+			return owner
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lookup(%q) found = %v, want %v", stream, found, wantFound)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistrySharesStreamOwners(t *testing.T) {
+	redis, addr := startFakeRedis(t, "secret")
+	liveA, liveB := &liveSet{}, &liveSet{}
+	a, b := registryDirectory("relay-a", addr, liveA), registryDirectory("relay-b", addr, liveB)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, liveA.state, logger.NewWithWriter(io.Discard))
+		close(done)
+	}()
+
+	liveA.set("cam1")
+	owner := waitLookup(t, b, "cam1", true)
+	if owner.InstanceID != "relay-a" || owner.URL != "http://relay-a:8080" || owner.Local {
+		t.Fatalf("owner = %+v, want relay-a", owner)
+	}
+	if st := a.Stats(); st.Registered != 1 || st.Registry != addr {
+		t.Fatalf("stats = %+v", st)
+	}
+	// The load report follows the stream registration.
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		nodes, err := b.Nodes(context.Background())
+		if err == nil && len(nodes) == 1 && nodes[0].InstanceID == "relay-a" && nodes[0].RTMPURL == "rtmp://relay-a:1935" && nodes[0].Sessions == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Nodes = %+v, %v; want relay-a with one session", nodes, err)
+		}
+	}
+	if nodes, err := a.Nodes(context.Background()); err != nil || len(nodes) != 0 {
+		t.Fatalf("relay-a's Nodes = %+v, %v; want itself left out", nodes, err)
+	}
+	redis.mu.Lock()
+	dbs := append([]string{}, redis.dbs...)
+	redis.mu.Unlock()
+	if len(dbs) == 0 || dbs[0] != "3" {
+		t.Fatalf("selected databases %v, want 3", dbs)
+	}
+
+	liveA.set()
+	waitLookup(t, b, "cam1", false)
+
+	// On shutdown a relay releases only the entries that still name it.
+	liveA.set("cam2")
+	waitLookup(t, b, "cam2", true)
+	liveB.set("cam2")
+	if err := b.registry.register(context.Background(), b.self("cam2")); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+	v, ok := redis.get(defaultKeyPrefix + "cam2")
+	if !ok || !strings.Contains(v, "relay-b") {
+		t.Fatalf("entry after relay-a stopped = %q, %v; want relay-b's claim kept", v, ok)
+	}
+	redis.mu.Lock()
+	_, announced := redis.hashes[defaultNodesKey]["relay-a"]
+	redis.mu.Unlock()
+	if announced {
+		t.Fatal("relay-a still listed in the nodes hash after stopping")
+	}
+}
+
+func TestRegistryIgnoresOwnStaleEntry(t *testing.T) {
+	_, addr := startFakeRedis(t, "secret")
+	a := registryDirectory("relay-a", addr, &liveSet{})
+	if err := a.registry.register(context.Background(), a.self("gone")); err != nil {
+		t.Fatal(err)
+	}
+	if owner, found, err := a.Lookup(context.Background(), "gone", false); err != nil || found {
+		t.Fatalf("Lookup = %+v, %v, %v; want this relay's stale entry ignored", owner, found, err)
+	}
+}
+
+func TestRegistryUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	d := registryDirectory("relay-a", addr, &liveSet{})
+	if _, _, err := d.Lookup(context.Background(), "cam1", false); err == nil {
+		t.Fatal("expected an unreachable registry to fail the lookup")
+	}
+}
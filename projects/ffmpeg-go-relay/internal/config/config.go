@@ -1,10 +1,16 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +25,24 @@ type SecurityConfig struct {
 	TLSEnabled  bool     `json:"tls_enabled"`
 	TLSCert     string   `json:"tls_cert"`
 	TLSKey      string   `json:"tls_key"`
+
+	OCSPStapling      bool     `json:"ocsp_stapling,omitempty"`       // Fetch and staple OCSP responses for the TLS listeners
+	OCSPRefresh       Duration `json:"ocsp_refresh,omitempty"`        // Longest wait between OCSP fetches; 0 uses 1h
+	CertExpiryWarning Duration `json:"cert_expiry_warning,omitempty"` // Warn when a chain certificate expires within this; 0 uses 30 days
+	ALPNMux           bool     `json:"alpn_mux,omitempty"`            // Also serve HTTPS on listen_addr to clients that negotiate http/1.1
+
+	PlaybackTokens PlaybackTokenConfig `json:"playback_tokens,omitempty"`
+}
+
+// PlaybackTokenConfig requires HTTP playback (DVR, HLS, DASH and
+// thumbnails) to carry a token signed for the stream, minted at POST
+// /admin/playback-tokens. Secrets are base64 of at least 16 bytes: the first
+// signs and all of them verify, so a new one can be put first while the old
+// one's tokens run out.
+type PlaybackTokenConfig struct {
+	Enabled bool     `json:"enabled"`
+	Secrets []string `json:"secrets,omitempty"`
+	TTL     Duration `json:"ttl,omitempty"` // Lifetime of tokens minted without one; 0 = 1h
 }
 
 // RateLimitConfig defines rate limiting settings.
@@ -34,6 +58,31 @@ type ConnectionLimitConfig struct {
 	MaxPerIP int64 `json:"max_per_ip"`
 }
 
+// PublishLimitConfig caps the distinct stream names one client IP or auth
+// token may publish at once; 0 leaves that side unlimited. It is checked
+// when the publish command arrives, after the connection limits let the
+// session in.
+type PublishLimitConfig struct {
+	MaxStreamsPerIP    int `json:"max_streams_per_ip,omitempty"`
+	MaxStreamsPerToken int `json:"max_streams_per_token,omitempty"`
+}
+
+// Duplicate publish policies.
+const (
+	DuplicatePublishAllow    = "allow"    // Both sessions publish; the upstream decides
+	DuplicatePublishReject   = "reject"   // The newcomer is refused
+	DuplicatePublishKick     = "kick"     // The running session is ended for the newcomer
+	DuplicatePublishTakeover = "takeover" // Like kick for newcomers with the takeover token, else reject
+)
+
+// DuplicatePublishConfig decides what happens when a client publishes a
+// stream name another session on this relay is already publishing.
+type DuplicatePublishConfig struct {
+	Policy        string   `json:"policy,omitempty"`         // One of the DuplicatePublish policies; empty is allow
+	TakeoverToken string   `json:"takeover_token,omitempty"` // Presented as ?takeover= on the app; required by takeover
+	Wait          Duration `json:"wait,omitempty"`           // How long a newcomer waits for the session it replaces to end; 0 = 5s
+}
+
 // CircuitBreakerConfig defines circuit breaker settings.
 type CircuitBreakerConfig struct {
 	Enabled         bool  `json:"enabled"`
@@ -50,12 +99,163 @@ type RetryConfig struct {
 	MaxDelaySec     int     `json:"max_delay_sec"`
 	Multiplier      float64 `json:"multiplier"`
 	JitterFraction  float64 `json:"jitter_fraction"`
+
+	PerAttemptTimeout Duration `json:"per_attempt_timeout,omitempty"` // Bounds each dial; 0 is unbounded
+	Deadline          Duration `json:"deadline,omitempty"`            // Bounds all dials of a session and the waits between them; 0 is unbounded
+
+	// The budget caps retries across all sessions within budget_window at
+	// budget_min_retries plus budget_ratio per dial; a ratio of 0 disables it.
+	BudgetRatio      float64  `json:"budget_ratio,omitempty"`
+	BudgetMinRetries int      `json:"budget_min_retries,omitempty"`
+	BudgetWindow     Duration `json:"budget_window,omitempty"` // 0 uses 10s
 }
 
 // UpstreamEndpoint defines a single upstream target.
 type UpstreamEndpoint struct {
-	URL    string `json:"url"`
-	Weight int    `json:"weight"`
+	URL           string               `json:"url"`
+	Weight        int                  `json:"weight"`
+	EgressShaping *EgressShapingConfig `json:"egress_shaping,omitempty"` // Overrides the global egress_shaping
+	DialRate      *DialRateConfig      `json:"dial_rate,omitempty"`      // Overrides the global upstream_dial_rate
+	Bind          *UpstreamBindConfig  `json:"bind,omitempty"`           // Overrides the global upstream_bind
+	DSCP          int                  `json:"dscp,omitempty"`           // Overrides qos.upstream; 0 uses it
+	Credentials   []UpstreamCredential `json:"credentials,omitempty"`    // Overrides the global upstream_credentials
+
+	// ExpandDNS dials every A/AAAA record of the host as an endpoint of its
+	// own, each with Weight, re-resolved every upstream_dns_interval. Meant
+	// for Kubernetes headless services and other round-robin DNS names.
+	ExpandDNS bool `json:"expand_dns,omitempty"`
+}
+
+// UpstreamCredential authenticates relayed sessions to an upstream. Its query
+// parameters are set on the connect app and tcUrl. Credentials are tried in
+// order until the upstream accepts the connect, so a key rotation lists the
+// new key first and keeps the old one until every upstream has switched.
+type UpstreamCredential struct {
+	Name  string `json:"name,omitempty"` // Label for logs and metrics; defaults to the list position
+	Query string `json:"query"`          // e.g. "token=abc123"
+}
+
+// RouteConfig sends sessions whose "app/stream" name matches Match to their
+// own upstream group instead of the global pool. Match uses path.Match
+// syntax, e.g. "live/*". Passthrough sessions are routed before the stream
+// name is known, so they are matched as "app/".
+type RouteConfig struct {
+	Match     string             `json:"match"`
+	Upstreams []UpstreamEndpoint `json:"upstreams"`
+	Strategy  string             `json:"strategy,omitempty"` // defaults to upstream_strategy
+}
+
+// TenantConfig isolates one customer of a shared relay, identified by the
+// RTMP app its encoders connect to. Its limits apply on top of the global
+// ones, and its sessions are labelled with App in the tenant metrics.
+type TenantConfig struct {
+	App             string                `json:"app"`                        // RTMP app name, without a query string
+	AuthTokens      []string              `json:"auth_tokens,omitempty"`      // Required from the app's clients instead of security.auth_tokens
+	Upstreams       []UpstreamEndpoint    `json:"upstreams,omitempty"`        // Routed as "app/*" after the explicit routes; empty uses them or the global pool
+	Strategy        string                `json:"strategy,omitempty"`         // defaults to upstream_strategy
+	ConnectionLimit ConnectionLimitConfig `json:"connection_limit,omitempty"` // Concurrent sessions of the app, in total and per client IP
+	RateLimit       RateLimitConfig       `json:"rate_limit,omitempty"`       // New sessions per second per client IP
+	StreamPolicy    *StreamPolicyConfig   `json:"stream_policy,omitempty"`    // The app's plan limits, replacing stream_policy
+}
+
+// EgressShapingConfig defines token bucket shaping of bytes sent to an upstream.
+// Intended for staging environments that need to reproduce constrained uplinks.
+type EgressShapingConfig struct {
+	RateBytesPerSec float64 `json:"rate_bytes_per_sec"` // 0 disables shaping
+	BurstBytes      int     `json:"burst_bytes"`        // 0 = one second of rate
+}
+
+// UpstreamBindConfig makes upstream connections from a given local address
+// or network interface, for hosts with more than one uplink.
+type UpstreamBindConfig struct {
+	SourceIP  string `json:"source_ip,omitempty"` // Local IP to dial from; must match the upstream's address family
+	Interface string `json:"interface,omitempty"` // Device to send through, e.g. "eth1"; Linux only
+}
+
+// DialRateConfig paces new connection attempts to an upstream, so a
+// reconnect storm after it restarts reaches it spread out. Dials over the
+// rate queue for their turn.
+type DialRateConfig struct {
+	PerSecond float64  `json:"per_second"`         // 0 disables pacing
+	Burst     int      `json:"burst,omitempty"`    // Dials allowed at once; 0 = one second of rate
+	MaxWait   Duration `json:"max_wait,omitempty"` // Longest a dial queues before its session fails; 0 = 10s
+	Jitter    float64  `json:"jitter,omitempty"`   // Extra random wait, as a fraction 0-1 of a queued dial's delay
+}
+
+// QoSConfig marks relay sockets with a DSCP class (0-63), e.g. 46 (EF) or
+// 34 (AF41), so network gear can prioritize media. 0 leaves sockets
+// unmarked. Marking is only supported on Linux.
+type QoSConfig struct {
+	DSCP         int `json:"dscp,omitempty"`          // Default for every socket below
+	RTMPListener int `json:"rtmp_listener,omitempty"` // Clients on listen_addr; 0 = dscp
+	HTTPListener int `json:"http_listener,omitempty"` // Clients on http_addr, including RTMPT; 0 = dscp
+	Upstream     int `json:"upstream,omitempty"`      // Upstream connections; 0 = dscp
+}
+
+// IdleTimeoutsConfig splits idle_timeout by direction and by phase. The
+// handshake phase runs from accept until the session starts relaying, so
+// it covers the RTMP handshake and the connect and publish exchange.
+type IdleTimeoutsConfig struct {
+	Read           Duration `json:"read,omitempty"`            // No data from the peer while relaying; 0 = idle_timeout
+	Write          Duration `json:"write,omitempty"`           // A write blocked while relaying; 0 = idle_timeout
+	HandshakeRead  Duration `json:"handshake_read,omitempty"`  // 0 = read
+	HandshakeWrite Duration `json:"handshake_write,omitempty"` // 0 = write
+}
+
+// StateTimeoutsConfig bounds how long a session may stay in each state,
+// however busy its sockets are, unlike the idle timeouts. A session still
+// in a state when its time is up ends with the reason state_timeout.
+type StateTimeoutsConfig struct {
+	Handshaking Duration `json:"handshaking,omitempty"` // RTMP handshake and connect command; 0 = unbounded
+	Dialing     Duration `json:"dialing,omitempty"`     // From an accepted connect until media flows; 0 = unbounded
+	Relaying    Duration `json:"relaying,omitempty"`    // Longest a session may relay; 0 = unbounded
+}
+
+// TCPKeepaliveConfig tunes how quickly a relay socket notices a dead peer,
+// such as an encoder whose network dropped without closing the connection.
+// Zero fields keep the OS defaults.
+type TCPKeepaliveConfig struct {
+	Idle        Duration `json:"idle,omitempty"`         // Quiet time before the first keepalive probe
+	Interval    Duration `json:"interval,omitempty"`     // Time between unanswered probes
+	Count       int      `json:"count,omitempty"`        // Unanswered probes before the connection is dropped
+	UserTimeout Duration `json:"user_timeout,omitempty"` // Longest sent data may go unacknowledged; Linux only
+}
+
+// TCPConfig tunes client and upstream sockets separately.
+type TCPConfig struct {
+	Downstream TCPKeepaliveConfig `json:"downstream,omitempty"`
+	Upstream   TCPKeepaliveConfig `json:"upstream,omitempty"`
+}
+
+// HappyEyeballsConfig races the IPv6 and IPv4 addresses of an upstream
+// hostname per RFC 8305 instead of trying them in the resolver's order, so a
+// broken address family costs one attempt delay rather than a dial timeout.
+type HappyEyeballsConfig struct {
+	Enabled      bool     `json:"enabled"`
+	AttemptDelay Duration `json:"attempt_delay,omitempty"` // Head start of each address over the next; 0 = 250ms
+}
+
+// OutlierDetectionConfig ejects pool upstreams whose recent connects keep
+// failing or are slow, even while their TCP health checks pass. It applies
+// to the global pool and to route and tenant groups.
+type OutlierDetectionConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Window        Duration `json:"window,omitempty"`         // Connect outcomes considered; 0 = 1m
+	MinRequests   int      `json:"min_requests,omitempty"`   // Outcomes in the window before an upstream is judged; 0 = 5
+	FailureRate   float64  `json:"failure_rate,omitempty"`   // Failed fraction that ejects, 0-1; 0 = 0.5
+	SlowThreshold Duration `json:"slow_threshold,omitempty"` // A dial and handshake slower than this counts as failed; 0 disables
+	BaseEjection  Duration `json:"base_ejection,omitempty"`  // First ejection, doubled for each repeat; 0 = 30s
+	MaxEjection   Duration `json:"max_ejection,omitempty"`   // Longest ejection; 0 = 5m
+	Ramp          Duration `json:"ramp,omitempty"`           // Traffic returns from 10% to full share over this; 0 = base_ejection
+}
+
+// UpstreamPrewarmConfig keeps dialed and handshaked connections ready to
+// each upstream, so a new session skips the dial and RTMP handshake.
+type UpstreamPrewarmConfig struct {
+	Enabled     bool     `json:"enabled"`
+	PerUpstream int      `json:"per_upstream,omitempty"` // Warm connections kept per upstream address; 0 = 2
+	MaxIdle     Duration `json:"max_idle,omitempty"`     // Unused connections are replaced after this; 0 = 30s
+	Interval    Duration `json:"interval,omitempty"`     // How often the pool is checked and topped up; 0 = 5s
 }
 
 // UpstreamHealthCheckConfig defines health check settings for upstreams.
@@ -63,25 +263,452 @@ type UpstreamHealthCheckConfig struct {
 	Enabled     bool `json:"enabled"`
 	IntervalSec int  `json:"interval_sec"`
 	TimeoutSec  int  `json:"timeout_sec"`
+
+	// LogMode is "every_failure" (default), warning on each failed probe, or
+	// "state_change", logging only when an upstream goes down or recovers
+	// plus a summary every ReminderIntervalSec while it stays down.
+	LogMode             string `json:"log_mode,omitempty"`
+	ReminderIntervalSec int    `json:"reminder_interval_sec,omitempty"` // 0 uses 600
 }
 
 // Config defines server settings.
 type Config struct {
-	ListenAddr          string                    `json:"listen_addr"`
+	ListenAddr          string                    `json:"listen_addr"` // host:port, or unix:///path for a Unix domain socket
 	HTTPAddr            string                    `json:"http_addr"`
+	UnixSocketMode      string                    `json:"unix_socket_mode,omitempty"` // Octal file mode of unix:// listeners, e.g. "0660"; empty uses the umask
 	Upstream            string                    `json:"upstream"`
 	Upstreams           []UpstreamEndpoint        `json:"upstreams,omitempty"`
 	UpstreamStrategy    string                    `json:"upstream_strategy,omitempty"`
 	UpstreamHealthCheck UpstreamHealthCheckConfig `json:"upstream_health_check,omitempty"`
+	UpstreamDNSInterval Duration                  `json:"upstream_dns_interval,omitempty"` // How often expand_dns upstreams are re-resolved; 0 = 30s
+	OutlierDetection    OutlierDetectionConfig    `json:"outlier_detection,omitempty"`
+	UpstreamPrewarm     UpstreamPrewarmConfig     `json:"upstream_prewarm,omitempty"`
+	EgressShaping       EgressShapingConfig       `json:"egress_shaping,omitempty"`
+	UpstreamDialRate    DialRateConfig            `json:"upstream_dial_rate,omitempty"`
+	UpstreamBind        UpstreamBindConfig        `json:"upstream_bind,omitempty"`
+	HappyEyeballs       HappyEyeballsConfig       `json:"happy_eyeballs,omitempty"`
+	TCP                 TCPConfig                 `json:"tcp,omitempty"`
+	QoS                 QoSConfig                 `json:"qos,omitempty"`
+	UpstreamCredentials []UpstreamCredential      `json:"upstream_credentials,omitempty"`
 	IdleTimeout         Duration                  `json:"idle_timeout"`
+	IdleTimeouts        IdleTimeoutsConfig        `json:"idle_timeouts,omitempty"`
+	StateTimeouts       StateTimeoutsConfig       `json:"state_timeouts,omitempty"`
 	ReadBuffer          int                       `json:"read_buffer"`
 	WriteBuffer         int                       `json:"write_buffer"`
 	Security            SecurityConfig            `json:"security,omitempty"`
 	RateLimit           RateLimitConfig           `json:"rate_limit,omitempty"`
 	ConnectionLimit     ConnectionLimitConfig     `json:"connection_limit,omitempty"`
+	PublishLimit        PublishLimitConfig        `json:"publish_limit,omitempty"`
+	DuplicatePublish    DuplicatePublishConfig    `json:"duplicate_publish,omitempty"`
 	CircuitBreaker      CircuitBreakerConfig      `json:"circuit_breaker,omitempty"`
 	Retry               RetryConfig               `json:"retry,omitempty"`
 	Transcode           TranscodeConfig           `json:"transcode,omitempty"`
+	TranscodeProfiles   TranscodeProfilesConfig   `json:"transcode_profiles,omitempty"`
+	Profiling           ProfilingConfig           `json:"profiling,omitempty"`
+	RTMP                RTMPConfig                `json:"rtmp,omitempty"`
+	RTMPT               RTMPTConfig               `json:"rtmpt,omitempty"`
+	Failover            FailoverConfig            `json:"failover,omitempty"`
+	RewriteRules        []RewriteRule             `json:"rewrite_rules,omitempty"`
+	Routes              []RouteConfig             `json:"routes,omitempty"`
+	Tenants             []TenantConfig            `json:"tenants,omitempty"`
+	ReconnectGrace      Duration                  `json:"reconnect_grace,omitempty"` // Hold transcoded outputs open for re-publishes; 0 disables
+	AccessLog           AccessLogConfig           `json:"access_log,omitempty"`
+	Logging             LoggingConfig             `json:"logging,omitempty"`
+	SessionJournal      SessionJournalConfig      `json:"session_journal,omitempty"`
+	State               StateConfig               `json:"state,omitempty"`
+	Metrics             MetricsConfig             `json:"metrics,omitempty"`
+	Readiness           ReadinessConfig           `json:"readiness,omitempty"`
+	Drain               DrainConfig               `json:"drain,omitempty"`
+	Redirect            RedirectConfig            `json:"redirect,omitempty"`
+	DNSResponder        DNSResponderConfig        `json:"dns_responder,omitempty"`
+	Cluster             ClusterConfig             `json:"cluster,omitempty"`
+	Thumbnails          ThumbnailConfig           `json:"thumbnails,omitempty"`
+	DVR                 DVRConfig                 `json:"dvr,omitempty"`
+	Packager            PackagerConfig            `json:"packager,omitempty"`
+	Viewers             ViewersConfig             `json:"viewers,omitempty"`
+	AVSync              AVSyncConfig              `json:"av_sync,omitempty"`
+	StreamPolicy        StreamPolicyConfig        `json:"stream_policy,omitempty"`
+}
+
+// ThumbnailConfig snapshots a keyframe of each live stream as a JPEG, served
+// at GET /streams/{name}/thumbnail.jpg. Snapshots are decoded by ffmpeg.
+type ThumbnailConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval,omitempty"` // Minimum time between snapshots of a stream; 0 = 10s
+	Width    int      `json:"width,omitempty"`    // Scaled width keeping the aspect ratio; 0 keeps the source size
+	Quality  int      `json:"quality,omitempty"`  // JPEG qscale, 2 (best) to 31; 0 = 5
+}
+
+// PackagerConfig repackages each live H.264/AAC stream as CMAF segments
+// held in memory, served as HLS at GET /streams/{name}/hls/index.m3u8 and
+// as DASH at GET /streams/{name}/dash/manifest.mpd. Both share the same
+// segments.
+type PackagerConfig struct {
+	Enabled         bool     `json:"enabled"`
+	HLS             bool     `json:"hls,omitempty"`
+	DASH            bool     `json:"dash,omitempty"`
+	SegmentDuration Duration `json:"segment_duration,omitempty"` // Segments are cut at the first keyframe after this; 0 = 4s
+	PartDuration    Duration `json:"part_duration,omitempty"`    // Cut segments into parts for low-latency HLS; 0 disables
+	MaxSegments     int      `json:"max_segments,omitempty"`     // Complete segments kept per stream; 0 = 6
+	MaxBytes        int64    `json:"max_bytes,omitempty"`        // Evict older segments past this many bytes per stream; 0 = unlimited
+}
+
+// ViewersConfig caps the players of each stream across RTMP play, DVR
+// HTTP-FLV and packaged HLS/DASH. Viewers are counted whether or not a cap
+// is set.
+type ViewersConfig struct {
+	MaxPerStream int      `json:"max_per_stream,omitempty"` // Turn away players past this many per stream; 0 = unlimited
+	SegmentIdle  Duration `json:"segment_idle,omitempty"`   // An HLS/DASH player stops counting after this long without a request; 0 = 30s
+}
+
+// AVSyncConfig watches the timestamps of each published stream for drift
+// between its audio and video and for gaps within either track.
+type AVSyncConfig struct {
+	Enabled        bool     `json:"enabled"`
+	DriftThreshold Duration `json:"drift_threshold,omitempty"` // Warn when video and audio timestamps are further apart; 0 = 500ms
+	GapThreshold   Duration `json:"gap_threshold,omitempty"`   // Count and warn about gaps between frames of a track longer than this; 0 = 1s
+}
+
+// Stream policy actions.
+const (
+	StreamPolicyWarn   = "warn"   // Log and count violations only
+	StreamPolicyReject = "reject" // End the session at the first violation
+)
+
+// StreamPolicyConfig checks what publishers send against limits, from the
+// video and audio tag headers and the stream's onMetaData. Zero values leave
+// a check off.
+type StreamPolicyConfig struct {
+	Enabled             bool     `json:"enabled"`
+	Action              string   `json:"action,omitempty"`                // One of the StreamPolicy actions; empty is warn
+	MaxKeyframeInterval Duration `json:"max_keyframe_interval,omitempty"` // Longest time between video keyframes
+	VideoCodecs         []string `json:"video_codecs,omitempty"`          // Allowed video codecs, e.g. "h264", "hevc"
+	AudioCodecs         []string `json:"audio_codecs,omitempty"`          // Allowed audio codecs, e.g. "aac", "mp3"
+	MaxWidth            int      `json:"max_width,omitempty"`             // Pixels, as declared in onMetaData
+	MaxHeight           int      `json:"max_height,omitempty"`
+	MaxBitrateKbps      int      `json:"max_bitrate_kbps,omitempty"` // Declared video plus audio data rate
+	DisallowBFrames     bool     `json:"disallow_bframes,omitempty"` // Refuse frames with a composition time offset
+}
+
+// DVRConfig records each live stream to a rolling window of FLV segments on
+// disk, played back time-shifted at GET /streams/{name}/dvr.flv?offset=5m.
+type DVRConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Dir      string   `json:"dir,omitempty"`       // Segment directory; required when enabled
+	Window   Duration `json:"window,omitempty"`    // How far back viewers can seek; 0 = 30m
+	Segment  Duration `json:"segment,omitempty"`   // Target segment length, cut at keyframes; 0 = 6s
+	MaxBytes int64    `json:"max_bytes,omitempty"` // Per-stream disk cap; 0 bounds by window only
+
+	Encryption  DVREncryptionConfig  `json:"encryption,omitempty"`
+	PostProcess DVRPostProcessConfig `json:"post_process,omitempty"`
+}
+
+// DVRPostProcessConfig runs Steps, in order, on each DVR segment once it is
+// closed. Jobs work on a standalone FLV copy of the segment in WorkDir, so
+// they are unaffected by the recording pruning or deleting it.
+type DVRPostProcessConfig struct {
+	Enabled      bool     `json:"enabled"`
+	WorkDir      string   `json:"work_dir,omitempty"`      // Empty = "postprocess" in the DVR dir; keep it on the same filesystem
+	Concurrency  int      `json:"concurrency,omitempty"`   // Jobs run at once; 0 = 2
+	Queue        int      `json:"queue,omitempty"`         // Jobs waiting beyond those; 0 = 100, later segments are skipped
+	MaxAttempts  int      `json:"max_attempts,omitempty"`  // Per step; 0 = 3
+	RetryBackoff Duration `json:"retry_backoff,omitempty"` // Before the second attempt, doubling after; 0 = 5s
+	StepTimeout  Duration `json:"step_timeout,omitempty"`  // Per attempt; 0 = 2m
+
+	Steps []DVRPostProcessStep `json:"steps"`
+}
+
+// DVRPostProcessStep is one thing done with a closed segment.
+type DVRPostProcessStep struct {
+	Type  string `json:"type"`            // remux_mp4, thumbnail, webhook or move
+	URL   string `json:"url,omitempty"`   // webhook: receives the job as JSON
+	Dir   string `json:"dir,omitempty"`   // move: files land in a subdirectory per stream
+	Width int    `json:"width,omitempty"` // thumbnail: scaled to this width; 0 keeps the video's
+}
+
+// Post-processing step types.
+const (
+	PostProcessRemuxMP4  = "remux_mp4" // Remux the segment to MP4 with ffmpeg
+	PostProcessThumbnail = "thumbnail" // Snapshot its first keyframe as a JPEG with ffmpeg
+	PostProcessWebhook   = "webhook"   // POST the job, with the files made so far, to URL
+	PostProcessMove      = "move"      // Move the segment and the files made so far to Dir
+)
+
+// DVREncryptionConfig seals DVR segments with AES-GCM. The key is read once
+// at startup from exactly one of Key, KeyFile or KeyCommand, each holding it
+// base64-encoded: 16, 24 or 32 bytes for AES-128, -192 or -256.
+type DVREncryptionConfig struct {
+	Enabled    bool     `json:"enabled"`
+	KeyID      string   `json:"key_id,omitempty"` // Written into each segment, so archives name the key they need
+	Key        string   `json:"key,omitempty"`
+	KeyFile    string   `json:"key_file,omitempty"`
+	KeyCommand []string `json:"key_command,omitempty"` // Prints the key, e.g. a KMS or Vault client
+}
+
+// DNSResponderConfig runs a small authoritative DNS server that answers A
+// queries for Name with Addresses only while the relay is ready.
+type DNSResponderConfig struct {
+	Listen    string   `json:"listen,omitempty"`    // UDP address, e.g. ":53"; empty disables it
+	Name      string   `json:"name,omitempty"`      // Name encoders resolve, e.g. "ingest.example.com"
+	Addresses []string `json:"addresses,omitempty"` // IPv4 addresses of this relay
+	TTL       Duration `json:"ttl,omitempty"`       // 0 = 10s; keep short so failover is quick
+}
+
+// ClusterConfig names the relays that share ingest traffic, so /api/route
+// can tell load balancers which one currently has a stream.
+type ClusterConfig struct {
+	InstanceID    string                `json:"instance_id,omitempty"`     // Defaults to the hostname
+	AdvertiseURL  string                `json:"advertise_url,omitempty"`   // Where balancers reach this relay, e.g. "http://relay-1:8080"
+	AdvertiseRTMP string                `json:"advertise_rtmp,omitempty"`  // Where other relays redirect publishers to this one, e.g. "rtmp://relay-1:1935"
+	Peers         []string              `json:"peers,omitempty"`           // HTTP base URLs of the other relays
+	RouteCacheTTL Duration              `json:"route_cache_ttl,omitempty"` // 0 = 5s; also the Cache-Control max-age
+	Registry      ClusterRegistryConfig `json:"registry,omitempty"`
+	PlayRouting   string                `json:"play_routing,omitempty"` // "redirect" or "proxy" playback of streams live on another relay; empty serves local streams only
+}
+
+// Cluster play routing modes.
+const (
+	PlayRoutingRedirect = "redirect"
+	PlayRoutingProxy    = "proxy"
+)
+
+// ClusterRegistryConfig keeps the owner of every live stream in a shared
+// Redis, so relays find each other's streams without asking every peer.
+type ClusterRegistryConfig struct {
+	Redis     string   `json:"redis,omitempty"`      // redis://[user:password@]host:port[/db] or rediss://; empty disables the registry
+	KeyPrefix string   `json:"key_prefix,omitempty"` // Prepended to stream names; empty = "rtmp-relay:stream:"
+	NodesKey  string   `json:"nodes_key,omitempty"`  // Hash holding each relay's load; empty = "rtmp-relay:nodes"
+	TTL       Duration `json:"ttl,omitempty"`        // How long an entry outlives a relay that stops refreshing it; 0 = 15s
+}
+
+// MetricsConfig controls the final metrics push made during shutdown, so
+// counters from the last sessions survive a process that exits between
+// scrapes, and the StatsD emitter used where nothing scrapes /metrics.
+type MetricsConfig struct {
+	PushGateway string       `json:"push_gateway,omitempty"` // Pushgateway base URL; empty disables the push
+	PushJob     string       `json:"push_job,omitempty"`     // Job label; defaults to "rtmp_relay"
+	StatsD      StatsDConfig `json:"statsd,omitempty"`
+}
+
+// StatsDConfig sends the relay's metrics to a StatsD or DogStatsD agent over
+// UDP.
+type StatsDConfig struct {
+	Address       string            `json:"address,omitempty"`        // Agent host:port, e.g. "127.0.0.1:8125"; empty disables the emitter
+	Flavor        string            `json:"flavor,omitempty"`         // "statsd" (default) or "dogstatsd"
+	Prefix        string            `json:"prefix,omitempty"`         // Prepended to metric names with a dot
+	Tags          map[string]string `json:"tags,omitempty"`           // Added to every metric; dogstatsd only
+	FlushInterval Duration          `json:"flush_interval,omitempty"` // 0 = 10s
+}
+
+func (s StatsDConfig) validate() error {
+	if s.Address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("metrics.statsd.address %q must be host:port", s.Address)
+	}
+	switch s.Flavor {
+	case "", "statsd":
+		if len(s.Tags) > 0 {
+			return errors.New("metrics.statsd.tags requires flavor dogstatsd")
+		}
+	case "dogstatsd":
+	default:
+		return fmt.Errorf("metrics.statsd.flavor %q must be statsd or dogstatsd", s.Flavor)
+	}
+	if s.FlushInterval < 0 {
+		return errors.New("metrics.statsd.flush_interval must be >= 0")
+	}
+	return nil
+}
+
+// Dependencies /ready can check.
+const (
+	ReadyUpstream   = "upstream"   // An upstream accepts connections, or a pool member is healthy
+	ReadyTranscoder = "transcoder" // Transcoding is switched on and has a free slot
+	ReadyRecording  = "recording"  // The DVR directory has min_free_bytes free
+)
+
+// ReadinessConfig controls /ready. Probe results are reused for CacheTTL so
+// frequent kubelet probes do not dial the upstream each time; every
+// dependency in use is reported, but only those in Require fail the probe.
+type ReadinessConfig struct {
+	CacheTTL     Duration `json:"cache_ttl,omitempty"`      // 0 = 2s
+	ProbeTimeout Duration `json:"probe_timeout,omitempty"`  // Per-dependency probe limit; 0 = 2s
+	Require      []string `json:"require,omitempty"`        // Dependencies that gate readiness; empty = ["upstream"]
+	MinFreeBytes int64    `json:"min_free_bytes,omitempty"` // Free space the recording check needs; 0 = 1 GiB
+}
+
+func (r ReadinessConfig) validate(transcode, dvr bool) error {
+	if r.CacheTTL < 0 {
+		return errors.New("readiness.cache_ttl must be >= 0")
+	}
+	if r.ProbeTimeout < 0 {
+		return errors.New("readiness.probe_timeout must be >= 0")
+	}
+	if r.MinFreeBytes < 0 {
+		return errors.New("readiness.min_free_bytes must be >= 0")
+	}
+	for i, dep := range r.Require {
+		switch dep {
+		case ReadyUpstream:
+		case ReadyTranscoder:
+			if !transcode {
+				return fmt.Errorf("readiness.require[%d] %q requires transcode.enabled", i, dep)
+			}
+		case ReadyRecording:
+			if !dvr {
+				return fmt.Errorf("readiness.require[%d] %q requires dvr.enabled", i, dep)
+			}
+		default:
+			return fmt.Errorf("readiness.require[%d] %q must be upstream, transcoder or recording", i, dep)
+		}
+	}
+	return nil
+}
+
+// DrainConfig sets the reply publishers get while the node is draining for
+// maintenance (POST /admin/drain).
+type DrainConfig struct {
+	Code        string `json:"code,omitempty"`        // NetConnection status code; empty = NetConnection.Connect.Rejected
+	Description string `json:"description,omitempty"` // Empty = "server is draining for maintenance"
+}
+
+func (d DrainConfig) validate() error {
+	if d.Code != "" && !strings.HasPrefix(d.Code, "NetConnection.") {
+		return fmt.Errorf("drain.code %q must be a NetConnection status code", d.Code)
+	}
+	return nil
+}
+
+// RedirectConfig answers publishers' connects with an RTMP redirect to
+// another relay instead of relaying them here, for apps served elsewhere and
+// to shed load once this relay is busy.
+type RedirectConfig struct {
+	Apps        map[string]string `json:"apps,omitempty"`         // App -> rtmp:// URL its publishers are always sent to
+	MaxSessions int               `json:"max_sessions,omitempty"` // Redirect new connects while this many sessions run here; 0 never redirects for load
+	Targets     []string          `json:"targets,omitempty"`      // rtmp:// base URLs taking the overflow in turn; empty picks the least loaded relay in cluster.registry
+}
+
+func (r RedirectConfig) validate(registry bool) error {
+	for app, target := range r.Apps {
+		if !isRTMPURL(target) {
+			return fmt.Errorf("redirect.apps[%q] %q must be an rtmp(s) URL", app, target)
+		}
+	}
+	for i, target := range r.Targets {
+		if !isRTMPURL(target) {
+			return fmt.Errorf("redirect.targets[%d] %q must be an rtmp(s) URL", i, target)
+		}
+	}
+	if r.MaxSessions < 0 {
+		return errors.New("redirect.max_sessions must be >= 0")
+	}
+	if r.MaxSessions > 0 && len(r.Targets) == 0 && !registry {
+		return errors.New("redirect.max_sessions requires redirect.targets or cluster.registry")
+	}
+	return nil
+}
+
+// SessionJournalConfig appends a JSON record per finished session to Path.
+type SessionJournalConfig struct {
+	Path          string   `json:"path"`                     // Empty disables the journal
+	Compress      bool     `json:"compress"`                 // gzip the journal on disk
+	FlushInterval Duration `json:"flush_interval,omitempty"` // 0 = 5s
+}
+
+// StateConfig persists stream history, session totals and DVR recording
+// manifests to Path so they survive restarts.
+type StateConfig struct {
+	Path         string   `json:"path"`                    // bbolt database; empty disables persistence
+	SaveInterval Duration `json:"save_interval,omitempty"` // 0 = 10s
+}
+
+// RewriteRule maps an inbound app or stream name to the name used upstream.
+// The first rule matching a field wins.
+type RewriteRule struct {
+	Field   string `json:"field"`   // "app" or "stream"
+	Match   string `json:"match"`   // Regular expression matched against the whole value
+	Replace string `json:"replace"` // Expansion template, e.g. "live_$1" or "${tenant}-ingest"
+}
+
+// FailoverConfig defines primary/backup publisher pairs. The relay forwards
+// the primary and switches to the backup when the primary is lost.
+type FailoverConfig struct {
+	Pairs       []PublisherPair `json:"pairs"`
+	LossTimeout Duration        `json:"loss_timeout"` // Treat a silent primary as lost (0 = disconnect only)
+}
+
+// PublisherPair links the stream keys of a primary and backup encoder.
+type PublisherPair struct {
+	Primary string `json:"primary"`
+	Backup  string `json:"backup"`
+}
+
+// RTMPTConfig defines RTMP-over-HTTP tunneling served on the HTTP listener.
+type RTMPTConfig struct {
+	Enabled     bool     `json:"enabled"`
+	IdleTimeout Duration `json:"idle_timeout"` // Close tunnels not polled within this window
+}
+
+// RTMPConfig defines protocol-level limits for inbound RTMP sessions.
+type RTMPConfig struct {
+	MaxMessageSize   int   `json:"max_message_size"`   // Bytes per message (0 = default)
+	MaxChunkStreams  int   `json:"max_chunk_streams"`  // Chunk streams with a message in progress (0 = default)
+	MaxBufferedBytes int64 `json:"max_buffered_bytes"` // Bytes across partial messages (0 = default)
+	SessionQueue     int   `json:"session_queue"`      // Messages queued per session before frames are dropped (0 = default)
+
+	// Strict checks every client message against the RTMP specification and
+	// keeps a per-session compliance report at /admin/compliance. Sessions
+	// are never cut off for violations; this is for testing encoders.
+	Strict bool `json:"strict"`
+
+	// DeterministicHandshake fixes handshake time and random bytes so
+	// handshakes are byte-identical. For replay and golden-file testing only.
+	DeterministicHandshake bool `json:"deterministic_handshake"`
+}
+
+// ProfilingConfig defines sampled per-session profiling settings.
+type ProfilingConfig struct {
+	Enabled    bool    `json:"enabled"`
+	SampleRate float64 `json:"sample_rate"` // Fraction of sessions profiled, 0-1
+}
+
+// LoggingConfig defines log output settings. Logs always go to stdout.
+type LoggingConfig struct {
+	Ship LogShipConfig `json:"ship,omitempty"`
+}
+
+// AccessLogConfig writes one JSON line per finished session, apart from the
+// debug log, for billing and analytics. Both sinks may be used at once.
+type AccessLogConfig struct {
+	Path string        `json:"path,omitempty"` // File to append to, or "-" for stdout; empty writes no file
+	Ship LogShipConfig `json:"ship,omitempty"` // Push the lines to Loki or syslog instead of, or as well as, Path
+}
+
+func (a AccessLogConfig) validate(logShip LogShipConfig) error {
+	if err := a.Ship.validate("access_log.ship"); err != nil {
+		return err
+	}
+	if a.Ship.BufferDir != "" && a.Ship.BufferDir == logShip.BufferDir {
+		return errors.New("access_log.ship.buffer_dir must differ from logging.ship.buffer_dir")
+	}
+	return nil
+}
+
+// LogShipConfig pushes logs directly to Loki or a syslog server, buffering
+// them on disk while the sink is unreachable.
+type LogShipConfig struct {
+	Type           string            `json:"type"`                       // "loki" or "syslog"; empty disables shipping
+	URL            string            `json:"url,omitempty"`              // Loki push endpoint, e.g. http://loki:3100/loki/api/v1/push
+	Labels         map[string]string `json:"labels,omitempty"`           // Loki stream labels
+	Address        string            `json:"address,omitempty"`          // Syslog host:port
+	TLS            bool              `json:"tls,omitempty"`              // Syslog over TLS
+	AppName        string            `json:"app_name,omitempty"`         // Syslog APP-NAME, defaults to "rtmp-relay"
+	BufferDir      string            `json:"buffer_dir,omitempty"`       // Empty drops logs the sink rejects
+	BufferMaxBytes int64             `json:"buffer_max_bytes,omitempty"` // 0 = 64 MB
+	BatchSize      int               `json:"batch_size,omitempty"`       // 0 = 100
+	FlushInterval  Duration          `json:"flush_interval,omitempty"`   // 0 = 1s
 }
 
 // TranscodeConfig defines transcoding settings.
@@ -93,6 +720,141 @@ type TranscodeConfig struct {
 	Preset     string `json:"preset"`      // e.g., "ultrafast", "veryfast"
 	CRF        int    `json:"crf"`         // 0-51
 	GOP        string `json:"gop"`         // e.g., "2s" or "60"
+
+	// Encoder private options, e.g. {"tune": "zerolatency", "threads": "4"}.
+	// Passed to the libav encoder dictionary or as -key:v / -key:a flags.
+	VideoOpts map[string]string `json:"video_opts,omitempty"`
+	AudioOpts map[string]string `json:"audio_opts,omitempty"`
+
+	// Video shaping for the single output; renditions carry their own. A lone
+	// width or height keeps the source aspect ratio.
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	Scale        string `json:"scale,omitempty"`         // ffmpeg scale arguments, e.g. "iw/2:-2"; instead of width/height
+	FPS          int    `json:"fps,omitempty"`           // 0 keeps the source frame rate
+	VideoBitrate string `json:"video_bitrate,omitempty"` // e.g. "3000k"
+	VideoMaxRate string `json:"video_maxrate,omitempty"`
+	VideoBufSize string `json:"video_bufsize,omitempty"`
+
+	// Audio settings apply to every output.
+	AudioBitrate    string `json:"audio_bitrate,omitempty"`     // e.g. "128k"
+	AudioSampleRate int    `json:"audio_sample_rate,omitempty"` // Hz; 0 keeps the source rate
+
+	Loudness LoudnessConfig `json:"loudness,omitempty"`
+
+	// Passthrough for options the fields above don't model. Filters are plain
+	// filter chains run ahead of the built-in ones, in both backends; extra
+	// arguments are ffmpeg command-line flags and need the ffmpeg backend.
+	ExtraInputArgs  []string `json:"extra_input_args,omitempty"`  // before -i
+	ExtraOutputArgs []string `json:"extra_output_args,omitempty"` // before each output URL
+	VideoFilter     string   `json:"video_filter,omitempty"`      // e.g. "yadif,hqdn3d"
+	AudioFilter     string   `json:"audio_filter,omitempty"`      // e.g. "highpass=f=80"
+
+	// Mode "smart" holds each publish back until its codecs are known and
+	// runs the transcoder only when the upstream needs other codecs; the rest
+	// are remuxed with both codecs copied. Empty or "always" transcodes every
+	// session. The accepted codecs are named as in /admin/connections, e.g.
+	// "h264" or "aac"; empty lists accept what video_codec and audio_codec
+	// encode to.
+	Mode        string   `json:"mode,omitempty"`
+	AcceptVideo []string `json:"accept_video,omitempty"`
+	AcceptAudio []string `json:"accept_audio,omitempty"`
+
+	KillSwitch TranscodeKillSwitchConfig `json:"kill_switch,omitempty"`
+	Restart    TranscodeRestartConfig    `json:"restart,omitempty"`
+	Slots      TranscodeSlotsConfig      `json:"slots,omitempty"`
+	Limits     TranscodeLimitsConfig     `json:"limits,omitempty"`
+
+	// Renditions turns one input into an adaptive bitrate ladder: the input is
+	// decoded once and encoded once per rendition, each pushed to its own URL.
+	// Empty means a single output at the source size.
+	Renditions []TranscodeRendition `json:"renditions,omitempty"`
+}
+
+// TranscodeProfilesConfig replaces the one transcode setting with a choice
+// per session: Rules pick one of Profiles, or passthrough, by app, stream
+// and token. Each profile stands alone and is enabled by being picked; the
+// kill switch and slots of transcode apply to all of them.
+type TranscodeProfilesConfig struct {
+	Profiles map[string]TranscodeConfig `json:"profiles,omitempty"`
+	Rules    []TranscodeRule            `json:"rules,omitempty"`
+}
+
+// Transcode modes.
+const (
+	TranscodeModeAlways = "always"
+	TranscodeModeSmart  = "smart"
+)
+
+// ProfilePassthrough is the transcode rule profile that relays sessions
+// without transcoding.
+const ProfilePassthrough = "passthrough"
+
+// TranscodeRule picks the transcode profile of the sessions it matches.
+// Rules are tried in order and the first match wins; sessions no rule
+// matches use transcode. App and Stream use path.Match syntax and an empty
+// pattern matches anything. A rule with a stream pattern makes the relay
+// accept the publish itself to learn the stream name, so a passthrough
+// choice made then is served as a remux with both codecs copied.
+type TranscodeRule struct {
+	App     string   `json:"app,omitempty"`    // RTMP app, without a query string
+	Stream  string   `json:"stream,omitempty"` // Publish name
+	Tokens  []string `json:"tokens,omitempty"` // Auth tokens the publisher must have used; empty matches any
+	Profile string   `json:"profile"`          // A profiles name, or "passthrough"
+}
+
+// TranscodeRendition is one rung of an adaptive bitrate ladder.
+type TranscodeRendition struct {
+	Name         string `json:"name"`                    // e.g. "720p"
+	Width        int    `json:"width,omitempty"`         // 0 keeps the source width, or follows Height's aspect
+	Height       int    `json:"height,omitempty"`        // 0 keeps the source height, or follows Width's aspect
+	VideoBitrate string `json:"video_bitrate,omitempty"` // e.g. "2500k"; empty leaves rate control to crf
+	FPS          int    `json:"fps,omitempty"`           // 0 keeps the source frame rate
+	URL          string `json:"url,omitempty"`           // {upstream} and {name} are substituted; default "{upstream}_{name}"
+}
+
+// LoudnessConfig normalizes transcoded audio to a loudness target.
+type LoudnessConfig struct {
+	Filter     string  `json:"filter,omitempty"`      // "loudnorm" (EBU R128) or "dynaudnorm"; empty disables
+	TargetLUFS float64 `json:"target_lufs,omitempty"` // Integrated loudness; 0 uses -23 (EBU R128)
+	TruePeak   float64 `json:"true_peak,omitempty"`   // loudnorm only, dBTP ceiling; 0 uses -1
+	LRA        float64 `json:"lra,omitempty"`         // loudnorm only, loudness range in LU; 0 uses 7
+}
+
+// TranscodeKillSwitchConfig sets the initial state of the transcoding kill
+// switch. It can be flipped at runtime through /admin/transcode.
+type TranscodeKillSwitchConfig struct {
+	Disabled        bool     `json:"disabled"`                   // disable transcoding for every tenant
+	DisabledTenants []string `json:"disabled_tenants,omitempty"` // RTMP app names
+	Fallback        string   `json:"fallback,omitempty"`         // "passthrough" (default) or "reject"
+}
+
+// TranscodeRestartConfig restarts a transcoder that exits mid-stream instead
+// of ending the session. The new process is fed the stream headers again and
+// resumes at the next keyframe.
+type TranscodeRestartConfig struct {
+	MaxRestarts int      `json:"max_restarts,omitempty"` // 0 disables restarts
+	Backoff     Duration `json:"backoff,omitempty"`      // first delay, doubled per restart; 0 uses 1s
+	MaxBackoff  Duration `json:"max_backoff,omitempty"`  // 0 uses 30s
+	ResetAfter  Duration `json:"reset_after,omitempty"`  // a run this long restores the restart budget; 0 uses 1m
+}
+
+// TranscodeSlotsConfig caps how many sessions transcode at once. Sessions
+// beyond the cap are relayed untouched or turned away.
+type TranscodeSlotsConfig struct {
+	MaxSessions int    `json:"max_sessions,omitempty"` // 0 means unlimited
+	Overflow    string `json:"overflow,omitempty"`     // "passthrough" (default) or "reject"
+}
+
+// TranscodeLimitsConfig caps the host resources of each ffmpeg process, so a
+// runaway transcode cannot starve the relay. CPU and memory limits need a
+// cgroup v2 directory delegated to the relay; without one, or where it
+// cannot be used, the process only runs at a lower CPU and IO priority.
+type TranscodeLimitsConfig struct {
+	CPU         float64 `json:"cpu,omitempty"`          // Cores, e.g. 1.5; 0 is unlimited
+	MemoryBytes int64   `json:"memory_bytes,omitempty"` // 0 is unlimited
+	Cgroup      string  `json:"cgroup,omitempty"`       // e.g. "/sys/fs/cgroup/relay.service/transcode"
+	Nice        int     `json:"nice,omitempty"`         // 1-19; 0 keeps the relay's priority, or 10 as the fallback
 }
 
 func Default() Config {
@@ -128,6 +890,12 @@ func (c Config) Validate() error {
 	if c.ListenAddr == "" {
 		return errors.New("listen_addr is required")
 	}
+	if c.ListenAddr == "unix://" {
+		return errors.New("listen_addr unix:// needs a socket path")
+	}
+	if _, err := c.UnixSocketFileMode(); err != nil {
+		return err
+	}
 	if c.ReadBuffer <= 0 {
 		return errors.New("read_buffer must be positive")
 	}
@@ -141,8 +909,11 @@ func (c Config) Validate() error {
 		return fmt.Errorf("write_buffer must be between %d and %d bytes", MinBufferSize, MaxBufferSize)
 	}
 	strategy := strings.ToLower(strings.TrimSpace(c.UpstreamStrategy))
-	if strategy != "" && strategy != "round_robin" && strategy != "random" {
-		return errors.New("upstream_strategy must be round_robin or random")
+	if !validStrategy(strategy) {
+		return errors.New("upstream_strategy must be round_robin, random or redundant")
+	}
+	if strategy == "redundant" && len(c.Upstreams) < 2 {
+		return errors.New("upstream_strategy redundant needs at least two upstreams")
 	}
 	if len(c.Upstreams) == 0 {
 		if c.Upstream == "" {
@@ -151,18 +922,90 @@ func (c Config) Validate() error {
 		if err := validator.ValidateUpstreamURL(c.Upstream); err != nil {
 			return fmt.Errorf("upstream validation failed: %w", err)
 		}
-	} else {
-		for i, upstream := range c.Upstreams {
-			if strings.TrimSpace(upstream.URL) == "" {
-				return fmt.Errorf("upstreams[%d] url is required", i)
-			}
-			if upstream.Weight < 0 {
-				return fmt.Errorf("upstreams[%d] weight must be >= 0", i)
-			}
-			if err := validator.ValidateUpstreamURL(upstream.URL); err != nil {
-				return fmt.Errorf("upstreams[%d] validation failed: %w", i, err)
-			}
+	} else if err := validateUpstreamEndpoints("upstreams", c.Upstreams); err != nil {
+		return err
+	}
+	for i, route := range c.Routes {
+		if strings.TrimSpace(route.Match) == "" {
+			return fmt.Errorf("routes[%d] match is required", i)
+		}
+		if _, err := path.Match(route.Match, ""); err != nil {
+			return fmt.Errorf("routes[%d] match: %w", i, err)
+		}
+		if len(route.Upstreams) == 0 {
+			return fmt.Errorf("routes[%d] requires at least one upstream", i)
+		}
+		if err := validateUpstreamEndpoints(fmt.Sprintf("routes[%d].upstreams", i), route.Upstreams); err != nil {
+			return err
+		}
+		strategy := strings.ToLower(strings.TrimSpace(route.Strategy))
+		if !validStrategy(strategy) {
+			return fmt.Errorf("routes[%d] strategy must be round_robin, random or redundant", i)
+		}
+		if strategy == "redundant" && len(route.Upstreams) < 2 {
+			return fmt.Errorf("routes[%d] strategy redundant needs at least two upstreams", i)
+		}
+	}
+	apps := make(map[string]bool, len(c.Tenants))
+	for i, tenant := range c.Tenants {
+		if err := tenant.validate(fmt.Sprintf("tenants[%d]", i)); err != nil {
+			return err
+		}
+		if apps[tenant.App] {
+			return fmt.Errorf("tenants[%d] app %q is listed twice", i, tenant.App)
 		}
+		apps[tenant.App] = true
+	}
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	if err := c.UpstreamHealthCheck.validate(); err != nil {
+		return err
+	}
+	if c.UpstreamDNSInterval < 0 {
+		return errors.New("upstream_dns_interval must be >= 0")
+	}
+	if err := c.OutlierDetection.validate(); err != nil {
+		return err
+	}
+	if err := c.UpstreamPrewarm.validate(); err != nil {
+		return err
+	}
+	if err := c.EgressShaping.validate(); err != nil {
+		return err
+	}
+	if err := c.UpstreamDialRate.validate("upstream_dial_rate"); err != nil {
+		return err
+	}
+	if err := c.UpstreamBind.validate("upstream_bind"); err != nil {
+		return err
+	}
+	if t := c.IdleTimeouts; t.Read < 0 || t.Write < 0 || t.HandshakeRead < 0 || t.HandshakeWrite < 0 {
+		return errors.New("idle_timeouts must be >= 0")
+	}
+	if t := c.StateTimeouts; t.Handshaking < 0 || t.Dialing < 0 || t.Relaying < 0 {
+		return errors.New("state_timeouts must be >= 0")
+	}
+	if err := c.QoS.validate(); err != nil {
+		return err
+	}
+	if err := c.TCP.Downstream.validate("tcp.downstream"); err != nil {
+		return err
+	}
+	if err := c.TCP.Upstream.validate("tcp.upstream"); err != nil {
+		return err
+	}
+	if c.HappyEyeballs.AttemptDelay < 0 {
+		return errors.New("happy_eyeballs.attempt_delay must be >= 0")
+	}
+	if err := c.PublishLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.DuplicatePublish.validate(); err != nil {
+		return err
+	}
+	if err := validateUpstreamCredentials("upstream_credentials", c.UpstreamCredentials); err != nil {
+		return err
 	}
 	if c.Security.AuthEnabled && len(c.Security.AuthTokens) == 0 {
 		return errors.New("auth_enabled requires at least one auth token")
@@ -172,18 +1015,1132 @@ func (c Config) Validate() error {
 			return errors.New("tls_enabled requires tls_cert and tls_key")
 		}
 	}
-	if c.Transcode.Enabled && strings.TrimSpace(c.Transcode.GOP) != "" {
-		gop := strings.TrimSpace(c.Transcode.GOP)
-		if frames, err := strconv.Atoi(gop); err == nil {
-			if frames <= 0 {
-				return errors.New("transcode.gop must be a positive frame count or duration")
-			}
-		} else if dur, err := time.ParseDuration(gop); err == nil {
-			if dur <= 0 {
-				return errors.New("transcode.gop must be a positive frame count or duration")
-			}
-		} else {
-			return errors.New("transcode.gop must be a positive frame count or duration")
+	if c.Security.OCSPStapling && !c.Security.TLSEnabled {
+		return errors.New("ocsp_stapling requires tls_enabled")
+	}
+	if c.Security.ALPNMux && !c.Security.TLSEnabled {
+		return errors.New("alpn_mux requires tls_enabled")
+	}
+	if c.Security.OCSPRefresh < 0 || c.Security.CertExpiryWarning < 0 {
+		return errors.New("ocsp_refresh and cert_expiry_warning must not be negative")
+	}
+	if err := c.Security.PlaybackTokens.validate(); err != nil {
+		return err
+	}
+	if c.RTMP.MaxMessageSize < 0 || c.RTMP.MaxMessageSize > 0xFFFFFF {
+		return errors.New("rtmp.max_message_size must be between 0 and 16777215")
+	}
+	if c.RTMP.MaxChunkStreams < 0 {
+		return errors.New("rtmp.max_chunk_streams must be >= 0")
+	}
+	if c.RTMP.MaxBufferedBytes < 0 {
+		return errors.New("rtmp.max_buffered_bytes must be >= 0")
+	}
+	if c.RTMP.SessionQueue < 0 {
+		return errors.New("rtmp.session_queue must be >= 0")
+	}
+	if c.RTMPT.Enabled && c.HTTPAddr == "" {
+		return errors.New("rtmpt requires http_addr")
+	}
+	for i, rule := range c.RewriteRules {
+		if rule.Field != "app" && rule.Field != "stream" {
+			return fmt.Errorf("rewrite_rules[%d] field must be app or stream", i)
+		}
+		if rule.Match == "" {
+			return fmt.Errorf("rewrite_rules[%d] match is required", i)
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("rewrite_rules[%d] match: %w", i, err)
+		}
+	}
+	if err := c.Logging.Ship.validate("logging.ship"); err != nil {
+		return err
+	}
+	if err := c.AccessLog.validate(c.Logging.Ship); err != nil {
+		return err
+	}
+	if err := c.DNSResponder.validate(); err != nil {
+		return err
+	}
+	if err := c.Cluster.validate(); err != nil {
+		return err
+	}
+	if err := c.Thumbnails.validate(); err != nil {
+		return err
+	}
+	if err := c.DVR.validate(); err != nil {
+		return err
+	}
+	if err := c.Packager.validate(); err != nil {
+		return err
+	}
+	if err := c.Viewers.validate(); err != nil {
+		return err
+	}
+	if err := c.AVSync.validate(); err != nil {
+		return err
+	}
+	if err := c.StreamPolicy.validate("stream_policy"); err != nil {
+		return err
+	}
+	if c.Metrics.PushGateway != "" {
+		u, err := url.Parse(c.Metrics.PushGateway)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("metrics.push_gateway must be an http(s) URL")
+		}
+	}
+	if err := c.Metrics.StatsD.validate(); err != nil {
+		return err
+	}
+	if err := c.Readiness.validate(c.Transcode.Enabled, c.DVR.Enabled); err != nil {
+		return err
+	}
+	if err := c.Drain.validate(); err != nil {
+		return err
+	}
+	if err := c.Redirect.validate(c.Cluster.Registry.Redis != ""); err != nil {
+		return err
+	}
+	if c.SessionJournal.FlushInterval < 0 {
+		return errors.New("session_journal.flush_interval must be >= 0")
+	}
+	if c.State.SaveInterval < 0 {
+		return errors.New("state.save_interval must be >= 0")
+	}
+	if c.ReconnectGrace < 0 {
+		return errors.New("reconnect_grace must be >= 0")
+	}
+	if c.ReconnectGrace > 0 && !c.Transcode.Enabled {
+		return errors.New("reconnect_grace requires transcode.enabled")
+	}
+	if err := c.Failover.validate(c.Transcode.Enabled); err != nil {
+		return err
+	}
+	if c.Profiling.Enabled && (c.Profiling.SampleRate <= 0 || c.Profiling.SampleRate > 1) {
+		return errors.New("profiling.sample_rate must be in (0, 1]")
+	}
+	if err := c.Transcode.KillSwitch.validate(); err != nil {
+		return err
+	}
+	if err := c.Transcode.Restart.validate(); err != nil {
+		return err
+	}
+	if err := c.Transcode.Slots.validate(); err != nil {
+		return err
+	}
+	if err := c.Transcode.Limits.validate(); err != nil {
+		return err
+	}
+	if err := validateCodecOptions("transcode.video_opts", c.Transcode.VideoOpts); err != nil {
+		return err
+	}
+	if err := validateCodecOptions("transcode.audio_opts", c.Transcode.AudioOpts); err != nil {
+		return err
+	}
+	if err := validateTranscodeShaping(c.Transcode); err != nil {
+		return err
+	}
+	if err := validatePassthrough(c.Transcode); err != nil {
+		return err
+	}
+	if err := validateLoudness(c.Transcode); err != nil {
+		return err
+	}
+	if err := validateRenditions(c.Transcode); err != nil {
+		return err
+	}
+	if c.Transcode.Enabled {
+		if err := validateGOP(c.Transcode.GOP); err != nil {
+			return err
+		}
+	}
+	if err := validateTranscodeMode(c.Transcode); err != nil {
+		return err
+	}
+	if err := c.validateTranscodeProfiles(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateGOP(gop string) error {
+	gop = strings.TrimSpace(gop)
+	if gop == "" {
+		return nil
+	}
+	if frames, err := strconv.Atoi(gop); err == nil {
+		if frames > 0 {
+			return nil
+		}
+	} else if dur, err := time.ParseDuration(gop); err == nil && dur > 0 {
+		return nil
+	}
+	return errors.New("transcode.gop must be a positive frame count or duration")
+}
+
+// validateTranscodeProfiles checks each profile as transcode is checked, and
+// that every rule names a profile. Profile errors are prefixed with the
+// profile's name.
+func (c *Config) validateTranscodeProfiles() error {
+	tp := c.TranscodeProfiles
+	for name, t := range tp.Profiles {
+		if name == "" || name == ProfilePassthrough {
+			return fmt.Errorf("transcode_profiles.profiles name %q is reserved", name)
+		}
+		for _, check := range []func() error{
+			t.Restart.validate,
+			t.Limits.validate,
+			func() error { return validateCodecOptions("transcode.video_opts", t.VideoOpts) },
+			func() error { return validateCodecOptions("transcode.audio_opts", t.AudioOpts) },
+			func() error { return validateTranscodeShaping(t) },
+			func() error { return validatePassthrough(t) },
+			func() error { return validateLoudness(t) },
+			func() error { return validateRenditions(t) },
+			func() error { return validateGOP(t.GOP) },
+			func() error { return validateTranscodeMode(t) },
+		} {
+			if err := check(); err != nil {
+				return fmt.Errorf("transcode_profiles.profiles.%s: %w", name, err)
+			}
+		}
+	}
+	if len(tp.Rules) > 0 && !c.Transcode.Enabled {
+		return errors.New("transcode_profiles.rules requires transcode.enabled; end the rules with a passthrough rule to leave other sessions untranscoded")
+	}
+	for i, rule := range tp.Rules {
+		for field, pattern := range map[string]string{"app": rule.App, "stream": rule.Stream} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("transcode_profiles.rules[%d] %s: %w", i, field, err)
+			}
+		}
+		if _, ok := tp.Profiles[rule.Profile]; !ok && rule.Profile != ProfilePassthrough {
+			return fmt.Errorf("transcode_profiles.rules[%d] profile must be passthrough or a profiles name", i)
+		}
+	}
+	return nil
+}
+
+// validStrategy reports whether strategy, lower-cased, names an upstream
+// selection strategy. Empty means the default.
+func validStrategy(strategy string) bool {
+	switch strategy {
+	case "", "round_robin", "random", "redundant":
+		return true
+	}
+	return false
+}
+
+func validateUpstreamEndpoints(field string, endpoints []UpstreamEndpoint) error {
+	for i, upstream := range endpoints {
+		if strings.TrimSpace(upstream.URL) == "" {
+			return fmt.Errorf("%s[%d] url is required", field, i)
+		}
+		if upstream.Weight < 0 {
+			return fmt.Errorf("%s[%d] weight must be >= 0", field, i)
+		}
+		if err := validator.ValidateUpstreamURL(upstream.URL); err != nil {
+			return fmt.Errorf("%s[%d] validation failed: %w", field, i, err)
+		}
+		if upstream.EgressShaping != nil {
+			if err := upstream.EgressShaping.validate(); err != nil {
+				return fmt.Errorf("%s[%d] %w", field, i, err)
+			}
+		}
+		if upstream.DialRate != nil {
+			if err := upstream.DialRate.validate(fmt.Sprintf("%s[%d].dial_rate", field, i)); err != nil {
+				return err
+			}
+		}
+		if upstream.DSCP < 0 || upstream.DSCP > 63 {
+			return fmt.Errorf("%s[%d].dscp must be between 0 and 63", field, i)
+		}
+		if upstream.Bind != nil {
+			if err := upstream.Bind.validate(fmt.Sprintf("%s[%d].bind", field, i)); err != nil {
+				return err
+			}
+		}
+		if err := validateUpstreamCredentials(fmt.Sprintf("%s[%d].credentials", field, i), upstream.Credentials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateUpstreamCredentials(field string, creds []UpstreamCredential) error {
+	names := make(map[string]bool, len(creds))
+	for i, cred := range creds {
+		if strings.TrimSpace(cred.Query) == "" {
+			return fmt.Errorf("%s[%d] query is required", field, i)
+		}
+		if _, err := url.ParseQuery(cred.Query); err != nil {
+			return fmt.Errorf("%s[%d] query: %w", field, i, err)
+		}
+		if cred.Name != "" {
+			if names[cred.Name] {
+				return fmt.Errorf("%s[%d] duplicate name %q", field, i, cred.Name)
+			}
+			names[cred.Name] = true
+		}
+	}
+	return nil
+}
+
+func (h UpstreamHealthCheckConfig) validate() error {
+	switch h.LogMode {
+	case "", "every_failure", "state_change":
+	default:
+		return fmt.Errorf("upstream_health_check.log_mode %q must be every_failure or state_change", h.LogMode)
+	}
+	if h.ReminderIntervalSec < 0 {
+		return errors.New("upstream_health_check.reminder_interval_sec must be >= 0")
+	}
+	return nil
+}
+
+func (o OutlierDetectionConfig) validate() error {
+	if o.Window < 0 || o.SlowThreshold < 0 || o.BaseEjection < 0 || o.MaxEjection < 0 || o.Ramp < 0 {
+		return errors.New("outlier_detection durations must be >= 0")
+	}
+	if o.MinRequests < 0 {
+		return errors.New("outlier_detection.min_requests must be >= 0")
+	}
+	if o.FailureRate < 0 || o.FailureRate > 1 {
+		return errors.New("outlier_detection.failure_rate must be between 0 and 1")
+	}
+	if o.BaseEjection > 0 && o.MaxEjection > 0 && o.MaxEjection < o.BaseEjection {
+		return errors.New("outlier_detection.max_ejection must be >= base_ejection")
+	}
+	return nil
+}
+
+func (w UpstreamPrewarmConfig) validate() error {
+	if w.PerUpstream < 0 {
+		return errors.New("upstream_prewarm.per_upstream must be >= 0")
+	}
+	if w.MaxIdle < 0 || w.Interval < 0 {
+		return errors.New("upstream_prewarm durations must be >= 0")
+	}
+	return nil
+}
+
+func (p PublishLimitConfig) validate() error {
+	if p.MaxStreamsPerIP < 0 {
+		return errors.New("publish_limit.max_streams_per_ip must be >= 0")
+	}
+	if p.MaxStreamsPerToken < 0 {
+		return errors.New("publish_limit.max_streams_per_token must be >= 0")
+	}
+	return nil
+}
+
+func (a AVSyncConfig) validate() error {
+	if a.DriftThreshold < 0 {
+		return errors.New("av_sync.drift_threshold must be >= 0")
+	}
+	if a.GapThreshold < 0 {
+		return errors.New("av_sync.gap_threshold must be >= 0")
+	}
+	return nil
+}
+
+func (p StreamPolicyConfig) validate(field string) error {
+	switch p.Action {
+	case "", StreamPolicyWarn, StreamPolicyReject:
+	default:
+		return fmt.Errorf("%s.action must be warn or reject", field)
+	}
+	if p.MaxKeyframeInterval < 0 {
+		return fmt.Errorf("%s.max_keyframe_interval must be >= 0", field)
+	}
+	if p.MaxWidth < 0 || p.MaxHeight < 0 || p.MaxBitrateKbps < 0 {
+		return fmt.Errorf("%s limits must be >= 0", field)
+	}
+	for i, codec := range p.VideoCodecs {
+		if strings.TrimSpace(codec) == "" {
+			return fmt.Errorf("%s.video_codecs[%d] is empty", field, i)
+		}
+	}
+	for i, codec := range p.AudioCodecs {
+		if strings.TrimSpace(codec) == "" {
+			return fmt.Errorf("%s.audio_codecs[%d] is empty", field, i)
+		}
+	}
+	return nil
+}
+
+func (d DuplicatePublishConfig) validate() error {
+	switch d.Policy {
+	case "", DuplicatePublishAllow, DuplicatePublishReject, DuplicatePublishKick:
+	case DuplicatePublishTakeover:
+		if d.TakeoverToken == "" {
+			return errors.New("duplicate_publish.takeover_token is required by the takeover policy")
+		}
+	default:
+		return fmt.Errorf("duplicate_publish.policy %q must be allow, reject, kick or takeover", d.Policy)
+	}
+	if d.Wait < 0 {
+		return errors.New("duplicate_publish.wait must be >= 0")
+	}
+	return nil
+}
+
+func (e EgressShapingConfig) validate() error {
+	if e.RateBytesPerSec < 0 {
+		return errors.New("egress_shaping.rate_bytes_per_sec must be >= 0")
+	}
+	if e.BurstBytes < 0 {
+		return errors.New("egress_shaping.burst_bytes must be >= 0")
+	}
+	return nil
+}
+
+func (q QoSConfig) validate() error {
+	fields := []struct {
+		name  string
+		value int
+	}{{"dscp", q.DSCP}, {"rtmp_listener", q.RTMPListener}, {"http_listener", q.HTTPListener}, {"upstream", q.Upstream}}
+	for _, f := range fields {
+		if f.value < 0 || f.value > 63 {
+			return fmt.Errorf("qos.%s must be between 0 and 63", f.name)
+		}
+	}
+	return nil
+}
+
+// Class returns the DSCP class for a socket whose own setting is v.
+func (q QoSConfig) Class(v int) int {
+	if v != 0 {
+		return v
+	}
+	return q.DSCP
+}
+
+// UnixSocketFileMode returns the parsed unix_socket_mode, 0 when unset.
+func (c Config) UnixSocketFileMode() (os.FileMode, error) {
+	if c.UnixSocketMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode == 0 || mode > 0o777 {
+		return 0, fmt.Errorf("unix_socket_mode %q must be an octal permission such as 0660", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (k TCPKeepaliveConfig) validate(field string) error {
+	if k.Idle < 0 || k.Interval < 0 || k.UserTimeout < 0 {
+		return fmt.Errorf("%s durations must be >= 0", field)
+	}
+	if k.Count < 0 {
+		return fmt.Errorf("%s.count must be >= 0", field)
+	}
+	return nil
+}
+
+func (b UpstreamBindConfig) validate(field string) error {
+	if b.SourceIP != "" && net.ParseIP(b.SourceIP) == nil {
+		return fmt.Errorf("%s.source_ip %q is not an IP address", field, b.SourceIP)
+	}
+	return nil
+}
+
+func (d DialRateConfig) validate(field string) error {
+	if d.PerSecond < 0 {
+		return fmt.Errorf("%s.per_second must be >= 0", field)
+	}
+	if d.Burst < 0 {
+		return fmt.Errorf("%s.burst must be >= 0", field)
+	}
+	if d.MaxWait < 0 {
+		return fmt.Errorf("%s.max_wait must be >= 0", field)
+	}
+	if d.Jitter < 0 || d.Jitter > 1 {
+		return fmt.Errorf("%s.jitter must be between 0 and 1", field)
+	}
+	return nil
+}
+
+func (f FailoverConfig) validate(transcodeEnabled bool) error {
+	if len(f.Pairs) == 0 {
+		return nil
+	}
+	if !transcodeEnabled {
+		return errors.New("failover requires transcode.enabled")
+	}
+	if f.LossTimeout < 0 {
+		return errors.New("failover.loss_timeout must be >= 0")
+	}
+	seen := make(map[string]bool, len(f.Pairs)*2)
+	for i, p := range f.Pairs {
+		primary, backup := strings.TrimSpace(p.Primary), strings.TrimSpace(p.Backup)
+		if primary == "" || backup == "" {
+			return fmt.Errorf("failover.pairs[%d] requires primary and backup", i)
+		}
+		if primary == backup {
+			return fmt.Errorf("failover.pairs[%d] primary and backup must differ", i)
+		}
+		for _, key := range []string{primary, backup} {
+			if seen[key] {
+				return fmt.Errorf("failover.pairs[%d] stream key %q is already paired", i, key)
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
+
+func (d DNSResponderConfig) validate() error {
+	if d.Listen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(d.Listen); err != nil {
+		return fmt.Errorf("dns_responder.listen: %w", err)
+	}
+	if strings.Trim(d.Name, ".") == "" {
+		return errors.New("dns_responder.name is required")
+	}
+	if len(d.Addresses) == 0 {
+		return errors.New("dns_responder.addresses must list at least one IPv4 address")
+	}
+	for i, a := range d.Addresses {
+		if ip := net.ParseIP(a); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("dns_responder.addresses[%d] %q is not an IPv4 address", i, a)
+		}
+	}
+	if d.TTL < 0 {
+		return errors.New("dns_responder.ttl must be >= 0")
+	}
+	return nil
+}
+
+func (t ThumbnailConfig) validate() error {
+	if t.Interval < 0 {
+		return errors.New("thumbnails.interval must be >= 0")
+	}
+	if t.Width < 0 {
+		return errors.New("thumbnails.width must be >= 0")
+	}
+	if t.Quality != 0 && (t.Quality < 2 || t.Quality > 31) {
+		return errors.New("thumbnails.quality must be between 2 and 31")
+	}
+	return nil
+}
+
+func (p PlaybackTokenConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if len(p.Secrets) == 0 {
+		return errors.New("security.playback_tokens requires at least one secret")
+	}
+	for i, secret := range p.Secrets {
+		if key, err := base64.StdEncoding.DecodeString(secret); err != nil || len(key) < 16 {
+			return fmt.Errorf("security.playback_tokens.secrets[%d] must be base64 of at least 16 bytes", i)
+		}
+	}
+	if p.TTL < 0 {
+		return errors.New("security.playback_tokens.ttl must be >= 0")
+	}
+	return nil
+}
+
+func (v ViewersConfig) validate() error {
+	if v.MaxPerStream < 0 {
+		return errors.New("viewers.max_per_stream must be >= 0")
+	}
+	if v.SegmentIdle < 0 {
+		return errors.New("viewers.segment_idle must be >= 0")
+	}
+	return nil
+}
+
+func (p PackagerConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if !p.HLS && !p.DASH {
+		return errors.New("packager must enable hls, dash or both")
+	}
+	if p.SegmentDuration < 0 {
+		return errors.New("packager.segment_duration must be >= 0")
+	}
+	if p.PartDuration < 0 {
+		return errors.New("packager.part_duration must be >= 0")
+	}
+	segment := p.SegmentDuration
+	if segment == 0 {
+		segment = Duration(4 * time.Second)
+	}
+	if p.PartDuration >= segment {
+		return errors.New("packager.part_duration must be shorter than packager.segment_duration")
+	}
+	if p.MaxSegments < 0 {
+		return errors.New("packager.max_segments must be >= 0")
+	}
+	if p.MaxBytes < 0 {
+		return errors.New("packager.max_bytes must be >= 0")
+	}
+	return nil
+}
+
+func (t TenantConfig) validate(field string) error {
+	if strings.TrimSpace(t.App) == "" {
+		return fmt.Errorf("%s app is required", field)
+	}
+	if strings.ContainsAny(t.App, "/?") {
+		return fmt.Errorf("%s app %q must be a bare app name", field, t.App)
+	}
+	for i, token := range t.AuthTokens {
+		if strings.TrimSpace(token) == "" {
+			return fmt.Errorf("%s.auth_tokens[%d] is empty", field, i)
+		}
+	}
+	if len(t.Upstreams) > 0 {
+		if err := validateUpstreamEndpoints(field+".upstreams", t.Upstreams); err != nil {
+			return err
+		}
+	}
+	strategy := strings.ToLower(strings.TrimSpace(t.Strategy))
+	if !validStrategy(strategy) {
+		return fmt.Errorf("%s strategy must be round_robin, random or redundant", field)
+	}
+	if strategy == "redundant" && len(t.Upstreams) < 2 {
+		return fmt.Errorf("%s strategy redundant needs at least two upstreams", field)
+	}
+	if t.ConnectionLimit.MaxTotal < 0 || t.ConnectionLimit.MaxPerIP < 0 {
+		return fmt.Errorf("%s.connection_limit values must be >= 0", field)
+	}
+	if t.RateLimit.Enabled && (t.RateLimit.RequestsPerSec <= 0 || t.RateLimit.Burst <= 0) {
+		return fmt.Errorf("%s.rate_limit requires requests_per_sec and burst > 0", field)
+	}
+	if t.StreamPolicy != nil {
+		if err := t.StreamPolicy.validate(field + ".stream_policy"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r RetryConfig) validate() error {
+	if r.BudgetRatio < 0 {
+		return errors.New("retry.budget_ratio must be >= 0")
+	}
+	if r.BudgetMinRetries < 0 {
+		return errors.New("retry.budget_min_retries must be >= 0")
+	}
+	if r.BudgetWindow < 0 {
+		return errors.New("retry.budget_window must be >= 0")
+	}
+	if r.PerAttemptTimeout < 0 {
+		return errors.New("retry.per_attempt_timeout must be >= 0")
+	}
+	if r.Deadline < 0 {
+		return errors.New("retry.deadline must be >= 0")
+	}
+	return nil
+}
+
+func (d DVRConfig) validate() error {
+	if d.Enabled && d.Dir == "" {
+		return errors.New("dvr.dir is required when dvr is enabled")
+	}
+	if d.Window < 0 {
+		return errors.New("dvr.window must be >= 0")
+	}
+	if d.Segment < 0 {
+		return errors.New("dvr.segment must be >= 0")
+	}
+	if d.MaxBytes < 0 {
+		return errors.New("dvr.max_bytes must be >= 0")
+	}
+	if err := d.Encryption.validate(); err != nil {
+		return err
+	}
+	return d.PostProcess.validate()
+}
+
+func (p DVRPostProcessConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.WorkDir != "" && !filepath.IsAbs(p.WorkDir) {
+		return errors.New("dvr.post_process.work_dir must be an absolute path")
+	}
+	if p.Concurrency < 0 {
+		return errors.New("dvr.post_process.concurrency must be >= 0")
+	}
+	if p.Queue < 0 {
+		return errors.New("dvr.post_process.queue must be >= 0")
+	}
+	if p.MaxAttempts < 0 {
+		return errors.New("dvr.post_process.max_attempts must be >= 0")
+	}
+	if p.RetryBackoff < 0 {
+		return errors.New("dvr.post_process.retry_backoff must be >= 0")
+	}
+	if p.StepTimeout < 0 {
+		return errors.New("dvr.post_process.step_timeout must be >= 0")
+	}
+	if len(p.Steps) == 0 {
+		return errors.New("dvr.post_process.steps must not be empty")
+	}
+	for i, step := range p.Steps {
+		field := fmt.Sprintf("dvr.post_process.steps[%d]", i)
+		switch step.Type {
+		case PostProcessRemuxMP4:
+		case PostProcessThumbnail:
+			if step.Width < 0 {
+				return fmt.Errorf("%s.width must be >= 0", field)
+			}
+		case PostProcessWebhook:
+			u, err := url.Parse(step.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s.url must be an http or https URL", field)
+			}
+		case PostProcessMove:
+			if !filepath.IsAbs(step.Dir) {
+				return fmt.Errorf("%s.dir must be an absolute path", field)
+			}
+		default:
+			return fmt.Errorf("%s.type must be one of remux_mp4, thumbnail, webhook, move", field)
+		}
+	}
+	return nil
+}
+
+func (e DVREncryptionConfig) validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	sources := 0
+	for _, set := range []bool{e.Key != "", e.KeyFile != "", len(e.KeyCommand) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("dvr.encryption needs exactly one of key, key_file or key_command")
+	}
+	if len(e.KeyID) > 255 {
+		return errors.New("dvr.encryption.key_id must be at most 255 bytes")
+	}
+	if e.Key != "" {
+		secret, err := base64.StdEncoding.DecodeString(e.Key)
+		if err != nil || (len(secret) != 16 && len(secret) != 24 && len(secret) != 32) {
+			return errors.New("dvr.encryption.key must be a base64 16, 24 or 32 byte AES key")
+		}
+	}
+	return nil
+}
+
+func (c ClusterConfig) validate() error {
+	if c.AdvertiseURL != "" && !isHTTPURL(c.AdvertiseURL) {
+		return fmt.Errorf("cluster.advertise_url %q must be an http(s) URL", c.AdvertiseURL)
+	}
+	for i, peer := range c.Peers {
+		if !isHTTPURL(peer) {
+			return fmt.Errorf("cluster.peers[%d] %q must be an http(s) URL", i, peer)
+		}
+	}
+	if c.AdvertiseRTMP != "" && !isRTMPURL(c.AdvertiseRTMP) {
+		return fmt.Errorf("cluster.advertise_rtmp %q must be an rtmp(s) URL", c.AdvertiseRTMP)
+	}
+	if c.RouteCacheTTL < 0 {
+		return errors.New("cluster.route_cache_ttl must be >= 0")
+	}
+	if r := c.Registry; r.Redis != "" {
+		u, err := url.Parse(r.Redis)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return errors.New("cluster.registry.redis must be a redis:// or rediss:// URL")
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			if n, err := strconv.Atoi(db); err != nil || n < 0 {
+				return fmt.Errorf("cluster.registry.redis database %q must be a number", db)
+			}
+		}
+		if c.AdvertiseURL == "" {
+			return errors.New("cluster.registry requires cluster.advertise_url")
+		}
+	}
+	if c.Registry.TTL < 0 {
+		return errors.New("cluster.registry.ttl must be >= 0")
+	}
+	switch c.PlayRouting {
+	case "", PlayRoutingRedirect, PlayRoutingProxy:
+	default:
+		return fmt.Errorf("cluster.play_routing %q must be redirect or proxy", c.PlayRouting)
+	}
+	return nil
+}
+
+func isRTMPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "rtmp" || u.Scheme == "rtmps") && u.Host != ""
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (l LogShipConfig) validate(field string) error {
+	switch l.Type {
+	case "":
+		return nil
+	case "loki":
+		u, err := url.Parse(l.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.url must be an http(s) Loki push URL", field)
+		}
+	case "syslog":
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("%s.address: %w", field, err)
+		}
+	default:
+		return fmt.Errorf("%s.type must be loki or syslog", field)
+	}
+	if l.BufferMaxBytes < 0 {
+		return fmt.Errorf("%s.buffer_max_bytes must be >= 0", field)
+	}
+	if l.BatchSize < 0 {
+		return fmt.Errorf("%s.batch_size must be >= 0", field)
+	}
+	if l.FlushInterval < 0 {
+		return fmt.Errorf("%s.flush_interval must be >= 0", field)
+	}
+	return nil
+}
+
+func (k TranscodeKillSwitchConfig) validate() error {
+	switch k.Fallback {
+	case "", "passthrough", "reject":
+	default:
+		return errors.New("transcode.kill_switch.fallback must be passthrough or reject")
+	}
+	for i, tenant := range k.DisabledTenants {
+		if strings.TrimSpace(tenant) == "" {
+			return fmt.Errorf("transcode.kill_switch.disabled_tenants[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+func (r TranscodeRestartConfig) validate() error {
+	if r.MaxRestarts < 0 {
+		return errors.New("transcode.restart.max_restarts must be >= 0")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 || r.ResetAfter < 0 {
+		return errors.New("transcode.restart durations must be >= 0")
+	}
+	if r.Backoff > 0 && r.MaxBackoff > 0 && r.MaxBackoff < r.Backoff {
+		return errors.New("transcode.restart.max_backoff must be >= backoff")
+	}
+	return nil
+}
+
+func (s TranscodeSlotsConfig) validate() error {
+	if s.MaxSessions < 0 {
+		return errors.New("transcode.slots.max_sessions must be >= 0")
+	}
+	switch s.Overflow {
+	case "", "passthrough", "reject":
+	default:
+		return errors.New("transcode.slots.overflow must be passthrough or reject")
+	}
+	return nil
+}
+
+func (l TranscodeLimitsConfig) validate() error {
+	if l.CPU < 0 {
+		return errors.New("transcode.limits.cpu must be >= 0")
+	}
+	if l.MemoryBytes < 0 {
+		return errors.New("transcode.limits.memory_bytes must be >= 0")
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return errors.New("transcode.limits.nice must be between 0 and 19")
+	}
+	if l.Cgroup != "" && !filepath.IsAbs(l.Cgroup) {
+		return errors.New("transcode.limits.cgroup must be an absolute path")
+	}
+	if l.Cgroup != "" && l.CPU == 0 && l.MemoryBytes == 0 {
+		return errors.New("transcode.limits.cgroup requires cpu or memory_bytes")
+	}
+	return nil
+}
+
+// validateCodecOptions rejects keys that cannot be a single encoder option
+// name, so values can never smuggle extra flags onto the ffmpeg command line.
+func validateTranscodeShaping(t TranscodeConfig) error {
+	if t.Width < 0 || t.Height < 0 || t.Width%2 != 0 || t.Height%2 != 0 {
+		return errors.New("transcode.width and transcode.height must be even and >= 0")
+	}
+	if t.Scale != "" {
+		if t.Width > 0 || t.Height > 0 {
+			return errors.New("transcode.scale cannot be combined with width or height")
+		}
+		if strings.ContainsAny(t.Scale, ",;[]'\"\\ \t\n") {
+			return errors.New("transcode.scale must be scale filter arguments only, e.g. iw/2:-2")
+		}
+	}
+	if t.FPS < 0 {
+		return errors.New("transcode.fps must be >= 0")
+	}
+	for field, rate := range map[string]string{
+		"video_bitrate": t.VideoBitrate,
+		"video_maxrate": t.VideoMaxRate,
+		"video_bufsize": t.VideoBufSize,
+		"audio_bitrate": t.AudioBitrate,
+	} {
+		if rate == "" {
+			continue
+		}
+		if _, err := ParseBitrate(rate); err != nil {
+			return fmt.Errorf("transcode.%s: %w", field, err)
+		}
+	}
+	if t.AudioSampleRate < 0 || t.AudioSampleRate > 192000 {
+		return errors.New("transcode.audio_sample_rate must be between 0 and 192000")
+	}
+	if len(t.Renditions) > 0 && (t.Width > 0 || t.Height > 0 || t.Scale != "" || t.FPS > 0 ||
+		t.VideoBitrate != "" || t.VideoMaxRate != "" || t.VideoBufSize != "") {
+		return errors.New("transcode video size, fps and bitrate are set per rendition when renditions are used")
+	}
+	if strings.EqualFold(strings.TrimSpace(t.VideoCodec), "copy") && (t.Width > 0 || t.Height > 0 || t.Scale != "" || t.FPS > 0) {
+		return errors.New("transcode.video_codec copy cannot scale or change fps")
+	}
+	return nil
+}
+
+// deniedFFmpegFlags would let passthrough arguments read or write local
+// files, add inputs or outputs, or replace the mapping and filters the relay
+// builds itself. Stream specifiers are stripped before the lookup.
+var deniedFFmpegFlags = map[string]bool{
+	"-i": true, "-y": true, "-n": true, "-map": true,
+	"-vf": true, "-af": true, "-filter": true, "-lavfi": true,
+	"-filter_complex": true, "-filter_script": true, "-filter_complex_script": true,
+	"-attach": true, "-dump_attachment": true, "-passlogfile": true,
+	"-vstats": true, "-vstats_file": true, "-report": true, "-progress": true,
+	"-protocol_whitelist": true, "-protocol_blacklist": true,
+}
+
+// deniedFilters read files or open sockets.
+var deniedFilters = map[string]bool{
+	"movie": true, "amovie": true, "sendcmd": true, "asendcmd": true,
+	"zmq": true, "azmq": true, "subtitles": true, "ass": true,
+}
+
+// filterFileOption matches options such as drawtext's textfile= or fontfile=.
+var filterFileOption = regexp.MustCompile(`(?i)(^|[:=,])(text|font)?file(name)?=`)
+
+func validatePassthrough(t TranscodeConfig) error {
+	if (len(t.ExtraInputArgs) > 0 || len(t.ExtraOutputArgs) > 0) && strings.EqualFold(strings.TrimSpace(t.Backend), "libav") {
+		return errors.New("transcode extra_input_args and extra_output_args need the ffmpeg backend")
+	}
+	if err := validateExtraArgs("transcode.extra_input_args", t.ExtraInputArgs); err != nil {
+		return err
+	}
+	if err := validateExtraArgs("transcode.extra_output_args", t.ExtraOutputArgs); err != nil {
+		return err
+	}
+	for _, f := range t.ExtraOutputArgs {
+		if f == "-f" {
+			return errors.New("transcode.extra_output_args cannot change the output format")
+		}
+	}
+	if err := validateFilterChain("transcode.video_filter", t.VideoFilter); err != nil {
+		return err
+	}
+	if err := validateFilterChain("transcode.audio_filter", t.AudioFilter); err != nil {
+		return err
+	}
+	if t.VideoFilter != "" && strings.EqualFold(strings.TrimSpace(t.VideoCodec), "copy") {
+		return errors.New("transcode.video_filter needs a video encoder; video_codec cannot be copy")
+	}
+	if t.AudioFilter != "" && strings.EqualFold(strings.TrimSpace(t.AudioCodec), "copy") {
+		return errors.New("transcode.audio_filter needs an audio encoder; audio_codec cannot be copy")
+	}
+	return nil
+}
+
+// validateExtraArgs accepts flag/value lists. Values that look like paths or
+// URLs are refused, since ffmpeg treats a stray one as another output.
+func validateExtraArgs(field string, args []string) error {
+	prevValue := true // a list must start with a flag
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") && len(a) > 1 {
+			name, _, _ := strings.Cut(a, ":")
+			if deniedFFmpegFlags[name] || strings.HasPrefix(name, "-/") || strings.HasPrefix(name, "-stats_") {
+				return fmt.Errorf("%s: %s is not allowed", field, a)
+			}
+			prevValue = false
+			continue
+		}
+		if prevValue {
+			return fmt.Errorf("%s: %q does not follow a flag", field, a)
+		}
+		if strings.Contains(a, "://") || strings.HasPrefix(a, "/") || strings.HasPrefix(a, ".") ||
+			strings.HasPrefix(a, "~") || strings.ContainsAny(a, "\\\n") {
+			return fmt.Errorf("%s: %q looks like a path or URL", field, a)
+		}
+		prevValue = true
+	}
+	return nil
+}
+
+// validateFilterChain accepts a single linear chain without labels, so it can
+// be joined with the filters the relay adds.
+func validateFilterChain(field, chain string) error {
+	if chain == "" {
+		return nil
+	}
+	if strings.ContainsAny(chain, ";[]\n") {
+		return fmt.Errorf("%s must be a single filter chain without labels", field)
+	}
+	if filterFileOption.MatchString(chain) {
+		return fmt.Errorf("%s cannot reference files", field)
+	}
+	for _, f := range strings.Split(chain, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(f), "=")
+		if name == "" {
+			return fmt.Errorf("%s has an empty filter", field)
+		}
+		if deniedFilters[name] {
+			return fmt.Errorf("%s: filter %s is not allowed", field, name)
+		}
+	}
+	return nil
+}
+
+func validateLoudness(t TranscodeConfig) error {
+	l := t.Loudness
+	switch l.Filter {
+	case "":
+		if l.TargetLUFS != 0 || l.TruePeak != 0 || l.LRA != 0 {
+			return errors.New("transcode.loudness targets need a filter")
+		}
+		return nil
+	case "loudnorm", "dynaudnorm":
+	default:
+		return errors.New("transcode.loudness.filter must be loudnorm or dynaudnorm")
+	}
+	if strings.EqualFold(strings.TrimSpace(t.AudioCodec), "copy") {
+		return errors.New("transcode.loudness needs an audio encoder; audio_codec cannot be copy")
+	}
+	if l.TargetLUFS != 0 && (l.TargetLUFS < -70 || l.TargetLUFS > -5) {
+		return errors.New("transcode.loudness.target_lufs must be between -70 and -5")
+	}
+	if l.Filter == "dynaudnorm" && (l.TruePeak != 0 || l.LRA != 0) {
+		return errors.New("transcode.loudness true_peak and lra only apply to loudnorm")
+	}
+	if l.TruePeak < -9 || l.TruePeak > 0 {
+		return errors.New("transcode.loudness.true_peak must be between -9 and 0")
+	}
+	if l.LRA != 0 && (l.LRA < 1 || l.LRA > 50) {
+		return errors.New("transcode.loudness.lra must be between 1 and 50")
+	}
+	return nil
+}
+
+func validateRenditions(t TranscodeConfig) error {
+	if len(t.Renditions) > 0 && strings.EqualFold(strings.TrimSpace(t.VideoCodec), "copy") {
+		return errors.New("transcode.renditions need a video encoder; video_codec cannot be copy")
+	}
+	seen := make(map[string]bool, len(t.Renditions))
+	for i, r := range t.Renditions {
+		if r.Name == "" || strings.Trim(r.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") != "" {
+			return fmt.Errorf("transcode.renditions[%d] name must be non-empty letters, digits, _ or -", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("transcode.renditions[%d] name %q is repeated", i, r.Name)
+		}
+		seen[r.Name] = true
+		if r.Width < 0 || r.Height < 0 || r.Width%2 != 0 || r.Height%2 != 0 {
+			return fmt.Errorf("transcode.renditions[%d] width and height must be even and >= 0", i)
+		}
+		if r.FPS < 0 {
+			return fmt.Errorf("transcode.renditions[%d] fps must be >= 0", i)
+		}
+		if r.VideoBitrate != "" {
+			if _, err := ParseBitrate(r.VideoBitrate); err != nil {
+				return fmt.Errorf("transcode.renditions[%d] video_bitrate: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTranscodeMode(t TranscodeConfig) error {
+	switch t.Mode {
+	case "", TranscodeModeAlways:
+		if len(t.AcceptVideo) > 0 || len(t.AcceptAudio) > 0 {
+			return errors.New("transcode accept_video and accept_audio need mode smart")
+		}
+		return nil
+	case TranscodeModeSmart:
+	default:
+		return errors.New("transcode.mode must be always or smart")
+	}
+	video, audio := strings.TrimSpace(t.VideoCodec), strings.TrimSpace(t.AudioCodec)
+	if len(t.AcceptVideo) == 0 && !strings.EqualFold(video, "copy") && EncoderCodec(video, "libx264") == "" {
+		return fmt.Errorf("transcode.accept_video is required in smart mode with video_codec %q", video)
+	}
+	if len(t.AcceptAudio) == 0 && !strings.EqualFold(audio, "copy") && EncoderCodec(audio, "aac") == "" {
+		return fmt.Errorf("transcode.accept_audio is required in smart mode with audio_codec %q", audio)
+	}
+	return nil
+}
+
+// EncoderCodec names the codec an ffmpeg encoder produces, as the relay
+// reports publishers' codecs, e.g. "h264" for libx264 or h264_nvenc. An
+// empty encoder is taken as def. It returns "" for encoders it does not
+// know, and for copy.
+func EncoderCodec(encoder, def string) string {
+	e := strings.ToLower(strings.TrimSpace(encoder))
+	if e == "" {
+		e = def
+	}
+	switch {
+	case strings.Contains(e, "264"):
+		return "h264"
+	case strings.Contains(e, "265"), strings.Contains(e, "hevc"):
+		return "hevc"
+	case strings.Contains(e, "av1"), strings.Contains(e, "aom"):
+		return "av1"
+	case strings.Contains(e, "vp9"):
+		return "vp9"
+	case strings.Contains(e, "aac"):
+		return "aac"
+	case strings.Contains(e, "mp3"):
+		return "mp3"
+	case strings.Contains(e, "opus"):
+		return "opus"
+	}
+	return ""
+}
+
+// ParseBitrate parses a rate in bits per second with an optional k or M
+// suffix, as ffmpeg accepts it: "800k", "2.5M" or "64000".
+func ParseBitrate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		mult, s = 1e3, s[:len(s)-1]
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1e6, s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, errors.New("must be a positive rate such as 2500k or 2.5M")
+	}
+	return int64(v * mult), nil
+}
+
+func validateCodecOptions(field string, opts map[string]string) error {
+	for k := range opts {
+		if k == "" || strings.HasPrefix(k, "-") || strings.ContainsAny(k, " \t\n:=") {
+			return fmt.Errorf("%s key %q is not a valid option name", field, k)
 		}
 	}
 	return nil
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -84,6 +85,63 @@ func TestValidateTLSConfig(t *testing.T) {
 	}
 }
 
+func TestValidateOCSPStapling(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Security.OCSPStapling = true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected ocsp_stapling without tls to be rejected")
+	}
+
+	cfg.Security.TLSEnabled = true
+	cfg.Security.TLSCert = "cert.pem"
+	cfg.Security.TLSKey = "key.pem"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected ocsp stapling to validate, got %v", err)
+	}
+
+	cfg.Security.OCSPRefresh = Duration(-time.Minute)
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative ocsp_refresh to be rejected")
+	}
+}
+
+func TestValidateALPNMux(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Security.ALPNMux = true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected alpn_mux without tls to be rejected")
+	}
+
+	cfg.Security.TLSEnabled = true
+	cfg.Security.TLSCert = "cert.pem"
+	cfg.Security.TLSKey = "key.pem"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected alpn_mux to validate, got %v", err)
+	}
+}
+
+func TestValidateUpstreamCredentials(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.UpstreamCredentials = []UpstreamCredential{{Name: "new", Query: "token=b"}, {Name: "old", Query: "token=a"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected credentials to validate, got %v", err)
+	}
+
+	cfg.UpstreamCredentials[1].Name = "new"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected duplicate credential names to fail validation")
+	}
+
+	cfg.UpstreamCredentials = nil
+	cfg.Upstreams = []UpstreamEndpoint{{URL: "rtmp://example.com/app", Credentials: []UpstreamCredential{{Query: "%zz"}}}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an unparseable endpoint credential to fail validation")
+	}
+}
+
 func TestValidateUpstreamsList(t *testing.T) {
 	cfg := Default()
 	cfg.Upstream = ""
@@ -117,3 +175,1140 @@ func TestValidateUpstreamStrategy(t *testing.T) {
 		t.Fatal("expected invalid upstream_strategy to fail validation")
 	}
 }
+
+func TestValidateRedundantStrategy(t *testing.T) {
+	cfg := Default()
+	cfg.UpstreamStrategy = "redundant"
+	cfg.Upstreams = []UpstreamEndpoint{{URL: "rtmp://a.example.com/live"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "at least two upstreams") {
+		t.Fatalf("expected a one-leg redundant pool to fail validation, got %v", err)
+	}
+	cfg.Upstreams = append(cfg.Upstreams, UpstreamEndpoint{URL: "rtmp://b.example.com/live"})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected a two-leg redundant pool to validate, got %v", err)
+	}
+
+	cfg.Routes = []RouteConfig{{
+		Match:     "contrib/*",
+		Upstreams: []UpstreamEndpoint{{URL: "rtmp://c.example.com/live"}},
+		Strategy:  "redundant",
+	}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "routes[0] strategy redundant") {
+		t.Fatalf("expected a one-leg redundant route to fail validation, got %v", err)
+	}
+}
+
+func TestValidateDrainCode(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Drain.Code = "NetConnection.Connect.Closed"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected drain code to validate, got %v", err)
+	}
+	cfg.Drain.Code = "Rejected"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "drain.code") {
+		t.Fatalf("expected a non-NetConnection drain code to fail validation, got %v", err)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Routes = []RouteConfig{{
+		Match:     "live/*",
+		Upstreams: []UpstreamEndpoint{{URL: "rtmp://pool-a.example.com/live/"}},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected route to validate, got %v", err)
+	}
+
+	cfg.Routes[0].Match = "live/["
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected malformed match pattern to fail validation")
+	}
+
+	cfg.Routes[0].Match = "live/*"
+	cfg.Routes[0].Upstreams = nil
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected route without upstreams to fail validation")
+	}
+
+	cfg.Routes[0].Upstreams = []UpstreamEndpoint{{URL: "rtmp://pool-a.example.com/live/"}}
+	cfg.Routes[0].Strategy = "least_conn"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected invalid route strategy to fail validation")
+	}
+}
+
+func TestValidateLogShipping(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Logging.Ship = LogShipConfig{Type: "loki", URL: "http://loki:3100/loki/api/v1/push"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected loki shipping to validate, got %v", err)
+	}
+
+	cfg.Logging.Ship = LogShipConfig{Type: "syslog", Address: "logs.example.com:6514", TLS: true}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected syslog shipping to validate, got %v", err)
+	}
+
+	cfg.Logging.Ship.Address = "logs.example.com"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected syslog address without port to fail validation")
+	}
+
+	cfg.Logging.Ship = LogShipConfig{Type: "loki", URL: "loki:3100"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected non-http loki url to fail validation")
+	}
+
+	cfg.Logging.Ship = LogShipConfig{Type: "kafka"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown ship type to fail validation")
+	}
+}
+
+func TestValidateReconnectGrace(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.ReconnectGrace = Duration(5 * time.Second)
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected reconnect_grace without transcode to fail validation")
+	}
+
+	cfg.Transcode.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected reconnect_grace to validate, got %v", err)
+	}
+
+	cfg.ReconnectGrace = Duration(-time.Second)
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative reconnect_grace to fail validation")
+	}
+}
+
+func TestValidateFailoverPairs(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Failover.Pairs = []PublisherPair{{Primary: "live", Backup: "live_backup"}}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected failover without transcode to fail validation")
+	}
+
+	cfg.Transcode.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected failover pair to validate, got %v", err)
+	}
+
+	cfg.Failover.Pairs = append(cfg.Failover.Pairs, PublisherPair{Primary: "live_backup", Backup: "other"})
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected reused stream key to fail validation")
+	}
+
+	cfg.Failover.Pairs = []PublisherPair{{Primary: "live", Backup: "live"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected identical primary and backup to fail validation")
+	}
+}
+
+func TestValidateTranscodeCodecOptions(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.Enabled = true
+	cfg.Transcode.VideoOpts = map[string]string{"tune": "zerolatency", "rc-lookahead": "20"}
+	cfg.Transcode.AudioOpts = map[string]string{"profile": "aac_low"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected codec options to validate, got %v", err)
+	}
+
+	cfg.Transcode.VideoOpts = map[string]string{"-f": "null"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected dash-prefixed option key to fail validation")
+	}
+
+	cfg.Transcode.VideoOpts = nil
+	cfg.Transcode.AudioOpts = map[string]string{"b a": "128k"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected option key with whitespace to fail validation")
+	}
+}
+
+func TestValidateTranscodeKillSwitch(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.KillSwitch = TranscodeKillSwitchConfig{DisabledTenants: []string{"acme"}, Fallback: "reject"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected kill switch to validate, got %v", err)
+	}
+
+	cfg.Transcode.KillSwitch.Fallback = "drop"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown fallback to fail validation")
+	}
+
+	cfg.Transcode.KillSwitch.Fallback = ""
+	cfg.Transcode.KillSwitch.DisabledTenants = []string{" "}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected blank tenant to fail validation")
+	}
+}
+
+func TestValidateTranscodeRestart(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.Restart = TranscodeRestartConfig{MaxRestarts: 3, Backoff: Duration(time.Second), MaxBackoff: Duration(10 * time.Second)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected restart settings to validate, got %v", err)
+	}
+
+	cfg.Transcode.Restart.MaxBackoff = Duration(500 * time.Millisecond)
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected max_backoff below backoff to fail validation")
+	}
+
+	cfg.Transcode.Restart = TranscodeRestartConfig{MaxRestarts: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative max_restarts to fail validation")
+	}
+}
+
+func TestValidateTranscodeSlots(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.Slots = TranscodeSlotsConfig{MaxSessions: 4, Overflow: "reject"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected slot settings to validate, got %v", err)
+	}
+
+	cfg.Transcode.Slots.Overflow = "queue"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown overflow to fail validation")
+	}
+
+	cfg.Transcode.Slots = TranscodeSlotsConfig{MaxSessions: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative max_sessions to fail validation")
+	}
+}
+
+func TestValidateTranscodeLimits(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.Limits = TranscodeLimitsConfig{CPU: 1.5, MemoryBytes: 1 << 30, Cgroup: "/sys/fs/cgroup/relay/transcode", Nice: 10}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected limits to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		limits TranscodeLimitsConfig
+		want   string
+	}{
+		{TranscodeLimitsConfig{CPU: -1}, "transcode.limits.cpu must be >= 0"},
+		{TranscodeLimitsConfig{MemoryBytes: -1}, "transcode.limits.memory_bytes must be >= 0"},
+		{TranscodeLimitsConfig{Nice: 20}, "transcode.limits.nice must be between 0 and 19"},
+		{TranscodeLimitsConfig{CPU: 1, Cgroup: "relay/transcode"}, "must be an absolute path"},
+		{TranscodeLimitsConfig{Cgroup: "/sys/fs/cgroup/relay"}, "requires cpu or memory_bytes"},
+	} {
+		cfg.Transcode.Limits = tc.limits
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error = %v, want %q", tc.limits, err, tc.want)
+		}
+	}
+}
+
+func TestValidateHealthCheckLogMode(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.UpstreamHealthCheck = UpstreamHealthCheckConfig{Enabled: true, LogMode: "state_change", ReminderIntervalSec: 300}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected state_change logging to validate, got %v", err)
+	}
+
+	cfg.UpstreamHealthCheck.LogMode = "quiet"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown log_mode to fail validation")
+	}
+
+	cfg.UpstreamHealthCheck = UpstreamHealthCheckConfig{ReminderIntervalSec: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative reminder_interval_sec to fail validation")
+	}
+}
+
+func TestValidateThumbnails(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Thumbnails = ThumbnailConfig{Enabled: true, Interval: Duration(5 * time.Second), Width: 320, Quality: 4}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected thumbnail settings to validate, got %v", err)
+	}
+
+	cfg.Thumbnails.Quality = 1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected out-of-range quality to fail validation")
+	}
+
+	cfg.Thumbnails = ThumbnailConfig{Enabled: true, Interval: Duration(-time.Second)}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative interval to fail validation")
+	}
+}
+
+func TestValidatePlaybackTokens(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Security.PlaybackTokens = PlaybackTokenConfig{Enabled: true, Secrets: []string{"c2lnbmluZy1zZWNyZXQtMTIz"}, TTL: Duration(time.Hour)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected playback tokens to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		p    PlaybackTokenConfig
+		want string
+	}{
+		{PlaybackTokenConfig{Enabled: true}, "requires at least one secret"},
+		{PlaybackTokenConfig{Enabled: true, Secrets: []string{"c2hvcnQ="}}, "secrets[0] must be base64 of at least 16 bytes"},
+		{PlaybackTokenConfig{Enabled: true, Secrets: []string{"not base64!"}}, "secrets[0] must be base64"},
+	} {
+		cfg.Security.PlaybackTokens = tc.p
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("Validate(%+v) = %v, want error containing %q", tc.p, err, tc.want)
+		}
+	}
+}
+
+func TestValidatePackager(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Packager = PackagerConfig{Enabled: true, HLS: true, DASH: true, SegmentDuration: Duration(2 * time.Second), PartDuration: Duration(500 * time.Millisecond)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected packager settings to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		p    PackagerConfig
+		want string
+	}{
+		{PackagerConfig{Enabled: true}, "must enable hls, dash or both"},
+		{PackagerConfig{Enabled: true, HLS: true, SegmentDuration: Duration(-time.Second)}, "segment_duration must be >= 0"},
+		{PackagerConfig{Enabled: true, HLS: true, PartDuration: Duration(4 * time.Second)}, "part_duration must be shorter"},
+		{PackagerConfig{Enabled: true, DASH: true, MaxSegments: -1}, "max_segments must be >= 0"},
+	} {
+		cfg.Packager = tc.p
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("Validate(%+v) = %v, want error containing %q", tc.p, err, tc.want)
+		}
+	}
+}
+
+func TestValidateViewers(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Viewers = ViewersConfig{MaxPerStream: 100, SegmentIdle: Duration(time.Minute)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected viewer settings to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		v    ViewersConfig
+		want string
+	}{
+		{ViewersConfig{MaxPerStream: -1}, "max_per_stream must be >= 0"},
+		{ViewersConfig{SegmentIdle: Duration(-time.Second)}, "segment_idle must be >= 0"},
+	} {
+		cfg.Viewers = tc.v
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("Validate(%+v) = %v, want error containing %q", tc.v, err, tc.want)
+		}
+	}
+}
+
+func TestValidateStateTimeouts(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.StateTimeouts = StateTimeoutsConfig{Handshaking: Duration(10 * time.Second), Relaying: Duration(12 * time.Hour)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected state timeouts to validate, got %v", err)
+	}
+	cfg.StateTimeouts.Dialing = Duration(-time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "state_timeouts must be >= 0") {
+		t.Fatalf("expected negative dialing timeout to fail, got %v", err)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Tenants = []TenantConfig{
+		{
+			App:             "acme",
+			AuthTokens:      []string{"acme-secret"},
+			Upstreams:       []UpstreamEndpoint{{URL: "rtmp://acme.example.com/live", Weight: 1}},
+			ConnectionLimit: ConnectionLimitConfig{MaxTotal: 20},
+			RateLimit:       RateLimitConfig{Enabled: true, RequestsPerSec: 1, Burst: 5},
+		},
+		{App: "globex"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected tenants to validate, got %v", err)
+	}
+
+	cfg.Tenants[1].App = "acme"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected duplicate tenant app to fail validation")
+	}
+
+	cfg.Tenants[1].App = "globex/live"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected app with a slash to fail validation")
+	}
+
+	cfg.Tenants = []TenantConfig{{App: "acme", RateLimit: RateLimitConfig{Enabled: true}}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected enabled rate limit without a rate to fail validation")
+	}
+}
+
+func TestValidateStateSaveInterval(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.State = StateConfig{Path: "/var/lib/relay/state.db", SaveInterval: Duration(-time.Second)}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative state.save_interval to fail validation")
+	}
+}
+
+func TestValidateDVR(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.DVR = DVRConfig{Enabled: true, Dir: "/var/lib/relay/dvr", Window: Duration(10 * time.Minute)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected dvr settings to validate, got %v", err)
+	}
+
+	cfg.DVR.Dir = ""
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected enabled dvr without a directory to fail validation")
+	}
+
+	cfg.DVR = DVRConfig{Enabled: true, Dir: "/tmp/dvr", MaxBytes: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative max_bytes to fail validation")
+	}
+}
+
+func TestValidateDVREncryption(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.DVR = DVRConfig{Enabled: true, Dir: "/var/lib/relay/dvr"}
+	cfg.DVR.Encryption = DVREncryptionConfig{Enabled: true, KeyID: "2026-10", KeyCommand: []string{"vault", "kv", "get", "-field=key", "secret/dvr"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected dvr encryption to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		enc  DVREncryptionConfig
+		want string
+	}{
+		{DVREncryptionConfig{Enabled: true}, "needs exactly one of key, key_file or key_command"},
+		{DVREncryptionConfig{Enabled: true, Key: "AAAAAAAAAAAAAAAAAAAAAA==", KeyFile: "/etc/relay/dvr.key"}, "needs exactly one of"},
+		{DVREncryptionConfig{Enabled: true, Key: "c2hvcnQ="}, "dvr.encryption.key must be a base64 16, 24 or 32 byte AES key"},
+		{DVREncryptionConfig{Enabled: true, KeyFile: "/etc/relay/dvr.key", KeyID: strings.Repeat("k", 256)}, "key_id must be at most 255 bytes"},
+	} {
+		cfg.DVR.Encryption = tc.enc
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error = %v, want %q", tc.enc, err, tc.want)
+		}
+	}
+}
+
+func TestValidateDVRPostProcess(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.DVR = DVRConfig{Enabled: true, Dir: "/var/lib/relay/dvr"}
+	cfg.DVR.PostProcess = DVRPostProcessConfig{Enabled: true, Concurrency: 4, Steps: []DVRPostProcessStep{
+		{Type: PostProcessRemuxMP4},
+		{Type: PostProcessThumbnail, Width: 320},
+		{Type: PostProcessMove, Dir: "/srv/archive"},
+		{Type: PostProcessWebhook, URL: "https://hooks.example.com/segments"},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected dvr post-processing to validate, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		pp   DVRPostProcessConfig
+		want string
+	}{
+		{DVRPostProcessConfig{Enabled: true}, "dvr.post_process.steps must not be empty"},
+		{DVRPostProcessConfig{Enabled: true, Concurrency: -1, Steps: []DVRPostProcessStep{{Type: PostProcessRemuxMP4}}}, "concurrency must be >= 0"},
+		{DVRPostProcessConfig{Enabled: true, WorkDir: "work", Steps: []DVRPostProcessStep{{Type: PostProcessRemuxMP4}}}, "work_dir must be an absolute path"},
+		{DVRPostProcessConfig{Enabled: true, Steps: []DVRPostProcessStep{{Type: "upload"}}}, "steps[0].type must be one of"},
+		{DVRPostProcessConfig{Enabled: true, Steps: []DVRPostProcessStep{{Type: PostProcessRemuxMP4}, {Type: PostProcessWebhook, URL: "ftp://example.com"}}}, "steps[1].url must be an http or https URL"},
+		{DVRPostProcessConfig{Enabled: true, Steps: []DVRPostProcessStep{{Type: PostProcessMove, Dir: "archive"}}}, "steps[0].dir must be an absolute path"},
+	} {
+		cfg.DVR.PostProcess = tc.pp
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error = %v, want %q", tc.pp, err, tc.want)
+		}
+	}
+}
+
+func TestValidateRetryBudget(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Retry = RetryConfig{Enabled: true, MaxAttempts: 3, BudgetRatio: 0.2, BudgetMinRetries: 10, BudgetWindow: Duration(10 * time.Second)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected retry budget to validate, got %v", err)
+	}
+
+	cfg.Retry.BudgetRatio = -0.1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative budget_ratio to fail validation")
+	}
+
+	cfg.Retry.BudgetRatio = 0
+	cfg.Retry.PerAttemptTimeout = Duration(-time.Second)
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative per_attempt_timeout to fail validation")
+	}
+}
+
+func TestValidateRewriteRules(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.RewriteRules = []RewriteRule{{Field: "stream", Match: `vanity-(\w+)`, Replace: "key_$1"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected rewrite rule to validate, got %v", err)
+	}
+
+	cfg.RewriteRules = []RewriteRule{{Field: "host", Match: ".*"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown rewrite field to fail validation")
+	}
+
+	cfg.RewriteRules = []RewriteRule{{Field: "app", Match: "("}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected invalid rewrite regex to fail validation")
+	}
+}
+
+func TestValidateMetricsPushGateway(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Metrics.PushGateway = "http://pushgateway:9091"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected push_gateway to validate, got %v", err)
+	}
+
+	cfg.Metrics.PushGateway = "pushgateway:9091"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected push_gateway without scheme to fail validation")
+	}
+}
+
+func TestValidateMetricsStatsD(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Metrics.StatsD = StatsDConfig{Address: "127.0.0.1:8125", Flavor: "dogstatsd", Tags: map[string]string{"env": "prod"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected statsd to validate, got %v", err)
+	}
+
+	cfg.Metrics.StatsD.Flavor = ""
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected tags without dogstatsd to fail validation")
+	}
+
+	cfg.Metrics.StatsD = StatsDConfig{Address: "127.0.0.1"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected address without port to fail validation")
+	}
+
+	cfg.Metrics.StatsD = StatsDConfig{Address: "127.0.0.1:8125", Flavor: "graphite"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown flavor to fail validation")
+	}
+}
+
+func TestValidateReadiness(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Readiness = ReadinessConfig{CacheTTL: Duration(time.Second), Require: []string{ReadyUpstream}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected readiness to validate, got %v", err)
+	}
+
+	cfg.Readiness.Require = []string{ReadyRecording}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected recording to require dvr.enabled")
+	}
+
+	cfg.Readiness.Require = []string{"database"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an unknown dependency to fail validation")
+	}
+
+	cfg.Readiness = ReadinessConfig{CacheTTL: Duration(-time.Second)}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected a negative cache_ttl to fail validation")
+	}
+}
+
+func TestValidateDNSResponder(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.DNSResponder = DNSResponderConfig{Listen: ":5353", Name: "ingest.example.com", Addresses: []string{"192.0.2.10"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected dns_responder to validate, got %v", err)
+	}
+
+	cfg.DNSResponder.Addresses = []string{"2001:db8::1"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected IPv6 dns_responder address to fail validation")
+	}
+
+	cfg.DNSResponder.Addresses = []string{"192.0.2.10"}
+	cfg.DNSResponder.Name = ""
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected dns_responder without name to fail validation")
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Cluster = ClusterConfig{InstanceID: "relay-1", AdvertiseURL: "http://relay-1:8080", Peers: []string{"http://relay-2:8080"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected cluster to validate, got %v", err)
+	}
+
+	cfg.Cluster.Peers = []string{"relay-2:8080"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected peer without scheme to fail validation")
+	}
+}
+
+func TestValidateClusterRegistry(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Cluster = ClusterConfig{
+		AdvertiseURL: "http://relay-1:8080",
+		Registry:     ClusterRegistryConfig{Redis: "redis://:secret@redis:6379/2"},
+		PlayRouting:  PlayRoutingProxy,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected cluster registry to validate, got %v", err)
+	}
+
+	cfg.Cluster.Registry.Redis = "redis://redis:6379/cache"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cluster.registry.redis") {
+		t.Fatalf("expected a named database to fail validation, got %v", err)
+	}
+	cfg.Cluster.Registry.Redis = "redis:6379"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cluster.registry.redis") {
+		t.Fatalf("expected a redis address without scheme to fail validation, got %v", err)
+	}
+	cfg.Cluster.Registry.Redis = "redis://redis:6379"
+	cfg.Cluster.AdvertiseURL = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "advertise_url") {
+		t.Fatalf("expected a registry without advertise_url to fail validation, got %v", err)
+	}
+	cfg.Cluster.AdvertiseURL = "http://relay-1:8080"
+	cfg.Cluster.PlayRouting = "pull"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cluster.play_routing") {
+		t.Fatalf("expected an unknown play_routing to fail validation, got %v", err)
+	}
+}
+
+func TestValidateRedirect(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Redirect = RedirectConfig{
+		Apps:        map[string]string{"archive": "rtmp://relay-9:1935/archive"},
+		MaxSessions: 100,
+		Targets:     []string{"rtmp://relay-2:1935", "rtmps://relay-3:443"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected redirect to validate, got %v", err)
+	}
+
+	cfg.Redirect.Apps["archive"] = "http://relay-9/archive"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "redirect.apps") {
+		t.Fatalf("expected a non-RTMP app target to fail validation, got %v", err)
+	}
+	delete(cfg.Redirect.Apps, "archive")
+	cfg.Redirect.Targets = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "redirect.max_sessions") {
+		t.Fatalf("expected overflow without targets or registry to fail validation, got %v", err)
+	}
+	cfg.Cluster = ClusterConfig{
+		AdvertiseURL:  "http://relay-1:8080",
+		AdvertiseRTMP: "rtmp://relay-1:1935",
+		Registry:      ClusterRegistryConfig{Redis: "redis://redis:6379"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected overflow to registry relays to validate, got %v", err)
+	}
+	cfg.Cluster.AdvertiseRTMP = "relay-1:1935"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cluster.advertise_rtmp") {
+		t.Fatalf("expected advertise_rtmp without scheme to fail validation, got %v", err)
+	}
+}
+
+func TestValidateTranscodeProfiles(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode.Enabled = true
+	cfg.TranscodeProfiles = TranscodeProfilesConfig{
+		Profiles: map[string]TranscodeConfig{"mobile": {VideoCodec: "libx264", Height: 360, GOP: "2s"}},
+		Rules: []TranscodeRule{
+			{App: "live", Stream: "*_mobile", Profile: "mobile"},
+			{Profile: ProfilePassthrough},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected transcode profiles to validate, got %v", err)
+	}
+
+	cfg.TranscodeProfiles.Rules[0].Profile = "hd"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "transcode_profiles.rules[0] profile") {
+		t.Fatalf("expected an unknown profile to fail validation, got %v", err)
+	}
+	cfg.TranscodeProfiles.Rules[0].Profile = "mobile"
+	cfg.TranscodeProfiles.Profiles["mobile"] = TranscodeConfig{VideoCodec: "copy", Height: 360}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "transcode_profiles.profiles.mobile") {
+		t.Fatalf("expected a bad profile to fail validation, got %v", err)
+	}
+	cfg.TranscodeProfiles.Profiles["mobile"] = TranscodeConfig{VideoCodec: "libx264"}
+	cfg.Transcode.Enabled = false
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires transcode.enabled") {
+		t.Fatalf("expected rules without transcode.enabled to fail validation, got %v", err)
+	}
+}
+
+func TestValidateTranscodeMode(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.Transcode = TranscodeConfig{Enabled: true, Mode: TranscodeModeSmart, VideoCodec: "h264_nvenc"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected smart mode to validate, got %v", err)
+	}
+
+	cfg.Transcode.VideoCodec = "prores_ks"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "transcode.accept_video") {
+		t.Fatalf("expected an unknown encoder without accept_video to fail validation, got %v", err)
+	}
+	cfg.Transcode.AcceptVideo = []string{"h264"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected accept_video to settle the codecs, got %v", err)
+	}
+	cfg.Transcode.Mode = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "need mode smart") {
+		t.Fatalf("expected accept lists outside smart mode to fail validation, got %v", err)
+	}
+	cfg.Transcode.Mode = "sometimes"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "transcode.mode") {
+		t.Fatalf("expected an unknown mode to fail validation, got %v", err)
+	}
+}
+
+func TestValidateAccessLog(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.AccessLog = AccessLogConfig{Path: "-", Ship: LogShipConfig{Type: "syslog", Address: "syslog:514", BufferDir: "/var/spool/access"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected access log to validate, got %v", err)
+	}
+
+	cfg.AccessLog.Ship.Type = "kafka"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "access_log.ship") {
+		t.Fatalf("expected access_log.ship error, got %v", err)
+	}
+
+	cfg.AccessLog.Ship.Type = "syslog"
+	cfg.Logging.Ship = LogShipConfig{Type: "syslog", Address: "syslog:514", BufferDir: "/var/spool/access"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected a buffer_dir shared with logging.ship to fail validation")
+	}
+}
+
+func TestValidateRenditions(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Transcode.Enabled = true
+	cfg.Transcode.Renditions = []TranscodeRendition{
+		{Name: "720p", Height: 720, VideoBitrate: "2500k", FPS: 30},
+		{Name: "360p", Height: 360, VideoBitrate: "0.8M"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected ladder to validate, got %v", err)
+	}
+
+	cfg.Transcode.Renditions[1].Name = "720p"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected repeated rendition name to fail validation")
+	}
+	cfg.Transcode.Renditions[1].Name = "360p"
+
+	cfg.Transcode.Renditions[1].Height = 361
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected odd rendition height to fail validation")
+	}
+	cfg.Transcode.Renditions[1].Height = 360
+
+	cfg.Transcode.Renditions[0].VideoBitrate = "fast"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected bad video_bitrate to fail validation")
+	}
+	cfg.Transcode.Renditions[0].VideoBitrate = "2500k"
+
+	cfg.Transcode.VideoCodec = "copy"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected renditions with video copy to fail validation")
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for in, want := range map[string]int64{"800k": 800000, "2.5M": 2500000, "64000": 64000} {
+		got, err := ParseBitrate(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseBitrate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseBitrate("-1k"); err == nil {
+		t.Fatal("expected negative bitrate to fail")
+	}
+}
+
+func TestValidateTranscodeShaping(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/"
+	cfg.Transcode.Enabled = true
+	cfg.Transcode.Height = 720
+	cfg.Transcode.FPS = 30
+	cfg.Transcode.VideoBitrate = "3000k"
+	cfg.Transcode.VideoMaxRate = "3500k"
+	cfg.Transcode.VideoBufSize = "6M"
+	cfg.Transcode.AudioBitrate = "128k"
+	cfg.Transcode.AudioSampleRate = 48000
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected shaping settings to validate, got %v", err)
+	}
+
+	cfg.Transcode.Scale = "iw/2:-2"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected scale with height to fail validation")
+	}
+	cfg.Transcode.Height = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected scale expression to validate, got %v", err)
+	}
+	cfg.Transcode.Scale = "iw/2:-2,drawtext=text=x"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected a filter chain in scale to fail validation")
+	}
+	cfg.Transcode.Scale = ""
+
+	cfg.Transcode.Renditions = []TranscodeRendition{{Name: "720p", Height: 720}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected top-level fps/bitrate with renditions to fail validation")
+	}
+}
+
+func TestValidateLoudness(t *testing.T) {
+	cases := []struct {
+		name string
+		l    LoudnessConfig
+		copy bool
+		ok   bool
+	}{
+		{"off", LoudnessConfig{}, false, true},
+		{"ebu defaults", LoudnessConfig{Filter: "loudnorm"}, false, true},
+		{"streaming target", LoudnessConfig{Filter: "loudnorm", TargetLUFS: -14, TruePeak: -1.5, LRA: 11}, false, true},
+		{"dynaudnorm", LoudnessConfig{Filter: "dynaudnorm", TargetLUFS: -16}, false, true},
+		{"unknown filter", LoudnessConfig{Filter: "compand"}, false, false},
+		{"target without filter", LoudnessConfig{TargetLUFS: -23}, false, false},
+		{"target too loud", LoudnessConfig{Filter: "loudnorm", TargetLUFS: -2}, false, false},
+		{"positive true peak", LoudnessConfig{Filter: "loudnorm", TruePeak: 1}, false, false},
+		{"lra on dynaudnorm", LoudnessConfig{Filter: "dynaudnorm", LRA: 7}, false, false},
+		{"audio copy", LoudnessConfig{Filter: "loudnorm"}, true, false},
+	}
+	for _, tc := range cases {
+		cfg := Default()
+		cfg.Upstream = "rtmp://example.com/app/stream"
+		cfg.Transcode.Loudness = tc.l
+		if tc.copy {
+			cfg.Transcode.AudioCodec = "copy"
+		}
+		if err := cfg.Validate(); (err == nil) != tc.ok {
+			t.Fatalf("%s: Validate() = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestValidateTranscodePassthrough(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(*TranscodeConfig)
+		ok   bool
+	}{
+		{"flags and values", func(tc *TranscodeConfig) {
+			tc.ExtraInputArgs = []string{"-thread_queue_size", "1024"}
+			tc.ExtraOutputArgs = []string{"-flvflags", "no_duration_filesize", "-shortest"}
+		}, true},
+		{"filters", func(tc *TranscodeConfig) {
+			tc.VideoFilter = "yadif,drawtext=text=LIVE:x=10:y=10"
+			tc.AudioFilter = "highpass=f=80"
+		}, true},
+		{"extra input", func(tc *TranscodeConfig) { tc.ExtraInputArgs = []string{"-i", "x.mp4"} }, false},
+		{"stray output", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"-shortest", "-an", "/tmp/out.flv"} }, false},
+		{"leading value", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"out.flv"} }, false},
+		{"url value", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"-metadata", "rtmp://elsewhere/live"} }, false},
+		{"stream specifier", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"-filter:v", "null"} }, false},
+		{"option from file", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"-/metadata", "meta.txt"} }, false},
+		{"output format", func(tc *TranscodeConfig) { tc.ExtraOutputArgs = []string{"-f", "mp4"} }, false},
+		{"libav", func(tc *TranscodeConfig) {
+			tc.Backend = "libav"
+			tc.ExtraInputArgs = []string{"-probesize", "32"}
+		}, false},
+		{"labels", func(tc *TranscodeConfig) { tc.VideoFilter = "[in]yadif[out]" }, false},
+		{"file reader", func(tc *TranscodeConfig) { tc.VideoFilter = "movie=logo.png" }, false},
+		{"text file", func(tc *TranscodeConfig) { tc.VideoFilter = "drawtext=textfile=/etc/passwd" }, false},
+		{"video copy", func(tc *TranscodeConfig) {
+			tc.VideoCodec = "copy"
+			tc.VideoFilter = "yadif"
+		}, false},
+	}
+	for _, tc := range cases {
+		cfg := Default()
+		cfg.Upstream = "rtmp://example.com/app/stream"
+		tc.edit(&cfg.Transcode)
+		if err := cfg.Validate(); (err == nil) != tc.ok {
+			t.Fatalf("%s: Validate() = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestValidatePublishLimit(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.PublishLimit = PublishLimitConfig{MaxStreamsPerIP: 2, MaxStreamsPerToken: 5}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected publish limit to validate, got %v", err)
+	}
+
+	cfg.PublishLimit.MaxStreamsPerToken = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "publish_limit.max_streams_per_token") {
+		t.Fatalf("expected publish_limit error, got %v", err)
+	}
+}
+
+func TestValidateDuplicatePublish(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.DuplicatePublish = DuplicatePublishConfig{Policy: DuplicatePublishKick}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected kick policy to validate, got %v", err)
+	}
+
+	cfg.DuplicatePublish.Policy = DuplicatePublishTakeover
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "takeover_token") {
+		t.Fatalf("expected missing takeover_token error, got %v", err)
+	}
+	cfg.DuplicatePublish.TakeoverToken = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected takeover policy to validate, got %v", err)
+	}
+
+	cfg.DuplicatePublish.Policy = "steal"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate_publish.policy") {
+		t.Fatalf("expected policy error, got %v", err)
+	}
+}
+
+func TestValidateAVSync(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.AVSync = AVSyncConfig{Enabled: true, DriftThreshold: Duration(time.Second)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected av_sync to validate, got %v", err)
+	}
+	cfg.AVSync.GapThreshold = Duration(-time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "av_sync.gap_threshold") {
+		t.Fatalf("expected av_sync.gap_threshold error, got %v", err)
+	}
+}
+
+func TestValidateStreamPolicy(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.StreamPolicy = StreamPolicyConfig{Enabled: true, Action: StreamPolicyReject, VideoCodecs: []string{"h264"}, MaxKeyframeInterval: Duration(4 * time.Second)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected stream_policy to validate, got %v", err)
+	}
+	cfg.StreamPolicy.Action = "drop"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "stream_policy.action") {
+		t.Fatalf("expected stream_policy.action error, got %v", err)
+	}
+	cfg.StreamPolicy.Action = ""
+	cfg.Tenants = []TenantConfig{{App: "live", StreamPolicy: &StreamPolicyConfig{Enabled: true, MaxWidth: -1}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "tenants[0].stream_policy") {
+		t.Fatalf("expected tenants[0].stream_policy error, got %v", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		EnvListenAddr:  ":1936",
+		EnvHTTPAddr:    "",
+		EnvUpstream:    "rtmp://env.example.com/app",
+		EnvIdleTimeout: "45s",
+		EnvReadBuffer:  "8192",
+	}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+
+	cfg := Default()
+	cfg.Upstream = "rtmp://file.example.com/app"
+	if err := ApplyEnv(&cfg, lookup); err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if cfg.ListenAddr != ":1936" || cfg.HTTPAddr != "" || cfg.Upstream != "rtmp://env.example.com/app" ||
+		cfg.IdleTimeout.AsDuration() != 45*time.Second || cfg.ReadBuffer != 8192 || cfg.WriteBuffer != 64*1024 {
+		t.Fatalf("config after env = %+v", cfg)
+	}
+
+	env[EnvWriteBuffer] = "big"
+	if err := ApplyEnv(&cfg, lookup); err == nil || !strings.Contains(err.Error(), EnvWriteBuffer) {
+		t.Fatalf("expected %s error, got %v", EnvWriteBuffer, err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://user:pw@ingest.example.com/live?token=abc/cam1?key=def&v=1"
+	cfg.Security.AuthTokens = []string{"secret-1", "secret-2"}
+	cfg.DuplicatePublish.TakeoverToken = "takeover"
+	cfg.UpstreamCredentials = []UpstreamCredential{{Name: "new", Query: "token=xyz"}}
+	cfg.Routes = []RouteConfig{{Match: "live/*", Upstreams: []UpstreamEndpoint{{URL: "rtmps://a.example.com/app?sig=1"}}}}
+	cfg.Tenants = []TenantConfig{{App: "acme", AuthTokens: []string{"tenant-secret"}}}
+	cfg.Security.PlaybackTokens.Secrets = []string{"c2lnbmluZy1zZWNyZXQtMTIz"}
+
+	out := cfg.Redact()
+	if out.Upstream != "rtmp://REDACTED@ingest.example.com/live?token=REDACTED/cam1?key=REDACTED&v=REDACTED" {
+		t.Fatalf("upstream = %s", out.Upstream)
+	}
+	if out.Routes[0].Upstreams[0].URL != "rtmps://a.example.com/app?sig=REDACTED" {
+		t.Fatalf("route upstream = %s", out.Routes[0].Upstreams[0].URL)
+	}
+	if out.UpstreamCredentials[0].Query != "token=REDACTED" || out.UpstreamCredentials[0].Name != "new" {
+		t.Fatalf("credentials = %+v", out.UpstreamCredentials)
+	}
+	if strings.Join(out.Security.AuthTokens, ",") != "REDACTED,REDACTED" || out.Tenants[0].AuthTokens[0] != Redacted ||
+		out.DuplicatePublish.TakeoverToken != Redacted {
+		t.Fatalf("tokens not redacted: %+v %+v %q", out.Security.AuthTokens, out.Tenants[0].AuthTokens, out.DuplicatePublish.TakeoverToken)
+	}
+	if out.Security.PlaybackTokens.Secrets[0] != Redacted {
+		t.Fatalf("playback secret not redacted: %q", out.Security.PlaybackTokens.Secrets[0])
+	}
+	if cfg.Security.AuthTokens[0] != "secret-1" || cfg.Routes[0].Upstreams[0].URL != "rtmps://a.example.com/app?sig=1" {
+		t.Fatal("Redact modified the original config")
+	}
+}
+
+func TestValidateOutlierDetection(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.OutlierDetection = OutlierDetectionConfig{Enabled: true, FailureRate: 0.3, BaseEjection: Duration(time.Minute)}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected outlier_detection to validate, got %v", err)
+	}
+	cfg.OutlierDetection.MaxEjection = Duration(time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "outlier_detection.max_ejection") {
+		t.Fatalf("expected outlier_detection.max_ejection error, got %v", err)
+	}
+	cfg.OutlierDetection.MaxEjection = 0
+	cfg.OutlierDetection.FailureRate = 1.5
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "outlier_detection.failure_rate") {
+		t.Fatalf("expected outlier_detection.failure_rate error, got %v", err)
+	}
+}
+
+func TestValidateDialRate(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.UpstreamDialRate = DialRateConfig{PerSecond: 5, Jitter: 0.5}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected upstream_dial_rate to validate, got %v", err)
+	}
+	cfg.UpstreamDialRate.Jitter = 2
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "upstream_dial_rate.jitter") {
+		t.Fatalf("expected upstream_dial_rate.jitter error, got %v", err)
+	}
+	cfg.UpstreamDialRate.Jitter = 0
+	cfg.Upstreams = []UpstreamEndpoint{{URL: "rtmp://example.com/app", DialRate: &DialRateConfig{PerSecond: -1}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "upstreams[0].dial_rate.per_second") {
+		t.Fatalf("expected upstreams[0].dial_rate.per_second error, got %v", err)
+	}
+}
+
+func TestValidateUpstreamBind(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.UpstreamBind = UpstreamBindConfig{SourceIP: "192.0.2.10", Interface: "eth1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected upstream_bind to validate, got %v", err)
+	}
+	cfg.Upstreams = []UpstreamEndpoint{{URL: "rtmp://example.com/app", Bind: &UpstreamBindConfig{SourceIP: "eth1"}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "upstreams[0].bind.source_ip") {
+		t.Fatalf("expected upstreams[0].bind.source_ip error, got %v", err)
+	}
+}
+
+func TestValidateTCPKeepalive(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.TCP.Downstream = TCPKeepaliveConfig{Idle: Duration(10 * time.Second), Count: 3}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected tcp to validate, got %v", err)
+	}
+	cfg.TCP.Upstream.Count = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "tcp.upstream.count") {
+		t.Fatalf("expected tcp.upstream.count error, got %v", err)
+	}
+}
+
+func TestValidateQoS(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.QoS = QoSConfig{DSCP: 34, RTMPListener: 46}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected qos to validate, got %v", err)
+	}
+	if got := cfg.QoS.Class(cfg.QoS.Upstream); got != 34 {
+		t.Fatalf("upstream class = %d, want the default 34", got)
+	}
+	cfg.QoS.HTTPListener = 64
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "qos.http_listener") {
+		t.Fatalf("expected qos.http_listener error, got %v", err)
+	}
+}
+
+func TestValidateUnixSocketMode(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "rtmp://example.com/app/stream"
+	cfg.ListenAddr = "unix:///run/relay/rtmp.sock"
+	cfg.UnixSocketMode = "0660"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected unix socket listener to validate, got %v", err)
+	}
+	if mode, _ := cfg.UnixSocketFileMode(); mode != 0o660 {
+		t.Fatalf("mode = %o, want 660", mode)
+	}
+	cfg.UnixSocketMode = "rw-rw----"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "unix_socket_mode") {
+		t.Fatalf("expected unix_socket_mode error, got %v", err)
+	}
+}
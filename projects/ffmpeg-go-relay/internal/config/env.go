@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables that override the config file. Command-line flags
+// override them in turn.
+const (
+	EnvListenAddr  = "LISTEN_ADDR"
+	EnvHTTPAddr    = "HTTP_ADDR"
+	EnvUpstream    = "UPSTREAM"
+	EnvIdleTimeout = "IDLE_TIMEOUT"
+	EnvReadBuffer  = "READ_BUFFER"
+	EnvWriteBuffer = "WRITE_BUFFER"
+)
+
+// ApplyEnv overrides cfg with the environment variables that are set.
+// lookup is os.LookupEnv outside tests; nil uses it. HTTP_ADDR may be set
+// empty to disable the HTTP listener.
+func ApplyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	if v, ok := lookup(EnvListenAddr); ok && v != "" {
+		cfg.ListenAddr = v
+	}
+	if v, ok := lookup(EnvHTTPAddr); ok {
+		cfg.HTTPAddr = v
+	}
+	if v, ok := lookup(EnvUpstream); ok && v != "" {
+		cfg.Upstream = v
+	}
+	if v, ok := lookup(EnvIdleTimeout); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvIdleTimeout, err)
+		}
+		cfg.IdleTimeout = Duration(d)
+	}
+	for _, b := range []struct {
+		name string
+		dst  *int
+	}{
+		{EnvReadBuffer, &cfg.ReadBuffer},
+		{EnvWriteBuffer, &cfg.WriteBuffer},
+	} {
+		if v, ok := lookup(b.name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", b.name, err)
+			}
+			*b.dst = n
+		}
+	}
+	return nil
+}
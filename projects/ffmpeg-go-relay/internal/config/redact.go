@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Redacted replaces secrets in Config.Redact output.
+const Redacted = "REDACTED"
+
+// Redact returns a copy of c that is safe to print: auth and takeover tokens
+// and playback secrets are replaced, and URLs and credential queries keep
+// their parameter names but lose their values and any userinfo. Stream keys
+// that are part of a URL path cannot be told apart from stream names and are
+// left alone.
+func (c Config) Redact() Config {
+	// A JSON round trip is the deep copy, so c's slices are not touched.
+	var out Config
+	data, err := json.Marshal(c)
+	if err == nil {
+		err = json.Unmarshal(data, &out)
+	}
+	if err != nil {
+		return Config{}
+	}
+
+	redactTokens(out.Security.AuthTokens)
+	redactTokens(out.Security.PlaybackTokens.Secrets)
+	if out.DuplicatePublish.TakeoverToken != "" {
+		out.DuplicatePublish.TakeoverToken = Redacted
+	}
+	if out.DVR.Encryption.Key != "" {
+		out.DVR.Encryption.Key = Redacted
+	}
+	out.Upstream = redactURL(out.Upstream)
+	redactEndpoints(out.Upstreams)
+	redactCredentials(out.UpstreamCredentials)
+	for i := range out.Routes {
+		redactEndpoints(out.Routes[i].Upstreams)
+	}
+	for i := range out.Tenants {
+		redactTokens(out.Tenants[i].AuthTokens)
+		redactEndpoints(out.Tenants[i].Upstreams)
+	}
+	for i := range out.Transcode.Renditions {
+		out.Transcode.Renditions[i].URL = redactURL(out.Transcode.Renditions[i].URL)
+	}
+	out.Logging.Ship.URL = redactURL(out.Logging.Ship.URL)
+	out.AccessLog.Ship.URL = redactURL(out.AccessLog.Ship.URL)
+	out.Metrics.PushGateway = redactURL(out.Metrics.PushGateway)
+	out.Cluster.AdvertiseURL = redactURL(out.Cluster.AdvertiseURL)
+	for i, peer := range out.Cluster.Peers {
+		out.Cluster.Peers[i] = redactURL(peer)
+	}
+	return out
+}
+
+func redactTokens(tokens []string) {
+	for i, t := range tokens {
+		if t != "" {
+			tokens[i] = Redacted
+		}
+	}
+}
+
+func redactEndpoints(endpoints []UpstreamEndpoint) {
+	for i := range endpoints {
+		endpoints[i].URL = redactURL(endpoints[i].URL)
+		redactCredentials(endpoints[i].Credentials)
+	}
+}
+
+func redactCredentials(creds []UpstreamCredential) {
+	for i := range creds {
+		creds[i].Query = strings.TrimPrefix(redactURL("?"+creds[i].Query), "?")
+	}
+}
+
+// redactURL replaces the userinfo and every query value of raw. RTMP URLs
+// carry queries on the app as well as on the stream, as in
+// "rtmp://host/live?token=x/cam1?key=y", so a "/" ends a query value.
+func redactURL(raw string) string {
+	if scheme, rest, ok := strings.Cut(raw, "://"); ok {
+		host, path, hasPath := strings.Cut(rest, "/")
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = Redacted + host[at:]
+		}
+		raw = scheme + "://" + host
+		if hasPath {
+			raw += "/" + path
+		}
+	}
+
+	var b strings.Builder
+	inQuery, inValue := false, false
+	for _, r := range raw {
+		switch {
+		case r == '?':
+			inQuery, inValue = true, false
+		case !inQuery:
+		case r == '&':
+			inValue = false
+		case r == '/':
+			inQuery, inValue = false, false
+		case inValue:
+			continue
+		case r == '=':
+			inValue = true
+			b.WriteRune(r)
+			b.WriteString(Redacted)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
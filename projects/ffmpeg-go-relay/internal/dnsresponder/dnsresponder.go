@@ -0,0 +1,253 @@
+// Package dnsresponder is a tiny authoritative DNS server for a single name.
+// It answers A queries with the relay's own addresses while the relay is
+// ready and refuses them otherwise, so a resolver holding several NS records
+// (one per relay) moves on to a healthy one. It is meant for small setups
+// without anycast or a health-checked DNS provider.
+package dnsresponder
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+const (
+	// DefaultTTL keeps resolvers re-asking often enough to notice failures.
+	DefaultTTL = 10 * time.Second
+
+	// readyCacheTTL bounds how often a burst of queries re-runs the check.
+	readyCacheTTL = 2 * time.Second
+
+	typeA   = 1
+	classIN = 1
+
+	rcodeNoError  = 0
+	rcodeFormErr  = 1
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+	headerLen     = 12
+	maxPacketSize = 512
+)
+
+// Stats counts how queries were answered.
+type Stats struct {
+	Answered uint64 `json:"answered"`
+	Refused  uint64 `json:"refused"`
+	Ready    bool   `json:"ready"`
+}
+
+// Responder answers queries for one name. A nil Responder does nothing.
+type Responder struct {
+	listen string
+	name   string // lower case, fully qualified with a trailing dot
+	addrs  []netip.Addr
+	ttl    uint32
+	ready  func(context.Context) bool
+	log    *logger.Logger
+
+	mu        sync.Mutex // Serializes readiness checks
+	checkedAt time.Time
+	lastReady atomic.Bool
+	answered  atomic.Uint64
+	refused   atomic.Uint64
+}
+
+// New returns nil when cfg.Listen is empty. ready is consulted at most once
+// every couple of seconds.
+func New(cfg config.DNSResponderConfig, ready func(context.Context) bool, log *logger.Logger) (*Responder, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+	r := &Responder{
+		listen: cfg.Listen,
+		name:   canonicalName(cfg.Name),
+		ttl:    uint32(DefaultTTL / time.Second),
+		ready:  ready,
+		log:    log,
+	}
+	if cfg.TTL > 0 {
+		r.ttl = uint32(time.Duration(cfg.TTL) / time.Second)
+	}
+	for _, a := range cfg.Addresses {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is4() {
+			return nil, fmt.Errorf("dnsresponder: %q is not an IPv4 address", a)
+		}
+		r.addrs = append(r.addrs, addr)
+	}
+	return r, nil
+}
+
+// Run serves UDP queries until ctx is done. Queries stop being answered as
+// soon as shutdown starts, which steers encoders away before the drain ends.
+func (r *Responder) Run(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	pc, err := net.ListenPacket("udp", r.listen)
+	if err != nil {
+		return fmt.Errorf("dnsresponder: listen: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	r.log.Info("dns responder listening", "addr", pc.LocalAddr().String(), "name", r.name)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			r.log.Warn("dns read failed", "err", err)
+			continue
+		}
+		resp := r.respond(ctx, buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err := pc.WriteTo(resp, from); err != nil {
+			r.log.Debug("dns write failed", "client", from.String(), "err", err)
+		}
+	}
+}
+
+// Stats returns query counters and the last readiness result.
+func (r *Responder) Stats() Stats {
+	if r == nil {
+		return Stats{}
+	}
+	return Stats{
+		Answered: r.answered.Load(),
+		Refused:  r.refused.Load(),
+		Ready:    r.lastReady.Load(),
+	}
+}
+
+// respond builds the reply to one query packet, or nil if it should be dropped.
+func (r *Responder) respond(ctx context.Context, query []byte) []byte {
+	if len(query) < headerLen || query[2]&0x80 != 0 {
+		return nil // Too short to answer, or not a query
+	}
+	opcode := (query[2] >> 3) & 0x0f
+	if opcode != 0 {
+		return reply(query, nil, rcodeNotImp, nil)
+	}
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return reply(query, nil, rcodeFormErr, nil)
+	}
+	name, end, ok := readName(query, headerLen)
+	if !ok || end+4 > len(query) {
+		return reply(query, nil, rcodeFormErr, nil)
+	}
+	question := query[headerLen : end+4]
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	qclass := binary.BigEndian.Uint16(query[end+2 : end+4])
+
+	if name != r.name || qclass != classIN || !r.isReady(ctx) {
+		r.refused.Add(1)
+		return reply(query, question, rcodeRefused, nil)
+	}
+	r.answered.Add(1)
+	if qtype != typeA {
+		return reply(query, question, rcodeNoError, nil) // No data of that type
+	}
+	return reply(query, question, rcodeNoError, r.answers())
+}
+
+func (r *Responder) isReady(ctx context.Context) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < readyCacheTTL {
+		return r.lastReady.Load()
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	ready := r.ready == nil || r.ready(checkCtx)
+	r.lastReady.Store(ready)
+	r.checkedAt = time.Now()
+	return ready
+}
+
+// answers encodes one A record per address, naming the question by pointer.
+func (r *Responder) answers() [][]byte {
+	records := make([][]byte, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		rec := make([]byte, 0, 16)
+		rec = append(rec, 0xc0, headerLen) // Pointer to the question name
+		rec = binary.BigEndian.AppendUint16(rec, typeA)
+		rec = binary.BigEndian.AppendUint16(rec, classIN)
+		rec = binary.BigEndian.AppendUint32(rec, r.ttl)
+		rec = binary.BigEndian.AppendUint16(rec, 4)
+		ip := addr.As4()
+		rec = append(rec, ip[:]...)
+		records = append(records, rec)
+	}
+	return records
+}
+
+// reply echoes the query ID, RD bit and question with the given answers.
+func reply(query, question []byte, rcode byte, answers [][]byte) []byte {
+	out := make([]byte, headerLen, headerLen+len(question)+16*len(answers))
+	copy(out[0:2], query[0:2])
+	out[2] = 0x80 | 0x04 | (query[2] & 0x79) // QR, AA, opcode and RD from the query
+	out[3] = rcode
+	if question != nil {
+		binary.BigEndian.PutUint16(out[4:6], 1)
+	}
+	binary.BigEndian.PutUint16(out[6:8], uint16(len(answers)))
+	out = append(out, question...)
+	for _, rec := range answers {
+		out = append(out, rec...)
+	}
+	return out
+}
+
+// readName decodes an uncompressed name starting at off. Queries never need
+// compression, so pointers are rejected.
+func readName(msg []byte, off int) (string, int, bool) {
+	var b strings.Builder
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 || off+l > len(msg) || b.Len()+l+1 > 255 {
+			return "", 0, false
+		}
+		b.WriteString(strings.ToLower(string(msg[off : off+l])))
+		b.WriteByte('.')
+		off += l
+	}
+	if b.Len() == 0 {
+		return ".", off, true
+	}
+	return b.String(), off, true
+}
+
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
@@ -0,0 +1,156 @@
+package dnsresponder
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+func query(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	for _, label := range splitLabels(name) {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			if i > start {
+				labels = append(labels, name[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return labels
+}
+
+func newTestResponder(t *testing.T, listen string, ready *bool) *Responder {
+	t.Helper()
+	r, err := New(config.DNSResponderConfig{
+		Listen:    listen,
+		Name:      "Ingest.Example.com",
+		Addresses: []string{"192.0.2.10", "192.0.2.11"},
+		TTL:       config.Duration(30 * time.Second),
+	}, func(context.Context) bool { return *ready }, logger.New())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return r
+}
+
+func TestRespondAnswersOnlyWhenReady(t *testing.T) {
+	ready := true
+	r := newTestResponder(t, ":0", &ready)
+
+	resp := r.respond(context.Background(), query(0xbeef, "ingest.example.COM", typeA))
+	if got := binary.BigEndian.Uint16(resp[0:2]); got != 0xbeef {
+		t.Fatalf("id = %#x, want 0xbeef", got)
+	}
+	if resp[2]&0x84 != 0x84 || resp[2]&0x01 == 0 {
+		t.Fatalf("flags = %#x, want QR, AA and RD set", resp[2])
+	}
+	if rcode := resp[3] & 0x0f; rcode != rcodeNoError {
+		t.Fatalf("rcode = %d, want NOERROR", rcode)
+	}
+	if n := binary.BigEndian.Uint16(resp[6:8]); n != 2 {
+		t.Fatalf("answers = %d, want 2", n)
+	}
+	last := resp[len(resp)-4:]
+	if net.IP(last).String() != "192.0.2.11" {
+		t.Fatalf("last answer = %v, want 192.0.2.11", net.IP(last))
+	}
+
+	// Readiness is cached briefly; expire it so the change is seen.
+	ready = false
+	r.checkedAt = time.Time{}
+	resp = r.respond(context.Background(), query(1, "ingest.example.com", typeA))
+	if rcode := resp[3] & 0x0f; rcode != rcodeRefused {
+		t.Fatalf("rcode = %d, want REFUSED while not ready", rcode)
+	}
+	if stats := r.Stats(); stats.Answered != 1 || stats.Refused != 1 || stats.Ready {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRespondRefusesOtherNames(t *testing.T) {
+	ready := true
+	r := newTestResponder(t, ":0", &ready)
+
+	resp := r.respond(context.Background(), query(2, "other.example.com", typeA))
+	if rcode := resp[3] & 0x0f; rcode != rcodeRefused {
+		t.Fatalf("rcode = %d, want REFUSED", rcode)
+	}
+	resp = r.respond(context.Background(), query(3, "ingest.example.com", 28)) // AAAA
+	if rcode := resp[3] & 0x0f; rcode != rcodeNoError || binary.BigEndian.Uint16(resp[6:8]) != 0 {
+		t.Fatalf("AAAA reply = rcode %d with %d answers, want empty NOERROR", rcode, binary.BigEndian.Uint16(resp[6:8]))
+	}
+	if resp := r.respond(context.Background(), []byte{1, 2, 3}); resp != nil {
+		t.Fatal("expected a truncated packet to be dropped")
+	}
+}
+
+func TestRunServesUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	ready := true
+	r := newTestResponder(t, addr, &ready)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, maxPacketSize)
+	// The listener may not be up yet; retry a few times.
+	for attempt := 0; attempt < 20; attempt++ {
+		conn.Write(query(7, "ingest.example.com", typeA))
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond) // ICMP refusals return at once
+			continue
+		}
+		if n < headerLen || binary.BigEndian.Uint16(buf[6:8]) != 2 {
+			t.Fatalf("unexpected reply %x", buf[:n])
+		}
+		return
+	}
+	t.Fatal("no reply from responder")
+}
+
+func TestNewDisabled(t *testing.T) {
+	r, err := New(config.DNSResponderConfig{}, nil, logger.New())
+	if err != nil || r != nil {
+		t.Fatalf("New(disabled) = %v, %v; want nil, nil", r, err)
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run on nil responder: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package dvr
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// Encrypted segments start with a header naming the key and a random salt,
+// followed by sealed records:
+//
+//	header: "RDVRGCM1" | key ID length (1 byte) | key ID | salt (16 bytes)
+//	record: sealed length (4 bytes, big endian) | kind (1 byte) | AES-GCM output
+//
+// Each segment is sealed with its own key, derived from the configured one
+// and the salt with HKDF-SHA256, so record n can use n as its nonce. The
+// header and kind are authenticated with every record. The first record
+// holds the segment's metadata and sequence headers, so a decrypted segment
+// plays on its own; then come the FLV tags, one per record, and an empty end
+// record once the segment is closed.
+const (
+	sealedMagic    = "RDVRGCM1"
+	saltSize       = 16
+	recordHeaders  = 'H'
+	recordTags     = 'T'
+	recordEnd      = 'E'
+	keyCommandWait = 30 * time.Second
+)
+
+// ErrTruncated is returned by Key.Open for a segment without its end
+// record: one still being written, or cut short by a crash.
+var ErrTruncated = errors.New("dvr: segment is truncated")
+
+// Key encrypts DVR segments at rest.
+type Key struct {
+	id     string
+	secret []byte
+}
+
+// NewKey returns the AES key secret, 16, 24 or 32 bytes long. id is written
+// into every segment, so an archive names the key it needs; it may be empty.
+func NewKey(id string, secret []byte) (*Key, error) {
+	if len(id) > 255 {
+		return nil, errors.New("dvr: key ID is longer than 255 bytes")
+	}
+	if _, err := aes.NewCipher(secret); err != nil {
+		return nil, fmt.Errorf("dvr: %w", err)
+	}
+	return &Key{id: id, secret: bytes.Clone(secret)}, nil
+}
+
+// LoadKey reads the base64 key cfg names: given inline, in a file, or
+// printed by a command such as a KMS or Vault client. It returns nil when
+// encryption is disabled.
+func LoadKey(cfg config.DVREncryptionConfig) (*Key, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var encoded []byte
+	switch {
+	case cfg.Key != "":
+		encoded = []byte(cfg.Key)
+	case cfg.KeyFile != "":
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("dvr: key file: %w", err)
+		}
+		encoded = data
+	case len(cfg.KeyCommand) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), keyCommandWait)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, cfg.KeyCommand[0], cfg.KeyCommand[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("dvr: key command: %w", err)
+		}
+		encoded = out
+	default:
+		return nil, errors.New("dvr: no encryption key configured")
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("dvr: key is not base64: %w", err)
+	}
+	return NewKey(cfg.KeyID, secret)
+}
+
+// segmentCipher derives the AES-GCM cipher of the segment with salt.
+func (k *Key) segmentCipher(salt []byte) (cipher.AEAD, error) {
+	derived, err := hkdf.Key(sha256.New, k.secret, salt, sealedMagic, len(k.secret))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealer encrypts the segment being written.
+type sealer struct {
+	aead   cipher.AEAD
+	header []byte
+	n      uint64
+}
+
+func (k *Key) newSealer() (*sealer, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := k.segmentCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(sealedMagic), byte(len(k.id)))
+	header = append(header, k.id...)
+	return &sealer{aead: aead, header: append(header, salt...)}, nil
+}
+
+// seal returns p as the segment's next record.
+func (s *sealer) seal(kind byte, p []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], s.n)
+	s.n++
+	out := make([]byte, 5, 5+len(p)+s.aead.Overhead())
+	out[4] = kind
+	out = s.aead.Seal(out, nonce, p, additionalData(s.header, kind))
+	binary.BigEndian.PutUint32(out, uint32(len(out)-5))
+	return out
+}
+
+// opener decrypts one segment as it is read, possibly while it grows.
+type opener struct {
+	key    *Key
+	aead   cipher.AEAD
+	header []byte
+	n      uint64
+	ended  bool
+}
+
+func (k *Key) newOpener() *opener {
+	return &opener{key: k}
+}
+
+// open decrypts the whole records at the start of b, which continues where
+// the last call stopped. It returns the segment's headers and tags found and
+// how much of b they took; a record cut short is left for the next call.
+func (o *opener) open(b []byte) (headers, tags []byte, used int, err error) {
+	if o.aead == nil {
+		n, err := o.readHeader(b)
+		if n == 0 || err != nil {
+			return nil, nil, 0, err
+		}
+		used = n
+	}
+	for !o.ended && len(b)-used >= 5 {
+		size := int(binary.BigEndian.Uint32(b[used:]))
+		kind := b[used+4]
+		if len(b)-used-5 < size {
+			break
+		}
+		nonce := make([]byte, o.aead.NonceSize())
+		binary.BigEndian.PutUint64(nonce[len(nonce)-8:], o.n)
+		plain, err := o.aead.Open(nil, nonce, b[used+5:used+5+size], additionalData(o.header, kind))
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("dvr: record %d does not open with key %q: %w", o.n, o.key.id, err)
+		}
+		o.n++
+		used += 5 + size
+		switch kind {
+		case recordHeaders:
+			headers = append(headers, plain...)
+		case recordTags:
+			tags = append(tags, plain...)
+		case recordEnd:
+			o.ended = true
+		default:
+			return nil, nil, 0, fmt.Errorf("dvr: unknown record kind %q", kind)
+		}
+	}
+	return headers, tags, used, nil
+}
+
+// read decrypts src, which holds whole records, into the tags they hold.
+func (o *opener) read(src io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	_, tags, used, err := o.open(data)
+	if err != nil {
+		return nil, err
+	}
+	if used != len(data) {
+		return nil, errors.New("dvr: segment record cut short")
+	}
+	return bytes.NewReader(tags), nil
+}
+
+// readHeader sets up the segment's cipher from its header, returning 0 when
+// b does not hold all of it yet.
+func (o *opener) readHeader(b []byte) (int, error) {
+	if len(b) < len(sealedMagic)+1 {
+		return 0, nil
+	}
+	if string(b[:len(sealedMagic)]) != sealedMagic {
+		return 0, errors.New("dvr: segment is not encrypted")
+	}
+	idLen := int(b[len(sealedMagic)])
+	n := len(sealedMagic) + 1 + idLen + saltSize
+	if len(b) < n {
+		return 0, nil
+	}
+	id := string(b[len(sealedMagic)+1 : len(sealedMagic)+1+idLen])
+	if o.key.id != "" && id != "" && id != o.key.id {
+		return 0, fmt.Errorf("dvr: segment is sealed with key %q, not %q", id, o.key.id)
+	}
+	aead, err := o.key.segmentCipher(b[n-saltSize : n])
+	if err != nil {
+		return 0, err
+	}
+	o.aead, o.header = aead, bytes.Clone(b[:n])
+	return n, nil
+}
+
+// Open decrypts a whole segment file, returning its metadata and sequence
+// headers and its FLV tags. A segment without its end record returns what
+// it holds along with ErrTruncated.
+func (k *Key) Open(segment []byte) (headers, tags []byte, err error) {
+	o := k.newOpener()
+	headers, tags, used, err := o.open(segment)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case !o.ended:
+		return headers, tags, ErrTruncated
+	case used != len(segment):
+		return nil, nil, errors.New("dvr: data after the segment's end record")
+	}
+	return headers, tags, nil
+}
+
+// additionalData is what each record authenticates besides its contents.
+func additionalData(header []byte, kind byte) []byte {
+	return append(bytes.Clone(header), kind)
+}
+
+// isSealed reports whether b starts like an encrypted segment.
+func isSealed(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(sealedMagic))
+}
@@ -0,0 +1,171 @@
+package dvr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func testKey(t *testing.T, id string, fill byte) *Key {
+	t.Helper()
+	k, err := NewKey(id, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptedRecording(t *testing.T) {
+	r, clk := newTestRecorder(t, time.Hour, 0)
+	r.key = testKey(t, "k1", 1)
+	tap := r.NewTap("live")
+	defer tap.Close()
+	tap.SetStream("cam")
+	record(tap, clk, 0, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if err := r.Play(ctx, &out, "cam", time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Play = %v, want it to follow live until the deadline", err)
+	}
+	tags := parseFLV(t, out.Bytes())
+	if len(tags) != 8 || tags[len(tags)-1] != (tag{rtmp.TagTypeVideo, 2500, 0x27}) {
+		t.Fatalf("played %+v, want the headers and all three GOPs", tags)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(r.dir, "*.dvr", "*.flv"))
+	if len(files) != 3 {
+		t.Fatalf("%d segment files on disk, want 3", len(files))
+	}
+	first, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(first) || bytes.Contains(first, []byte{0x17, 0x01}) {
+		t.Fatalf("segment on disk is not encrypted: %x", first)
+	}
+	headers, plain, err := r.key.Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The headers record holds the sequence header; the tags are the
+	// sequence header as recorded, then the first GOP.
+	if !bytes.Contains(headers, []byte{0x17, 0x00}) {
+		t.Fatalf("headers = %x, want the sequence header", headers)
+	}
+	if got := parseFLV(t, append([]byte("FLV\x01\x01\x00\x00\x00\x09\x00\x00\x00\x00"), plain...)); len(got) != 3 || got[1].first != 0x17 || got[2].first != 0x27 {
+		t.Fatalf("tags = %+v, want the first GOP", got)
+	}
+
+	// The segment being written has no end record yet.
+	live, _ := os.ReadFile(files[2])
+	if _, _, err := r.key.Open(live); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Open of the live segment = %v, want ErrTruncated", err)
+	}
+	if _, _, err := testKey(t, "k1", 2).Open(first); err == nil {
+		t.Fatal("Open with the wrong key succeeded")
+	}
+	if _, _, err := testKey(t, "k2", 1).Open(first); err == nil || !strings.Contains(err.Error(), `sealed with key "k1"`) {
+		t.Fatalf("Open with another key ID = %v, want it named", err)
+	}
+	tampered := bytes.Clone(first)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := r.key.Open(tampered); err == nil {
+		t.Fatal("Open of a tampered segment succeeded")
+	}
+}
+
+func TestResumeEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	idx := &memIndex{manifests: make(map[string][]byte)}
+	clk := &clock{now: time.Now()}
+	enc := config.DVREncryptionConfig{Enabled: true, Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16))}
+	open := func(enc config.DVREncryptionConfig) *Recorder {
+		t.Helper()
+		r, err := New(config.DVRConfig{Enabled: true, Dir: dir, Segment: config.Duration(time.Second), Encryption: enc}, logger.NewWithWriter(io.Discard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.now = clk.Now
+		if err := r.Attach(idx); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := open(enc)
+	tap := r.NewTap("live")
+	tap.SetStream("cam")
+	record(tap, clk, 0, 2)
+	r.Suspend()
+	tap.Close()
+
+	r = open(enc)
+	tap = r.NewTap("live")
+	tap.SetStream("cam")
+	record(tap, clk, 0, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if err := r.Play(ctx, &out, "cam", time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Play = %v, want it to follow live until the deadline", err)
+	}
+	if tags := parseFLV(t, out.Bytes()); len(tags) != 9 || tags[len(tags)-1].ts != 2001 {
+		t.Fatalf("played %+v, want both recordings", tags)
+	}
+	r.Suspend()
+	tap.Close()
+
+	// Without the key the recording cannot be read back, so it goes.
+	open(config.DVREncryptionConfig{})
+	if len(idx.manifests) != 0 {
+		t.Fatalf("manifests = %v, want the unreadable recording dropped", idx.manifests)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, 24)
+	encoded := base64.StdEncoding.EncodeToString(secret)
+	file := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(file, []byte(encoded+"\n"), 0o600)
+
+	for _, tc := range []struct {
+		name string
+		cfg  config.DVREncryptionConfig
+		err  string
+	}{
+		{"inline", config.DVREncryptionConfig{Enabled: true, KeyID: "a", Key: encoded}, ""},
+		{"file", config.DVREncryptionConfig{Enabled: true, KeyID: "a", KeyFile: file}, ""},
+		{"command", config.DVREncryptionConfig{Enabled: true, KeyID: "a", KeyCommand: []string{"echo", encoded}}, ""},
+		{"missing file", config.DVREncryptionConfig{Enabled: true, KeyFile: file + ".nope"}, "key file"},
+		{"failing command", config.DVREncryptionConfig{Enabled: true, KeyCommand: []string{"false"}}, "key command"},
+		{"not base64", config.DVREncryptionConfig{Enabled: true, Key: "not a key"}, "not base64"},
+		{"short", config.DVREncryptionConfig{Enabled: true, Key: base64.StdEncoding.EncodeToString(secret[:5])}, "invalid key size"},
+	} {
+		k, err := LoadKey(tc.cfg)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || k.id != "a" || !bytes.Equal(k.secret, secret) {
+			t.Errorf("%s: LoadKey = %+v, %v", tc.name, k, err)
+		}
+	}
+	if k, err := LoadKey(config.DVREncryptionConfig{Key: encoded}); k != nil || err != nil {
+		t.Fatalf("disabled LoadKey = %v, %v; want nil, nil", k, err)
+	}
+}
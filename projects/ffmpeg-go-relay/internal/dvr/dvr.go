@@ -0,0 +1,668 @@
+// Package dvr records each live stream to a rolling window of FLV segments on
+// disk so viewers can start playback some minutes behind live and follow the
+// stream from there. Segments are cut at video keyframes; the oldest are
+// deleted once they fall out of the window or the stream's byte cap. With a
+// Key, segments are encrypted as they are written.
+package dvr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	// DefaultWindow is how far behind live playback can start.
+	DefaultWindow = 30 * time.Minute
+	// DefaultSegment is the target length of one segment file.
+	DefaultSegment = 6 * time.Second
+)
+
+// ErrNotFound is returned by Play for streams that are not being recorded.
+var ErrNotFound = errors.New("dvr: stream is not being recorded")
+
+// Recorder owns the recordings of all live streams. A nil Recorder ignores
+// every call.
+type Recorder struct {
+	dir      string
+	window   time.Duration
+	segment  time.Duration
+	maxBytes int64
+	key      *Key // nil writes segments in the clear
+	log      *logger.Logger
+	now      func() time.Time
+	index    atomic.Pointer[Index] // Set by Attach; unset deletes every recording when its publisher leaves
+	onClosed atomic.Pointer[func(*ClosedSegment)]
+
+	suspended atomic.Bool // Set by Suspend: recordings that end are kept
+
+	mu      sync.Mutex
+	streams map[string]*recording
+	paused  map[string]*recording // Kept from the previous run, by stream
+}
+
+// StreamStats describes one stream's recording.
+type StreamStats struct {
+	Stream        string  `json:"stream"`
+	Segments      int     `json:"segments"`
+	Bytes         int64   `json:"bytes"`
+	WindowSeconds float64 `json:"window_seconds"` // How far back playback can currently start
+}
+
+// New returns the recorder described by cfg, or nil when DVR is disabled.
+// It records straight away but leaves what is already in cfg.Dir alone
+// until Attach.
+func New(cfg config.DVRConfig, log *logger.Logger) (*Recorder, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	key, err := LoadKey(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("dvr: %w", err)
+	}
+	r := newRecorder(cfg.Dir, cfg.Window.AsDuration(), cfg.Segment.AsDuration(), cfg.MaxBytes, log)
+	r.key = key
+	return r, nil
+}
+
+// Attach starts keeping manifests in index, which may be nil. Recordings a
+// previous run suspended into it wait for their publishers to come back;
+// any other recording left in the directory is removed. A relay started by
+// an upgrade attaches once the old process has released the directory, so
+// the recordings it is still writing are not removed under it.
+func (r *Recorder) Attach(index Index) error {
+	if r == nil {
+		return nil
+	}
+	if index != nil {
+		r.index.Store(&index)
+	}
+	// Recordings started after this are not stale, and start creates their
+	// directories under r.mu.
+	r.mu.Lock()
+	stale, err := filepath.Glob(filepath.Join(r.dir, "*.dvr"))
+	live := maps.Clone(r.streams)
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("dvr: %w", err)
+	}
+	kept := r.load(live)
+	for _, dir := range stale {
+		if kept[dir] {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			r.log.Warn("failed to remove stale dvr recording", "dir", dir, "err", err)
+		}
+	}
+	return nil
+}
+
+func newRecorder(dir string, window, segment time.Duration, maxBytes int64, log *logger.Logger) *Recorder {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if segment <= 0 {
+		segment = DefaultSegment
+	}
+	return &Recorder{
+		dir:      dir,
+		window:   window,
+		segment:  segment,
+		maxBytes: maxBytes,
+		log:      log,
+		now:      time.Now,
+		streams:  make(map[string]*recording),
+		paused:   make(map[string]*recording),
+	}
+}
+
+// Stats returns the recordings in progress.
+func (r *Recorder) Stats() []StreamStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	stats := make([]StreamStats, 0, len(r.streams))
+	for name, rec := range r.streams {
+		rec.mu.Lock()
+		st := StreamStats{Stream: name, Segments: len(rec.segments), Bytes: rec.bytes}
+		if len(rec.segments) > 0 {
+			st.WindowSeconds = now.Sub(rec.segments[0].started).Seconds()
+		}
+		rec.mu.Unlock()
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// FreeBytes returns the space available to unprivileged writers on the
+// filesystem holding the recordings. It returns errors.ErrUnsupported where
+// the platform cannot tell.
+func (r *Recorder) FreeBytes() (int64, error) {
+	if r == nil {
+		return 0, errors.ErrUnsupported
+	}
+	return freeBytes(r.dir)
+}
+
+// NewTap returns a Tap recording one session's media into r. app is the
+// RTMP app the session connected to, which decides who may play it back.
+func (r *Recorder) NewTap(app string) *Tap {
+	if r == nil {
+		return nil
+	}
+	app, _, _ = strings.Cut(app, "?")
+	return &Tap{rec: r, app: app}
+}
+
+// App returns the RTMP app of the live stream name, and false when the
+// stream is not being recorded.
+func (r *Recorder) App(name string) (string, bool) {
+	rec := r.lookup(name)
+	if rec == nil {
+		return "", false
+	}
+	return rec.app, true
+}
+
+func (r *Recorder) start(name, app string) (*recording, error) {
+	rec := r.unpause(name, app)
+	r.mu.Lock()
+	if rec == nil {
+		dir, err := os.MkdirTemp(r.dir, "stream-*.dvr")
+		if err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		rec = &recording{name: name, dir: dir, app: app, live: true, changed: make(chan struct{})}
+	}
+	old := r.streams[name]
+	r.streams[name] = rec
+	r.mu.Unlock()
+	if old != nil {
+		old.end(r)
+	}
+	return rec, nil
+}
+
+func (r *Recorder) stop(name string, rec *recording) {
+	r.mu.Lock()
+	if r.streams[name] == rec {
+		delete(r.streams, name)
+	}
+	r.mu.Unlock()
+	rec.end(r)
+}
+
+func (r *Recorder) lookup(name string) *recording {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[name]
+}
+
+// recording is one publisher's stream on disk. Its segments are written by
+// the session's reader and read by any number of players.
+type recording struct {
+	name string
+	dir  string
+	app  string
+
+	mu       sync.Mutex
+	changed  chan struct{} // Closed and replaced whenever data is appended or the stream ends
+	live     bool
+	failed   bool
+	hasVideo bool
+	meta     []byte // FLV tags at timestamp zero of the stream's current headers
+	videoSeq []byte
+	audioSeq []byte
+	segments []*segment
+	cur      *os.File
+	seal     *sealer // Encrypts cur when the recorder has a key
+	nextID   int
+	bytes    int64
+	lastTS   uint32      // Of the last tag written, after shift
+	shift    uint32      // Added to the publisher's timestamps
+	resumed  bool        // Set until the first message after a restart
+	expiry   *time.Timer // Drops the recording while paused
+}
+
+type segment struct {
+	id      int
+	path    string
+	started time.Time
+	ts      uint32 // Timestamp of the first tag
+	headers []byte // Metadata and sequence headers in effect when it started
+	size    int64
+	done    bool
+}
+
+func (rec *recording) observe(r *Recorder, msg *rtmp.Message) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !rec.live || rec.failed {
+		return
+	}
+
+	switch {
+	case msg.Header.TypeID == rtmp.TypeAMF0Data:
+		rec.meta = flvTag(msg, 0)
+	case msg.IsVideoSequenceHeader():
+		rec.videoSeq = flvTag(msg, 0)
+	case msg.IsAACSequenceHeader():
+		rec.audioSeq = flvTag(msg, 0)
+	}
+	if msg.Header.TypeID == rtmp.TypeVideo {
+		rec.hasVideo = true
+	}
+	if rec.resumed {
+		// A publisher back after a restart starts its clock again; carry
+		// on from where the recording stopped.
+		rec.resumed = false
+		if msg.Header.Timestamp <= rec.lastTS {
+			rec.shift = rec.lastTS + 1 - msg.Header.Timestamp
+		}
+	}
+	ts := msg.Header.Timestamp + rec.shift
+
+	cut := msg.IsVideoKeyframe() && !msg.IsVideoSequenceHeader()
+	if !rec.hasVideo {
+		cut = msg.Header.TypeID == rtmp.TypeAudio
+	}
+	if n := len(rec.segments); n == 0 || rec.cur == nil || (cut && segmentAge(rec.segments[n-1], ts) >= r.segment) {
+		if err := rec.rotate(r, ts); err != nil {
+			rec.fail(r, err)
+			return
+		}
+	}
+
+	tag := flvTag(msg, ts)
+	if rec.seal != nil {
+		tag = rec.seal.seal(recordTags, tag)
+	}
+	if err := rec.write(tag); err != nil {
+		rec.fail(r, err)
+		return
+	}
+	rec.lastTS = ts
+	rec.notify()
+}
+
+// segmentAge measures a segment by the stream's clock, so cuts follow the
+// media rather than when it happened to arrive.
+func segmentAge(seg *segment, ts uint32) time.Duration {
+	return time.Duration(ts-seg.ts) * time.Millisecond
+}
+
+func (rec *recording) rotate(r *Recorder, ts uint32) error {
+	if err := rec.finish(r); err != nil {
+		return err
+	}
+	path := segmentPath(rec.dir, rec.nextID)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	var headers []byte
+	for _, tag := range [][]byte{rec.meta, rec.videoSeq, rec.audioSeq} {
+		headers = append(headers, tag...)
+	}
+	rec.cur = f
+	rec.segments = append(rec.segments, &segment{
+		id:      rec.nextID,
+		path:    path,
+		started: r.now(),
+		ts:      ts,
+		headers: headers,
+	})
+	rec.nextID++
+	if r.key != nil {
+		// The headers go into the segment too, so a decrypted one plays on
+		// its own; Play still sends them from memory.
+		if rec.seal, err = r.key.newSealer(); err != nil {
+			return err
+		}
+		if err := rec.write(append(bytes.Clone(rec.seal.header), rec.seal.seal(recordHeaders, headers)...)); err != nil {
+			return err
+		}
+	}
+	rec.prune(r)
+	rec.persist(r)
+	return nil
+}
+
+// write appends p to the segment being written.
+func (rec *recording) write(p []byte) error {
+	if _, err := rec.cur.Write(p); err != nil {
+		return err
+	}
+	rec.segments[len(rec.segments)-1].size += int64(len(p))
+	rec.bytes += int64(len(p))
+	return nil
+}
+
+// finish closes the segment being written, if any, ending it with an end
+// record when it is encrypted, and hands it to the OnSegmentClosed hook.
+func (rec *recording) finish(r *Recorder) error {
+	if rec.cur == nil {
+		return nil
+	}
+	var err error
+	if rec.seal != nil {
+		err = rec.write(rec.seal.seal(recordEnd, nil))
+		rec.seal = nil
+	}
+	seg := rec.segments[len(rec.segments)-1]
+	seg.done = true
+	if cerr := rec.cur.Close(); err == nil {
+		err = cerr
+	}
+	rec.cur = nil
+	if err == nil {
+		rec.closed(r, seg)
+	}
+	return err
+}
+
+func segmentPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.flv", id))
+}
+
+// prune deletes the oldest segments while the next one already covers the
+// window, or while the recording is over its byte cap. The segment being
+// written is always kept.
+func (rec *recording) prune(r *Recorder) {
+	horizon := r.now().Add(-r.window)
+	for len(rec.segments) > 1 {
+		oldest := rec.segments[0]
+		overWindow := !rec.segments[1].started.After(horizon)
+		overBytes := r.maxBytes > 0 && rec.bytes > r.maxBytes
+		if !overWindow && !overBytes {
+			return
+		}
+		rec.segments = rec.segments[1:]
+		rec.bytes -= oldest.size
+		if err := os.Remove(oldest.path); err != nil {
+			r.log.Warn("failed to remove dvr segment", "path", oldest.path, "err", err)
+		}
+	}
+}
+
+// fail stops recording the stream after a disk error; players see it end.
+func (rec *recording) fail(r *Recorder, err error) {
+	r.log.Warn("dvr recording failed", "dir", rec.dir, "err", err)
+	rec.failed = true
+	rec.notify()
+}
+
+func (rec *recording) notify() {
+	close(rec.changed)
+	rec.changed = make(chan struct{})
+}
+
+// end closes the recording and deletes it. Players that are reading a
+// segment finish it; none can open another. Once r is suspended the files
+// and manifest stay behind for the next run to resume.
+func (rec *recording) end(r *Recorder) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !rec.live {
+		return
+	}
+	rec.live = false
+	if err := rec.finish(r); err != nil && !rec.failed {
+		r.log.Warn("failed to close dvr segment", "dir", rec.dir, "err", err)
+	}
+	if r.suspended.Load() && !rec.failed && len(rec.segments) > 0 {
+		rec.persist(r)
+		rec.notify()
+		return
+	}
+	rec.segments, rec.bytes = nil, 0
+	rec.notify()
+	os.RemoveAll(rec.dir)
+	r.forget(rec.name)
+}
+
+// Play writes name as an FLV stream starting at the keyframe nearest to
+// offset behind live, then follows the live stream until it ends or ctx is
+// done. Offsets beyond the window start at its oldest segment. ErrNotFound
+// is returned before anything is written.
+func (r *Recorder) Play(ctx context.Context, w io.Writer, name string, offset time.Duration) error {
+	rec := r.lookup(name)
+	if rec == nil {
+		return ErrNotFound
+	}
+
+	rec.mu.Lock()
+	if len(rec.segments) == 0 || !rec.live {
+		rec.mu.Unlock()
+		return ErrNotFound
+	}
+	target := r.now().Add(-offset)
+	start := rec.segments[0]
+	for _, seg := range rec.segments[1:] {
+		if seg.started.After(target) {
+			break
+		}
+		start = seg
+	}
+	id, base, headers := start.id, start.ts, start.headers
+	rec.mu.Unlock()
+
+	if err := rtmp.WriteFLVHeader(w, true, true); err != nil {
+		return err
+	}
+	if _, err := w.Write(headers); err != nil {
+		return err
+	}
+
+	var (
+		f   *os.File
+		pos int64
+		dec *opener // Decrypts f when segments are encrypted
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for {
+		rec.mu.Lock()
+		seg := rec.segment(id)
+		live, failed, changed := rec.live, rec.failed, rec.changed
+		var size int64
+		var done bool
+		if seg != nil {
+			size, done = seg.size, seg.done
+		}
+		rec.mu.Unlock()
+
+		switch {
+		case seg == nil && (!live || failed):
+			return nil
+		case seg == nil:
+			// Nothing newer than id yet.
+		case seg.id != id || f == nil:
+			// First read of this segment, or the one being read was pruned.
+			if f != nil {
+				f.Close()
+			}
+			id, pos, dec = seg.id, 0, nil
+			var err error
+			if f, err = os.Open(seg.path); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					f = nil
+					continue
+				}
+				return err
+			}
+			if r.key != nil {
+				dec = r.key.newOpener()
+			}
+			continue
+		case pos < size:
+			var src io.Reader = io.NewSectionReader(f, pos, size-pos)
+			if dec != nil {
+				var err error
+				if src, err = dec.read(src); err != nil {
+					return err
+				}
+			}
+			if err := copyTags(w, src, base); err != nil {
+				return err
+			}
+			pos = size
+			continue
+		case done:
+			f.Close()
+			f, id, pos = nil, id+1, 0
+			continue
+		case !live || failed:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// segment returns the first segment with an id of at least id.
+func (rec *recording) segment(id int) *segment {
+	for _, seg := range rec.segments {
+		if seg.id >= id {
+			return seg
+		}
+	}
+	return nil
+}
+
+// copyTags copies whole FLV tags from src to w, moving their timestamps back
+// by base so playback starts near zero.
+func copyTags(w io.Writer, src io.Reader, base uint32) error {
+	br := bufio.NewReader(src)
+	var header [11]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		ts := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+		if ts >= base {
+			ts -= base
+		} else {
+			ts = 0
+		}
+		header[4], header[5], header[6], header[7] = byte(ts>>16), byte(ts>>8), byte(ts), byte(ts>>24)
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, br, size+4); err != nil {
+			return err
+		}
+	}
+}
+
+func flvTag(msg *rtmp.Message, ts uint32) []byte {
+	m := *msg
+	m.Header.Timestamp = ts
+	var buf bytes.Buffer
+	rtmp.MessageToFLVTag(&buf, &m)
+	return buf.Bytes()
+}
+
+// Tap records one session's media under the stream name the client
+// published. Like thumbnail.Tap it is fed from the session's reader while
+// another goroutine closes it; a nil Tap ignores every call.
+type Tap struct {
+	rec *Recorder
+	app string
+
+	mu     sync.Mutex
+	stream string
+	cur    *recording
+	closed bool
+}
+
+// SetStream names the stream the session's media belongs to and starts its
+// recording, replacing any earlier recording of that name.
+func (t *Tap) SetStream(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || name == t.stream {
+		return
+	}
+	t.stopLocked()
+	rec, err := t.rec.start(name, t.app)
+	if err != nil {
+		t.rec.log.Warn("failed to start dvr recording", "stream", name, "err", err)
+		return
+	}
+	t.stream, t.cur = name, rec
+}
+
+// Observe records one message from the session.
+func (t *Tap) Observe(msg *rtmp.Message) {
+	if t == nil {
+		return
+	}
+	switch msg.Header.TypeID {
+	case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+	default:
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur != nil {
+		t.cur.observe(t.rec, msg)
+	}
+}
+
+// Close ends the recording and deletes it; the stream is no longer live.
+func (t *Tap) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.stopLocked()
+}
+
+func (t *Tap) stopLocked() {
+	if t.cur != nil {
+		t.rec.stop(t.stream, t.cur)
+	}
+	t.stream, t.cur = "", nil
+}
@@ -0,0 +1,442 @@
+package dvr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func video(ts uint32, payload ...byte) *rtmp.Message {
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts}, Payload: payload}
+}
+
+type tag struct {
+	typ   uint8
+	ts    uint32
+	first byte
+}
+
+func parseFLV(t *testing.T, b []byte) []tag {
+	t.Helper()
+	if len(b) < 13 || string(b[:3]) != "FLV" {
+		t.Fatalf("missing FLV header: %x", b)
+	}
+	b = b[13:]
+	var tags []tag
+	for len(b) > 0 {
+		if len(b) < 15 {
+			t.Fatalf("truncated tag: %x", b)
+		}
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		ts := uint32(b[7])<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+		tags = append(tags, tag{typ: b[0], ts: ts, first: b[11]})
+		b = b[11+size+4:]
+	}
+	return tags
+}
+
+// readTag reads one tag and its trailing previous-tag size from a player.
+func readTag(t *testing.T, r io.Reader) ([]byte, tag) {
+	t.Helper()
+	head := make([]byte, 11)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("read tag header: %v", err)
+	}
+	size := int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	raw := append(head, make([]byte, size+4)...)
+	if _, err := io.ReadFull(r, raw[11:]); err != nil {
+		t.Fatalf("read tag body: %v", err)
+	}
+	ts := uint32(head[7])<<24 | uint32(head[4])<<16 | uint32(head[5])<<8 | uint32(head[6])
+	return raw, tag{typ: head[0], ts: ts, first: raw[11]}
+}
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// record feeds seconds of 1s GOPs (a keyframe and an inter frame each) after
+// a sequence header, advancing clk with the stream.
+func record(tap *Tap, clk *clock, from, seconds int) {
+	if from == 0 {
+		tap.Observe(video(0, 0x17, 0x00))
+	}
+	for i := from; i < from+seconds; i++ {
+		ts := uint32(i * 1000)
+		tap.Observe(video(ts, 0x17, 0x01))
+		tap.Observe(video(ts+500, 0x27, 0x01))
+		clk.Advance(time.Second)
+	}
+}
+
+func newTestRecorder(t *testing.T, window time.Duration, maxBytes int64) (*Recorder, *clock) {
+	t.Helper()
+	r := newRecorder(t.TempDir(), window, time.Second, maxBytes, logger.NewWithWriter(io.Discard))
+	clk := &clock{now: time.Unix(1000, 0)}
+	r.now = clk.Now
+	return r, clk
+}
+
+func TestPlayFromOffset(t *testing.T) {
+	r, clk := newTestRecorder(t, time.Hour, 0)
+	tap := r.NewTap("live")
+	defer tap.Close()
+	tap.SetStream("cam")
+	record(tap, clk, 0, 10)
+
+	stats := r.Stats()
+	if len(stats) != 1 || stats[0].Segments != 10 {
+		t.Fatalf("stats = %+v, want 10 segments", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if err := r.Play(ctx, &out, "cam", 3*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Play = %v, want it to follow live until the deadline", err)
+	}
+
+	tags := parseFLV(t, out.Bytes())
+	// The sequence header, then 3 seconds before live: the GOPs at 7s, 8s and 9s.
+	want := []tag{
+		{rtmp.TagTypeVideo, 0, 0x17}, {rtmp.TagTypeVideo, 0, 0x17}, {rtmp.TagTypeVideo, 500, 0x27},
+		{rtmp.TagTypeVideo, 1000, 0x17}, {rtmp.TagTypeVideo, 1500, 0x27},
+		{rtmp.TagTypeVideo, 2000, 0x17}, {rtmp.TagTypeVideo, 2500, 0x27},
+	}
+	if len(tags) != len(want) {
+		t.Fatalf("got %d tags %+v, want %+v", len(tags), tags, want)
+	}
+	for i := range want {
+		if tags[i] != want[i] {
+			t.Fatalf("tag %d = %+v, want %+v", i, tags[i], want[i])
+		}
+	}
+}
+
+func TestPlayFollowsLive(t *testing.T) {
+	r, clk := newTestRecorder(t, time.Hour, 0)
+	tap := r.NewTap("live")
+	tap.SetStream("cam")
+	record(tap, clk, 0, 2)
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Play(context.Background(), pw, "cam", 0)
+		pw.Close()
+	}()
+	// Play picks its start segment before writing the header.
+	played := make([]byte, 13)
+	if _, err := io.ReadFull(pr, played); err != nil {
+		t.Fatal(err)
+	}
+
+	record(tap, clk, 2, 2)
+	// Read until the player has caught up with the last recorded frame
+	// before the stream ends and its files go.
+	for {
+		raw, tg := readTag(t, pr)
+		played = append(played, raw...)
+		if tg.ts == 2500 {
+			break
+		}
+	}
+	got := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(pr)
+		got <- append(played, b...)
+	}()
+	tap.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Play = %v, want nil when the stream ends", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Play did not return after the stream ended")
+	}
+	tags := parseFLV(t, <-got)
+	if last := tags[len(tags)-1]; last.ts != 2500 {
+		t.Fatalf("last tag = %+v, want the inter frame at 3.5s played as 2.5s", last)
+	}
+	if _, err := os.Stat(r.dir); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(r.dir, "*.dvr")); len(left) != 0 {
+		t.Fatalf("recordings left after the stream ended: %v", left)
+	}
+}
+
+func TestPruneWindowAndBytes(t *testing.T) {
+	r, clk := newTestRecorder(t, 3*time.Second, 0)
+	tap := r.NewTap("live")
+	defer tap.Close()
+	tap.SetStream("cam")
+	record(tap, clk, 0, 10)
+	// One more keyframe starts segment 10 and prunes everything before 7s.
+	tap.Observe(video(10000, 0x17, 0x01))
+
+	st := r.Stats()[0]
+	if st.Segments != 4 || st.WindowSeconds != 3 {
+		t.Fatalf("stats = %+v, want 4 segments covering 3s", st)
+	}
+	files, _ := filepath.Glob(filepath.Join(r.dir, "*.dvr", "*.flv"))
+	if len(files) != 4 {
+		t.Fatalf("%d segment files on disk, want 4", len(files))
+	}
+
+	r, clk = newTestRecorder(t, time.Hour, 100)
+	tap = r.NewTap("live")
+	defer tap.Close()
+	tap.SetStream("cam")
+	record(tap, clk, 0, 10)
+	// Each 1s segment holds two 17-byte tags; the cap leaves two full
+	// segments behind the one being written.
+	if st := r.Stats()[0]; st.Segments != 3 || st.Bytes != 102 {
+		t.Fatalf("stats = %+v, want 3 segments of 102 bytes", st)
+	}
+}
+
+func TestPlayUnknownStream(t *testing.T) {
+	r, _ := newTestRecorder(t, time.Hour, 0)
+	var out bytes.Buffer
+	if err := r.Play(context.Background(), &out, "nope", 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Play = %v, want ErrNotFound", err)
+	}
+	if out.Len() != 0 {
+		t.Fatal("Play wrote output for an unknown stream")
+	}
+	var nilRecorder *Recorder
+	if err := nilRecorder.Play(context.Background(), &out, "cam", 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("nil Recorder Play = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAttachRemovesStaleRecordings(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stream-1.dvr")
+	keep := filepath.Join(dir, "notes.txt")
+	os.Mkdir(stale, 0o755)
+	os.WriteFile(keep, nil, 0o644)
+
+	r, err := New(config.DVRConfig{Enabled: true, Dir: dir}, logger.NewWithWriter(io.Discard))
+	if err != nil || r == nil {
+		t.Fatalf("New = %v, %v", r, err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatal("New removed a recording before Attach")
+	}
+	if err := r.Attach(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("stale recording was not removed")
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatal("unrelated file was removed")
+	}
+
+	if r, err := New(config.DVRConfig{}, nil); r != nil || err != nil {
+		t.Fatalf("disabled New = %v, %v; want nil, nil", r, err)
+	}
+}
+
+// memIndex keeps manifests the way the state store does, across Recorders.
+type memIndex struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+}
+
+func (m *memIndex) SaveRecording(stream string, manifest []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.manifests[stream] = manifest
+	return nil
+}
+
+func (m *memIndex) DeleteRecording(stream string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.manifests, stream)
+	return nil
+}
+
+func (m *memIndex) Recordings() (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string][]byte, len(m.manifests))
+	for k, v := range m.manifests {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	idx := &memIndex{manifests: make(map[string][]byte)}
+	// Attach prunes restored recordings by the real clock.
+	clk := &clock{now: time.Now()}
+	open := func() *Recorder {
+		t.Helper()
+		r, err := New(config.DVRConfig{Enabled: true, Dir: dir, Segment: config.Duration(time.Second)}, logger.NewWithWriter(io.Discard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.now = clk.Now
+		if err := r.Attach(idx); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := open()
+	tap := r.NewTap("live?token=x")
+	tap.SetStream("cam")
+	record(tap, clk, 0, 3)
+	lobby := r.NewTap("live")
+	lobby.SetStream("lobby")
+	record(lobby, clk, 0, 1)
+	// The lobby's publisher leaves before the restart; its recording goes.
+	lobby.Close()
+	r.Suspend()
+	tap.Close()
+	if _, ok := idx.manifests["lobby"]; ok || len(idx.manifests) != 1 {
+		t.Fatalf("manifests after shutdown = %v, want cam's only", idx.manifests)
+	}
+
+	r = open()
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.dvr")); len(left) != 1 {
+		t.Fatalf("recordings on disk after restart = %v, want cam's only", left)
+	}
+	var out bytes.Buffer
+	if err := r.Play(context.Background(), &out, "cam", 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Play before the publisher is back = %v, want ErrNotFound", err)
+	}
+
+	// The publisher comes back with its clock starting over.
+	tap = r.NewTap("live")
+	defer tap.Close()
+	tap.SetStream("cam")
+	record(tap, clk, 0, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Play(ctx, &out, "cam", time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Play = %v, want it to follow live until the deadline", err)
+	}
+	tags := parseFLV(t, out.Bytes())
+	for i := 1; i < len(tags); i++ {
+		if tags[i].ts < tags[i-1].ts {
+			t.Fatalf("timestamps go back at tag %d: %+v", i, tags)
+		}
+	}
+	// Before the restart: frames up to 2.5s; after it the new publisher's
+	// frames follow on from there.
+	if first, last := tags[1], tags[len(tags)-1]; first.ts != 0 || last.ts != 2501+1500 {
+		t.Fatalf("played %+v, want the recording from before the restart followed by the new frames", tags)
+	}
+}
+
+func TestAttachAfterUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	idx := &memIndex{manifests: make(map[string][]byte)}
+	clk := &clock{now: time.Now()}
+	cfg := config.DVRConfig{Enabled: true, Dir: dir, Segment: config.Duration(time.Second)}
+	log := logger.NewWithWriter(io.Discard)
+
+	old, err := New(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.now = clk.Now
+	old.Attach(idx)
+	oldCam := old.NewTap("live")
+	oldCam.SetStream("cam")
+	record(oldCam, clk, 0, 2)
+	oldLobby := old.NewTap("live")
+	oldLobby.SetStream("lobby")
+	record(oldLobby, clk, 0, 2)
+
+	// The new process records while the old one drains.
+	r, err := New(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = clk.Now
+	cam := r.NewTap("live")
+	defer cam.Close()
+	cam.SetStream("cam")
+	record(cam, clk, 0, 1)
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.dvr")); len(left) != 3 {
+		t.Fatalf("recordings on disk before Attach = %v, want all three", left)
+	}
+
+	old.Suspend()
+	oldCam.Close()
+	oldLobby.Close()
+	if err := r.Attach(idx); err != nil {
+		t.Fatal(err)
+	}
+	// cam's publisher is back already, so its old recording goes; lobby's
+	// waits for its publisher.
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.dvr")); len(left) != 2 {
+		t.Fatalf("recordings on disk after Attach = %v, want cam's new one and lobby's", left)
+	}
+	var m manifest
+	if err := json.Unmarshal(idx.manifests["cam"], &m); err != nil || m.Dir != r.lookup("cam").dir {
+		t.Fatalf("cam manifest = %+v, %v; want the new recording", m, err)
+	}
+	lobby := r.NewTap("live")
+	defer lobby.Close()
+	lobby.SetStream("lobby")
+	record(lobby, clk, 0, 1)
+	if st := r.Stats(); len(st) != 2 {
+		t.Fatalf("stats = %+v, want both streams", st)
+	}
+	for _, st := range r.Stats() {
+		if st.Stream == "lobby" && st.Segments < 2 {
+			t.Fatalf("lobby = %+v, want its old segments resumed", st)
+		}
+	}
+}
+
+func TestFreeBytes(t *testing.T) {
+	r := newRecorder(t.TempDir(), 0, 0, 0, logger.NewWithWriter(io.Discard))
+	free, err := r.FreeBytes()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space is not reported on this platform")
+	}
+	if err != nil || free <= 0 {
+		t.Fatalf("FreeBytes = %d, %v; want a positive size", free, err)
+	}
+
+	r = newRecorder(filepath.Join(t.TempDir(), "missing"), 0, 0, 0, logger.NewWithWriter(io.Discard))
+	if _, err := r.FreeBytes(); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,114 @@
+package dvr
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// ClosedSegment is a segment the recorder has finished writing, as handed to
+// the OnSegmentClosed hook.
+type ClosedSegment struct {
+	Stream   string
+	App      string
+	ID       int
+	Started  time.Time
+	Duration time.Duration // From its first tag to its last
+	Bytes    int64         // On disk, encrypted or not
+
+	path    string
+	headers []byte
+	key     *Key
+}
+
+// OnSegmentClosed registers fn to be called with every segment the recorder
+// finishes, except those of a failed recording. fn runs with the stream's
+// recording locked, so it must return quickly and not call back into the
+// recorder. The segment file may be pruned as soon as fn returns: Keep it to
+// read it later.
+func (r *Recorder) OnSegmentClosed(fn func(*ClosedSegment)) {
+	if r == nil {
+		return
+	}
+	r.onClosed.Store(&fn)
+}
+
+// closed hands the segment just finished to the OnSegmentClosed hook.
+func (rec *recording) closed(r *Recorder, seg *segment) {
+	fn := r.onClosed.Load()
+	if fn == nil || rec.failed {
+		return
+	}
+	var d time.Duration
+	if rec.lastTS > seg.ts {
+		d = time.Duration(rec.lastTS-seg.ts) * time.Millisecond
+	}
+	(*fn)(&ClosedSegment{
+		Stream:   rec.name,
+		App:      rec.app,
+		ID:       seg.id,
+		Started:  seg.started,
+		Duration: d,
+		Bytes:    seg.size,
+		path:     seg.path,
+		headers:  seg.headers,
+		key:      r.key,
+	})
+}
+
+// Keep links the segment file to path, or copies it where links are not
+// supported, so it outlives the recording. The segment is read from path
+// from then on.
+func (c *ClosedSegment) Keep(path string) error {
+	if err := os.Link(c.path, path); err != nil {
+		if err := copyFile(c.path, path); err != nil {
+			return err
+		}
+	}
+	c.path = path
+	return nil
+}
+
+// WriteFLV writes the segment as a standalone FLV file: the metadata and
+// sequence headers in effect when it started, then its tags, decrypted if
+// need be.
+func (c *ClosedSegment) WriteFLV(w io.Writer) error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	tags := data
+	if c.key != nil {
+		if _, tags, err = c.key.Open(data); err != nil {
+			return err
+		}
+	}
+	if err := rtmp.WriteFLVHeader(w, true, true); err != nil {
+		return err
+	}
+	if _, err := w.Write(c.headers); err != nil {
+		return err
+	}
+	_, err = w.Write(tags)
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
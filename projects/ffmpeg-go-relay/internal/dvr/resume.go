@@ -0,0 +1,277 @@
+package dvr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Index keeps the manifest of each recording so a restarted relay can pick
+// it up again. *state.Store implements it.
+type Index interface {
+	SaveRecording(stream string, manifest []byte) error
+	DeleteRecording(stream string) error
+	Recordings() (map[string][]byte, error)
+}
+
+// manifest is what Index stores about a recording. Segment sizes are not
+// kept: they are read back from the files, which may have grown since.
+type manifest struct {
+	Dir      string            `json:"dir"`
+	App      string            `json:"app,omitempty"`
+	NextID   int               `json:"next_id"`
+	Segments []segmentManifest `json:"segments"`
+}
+
+type segmentManifest struct {
+	ID      int       `json:"id"`
+	Started time.Time `json:"started"`
+	TS      uint32    `json:"ts"`
+	Headers []byte    `json:"headers,omitempty"`
+}
+
+// Suspend keeps the recordings of sessions that end from now on, instead of
+// deleting them, so the next run can resume them when their publishers
+// reconnect. Call it when the relay starts shutting down. Without an Index
+// there is nothing to resume from and Suspend does nothing.
+func (r *Recorder) Suspend() {
+	if r == nil || r.indexed() == nil {
+		return
+	}
+	r.suspended.Store(true)
+}
+
+// indexed returns the Index given to Attach, or nil.
+func (r *Recorder) indexed() Index {
+	if p := r.index.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// persist saves the recording's manifest. Callers hold rec.mu.
+func (rec *recording) persist(r *Recorder) {
+	index := r.indexed()
+	if index == nil {
+		return
+	}
+	m := manifest{Dir: rec.dir, App: rec.app, NextID: rec.nextID}
+	for _, seg := range rec.segments {
+		m.Segments = append(m.Segments, segmentManifest{ID: seg.id, Started: seg.started, TS: seg.ts, Headers: seg.headers})
+	}
+	data, err := json.Marshal(m)
+	if err == nil {
+		err = index.SaveRecording(rec.name, data)
+	}
+	if err != nil {
+		r.log.Warn("failed to save dvr recording manifest", "stream", rec.name, "err", err)
+	}
+}
+
+// forget drops name's manifest once its recording is deleted.
+func (r *Recorder) forget(name string) {
+	index := r.indexed()
+	if index == nil {
+		return
+	}
+	if err := index.DeleteRecording(name); err != nil {
+		r.log.Warn("failed to delete dvr recording manifest", "stream", name, "err", err)
+	}
+}
+
+// load pauses the recordings listed in the index, each until its publisher
+// comes back or the window passes, and returns their directories along with
+// those of the live recordings. A live recording replaces the paused one of
+// its stream: its publisher came back before Attach.
+func (r *Recorder) load(live map[string]*recording) map[string]bool {
+	kept := make(map[string]bool)
+	for _, rec := range live {
+		kept[rec.dir] = true
+	}
+	defer func() {
+		for _, rec := range live {
+			rec.mu.Lock()
+			if rec.live {
+				rec.persist(r)
+			}
+			rec.mu.Unlock()
+		}
+	}()
+
+	index := r.indexed()
+	if index == nil {
+		return kept
+	}
+	manifests, err := index.Recordings()
+	if err != nil {
+		r.log.Warn("failed to load dvr recordings", "err", err)
+		return kept
+	}
+	for name, data := range manifests {
+		if live[name] != nil {
+			continue
+		}
+		rec, err := r.restore(name, data)
+		if err != nil {
+			r.log.Warn("failed to restore dvr recording", "stream", name, "err", err)
+		}
+		if rec == nil {
+			r.forget(name)
+			continue
+		}
+		r.mu.Lock()
+		if r.streams[name] != nil {
+			// Its publisher came back while it was being restored.
+			r.mu.Unlock()
+			continue
+		}
+		kept[rec.dir] = true
+		rec.expiry = time.AfterFunc(r.window, func() { r.expire(name, rec) })
+		r.paused[name] = rec
+		r.mu.Unlock()
+		r.log.Info("dvr recording waiting for its publisher", "stream", name, "segments", len(rec.segments))
+	}
+	return kept
+}
+
+// restore rebuilds a recording from its manifest and segment files. It
+// returns nil when nothing of it is left on disk.
+func (r *Recorder) restore(name string, data []byte) (*recording, error) {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if filepath.Dir(m.Dir) != filepath.Clean(r.dir) {
+		return nil, fmt.Errorf("recording %s is not in %s", m.Dir, r.dir)
+	}
+	rec := &recording{name: name, dir: m.Dir, app: m.App, changed: make(chan struct{}), nextID: m.NextID}
+	for _, sm := range m.Segments {
+		path := segmentPath(m.Dir, sm.ID)
+		size, last, err := r.completeTags(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.segments = append(rec.segments, &segment{
+			id:      sm.ID,
+			path:    path,
+			started: sm.Started,
+			ts:      sm.TS,
+			headers: sm.Headers,
+			size:    size,
+			done:    true,
+		})
+		rec.bytes += size
+		rec.lastTS = max(rec.lastTS, last)
+	}
+	if len(rec.segments) == 0 {
+		os.RemoveAll(m.Dir)
+		return nil, nil
+	}
+	rec.prune(r)
+	return rec, nil
+}
+
+// completeTags returns the length of the whole FLV tags at the start of a
+// segment, leaving out one cut short by a crash, and the last timestamp. For
+// encrypted segments it is the length of the whole records.
+func (r *Recorder) completeTags(path string) (int64, uint32, error) {
+	if r.key != nil {
+		return sealedTags(path, r.key)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		pos    int64
+		last   uint32
+		header [11]byte
+	)
+	for {
+		if _, err := f.ReadAt(header[:], pos); err != nil {
+			if errors.Is(err, io.EOF) {
+				return pos, last, nil
+			}
+			return 0, 0, err
+		}
+		if pos == 0 && isSealed(header[:]) {
+			return 0, 0, errors.New("dvr: segment is encrypted and no key is configured")
+		}
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		end := pos + 11 + size + 4
+		if end > info.Size() {
+			return pos, last, nil
+		}
+		last = uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+		pos = end
+	}
+}
+
+// sealedTags is completeTags for an encrypted segment.
+func sealedTags(path string, key *Key) (int64, uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, tags, used, err := key.newOpener().open(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	var last uint32
+	for pos := 0; pos+11 <= len(tags); {
+		header := tags[pos:]
+		last = uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+		pos += 11 + (int(header[1])<<16 | int(header[2])<<8 | int(header[3])) + 4
+	}
+	return int64(used), last, nil
+}
+
+// unpause hands the paused recording of name to a publisher of app, or
+// returns nil when there is none for it. A paused recording of another app
+// is deleted: the name now belongs to a new recording.
+func (r *Recorder) unpause(name, app string) *recording {
+	r.mu.Lock()
+	rec := r.paused[name]
+	delete(r.paused, name)
+	r.mu.Unlock()
+	if rec == nil {
+		return nil
+	}
+	rec.expiry.Stop()
+	if rec.app != app {
+		os.RemoveAll(rec.dir)
+		return nil
+	}
+
+	rec.mu.Lock()
+	rec.live, rec.resumed = true, true
+	rec.prune(r)
+	rec.mu.Unlock()
+	r.log.Info("resuming dvr recording", "stream", name, "segments", len(rec.segments))
+	return rec
+}
+
+// expire deletes a paused recording whose publisher did not come back.
+func (r *Recorder) expire(name string, rec *recording) {
+	r.mu.Lock()
+	if r.paused[name] != rec {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.paused, name)
+	r.mu.Unlock()
+	os.RemoveAll(rec.dir)
+	r.forget(name)
+}
@@ -0,0 +1,14 @@
+package dvr
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func freeBytes(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, fmt.Errorf("dvr: statfs: %w", err)
+	}
+	return int64(st.Bavail) * st.Bsize, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package dvr
+
+import "errors"
+
+// freeBytes is only implemented on Linux.
+func freeBytes(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
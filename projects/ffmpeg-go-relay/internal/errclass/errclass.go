@@ -0,0 +1,126 @@
+// Package errclass sorts the relay's failures into classes. The rtmp,
+// transcoder and relay packages return the typed errors below, so callers
+// tell failures apart with errors.As or Of rather than by their messages,
+// and logs, metrics and the admin API label them with the same class.
+package errclass
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Class is a category of failure.
+type Class string
+
+const (
+	None         Class = ""              // No error
+	Auth         Class = "auth"          // A client failed authentication
+	Protocol     Class = "protocol"      // A peer broke the RTMP protocol or sent malformed data
+	UpstreamDial Class = "upstream_dial" // The upstream could not be reached
+	Upstream     Class = "upstream"      // The upstream failed or refused after it was reached
+	Transcode    Class = "transcode"     // The transcoder could not start or died
+	Other        Class = "other"         // Anything unclassified, e.g. a dropped connection
+)
+
+// Classes lists every class of an error, for metric labels.
+var Classes = []Class{Auth, Protocol, UpstreamDial, Upstream, Transcode, Other}
+
+// Classifier is implemented by errors that know their class.
+type Classifier interface {
+	error
+	Class() Class
+}
+
+// Of returns the class of the outermost Classifier in err's chain, None for
+// nil and Other when nothing in the chain is classified.
+func Of(err error) Class {
+	if err == nil {
+		return None
+	}
+	var c Classifier
+	if errors.As(err, &c) {
+		return c.Class()
+	}
+	return Other
+}
+
+// AuthError is a client failing authentication.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return "authentication failed: " + e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+func (e *AuthError) Class() Class  { return Auth }
+
+// ProtocolError is a peer breaking the RTMP protocol during Op, e.g.
+// "handshake", "chunk" or "amf".
+type ProtocolError struct {
+	Op  string
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+func (e *ProtocolError) Class() Class  { return Protocol }
+
+// Protocolf returns a *ProtocolError for Op formatted like fmt.Errorf.
+func Protocolf(op, format string, args ...any) error {
+	return &ProtocolError{Op: op, Err: fmt.Errorf(format, args...)}
+}
+
+// UpstreamDialError is a session's failure to reach its upstream: the dial,
+// an open circuit breaker or a dial rate limit. It carries the session's
+// request_id, so the error can be joined with the session's logs.
+type UpstreamDialError struct {
+	RequestID string
+	Upstream  string
+	Err       error
+}
+
+func (e *UpstreamDialError) Error() string {
+	return withRequestID(e.RequestID, fmt.Sprintf("dial upstream %s: %v", e.Upstream, e.Err))
+}
+
+func (e *UpstreamDialError) Unwrap() error { return e.Err }
+func (e *UpstreamDialError) Class() Class  { return UpstreamDial }
+
+// UpstreamError is an upstream failing or refusing the session during Op,
+// e.g. "handshake", "connect" or "publish", once it was reached.
+type UpstreamError struct {
+	RequestID string
+	Upstream  string
+	Op        string
+	Err       error
+}
+
+func (e *UpstreamError) Error() string {
+	return withRequestID(e.RequestID, fmt.Sprintf("%s upstream %s: %v", e.Op, e.Upstream, e.Err))
+}
+
+func (e *UpstreamError) Unwrap() error { return e.Err }
+func (e *UpstreamError) Class() Class  { return Upstream }
+
+// TranscodeError is the transcoder failing during Op, e.g. "start" or
+// "restart".
+type TranscodeError struct {
+	Op  string
+	Err error
+}
+
+func (e *TranscodeError) Error() string { return "transcoder " + e.Op + ": " + e.Err.Error() }
+func (e *TranscodeError) Unwrap() error { return e.Err }
+func (e *TranscodeError) Class() Class  { return Transcode }
+
+func withRequestID(requestID, msg string) string {
+	if requestID == "" {
+		return msg
+	}
+	return "request " + requestID + ": " + msg
+}
@@ -0,0 +1,57 @@
+package errclass
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"nil", nil, None},
+		{"unclassified", boom, Other},
+		{"auth", &AuthError{Err: boom}, Auth},
+		{"wrapped protocol", fmt.Errorf("read message: %w", Protocolf("chunk", "bad length %d", 9)), Protocol},
+		{"dial", &UpstreamDialError{Upstream: "up:1935", Err: io.EOF}, UpstreamDial},
+		// The outermost class wins: an upstream breaking the protocol is
+		// the upstream's failure.
+		{"outermost", &UpstreamError{Op: "handshake", Err: &ProtocolError{Err: boom}}, Upstream},
+		{"transcode", &TranscodeError{Op: "start", Err: boom}, Transcode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Of(tt.err); got != tt.want {
+				t.Fatalf("Of(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&AuthError{Err: boom}, "authentication failed: boom"},
+		{&ProtocolError{Err: boom}, "boom"},
+		{Protocolf("connect", "decode: %w", boom), "connect: decode: boom"},
+		{&UpstreamDialError{RequestID: "req-1", Upstream: "up:1935", Err: boom}, "request req-1: dial upstream up:1935: boom"},
+		{&UpstreamError{Upstream: "up:1935", Op: "publish", Err: boom}, "publish upstream up:1935: boom"},
+		{&TranscodeError{Op: "start", Err: boom}, "transcoder start: boom"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+		if !errors.Is(tt.err, boom) {
+			t.Errorf("%q does not unwrap to its cause", tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package events fans relay events out to live subscribers, such as
+// dashboards following GET /admin/events. Publishing never blocks: a
+// subscriber that falls behind loses events and is told how many.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types.
+const (
+	TypeConnectionStart = "connection_start" // A client connected
+	TypeSessionState    = "session_state"    // A session moved From one state to State
+	TypeConnectionEnd   = "connection_end"   // A session ended for Reason
+	TypeUpstreamHealth  = "upstream_health"  // An upstream health check flipped to State, or session RequestID failed to dial it
+	TypeCircuitBreaker  = "circuit_breaker"  // The circuit breaker moved From one state to State
+)
+
+// DefaultBuffer is the number of events a subscriber can fall behind by
+// before it starts losing them.
+const DefaultBuffer = 256
+
+// Event is one thing that happened in the relay. Fields that do not apply to
+// its Type are empty.
+type Event struct {
+	ID         uint64    `json:"id"` // Increases by one per published event
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	From       string    `json:"from,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"` // See errclass; set with Error
+	Error      string    `json:"error,omitempty"`
+}
+
+// Bus delivers published events to every current subscriber. A nil Bus
+// drops everything.
+type Bus struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[*Subscription]struct{}
+}
+
+// New returns a bus with no subscribers.
+func New() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish stamps e with the next ID and, if unset, the current time, and
+// hands it to each subscriber with room for it.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e.ID = b.nextID
+	for sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe starts delivering events published from now on. buffer <= 0
+// uses DefaultBuffer. The subscription must be closed when done.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	sub := &Subscription{bus: b, ch: make(chan Event, buffer)}
+	sub.C = sub.ch
+	if b == nil {
+		return sub
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Subscribers returns the number of open subscriptions.
+func (b *Bus) Subscribers() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Subscription receives events on C.
+type Subscription struct {
+	C <-chan Event
+
+	bus     *Bus
+	ch      chan Event
+	dropped atomic.Int64
+}
+
+// TakeDropped returns the number of events lost since the last call.
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close stops delivery. C is not closed, so a receiver selecting on it along
+// with its own done channel never sees a zero Event.
+func (s *Subscription) Close() {
+	if s.bus == nil {
+		return
+	}
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+}
@@ -0,0 +1,52 @@
+package events
+
+import "testing"
+
+func TestPublishFansOut(t *testing.T) {
+	b := New()
+	a, c := b.Subscribe(4), b.Subscribe(4)
+	defer a.Close()
+	defer c.Close()
+
+	b.Publish(Event{Type: TypeConnectionStart, RequestID: "r1"})
+	for _, sub := range []*Subscription{a, c} {
+		e := <-sub.C
+		if e.ID != 1 || e.Type != TypeConnectionStart || e.RequestID != "r1" || e.Time.IsZero() {
+			t.Fatalf("event = %+v", e)
+		}
+	}
+
+	c.Close()
+	if n := b.Subscribers(); n != 1 {
+		t.Fatalf("subscribers = %d, want 1 after Close", n)
+	}
+}
+
+func TestSlowSubscriberLosesEvents(t *testing.T) {
+	b := New()
+	sub := b.Subscribe(2)
+	defer sub.Close()
+
+	for range 5 {
+		b.Publish(Event{Type: TypeSessionState})
+	}
+	if n := sub.TakeDropped(); n != 3 {
+		t.Fatalf("dropped = %d, want 3", n)
+	}
+	if n := sub.TakeDropped(); n != 0 {
+		t.Fatalf("dropped after take = %d, want 0", n)
+	}
+	if e := <-sub.C; e.ID != 1 {
+		t.Fatalf("first buffered event id = %d, want 1", e.ID)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var b *Bus
+	b.Publish(Event{Type: TypeCircuitBreaker})
+	sub := b.Subscribe(0)
+	sub.Close()
+	if b.Subscribers() != 0 {
+		t.Fatal("nil bus has subscribers")
+	}
+}
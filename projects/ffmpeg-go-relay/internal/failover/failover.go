@@ -0,0 +1,384 @@
+// Package failover links primary and backup publishers into a single output.
+//
+// Both encoders publish under their own stream keys. Media from the primary is
+// forwarded while it is healthy; when it disconnects or stalls, the output
+// switches to the backup at the backup's next keyframe, and switches back once
+// the primary returns. Timestamps are rebased on every switch so the output
+// stays monotonic.
+package failover
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Role identifies a publisher within a pair.
+type Role int
+
+const (
+	RolePrimary Role = iota
+	RoleBackup
+)
+
+func (r Role) String() string {
+	if r == RoleBackup {
+		return "backup"
+	}
+	return "primary"
+}
+
+// Switch reasons reported in events.
+const (
+	ReasonPrimaryLost     = "primary_lost"
+	ReasonPrimaryStalled  = "primary_stalled"
+	ReasonPrimaryRestored = "primary_restored"
+)
+
+// ErrRoleTaken is returned when a second publisher joins under a key that is
+// already being published.
+var ErrRoleTaken = errors.New("failover: publisher already connected for this stream key")
+
+// Pair links a primary stream key with its backup.
+type Pair struct {
+	Primary string
+	Backup  string
+}
+
+// Event describes a switchover between publishers of a pair.
+type Event struct {
+	Stream string // Primary stream key, identifies the pair
+	From   Role
+	To     Role
+	Reason string
+	Time   time.Time
+}
+
+// Sink receives the media of whichever publisher is active.
+type Sink interface {
+	WriteMessage(msg *rtmp.Message) error
+	Close() error
+}
+
+// PairStatus is a snapshot of a pair with at least one connected publisher.
+type PairStatus struct {
+	Stream           string `json:"stream"`
+	Active           string `json:"active"`
+	PrimaryConnected bool   `json:"primary_connected"`
+	BackupConnected  bool   `json:"backup_connected"`
+	Switches         int    `json:"switches"`
+}
+
+type member struct {
+	stream string
+	role   Role
+}
+
+// Manager tracks configured pairs and the groups of currently connected publishers.
+type Manager struct {
+	mu          sync.Mutex
+	members     map[string]member
+	groups      map[string]*group
+	lossTimeout time.Duration
+	onSwitch    func(Event)
+	now         func() time.Time
+}
+
+// NewManager creates a manager for the given pairs. lossTimeout is how long the
+// primary may go without sending media before it is considered lost while still
+// connected; 0 means only a disconnect triggers a switch. onSwitch may be nil.
+func NewManager(pairs []Pair, lossTimeout time.Duration, onSwitch func(Event)) *Manager {
+	m := &Manager{
+		members:     make(map[string]member, len(pairs)*2),
+		groups:      make(map[string]*group),
+		lossTimeout: lossTimeout,
+		onSwitch:    onSwitch,
+		now:         time.Now,
+	}
+	for _, p := range pairs {
+		m.members[p.Primary] = member{stream: p.Primary, role: RolePrimary}
+		m.members[p.Backup] = member{stream: p.Primary, role: RoleBackup}
+	}
+	return m
+}
+
+// Lookup reports whether key belongs to a pair, returning the pair's primary
+// key and the role key publishes as.
+func (m *Manager) Lookup(key string) (string, Role, bool) {
+	if m == nil {
+		return "", 0, false
+	}
+	mem, ok := m.members[key]
+	return mem.stream, mem.role, ok
+}
+
+// Join registers a publisher for key. When no output exists for the pair yet,
+// open is called with the pair's primary key to create it. The output is closed
+// once both publishers have left.
+func (m *Manager) Join(key string, open func(stream string) (Sink, error)) (*Publisher, error) {
+	mem, ok := m.members[key]
+	if !ok {
+		return nil, errors.New("failover: stream key is not part of a pair")
+	}
+
+	m.mu.Lock()
+	g := m.groups[mem.stream]
+	if g == nil {
+		g = &group{stream: mem.stream}
+		m.groups[mem.stream] = g
+	}
+	if g.joined[mem.role] {
+		m.mu.Unlock()
+		return nil, ErrRoleTaken
+	}
+	g.joined[mem.role] = true
+	g.refs++
+	m.mu.Unlock()
+
+	g.mu.Lock()
+	if g.sink == nil {
+		sink, err := open(g.stream)
+		if err != nil {
+			g.mu.Unlock()
+			m.leave(g, mem.role)
+			return nil, err
+		}
+		g.sink = sink
+	}
+	g.pubs[mem.role] = &publisherState{lastSeen: m.now()}
+	g.mu.Unlock()
+
+	return &Publisher{m: m, g: g, role: mem.role}, nil
+}
+
+// Status returns a snapshot of every pair with a connected publisher.
+func (m *Manager) Status() []PairStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	groups := make([]*group, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	m.mu.Unlock()
+
+	out := make([]PairStatus, 0, len(groups))
+	for _, g := range groups {
+		g.mu.Lock()
+		st := PairStatus{
+			Stream:           g.stream,
+			PrimaryConnected: g.pubs[RolePrimary] != nil,
+			BackupConnected:  g.pubs[RoleBackup] != nil,
+			Switches:         g.switches,
+		}
+		if g.started {
+			st.Active = g.active.String()
+		}
+		g.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+func (m *Manager) leave(g *group, role Role) {
+	m.mu.Lock()
+	g.joined[role] = false
+	g.refs--
+	last := g.refs == 0
+	if last && m.groups[g.stream] == g {
+		delete(m.groups, g.stream)
+	}
+	m.mu.Unlock()
+
+	g.mu.Lock()
+	g.pubs[role] = nil
+	var sink Sink
+	if last {
+		sink, g.sink = g.sink, nil
+	}
+	g.mu.Unlock()
+
+	if sink != nil {
+		_ = sink.Close()
+	}
+}
+
+// Publisher is one side of a pair. Its media reaches the output only while it
+// is the active publisher.
+type Publisher struct {
+	m    *Manager
+	g    *group
+	role Role
+	once sync.Once
+}
+
+// Role returns the role this publisher joined as.
+func (p *Publisher) Role() Role {
+	return p.role
+}
+
+// WriteMessage offers msg to the pair's output. Media from the standby
+// publisher is dropped without error.
+func (p *Publisher) WriteMessage(msg *rtmp.Message) error {
+	ev, err := p.g.write(p.m, p.role, msg)
+	if ev != nil && p.m.onSwitch != nil {
+		p.m.onSwitch(*ev)
+	}
+	return err
+}
+
+// Close leaves the pair. It is safe to call more than once.
+func (p *Publisher) Close() error {
+	p.once.Do(func() { p.m.leave(p.g, p.role) })
+	return nil
+}
+
+type publisherState struct {
+	lastSeen time.Time
+	offset   int64 // Added to source timestamps to keep the output monotonic
+	hasVideo bool
+
+	// Decoder configuration replayed ahead of the first keyframe after a switch.
+	meta     *rtmp.Message
+	videoSeq *rtmp.Message
+	audioSeq *rtmp.Message
+}
+
+type group struct {
+	stream string
+
+	// Guarded by Manager.mu.
+	joined [2]bool
+	refs   int
+
+	mu       sync.Mutex
+	sink     Sink
+	sinkErr  error
+	pubs     [2]*publisherState
+	started  bool
+	active   Role
+	lastOut  uint32
+	switches int
+}
+
+func (g *group) write(m *Manager, role Role, msg *rtmp.Message) (*Event, error) {
+	switch msg.Header.TypeID {
+	case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+	default:
+		return nil, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ps := g.pubs[role]
+	if ps == nil {
+		return nil, nil
+	}
+	now := m.now()
+	ps.lastSeen = now
+	ps.remember(msg)
+
+	if g.sinkErr != nil {
+		return nil, g.sinkErr
+	}
+	if !g.started {
+		g.started = true
+		g.active = role
+		return nil, g.forward(ps, msg)
+	}
+	if role == g.active {
+		return nil, g.forward(ps, msg)
+	}
+	if role != g.preferred(m, now) || !ps.isSwitchPoint(msg) {
+		return nil, nil
+	}
+
+	ev := &Event{Stream: g.stream, From: g.active, To: role, Reason: g.switchReason(role), Time: now}
+	g.active = role
+	g.switches++
+	ps.offset = int64(g.lastOut) + 1 - int64(msg.Header.Timestamp)
+	for _, cached := range []*rtmp.Message{ps.meta, ps.videoSeq, ps.audioSeq} {
+		if cached == nil {
+			continue
+		}
+		replay := *cached
+		replay.Header.Timestamp = msg.Header.Timestamp
+		if err := g.forward(ps, &replay); err != nil {
+			return ev, err
+		}
+	}
+	return ev, g.forward(ps, msg)
+}
+
+// preferred returns the publisher the output should follow: the primary while
+// it is connected and not stalled, otherwise the backup.
+func (g *group) preferred(m *Manager, now time.Time) Role {
+	primary := g.pubs[RolePrimary]
+	if primary == nil {
+		return RoleBackup
+	}
+	if m.lossTimeout > 0 && now.Sub(primary.lastSeen) > m.lossTimeout {
+		return RoleBackup
+	}
+	return RolePrimary
+}
+
+func (g *group) switchReason(to Role) string {
+	if to == RolePrimary {
+		return ReasonPrimaryRestored
+	}
+	if g.pubs[RolePrimary] == nil {
+		return ReasonPrimaryLost
+	}
+	return ReasonPrimaryStalled
+}
+
+func (g *group) forward(ps *publisherState, msg *rtmp.Message) error {
+	out := *msg
+	ts := int64(msg.Header.Timestamp) + ps.offset
+	if ts < 0 {
+		ts = 0
+	}
+	out.Header.Timestamp = uint32(ts)
+	if out.Header.Timestamp > g.lastOut {
+		g.lastOut = out.Header.Timestamp
+	}
+	if err := g.sink.WriteMessage(&out); err != nil {
+		g.sinkErr = err
+		return err
+	}
+	return nil
+}
+
+func (ps *publisherState) remember(msg *rtmp.Message) {
+	switch {
+	case msg.Header.TypeID == rtmp.TypeAMF0Data:
+		ps.meta = cloneMessage(msg)
+	case msg.IsVideoSequenceHeader():
+		ps.videoSeq = cloneMessage(msg)
+	case msg.IsAACSequenceHeader():
+		ps.audioSeq = cloneMessage(msg)
+	}
+	if msg.Header.TypeID == rtmp.TypeVideo {
+		ps.hasVideo = true
+	}
+}
+
+// isSwitchPoint reports whether the output can start following this publisher
+// at msg: a video keyframe, or any audio frame for audio-only publishers.
+func (ps *publisherState) isSwitchPoint(msg *rtmp.Message) bool {
+	if ps.hasVideo {
+		return msg.IsVideoKeyframe() && !msg.IsVideoSequenceHeader()
+	}
+	return msg.Header.TypeID == rtmp.TypeAudio && !msg.IsAACSequenceHeader()
+}
+
+func cloneMessage(msg *rtmp.Message) *rtmp.Message {
+	c := *msg
+	c.Payload = append([]byte(nil), msg.Payload...)
+	return &c
+}
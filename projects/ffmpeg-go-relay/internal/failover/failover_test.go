@@ -0,0 +1,183 @@
+package failover
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+type recordSink struct {
+	msgs   []rtmp.Message
+	closed bool
+}
+
+func (s *recordSink) WriteMessage(msg *rtmp.Message) error {
+	s.msgs = append(s.msgs, *msg)
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func video(ts uint32, key bool, tag byte) *rtmp.Message {
+	frame := byte(rtmp.FrameInterframe << 4)
+	if key {
+		frame = rtmp.FrameKeyframe << 4
+	}
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+		Payload: []byte{frame | rtmp.VideoAVC, rtmp.AVCPacketNALU, 0, 0, 0, tag},
+	}
+}
+
+func videoSeq(tag byte) *rtmp.Message {
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo},
+		Payload: []byte{rtmp.FrameKeyframe<<4 | rtmp.VideoAVC, rtmp.AVCPacketSequenceHeader, 0, 0, 0, tag},
+	}
+}
+
+func newTestManager(t *testing.T, lossTimeout time.Duration) (*Manager, *recordSink, *[]Event) {
+	t.Helper()
+	var events []Event
+	m := NewManager([]Pair{{Primary: "live", Backup: "live_backup"}}, lossTimeout, func(ev Event) {
+		events = append(events, ev)
+	})
+	return m, &recordSink{}, &events
+}
+
+func join(t *testing.T, m *Manager, key string, sink *recordSink) *Publisher {
+	t.Helper()
+	p, err := m.Join(key, func(stream string) (Sink, error) {
+		if stream != "live" {
+			t.Fatalf("open stream = %q, want live", stream)
+		}
+		return sink, nil
+	})
+	if err != nil {
+		t.Fatalf("join %s: %v", key, err)
+	}
+	return p
+}
+
+func TestLookup(t *testing.T) {
+	m, _, _ := newTestManager(t, 0)
+	if stream, role, ok := m.Lookup("live_backup"); !ok || stream != "live" || role != RoleBackup {
+		t.Fatalf("Lookup(live_backup) = %q, %v, %v", stream, role, ok)
+	}
+	if _, _, ok := m.Lookup("other"); ok {
+		t.Fatal("unpaired key should not match")
+	}
+	var nilMgr *Manager
+	if _, _, ok := nilMgr.Lookup("live"); ok {
+		t.Fatal("nil manager should not match")
+	}
+}
+
+func TestSwitchToBackupOnPrimaryLoss(t *testing.T) {
+	m, sink, events := newTestManager(t, 0)
+	primary := join(t, m, "live", sink)
+	backup := join(t, m, "live_backup", sink)
+
+	_ = primary.WriteMessage(videoSeq(1))
+	_ = primary.WriteMessage(video(1000, true, 1))
+	_ = backup.WriteMessage(videoSeq(2))
+	_ = backup.WriteMessage(video(5000, true, 2))
+	_ = primary.WriteMessage(video(1040, false, 1))
+	if len(sink.msgs) != 3 {
+		t.Fatalf("forwarded %d messages before loss, want 3 (standby dropped)", len(sink.msgs))
+	}
+
+	_ = primary.Close()
+	_ = backup.WriteMessage(video(5040, false, 2)) // not a switch point
+	if len(sink.msgs) != 3 {
+		t.Fatal("backup interframe should not trigger a switch")
+	}
+	_ = backup.WriteMessage(video(5080, true, 2))
+
+	if len(*events) != 1 || (*events)[0].Reason != ReasonPrimaryLost || (*events)[0].To != RoleBackup {
+		t.Fatalf("events = %+v, want one primary_lost switch to backup", *events)
+	}
+	got := sink.msgs[3:]
+	if len(got) != 2 || !(&got[0]).IsAVCSequenceHeader() || got[0].Payload[5] != 2 {
+		t.Fatalf("expected backup sequence header replayed before keyframe, got %d msgs", len(got))
+	}
+	if got[1].Header.Timestamp != 1041 {
+		t.Fatalf("rebased timestamp = %d, want 1041", got[1].Header.Timestamp)
+	}
+}
+
+func TestSwitchOnPrimaryStallAndRestore(t *testing.T) {
+	m, sink, events := newTestManager(t, time.Second)
+	now := time.Unix(1000, 0)
+	m.now = func() time.Time { return now }
+
+	primary := join(t, m, "live", sink)
+	backup := join(t, m, "live_backup", sink)
+	_ = primary.WriteMessage(video(0, true, 1))
+
+	now = now.Add(500 * time.Millisecond)
+	_ = backup.WriteMessage(video(0, true, 2))
+	if len(*events) != 0 {
+		t.Fatal("switched before loss timeout elapsed")
+	}
+
+	now = now.Add(time.Second)
+	_ = backup.WriteMessage(video(40, true, 2))
+	if len(*events) != 1 || (*events)[0].Reason != ReasonPrimaryStalled {
+		t.Fatalf("events = %+v, want primary_stalled", *events)
+	}
+
+	_ = primary.WriteMessage(video(80, false, 1))
+	_ = backup.WriteMessage(video(80, false, 2))
+	_ = primary.WriteMessage(video(120, true, 1))
+	if len(*events) != 2 || (*events)[1].Reason != ReasonPrimaryRestored || (*events)[1].To != RolePrimary {
+		t.Fatalf("events = %+v, want primary_restored", *events)
+	}
+	last := sink.msgs[len(sink.msgs)-1]
+	if last.Payload[5] != 1 {
+		t.Fatal("expected primary media after restore")
+	}
+	for i := 1; i < len(sink.msgs); i++ {
+		if sink.msgs[i].Header.Timestamp < sink.msgs[i-1].Header.Timestamp {
+			t.Fatalf("output timestamps went backwards at %d", i)
+		}
+	}
+}
+
+func TestJoinRejectsDuplicateAndClosesSink(t *testing.T) {
+	m, sink, _ := newTestManager(t, 0)
+	p := join(t, m, "live", sink)
+	if _, err := m.Join("live", func(string) (Sink, error) { return sink, nil }); !errors.Is(err, ErrRoleTaken) {
+		t.Fatalf("duplicate join err = %v, want ErrRoleTaken", err)
+	}
+	b := join(t, m, "live_backup", sink)
+
+	_ = p.Close()
+	if sink.closed {
+		t.Fatal("sink closed while backup still connected")
+	}
+	_ = b.Close()
+	_ = b.Close()
+	if !sink.closed {
+		t.Fatal("sink should close when the last publisher leaves")
+	}
+	if st := m.Status(); len(st) != 0 {
+		t.Fatalf("status = %+v, want no pairs", st)
+	}
+}
+
+func TestJoinOpenFailure(t *testing.T) {
+	m, sink, _ := newTestManager(t, 0)
+	wantErr := errors.New("spawn failed")
+	if _, err := m.Join("live", func(string) (Sink, error) { return nil, wantErr }); !errors.Is(err, wantErr) {
+		t.Fatalf("join err = %v, want %v", err, wantErr)
+	}
+	// The failed join must not hold the role.
+	p := join(t, m, "live", sink)
+	_ = p.Close()
+}
@@ -0,0 +1,212 @@
+// Package grace keeps a stream's output open for a short window after its
+// publisher disconnects. A re-publish under the same key within the window is
+// spliced into the existing output, with timestamps rebased so they stay
+// monotonic, instead of restarting the upstream session and every viewer.
+package grace
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// ErrPublisherConnected is returned when a second publisher joins under a key
+// that is already being published.
+var ErrPublisherConnected = errors.New("grace: publisher already connected for this stream key")
+
+// Sink receives a stream's media across publisher reconnects.
+type Sink interface {
+	WriteMessage(msg *rtmp.Message) error
+	Close() error
+}
+
+// StreamStatus is a snapshot of a stream whose output is open.
+type StreamStatus struct {
+	Stream    string `json:"stream"`
+	Connected bool   `json:"connected"`
+	Resumes   int    `json:"resumes"`
+}
+
+// Holder tracks open outputs by stream key.
+type Holder struct {
+	mu      sync.Mutex
+	window  time.Duration
+	streams map[string]*stream
+}
+
+// NewHolder creates a holder that keeps outputs open for window after their
+// publisher leaves.
+func NewHolder(window time.Duration) *Holder {
+	return &Holder{
+		window:  window,
+		streams: make(map[string]*stream),
+	}
+}
+
+// Join registers a publisher for key. If an output is still held for key it is
+// resumed and Join reports true; otherwise open creates a new one.
+func (h *Holder) Join(key string, open func() (Sink, error)) (*Publisher, bool, error) {
+	h.mu.Lock()
+	s := h.streams[key]
+	if s != nil {
+		if s.connected {
+			h.mu.Unlock()
+			return nil, false, ErrPublisherConnected
+		}
+		s.connected = true
+		s.resumes++
+		if s.timer != nil {
+			s.timer.Stop()
+			s.timer = nil
+		}
+		h.mu.Unlock()
+		return &Publisher{h: h, key: key, s: s, resumed: true}, true, nil
+	}
+	// Reserve the key while the output opens so a concurrent publisher
+	// cannot open a second one.
+	s = &stream{connected: true}
+	h.streams[key] = s
+	h.mu.Unlock()
+
+	sink, err := open()
+	if err != nil {
+		h.mu.Lock()
+		delete(h.streams, key)
+		h.mu.Unlock()
+		return nil, false, err
+	}
+	s.mu.Lock()
+	s.sink = sink
+	s.mu.Unlock()
+	return &Publisher{h: h, key: key, s: s}, false, nil
+}
+
+// Status returns every open output sorted by stream key.
+func (h *Holder) Status() []StreamStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]StreamStatus, 0, len(h.streams))
+	for key, s := range h.streams {
+		out = append(out, StreamStatus{Stream: key, Connected: s.connected, Resumes: s.resumes})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Stream < out[j].Stream })
+	return out
+}
+
+// leave marks the publisher gone and schedules the output to close after the
+// window. Broken outputs are closed straight away.
+func (h *Holder) leave(key string, s *stream) {
+	s.mu.Lock()
+	broken := s.sinkErr != nil
+	s.mu.Unlock()
+
+	h.mu.Lock()
+	s.connected = false
+	if broken || h.window <= 0 {
+		delete(h.streams, key)
+		h.mu.Unlock()
+		s.close()
+		return
+	}
+	s.timer = time.AfterFunc(h.window, func() { h.expire(key, s) })
+	h.mu.Unlock()
+}
+
+func (h *Holder) expire(key string, s *stream) {
+	h.mu.Lock()
+	if s.connected || h.streams[key] != s {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.streams, key)
+	s.timer = nil
+	h.mu.Unlock()
+	s.close()
+}
+
+type stream struct {
+	// Guarded by Holder.mu.
+	connected bool
+	resumes   int
+	timer     *time.Timer
+
+	mu      sync.Mutex
+	sink    Sink
+	sinkErr error
+	started bool
+	lastOut uint32
+}
+
+func (s *stream) close() {
+	s.mu.Lock()
+	sink := s.sink
+	s.sink = nil
+	s.mu.Unlock()
+	if sink != nil {
+		_ = sink.Close()
+	}
+}
+
+// Publisher writes one publisher's media into a held output.
+type Publisher struct {
+	h       *Holder
+	key     string
+	s       *stream
+	resumed bool
+	rebased bool
+	offset  int64 // Added to source timestamps after a resume
+	once    sync.Once
+}
+
+// WriteMessage forwards media in msg to the output, rebasing timestamps of a
+// resumed publisher to continue after the last one written. Other message
+// types are dropped.
+func (p *Publisher) WriteMessage(msg *rtmp.Message) error {
+	switch msg.Header.TypeID {
+	case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+	default:
+		return nil
+	}
+
+	s := p.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sinkErr != nil {
+		return s.sinkErr
+	}
+	if s.sink == nil {
+		return errors.New("grace: output closed")
+	}
+	if p.resumed && !p.rebased && s.started {
+		p.offset = int64(s.lastOut) + 1 - int64(msg.Header.Timestamp)
+	}
+	p.rebased = true
+
+	out := *msg
+	ts := int64(msg.Header.Timestamp) + p.offset
+	if ts < 0 {
+		ts = 0
+	}
+	out.Header.Timestamp = uint32(ts)
+	if !s.started || out.Header.Timestamp > s.lastOut {
+		s.lastOut = out.Header.Timestamp
+	}
+	s.started = true
+	if err := s.sink.WriteMessage(&out); err != nil {
+		s.sinkErr = err
+		return err
+	}
+	return nil
+}
+
+// Close leaves the output. It is safe to call more than once.
+func (p *Publisher) Close() error {
+	p.once.Do(func() { p.h.leave(p.key, p.s) })
+	return nil
+}
@@ -0,0 +1,107 @@
+package grace
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+type recordSink struct {
+	mu     sync.Mutex
+	stamps []uint32
+	closed bool
+}
+
+func (s *recordSink) WriteMessage(msg *rtmp.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamps = append(s.stamps, msg.Header.Timestamp)
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordSink) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func audio(ts uint32) *rtmp.Message {
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAudio, Timestamp: ts}, Payload: []byte{0xaf, 1}}
+}
+
+func TestRepublishWithinWindowResumesOutput(t *testing.T) {
+	h := NewHolder(time.Minute)
+	sink := &recordSink{}
+	opens := 0
+	open := func() (Sink, error) {
+		opens++
+		return sink, nil
+	}
+
+	p, resumed, err := h.Join("live", open)
+	if err != nil || resumed {
+		t.Fatalf("Join = %v, %v; want fresh output", resumed, err)
+	}
+	if _, _, err := h.Join("live", open); !errors.Is(err, ErrPublisherConnected) {
+		t.Fatalf("second Join err = %v, want ErrPublisherConnected", err)
+	}
+	p.WriteMessage(audio(0))
+	p.WriteMessage(audio(500))
+	p.Close()
+	if sink.isClosed() {
+		t.Fatal("output closed inside grace window")
+	}
+
+	p, resumed, err = h.Join("live", open)
+	if err != nil || !resumed {
+		t.Fatalf("rejoin = %v, %v; want resumed output", resumed, err)
+	}
+	// The encoder restarts its clock at zero.
+	p.WriteMessage(audio(0))
+	p.WriteMessage(audio(20))
+	p.Close()
+
+	if opens != 1 {
+		t.Fatalf("opened %d outputs, want 1", opens)
+	}
+	want := []uint32{0, 500, 501, 521}
+	if len(sink.stamps) != len(want) {
+		t.Fatalf("timestamps = %v, want %v", sink.stamps, want)
+	}
+	for i := range want {
+		if sink.stamps[i] != want[i] {
+			t.Fatalf("timestamps = %v, want %v", sink.stamps, want)
+		}
+	}
+}
+
+func TestOutputClosesAfterWindow(t *testing.T) {
+	h := NewHolder(10 * time.Millisecond)
+	sink := &recordSink{}
+	p, _, err := h.Join("live", func() (Sink, error) { return sink, nil })
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	p.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for !sink.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("output still open after grace window")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := h.Status(); len(st) != 0 {
+		t.Fatalf("Status() = %v, want empty", st)
+	}
+}
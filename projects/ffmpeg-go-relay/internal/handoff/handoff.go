@@ -0,0 +1,393 @@
+// Package handoff lets a relay be upgraded without closing its listening
+// sockets. On the upgrade signal the running process starts its replacement
+// with every listener passed as an inherited file descriptor; once the new
+// process is serving it says so over a pipe, and the old one stops accepting
+// and drains its sessions. The files both processes write, such as the
+// session journal and the state database, stay with the old process until it
+// releases them over a second pipe. Listeners handed over by systemd socket
+// activation (LISTEN_FDS) are picked up the same way.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// DefaultReadyTimeout bounds how long an upgrade waits for the new process
+// to start serving before giving up and keeping the old one.
+const DefaultReadyTimeout = 30 * time.Second
+
+const (
+	envFDs   = "RELAY_HANDOFF_FDS"   // Comma-separated addresses of the inherited listeners
+	envReady = "RELAY_HANDOFF_READY" // Descriptor to report readiness on
+	envFiles = "RELAY_HANDOFF_FILES" // Descriptor closed once the shared files are released
+
+	// firstFD is where inherited descriptors start, after stdin, stdout and
+	// stderr, for both exec.Cmd.ExtraFiles and systemd.
+	firstFD = 3
+)
+
+// ErrNoListeners is returned by Upgrade before any listener was opened.
+var ErrNoListeners = errors.New("handoff: no listeners to pass on")
+
+type inherited struct {
+	name string // Listen address, or a systemd FileDescriptorName
+	f    *os.File
+}
+
+type listener struct {
+	addr string
+	ln   net.Listener
+}
+
+// Sockets opens listeners, preferring the ones this process inherited, and
+// hands them on to an upgraded process. A nil Sockets listens normally and
+// cannot upgrade.
+type Sockets struct {
+	log *logger.Logger
+
+	// UnixMode is the file mode of Unix sockets Listen creates; 0 leaves it
+	// to the umask.
+	UnixMode os.FileMode
+
+	mu        sync.Mutex
+	inherited []inherited
+	listeners []listener
+	ready     *os.File // Write end of the parent's readiness pipe
+	released  chan struct{}
+	successor *os.File // Write end of the new process's release pipe
+	upgrading bool
+}
+
+// closed is what Released returns when there is nothing to wait for.
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// FromEnvironment collects the listeners passed to this process by a
+// previous relay or by systemd and clears the variables describing them, so
+// they are not passed on to children by accident.
+func FromEnvironment(log *logger.Logger) (*Sockets, error) {
+	s := &Sockets{log: log, released: closed}
+
+	if names, ok := os.LookupEnv(envFDs); ok {
+		for i, name := range strings.Split(names, ",") {
+			s.inherited = append(s.inherited, inherited{name: name, f: os.NewFile(uintptr(firstFD+i), name)})
+		}
+		if v := os.Getenv(envReady); v != "" {
+			fd, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("handoff: %s=%q: %w", envReady, v, err)
+			}
+			s.ready = os.NewFile(uintptr(fd), "handoff-ready")
+		}
+		if v := os.Getenv(envFiles); v != "" {
+			fd, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("handoff: %s=%q: %w", envFiles, v, err)
+			}
+			s.released = make(chan struct{})
+			go waitReleased(os.NewFile(uintptr(fd), "handoff-files"), s.released)
+		}
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return nil, fmt.Errorf("handoff: LISTEN_FDS: %w", err)
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+			s.inherited = append(s.inherited, inherited{name: name, f: os.NewFile(uintptr(firstFD+i), name)})
+		}
+	}
+	for _, key := range []string{envFDs, envReady, envFiles, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(key)
+	}
+	return s, nil
+}
+
+// Inherited reports how many listeners this process was given.
+func (s *Sockets) Inherited() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inherited)
+}
+
+// Listen returns a listener on addr, a TCP address or a unix:// path: the
+// inherited one named addr, or else the first inherited one bound to that
+// address, or else a new one.
+func (s *Sockets) Listen(addr string) (net.Listener, error) {
+	if s == nil {
+		return Listen(addr, 0)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ln, err := s.takeInherited(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		s.log.Info("using inherited listener", "addr", ln.Addr().String())
+	} else if ln, err = Listen(addr, s.UnixMode); err != nil {
+		return nil, err
+	}
+	s.listeners = append(s.listeners, listener{addr: addr, ln: ln})
+	return ln, nil
+}
+
+func (s *Sockets) takeInherited(addr string) (net.Listener, error) {
+	network, address := ParseAddr(addr)
+	var want net.Addr
+	if network == "unix" {
+		want = &net.UnixAddr{Name: address, Net: network}
+	} else if tcp, err := net.ResolveTCPAddr(network, address); err == nil {
+		want = tcp
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, in := range s.inherited {
+			if in.f == nil || (pass == 0 && in.name != addr) {
+				continue
+			}
+			ln, err := net.FileListener(in.f)
+			if err != nil {
+				return nil, fmt.Errorf("handoff: inherited %q: %w", in.name, err)
+			}
+			if pass == 1 && !sameAddr(want, ln.Addr()) {
+				ln.Close()
+				continue
+			}
+			// FileListener holds its own copy of the descriptor.
+			in.f.Close()
+			s.inherited[i].f = nil
+			// This process now owns the socket file, so it removes it on
+			// exit unless it hands the socket on in turn.
+			if ul, ok := ln.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(true)
+			}
+			return ln, nil
+		}
+	}
+	return nil, nil
+}
+
+// sameAddr reports whether got is bound where want asks for: the same socket
+// path, or the same port and, unless want leaves the IP open, the same IP.
+func sameAddr(want, got net.Addr) bool {
+	if unix, ok := want.(*net.UnixAddr); ok {
+		g, ok := got.(*net.UnixAddr)
+		return ok && g.Name == unix.Name
+	}
+	w, ok := want.(*net.TCPAddr)
+	tcp, ok2 := got.(*net.TCPAddr)
+	if !ok || !ok2 || w.Port != tcp.Port {
+		return false
+	}
+	return w.IP == nil || w.IP.IsUnspecified() || w.IP.Equal(tcp.IP)
+}
+
+// CloseUnused closes inherited descriptors no Listen call asked for, such as
+// a listener dropped from the configuration since the upgrade.
+func (s *Sockets) CloseUnused() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, in := range s.inherited {
+		if in.f != nil {
+			s.log.Warn("closing unused inherited listener", "name", in.name)
+			in.f.Close()
+			s.inherited[i].f = nil
+		}
+	}
+}
+
+// Ready tells the process that started this one, if any, that it is serving
+// and may stop accepting.
+func (s *Sockets) Ready() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready == nil {
+		return
+	}
+	if _, err := s.ready.Write([]byte{1}); err != nil {
+		s.log.Warn("failed to report readiness to the previous process", "err", err)
+	}
+	s.ready.Close()
+	s.ready = nil
+}
+
+// Released returns a channel that is closed once the process that started
+// this one has released the files they share, or has exited. Until then
+// this process must not open them. Without such a process it is closed
+// already.
+func (s *Sockets) Released() <-chan struct{} {
+	if s == nil || s.released == nil {
+		return closed
+	}
+	return s.released
+}
+
+// Release tells the process started by a successful Upgrade that this one
+// is done with the files they share. Exiting does the same.
+func (s *Sockets) Release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.successor != nil {
+		s.successor.Close()
+		s.successor = nil
+	}
+}
+
+func waitReleased(r *os.File, released chan<- struct{}) {
+	// Nothing is ever written: the read ends when the other process closes
+	// its end.
+	io.Copy(io.Discard, r)
+	r.Close()
+	close(released)
+}
+
+// Upgrade starts the current executable with the same arguments and this
+// process's listeners, and waits up to timeout for it to call Ready. On
+// success the caller should stop accepting, drain and then Release; on error the new
+// process, if it started at all, has been told to exit and the caller keeps
+// serving.
+func (s *Sockets) Upgrade(timeout time.Duration) (int, error) {
+	if s == nil {
+		return 0, ErrNoListeners
+	}
+	s.mu.Lock()
+	if s.upgrading {
+		s.mu.Unlock()
+		return 0, errors.New("handoff: an upgrade is already in progress")
+	}
+	s.upgrading = true
+	listeners := append([]listener(nil), s.listeners...)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.upgrading = false
+		s.mu.Unlock()
+	}()
+
+	if len(listeners) == 0 {
+		return 0, ErrNoListeners
+	}
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("handoff: %w", err)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("handoff: listener on %s cannot be passed on", l.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return 0, fmt.Errorf("handoff: %s: %w", l.addr, err)
+		}
+		files = append(files, f)
+		names = append(names, l.addr)
+	}
+
+	filesR, filesW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("handoff: %w", err)
+	}
+	files = append(files, filesR)
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		filesW.Close()
+		return 0, fmt.Errorf("handoff: %w", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envFDs+"="+strings.Join(names, ","),
+		envReady+"="+strconv.Itoa(firstFD+len(files)-1),
+		envFiles+"="+strconv.Itoa(firstFD+len(files)-2),
+	)
+	if err := cmd.Start(); err != nil {
+		filesW.Close()
+		return 0, fmt.Errorf("handoff: start %s: %w", exe, err)
+	}
+	// Only the child may hold the write end, so its exit ends the read.
+	readyW.Close()
+	files = files[:len(files)-1]
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	if err := waitReady(readyR, timeout); err != nil {
+		filesW.Close()
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+		}
+		return 0, err
+	}
+	keepUnixSockets(listeners)
+	s.mu.Lock()
+	s.successor = filesW
+	s.mu.Unlock()
+	return cmd.Process.Pid, nil
+}
+
+func waitReady(r *os.File, timeout time.Duration) error {
+	if err := r.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	var b [1]byte
+	if _, err := r.Read(b[:]); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("handoff: new process not ready after %s", timeout)
+		}
+		return errors.New("handoff: new process exited before it was ready")
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+//go:build unix
+
+package handoff
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// envChild tells a re-executed test binary to act as the upgraded relay:
+// "serve" reports ready and answers one connection, "release" answers it
+// once the old process has released their files, "fail" exits at once.
+const envChild = "HANDOFF_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(envChild); mode != "" && os.Getenv(envFDs) != "" {
+		os.Exit(runChild(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runChild(mode string) int {
+	if mode == "fail" {
+		return 1
+	}
+	addr := strings.Split(os.Getenv(envFDs), ",")[0]
+	s, err := FromEnvironment(logger.NewWithWriter(io.Discard))
+	if err != nil {
+		return 2
+	}
+	ln, err := s.Listen(addr)
+	if err != nil {
+		return 3
+	}
+	s.Ready()
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		return 4
+	}
+	if mode == "release" {
+		<-s.Released()
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+	return 0
+}
+
+func TestUpgradeHandsOverListener(t *testing.T) {
+	t.Setenv(envChild, "serve")
+	s := &Sockets{log: logger.NewWithWriter(io.Discard)}
+	ln, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	pid, err := s.Upgrade(10 * time.Second)
+	if err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if pid == 0 || pid == os.Getpid() {
+		t.Fatalf("Upgrade returned pid %d", pid)
+	}
+	// The old process stops accepting; the port stays open in the new one.
+	ln.Close()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial after handoff: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, _ := io.ReadAll(conn)
+	if string(got) != "child" {
+		t.Fatalf("got %q from the listener, want the new process to answer", got)
+	}
+}
+
+func TestUpgradeWaitsForRelease(t *testing.T) {
+	t.Setenv(envChild, "release")
+	s := &Sockets{log: logger.NewWithWriter(io.Discard)}
+	ln, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Upgrade(10 * time.Second); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	ln.Close()
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial after handoff: %v", err)
+	}
+	defer conn.Close()
+	// The new process holds off while the old one still has the files.
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 5)); n > 0 || err == nil {
+		t.Fatal("the new process went ahead before the files were released")
+	}
+	s.Release()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, _ := io.ReadAll(conn)
+	if string(got) != "child" {
+		t.Fatalf("got %q after Release, want the new process to answer", got)
+	}
+}
+
+func TestUpgradeFailsWhenChildExits(t *testing.T) {
+	t.Setenv(envChild, "fail")
+	s := &Sockets{log: logger.NewWithWriter(io.Discard)}
+	ln, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if _, err := s.Upgrade(10 * time.Second); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Fatalf("Upgrade = %v, want an error for a child that exits early", err)
+	}
+	// The old process keeps serving on its listener.
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("listener unusable after a failed upgrade: %v", err)
+	}
+	c.Close()
+}
+
+func TestListenPrefersInherited(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := orig.Addr().(*net.TCPAddr).Port
+
+	// systemd names sockets after the unit, not the address, so an unnamed
+	// socket is matched by where it is bound.
+	s := &Sockets{log: logger.NewWithWriter(io.Discard), inherited: []inherited{{name: "relay.socket", f: f}}}
+	if s.Inherited() != 1 {
+		t.Fatalf("Inherited() = %d, want 1", s.Inherited())
+	}
+	ln, err := s.Listen(net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != orig.Addr().String() {
+		t.Fatalf("Listen bound %s, want the inherited %s", ln.Addr(), orig.Addr())
+	}
+
+	other, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if other.Addr().String() == orig.Addr().String() {
+		t.Fatal("an inherited listener was used twice")
+	}
+	s.CloseUnused()
+}
+
+func TestNilSockets(t *testing.T) {
+	var s *Sockets
+	ln, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	s.Ready()
+	s.CloseUnused()
+	s.Release()
+	<-s.Released()
+	if _, err := s.Upgrade(time.Second); err != ErrNoListeners {
+		t.Fatalf("Upgrade on nil Sockets = %v, want ErrNoListeners", err)
+	}
+}
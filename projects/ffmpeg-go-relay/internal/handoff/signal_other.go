@@ -0,0 +1,9 @@
+//go:build !unix
+
+package handoff
+
+import "os"
+
+// NotifyUpgrade does nothing: upgrades are requested with SIGUSR2, which
+// this platform does not have.
+func NotifyUpgrade(c chan<- os.Signal) {}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package handoff
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifyUpgrade relays SIGUSR2, the upgrade request, to c.
+func NotifyUpgrade(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,82 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// UnixPrefix marks a listen address as a Unix domain socket path, as in
+// unix:///run/relay/rtmp.sock.
+const UnixPrefix = "unix://"
+
+// ParseAddr splits a listen address into the network and address net.Listen
+// takes: "unix" and the path for a unix:// address, otherwise "tcp".
+func ParseAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// Listen opens a new listener on addr, a TCP address or a unix:// path. A
+// Unix socket gets file mode mode (0 leaves it to the umask) and is removed
+// when the listener is closed. A socket file left behind by a process that
+// did not exit cleanly is replaced, but a socket something still accepts on,
+// or a file that is not a socket, is an error.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	network, address := ParseAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("listen %s: no socket path", addr)
+	}
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(address, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen %s: %w", addr, err)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file at path if nothing is listening
+// on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen unix %s: file exists and is not a socket", path)
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("listen unix %s: socket is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// keepUnixSockets stops closing listeners from removing their socket files,
+// once a new process serves on them.
+func keepUnixSockets(listeners []listener) {
+	for _, l := range listeners {
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
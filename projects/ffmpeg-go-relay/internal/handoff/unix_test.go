@@ -0,0 +1,90 @@
+//go:build unix
+
+package handoff
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.sock")
+	addr := UnixPrefix + path
+
+	// A socket file left by a relay that crashed is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(addr, 0o660)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode = %v, want 0660", fi.Mode().Perm())
+	}
+
+	// A socket still accepting is left alone.
+	if _, err := Listen(addr, 0); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("listen on a live socket: err = %v", err)
+	}
+
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed on close: %v", err)
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(addr, 0); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("listen over a regular file: err = %v", err)
+	}
+}
+
+func TestListenMatchesInheritedUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.sock")
+	orig, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := orig.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The previous process hands the socket on without removing it.
+	orig.(*net.UnixListener).SetUnlinkOnClose(false)
+	orig.Close()
+
+	s := &Sockets{log: logger.NewWithWriter(io.Discard), inherited: []inherited{{name: "relay.socket", f: f}}}
+	ln, err := s.Listen(UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ln.Addr().String(); got != path {
+		t.Fatalf("Listen bound %s, want the inherited %s", got, path)
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("inherited socket unusable: %v", err)
+	}
+	c.Close()
+
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("inherited socket file not removed on close: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventsKeepalive is how often an idle /admin/events stream sends a comment,
+// so proxies do not time it out.
+const eventsKeepalive = 15 * time.Second
+
+// eventStreams ends open /admin/events streams at shutdown; http.Server
+// waits for handlers to return and would otherwise hold Shutdown for its
+// whole timeout.
+type eventStreams struct {
+	initOnce sync.Once
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (e *eventStreams) stopped() <-chan struct{} {
+	e.initOnce.Do(func() { e.done = make(chan struct{}) })
+	return e.done
+}
+
+func (e *eventStreams) stop() {
+	e.stopped()
+	e.stopOnce.Do(func() { close(e.done) })
+}
+
+// handleAdminEvents streams relay events as Server-Sent Events until the
+// client disconnects. ?type= limits the stream to a comma-separated list of
+// event types. A client that reads too slowly is sent a "dropped" event with
+// the number of events it missed.
+func (s *Server) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	var types map[string]bool
+	if v := r.URL.Query().Get("type"); v != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(v, ",") {
+			types[strings.TrimSpace(typ)] = true
+		}
+	}
+
+	sub := s.relayStats.Events.Subscribe(0)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.stopped():
+			return
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case e := <-sub.C:
+			if n := sub.TakeDropped(); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n)
+			}
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.log.Error("failed to encode event", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
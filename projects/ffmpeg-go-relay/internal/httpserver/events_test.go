@@ -0,0 +1,66 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+func TestAdminEventsStreamsFilteredEvents(t *testing.T) {
+	bus := events.New()
+	s := New("", logger.NewWithWriter(io.Discard), &RelayStats{Events: bus}, nil)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/events?type=connection_end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	br.ReadString('\n')
+
+	bus.Publish(events.Event{Type: events.TypeConnectionStart, RequestID: "r1"})
+	bus.Publish(events.Event{Type: events.TypeConnectionEnd, RequestID: "r1", Reason: "client_disconnect"})
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "id: 2" || lines[1] != "event: connection_end" {
+		t.Fatalf("event header = %q, want the connection_end event only", lines[:2])
+	}
+	var e events.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &e); err != nil {
+		t.Fatalf("decode %q: %v", lines[2], err)
+	}
+	if e.RequestID != "r1" || e.Reason != "client_disconnect" {
+		t.Fatalf("event = %+v", e)
+	}
+}
+
+func TestAdminEventsDisabled(t *testing.T) {
+	s := New("", logger.NewWithWriter(io.Discard), &RelayStats{}, nil)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/events", nil))
+	if rec.Code == http.StatusOK && rec.Header().Get("Content-Type") == "text/event-stream" {
+		t.Fatal("/admin/events served without an event bus")
+	}
+}
@@ -0,0 +1,21 @@
+package httpserver
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes every endpoint handler registers except pprof.
+// TestOpenAPIPaths fails when a route in server.go is missing from it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the OpenAPI 3 document of this API.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPISpec); err != nil {
+		s.log.Debug("failed to write openapi document", "err", err)
+	}
+}
@@ -0,0 +1,88 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+type openAPIDoc struct {
+	OpenAPI string                    `json:"openapi"`
+	Paths   map[string]map[string]any `json:"paths"`
+}
+
+// TestOpenAPIPaths checks that every route registered in server.go is
+// described in openapi.json. pprof endpoints are left out on purpose.
+func TestOpenAPIPaths(t *testing.T) {
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q, want 3.x", doc.OpenAPI)
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), "server.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "HandleFunc" && sel.Sel.Name != "Handle") {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			pattern, _ := strconv.Unquote(lit.Value)
+			patterns = append(patterns, pattern)
+		}
+		return true
+	})
+	if len(patterns) < 10 {
+		t.Fatalf("found only %d routes in server.go", len(patterns))
+	}
+
+	for _, pattern := range patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		if strings.HasPrefix(path, "/debug/pprof/") {
+			continue
+		}
+		ops, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("route %q is not in openapi.json", pattern)
+			continue
+		}
+		if method != "" && ops[strings.ToLower(method)] == nil {
+			t.Errorf("route %q has no %s operation in openapi.json", pattern, method)
+		}
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	s := New("", logger.NewWithWriter(io.Discard), nil, nil)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /admin/openapi.json = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.Paths["/ready"] == nil {
+		t.Fatalf("served document = %v, %v", doc.Paths["/ready"], err)
+	}
+}
@@ -0,0 +1,209 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ffmpeg-go-relay/internal/auth"
+	"ffmpeg-go-relay/internal/packager"
+)
+
+// packagedStream finds the stream a packager request is for, holds the
+// player to its app's tokens and counts it as a viewer. When it returns false
+// it has answered the request: routed it to the relay the stream is live on,
+// or failed it.
+func (s *Server) packagedStream(w http.ResponseWriter, r *http.Request) (*packager.Stream, bool) {
+	name := r.PathValue("name")
+	st, ok := s.relayStats.Packager.Stream(name)
+	if !ok {
+		if !s.routePlayback(w, r, name) {
+			s.packagerError(w, http.StatusNotFound, "stream is not being packaged")
+		}
+		return nil, false
+	}
+	if err := s.authorizePlayback(r, st.App(), name); err != nil {
+		s.packagerError(w, http.StatusUnauthorized, "authentication failed")
+		return nil, false
+	}
+	if err := s.relayStats.Viewers.Touch(name, segmentViewer(r)); err != nil {
+		s.packagerError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, false
+	}
+	return st, true
+}
+
+// segmentViewer tells HLS and DASH players apart, which make a request per
+// segment rather than holding a connection: by client address and token.
+func segmentViewer(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = auth.ExtractTokenFromHeader(r.Header.Get("Authorization"))
+	}
+	return host + " " + token
+}
+
+// tokenQuery carries a ?token= the playlist was fetched with over to the
+// URLs in it, so players that cannot set headers fetch media with it too.
+func tokenQuery(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return "?token=" + url.QueryEscape(token)
+	}
+	return ""
+}
+
+func (s *Server) packagerError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]any{"error": msg}); err != nil {
+		s.log.Error("failed to encode packager error response", "err", err)
+	}
+}
+
+// handleHLS serves a stream's multivariant playlist as index.m3u8, and the
+// media playlist of each track as {track}.m3u8. A media playlist request
+// with _HLS_msn (and _HLS_part) blocks until that segment (or part) is cut.
+func (s *Server) handleHLS(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.packagedStream(w, r)
+	if !ok {
+		return
+	}
+	track, ok := strings.CutSuffix(r.PathValue("playlist"), ".m3u8")
+	if !ok {
+		s.packagerError(w, http.StatusNotFound, "no such playlist")
+		return
+	}
+	var playlist []byte
+	if track == "index" {
+		playlist, ok = st.HLSMaster(tokenQuery(r))
+	} else {
+		if msn := r.URL.Query().Get("_HLS_msn"); msn != "" {
+			if !s.blockForHLS(w, r, st, msn) {
+				return
+			}
+		}
+		playlist, ok = st.HLSPlaylist(track, tokenQuery(r))
+	}
+	if !ok {
+		s.packagerError(w, http.StatusNotFound, "no such playlist yet")
+		return
+	}
+	s.writePackaged(w, r, "application/vnd.apple.mpegurl", "no-cache", playlist)
+}
+
+// blockForHLS waits for the segment, or the part, a blocking playlist reload
+// asks for. Like the LL-HLS spec, it gives up after three target durations.
+func (s *Server) blockForHLS(w http.ResponseWriter, r *http.Request, st *packager.Stream, msn string) bool {
+	seq, err := strconv.ParseUint(msn, 10, 64)
+	index := -1
+	if v := r.URL.Query().Get("_HLS_part"); err == nil && v != "" {
+		index, err = strconv.Atoi(v)
+	}
+	if err != nil || index < -1 {
+		s.packagerError(w, http.StatusBadRequest, "invalid _HLS_msn or _HLS_part")
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*s.relayStats.Packager.SegmentDuration())
+	defer cancel()
+	if !st.Wait(ctx, seq, index) {
+		s.packagerError(w, http.StatusServiceUnavailable, "segment is not available")
+		return false
+	}
+	return true
+}
+
+// handleDASH serves a stream's DASH manifest.
+func (s *Server) handleDASH(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.packagedStream(w, r)
+	if !ok {
+		return
+	}
+	mpd, ok := st.DASHManifest(tokenQuery(r))
+	if !ok {
+		s.packagerError(w, http.StatusNotFound, "stream has no complete segment yet")
+		return
+	}
+	s.writePackaged(w, r, "application/dash+xml", "no-cache", mpd)
+}
+
+// handleCMAF serves the media both HLS and DASH point at: a track's
+// init.mp4, its complete segments as {seq}.m4s and their parts as
+// {seq}.{part}.m4s. A part request blocks until the part is cut, so players
+// can fetch the part a playlist hints at ahead of time.
+func (s *Server) handleCMAF(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.packagedStream(w, r)
+	if !ok {
+		return
+	}
+	track, file := r.PathValue("track"), r.PathValue("file")
+	contentType := "video/mp4"
+	if track == "audio" {
+		contentType = "audio/mp4"
+	}
+	if file == "init.mp4" {
+		header, ok := st.Init(track)
+		if !ok {
+			s.packagerError(w, http.StatusNotFound, "no such track")
+			return
+		}
+		s.writePackaged(w, r, contentType, "no-cache", header)
+		return
+	}
+	name, ok := strings.CutSuffix(file, ".m4s")
+	segment, part, isPart := strings.Cut(name, ".")
+	seq, err := strconv.ParseUint(segment, 10, 64)
+	index := -1
+	if err == nil && isPart {
+		index, err = strconv.Atoi(part)
+	}
+	if !ok || err != nil || (isPart && index < 0) {
+		s.packagerError(w, http.StatusNotFound, "no such segment")
+		return
+	}
+	var chunks [][]byte
+	if isPart {
+		ctx, cancel := context.WithTimeout(r.Context(), 3*s.relayStats.Packager.SegmentDuration())
+		defer cancel()
+		st.Wait(ctx, seq, index)
+		var chunk []byte
+		chunk, ok = st.Part(track, seq, index)
+		chunks = [][]byte{chunk}
+	} else {
+		chunks, ok = st.Segment(track, seq)
+	}
+	if !ok {
+		s.packagerError(w, http.StatusNotFound, "no such segment")
+		return
+	}
+	// Segments never change once cut: numbering goes on across codec
+	// changes.
+	s.writePackaged(w, r, contentType, "max-age=60", chunks...)
+}
+
+func (s *Server) writePackaged(w http.ResponseWriter, r *http.Request, contentType, cacheControl string, chunks ...[]byte) {
+	size := 0
+	for _, c := range chunks {
+		size += len(c)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	for _, c := range chunks {
+		if _, err := w.Write(c); err != nil {
+			s.log.Debug("failed to write packaged media", "err", err)
+			return
+		}
+	}
+}
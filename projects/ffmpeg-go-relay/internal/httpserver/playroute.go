@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// routePlayback sends a playback request for a stream that is not live here
+// to the relay publishing it, when cluster play routing is on. It reports
+// whether it answered the request. Routed requests carry local=1 so the
+// owner serves them itself instead of routing them on.
+func (s *Server) routePlayback(w http.ResponseWriter, r *http.Request, stream string) bool {
+	if s.relayStats == nil || s.relayStats.Cluster == nil || r.URL.Query().Get("local") == "1" {
+		return false
+	}
+	dir := s.relayStats.Cluster
+	mode := dir.PlayRouting()
+	if mode == "" {
+		return false
+	}
+	owner, found, err := dir.Lookup(r.Context(), stream, false)
+	if err != nil {
+		s.log.Warn("playback route lookup failed", "stream", stream, "err", err)
+		return false
+	}
+	if !found || owner.Local || owner.URL == "" {
+		return false
+	}
+	target, err := url.Parse(owner.URL)
+	if err != nil {
+		s.log.Warn("stream owner has an invalid url", "stream", stream, "owner", owner.InstanceID, "url", owner.URL)
+		return false
+	}
+	query := r.URL.Query()
+	query.Set("local", "1")
+
+	if mode == config.PlayRoutingRedirect {
+		to := strings.TrimRight(owner.URL, "/") + r.URL.Path + "?" + query.Encode()
+		http.Redirect(w, r, to, http.StatusTemporaryRedirect)
+		return true
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.RawQuery = query.Encode()
+			pr.SetXForwarded()
+		},
+		FlushInterval: -1, // Live FLV must not sit in a buffer
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.log.Warn("playback proxy to stream owner failed", "stream", stream, "owner", owner.InstanceID, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ffmpeg-go-relay/internal/cluster"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// ownerRelay stands in for the relay publishing "cam1": it answers route
+// lookups and serves the stream's thumbnail to routed requests.
+func ownerRelay(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/route" && r.URL.Query().Get("stream") == "cam1":
+			json.NewEncoder(w).Encode(cluster.Owner{Stream: "cam1", InstanceID: "relay-b", URL: srv.URL})
+		case r.URL.Path == "/streams/cam1/thumbnail.jpg" && r.URL.Query().Get("local") == "1":
+			w.Header().Set("Content-Type", "image/jpeg")
+			io.WriteString(w, "jpeg from relay-b")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getThumbnail(s *Server, stream, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/streams/"+stream+"/thumbnail.jpg"+query, nil)
+	req.SetPathValue("name", stream)
+	rec := httptest.NewRecorder()
+	s.handleThumbnail(rec, req)
+	return rec
+}
+
+func TestPlaybackRouting(t *testing.T) {
+	owner := ownerRelay(t)
+	newServer := func(mode string) *Server {
+		dir := cluster.New(config.ClusterConfig{InstanceID: "relay-a", Peers: []string{owner.URL}, PlayRouting: mode}, nil)
+		return New("", logger.NewWithWriter(io.Discard), &RelayStats{Cluster: dir}, nil)
+	}
+
+	rec := getThumbnail(newServer(config.PlayRoutingRedirect), "cam1", "?w=1")
+	if want := owner.URL + "/streams/cam1/thumbnail.jpg?local=1&w=1"; rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != want {
+		t.Fatalf("redirect = %d to %q, want 307 to %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+
+	rec = getThumbnail(newServer(config.PlayRoutingProxy), "cam1", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "jpeg from relay-b" {
+		t.Fatalf("proxy = %d %q, want relay-b's thumbnail", rec.Code, rec.Body.String())
+	}
+
+	// Unknown streams, routed requests and relays without routing answer
+	// locally.
+	for _, tc := range []struct{ mode, stream, query string }{
+		{config.PlayRoutingProxy, "other", ""},
+		{config.PlayRoutingProxy, "cam1", "?local=1"},
+		{"", "cam1", ""},
+	} {
+		if rec := getThumbnail(newServer(tc.mode), tc.stream, tc.query); rec.Code != http.StatusNotFound {
+			t.Fatalf("%+v: got %d, want a local 404", tc, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/relay"
+)
+
+const (
+	defaultReadyCacheTTL     = 2 * time.Second
+	defaultReadyProbeTimeout = 2 * time.Second
+	defaultMinFreeBytes      = 1 << 30 // 1 GiB
+)
+
+// readiness holds the last /ready result. Probes run under mu, so a burst of
+// requests arriving after the result expires waits for one probe instead of
+// each dialing the upstream.
+type readiness struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+	deps      map[string]map[string]any
+}
+
+// readyResult returns whether the relay is ready, the per-dependency
+// breakdown, when it was probed and whether the result came from the cache.
+func (s *Server) readyResult() (bool, map[string]map[string]any, time.Time, bool) {
+	var cfg config.ReadinessConfig
+	if s.relayStats != nil {
+		cfg = s.relayStats.Readiness
+	}
+	ttl := cfg.CacheTTL.AsDuration()
+	if ttl <= 0 {
+		ttl = defaultReadyCacheTTL
+	}
+
+	s.ready.mu.Lock()
+	defer s.ready.mu.Unlock()
+	if !s.ready.checkedAt.IsZero() && time.Since(s.ready.checkedAt) < ttl {
+		return s.ready.ready, s.ready.deps, s.ready.checkedAt, true
+	}
+	s.ready.deps = s.probeDependencies(cfg)
+	s.ready.ready = true
+	for _, dep := range s.ready.deps {
+		if dep["required"].(bool) && !dep["ready"].(bool) {
+			s.ready.ready = false
+		}
+	}
+	s.ready.checkedAt = time.Now()
+	return s.ready.ready, s.ready.deps, s.ready.checkedAt, false
+}
+
+// probeDependencies checks the upstream and, when they are in use, the
+// transcoder and the recording disk. The probes are not tied to a request,
+// so one probe's client hanging up does not leave a failure in the cache.
+func (s *Server) probeDependencies(cfg config.ReadinessConfig) map[string]map[string]any {
+	timeout := cfg.ProbeTimeout.AsDuration()
+	if timeout <= 0 {
+		timeout = defaultReadyProbeTimeout
+	}
+	required := cfg.Require
+	if len(required) == 0 {
+		required = []string{config.ReadyUpstream}
+	}
+	stats := s.relayStats
+	if stats == nil {
+		stats = &RelayStats{}
+	}
+
+	deps := make(map[string]map[string]any)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	reachable := relay.UpstreamReady(ctx, stats.Upstream, stats.UpstreamPool)
+	cancel()
+	upstream := map[string]any{
+		"ready":    reachable,
+		"upstream": stats.Upstream,
+	}
+	if stats.UpstreamPool != nil {
+		upstream["upstreams_total"] = stats.UpstreamPool.Size()
+		upstream["upstreams_healthy"] = stats.UpstreamPool.HealthyCount()
+	}
+	deps[config.ReadyUpstream] = upstream
+
+	if stats.Transcode != nil {
+		sw := stats.Transcode.Status()
+		slots := stats.TranscodeSlots.Status()
+		deps[config.ReadyTranscoder] = map[string]any{
+			"ready":        !sw.Disabled && (slots.MaxSessions == 0 || slots.InUse < slots.MaxSessions),
+			"disabled":     sw.Disabled,
+			"slots_in_use": slots.InUse,
+			"max_sessions": slots.MaxSessions,
+		}
+	}
+
+	if stats.DVR != nil {
+		minFree := cfg.MinFreeBytes
+		if minFree <= 0 {
+			minFree = defaultMinFreeBytes
+		}
+		recording := map[string]any{"ready": true, "min_free_bytes": minFree}
+		free, err := stats.DVR.FreeBytes()
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+		case err != nil:
+			recording["ready"] = false
+			recording["error"] = err.Error()
+		default:
+			recording["ready"] = free >= minFree
+			recording["free_bytes"] = free
+		}
+		deps[config.ReadyRecording] = recording
+	}
+
+	for _, name := range required {
+		if deps[name] == nil {
+			deps[name] = map[string]any{"ready": false, "error": "not configured"}
+		}
+	}
+	for name, dep := range deps {
+		dep["required"] = slices.Contains(required, name)
+	}
+	return deps
+}
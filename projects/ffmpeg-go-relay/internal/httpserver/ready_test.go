@@ -0,0 +1,155 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/relay"
+	"ffmpeg-go-relay/internal/transcoder"
+)
+
+func getReady(t *testing.T, s *Server) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode /ready: %v", err)
+	}
+	return rec.Code, body
+}
+
+func TestReadyCachesUpstreamProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	s := New("", logger.NewWithWriter(io.Discard), &RelayStats{
+		Upstream:  "rtmp://" + ln.Addr().String() + "/app/stream",
+		Readiness: config.ReadinessConfig{CacheTTL: config.Duration(time.Minute)},
+	}, nil)
+
+	code, body := getReady(t, s)
+	if code != http.StatusOK || body["cached"] != false {
+		t.Fatalf("first /ready = %d %v, want a fresh 200", code, body)
+	}
+	ln.Close()
+	code, body = getReady(t, s)
+	if code != http.StatusOK || body["cached"] != true {
+		t.Fatalf("second /ready = %d %v, want the cached 200", code, body)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("upstream dialed %d times, want 1", n)
+	}
+}
+
+func TestReadyRequiredDependencies(t *testing.T) {
+	sw := transcoder.NewKillSwitch(config.TranscodeKillSwitchConfig{Disabled: true})
+	stats := &RelayStats{Transcode: sw}
+	s := New("", logger.NewWithWriter(io.Discard), stats, nil)
+
+	code, body := getReady(t, s)
+	if code != http.StatusOK {
+		t.Fatalf("/ready = %d, want 200 while the transcoder is not required", code)
+	}
+	deps := body["dependencies"].(map[string]any)
+	transcode := deps["transcoder"].(map[string]any)
+	if transcode["ready"] != false || transcode["required"] != false {
+		t.Fatalf("transcoder = %v, want reported unready and not required", transcode)
+	}
+
+	stats.Readiness = config.ReadinessConfig{Require: []string{config.ReadyUpstream, config.ReadyTranscoder, config.ReadyRecording}}
+	s = New("", logger.NewWithWriter(io.Discard), stats, nil)
+	code, body = getReady(t, s)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready = %d, want 503 with a required transcoder switched off", code)
+	}
+	recording := body["dependencies"].(map[string]any)["recording"].(map[string]any)
+	if recording["error"] != "not configured" {
+		t.Fatalf("recording = %v, want a required but missing dependency reported", recording)
+	}
+}
+
+func TestReadyFailsWhileDraining(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	drain := relay.NewDrain(config.DrainConfig{})
+	s := New("", logger.NewWithWriter(io.Discard), &RelayStats{
+		Upstream: "rtmp://" + ln.Addr().String() + "/app/stream",
+		Drain:    drain,
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	s.handleAdminDrain(rec, httptest.NewRequest(http.MethodPost, "/admin/drain", strings.NewReader(`{"after":"1h"}`)))
+	var resp struct {
+		Drain relay.DrainStatus `json:"drain"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || resp.Drain.Draining || resp.Drain.ScheduledUnix == 0 {
+		t.Fatalf("scheduled drain = %d %+v, want a drain pending", rec.Code, resp.Drain)
+	}
+	if code, _ := getReady(t, s); code != http.StatusOK {
+		t.Fatalf("/ready = %d before the scheduled drain, want 200", code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleAdminDrain(rec, httptest.NewRequest(http.MethodPost, "/admin/drain", nil))
+	if rec.Code != http.StatusOK || !drain.Draining() {
+		t.Fatalf("POST /admin/drain = %d, draining %v", rec.Code, drain.Draining())
+	}
+	code, body := getReady(t, s)
+	if code != http.StatusServiceUnavailable || body["draining"] != true {
+		t.Fatalf("/ready while draining = %d %v, want 503", code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleAdminUndrain(rec, httptest.NewRequest(http.MethodGet, "/admin/undrain", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /admin/undrain = %d, want 405", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.handleAdminUndrain(rec, httptest.NewRequest(http.MethodPost, "/admin/undrain", nil))
+	if rec.Code != http.StatusOK || drain.Draining() {
+		t.Fatalf("POST /admin/undrain = %d, draining %v", rec.Code, drain.Draining())
+	}
+	if code, _ := getReady(t, s); code != http.StatusOK {
+		t.Fatalf("/ready after undrain = %d, want 200", code)
+	}
+}
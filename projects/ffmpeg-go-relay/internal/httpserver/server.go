@@ -4,20 +4,37 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"runtime"
+	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"ffmpeg-go-relay/internal/auth"
 	"ffmpeg-go-relay/internal/circuit"
+	"ffmpeg-go-relay/internal/cluster"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dnsresponder"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/failover"
+	"ffmpeg-go-relay/internal/grace"
 	"ffmpeg-go-relay/internal/logger"
 	"ffmpeg-go-relay/internal/middleware"
+	"ffmpeg-go-relay/internal/packager"
 	"ffmpeg-go-relay/internal/pool"
 	"ffmpeg-go-relay/internal/relay"
+	"ffmpeg-go-relay/internal/rtmpt"
+	"ffmpeg-go-relay/internal/state"
+	"ffmpeg-go-relay/internal/thumbnail"
+	"ffmpeg-go-relay/internal/transcoder"
 )
 
 // Build information, set at compile time via -ldflags
@@ -31,21 +48,44 @@ var (
 type Server struct {
 	addr        string
 	log         *logger.Logger
-	server      *http.Server
 	relayStats  *RelayStats
 	startedAt   time.Time
 	enablePprof bool
 	tlsConfig   *tls.Config
+	ready       readiness
+	streams     eventStreams
 }
 
 // RelayStats holds references to relay state for stats reporting.
 type RelayStats struct {
 	ConnLimiter    *middleware.ConnectionLimiter
+	PublishLimiter *middleware.PublishLimiter
 	RateLimit      *middleware.RateLimiter
 	CircuitBreaker *circuit.Breaker
 	BufferPool     *pool.BytePool
 	Upstream       string
 	UpstreamPool   *relay.UpstreamPool
+	Routes         *relay.Router
+	Auth           *auth.TokenAuthenticator
+	PlaybackTokens *auth.PlaybackSigner // nil lets publish tokens gate playback; else signed tokens are required
+	Tenants        *relay.Tenants
+	RTMPT          *rtmpt.Handler
+	Failover       *failover.Manager
+	Grace          *grace.Holder
+	Transcode      *transcoder.KillSwitch
+	TranscodeSlots *transcoder.Slots
+	Capabilities   *transcoder.Capabilities // nil disables /admin/capabilities
+	DNS            *dnsresponder.Responder
+	Drain          *relay.Drain           // nil disables /admin/drain
+	Cluster        *cluster.Directory     // nil disables /api/route
+	Thumbnails     *thumbnail.Store       // nil disables /streams/{name}/thumbnail.jpg
+	DVR            *dvr.Recorder          // nil disables /streams/{name}/dvr.flv
+	Packager       *packager.Packager     // nil disables /streams/{name}/hls, /dash and /cmaf
+	Viewers        *relay.Viewers         // nil counts no players and never caps them
+	State          *state.Store           // Stream history and totals kept across restarts
+	Events         *events.Bus            // nil disables /admin/events
+	Readiness      config.ReadinessConfig // Probe caching and the dependencies that gate /ready
+	Gatherer       prometheus.Gatherer    // Serves /metrics; nil uses the default registry
 }
 
 // New creates a new HTTP server.
@@ -74,6 +114,17 @@ func NewWithPprof(addr string, log *logger.Logger, stats *RelayStats, enablePpro
 
 // Run starts the HTTP server and blocks until context is done.
 func (s *Server) Run(ctx context.Context) error {
+	return s.run(ctx, nil)
+}
+
+// Serve is Run on connections accepted from ln instead of addr, such as the
+// HTTPS half of an ALPN-multiplexed port. ln's connections are served as they
+// come; any TLS is already terminated by the listener.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	return s.run(ctx, ln)
+}
+
+func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Root endpoint
@@ -89,18 +140,71 @@ func (s *Server) Run(ctx context.Context) error {
 	mux.HandleFunc("/livez", s.handleLivez)
 
 	// Metrics endpoint
-	mux.Handle("/metrics", promhttp.Handler())
+	if s.relayStats != nil && s.relayStats.Gatherer != nil {
+		mux.Handle("/metrics", promhttp.HandlerFor(s.relayStats.Gatherer, promhttp.HandlerOpts{}))
+	} else {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
 
 	// Status endpoint
-	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/status", withCompression(s.handleStatus))
 
 	// Version endpoint
 	mux.HandleFunc("/version", s.handleVersion)
 
+	// Stream affinity lookup for load balancers
+	mux.HandleFunc("/api/route", s.handleRoute)
+
+	// Latest JPEG snapshot of a live stream
+	if s.relayStats != nil && s.relayStats.Thumbnails != nil {
+		mux.HandleFunc("GET /streams/{name}/thumbnail.jpg", s.handleThumbnail)
+	}
+
+	// Time-shifted HTTP-FLV playback of a live stream
+	if s.relayStats != nil && s.relayStats.DVR != nil {
+		mux.HandleFunc("GET /streams/{name}/dvr.flv", s.handleDVR)
+	}
+
+	// HLS and DASH playback of live streams, sharing CMAF segments
+	if s.relayStats != nil && s.relayStats.Packager != nil {
+		if s.relayStats.Packager.HLS() {
+			mux.HandleFunc("GET /streams/{name}/hls/{playlist}", s.handleHLS)
+		}
+		if s.relayStats.Packager.DASH() {
+			mux.HandleFunc("GET /streams/{name}/dash/manifest.mpd", s.handleDASH)
+		}
+		mux.HandleFunc("GET /streams/{name}/cmaf/{track}/{file}", s.handleCMAF)
+	}
+
 	// Admin endpoints
-	mux.HandleFunc("/admin/connections", s.handleAdminConnections)
-	mux.HandleFunc("/admin/circuit-breaker", s.handleAdminCircuitBreaker)
+	mux.HandleFunc("/admin/connections", withCompression(s.handleAdminConnections))
+	mux.HandleFunc("/admin/circuit-breaker", withCompression(s.handleAdminCircuitBreaker))
 	mux.HandleFunc("/admin/circuit-breaker/reset", s.handleAdminCircuitBreakerReset)
+	mux.HandleFunc("/admin/transcode", withCompression(s.handleAdminTranscode))
+	mux.HandleFunc("GET /admin/capabilities", withCompression(s.handleAdminCapabilities))
+	mux.HandleFunc("/admin/compliance", withCompression(s.handleAdminCompliance))
+	mux.HandleFunc("/admin/drain", s.handleAdminDrain)
+	mux.HandleFunc("/admin/undrain", s.handleAdminUndrain)
+
+	// Signed playback tokens for the stream endpoints
+	if s.relayStats != nil && s.relayStats.PlaybackTokens != nil {
+		mux.HandleFunc("POST /admin/playback-tokens", s.handleAdminPlaybackToken)
+	}
+
+	// Machine-readable description of this API
+	mux.HandleFunc("GET /admin/openapi.json", withCompression(s.handleOpenAPI))
+
+	// Live stream of session, upstream health and circuit breaker events
+	if s.relayStats != nil && s.relayStats.Events != nil {
+		mux.HandleFunc("GET /admin/events", s.handleAdminEvents)
+	}
+
+	// RTMPT tunnel endpoints (served as RTMPTS when TLS is enabled)
+	if s.relayStats != nil && s.relayStats.RTMPT != nil {
+		for _, path := range s.relayStats.RTMPT.Paths() {
+			mux.Handle(path, s.relayStats.RTMPT)
+		}
+	}
 
 	// Performance profiling endpoints (pprof) - only if enabled
 	if s.enablePprof {
@@ -118,21 +222,31 @@ func (s *Server) Run(ctx context.Context) error {
 		mux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 	}
 
-	s.server = &http.Server{
+	return mux
+}
+
+func (s *Server) run(ctx context.Context, ln net.Listener) error {
+	server := &http.Server{
 		Addr:    s.addr,
-		Handler: mux,
+		Handler: s.handler(),
 	}
+	server.RegisterOnShutdown(s.streams.stop)
 
 	// Start listening
 	errCh := make(chan error, 1)
 	go func() {
-		s.log.Info("http server starting", "addr", s.addr)
-		if s.tlsConfig != nil {
-			s.server.TLSConfig = s.tlsConfig
-			errCh <- s.server.ListenAndServeTLS("", "")
-			return
+		switch {
+		case ln != nil:
+			s.log.Info("http server starting", "addr", ln.Addr().String())
+			errCh <- server.Serve(ln)
+		case s.tlsConfig != nil:
+			s.log.Info("http server starting", "addr", s.addr)
+			server.TLSConfig = s.tlsConfig
+			errCh <- server.ListenAndServeTLS("", "")
+		default:
+			s.log.Info("http server starting", "addr", s.addr)
+			errCh <- server.ListenAndServe()
 		}
-		errCh <- s.server.ListenAndServe()
 	}()
 
 	// Wait for context done or error
@@ -141,7 +255,7 @@ func (s *Server) Run(ctx context.Context) error {
 		s.log.Info("http server shutdown initiated")
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		return s.server.Shutdown(shutdownCtx)
+		return server.Shutdown(shutdownCtx)
 	case err := <-errCh:
 		if err != nil && err != http.ErrServerClosed {
 			return fmt.Errorf("http server error: %w", err)
@@ -176,61 +290,38 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleReady checks if server is ready to accept connections.
-// Includes checks for upstream connectivity.
+// handleReady checks if server is ready to accept connections. Probe results
+// are cached briefly; dependencies listed in readiness.require decide the
+// status code.
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Check upstream connectivity with timeout
-	timeoutCtx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-	defer cancel()
-
-	upstream := ""
-	if s.relayStats != nil {
-		upstream = s.relayStats.Upstream
+	ready, deps, checkedAt, cached := s.readyResult()
+	upstream := deps[config.ReadyUpstream]
+	response := map[string]any{
+		"ready":        ready,
+		"time":         time.Now().Unix(),
+		"checked_at":   checkedAt.Unix(),
+		"cached":       cached,
+		"upstream":     upstream["upstream"],
+		"reachable":    upstream["ready"],
+		"dependencies": deps,
 	}
 
-	upstreamReachable := true
 	if s.relayStats != nil && s.relayStats.UpstreamPool != nil {
-		healthy := s.relayStats.UpstreamPool.HealthyCount()
-		upstreamReachable = healthy > 0
-	} else if upstream != "" {
-		info, err := relay.ParseUpstream(upstream)
-		if err != nil {
-			upstreamReachable = false
-		} else {
-			dialer := &net.Dialer{}
-			var conn net.Conn
-			if info.UseTLS {
-				tlsDialer := tls.Dialer{
-					NetDialer: dialer,
-					Config:    &tls.Config{ServerName: info.Host},
-				}
-				conn, err = tlsDialer.DialContext(timeoutCtx, "tcp", info.Address)
-			} else {
-				conn, err = dialer.DialContext(timeoutCtx, "tcp", info.Address)
-			}
-			if err != nil {
-				upstreamReachable = false
-			} else if closeErr := conn.Close(); closeErr != nil {
-				s.log.Warn("failed to close readiness check connection", "err", closeErr)
-			}
-		}
+		response["upstreams_total"] = upstream["upstreams_total"]
+		response["upstreams_healthy"] = upstream["upstreams_healthy"]
 	}
 
-	response := map[string]any{
-		"ready":     upstreamReachable,
-		"time":      time.Now().Unix(),
-		"upstream":  upstream,
-		"reachable": upstreamReachable,
+	// A draining node reports unready whatever its dependencies say, so load
+	// balancers stop sending it new sessions.
+	if s.relayStats != nil && s.relayStats.Drain.Draining() {
+		ready = false
+		response["ready"] = false
+		response["draining"] = true
 	}
 
-	if s.relayStats != nil && s.relayStats.UpstreamPool != nil {
-		response["upstreams_total"] = s.relayStats.UpstreamPool.Size()
-		response["upstreams_healthy"] = s.relayStats.UpstreamPool.HealthyCount()
-	}
-
-	if !upstreamReachable {
+	if !ready {
 		w.WriteHeader(http.StatusServiceUnavailable)
 	} else {
 		w.WriteHeader(http.StatusOK)
@@ -275,9 +366,20 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		status["upstream_strategy"] = s.relayStats.UpstreamPool.Strategy()
 	}
 
+	if s.relayStats != nil && s.relayStats.Routes != nil {
+		status["routes"] = s.relayStats.Routes.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.Tenants != nil {
+		status["tenants"] = s.relayStats.Tenants.Stats()
+	}
+
 	if s.relayStats != nil && s.relayStats.ConnLimiter != nil {
 		status["connections"] = s.relayStats.ConnLimiter.Stats()
 	}
+	if s.relayStats != nil && s.relayStats.PublishLimiter != nil {
+		status["publish_limit"] = s.relayStats.PublishLimiter.Stats()
+	}
 
 	if s.relayStats != nil && s.relayStats.RateLimit != nil {
 		status["rate_limit"] = s.relayStats.RateLimit.Stats()
@@ -291,6 +393,50 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		status["buffer_pool"] = s.relayStats.BufferPool.Stats()
 	}
 
+	if s.relayStats != nil && s.relayStats.RTMPT != nil {
+		status["rtmpt_sessions"] = s.relayStats.RTMPT.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.Failover != nil {
+		status["failover_pairs"] = s.relayStats.Failover.Status()
+	}
+
+	if s.relayStats != nil && s.relayStats.Grace != nil {
+		status["held_outputs"] = s.relayStats.Grace.Status()
+	}
+
+	if s.relayStats != nil && s.relayStats.Transcode != nil {
+		status["transcode_kill_switch"] = s.relayStats.Transcode.Status()
+	}
+
+	if s.relayStats != nil && s.relayStats.TranscodeSlots != nil {
+		status["transcode_slots"] = s.relayStats.TranscodeSlots.Status()
+	}
+
+	if s.relayStats != nil && s.relayStats.DNS != nil {
+		status["dns_responder"] = s.relayStats.DNS.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.Cluster != nil {
+		status["cluster"] = s.relayStats.Cluster.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.DVR != nil {
+		status["dvr"] = s.relayStats.DVR.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.Packager != nil {
+		status["packager"] = s.relayStats.Packager.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.Viewers != nil {
+		status["viewers"] = s.relayStats.Viewers.Stats()
+	}
+
+	if s.relayStats != nil && s.relayStats.State != nil {
+		status["state"] = s.relayStats.State.Status()
+	}
+
 	if err := json.NewEncoder(w).Encode(status); err != nil {
 		s.log.Error("failed to encode status response", "err", err)
 	}
@@ -310,14 +456,222 @@ func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleRoute reports which relay instance publishes ?stream=. Answers carry
+// Cache-Control for the directory's cache TTL, negative ones included, so
+// balancers can cache them. ?local=1 only checks this relay; peers use it.
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status, response := http.StatusOK, any(nil)
+	var dir *cluster.Directory
+	if s.relayStats != nil {
+		dir = s.relayStats.Cluster
+	}
+	stream := r.URL.Query().Get("stream")
+	switch {
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		status, response = http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed, use GET"}
+	case dir == nil:
+		status, response = http.StatusNotFound, map[string]any{"error": "route lookup is not enabled"}
+	case stream == "":
+		status, response = http.StatusBadRequest, map[string]any{"error": "stream is required"}
+	default:
+		owner, found, err := dir.Lookup(r.Context(), stream, r.URL.Query().Get("local") == "1")
+		switch {
+		case err != nil:
+			s.log.Warn("route lookup failed", "stream", stream, "err", err)
+			w.Header().Set("Cache-Control", "no-store")
+			status, response = http.StatusServiceUnavailable, map[string]any{"error": err.Error()}
+		case !found:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(dir.CacheTTL().Seconds())))
+			status, response = http.StatusNotFound, map[string]any{"error": "stream is not live on any relay"}
+		default:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(dir.CacheTTL().Seconds())))
+			response = owner
+		}
+	}
+
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.log.Error("failed to encode route response", "err", err)
+	}
+}
+
+// handleThumbnail serves the latest snapshot of a stream. Snapshots are
+// replaced every few seconds, so clients must not cache them for long.
+// Streams live on another relay are routed there when play routing is on.
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if s.relayStats.PlaybackTokens != nil {
+		if err := s.authorizePlayback(r, "", r.PathValue("name")); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			if err := json.NewEncoder(w).Encode(map[string]any{"error": "authentication failed"}); err != nil {
+				s.log.Error("failed to encode thumbnail error response", "err", err)
+			}
+			return
+		}
+	}
+	snap, ok := s.relayStats.Thumbnails.Latest(r.PathValue("name"))
+	if !ok && s.routePlayback(w, r, r.PathValue("name")) {
+		return
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(map[string]any{"error": "no thumbnail for this stream yet"}); err != nil {
+			s.log.Error("failed to encode thumbnail error response", "err", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Last-Modified", snap.Taken.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(snap.JPEG)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(snap.JPEG); err != nil {
+		s.log.Debug("failed to write thumbnail", "err", err)
+	}
+}
+
+// handleDVR streams a recorded stream as FLV, starting ?offset= behind live
+// (a duration such as "5m", or seconds) and following it until it ends.
+// Streams live on another relay are routed there when play routing is on.
+func (s *Server) handleDVR(w http.ResponseWriter, r *http.Request) {
+	offset, err := parseOffset(r.URL.Query().Get("offset"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(map[string]any{"error": err.Error()}); err != nil {
+			s.log.Error("failed to encode dvr error response", "err", err)
+		}
+		return
+	}
+	if app, ok := s.relayStats.DVR.App(r.PathValue("name")); ok {
+		if err := s.authorizePlayback(r, app, r.PathValue("name")); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			if err := json.NewEncoder(w).Encode(map[string]any{"error": "authentication failed"}); err != nil {
+				s.log.Error("failed to encode dvr error response", "err", err)
+			}
+			return
+		}
+		leave, err := s.relayStats.Viewers.Join(r.PathValue("name"), relay.ViewerHTTPFLV)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if err := json.NewEncoder(w).Encode(map[string]any{"error": err.Error()}); err != nil {
+				s.log.Error("failed to encode dvr error response", "err", err)
+			}
+			return
+		}
+		defer leave()
+	}
+
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = s.relayStats.DVR.Play(r.Context(), flushWriter{w, http.NewResponseController(w)}, r.PathValue("name"), offset)
+	switch {
+	case errors.Is(err, dvr.ErrNotFound):
+		w.Header().Del("Content-Type")
+		w.Header().Del("Cache-Control")
+		if s.routePlayback(w, r, r.PathValue("name")) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(map[string]any{"error": "stream is not being recorded"}); err != nil {
+			s.log.Error("failed to encode dvr error response", "err", err)
+		}
+	case err != nil && !errors.Is(err, context.Canceled):
+		s.log.Debug("dvr playback ended", "stream", r.PathValue("name"), "err", err)
+	}
+}
+
+// authorizePlayback holds a player of stream to a playback token signed for
+// it when playback tokens are on, or to the tokens a publisher of app needs,
+// which also pass when they are. Either is passed as ?token= or a bearer
+// Authorization header.
+func (s *Server) authorizePlayback(r *http.Request, app, stream string) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = auth.ExtractTokenFromHeader(r.Header.Get("Authorization"))
+	}
+	authenticator := s.relayStats.Tenants.Authenticator(app, s.relayStats.Auth)
+	signer := s.relayStats.PlaybackTokens
+	if signer == nil {
+		if authenticator == nil {
+			return nil
+		}
+		return authenticator.Authenticate(token)
+	}
+	err := signer.Verify(token, stream, time.Now())
+	if err != nil && authenticator != nil && authenticator.Authenticate(token) == nil {
+		return nil
+	}
+	return err
+}
+
+// streamApp returns the app stream is packaged or recorded under, or "" if
+// it is neither.
+func (s *Server) streamApp(stream string) string {
+	if st, ok := s.relayStats.Packager.Stream(stream); ok {
+		return st.App()
+	}
+	app, _ := s.relayStats.DVR.App(stream)
+	return app
+}
+
+func parseOffset(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.Atoi(v)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid offset %q", v)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("offset %q must not be negative", v)
+	}
+	return d, nil
+}
+
+// flushWriter pushes each write to the client so playback keeps up with live.
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.rc.Flush()
+	}
+	return n, err
+}
+
 // handleAdminConnections returns information about active connections.
+// DELETE with ?request_id= ends that session.
 func (s *Server) handleAdminConnections(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if r.Method == http.MethodDelete {
+		s.handleAdminConnectionKill(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		if err := json.NewEncoder(w).Encode(map[string]any{
-			"error": "method not allowed",
+			"error": "method not allowed, use GET or DELETE",
 		}); err != nil {
 			s.log.Error("failed to encode admin connections error response", "err", err)
 		}
@@ -346,6 +700,28 @@ func (s *Server) handleAdminConnections(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (s *Server) handleAdminConnectionKill(w http.ResponseWriter, r *http.Request) {
+	requestID := r.URL.Query().Get("request_id")
+	status, response := http.StatusOK, map[string]any{
+		"time":       time.Now().Unix(),
+		"request_id": requestID,
+		"killed":     true,
+	}
+	switch {
+	case requestID == "":
+		status, response = http.StatusBadRequest, map[string]any{"error": "request_id is required"}
+	case !relay.KillSession(requestID):
+		status, response = http.StatusNotFound, map[string]any{"error": "no active session with that request_id"}
+	default:
+		s.log.Warn("session killed via admin API", "request_id", requestID)
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.log.Error("failed to encode connection kill response", "err", err)
+	}
+}
+
 // handleAdminCircuitBreaker returns circuit breaker state.
 func (s *Server) handleAdminCircuitBreaker(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -414,3 +790,278 @@ func (s *Server) handleAdminCircuitBreakerReset(w http.ResponseWriter, r *http.R
 		s.log.Error("failed to encode circuit breaker reset response", "err", err)
 	}
 }
+
+// transcodeToggle is the POST body for /admin/transcode. An empty Tenant
+// flips the global switch.
+type transcodeToggle struct {
+	Disabled bool   `json:"disabled"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+// handleAdminTranscode reports or changes the transcoding kill switch.
+// Sessions already transcoding keep running; new ones see the change.
+// handleAdminCompliance returns strict-mode RTMP compliance reports, for one
+// session with ?request_id= or for all recent sessions.
+func (s *Server) handleAdminCompliance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "method not allowed",
+		}); err != nil {
+			s.log.Error("failed to encode compliance error response", "err", err)
+		}
+		return
+	}
+
+	if id := r.URL.Query().Get("request_id"); id != "" {
+		report, ok := relay.ComplianceReportFor(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if err := json.NewEncoder(w).Encode(map[string]any{
+				"error": "no compliance report for request_id",
+			}); err != nil {
+				s.log.Error("failed to encode compliance not found response", "err", err)
+			}
+			return
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			s.log.Error("failed to encode compliance response", "err", err)
+		}
+		return
+	}
+
+	reports := relay.ComplianceReports()
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"time":    time.Now().Unix(),
+		"count":   len(reports),
+		"reports": reports,
+	}); err != nil {
+		s.log.Error("failed to encode compliance response", "err", err)
+	}
+}
+
+func (s *Server) handleAdminTranscode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "method not allowed, use GET or POST",
+		}); err != nil {
+			s.log.Error("failed to encode transcode switch error response", "err", err)
+		}
+		return
+	}
+
+	if s.relayStats == nil || s.relayStats.Transcode == nil {
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "transcoding not configured",
+		}); err != nil {
+			s.log.Error("failed to encode transcode switch not found response", "err", err)
+		}
+		return
+	}
+	ks := s.relayStats.Transcode
+
+	if r.Method == http.MethodPost {
+		var req transcodeToggle
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(map[string]any{
+				"error": fmt.Sprintf("invalid request body: %v", err),
+			}); err != nil {
+				s.log.Error("failed to encode transcode switch bad request response", "err", err)
+			}
+			return
+		}
+		if req.Tenant == "" {
+			ks.SetGlobal(req.Disabled)
+		} else {
+			ks.SetTenant(req.Tenant, req.Disabled)
+		}
+		s.log.Warn("transcoding switch changed via admin API", "disabled", req.Disabled, "tenant", req.Tenant)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"time":        time.Now().Unix(),
+		"kill_switch": ks.Status(),
+	}); err != nil {
+		s.log.Error("failed to encode transcode switch response", "err", err)
+	}
+}
+
+// handleAdminCapabilities reports the encoders, hardware acceleration methods
+// and ffmpeg/libav versions probed at startup, so orchestration can send
+// transcoding jobs to relays that can run them.
+func (s *Server) handleAdminCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.relayStats == nil || s.relayStats.Capabilities == nil {
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "transcoding not configured",
+		}); err != nil {
+			s.log.Error("failed to encode capabilities not found response", "err", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.relayStats.Capabilities); err != nil {
+		s.log.Error("failed to encode capabilities response", "err", err)
+	}
+}
+
+// playbackTokenRequest is the POST body for /admin/playback-tokens.
+type playbackTokenRequest struct {
+	Stream string          `json:"stream"`
+	App    string          `json:"app,omitempty"` // Defaults to the app the stream is packaged or recorded under
+	TTL    config.Duration `json:"ttl,omitempty"` // 0 uses security.playback_tokens.ttl
+}
+
+// handleAdminPlaybackToken mints a playback token for one stream, to be
+// passed to players as ?token=. Whoever could publish the stream may mint
+// one, so the request carries a publish token of its app as a bearer
+// Authorization header; with no publish tokens for the app, none is minted.
+func (s *Server) handleAdminPlaybackToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req playbackTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil && (req.Stream == "" || req.TTL < 0) {
+		err = errors.New("stream is required and ttl must not be negative")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": fmt.Sprintf("invalid request body: %v", err),
+		}); err != nil {
+			s.log.Error("failed to encode playback token bad request response", "err", err)
+		}
+		return
+	}
+	if req.App == "" {
+		req.App = s.streamApp(req.Stream)
+	}
+	authenticator := s.relayStats.Tenants.Authenticator(req.App, s.relayStats.Auth)
+	if authenticator == nil || authenticator.Authenticate(auth.ExtractTokenFromHeader(r.Header.Get("Authorization"))) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		if err := json.NewEncoder(w).Encode(map[string]any{"error": "authentication failed"}); err != nil {
+			s.log.Error("failed to encode playback token error response", "err", err)
+		}
+		return
+	}
+	token, expires := s.relayStats.PlaybackTokens.Mint(req.Stream, req.TTL.AsDuration())
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"stream":  req.Stream,
+		"token":   token,
+		"expires": expires.Unix(),
+	}); err != nil {
+		s.log.Error("failed to encode playback token response", "err", err)
+	}
+}
+
+// drainRequest is the optional POST body for /admin/drain. Without one, or
+// with neither field set, the drain starts at once.
+type drainRequest struct {
+	At    int64           `json:"at,omitempty"`    // Unix time to start draining
+	After config.Duration `json:"after,omitempty"` // Delay before draining starts
+}
+
+// handleAdminDrain reports the maintenance drain or starts one. While
+// draining, new sessions are rejected and /ready fails so load balancers
+// stop sending traffic; sessions already running are left to finish.
+func (s *Server) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "method not allowed, use GET or POST",
+		}); err != nil {
+			s.log.Error("failed to encode drain error response", "err", err)
+		}
+		return
+	}
+
+	if s.relayStats == nil || s.relayStats.Drain == nil {
+		s.drainNotConfigured(w)
+		return
+	}
+	d := s.relayStats.Drain
+
+	if r.Method == http.MethodPost {
+		var req drainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(map[string]any{
+				"error": fmt.Sprintf("invalid request body: %v", err),
+			}); err != nil {
+				s.log.Error("failed to encode drain bad request response", "err", err)
+			}
+			return
+		}
+		var at time.Time
+		switch {
+		case req.At != 0:
+			at = time.Unix(req.At, 0)
+		case req.After > 0:
+			at = time.Now().Add(req.After.AsDuration())
+		}
+		d.Start(at)
+		s.log.Warn("drain requested via admin API", "at", at, "active_sessions", relay.GetActiveConnectionCount())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"time":  time.Now().Unix(),
+		"drain": d.Status(),
+	}); err != nil {
+		s.log.Error("failed to encode drain response", "err", err)
+	}
+}
+
+// handleAdminUndrain ends or cancels a maintenance drain so the node accepts
+// sessions again.
+func (s *Server) handleAdminUndrain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"error": "method not allowed, use POST",
+		}); err != nil {
+			s.log.Error("failed to encode undrain error response", "err", err)
+		}
+		return
+	}
+
+	if s.relayStats == nil || s.relayStats.Drain == nil {
+		s.drainNotConfigured(w)
+		return
+	}
+	d := s.relayStats.Drain
+	d.Stop()
+	s.log.Warn("drain ended via admin API")
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"time":  time.Now().Unix(),
+		"drain": d.Status(),
+	}); err != nil {
+		s.log.Error("failed to encode undrain response", "err", err)
+	}
+}
+
+func (s *Server) drainNotConfigured(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"error": "drain not configured",
+	}); err != nil {
+		s.log.Error("failed to encode drain not found response", "err", err)
+	}
+}
@@ -0,0 +1,287 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/auth"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/packager"
+	"ffmpeg-go-relay/internal/relay"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/testpattern"
+	"ffmpeg-go-relay/internal/transcoder"
+)
+
+func TestDVRPlaybackAuth(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	recorder, err := dvr.New(config.DVRConfig{Enabled: true, Dir: t.TempDir()}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for app, stream := range map[string]string{"acme?token=a1": "acme-cam", "live": "cam", "open": "open-cam"} {
+		tap := recorder.NewTap(app)
+		tap.SetStream(stream)
+		defer tap.Close()
+		for _, payload := range [][]byte{{0x17, 0x00}, {0x17, 0x01}} {
+			tap.Observe(&rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo}, Payload: payload})
+		}
+	}
+	s := New("", log, &RelayStats{
+		DVR:     recorder,
+		Auth:    auth.NewTokenAuthenticator([]string{"global"}),
+		Tenants: relay.NewTenants([]config.TenantConfig{{App: "acme", AuthTokens: []string{"a1"}}, {App: "open"}}),
+	}, nil)
+
+	for _, tc := range []struct {
+		stream, query, header string
+		want                  int
+	}{
+		{"acme-cam", "", "", http.StatusUnauthorized},
+		{"acme-cam", "?token=global", "", http.StatusUnauthorized},
+		{"acme-cam", "?token=a1", "", http.StatusOK},
+		{"acme-cam", "", "Bearer a1", http.StatusOK},
+		{"cam", "", "", http.StatusUnauthorized},
+		{"cam", "?token=global", "", http.StatusOK},
+		// A tenant without tokens of its own keeps the global ones.
+		{"open-cam", "", "", http.StatusUnauthorized},
+		{"open-cam", "?token=global", "", http.StatusOK},
+		// Unrecorded streams answer 404 whatever the token.
+		{"other", "", "", http.StatusNotFound},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // Authorized players return at once instead of following live.
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/streams/"+tc.stream+"/dvr.flv"+tc.query, nil)
+		req.SetPathValue("name", tc.stream)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		s.handleDVR(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%+v: got %d, want %d", tc, rec.Code, tc.want)
+		}
+	}
+}
+
+// packageTestPattern feeds three seconds of a test pattern to pkg as
+// stream, published on app, until the test ends.
+func packageTestPattern(t *testing.T, pkg *packager.Packager, app, stream string) {
+	t.Helper()
+	gen, err := testpattern.New(testpattern.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tap := pkg.NewTap(app)
+	tap.SetStream(stream)
+	t.Cleanup(tap.Close)
+	for _, msg := range gen.Headers() {
+		tap.Observe(msg)
+	}
+	for range 75 {
+		for _, msg := range gen.Next() {
+			tap.Observe(msg)
+		}
+	}
+}
+
+func TestPackagedPlayback(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	pkg := packager.New(config.PackagerConfig{Enabled: true, HLS: true, DASH: true, SegmentDuration: config.Duration(time.Second)}, log)
+	packageTestPattern(t, pkg, "acme", "acme-cam")
+	packageTestPattern(t, pkg, "open", "open-cam")
+	s := New("", log, &RelayStats{
+		Packager: pkg,
+		Tenants:  relay.NewTenants([]config.TenantConfig{{App: "acme", AuthTokens: []string{"a1"}}, {App: "open"}}),
+	}, nil)
+	h := s.handler()
+
+	for _, tc := range []struct {
+		path, contentType string
+		want              int
+	}{
+		{"/streams/open-cam/hls/index.m3u8", "application/vnd.apple.mpegurl", http.StatusOK},
+		{"/streams/open-cam/hls/video.m3u8?_HLS_msn=1", "application/vnd.apple.mpegurl", http.StatusOK},
+		{"/streams/open-cam/hls/video.m3u8?_HLS_msn=x", "application/json", http.StatusBadRequest},
+		{"/streams/open-cam/dash/manifest.mpd", "application/dash+xml", http.StatusOK},
+		{"/streams/open-cam/cmaf/video/init.mp4", "video/mp4", http.StatusOK},
+		{"/streams/open-cam/cmaf/audio/1.m4s", "audio/mp4", http.StatusOK},
+		{"/streams/open-cam/cmaf/video/0.0.m4s", "video/mp4", http.StatusOK},
+		{"/streams/open-cam/cmaf/video/2.m4s", "application/json", http.StatusNotFound},
+		{"/streams/open-cam/cmaf/video/one.m4s", "application/json", http.StatusNotFound},
+		{"/streams/acme-cam/hls/index.m3u8", "application/json", http.StatusUnauthorized},
+		{"/streams/acme-cam/hls/index.m3u8?token=a1", "application/vnd.apple.mpegurl", http.StatusOK},
+		{"/streams/other/hls/index.m3u8", "application/json", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want || rec.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("GET %s = %d %q, want %d %q", tc.path, rec.Code, rec.Header().Get("Content-Type"), tc.want, tc.contentType)
+		}
+	}
+}
+
+func TestPlaybackTokens(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	pkg := packager.New(config.PackagerConfig{Enabled: true, HLS: true, SegmentDuration: config.Duration(time.Second)}, log)
+	packageTestPattern(t, pkg, "acme", "acme-cam")
+	signer, err := auth.NewPlaybackSigner([]string{base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("", log, &RelayStats{
+		Packager:       pkg,
+		PlaybackTokens: signer,
+		Tenants:        relay.NewTenants([]config.TenantConfig{{App: "acme", AuthTokens: []string{"a1"}}}),
+	}, nil)
+	h := s.handler()
+
+	// Minting needs a publish token of the stream's app.
+	for _, bearer := range []string{"", "wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/playback-tokens", strings.NewReader(`{"stream":"acme-cam"}`))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("minting with bearer %q = %d, want 401", bearer, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/playback-tokens", strings.NewReader(`{"stream":"acme-cam","ttl":"1m"}`))
+	req.Header.Set("Authorization", "Bearer a1")
+	h.ServeHTTP(rec, req)
+	var minted struct {
+		Token   string `json:"token"`
+		Expires int64  `json:"expires"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&minted); err != nil || rec.Code != http.StatusOK || minted.Token == "" {
+		t.Fatalf("minting a token = %d %+v, %v", rec.Code, minted, err)
+	}
+	other, _ := signer.Mint("other-cam", 0)
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/streams/acme-cam/hls/index.m3u8", http.StatusUnauthorized},
+		{"/streams/acme-cam/hls/index.m3u8?token=" + minted.Token, http.StatusOK},
+		{"/streams/acme-cam/hls/index.m3u8?token=" + other, http.StatusUnauthorized},
+		{"/streams/acme-cam/cmaf/video/init.mp4?token=" + minted.Token, http.StatusOK},
+		// Publish tokens still let the tenant's own tools play.
+		{"/streams/acme-cam/hls/index.m3u8?token=a1", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+
+	// Playlists hand the token on to the URIs in them.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/streams/acme-cam/hls/video.m3u8?token="+minted.Token, nil))
+	if !strings.Contains(rec.Body.String(), "init.mp4?token="+minted.Token) {
+		t.Fatalf("media playlist drops the token:\n%s", rec.Body)
+	}
+
+	for _, body := range []string{`{}`, `{"stream":"acme-cam","ttl":"-1s"}`, `nope`} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/playback-tokens", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("minting with %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestViewerLimit(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	pkg := packager.New(config.PackagerConfig{Enabled: true, HLS: true, SegmentDuration: config.Duration(time.Second)}, log)
+	packageTestPattern(t, pkg, "live", "cam")
+	viewers := relay.NewViewers(config.ViewersConfig{MaxPerStream: 2}, nil)
+	s := New("", log, &RelayStats{Packager: pkg, Viewers: viewers}, nil)
+	h := s.handler()
+
+	leave, err := viewers.Join("cam", relay.ViewerRTMP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+	for _, tc := range []struct {
+		addr, path string
+		want       int
+	}{
+		{"10.0.0.1:5000", "/streams/cam/hls/index.m3u8", http.StatusOK},
+		// The same player fetching on, from another port, is not a new viewer.
+		{"10.0.0.1:5001", "/streams/cam/cmaf/video/init.mp4", http.StatusOK},
+		{"10.0.0.2:5000", "/streams/cam/hls/index.m3u8", http.StatusServiceUnavailable},
+		{"10.0.0.1:5000", "/streams/cam/hls/index.m3u8?token=other", http.StatusServiceUnavailable},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.RemoteAddr = tc.addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("GET %s from %s = %d, want %d", tc.path, tc.addr, rec.Code, tc.want)
+		}
+		if rec.Code == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "viewer limit") {
+			t.Errorf("rejection does not say why: %s", rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status struct {
+		Viewers []relay.StreamViewers `json:"viewers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Viewers) != 1 || status.Viewers[0].Total != 2 || status.Viewers[0].ByProtocol[relay.ViewerHLS] != 1 {
+		t.Fatalf("status viewers = %+v", status.Viewers)
+	}
+}
+
+func TestAdminCapabilities(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	caps := &transcoder.Capabilities{FFmpeg: transcoder.BackendCapabilities{
+		Available: true,
+		Version:   "7.1",
+		Encoders:  []transcoder.Encoder{{Name: "h264_nvenc", Type: "video", Hardware: true}},
+	}}
+	for _, tc := range []struct {
+		caps *transcoder.Capabilities
+		want int
+	}{
+		{nil, http.StatusNotFound},
+		{caps, http.StatusOK},
+	} {
+		s := New("", log, &RelayStats{Capabilities: tc.caps}, nil)
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/capabilities", nil))
+		if rec.Code != tc.want {
+			t.Fatalf("capabilities %v: got %d, want %d", tc.caps != nil, rec.Code, tc.want)
+		}
+		if tc.caps == nil {
+			continue
+		}
+		var got transcoder.Capabilities
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.FFmpeg.Version != "7.1" || len(got.FFmpeg.Encoders) != 1 || !got.FFmpeg.Encoders[0].Hardware {
+			t.Fatalf("got %+v, want the probed capabilities", got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package journal appends one JSON record per finished session to a local
+// file, optionally gzip-compressed. Records are buffered and flushed on an
+// interval and on Close, so a clean shutdown loses nothing.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval bounds how long a record may sit in memory.
+const DefaultFlushInterval = 5 * time.Second
+
+// Entry describes one finished session.
+type Entry struct {
+	RequestID  string    `json:"request_id"`
+	ClientAddr string    `json:"client_addr"`
+	Upstream   string    `json:"upstream,omitempty"`
+	App        string    `json:"app,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	Reason     string    `json:"reason"`
+	ErrorClass string    `json:"error_class,omitempty"` // See errclass; set with Error
+	Error      string    `json:"error,omitempty"`
+}
+
+// Journal is safe for concurrent use. A nil Journal discards records.
+type Journal struct {
+	mu     sync.Mutex
+	f      *os.File      // nil until Attach
+	held   *bytes.Buffer // Records flushed before Attach
+	gz     *gzip.Writer  // nil when uncompressed
+	bw     *bufio.Writer
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open appends to the journal at path, creating it if needed. Compressed
+// journals gain one gzip member per Open, which gzip readers (and ReadFile)
+// treat as a single stream.
+func Open(path string, compress bool, flushInterval time.Duration) (*Journal, error) {
+	j := New(flushInterval)
+	if err := j.Attach(path, compress); err != nil {
+		j.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// New returns a journal that keeps its records in memory until Attach opens
+// its file. A relay started by an upgrade records into it while the old
+// process is still appending to the file.
+func New(flushInterval time.Duration) *Journal {
+	j := &Journal{held: new(bytes.Buffer), stop: make(chan struct{})}
+	j.bw = bufio.NewWriter(j.held)
+
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	j.wg.Add(1)
+	go j.flushLoop(flushInterval)
+	return j
+}
+
+// Attach appends to the journal at path, creating it if needed, starting
+// with the records held since New. It can be called once.
+func (j *Journal) Attach(path string, compress bool) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return errors.New("journal: closed")
+	}
+	if j.f != nil {
+		return errors.New("journal: already attached")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("journal: open: %w", err)
+	}
+	if err := j.bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("journal: flush: %w", err)
+	}
+	var w io.Writer = f
+	if compress {
+		j.gz = gzip.NewWriter(f)
+		w = j.gz
+	}
+	j.f = f
+	j.bw = bufio.NewWriter(w)
+	j.bw.Write(j.held.Bytes())
+	j.held = nil
+	return nil
+}
+
+// Record appends e. It is written to disk by the next flush.
+func (j *Journal) Record(e Entry) error {
+	if j == nil {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("journal: encode: %w", err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return errors.New("journal: closed")
+	}
+	j.bw.Write(line)
+	return j.bw.WriteByte('\n')
+}
+
+// Flush writes buffered records through to the file.
+func (j *Journal) Flush() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return nil
+	}
+	return j.flushLocked()
+}
+
+func (j *Journal) flushLocked() error {
+	if err := j.bw.Flush(); err != nil {
+		return fmt.Errorf("journal: flush: %w", err)
+	}
+	if j.gz != nil {
+		if err := j.gz.Flush(); err != nil {
+			return fmt.Errorf("journal: flush: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes pending records and closes the file. Records held by a
+// journal that was never attached are dropped.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	close(j.stop)
+	err := j.flushLocked()
+	if j.gz != nil {
+		if cerr := j.gz.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("journal: close: %w", cerr)
+		}
+	}
+	if j.f != nil {
+		if cerr := j.f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("journal: close: %w", cerr)
+		}
+	}
+	j.mu.Unlock()
+	j.wg.Wait()
+	return err
+}
+
+func (j *Journal) flushLoop(interval time.Duration) {
+	defer j.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = j.Flush()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// ReadFile returns every record in a journal, compressed or not.
+func ReadFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("journal: read: %w", err)
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("journal: read: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []Entry
+	dec := json.NewDecoder(r)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return entries, fmt.Errorf("journal: decode: %w", err)
+		}
+		entries = append(entries, e)
+	}
+}
@@ -0,0 +1,92 @@
+package journal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedJournalAcrossReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl.gz")
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for i, id := range []string{"a", "b"} {
+		j, err := Open(path, true, time.Hour)
+		if err != nil {
+			t.Fatalf("Open #%d: %v", i, err)
+		}
+		if err := j.Record(Entry{RequestID: id, Start: start, Reason: "client_disconnect"}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		if err := j.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	entries, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(entries) != 2 || entries[0].RequestID != "a" || entries[1].RequestID != "b" {
+		t.Fatalf("entries = %+v, want a then b", entries)
+	}
+	if !entries[0].Start.Equal(start) {
+		t.Fatalf("start = %v, want %v", entries[0].Start, start)
+	}
+}
+
+func TestAttachAfterAnotherWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl.gz")
+	old, err := Open(path, true, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new journal records while the old one still owns the file.
+	j := New(time.Millisecond)
+	for _, e := range []struct {
+		j  *Journal
+		id string
+	}{{j, "b"}, {old, "a"}} {
+		if err := e.j.Record(Entry{RequestID: e.id, Reason: "client_disconnect"}); err != nil {
+			t.Fatalf("Record %s: %v", e.id, err)
+		}
+	}
+	if err := j.Flush(); err != nil {
+		t.Fatalf("Flush before Attach: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Attach(path, true); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if err := j.Record(Entry{RequestID: "c", Reason: "client_disconnect"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.RequestID)
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Fatalf("records = %v, want a, b, c", ids)
+	}
+}
+
+func TestNilJournalDiscards(t *testing.T) {
+	var j *Journal
+	if err := j.Record(Entry{RequestID: "a"}); err != nil {
+		t.Fatalf("Record on nil journal: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close on nil journal: %v", err)
+	}
+}
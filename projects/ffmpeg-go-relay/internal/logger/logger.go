@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"log/slog"
 	"os"
 )
@@ -13,7 +14,12 @@ type Logger struct {
 
 // New creates a new logger with JSON output to stdout.
 func New() *Logger {
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	return NewWithWriter(os.Stdout)
+}
+
+// NewWithWriter creates a logger writing one JSON record per Write to w.
+func NewWithWriter(w io.Writer) *Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	})
 	return &Logger{
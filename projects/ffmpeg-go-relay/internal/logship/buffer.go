@@ -0,0 +1,137 @@
+package logship
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// bufferFile holds undelivered records as "<unix nanos> <record>\n" lines.
+const bufferFile = "logship.buf"
+
+// diskBuffer is an append-only spill file capped at maxBytes. It is only
+// written from the shipper goroutine; size may be read from anywhere.
+type diskBuffer struct {
+	path     string
+	maxBytes int64
+	size     atomic.Int64
+}
+
+func openDiskBuffer(dir string, maxBytes int64) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("logship: create buffer dir: %w", err)
+	}
+	b := &diskBuffer{path: filepath.Join(dir, bufferFile), maxBytes: maxBytes}
+	if fi, err := os.Stat(b.path); err == nil {
+		b.size.Store(fi.Size())
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("logship: stat buffer: %w", err)
+	}
+	return b, nil
+}
+
+func (b *diskBuffer) bytes() int64 {
+	return b.size.Load()
+}
+
+// append writes entries until the cap is reached and returns how many did
+// not fit.
+func (b *diskBuffer) append(entries []entry) (int, error) {
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("logship: open buffer: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	written := 0
+	for _, e := range entries {
+		rec := encodeRecord(e)
+		if b.size.Load()+int64(len(rec)) > b.maxBytes {
+			break
+		}
+		if _, err := w.Write(rec); err != nil {
+			return len(entries) - written, err
+		}
+		b.size.Add(int64(len(rec)))
+		written++
+	}
+	if err := w.Flush(); err != nil {
+		return len(entries) - written, fmt.Errorf("logship: write buffer: %w", err)
+	}
+	return len(entries) - written, nil
+}
+
+// replay sends the buffered records in batches. On failure the undelivered
+// remainder is written back so the next replay starts where this one stopped.
+func (b *diskBuffer) replay(send func([]entry) error, batchSize int) error {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			b.size.Store(0)
+			return nil
+		}
+		return fmt.Errorf("logship: read buffer: %w", err)
+	}
+	entries := decodeRecords(data)
+	for len(entries) > 0 {
+		n := min(batchSize, len(entries))
+		if err := send(entries[:n]); err != nil {
+			return b.rewrite(entries, err)
+		}
+		entries = entries[n:]
+	}
+	b.size.Store(0)
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("logship: remove buffer: %w", err)
+	}
+	return nil
+}
+
+func (b *diskBuffer) rewrite(entries []entry, sendErr error) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.Write(encodeRecord(e))
+	}
+	if err := os.WriteFile(b.path, buf.Bytes(), 0o640); err != nil {
+		return fmt.Errorf("logship: rewrite buffer: %w", err)
+	}
+	b.size.Store(int64(buf.Len()))
+	return sendErr
+}
+
+func encodeRecord(e entry) []byte {
+	rec := strconv.AppendInt(nil, e.ts.UnixNano(), 10)
+	rec = append(rec, ' ')
+	rec = append(rec, e.line...)
+	return append(rec, '\n')
+}
+
+// decodeRecords parses buffered lines, skipping any that are truncated or
+// malformed.
+func decodeRecords(data []byte) []entry {
+	var entries []entry
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break // Partial final write
+		}
+		line := data[:i]
+		data = data[i+1:]
+		ts, rest, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(string(ts), 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{ts: time.Unix(0, nanos), line: rest})
+	}
+	return entries
+}
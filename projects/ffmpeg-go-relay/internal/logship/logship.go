@@ -0,0 +1,195 @@
+// Package logship pushes log records straight to Loki or a syslog server for
+// deployments where container stdout is not collected. Records that cannot be
+// delivered are appended to a disk buffer and replayed, oldest first, once the
+// sink is reachable again. Delivery is at least once: a batch that fails part
+// way through is buffered and resent in full.
+package logship
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+const (
+	defaultBatchSize      = 100
+	defaultFlushInterval  = time.Second
+	defaultBufferMaxBytes = 64 << 20
+	queueSize             = 4096
+)
+
+// entry is one log record and the time it was written.
+type entry struct {
+	ts   time.Time
+	line []byte
+}
+
+// sink delivers a batch of records, in order.
+type sink interface {
+	send(entries []entry) error
+	close() error
+}
+
+// Shipper is an io.Writer that forwards each written log record to a sink.
+// Writes never block or fail: when the in-memory queue is full the record is
+// dropped and counted.
+type Shipper struct {
+	sink      sink
+	buf       *diskBuffer // nil without a buffer_dir
+	queue     chan entry
+	batchSize int
+	interval  time.Duration
+
+	dropped atomic.Int64
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// Stats reports records the shipper has given up on.
+type Stats struct {
+	Dropped       int64 `json:"dropped"`
+	BufferedBytes int64 `json:"buffered_bytes"`
+}
+
+// New creates and starts a shipper for cfg. It returns nil when shipping is
+// not configured.
+func New(cfg config.LogShipConfig) (*Shipper, error) {
+	var snk sink
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "loki":
+		snk = newLokiSink(cfg.URL, cfg.Labels)
+	case "syslog":
+		snk = newSyslogSink(cfg.Address, cfg.TLS, cfg.AppName)
+	default:
+		return nil, errors.New("logship: unknown sink type " + cfg.Type)
+	}
+
+	s := &Shipper{
+		sink:      snk,
+		queue:     make(chan entry, queueSize),
+		batchSize: cfg.BatchSize,
+		interval:  time.Duration(cfg.FlushInterval),
+		done:      make(chan struct{}),
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultBatchSize
+	}
+	if s.interval <= 0 {
+		s.interval = defaultFlushInterval
+	}
+	if cfg.BufferDir != "" {
+		maxBytes := cfg.BufferMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = defaultBufferMaxBytes
+		}
+		buf, err := openDiskBuffer(cfg.BufferDir, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		s.buf = buf
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Write queues one log record. The slog JSON handler issues exactly one Write
+// per record.
+func (s *Shipper) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\n")
+	e := entry{ts: time.Now(), line: append([]byte(nil), line...)}
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Close flushes queued records and stops the shipper. Records that still
+// cannot be delivered stay in the disk buffer for the next start.
+func (s *Shipper) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+	return s.sink.close()
+}
+
+// Stats returns drop and backlog counters.
+func (s *Shipper) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	st := Stats{Dropped: s.dropped.Load()}
+	if s.buf != nil {
+		st.BufferedBytes = s.buf.bytes()
+	}
+	return st
+}
+
+func (s *Shipper) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]entry, 0, s.batchSize)
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) >= s.batchSize {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+		case <-s.done:
+			for {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e)
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush delivers the disk backlog and then batch, spilling batch to disk if
+// either fails. It returns batch emptied for reuse.
+func (s *Shipper) flush(batch []entry) []entry {
+	var err error
+	if s.buf != nil && s.buf.bytes() > 0 {
+		err = s.buf.replay(s.sink.send, s.batchSize)
+	}
+	if err == nil && len(batch) > 0 {
+		err = s.sink.send(batch)
+	}
+	if err != nil && len(batch) > 0 {
+		s.spill(batch)
+	}
+	return batch[:0]
+}
+
+func (s *Shipper) spill(batch []entry) {
+	if s.buf == nil {
+		s.dropped.Add(int64(len(batch)))
+		return
+	}
+	dropped, err := s.buf.append(batch)
+	if err != nil {
+		dropped = len(batch)
+	}
+	s.dropped.Add(int64(dropped))
+}
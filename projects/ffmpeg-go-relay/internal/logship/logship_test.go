@@ -0,0 +1,133 @@
+package logship
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestLokiBuffersWhileUnreachable(t *testing.T) {
+	var (
+		up    atomic.Bool
+		mu    sync.Mutex
+		lines []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var push lokiPush
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			t.Errorf("decode push: %v", err)
+			return
+		}
+		mu.Lock()
+		for _, v := range push.Streams[0].Values {
+			lines = append(lines, v[1])
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := New(config.LogShipConfig{
+		Type:          "loki",
+		URL:           srv.URL,
+		BufferDir:     t.TempDir(),
+		BatchSize:     2,
+		FlushInterval: config.Duration(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s.Write([]byte(`{"msg":"first"}` + "\n"))
+	s.Write([]byte(`{"msg":"second"}` + "\n"))
+
+	waitFor(t, func() bool { return s.Stats().BufferedBytes > 0 })
+	up.Store(true)
+	s.Write([]byte(`{"msg":"third"}` + "\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{`{"msg":"first"}`, `{"msg":"second"}`, `{"msg":"third"}`}
+	if strings.Join(lines, ",") != strings.Join(want, ",") {
+		t.Fatalf("delivered %v, want %v", lines, want)
+	}
+	if st := s.Stats(); st.BufferedBytes != 0 || st.Dropped != 0 {
+		t.Fatalf("Stats() = %+v, want empty buffer and no drops", st)
+	}
+}
+
+func TestSyslogOctetCountedFrames(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		size, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(size))
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		got <- string(msg)
+	}()
+
+	s, err := New(config.LogShipConfig{Type: "syslog", Address: ln.Addr().String(), AppName: "relay-test"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s.Write([]byte(`{"level":"WARN","msg":"slow upstream"}` + "\n"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case msg := <-got:
+		// local0 (16) * 8 + warning (4)
+		if !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, " relay-test ") ||
+			!strings.HasSuffix(msg, `{"level":"WARN","msg":"slow upstream"}`) {
+			t.Fatalf("syslog message = %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no syslog message received")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,65 @@
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// lokiSink posts batches to the Loki push API as a single stream.
+type lokiSink struct {
+	url    string
+	labels map[string]string
+	client *http.Client
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiSink(url string, labels map[string]string) *lokiSink {
+	if len(labels) == 0 {
+		labels = map[string]string{"job": "rtmp-relay"}
+	}
+	return &lokiSink{
+		url:    url,
+		labels: labels,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (l *lokiSink) send(entries []entry) error {
+	stream := lokiStream{Stream: l.labels, Values: make([][2]string, 0, len(entries))}
+	for _, e := range entries {
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), string(e.line)})
+	}
+	body, err := json.Marshal(lokiPush{Streams: []lokiStream{stream}})
+	if err != nil {
+		return fmt.Errorf("logship: encode loki push: %w", err)
+	}
+
+	resp, err := l.client.Post(l.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("logship: loki push: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("logship: loki push: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (l *lokiSink) close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,118 @@
+package logship
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	syslogFacilityLocal0 = 16
+	syslogDialTimeout    = 5 * time.Second
+	syslogWriteTimeout   = 10 * time.Second
+)
+
+// syslogSink writes RFC 5424 messages over TCP or TLS using octet-counting
+// framing (RFC 6587), reconnecting on the next batch after a failure.
+type syslogSink struct {
+	addr     string
+	useTLS   bool
+	appName  string
+	hostname string
+	pid      string
+	conn     net.Conn
+}
+
+func newSyslogSink(addr string, useTLS bool, appName string) *syslogSink {
+	if appName == "" {
+		appName = "rtmp-relay"
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{
+		addr:     addr,
+		useTLS:   useTLS,
+		appName:  appName,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+}
+
+func (s *syslogSink) send(entries []entry) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return fmt.Errorf("logship: dial syslog: %w", err)
+		}
+		s.conn = conn
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return s.fail(err)
+	}
+	var frames []byte
+	for _, e := range entries {
+		msg := s.format(e)
+		frames = strconv.AppendInt(frames, int64(len(msg)), 10)
+		frames = append(frames, ' ')
+		frames = append(frames, msg...)
+	}
+	if _, err := s.conn.Write(frames); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	if s.useTLS {
+		return tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return dialer.Dial("tcp", s.addr)
+}
+
+func (s *syslogSink) fail(err error) error {
+	s.conn.Close()
+	s.conn = nil
+	return fmt.Errorf("logship: write syslog: %w", err)
+}
+
+// format renders e as "<PRI>1 TIMESTAMP HOST APP PID - - MSG" with the JSON
+// record as MSG.
+func (s *syslogSink) format(e entry) []byte {
+	pri := syslogFacilityLocal0*8 + severity(e.line)
+	msg := fmt.Appendf(nil, "<%d>1 %s %s %s %s - - ", pri, e.ts.UTC().Format(time.RFC3339Nano), s.hostname, s.appName, s.pid)
+	return append(msg, e.line...)
+}
+
+func (s *syslogSink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// severity maps the slog level of a JSON record to a syslog severity.
+func severity(line []byte) int {
+	var rec struct {
+		Level string `json:"level"`
+	}
+	_ = json.Unmarshal(line, &rec)
+	switch rec.Level {
+	case "ERROR":
+		return 3
+	case "WARN":
+		return 4
+	case "DEBUG":
+		return 7
+	default:
+		return 6
+	}
+}
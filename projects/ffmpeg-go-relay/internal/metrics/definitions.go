@@ -0,0 +1,698 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kind is the Prometheus type of a metric.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// Definition describes one relay metric. NewRegistry builds its collectors
+// from Definitions, and the monitoring generator derives alert rules and
+// dashboard panels from the same entries, so the two cannot drift apart.
+type Definition struct {
+	Name    string // without the namespace prefix
+	Help    string
+	Kind    Kind
+	Labels  []string
+	Buckets []float64 // histograms only
+	Unit    string    // Grafana unit of the dashboard panel
+	Alerts  []Alert
+}
+
+// Alert is a Prometheus alerting rule attached to a metric. "{metric}" in
+// Expr is replaced by the namespaced metric name.
+type Alert struct {
+	Name     string
+	Expr     string
+	For      time.Duration
+	Severity string // "warning" or "critical"
+	Summary  string
+}
+
+// Definitions lists every metric the relay exports, in dashboard order.
+var Definitions = []Definition{
+	{
+		Name: "active_connections",
+		Help: "Number of active RTMP relay connections",
+		Kind: KindGauge,
+		Unit: "short",
+	},
+	{
+		Name:   "connections_total",
+		Help:   "Total number of RTMP connections",
+		Kind:   KindCounter,
+		Labels: []string{"status"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayConnectionErrorRatio",
+			Expr:     `sum(rate({metric}{status="error"}[5m])) / sum(rate({metric}{status="started"}[5m])) > 0.2`,
+			For:      10 * time.Minute,
+			Severity: "warning",
+			Summary:  "More than 20% of relay connections end in an error",
+		}},
+	},
+	{
+		Name:   "bytes_total",
+		Help:   "Total bytes transferred",
+		Kind:   KindCounter,
+		Labels: []string{"direction"},
+		Unit:   "Bps",
+	},
+	{
+		Name:    "connection_duration_seconds",
+		Help:    "Connection duration in seconds",
+		Kind:    KindHistogram,
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10), // 1s to 512s
+		Unit:    "s",
+	},
+	{
+		Name:    "session_duration_by_reason_seconds",
+		Help:    "Session duration in seconds by termination reason",
+		Kind:    KindHistogram,
+		Labels:  []string{"reason"},
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 14), // 0.5s to ~68m
+		Unit:    "s",
+	},
+	{
+		Name:   "session_completions_total",
+		Help:   "Total sessions ended by termination reason",
+		Kind:   KindCounter,
+		Labels: []string{"reason"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "session_errors_total",
+		Help:   "Total sessions ended with an error by error class",
+		Kind:   KindCounter,
+		Labels: []string{"class"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "failover_switches_total",
+		Help:   "Total switchovers between primary and backup publishers",
+		Kind:   KindCounter,
+		Labels: []string{"reason"},
+		Unit:   "short",
+		Alerts: []Alert{{
+			Name:     "RelayFailoverFlapping",
+			Expr:     `sum(increase({metric}[15m])) > 3`,
+			Severity: "warning",
+			Summary:  "Publishers switched between primary and backup more than 3 times in 15 minutes",
+		}},
+	},
+	{
+		Name:    "latency_seconds",
+		Help:    "Relay latency in seconds",
+		Kind:    KindHistogram,
+		Buckets: prometheus.DefBuckets,
+		Unit:    "s",
+		Alerts: []Alert{{
+			Name:     "RelayHighUpstreamLatency",
+			Expr:     `histogram_quantile(0.99, sum by (le) (rate({metric}_bucket[5m]))) > 1`,
+			For:      10 * time.Minute,
+			Severity: "warning",
+			Summary:  "p99 upstream connection latency is above 1s",
+		}},
+	},
+	{
+		Name:   "dropped_frames_total",
+		Help:   "Total media frames dropped because the upstream could not keep up",
+		Kind:   KindCounter,
+		Labels: []string{"type"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayDroppingFrames",
+			Expr:     `sum(rate({metric}[5m])) > 10`,
+			For:      5 * time.Minute,
+			Severity: "warning",
+			Summary:  "The relay is shedding more than 10 frames/s under backpressure",
+		}},
+	},
+	{
+		Name:   "upstream_errors_total",
+		Help:   "Total upstream connection errors",
+		Kind:   KindCounter,
+		Labels: []string{"error_type"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayUpstreamErrors",
+			Expr:     `sum(rate({metric}[5m])) > 0.5`,
+			For:      5 * time.Minute,
+			Severity: "critical",
+			Summary:  "Upstream connection errors are above 0.5/s",
+		}},
+	},
+	{
+		Name: "upstream_dial_retries_total",
+		Help: "Upstream dials retried after a failed attempt",
+		Kind: KindCounter,
+		Unit: "ops",
+	},
+	{
+		Name:   "upstream_outlier_ejections_total",
+		Help:   "Pool upstreams taken out of rotation for failing or slow connects, by upstream",
+		Kind:   KindCounter,
+		Labels: []string{"upstream"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "upstream_prewarm_total",
+		Help:   "Sessions that found a pre-warmed upstream connection (hit) or had to dial (miss)",
+		Kind:   KindCounter,
+		Labels: []string{"result"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "upstream_auth_attempts_total",
+		Help:   "Upstream connect attempts with a configured credential, by credential and result",
+		Kind:   KindCounter,
+		Labels: []string{"credential", "result"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayUpstreamCredentialRejected",
+			Expr:     `sum by (credential) (rate({metric}{result="rejected"}[5m])) > 0`,
+			For:      15 * time.Minute,
+			Severity: "warning",
+			Summary:  "An upstream keeps rejecting a configured credential; finish the rotation or remove it",
+		}},
+	},
+	{
+		Name:   "bond_leg_healthy",
+		Help:   "Whether each leg of a redundant push is up and keeping pace (1) or not (0), by stream and upstream",
+		Kind:   KindGauge,
+		Labels: []string{"stream", "upstream"},
+		Unit:   "short",
+		Alerts: []Alert{{
+			Name:     "RelayRedundantLegDown",
+			Expr:     `{metric} == 0`,
+			For:      2 * time.Minute,
+			Severity: "warning",
+			Summary:  "A redundant push is running on fewer legs than configured and has lost its path diversity",
+		}},
+	},
+	{
+		Name: "rate_limit_rejections_total",
+		Help: "Total connections rejected by rate limiting",
+		Kind: KindCounter,
+		Unit: "ops",
+	},
+	{
+		Name: "connection_limit_rejections_total",
+		Help: "Total connections rejected by connection limits",
+		Kind: KindCounter,
+		Unit: "ops",
+		Alerts: []Alert{{
+			Name:     "RelayConnectionLimitReached",
+			Expr:     `sum(rate({metric}[5m])) > 0`,
+			For:      15 * time.Minute,
+			Severity: "warning",
+			Summary:  "Connections are being rejected by the connection limit",
+		}},
+	},
+	{
+		Name:   "publish_limit_rejections_total",
+		Help:   "Publishes refused because the client IP or auth token had too many streams live",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"scope"},
+	},
+	{
+		Name:   "redirects_total",
+		Help:   "Publishers redirected to another relay at connect time, by reason: a mapped app or overflow past max_sessions",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"reason"},
+	},
+	{
+		Name:   "duplicate_publishes_total",
+		Help:   "Publishes of a stream name already live on this relay, by what the duplicate publish policy did",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"outcome"},
+	},
+	{
+		Name:   "stream_viewers",
+		Help:   "Players of each stream, by protocol: rtmp play, http_flv DVR playback or hls for HLS and DASH",
+		Kind:   KindGauge,
+		Labels: []string{"stream", "protocol"},
+		Unit:   "short",
+	},
+	{
+		Name:   "viewer_limit_rejections_total",
+		Help:   "Players turned away because their stream had viewers.max_per_stream viewers",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"protocol"},
+	},
+	{
+		Name: "auth_failures_total",
+		Help: "Total authentication failures",
+		Kind: KindCounter,
+		Unit: "ops",
+		Alerts: []Alert{{
+			Name:     "RelayAuthFailures",
+			Expr:     `sum(rate({metric}[5m])) > 1`,
+			For:      10 * time.Minute,
+			Severity: "warning",
+			Summary:  "Authentication failures are above 1/s; check for leaked or rotated keys",
+		}},
+	},
+	{
+		Name:    "session_phase_duration_seconds",
+		Help:    "Time spent per session phase (parse, copy, transcode) for profiled sessions",
+		Kind:    KindHistogram,
+		Labels:  []string{"phase"},
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to ~262s
+		Unit:    "s",
+	},
+	{
+		Name:   "sessions_in_state",
+		Help:   "Sessions in each connection state: connecting, handshaking, dialing, relaying or closing",
+		Kind:   KindGauge,
+		Labels: []string{"state"},
+		Unit:   "short",
+	},
+	{
+		Name:   "session_state_transitions_total",
+		Help:   "Sessions moving from one connection state to another",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"from", "to"},
+	},
+	{
+		Name:    "session_state_duration_seconds",
+		Help:    "Time sessions spent in each connection state before leaving it",
+		Kind:    KindHistogram,
+		Labels:  []string{"state"},
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 12), // 1ms to ~70m
+		Unit:    "s",
+	},
+	{
+		Name:   "session_state_timeouts_total",
+		Help:   "Sessions ended for staying in a connection state past its state_timeouts entry",
+		Kind:   KindCounter,
+		Unit:   "ops",
+		Labels: []string{"state"},
+		Alerts: []Alert{{
+			Name:     "RelayUpstreamDialTimeouts",
+			Expr:     `sum(rate({metric}{state="dialing"}[5m])) > 0.1`,
+			For:      10 * time.Minute,
+			Severity: "warning",
+			Summary:  "Sessions keep timing out before their upstream or transcoder starts",
+		}},
+	},
+	{
+		Name:    "session_alloc_bytes",
+		Help:    "Heap bytes allocated during profiled sessions (process-wide delta)",
+		Kind:    KindHistogram,
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 12), // 1KB to 4GB
+		Unit:    "bytes",
+	},
+	{
+		Name:   "tls_cert_expiry_seconds",
+		Help:   "Seconds until a certificate in the served TLS chain expires",
+		Kind:   KindGauge,
+		Labels: []string{"subject"},
+		Unit:   "s",
+		Alerts: []Alert{{
+			Name:     "RelayTLSCertificateExpiresSoon",
+			Expr:     `min({metric}) < 7 * 24 * 3600`,
+			Severity: "critical",
+			Summary:  "A served TLS certificate expires within 7 days",
+		}},
+	},
+	{
+		Name:   "tls_cert_expiring",
+		Help:   "1 when a certificate in the served TLS chain is close to expiry",
+		Kind:   KindGauge,
+		Labels: []string{"subject"},
+		Unit:   "bool",
+		Alerts: []Alert{{
+			Name:     "RelayTLSCertificateInWarningWindow",
+			Expr:     `max({metric}) == 1`,
+			For:      time.Hour,
+			Severity: "warning",
+			Summary:  "A served TLS certificate is inside the configured expiry warning window",
+		}},
+	},
+	{
+		Name:   "ocsp_refreshes_total",
+		Help:   "Total OCSP staple fetches by result",
+		Kind:   KindCounter,
+		Labels: []string{"result"},
+		Unit:   "short",
+		Alerts: []Alert{{
+			Name:     "RelayOCSPRefreshFailing",
+			Expr:     `sum(rate({metric}{result="error"}[15m])) > 0`,
+			For:      30 * time.Minute,
+			Severity: "warning",
+			Summary:  "OCSP staple refreshes keep failing; the staple will go stale",
+		}},
+	},
+	{
+		Name: "transcode_slots_in_use",
+		Help: "Sessions currently holding a transcode slot",
+		Kind: KindGauge,
+		Unit: "short",
+	},
+	{
+		Name:   "transcode_slot_overflows_total",
+		Help:   "Sessions that found every transcode slot taken, by what happened to them",
+		Kind:   KindCounter,
+		Labels: []string{"action"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayTranscodeSlotsExhausted",
+			Expr:     `sum(rate({metric}[5m])) > 0`,
+			For:      15 * time.Minute,
+			Severity: "warning",
+			Summary:  "Publishers keep finding every transcode slot taken",
+		}},
+	},
+	{
+		Name:   "transcode_smart_decisions_total",
+		Help:   "Smart mode sessions by whether their codecs needed the transcoder or were remuxed as they are",
+		Kind:   KindCounter,
+		Labels: []string{"decision"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "transcode_fps",
+		Help:   "Frames per second encoded by each session's transcoder",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "short",
+	},
+	{
+		Name:   "transcode_speed",
+		Help:   "Media seconds encoded per wall-clock second by each session's transcoder",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "short",
+		Alerts: []Alert{{
+			Name:     "RelayTranscoderBehindRealtime",
+			Expr:     `{metric} < 0.9`,
+			For:      2 * time.Minute,
+			Severity: "warning",
+			Summary:  "A transcoder encodes slower than real time and is falling behind its publisher",
+		}},
+	},
+	{
+		Name:   "transcode_bitrate_bits_per_second",
+		Help:   "Output bitrate of each session's transcoder",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "bps",
+	},
+	{
+		Name:   "transcode_dropped_frames",
+		Help:   "Frames dropped by each session's transcoder since it started",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "short",
+	},
+	{
+		Name:   "transcode_cpu_cores",
+		Help:   "CPU cores used by each session's ffmpeg process over the last poll",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "short",
+	},
+	{
+		Name:   "transcode_memory_bytes",
+		Help:   "Resident memory of each session's ffmpeg process",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "bytes",
+	},
+	{
+		Name:   "av_drift_seconds",
+		Help:   "How far each published stream's video timestamps run ahead of its audio; negative when audio leads",
+		Kind:   KindGauge,
+		Labels: []string{"stream"},
+		Unit:   "s",
+		Alerts: []Alert{{
+			Name:     "RelayAVDrift",
+			Expr:     `abs({metric}) > 0.5`,
+			For:      5 * time.Minute,
+			Severity: "warning",
+			Summary:  "A stream's audio and video have drifted apart; check its encoder",
+		}},
+	},
+	{
+		Name:    "frame_gap_seconds",
+		Help:    "Timestamp gap between consecutive frames of a track in published streams",
+		Kind:    KindHistogram,
+		Labels:  []string{"track"},
+		Buckets: []float64{0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12},
+		Unit:    "s",
+	},
+	{
+		Name:   "long_frame_gaps_total",
+		Help:   "Gaps between frames longer than av_sync.gap_threshold, per stream and track",
+		Kind:   KindCounter,
+		Labels: []string{"stream", "track"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "stream_policy_violations_total",
+		Help:   "Published streams that broke a stream_policy rule, by rule and the action taken",
+		Kind:   KindCounter,
+		Labels: []string{"rule", "action"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "tenant_active_sessions",
+		Help:   "Sessions in progress for each configured tenant",
+		Kind:   KindGauge,
+		Labels: []string{"tenant"},
+		Unit:   "short",
+	},
+	{
+		Name:   "tenant_sessions_total",
+		Help:   "Sessions of each configured tenant by termination reason",
+		Kind:   KindCounter,
+		Labels: []string{"tenant", "reason"},
+		Unit:   "ops",
+	},
+	{
+		Name:   "tenant_rejections_total",
+		Help:   "Sessions refused by a tenant's own auth tokens or limits",
+		Kind:   KindCounter,
+		Labels: []string{"tenant", "limit"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayTenantRejections",
+			Expr:     `sum by (tenant) (rate({metric}{limit!="auth"}[5m])) > 0.1`,
+			For:      15 * time.Minute,
+			Severity: "warning",
+			Summary:  "A tenant keeps running into its connection or rate limit",
+		}},
+	},
+	{
+		Name:   "dvr_postprocess_jobs_total",
+		Help:   "DVR segments post-processed, by whether every step succeeded, one failed, or the segment was skipped",
+		Kind:   KindCounter,
+		Labels: []string{"result"},
+		Unit:   "ops",
+		Alerts: []Alert{{
+			Name:     "RelayDVRPostProcessFailing",
+			Expr:     `sum(rate({metric}{result=~"failed|dropped"}[15m])) > 0`,
+			For:      30 * time.Minute,
+			Severity: "warning",
+			Summary:  "DVR segments keep failing post-processing or finding its queue full",
+		}},
+	},
+	{
+		Name: "dvr_postprocess_jobs_pending",
+		Help: "DVR segments waiting for a post-processing worker",
+		Kind: KindGauge,
+		Unit: "short",
+	},
+	{
+		Name: "buffer_pool_gets_total",
+		Help: "Copy buffers taken from the buffer pool",
+		Kind: KindCounter,
+		Unit: "ops",
+	},
+	{
+		Name: "buffer_pool_misses_total",
+		Help: "Buffer pool gets that had to allocate a new buffer",
+		Kind: KindCounter,
+		Unit: "ops",
+	},
+	{
+		Name: "buffer_pool_puts_total",
+		Help: "Copy buffers returned to the buffer pool",
+		Kind: KindCounter,
+		Unit: "ops",
+	},
+	{
+		Name: "buffer_pool_in_use",
+		Help: "Copy buffers taken from the buffer pool and not yet returned",
+		Kind: KindGauge,
+		Unit: "short",
+	},
+}
+
+// FullName returns the metric name as exported under namespace.
+func (d Definition) FullName(namespace string) string {
+	return prometheus.BuildFQName(namespace, "", d.Name)
+}
+
+func (d Definition) collector(namespace string) (prometheus.Collector, error) {
+	switch d.Kind {
+	case KindCounter:
+		opts := prometheus.CounterOpts{Namespace: namespace, Name: d.Name, Help: d.Help}
+		if len(d.Labels) == 0 {
+			return prometheus.NewCounter(opts), nil
+		}
+		return prometheus.NewCounterVec(opts, d.Labels), nil
+	case KindGauge:
+		opts := prometheus.GaugeOpts{Namespace: namespace, Name: d.Name, Help: d.Help}
+		if len(d.Labels) == 0 {
+			return prometheus.NewGauge(opts), nil
+		}
+		return prometheus.NewGaugeVec(opts, d.Labels), nil
+	case KindHistogram:
+		opts := prometheus.HistogramOpts{Namespace: namespace, Name: d.Name, Help: d.Help, Buckets: d.Buckets}
+		if len(d.Labels) == 0 {
+			return prometheus.NewHistogram(opts), nil
+		}
+		return prometheus.NewHistogramVec(opts, d.Labels), nil
+	}
+	return nil, fmt.Errorf("metrics: %s has unknown kind %q", d.Name, d.Kind)
+}
+
+// bind stores a registered collector in the Registry field for its metric.
+func (r *Registry) bind(name string, c prometheus.Collector) error {
+	var ok bool
+	switch name {
+	case "active_connections":
+		r.ActiveConnections, ok = c.(prometheus.Gauge)
+	case "connections_total":
+		r.TotalConnections, ok = c.(*prometheus.CounterVec)
+	case "bytes_total":
+		r.BytesTransferred, ok = c.(*prometheus.CounterVec)
+	case "connection_duration_seconds":
+		r.ConnectionDuration, ok = c.(prometheus.Histogram)
+	case "session_duration_by_reason_seconds":
+		r.SessionDurationByReason, ok = c.(*prometheus.HistogramVec)
+	case "session_completions_total":
+		r.SessionCompletions, ok = c.(*prometheus.CounterVec)
+	case "session_errors_total":
+		r.SessionErrors, ok = c.(*prometheus.CounterVec)
+	case "failover_switches_total":
+		r.FailoverSwitches, ok = c.(*prometheus.CounterVec)
+	case "latency_seconds":
+		r.LatencyHistogram, ok = c.(prometheus.Histogram)
+	case "dropped_frames_total":
+		r.DroppedFrames, ok = c.(*prometheus.CounterVec)
+	case "upstream_errors_total":
+		r.UpstreamErrors, ok = c.(*prometheus.CounterVec)
+	case "upstream_dial_retries_total":
+		r.UpstreamDialRetries, ok = c.(prometheus.Counter)
+	case "upstream_outlier_ejections_total":
+		r.UpstreamOutlierEjections, ok = c.(*prometheus.CounterVec)
+	case "upstream_prewarm_total":
+		r.UpstreamPrewarm, ok = c.(*prometheus.CounterVec)
+	case "upstream_auth_attempts_total":
+		r.UpstreamAuthAttempts, ok = c.(*prometheus.CounterVec)
+	case "bond_leg_healthy":
+		r.BondLegHealthy, ok = c.(*prometheus.GaugeVec)
+	case "rate_limit_rejections_total":
+		r.RateLimitRejections, ok = c.(prometheus.Counter)
+	case "connection_limit_rejections_total":
+		r.ConnectionLimitRejections, ok = c.(prometheus.Counter)
+	case "publish_limit_rejections_total":
+		r.PublishLimitRejections, ok = c.(*prometheus.CounterVec)
+	case "av_drift_seconds":
+		r.AVDrift, ok = c.(*prometheus.GaugeVec)
+	case "frame_gap_seconds":
+		r.FrameGaps, ok = c.(*prometheus.HistogramVec)
+	case "long_frame_gaps_total":
+		r.LongFrameGaps, ok = c.(*prometheus.CounterVec)
+	case "stream_policy_violations_total":
+		r.StreamPolicyViolations, ok = c.(*prometheus.CounterVec)
+	case "redirects_total":
+		r.Redirects, ok = c.(*prometheus.CounterVec)
+	case "duplicate_publishes_total":
+		r.DuplicatePublishes, ok = c.(*prometheus.CounterVec)
+	case "stream_viewers":
+		r.StreamViewers, ok = c.(*prometheus.GaugeVec)
+	case "viewer_limit_rejections_total":
+		r.ViewerLimitRejections, ok = c.(*prometheus.CounterVec)
+	case "auth_failures_total":
+		r.AuthFailures, ok = c.(prometheus.Counter)
+	case "session_phase_duration_seconds":
+		r.SessionPhaseDuration, ok = c.(*prometheus.HistogramVec)
+	case "sessions_in_state":
+		r.SessionsInState, ok = c.(*prometheus.GaugeVec)
+	case "session_state_transitions_total":
+		r.SessionStateTransitions, ok = c.(*prometheus.CounterVec)
+	case "session_state_duration_seconds":
+		r.SessionStateDuration, ok = c.(*prometheus.HistogramVec)
+	case "session_state_timeouts_total":
+		r.SessionStateTimeouts, ok = c.(*prometheus.CounterVec)
+	case "session_alloc_bytes":
+		r.SessionAllocBytes, ok = c.(prometheus.Histogram)
+	case "tls_cert_expiry_seconds":
+		r.TLSCertExpiry, ok = c.(*prometheus.GaugeVec)
+	case "tls_cert_expiring":
+		r.TLSCertExpiring, ok = c.(*prometheus.GaugeVec)
+	case "ocsp_refreshes_total":
+		r.OCSPRefreshes, ok = c.(*prometheus.CounterVec)
+	case "transcode_slots_in_use":
+		r.TranscodeSlotsInUse, ok = c.(prometheus.Gauge)
+	case "transcode_slot_overflows_total":
+		r.TranscodeSlotOverflows, ok = c.(*prometheus.CounterVec)
+	case "transcode_smart_decisions_total":
+		r.TranscodeSmartDecisions, ok = c.(*prometheus.CounterVec)
+	case "transcode_fps":
+		r.TranscodeFPS, ok = c.(*prometheus.GaugeVec)
+	case "transcode_speed":
+		r.TranscodeSpeed, ok = c.(*prometheus.GaugeVec)
+	case "transcode_bitrate_bits_per_second":
+		r.TranscodeBitrate, ok = c.(*prometheus.GaugeVec)
+	case "transcode_dropped_frames":
+		r.TranscodeDroppedFrames, ok = c.(*prometheus.GaugeVec)
+	case "transcode_cpu_cores":
+		r.TranscodeCPUCores, ok = c.(*prometheus.GaugeVec)
+	case "transcode_memory_bytes":
+		r.TranscodeMemory, ok = c.(*prometheus.GaugeVec)
+	case "tenant_active_sessions":
+		r.TenantActiveSessions, ok = c.(*prometheus.GaugeVec)
+	case "tenant_sessions_total":
+		r.TenantSessions, ok = c.(*prometheus.CounterVec)
+	case "tenant_rejections_total":
+		r.TenantRejections, ok = c.(*prometheus.CounterVec)
+	case "dvr_postprocess_jobs_total":
+		r.DVRPostProcessJobs, ok = c.(*prometheus.CounterVec)
+	case "dvr_postprocess_jobs_pending":
+		r.DVRPostProcessPending, ok = c.(prometheus.Gauge)
+	case "buffer_pool_gets_total":
+		r.BufferPoolGets, ok = c.(prometheus.Counter)
+	case "buffer_pool_misses_total":
+		r.BufferPoolMisses, ok = c.(prometheus.Counter)
+	case "buffer_pool_puts_total":
+		r.BufferPoolPuts, ok = c.(prometheus.Counter)
+	case "buffer_pool_in_use":
+		r.BufferPoolInUse, ok = c.(prometheus.Gauge)
+	default:
+		return fmt.Errorf("metrics: no Registry field for %s", name)
+	}
+	if !ok {
+		return fmt.Errorf("metrics: %s is already registered as %T", name, c)
+	}
+	return nil
+}
@@ -1,108 +1,676 @@
 package metrics
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// Define all Prometheus metrics
-var (
+// DefaultNamespace prefixes metric names when no namespace is given.
+const DefaultNamespace = "rtmp_relay"
+
+// Registry holds the relay's Prometheus collectors. Embedding applications
+// create their own with NewRegistry; the standalone binary uses Default.
+// All Record and Observe methods are no-ops on a nil Registry.
+type Registry struct {
 	// Active connections gauge
-	ActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "rtmp_relay_active_connections",
-		Help: "Number of active RTMP relay connections",
-	})
+	ActiveConnections prometheus.Gauge
 
 	// Total connections counter
-	TotalConnections = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "rtmp_relay_connections_total",
-		Help: "Total number of RTMP connections",
-	}, []string{"status"})
+	TotalConnections *prometheus.CounterVec
 
 	// Bytes transferred counter
-	BytesTransferred = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "rtmp_relay_bytes_total",
-		Help: "Total bytes transferred",
-	}, []string{"direction"})
+	BytesTransferred *prometheus.CounterVec
 
 	// Connection duration histogram
-	ConnectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name:    "rtmp_relay_connection_duration_seconds",
-		Help:    "Connection duration in seconds",
-		Buckets: prometheus.ExponentialBuckets(1, 2, 10), // 1s to 512s
-	})
+	ConnectionDuration prometheus.Histogram
+
+	// Session duration histogram split by termination reason
+	SessionDurationByReason *prometheus.HistogramVec
+
+	// Session completions counter by termination reason
+	SessionCompletions *prometheus.CounterVec
+
+	// Failed sessions counter by error class
+	SessionErrors *prometheus.CounterVec
+
+	// Publisher failover switches counter
+	FailoverSwitches *prometheus.CounterVec
 
 	// Upstream connection latency histogram
-	LatencyHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name:    "rtmp_relay_latency_seconds",
-		Help:    "Relay latency in seconds",
-		Buckets: prometheus.DefBuckets,
-	})
+	LatencyHistogram prometheus.Histogram
+
+	// Media frames shed by per-session queues under backpressure
+	DroppedFrames *prometheus.CounterVec
 
 	// Upstream errors counter
-	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "rtmp_relay_upstream_errors_total",
-		Help: "Total upstream connection errors",
-	}, []string{"error_type"})
+	UpstreamErrors *prometheus.CounterVec
+
+	// Upstream dial attempts that were retried
+	UpstreamDialRetries prometheus.Counter
+
+	// Pool upstreams ejected by outlier detection, by upstream
+	UpstreamOutlierEjections *prometheus.CounterVec
+
+	// Sessions served from the pre-warmed upstream pool, by hit/miss
+	UpstreamPrewarm *prometheus.CounterVec
+
+	// Upstream connects by credential and accepted/rejected result
+	UpstreamAuthAttempts *prometheus.CounterVec
+
+	// Health of each leg of a redundant push, by stream and upstream
+	BondLegHealthy *prometheus.GaugeVec
 
 	// Rate limit rejections counter
-	RateLimitRejections = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "rtmp_relay_rate_limit_rejections_total",
-		Help: "Total connections rejected by rate limiting",
-	})
+	RateLimitRejections prometheus.Counter
 
 	// Connection limit rejections counter
-	ConnectionLimitRejections = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "rtmp_relay_connection_limit_rejections_total",
-		Help: "Total connections rejected by connection limits",
-	})
+	ConnectionLimitRejections prometheus.Counter
+
+	// Publishes refused by the per-IP or per-token stream limit
+	PublishLimitRejections *prometheus.CounterVec
+
+	// Publishers redirected to another relay, by reason
+	Redirects *prometheus.CounterVec
+
+	// Publishes of a stream name another session was publishing, by outcome
+	DuplicatePublishes *prometheus.CounterVec
+
+	// Players of each stream by protocol, and those turned away by the cap
+	StreamViewers         *prometheus.GaugeVec
+	ViewerLimitRejections *prometheus.CounterVec
+
+	// Audio/video sync of published streams
+	AVDrift       *prometheus.GaugeVec
+	FrameGaps     *prometheus.HistogramVec
+	LongFrameGaps *prometheus.CounterVec
+
+	// Stream policy violations, by rule and action
+	StreamPolicyViolations *prometheus.CounterVec
 
 	// Authentication failures counter
-	AuthFailures = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "rtmp_relay_auth_failures_total",
-		Help: "Total authentication failures",
-	})
+	AuthFailures prometheus.Counter
+
+	// Sampled per-session phase timings
+	SessionPhaseDuration *prometheus.HistogramVec
+
+	// Sampled per-session heap allocations
+	SessionAllocBytes prometheus.Histogram
+
+	// Sessions by connection state, their transitions, the time spent in
+	// each state and the sessions that overstayed one
+	SessionsInState         *prometheus.GaugeVec
+	SessionStateTransitions *prometheus.CounterVec
+	SessionStateDuration    *prometheus.HistogramVec
+	SessionStateTimeouts    *prometheus.CounterVec
+
+	// Seconds until each served TLS certificate expires
+	TLSCertExpiry *prometheus.GaugeVec
+
+	// 1 while a served TLS certificate is inside the expiry warning window
+	TLSCertExpiring *prometheus.GaugeVec
+
+	// OCSP staple fetches by result
+	OCSPRefreshes *prometheus.CounterVec
+
+	// Sessions holding a transcode slot
+	TranscodeSlotsInUse prometheus.Gauge
+
+	// Sessions turned away from a full transcoder, by fallback action
+	TranscodeSlotOverflows *prometheus.CounterVec
+
+	// Smart mode sessions by whether they were transcoded or remuxed
+	TranscodeSmartDecisions *prometheus.CounterVec
+
+	// Per-stream encoder progress reported by the transcoders
+	TranscodeFPS           *prometheus.GaugeVec
+	TranscodeSpeed         *prometheus.GaugeVec
+	TranscodeBitrate       *prometheus.GaugeVec
+	TranscodeDroppedFrames *prometheus.GaugeVec
+
+	// Per-stream CPU and memory of the ffmpeg processes
+	TranscodeCPUCores *prometheus.GaugeVec
+	TranscodeMemory   *prometheus.GaugeVec
+
+	// Per-tenant sessions in progress, completions and limit rejections
+	TenantActiveSessions *prometheus.GaugeVec
+	TenantSessions       *prometheus.CounterVec
+	TenantRejections     *prometheus.CounterVec
+
+	// DVR segment post-processing outcomes and backlog
+	DVRPostProcessJobs    *prometheus.CounterVec
+	DVRPostProcessPending prometheus.Gauge
+
+	// Copy buffer pool gets, allocations, returns and buffers out
+	BufferPoolGets   prometheus.Counter
+	BufferPoolMisses prometheus.Counter
+	BufferPoolPuts   prometheus.Counter
+	BufferPoolInUse  prometheus.Gauge
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
 )
 
+// Default returns the process-wide registry backed by prometheus.DefaultRegisterer.
+// It is created on first use so that importing this package registers nothing.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		r, err := NewRegistry(prometheus.DefaultRegisterer, DefaultNamespace)
+		if err != nil {
+			panic(fmt.Sprintf("metrics: register default collectors: %v", err))
+		}
+		defaultRegistry = r
+	})
+	return defaultRegistry
+}
+
+// NewRegistry creates the collectors listed in Definitions, named
+// "<namespace>_<metric>", and registers them with reg. An empty namespace uses DefaultNamespace. Collectors already
+// registered with the same name and shape are reused, so several Servers can
+// share one registerer; any other conflict is returned as an error.
+func NewRegistry(reg prometheus.Registerer, namespace string) (*Registry, error) {
+	if reg == nil {
+		return nil, errors.New("metrics: nil registerer")
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	r := &Registry{}
+	for _, d := range Definitions {
+		c, err := d.collector(namespace)
+		if err != nil {
+			return nil, err
+		}
+		if c, err = register(reg, c); err != nil {
+			return nil, err
+		}
+		if err := r.bind(d.Name, c); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// register adds c to reg, returning the previously registered collector when
+// an identical one already exists.
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) (T, error) {
+	err := reg.Register(c)
+	if err == nil {
+		return c, nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return c, fmt.Errorf("metrics: register collector: %w", err)
+}
+
 // RecordConnectionStart records when a connection starts
-func RecordConnectionStart() {
-	ActiveConnections.Inc()
-	TotalConnections.WithLabelValues("started").Inc()
+func (r *Registry) RecordConnectionStart() {
+	if r == nil {
+		return
+	}
+	r.ActiveConnections.Inc()
+	r.TotalConnections.WithLabelValues("started").Inc()
 }
 
 // RecordConnectionSuccess records when a connection completes successfully
-func RecordConnectionSuccess() {
-	ActiveConnections.Dec()
-	TotalConnections.WithLabelValues("success").Inc()
+func (r *Registry) RecordConnectionSuccess() {
+	if r == nil {
+		return
+	}
+	r.ActiveConnections.Dec()
+	r.TotalConnections.WithLabelValues("success").Inc()
 }
 
-// RecordConnectionError records when a connection ends with error
-func RecordConnectionError() {
-	ActiveConnections.Dec()
-	TotalConnections.WithLabelValues("error").Inc()
+// RecordConnectionError records when a connection ends with an error of
+// class, e.g. "protocol" or "upstream_dial"
+func (r *Registry) RecordConnectionError(class string) {
+	if r == nil {
+		return
+	}
+	r.ActiveConnections.Dec()
+	r.TotalConnections.WithLabelValues("error").Inc()
+	r.SessionErrors.WithLabelValues(class).Inc()
+}
+
+// ObserveConnectionDuration records how long a connection lasted
+func (r *Registry) ObserveConnectionDuration(d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.ConnectionDuration.Observe(d.Seconds())
+}
+
+// RecordSessionEnd records a finished session's duration and termination reason
+func (r *Registry) RecordSessionEnd(reason string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.SessionDurationByReason.WithLabelValues(reason).Observe(d.Seconds())
+	r.SessionCompletions.WithLabelValues(reason).Inc()
+}
+
+// ResumeSessionCompletions starts the completion counters from the totals a
+// previous run left in the state store, so they carry on across restarts.
+func (r *Registry) ResumeSessionCompletions(byReason map[string]uint64) {
+	if r == nil {
+		return
+	}
+	for reason, n := range byReason {
+		r.SessionCompletions.WithLabelValues(reason).Add(float64(n))
+	}
+}
+
+// RecordFailoverSwitch records a switchover between paired publishers
+func (r *Registry) RecordFailoverSwitch(reason string) {
+	if r == nil {
+		return
+	}
+	r.FailoverSwitches.WithLabelValues(reason).Inc()
+}
+
+// ObserveLatency records upstream connection setup latency
+func (r *Registry) ObserveLatency(d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.LatencyHistogram.Observe(d.Seconds())
 }
 
 // RecordBytesTransferred records bytes transferred in a direction
-func RecordBytesTransferred(direction string, bytes int64) {
-	BytesTransferred.WithLabelValues(direction).Add(float64(bytes))
+func (r *Registry) RecordBytesTransferred(direction string, bytes int64) {
+	if r == nil {
+		return
+	}
+	r.BytesTransferred.WithLabelValues(direction).Add(float64(bytes))
+}
+
+// RecordDroppedFrame records a media frame shed under backpressure
+func (r *Registry) RecordDroppedFrame(kind string) {
+	if r == nil {
+		return
+	}
+	r.DroppedFrames.WithLabelValues(kind).Inc()
 }
 
 // RecordUpstreamError records an upstream error
-func RecordUpstreamError(errorType string) {
-	UpstreamErrors.WithLabelValues(errorType).Inc()
+func (r *Registry) RecordUpstreamError(errorType string) {
+	if r == nil {
+		return
+	}
+	r.UpstreamErrors.WithLabelValues(errorType).Inc()
+}
+
+// RecordUpstreamDialRetry records a failed upstream dial that will be retried
+func (r *Registry) RecordUpstreamDialRetry() {
+	if r == nil {
+		return
+	}
+	r.UpstreamDialRetries.Inc()
+}
+
+// RecordUpstreamOutlierEjection records an upstream ejected by outlier detection
+func (r *Registry) RecordUpstreamOutlierEjection(upstream string) {
+	if r == nil {
+		return
+	}
+	r.UpstreamOutlierEjections.WithLabelValues(upstream).Inc()
+}
+
+// RecordUpstreamPrewarm records whether a session found a warm upstream connection
+func (r *Registry) RecordUpstreamPrewarm(result string) {
+	if r == nil {
+		return
+	}
+	r.UpstreamPrewarm.WithLabelValues(result).Inc()
+}
+
+// RecordUpstreamAuth records whether an upstream accepted a credential
+func (r *Registry) RecordUpstreamAuth(credential, result string) {
+	if r == nil {
+		return
+	}
+	r.UpstreamAuthAttempts.WithLabelValues(credential, result).Inc()
+}
+
+// SetBondLegHealthy records whether a redundant push leg is up and keeping pace
+func (r *Registry) SetBondLegHealthy(stream, upstream string, healthy bool) {
+	if r == nil {
+		return
+	}
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	r.BondLegHealthy.WithLabelValues(stream, upstream).Set(v)
+}
+
+// DeleteBondLeg drops the series of a redundant push leg whose session ended
+func (r *Registry) DeleteBondLeg(stream, upstream string) {
+	if r == nil {
+		return
+	}
+	r.BondLegHealthy.DeleteLabelValues(stream, upstream)
 }
 
 // RecordRateLimitRejection records a rate limit rejection
-func RecordRateLimitRejection() {
-	RateLimitRejections.Inc()
+func (r *Registry) RecordRateLimitRejection() {
+	if r == nil {
+		return
+	}
+	r.RateLimitRejections.Inc()
 }
 
 // RecordConnectionLimitRejection records a connection limit rejection
-func RecordConnectionLimitRejection() {
-	ConnectionLimitRejections.Inc()
+func (r *Registry) RecordConnectionLimitRejection() {
+	if r == nil {
+		return
+	}
+	r.ConnectionLimitRejections.Inc()
+}
+
+// RecordPublishLimitRejection records a publish refused by the stream limit
+// of scope, "ip" or "token".
+func (r *Registry) RecordPublishLimitRejection(scope string) {
+	if r == nil {
+		return
+	}
+	r.PublishLimitRejections.WithLabelValues(scope).Inc()
+}
+
+// RecordRedirect records a publisher redirected to another relay, for reason
+// "app" or "overflow".
+func (r *Registry) RecordRedirect(reason string) {
+	if r == nil {
+		return
+	}
+	r.Redirects.WithLabelValues(reason).Inc()
+}
+
+// RecordDuplicatePublish records a publish of a stream that was already
+// live: "reject" when the newcomer was refused, "kick" or "takeover" when it
+// replaced the running session.
+func (r *Registry) RecordDuplicatePublish(outcome string) {
+	if r == nil {
+		return
+	}
+	r.DuplicatePublishes.WithLabelValues(outcome).Inc()
+}
+
+// SetStreamViewers records how many players of a protocol a stream has,
+// dropping the series once it has none.
+func (r *Registry) SetStreamViewers(stream, protocol string, n int) {
+	if r == nil {
+		return
+	}
+	if n == 0 {
+		r.StreamViewers.DeleteLabelValues(stream, protocol)
+		return
+	}
+	r.StreamViewers.WithLabelValues(stream, protocol).Set(float64(n))
+}
+
+// RecordViewerLimitRejection records a player turned away from a stream at
+// its viewer cap.
+func (r *Registry) RecordViewerLimitRejection(protocol string) {
+	if r == nil {
+		return
+	}
+	r.ViewerLimitRejections.WithLabelValues(protocol).Inc()
+}
+
+// SetAVDrift records how far a stream's video timestamps run ahead of its
+// audio; negative when the audio is ahead.
+func (r *Registry) SetAVDrift(stream string, seconds float64) {
+	if r == nil {
+		return
+	}
+	r.AVDrift.WithLabelValues(stream).Set(seconds)
+}
+
+// ObserveFrameGap records the timestamp gap between consecutive frames of a
+// track, "audio" or "video". long marks gaps over the configured threshold,
+// which are also counted per stream.
+func (r *Registry) ObserveFrameGap(stream, track string, seconds float64, long bool) {
+	if r == nil {
+		return
+	}
+	r.FrameGaps.WithLabelValues(track).Observe(seconds)
+	if long {
+		r.LongFrameGaps.WithLabelValues(stream, track).Inc()
+	}
+}
+
+// DeleteAVSync removes the per-stream sync series of a stream that ended.
+func (r *Registry) DeleteAVSync(stream string) {
+	if r == nil {
+		return
+	}
+	r.AVDrift.DeleteLabelValues(stream)
+	r.LongFrameGaps.DeleteLabelValues(stream, "audio")
+	r.LongFrameGaps.DeleteLabelValues(stream, "video")
+}
+
+// RecordStreamPolicyViolation records a stream breaking a stream_policy
+// rule, with the action taken, "warn" or "reject".
+func (r *Registry) RecordStreamPolicyViolation(rule, action string) {
+	if r == nil {
+		return
+	}
+	r.StreamPolicyViolations.WithLabelValues(rule, action).Inc()
 }
 
 // RecordAuthFailure records an authentication failure
-func RecordAuthFailure() {
-	AuthFailures.Inc()
+func (r *Registry) RecordAuthFailure() {
+	if r == nil {
+		return
+	}
+	r.AuthFailures.Inc()
+}
+
+// ObservePhase records time spent in a session phase. Together with
+// ObserveAllocBytes it lets a Registry serve as a profiling.Observer.
+func (r *Registry) ObservePhase(phase string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.SessionPhaseDuration.WithLabelValues(phase).Observe(d.Seconds())
+}
+
+// RecordSessionState records a session leaving state from, after spent in
+// it, for state to. from is empty for a new session and to for one that
+// ended.
+func (r *Registry) RecordSessionState(from, to string, spent time.Duration) {
+	if r == nil {
+		return
+	}
+	if from != "" {
+		r.SessionsInState.WithLabelValues(from).Dec()
+		r.SessionStateDuration.WithLabelValues(from).Observe(spent.Seconds())
+	}
+	if to != "" {
+		r.SessionsInState.WithLabelValues(to).Inc()
+	}
+	if from != "" && to != "" {
+		r.SessionStateTransitions.WithLabelValues(from, to).Inc()
+	}
+}
+
+// RecordSessionStateTimeout records a session ended for staying in state
+// too long.
+func (r *Registry) RecordSessionStateTimeout(state string) {
+	if r == nil {
+		return
+	}
+	r.SessionStateTimeouts.WithLabelValues(state).Inc()
+}
+
+// ObserveAllocBytes records heap bytes allocated during a session
+func (r *Registry) ObserveAllocBytes(bytes uint64) {
+	if r == nil {
+		return
+	}
+	r.SessionAllocBytes.Observe(float64(bytes))
+}
+
+// SetTLSCertExpiry records how long a served certificate remains valid and
+// whether that is inside the warning window
+func (r *Registry) SetTLSCertExpiry(subject string, remaining time.Duration, expiring bool) {
+	if r == nil {
+		return
+	}
+	r.TLSCertExpiry.WithLabelValues(subject).Set(remaining.Seconds())
+	flag := 0.0
+	if expiring {
+		flag = 1
+	}
+	r.TLSCertExpiring.WithLabelValues(subject).Set(flag)
+}
+
+// RecordOCSPRefresh records the outcome of an OCSP staple fetch
+func (r *Registry) RecordOCSPRefresh(result string) {
+	if r == nil {
+		return
+	}
+	r.OCSPRefreshes.WithLabelValues(result).Inc()
+}
+
+// AddTranscodeSlotsInUse moves the transcode slot gauge by delta
+func (r *Registry) AddTranscodeSlotsInUse(delta int) {
+	if r == nil {
+		return
+	}
+	r.TranscodeSlotsInUse.Add(float64(delta))
+}
+
+// RecordTranscodeSlotOverflow records a session that found every transcode
+// slot taken and was passed through or rejected
+func (r *Registry) RecordTranscodeSlotOverflow(action string) {
+	if r == nil {
+		return
+	}
+	r.TranscodeSlotOverflows.WithLabelValues(action).Inc()
+}
+
+// RecordTranscodeSmartDecision records whether a smart mode session was
+// transcoded or remuxed
+func (r *Registry) RecordTranscodeSmartDecision(decision string) {
+	if r == nil {
+		return
+	}
+	r.TranscodeSmartDecisions.WithLabelValues(decision).Inc()
+}
+
+// SetTranscodeProgress records the latest encoder progress for a stream
+func (r *Registry) SetTranscodeProgress(stream string, fps, speed, bitsPerSecond float64, dropped int64) {
+	if r == nil {
+		return
+	}
+	r.TranscodeFPS.WithLabelValues(stream).Set(fps)
+	r.TranscodeSpeed.WithLabelValues(stream).Set(speed)
+	r.TranscodeBitrate.WithLabelValues(stream).Set(bitsPerSecond)
+	r.TranscodeDroppedFrames.WithLabelValues(stream).Set(float64(dropped))
+}
+
+// DeleteTranscodeProgress drops a finished stream's progress series
+func (r *Registry) DeleteTranscodeProgress(stream string) {
+	if r == nil {
+		return
+	}
+	r.TranscodeFPS.DeleteLabelValues(stream)
+	r.TranscodeSpeed.DeleteLabelValues(stream)
+	r.TranscodeBitrate.DeleteLabelValues(stream)
+	r.TranscodeDroppedFrames.DeleteLabelValues(stream)
+}
+
+// SetTranscodeUsage records the CPU and memory a stream's ffmpeg process uses
+func (r *Registry) SetTranscodeUsage(stream string, cpuCores float64, rssBytes int64) {
+	if r == nil {
+		return
+	}
+	r.TranscodeCPUCores.WithLabelValues(stream).Set(cpuCores)
+	r.TranscodeMemory.WithLabelValues(stream).Set(float64(rssBytes))
+}
+
+// DeleteTranscodeUsage drops a finished stream's usage series
+func (r *Registry) DeleteTranscodeUsage(stream string) {
+	if r == nil {
+		return
+	}
+	r.TranscodeCPUCores.DeleteLabelValues(stream)
+	r.TranscodeMemory.DeleteLabelValues(stream)
+}
+
+// AddTenantActiveSessions moves a tenant's session gauge by delta
+func (r *Registry) AddTenantActiveSessions(tenant string, delta int) {
+	if r == nil {
+		return
+	}
+	r.TenantActiveSessions.WithLabelValues(tenant).Add(float64(delta))
+}
+
+// RecordTenantSession records a finished session of a tenant
+func (r *Registry) RecordTenantSession(tenant, reason string) {
+	if r == nil {
+		return
+	}
+	r.TenantSessions.WithLabelValues(tenant, reason).Inc()
+}
+
+// RecordTenantRejection records a session refused by a tenant's auth tokens
+// or limits
+func (r *Registry) RecordTenantRejection(tenant, limit string) {
+	if r == nil {
+		return
+	}
+	r.TenantRejections.WithLabelValues(tenant, limit).Inc()
+}
+
+// RecordPostProcessJob records a DVR segment that went through
+// post-processing or was skipped
+func (r *Registry) RecordPostProcessJob(result string) {
+	if r == nil {
+		return
+	}
+	r.DVRPostProcessJobs.WithLabelValues(result).Inc()
+}
+
+// SetPostProcessPending records how many DVR segments wait for a
+// post-processing worker
+func (r *Registry) SetPostProcessPending(n int64) {
+	if r == nil {
+		return
+	}
+	r.DVRPostProcessPending.Set(float64(n))
+}
+
+// RecordBufferGet records a buffer taken from the pool; miss means it had to
+// be allocated
+func (r *Registry) RecordBufferGet(miss bool) {
+	if r == nil {
+		return
+	}
+	r.BufferPoolGets.Inc()
+	if miss {
+		r.BufferPoolMisses.Inc()
+	}
+	r.BufferPoolInUse.Inc()
+}
+
+// RecordBufferPut records a buffer returned to the pool
+func (r *Registry) RecordBufferPut() {
+	if r == nil {
+		return
+	}
+	r.BufferPoolPuts.Inc()
+	r.BufferPoolInUse.Dec()
 }
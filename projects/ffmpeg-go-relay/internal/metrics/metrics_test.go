@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewRegistryNamespace(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := NewRegistry(reg, "embedded")
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	r.RecordConnectionStart()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	found := false
+	for _, f := range families {
+		if f.GetName() == "embedded_active_connections" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected embedded_active_connections to be registered")
+	}
+}
+
+func TestNewRegistryReusesExistingCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first, err := NewRegistry(reg, "")
+	if err != nil {
+		t.Fatalf("first NewRegistry: %v", err)
+	}
+	second, err := NewRegistry(reg, "")
+	if err != nil {
+		t.Fatalf("second NewRegistry should reuse collectors, got %v", err)
+	}
+
+	first.RecordAuthFailure()
+	second.RecordAuthFailure()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "rtmp_relay_auth_failures_total" {
+			continue
+		}
+		if got := f.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+			t.Fatalf("auth failures = %v, want 2 (shared collector)", got)
+		}
+		return
+	}
+	t.Fatal("auth failures metric not gathered")
+}
+
+func TestNewRegistryConflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rtmp_relay_connections_total",
+		Help: "host application metric with a clashing name",
+	}))
+	if _, err := NewRegistry(reg, ""); err == nil {
+		t.Fatal("expected conflicting collector to return an error")
+	}
+}
+
+func TestNilRegistryIsNoop(t *testing.T) {
+	var r *Registry
+	r.RecordConnectionStart()
+	r.RecordSessionEnd("idle", time.Second)
+	r.ResumeSessionCompletions(map[string]uint64{"idle": 1})
+	r.ObservePhase("copy", time.Millisecond)
+	r.SetTLSCertExpiry("relay.example", time.Hour, true)
+	r.RecordOCSPRefresh("ok")
+}
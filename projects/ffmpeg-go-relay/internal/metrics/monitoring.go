@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateWindow is the range used for rate() and histogram queries on the
+// generated dashboard.
+const rateWindow = "5m"
+
+// AlertRules renders the alerts in Definitions as a Prometheus rule file for
+// metrics exported under namespace. An empty namespace uses DefaultNamespace.
+func AlertRules(namespace string) []byte {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by `relay gen-monitoring`; do not edit.\n")
+	fmt.Fprintf(&b, "groups:\n  - name: %s\n    rules:\n", namespace)
+	for _, d := range Definitions {
+		for _, a := range d.Alerts {
+			fmt.Fprintf(&b, "      - alert: %s\n", a.Name)
+			fmt.Fprintf(&b, "        expr: %s\n", strconv.Quote(a.expr(d, namespace)))
+			if a.For > 0 {
+				fmt.Fprintf(&b, "        for: %s\n", promDuration(a.For))
+			}
+			fmt.Fprintf(&b, "        labels:\n          severity: %s\n", a.Severity)
+			fmt.Fprintf(&b, "        annotations:\n")
+			fmt.Fprintf(&b, "          summary: %s\n", strconv.Quote(a.Summary))
+			fmt.Fprintf(&b, "          description: %s\n", strconv.Quote(d.FullName(namespace)+": "+d.Help))
+		}
+	}
+	return b.Bytes()
+}
+
+func (a Alert) expr(d Definition, namespace string) string {
+	return strings.ReplaceAll(a.Expr, "{metric}", d.FullName(namespace))
+}
+
+// promDuration formats d the way Prometheus rule files write durations.
+func promDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+type dashboard struct {
+	UID           string           `json:"uid"`
+	Title         string           `json:"title"`
+	Tags          []string         `json:"tags"`
+	Timezone      string           `json:"timezone"`
+	Refresh       string           `json:"refresh"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          map[string]any   `json:"time"`
+	Templating    map[string]any   `json:"templating"`
+	Panels        []dashboardPanel `json:"panels"`
+}
+
+type dashboardPanel struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Datasource  map[string]string `json:"datasource"`
+	GridPos     map[string]int    `json:"gridPos"`
+	FieldConfig map[string]any    `json:"fieldConfig"`
+	Targets     []panelTarget     `json:"targets"`
+}
+
+type panelTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// Dashboard renders a Grafana dashboard with one panel per entry in
+// Definitions for metrics exported under namespace. An empty namespace uses
+// DefaultNamespace. The Prometheus datasource is picked through a dashboard
+// variable so the JSON imports into any Grafana.
+func Dashboard(namespace string) ([]byte, error) {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	datasource := map[string]string{"type": "prometheus", "uid": "${datasource}"}
+	db := dashboard{
+		UID:           strings.ReplaceAll(namespace, "_", "-"),
+		Title:         "RTMP relay (" + namespace + ")",
+		Tags:          []string{"rtmp", "relay", "generated"},
+		Timezone:      "browser",
+		Refresh:       "30s",
+		SchemaVersion: 39,
+		Time:          map[string]any{"from": "now-6h", "to": "now"},
+		Templating: map[string]any{"list": []map[string]any{{
+			"name":  "datasource",
+			"label": "Datasource",
+			"type":  "datasource",
+			"query": "prometheus",
+		}}},
+	}
+	for i, d := range Definitions {
+		db.Panels = append(db.Panels, dashboardPanel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       d.Name,
+			Description: d.Help,
+			Datasource:  datasource,
+			GridPos:     map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			FieldConfig: map[string]any{"defaults": map[string]any{"unit": d.Unit}, "overrides": []any{}},
+			Targets:     d.panelTargets(namespace),
+		})
+	}
+	return json.MarshalIndent(db, "", "  ")
+}
+
+// panelTargets returns the queries charting d: per-second rates for
+// counters, the raw value for gauges and p50/p99 for histograms, split by
+// d's labels.
+func (d Definition) panelTargets(namespace string) []panelTarget {
+	name := d.FullName(namespace)
+	by, legend := "", d.Name
+	if len(d.Labels) > 0 {
+		by = " by (" + strings.Join(d.Labels, ", ") + ") "
+		parts := make([]string, len(d.Labels))
+		for i, l := range d.Labels {
+			parts[i] = "{{" + l + "}}"
+		}
+		legend = strings.Join(parts, " ")
+	}
+	switch d.Kind {
+	case KindCounter:
+		return []panelTarget{{RefID: "A", Expr: fmt.Sprintf("sum%s(rate(%s[%s]))", by, name, rateWindow), LegendFormat: legend}}
+	case KindGauge:
+		return []panelTarget{{RefID: "A", Expr: fmt.Sprintf("max%s(%s)", by, name), LegendFormat: legend}}
+	case KindHistogram:
+		le := " by (" + strings.Join(append([]string{"le"}, d.Labels...), ", ") + ") "
+		quantile := func(refID, q, label string) panelTarget {
+			return panelTarget{
+				RefID:        refID,
+				Expr:         fmt.Sprintf("histogram_quantile(%s, sum%s(rate(%s_bucket[%s])))", q, le, name, rateWindow),
+				LegendFormat: label + " " + legend,
+			}
+		}
+		return []panelTarget{quantile("A", "0.5", "p50"), quantile("B", "0.99", "p99")}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registeredNames returns the metric names of every collector in a Registry
+// built under namespace.
+func registeredNames(t *testing.T, namespace string) map[string]bool {
+	t.Helper()
+	r, err := NewRegistry(prometheus.NewRegistry(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fqName := regexp.MustCompile(`fqName: "([^"]+)"`)
+	names := make(map[string]bool)
+	v := reflect.ValueOf(r).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		c, ok := v.Field(i).Interface().(prometheus.Collector)
+		if !ok || v.Field(i).IsNil() {
+			t.Fatalf("Registry.%s was not populated from Definitions", v.Type().Field(i).Name)
+		}
+		ch := make(chan *prometheus.Desc, 1)
+		go func() { c.Describe(ch); close(ch) }()
+		for desc := range ch {
+			names[fqName.FindStringSubmatch(desc.String())[1]] = true
+		}
+	}
+	if len(names) != len(Definitions) {
+		t.Fatalf("Registry exports %d metrics, Definitions lists %d", len(names), len(Definitions))
+	}
+	return names
+}
+
+// checkReferences fails if expr mentions a namespaced metric the registry
+// does not export. Histogram series suffixes are stripped first.
+func checkReferences(t *testing.T, where, expr, namespace string, names map[string]bool) {
+	t.Helper()
+	refs := regexp.MustCompile(namespace+`_[a-z0-9_]+`).FindAllString(expr, -1)
+	if len(refs) == 0 {
+		t.Errorf("%s: %q references no %s metric", where, expr, namespace)
+	}
+	for _, ref := range refs {
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(ref, suffix); base != ref && names[base] {
+				ref = base
+			}
+		}
+		if !names[ref] {
+			t.Errorf("%s: %q references unknown metric %s", where, expr, ref)
+		}
+	}
+}
+
+func TestAlertRulesReferenceRegisteredMetrics(t *testing.T) {
+	const ns = "edge"
+	names := registeredNames(t, ns)
+	rules := string(AlertRules(ns))
+	if !strings.Contains(rules, "  - name: edge\n") {
+		t.Fatalf("rule group not named after the namespace:\n%s", rules)
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range Definitions {
+		for _, a := range d.Alerts {
+			if seen[a.Name] {
+				t.Errorf("duplicate alert name %s", a.Name)
+			}
+			seen[a.Name] = true
+			if a.Severity != "warning" && a.Severity != "critical" {
+				t.Errorf("%s: severity %q", a.Name, a.Severity)
+			}
+			expr := a.expr(d, ns)
+			if strings.Contains(expr, "{metric}") {
+				t.Errorf("%s: placeholder left in %q", a.Name, expr)
+			}
+			checkReferences(t, a.Name, expr, ns, names)
+			if !strings.Contains(rules, "      - alert: "+a.Name+"\n") {
+				t.Errorf("%s missing from rule file", a.Name)
+			}
+		}
+	}
+	if len(seen) == 0 {
+		t.Fatal("no alerts defined")
+	}
+}
+
+func TestDashboardPanelsMatchDefinitions(t *testing.T) {
+	names := registeredNames(t, "")
+	raw, err := Dashboard("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var db dashboard
+	if err := json.Unmarshal(raw, &db); err != nil {
+		t.Fatalf("dashboard is not valid JSON: %v", err)
+	}
+	if len(db.Panels) != len(Definitions) {
+		t.Fatalf("dashboard has %d panels, want one per definition (%d)", len(db.Panels), len(Definitions))
+	}
+	for i, p := range db.Panels {
+		d := Definitions[i]
+		want := 1
+		if d.Kind == KindHistogram {
+			want = 2
+		}
+		if len(p.Targets) != want {
+			t.Errorf("%s: %d queries, want %d", d.Name, len(p.Targets), want)
+		}
+		for _, target := range p.Targets {
+			checkReferences(t, d.Name, target.Expr, DefaultNamespace, names)
+		}
+	}
+}
+
+func TestPanelTargets(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want []string
+	}{
+		{"active_connections", []string{"max(ns_active_connections)"}},
+		{"auth_failures_total", []string{"sum(rate(ns_auth_failures_total[5m]))"}},
+		{"upstream_errors_total", []string{"sum by (error_type) (rate(ns_upstream_errors_total[5m]))"}},
+		{"session_phase_duration_seconds", []string{
+			"histogram_quantile(0.5, sum by (le, phase) (rate(ns_session_phase_duration_seconds_bucket[5m])))",
+			"histogram_quantile(0.99, sum by (le, phase) (rate(ns_session_phase_duration_seconds_bucket[5m])))",
+		}},
+	} {
+		var d Definition
+		for _, def := range Definitions {
+			if def.Name == tt.name {
+				d = def
+			}
+		}
+		var got []string
+		for _, target := range d.panelTargets("ns") {
+			got = append(got, target.Expr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
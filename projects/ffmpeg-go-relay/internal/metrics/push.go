@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Push sends a snapshot of everything in g to the Pushgateway at url, grouped
+// by job and the local hostname. It replaces the previous snapshot for that
+// group, so repeated pushes from one instance do not accumulate.
+func Push(ctx context.Context, url, job string, g prometheus.Gatherer) error {
+	if job == "" {
+		job = DefaultNamespace
+	}
+	p := push.New(url, job).Gatherer(g)
+	if host, err := os.Hostname(); err == nil {
+		p = p.Grouping("instance", host)
+	}
+	if err := p.PushContext(ctx); err != nil {
+		return fmt.Errorf("metrics: push: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushSendsSnapshot(t *testing.T) {
+	var method, path, body string
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gw.Close()
+
+	reg := prometheus.NewRegistry()
+	r, err := NewRegistry(reg, "")
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	r.RecordSessionEnd("shutdown", 0)
+
+	if err := Push(context.Background(), gw.URL, "", reg); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if method != http.MethodPut {
+		t.Fatalf("method = %s, want PUT", method)
+	}
+	if !strings.HasPrefix(path, "/metrics/job/rtmp_relay") {
+		t.Fatalf("path = %s, want /metrics/job/rtmp_relay...", path)
+	}
+	if !strings.Contains(body, "rtmp_relay_session") {
+		t.Fatalf("pushed body is missing session metrics")
+	}
+}
+
+func TestPushReportsGatewayErrors(t *testing.T) {
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer gw.Close()
+
+	if err := Push(context.Background(), gw.URL, "relay", prometheus.NewRegistry()); err == nil {
+		t.Fatal("expected an error from a failing gateway")
+	}
+}
@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdPacketSize keeps datagrams under a typical 1500 byte MTU.
+const statsdPacketSize = 1432
+
+// StatsDOptions configures a StatsD emitter.
+type StatsDOptions struct {
+	Address   string            // UDP host:port of the agent
+	DogStatsD bool              // Send labels as DogStatsD tags instead of name segments
+	Prefix    string            // Prepended to every metric name with a dot
+	Tags      map[string]string // DogStatsD tags added to every metric
+	Interval  time.Duration     // 0 = 10s
+}
+
+// StatsD sends the metrics in a Prometheus gatherer to a StatsD or DogStatsD
+// agent, for deployments that have no Prometheus to scrape /metrics.
+// Counters are sent as the increase since the previous flush, gauges as
+// their current value, and histograms as one sampled timing per non-empty
+// bucket, valued at the bucket's upper bound, so the agent's percentiles are
+// accurate to the bucket layout. Summaries are not sent.
+//
+// A nil StatsD does nothing.
+type StatsD struct {
+	g    prometheus.Gatherer
+	opts StatsDOptions
+	conn net.Conn
+	tags string // Rendered constant tags, DogStatsD only
+
+	mu   sync.Mutex
+	last map[string][]float64 // Counter value or cumulative histogram buckets at the last flush
+}
+
+// NewStatsD dials the agent at opts.Address. It returns nil when no address
+// is configured.
+func NewStatsD(g prometheus.Gatherer, opts StatsDOptions) (*StatsD, error) {
+	if opts.Address == "" {
+		return nil, nil
+	}
+	if g == nil {
+		return nil, errors.New("metrics: nil gatherer")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: statsd: %w", err)
+	}
+	s := &StatsD{g: g, opts: opts, conn: conn, last: make(map[string][]float64)}
+	if opts.DogStatsD && len(opts.Tags) > 0 {
+		keys := make([]string, 0, len(opts.Tags))
+		for k := range opts.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		tags := make([]string, len(keys))
+		for i, k := range keys {
+			tags[i] = sanitizeTag(k) + ":" + sanitizeTag(opts.Tags[k])
+		}
+		s.tags = strings.Join(tags, ",")
+	}
+	return s, nil
+}
+
+// Run flushes every interval until ctx is done. The last flush is left to
+// Close, so it can follow the drain of the remaining sessions.
+func (s *StatsD) Run(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Flush gathers and sends every metric once. Send errors are returned but
+// do not stop the flush: UDP drops are expected and the next flush carries
+// the counter increases that were lost.
+func (s *StatsD) Flush() error {
+	if s == nil {
+		return nil
+	}
+	families, err := s.g.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("metrics: statsd: gather: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			lines = s.appendLines(lines, mf, m)
+		}
+	}
+	return s.send(lines)
+}
+
+// Close sends a final flush and closes the connection.
+func (s *StatsD) Close() error {
+	if s == nil {
+		return nil
+	}
+	return errors.Join(s.Flush(), s.conn.Close())
+}
+
+func (s *StatsD) appendLines(lines []string, mf *dto.MetricFamily, m *dto.Metric) []string {
+	name, tags := s.name(mf.GetName(), m.GetLabel())
+	key := mf.GetName() + "{" + labelKey(m.GetLabel()) + "}"
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		v := m.GetCounter().GetValue()
+		delta := v
+		if prev, ok := s.last[key]; ok && v >= prev[0] {
+			delta = v - prev[0]
+		}
+		s.last[key] = []float64{v}
+		if delta > 0 {
+			lines = append(lines, s.line(name, formatFloat(delta), "c", "", tags))
+		}
+	case dto.MetricType_GAUGE:
+		lines = append(lines, s.line(name, formatFloat(m.GetGauge().GetValue()), "g", "", tags))
+	case dto.MetricType_UNTYPED:
+		lines = append(lines, s.line(name, formatFloat(m.GetUntyped().GetValue()), "g", "", tags))
+	case dto.MetricType_HISTOGRAM:
+		lines = s.appendHistogram(lines, name, tags, key, m.GetHistogram())
+	}
+	return lines
+}
+
+// appendHistogram turns the observations made since the last flush into
+// sampled timings: a bucket that gained n observations is sent once with a
+// sample rate of 1/n, which the agent counts as n samples.
+func (s *StatsD) appendHistogram(lines []string, name, tags, key string, h *dto.Histogram) []string {
+	buckets := h.GetBucket()
+	cum := make([]float64, len(buckets)+1)
+	for i, b := range buckets {
+		cum[i] = float64(b.GetCumulativeCount())
+	}
+	cum[len(buckets)] = float64(h.GetSampleCount()) // +Inf
+	prev, ok := s.last[key]
+	if !ok || len(prev) != len(cum) || cum[len(buckets)] < prev[len(buckets)] {
+		prev = make([]float64, len(cum))
+	}
+	s.last[key] = cum
+
+	unit, scale := "h", 1.0
+	if !s.opts.DogStatsD {
+		// Plain StatsD timers are in milliseconds.
+		unit = "ms"
+		if strings.HasSuffix(name, "_seconds") {
+			scale = 1000
+		}
+	}
+	var below float64
+	for i := range cum {
+		gained := (cum[i] - prev[i]) - below
+		below = cum[i] - prev[i]
+		if gained <= 0 {
+			continue
+		}
+		var bound float64
+		switch {
+		case i < len(buckets):
+			bound = buckets[i].GetUpperBound()
+		case len(buckets) > 0:
+			bound = buckets[len(buckets)-1].GetUpperBound() // Overflow is reported at the last bound
+		default:
+			bound = h.GetSampleSum() / float64(h.GetSampleCount())
+		}
+		rate := ""
+		if gained > 1 {
+			rate = "|@" + strconv.FormatFloat(1/gained, 'g', 6, 64)
+		}
+		lines = append(lines, s.line(name, formatFloat(bound*scale), unit, rate, tags))
+	}
+	return lines
+}
+
+// name returns the StatsD name and DogStatsD tags for a metric. Plain StatsD
+// has no tags, so label values are appended to the name as segments.
+func (s *StatsD) name(metric string, labels []*dto.LabelPair) (string, string) {
+	var b strings.Builder
+	if s.opts.Prefix != "" {
+		b.WriteString(s.opts.Prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(metric)
+	if !s.opts.DogStatsD {
+		for _, lp := range labels {
+			b.WriteByte('.')
+			b.WriteString(sanitizeName(lp.GetValue()))
+		}
+		return b.String(), ""
+	}
+	tags := make([]string, 0, len(labels)+1)
+	if s.tags != "" {
+		tags = append(tags, s.tags)
+	}
+	for _, lp := range labels {
+		tags = append(tags, sanitizeTag(lp.GetName())+":"+sanitizeTag(lp.GetValue()))
+	}
+	return b.String(), strings.Join(tags, ",")
+}
+
+func (s *StatsD) line(name, value, unit, rate, tags string) string {
+	l := name + ":" + value + "|" + unit + rate
+	if tags != "" {
+		l += "|#" + tags
+	}
+	return l
+}
+
+// send packs lines into as few datagrams as fit the packet size.
+func (s *StatsD) send(lines []string) error {
+	var errs []error
+	var packet strings.Builder
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write([]byte(packet.String())); err != nil {
+			errs = append(errs, err)
+		}
+		packet.Reset()
+	}
+	for _, l := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(l) > statsdPacketSize {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(l)
+	}
+	flush()
+	if len(errs) > 0 {
+		return fmt.Errorf("metrics: statsd: send: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func labelKey(labels []*dto.LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, lp := range labels {
+		parts[i] = lp.GetName() + "=" + strconv.Quote(lp.GetValue())
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// sanitizeName keeps a label value usable as a dotted name segment.
+func sanitizeName(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// sanitizeTag strips the characters DogStatsD uses as separators.
+func sanitizeTag(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ':', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func listenStatsD(t *testing.T) *net.UDPConn {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// readLines collects the lines of every datagram that arrives within a
+// short window.
+func readLines(t *testing.T, pc *net.UDPConn) map[string]bool {
+	t.Helper()
+	lines := make(map[string]bool)
+	buf := make([]byte, 65536)
+	for {
+		pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := pc.Read(buf)
+		if err != nil {
+			return lines
+		}
+		for _, l := range strings.Split(string(buf[:n]), "\n") {
+			lines[l] = true
+		}
+	}
+}
+
+func TestStatsDSendsCounterIncreases(t *testing.T) {
+	pc := listenStatsD(t)
+	reg := prometheus.NewRegistry()
+	r, err := NewRegistry(reg, "")
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	s, err := NewStatsD(reg, StatsDOptions{Address: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("NewStatsD: %v", err)
+	}
+	defer s.Close()
+
+	r.RecordConnectionStart()
+	r.RecordConnectionStart()
+	r.ObserveLatency(30 * time.Millisecond)
+	r.ObserveLatency(30 * time.Millisecond)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	lines := readLines(t, pc)
+	for _, want := range []string{
+		"rtmp_relay_connections_total.started:2|c",
+		"rtmp_relay_active_connections:2|g",
+		"rtmp_relay_latency_seconds:50|ms|@0.5",
+	} {
+		if !lines[want] {
+			t.Errorf("missing %q in %v", want, lines)
+		}
+	}
+
+	r.RecordConnectionStart()
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	lines = readLines(t, pc)
+	if !lines["rtmp_relay_connections_total.started:1|c"] {
+		t.Errorf("second flush should send only the increase, got %v", lines)
+	}
+	for l := range lines {
+		if strings.HasPrefix(l, "rtmp_relay_latency_seconds:") {
+			t.Errorf("histogram without new observations was sent: %q", l)
+		}
+	}
+}
+
+func TestStatsDDogStatsDTags(t *testing.T) {
+	pc := listenStatsD(t)
+	reg := prometheus.NewRegistry()
+	r, err := NewRegistry(reg, "")
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	s, err := NewStatsD(reg, StatsDOptions{
+		Address:   pc.LocalAddr().String(),
+		DogStatsD: true,
+		Prefix:    "edge",
+		Tags:      map[string]string{"env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("NewStatsD: %v", err)
+	}
+	defer s.Close()
+
+	r.RecordSessionEnd("client_closed", 3*time.Second)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	lines := readLines(t, pc)
+	for _, want := range []string{
+		"edge.rtmp_relay_session_completions_total:1|c|#env:prod,reason:client_closed",
+		"edge.rtmp_relay_session_duration_by_reason_seconds:4|h|#env:prod,reason:client_closed",
+	} {
+		if !lines[want] {
+			t.Errorf("missing %q in %v", want, lines)
+		}
+	}
+}
+
+func TestNewStatsDDisabled(t *testing.T) {
+	s, err := NewStatsD(prometheus.NewRegistry(), StatsDOptions{})
+	if err != nil || s != nil {
+		t.Fatalf("NewStatsD without an address = %v, %v; want nil, nil", s, err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("nil Flush: %v", err)
+	}
+}
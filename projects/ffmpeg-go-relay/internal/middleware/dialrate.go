@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultDialMaxWait is how long a dial may queue when no maximum is
+// configured.
+const DefaultDialMaxWait = 10 * time.Second
+
+// ErrDialQueueFull is returned by DialLimiter.Wait when a dial would have to
+// queue longer than the limiter's maximum wait.
+var ErrDialQueueFull = errors.New("upstream dial rate limit exceeded")
+
+// DialLimiter paces new connection attempts to one upstream. Attempts over
+// the rate queue for their turn, and each queued attempt waits a random
+// extra fraction of its delay, so a reconnect storm after an upstream
+// restart reaches it spread out instead of in lockstep. One limiter is
+// shared by every session dialing that upstream.
+type DialLimiter struct {
+	limiter *rate.Limiter
+	perSec  float64
+	burst   int
+	maxWait time.Duration
+	jitter  float64
+	waiting atomic.Int64
+
+	mu  sync.Mutex // Guards rng
+	rng *rand.Rand
+}
+
+// NewDialLimiter allows perSec dials per second with bursts of burst (0 is
+// one second's worth). A dial queues at most maxWait (0 uses
+// DefaultDialMaxWait) and waits an extra random 0 to jitter of its delay.
+// Returns nil (no pacing) when perSec <= 0.
+func NewDialLimiter(perSec float64, burst int, maxWait time.Duration, jitter float64) *DialLimiter {
+	if perSec <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(perSec)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultDialMaxWait
+	}
+	return &DialLimiter{
+		limiter: rate.NewLimiter(rate.Limit(perSec), burst),
+		perSec:  perSec,
+		burst:   burst,
+		maxWait: maxWait,
+		jitter:  jitter,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Wait blocks until the caller may dial and returns how long it waited. It
+// fails with ErrDialQueueFull, without waiting, when the queue ahead is
+// longer than the maximum wait, and with ctx's error if ctx ends first.
+func (d *DialLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	if d == nil {
+		return 0, nil
+	}
+	r := d.limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return 0, nil
+	}
+	if delay > d.maxWait {
+		r.Cancel()
+		return 0, fmt.Errorf("%w: %d dials queued", ErrDialQueueFull, d.waiting.Load())
+	}
+	if d.jitter > 0 {
+		d.mu.Lock()
+		delay += time.Duration(d.rng.Float64() * d.jitter * float64(delay))
+		d.mu.Unlock()
+	}
+
+	d.waiting.Add(1)
+	defer d.waiting.Add(-1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		r.Cancel()
+		return 0, ctx.Err()
+	}
+}
+
+// Stats returns the pacing configuration and the dials queued now.
+func (d *DialLimiter) Stats() map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"dials_per_sec":    d.perSec,
+		"burst":            d.burst,
+		"max_wait_ms":      d.maxWait.Milliseconds(),
+		"tokens_available": d.limiter.Tokens(),
+		"queued":           d.waiting.Load(),
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewDialLimiterDisabled(t *testing.T) {
+	d := NewDialLimiter(0, 5, time.Second, 0)
+	if d != nil {
+		t.Fatal("expected nil limiter for zero rate")
+	}
+	if waited, err := d.Wait(context.Background()); waited != 0 || err != nil {
+		t.Fatalf("nil limiter Wait = %v, %v", waited, err)
+	}
+}
+
+func TestDialLimiterQueuesOverBurst(t *testing.T) {
+	// 20 dials/s with a burst of 2: the third dial waits ~50ms.
+	d := NewDialLimiter(20, 2, time.Second, 0)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if waited, err := d.Wait(ctx); waited != 0 || err != nil {
+			t.Fatalf("dial %d in burst: waited %v, err %v", i, waited, err)
+		}
+	}
+	start := time.Now()
+	waited, err := d.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited < 30*time.Millisecond || time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("third dial waited %v, want about 50ms", waited)
+	}
+}
+
+func TestDialLimiterRejectsPastMaxWait(t *testing.T) {
+	// One dial per second queues the second for a second, past max_wait.
+	d := NewDialLimiter(1, 1, 100*time.Millisecond, 0)
+	ctx := context.Background()
+	if _, err := d.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := d.Wait(ctx); !errors.Is(err, ErrDialQueueFull) {
+		t.Fatalf("err = %v, want ErrDialQueueFull", err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatal("rejected dial waited")
+	}
+}
+
+func TestDialLimiterJitterAndCancel(t *testing.T) {
+	d := NewDialLimiter(10, 1, time.Second, 1)
+	if _, err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waited, err := d.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ~100ms turn plus up to as much again of jitter.
+	if waited < 50*time.Millisecond || waited > 250*time.Millisecond {
+		t.Fatalf("jittered wait = %v", waited)
+	}
+
+	// A dial whose session gives up leaves the queue.
+	d = NewDialLimiter(1, 1, time.Second, 0)
+	if _, err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if got := d.Stats()["queued"]; got != int64(0) {
+		t.Fatalf("queued = %v after the wait ended", got)
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PublishLimiter caps how many distinct stream names one client IP, and one
+// auth token, may publish at the same time. Sessions publishing a name the
+// IP or token already has live do not count again, so a reconnecting
+// encoder that briefly overlaps its old session is not refused.
+type PublishLimiter struct {
+	mu          sync.Mutex
+	maxPerIP    int
+	maxPerToken int
+	byIP        map[string]map[string]int // IP -> stream -> sessions publishing it
+	byToken     map[string]map[string]int
+}
+
+// NewPublishLimiter returns a limiter, or nil when both limits are 0
+// (unlimited). A nil limiter admits everything.
+func NewPublishLimiter(maxPerIP, maxPerToken int) *PublishLimiter {
+	if maxPerIP <= 0 && maxPerToken <= 0 {
+		return nil
+	}
+	return &PublishLimiter{
+		maxPerIP:    maxPerIP,
+		maxPerToken: maxPerToken,
+		byIP:        make(map[string]map[string]int),
+		byToken:     make(map[string]map[string]int),
+	}
+}
+
+// Publish limit scopes, as returned by Acquire.
+const (
+	PublishLimitIP    = "ip"
+	PublishLimitToken = "token"
+)
+
+// Acquire admits a publish of stream from ip with token, which is empty for
+// clients that did not authenticate. A refusal names the limit that was hit,
+// PublishLimitIP or PublishLimitToken. Every successful Acquire must be
+// matched by a Release with the same arguments.
+func (l *PublishLimiter) Acquire(ip, token, stream string) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxPerIP > 0 && streamsExceed(l.byIP[ip], stream, l.maxPerIP) {
+		return PublishLimitIP, fmt.Errorf("per-IP stream limit exceeded for %s (%d)", ip, l.maxPerIP)
+	}
+	if token != "" && l.maxPerToken > 0 && streamsExceed(l.byToken[token], stream, l.maxPerToken) {
+		return PublishLimitToken, fmt.Errorf("per-token stream limit exceeded (%d)", l.maxPerToken)
+	}
+	addStream(l.byIP, ip, stream)
+	if token != "" {
+		addStream(l.byToken, token, stream)
+	}
+	return "", nil
+}
+
+// Release ends a publish admitted by Acquire.
+func (l *PublishLimiter) Release(ip, token, stream string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	removeStream(l.byIP, ip, stream)
+	if token != "" {
+		removeStream(l.byToken, token, stream)
+	}
+}
+
+// Stats returns the limits and how many IPs and tokens are publishing.
+func (l *PublishLimiter) Stats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return map[string]interface{}{
+		"max_streams_per_ip":    l.maxPerIP,
+		"max_streams_per_token": l.maxPerToken,
+		"publishing_ips":        len(l.byIP),
+		"publishing_tokens":     len(l.byToken),
+	}
+}
+
+// streamsExceed reports whether publishing stream would take streams past max.
+func streamsExceed(streams map[string]int, stream string, max int) bool {
+	if streams[stream] > 0 {
+		return false
+	}
+	return len(streams) >= max
+}
+
+func addStream(m map[string]map[string]int, key, stream string) {
+	streams := m[key]
+	if streams == nil {
+		streams = make(map[string]int)
+		m[key] = streams
+	}
+	streams[stream]++
+}
+
+func removeStream(m map[string]map[string]int, key, stream string) {
+	streams := m[key]
+	if streams[stream] <= 1 {
+		delete(streams, stream)
+	} else {
+		streams[stream]--
+	}
+	if len(streams) == 0 {
+		delete(m, key)
+	}
+}
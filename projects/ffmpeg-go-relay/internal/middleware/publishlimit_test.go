@@ -0,0 +1,63 @@
+package middleware
+
+import "testing"
+
+func TestPublishLimiterDisabled(t *testing.T) {
+	l := NewPublishLimiter(0, 0)
+	if l != nil {
+		t.Fatal("expected nil limiter without limits")
+	}
+	if _, err := l.Acquire("10.0.0.1", "tok", "cam1"); err != nil {
+		t.Fatalf("nil limiter refused a publish: %v", err)
+	}
+	l.Release("10.0.0.1", "tok", "cam1")
+}
+
+func TestPublishLimiterPerIP(t *testing.T) {
+	l := NewPublishLimiter(2, 0)
+	for _, stream := range []string{"cam1", "cam2"} {
+		if _, err := l.Acquire("10.0.0.1", "", stream); err != nil {
+			t.Fatalf("Acquire(%s): %v", stream, err)
+		}
+	}
+	// A stream already live from the IP does not count again.
+	if _, err := l.Acquire("10.0.0.1", "", "cam1"); err != nil {
+		t.Fatalf("re-publish of a live stream refused: %v", err)
+	}
+	if scope, err := l.Acquire("10.0.0.1", "", "cam3"); err == nil || scope != PublishLimitIP {
+		t.Fatalf("third stream: scope %q, err %v; want ip limit", scope, err)
+	}
+	if _, err := l.Acquire("10.0.0.2", "", "cam3"); err != nil {
+		t.Fatalf("other IP refused: %v", err)
+	}
+
+	// cam1 stays live until both of its sessions end.
+	l.Release("10.0.0.1", "", "cam1")
+	if _, err := l.Acquire("10.0.0.1", "", "cam3"); err == nil {
+		t.Fatal("expected cam1's remaining session to keep the limit reached")
+	}
+	l.Release("10.0.0.1", "", "cam1")
+	if _, err := l.Acquire("10.0.0.1", "", "cam3"); err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+}
+
+func TestPublishLimiterPerToken(t *testing.T) {
+	l := NewPublishLimiter(0, 1)
+	if _, err := l.Acquire("10.0.0.1", "tok", "cam1"); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if scope, err := l.Acquire("10.0.0.2", "tok", "cam2"); err == nil || scope != PublishLimitToken {
+		t.Fatalf("second stream of token: scope %q, err %v; want token limit", scope, err)
+	}
+	// Unauthenticated publishes are not held to the token limit.
+	for _, stream := range []string{"cam2", "cam3"} {
+		if _, err := l.Acquire("10.0.0.2", "", stream); err != nil {
+			t.Fatalf("Acquire without token: %v", err)
+		}
+	}
+	l.Release("10.0.0.1", "tok", "cam1")
+	if got := l.Stats()["publishing_tokens"]; got != 0 {
+		t.Fatalf("publishing_tokens = %v after release, want 0", got)
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/time/rate"
+)
+
+// Shaper applies token bucket shaping to bytes written through wrapped connections.
+// A single Shaper is shared by every connection it wraps, so it models one
+// constrained link rather than a per-connection cap.
+type Shaper struct {
+	limiter     *rate.Limiter
+	bytesPerSec float64
+	burst       int
+}
+
+// NewShaper creates a shaper allowing bytesPerSec sustained throughput with the
+// given burst in bytes. Returns nil (no shaping) when bytesPerSec <= 0.
+func NewShaper(bytesPerSec float64, burst int) *Shaper {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(bytesPerSec) // Default to one second worth of data
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Shaper{
+		limiter:     rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+		bytesPerSec: bytesPerSec,
+		burst:       burst,
+	}
+}
+
+// Wrap returns conn with its writes shaped. Waits are abandoned when ctx is done.
+func (s *Shaper) Wrap(ctx context.Context, conn net.Conn) net.Conn {
+	if s == nil || conn == nil {
+		return conn
+	}
+	return &shapedConn{Conn: conn, ctx: ctx, shaper: s}
+}
+
+// Stats returns shaping configuration and current bucket level.
+func (s *Shaper) Stats() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"bytes_per_sec":    s.bytesPerSec,
+		"burst_bytes":      s.burst,
+		"tokens_available": s.limiter.Tokens(),
+	}
+}
+
+type shapedConn struct {
+	net.Conn
+	ctx    context.Context
+	shaper *Shaper
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > c.shaper.burst {
+			n = c.shaper.burst
+		}
+		if err := c.shaper.limiter.WaitN(c.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := c.Conn.Write(p[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewShaperDisabled(t *testing.T) {
+	if s := NewShaper(0, 100); s != nil {
+		t.Fatal("expected nil shaper for zero rate")
+	}
+
+	var s *Shaper
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if got := s.Wrap(context.Background(), client); got != client {
+		t.Fatal("nil shaper should return the original conn")
+	}
+}
+
+func TestShaperLimitsThroughput(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	// 1000 B/s with a 100 byte bucket: 300 bytes needs ~200ms after the burst.
+	s := NewShaper(1000, 100)
+	conn := s.Wrap(context.Background(), client)
+
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 300))
+	if err != nil || n != 300 {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("write completed in %v, expected shaping delay", elapsed)
+	}
+}
+
+func TestShaperHonorsContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewShaper(10, 10)
+	conn := s.Wrap(ctx, client)
+
+	if _, err := conn.Write(make([]byte, 10)); err != nil {
+		t.Fatalf("initial burst write: %v", err)
+	}
+	cancel()
+	if _, err := conn.Write(make([]byte, 10)); err == nil {
+		t.Fatal("expected error after context cancellation")
+	}
+}
@@ -0,0 +1,200 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errShortConfig = errors.New("packager: decoder configuration is cut short")
+
+// avcConfig reads an AVCDecoderConfigurationRecord: the RFC 6381 codec
+// string and the picture size coded in its first SPS.
+func avcConfig(rec []byte) (codec string, width, height int, err error) {
+	if len(rec) < 8 || rec[5]&0x1f == 0 {
+		return "", 0, 0, errShortConfig
+	}
+	codec = fmt.Sprintf("avc1.%02x%02x%02x", rec[1], rec[2], rec[3])
+	n := int(rec[6])<<8 | int(rec[7])
+	if len(rec) < 8+n {
+		return "", 0, 0, errShortConfig
+	}
+	width, height, err = spsSize(rec[8 : 8+n])
+	return codec, width, height, err
+}
+
+// bitReader reads the fixed and Exp-Golomb coded fields of H.264 syntax.
+type bitReader struct {
+	b   []byte
+	pos int // In bits
+}
+
+func (r *bitReader) bit() uint {
+	if r.pos >= len(r.b)*8 {
+		r.pos++
+		return 0
+	}
+	v := uint(r.b[r.pos/8]>>(7-r.pos%8)) & 1
+	r.pos++
+	return v
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for range n {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bit() == 0 && zeros < 32 {
+		zeros++
+	}
+	return (1<<zeros - 1) + r.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb code.
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v&1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) overrun() bool { return r.pos > len(r.b)*8 }
+
+// spsSize returns the cropped picture size a sequence parameter set codes.
+func spsSize(nal []byte) (int, int, error) {
+	// Drop the emulation prevention bytes after each 0x0000.
+	rbsp := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal[min(1, len(nal)):] {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	r := &bitReader{b: rbsp}
+	profile := r.bits(8)
+	r.bits(16) // Constraint flags, level
+	r.ue()     // seq_parameter_set_id
+	chroma := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chroma = r.ue()
+		if chroma == 3 && r.bit() == 1 {
+			chroma = 0 // Colour planes coded separately, as monochrome
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			lists := 8
+			if chroma == 3 {
+				lists = 12
+			}
+			for i := range lists {
+				if r.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for range size {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit()
+		r.se()
+		r.se()
+		cycle := r.ue()
+		if cycle > 255 {
+			return 0, 0, fmt.Errorf("packager: SPS has %d reference frames in its POC cycle", cycle)
+		}
+		for range cycle {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	mbWidth := int(r.ue()) + 1
+	mapHeight := int(r.ue()) + 1
+	frameMBsOnly := int(r.bit())
+	if frameMBsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+	var left, right, top, bottom int
+	if r.bit() == 1 {
+		left, right, top, bottom = int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+	}
+	if r.overrun() {
+		return 0, 0, errShortConfig
+	}
+	cropX, cropY := 1, 2-frameMBsOnly
+	switch chroma {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMBsOnly)
+	case 2:
+		cropX = 2
+	}
+	width := mbWidth*16 - cropX*(left+right)
+	height := (2-frameMBsOnly)*mapHeight*16 - cropY*(top+bottom)
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("packager: SPS codes a %dx%d picture", width, height)
+	}
+	return width, height, nil
+}
+
+// aacSampleRates are the sampling frequencies an AudioSpecificConfig
+// indexes.
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacConfig reads an AudioSpecificConfig: the RFC 6381 codec string, the
+// sample rate and the channel count.
+func aacConfig(asc []byte) (codec string, rate, channels int, err error) {
+	if len(asc) < 2 {
+		return "", 0, 0, errShortConfig
+	}
+	r := &bitReader{b: asc}
+	object := r.bits(5)
+	if object == 31 {
+		object = 32 + r.bits(6)
+	}
+	if index := r.bits(4); index == 15 {
+		rate = int(r.bits(24))
+	} else if int(index) < len(aacSampleRates) {
+		rate = aacSampleRates[index]
+	}
+	channels = int(r.bits(4))
+	if r.overrun() || rate == 0 {
+		return "", 0, 0, errShortConfig
+	}
+	if channels == 0 {
+		channels = 2 // Given by a program config element instead
+	}
+	return fmt.Sprintf("mp4a.40.%d", object), rate, channels, nil
+}
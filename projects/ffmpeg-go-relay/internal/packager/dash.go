@@ -0,0 +1,133 @@
+package packager
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+type mpd struct {
+	XMLName                    xml.Name  `xml:"MPD"`
+	Xmlns                      string    `xml:"xmlns,attr"`
+	Profiles                   string    `xml:"profiles,attr"`
+	Type                       string    `xml:"type,attr"`
+	AvailabilityStartTime      string    `xml:"availabilityStartTime,attr"`
+	PublishTime                string    `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string    `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              string    `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string    `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string    `xml:"suggestedPresentationDelay,attr"`
+	Period                     mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int               `xml:"id,attr"`
+	ContentType      string            `xml:"contentType,attr"`
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	StartWithSAP     int               `xml:"startWithSAP,attr"`
+	Representation   mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                        string         `xml:"id,attr"`
+	Codecs                    string         `xml:"codecs,attr"`
+	Bandwidth                 int            `xml:"bandwidth,attr"`
+	Width                     int            `xml:"width,attr,omitempty"`
+	Height                    int            `xml:"height,attr,omitempty"`
+	AudioSamplingRate         int            `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *mpdDescriptor `xml:"AudioChannelConfiguration,omitempty"`
+	SegmentTemplate           mpdTemplate    `xml:"SegmentTemplate"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdTemplate struct {
+	Timescale              int      `xml:"timescale,attr"`
+	PresentationTimeOffset uint64   `xml:"presentationTimeOffset,attr"`
+	Initialization         string   `xml:"initialization,attr"`
+	Media                  string   `xml:"media,attr"`
+	StartNumber            uint64   `xml:"startNumber,attr"`
+	Timeline               []mpdSeg `xml:"SegmentTimeline>S"`
+}
+
+type mpdSeg struct {
+	T uint64 `xml:"t,attr"`
+	D uint64 `xml:"d,attr"`
+}
+
+// DASHManifest returns a dynamic MPD listing the complete segments of every
+// track. The period starts when the first sample arrived, and a new one
+// starts whenever the codecs change. query is appended to every URL.
+func (s *Stream) DASHManifest(query string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tracks == nil || len(s.segments) < 2 {
+		return nil, false
+	}
+	complete := s.segments[:len(s.segments)-1]
+	var timeline []mpdSeg
+	var depth uint64
+	for _, seg := range complete {
+		timeline = append(timeline, mpdSeg{T: seg.start, D: seg.duration})
+		depth += seg.duration
+	}
+	segment := s.opts.segment.Seconds()
+	m := mpd{
+		Xmlns:                      "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:                   "urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019",
+		Type:                       "dynamic",
+		AvailabilityStartTime:      s.started.UTC().Format(time.RFC3339Nano),
+		PublishTime:                time.Now().UTC().Format(time.RFC3339Nano),
+		MinimumUpdatePeriod:        isoDuration(segment),
+		MinBufferTime:              isoDuration(segment),
+		TimeShiftBufferDepth:       isoDuration(seconds(depth)),
+		SuggestedPresentationDelay: isoDuration(3 * segment),
+		Period:                     mpdPeriod{ID: fmt.Sprint(s.epoch), Start: "PT0S"},
+	}
+	for i, t := range s.tracks {
+		rep := mpdRepresentation{
+			ID:        t.name,
+			Codecs:    t.codec,
+			Bandwidth: max(s.bandwidth(i), 1),
+			SegmentTemplate: mpdTemplate{
+				Timescale:              timescale,
+				PresentationTimeOffset: s.firstDTS,
+				Initialization:         fmt.Sprintf("../cmaf/%s/init.mp4%s", t.name, query),
+				Media:                  fmt.Sprintf("../cmaf/%s/$Number$.m4s%s", t.name, query),
+				StartNumber:            complete[0].seq,
+				Timeline:               timeline,
+			},
+		}
+		as := mpdAdaptationSet{ID: int(t.id), SegmentAlignment: true, StartWithSAP: 1}
+		if t.kind == kindVideo {
+			as.ContentType, as.MimeType = "video", "video/mp4"
+			rep.Width, rep.Height = t.width, t.height
+		} else {
+			as.ContentType, as.MimeType = "audio", "audio/mp4"
+			rep.AudioSamplingRate = t.sampleRate
+			rep.AudioChannelConfiguration = &mpdDescriptor{
+				SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+				Value:       fmt.Sprint(t.channels),
+			}
+		}
+		as.Representation = rep
+		m.Period.AdaptationSets = append(m.Period.AdaptationSets, as)
+	}
+	out, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, false
+	}
+	return append([]byte(xml.Header), out...), true
+}
+
+func isoDuration(seconds float64) string { return fmt.Sprintf("PT%.3fS", seconds) }
@@ -0,0 +1,131 @@
+package packager
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// recentParts is how many of the newest segments an LL-HLS playlist lists
+// the parts of, besides the open one.
+const recentParts = 2
+
+// HLSMaster returns the multivariant playlist of the stream: its video
+// playlist, with the audio one as an alternate rendition group. query, such
+// as "?token=...", is appended to every URI.
+func (s *Stream) HLSMaster(query string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tracks == nil {
+		return nil, false
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", s.hlsVersion())
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	v := s.tracks[0]
+	codecs, bandwidth, audio := v.codec, s.bandwidth(0), ""
+	if len(s.tracks) > 1 {
+		a := s.tracks[1]
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio\",DEFAULT=YES,AUTOSELECT=YES,CHANNELS=\"%d\",URI=\"%s.m3u8%s\"\n", a.channels, a.name, query)
+		codecs += "," + a.codec
+		bandwidth += s.bandwidth(1)
+		audio = ",AUDIO=\"audio\""
+	}
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\",RESOLUTION=%dx%d%s\n", max(bandwidth, 1), codecs, v.width, v.height, audio)
+	fmt.Fprintf(&b, "%s.m3u8%s\n", v.name, query)
+	return []byte(b.String()), true
+}
+
+// HLSPlaylist returns the media playlist of the named track. When parts are
+// cut, it lists those of the newest segments and hints at the next one.
+// query is appended to every URI.
+func (s *Stream) HLSPlaylist(track, query string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.trackIndex(track)
+	if i < 0 {
+		return nil, false
+	}
+	name := s.tracks[i].name
+	complete := s.segments[:max(len(s.segments)-1, 0)]
+	target := s.opts.segment.Seconds()
+	for _, seg := range complete {
+		target = max(target, seconds(seg.duration))
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", s.hlsVersion())
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	if len(s.segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", s.segments[0].seq)
+	}
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.epoch)
+	parts := s.opts.part > 0
+	if parts {
+		partTarget := s.partTarget()
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	} else {
+		b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"../cmaf/%s/init.mp4%s\"\n", name, query)
+	for j, seg := range s.segments {
+		if parts && j >= len(s.segments)-1-recentParts {
+			for k, p := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"../cmaf/%s/%d.%d.m4s%s\"", seconds(p.duration), name, seg.seq, k, query)
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if !seg.complete {
+			if parts {
+				fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"../cmaf/%s/%d.%d.m4s%s\"\n", name, seg.seq, len(seg.parts), query)
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n../cmaf/%s/%d.m4s%s\n", seconds(seg.duration), name, seg.seq, query)
+	}
+	return []byte(b.String()), true
+}
+
+// hlsVersion is 9 for playlists with parts, and 7 otherwise.
+func (s *Stream) hlsVersion() int {
+	if s.opts.part > 0 {
+		return 9
+	}
+	return 7
+}
+
+// partTarget is the configured part duration, or the longest part kept if
+// longer: parts end at the first frame past the configured duration.
+func (s *Stream) partTarget() float64 {
+	target := s.opts.part.Seconds()
+	for _, seg := range s.segments {
+		for _, p := range seg.parts {
+			target = max(target, seconds(p.duration))
+		}
+	}
+	return target
+}
+
+// bandwidth is the peak bitrate of track i over the complete segments kept,
+// in bits per second.
+func (s *Stream) bandwidth(i int) int {
+	peak := 0
+	for _, seg := range s.segments {
+		if !seg.complete || seg.duration == 0 {
+			continue
+		}
+		size := 0
+		for _, p := range seg.parts {
+			size += len(p.data[i])
+		}
+		peak = max(peak, int(uint64(size)*8*timescale/seg.duration))
+	}
+	return peak
+}
+
+func seconds(ms uint64) float64 { return float64(ms) / timescale }
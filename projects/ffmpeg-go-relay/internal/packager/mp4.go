@@ -0,0 +1,243 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// timescale is the clock of every track: RTMP timestamps are milliseconds.
+const timescale = 1000
+
+// Sample flags of the trun box: a sync sample depends on no other; any
+// other video sample depends on others and is not a sync sample.
+const (
+	flagsSync    = 0x02000000
+	flagsNonSync = 0x01010000
+)
+
+// boxWriter builds ISO BMFF boxes, patching each box's size once its
+// contents are written.
+type boxWriter struct {
+	bytes.Buffer
+}
+
+// box writes a box of type typ holding what fn writes.
+func (w *boxWriter) box(typ string, fn func()) {
+	start := w.Len()
+	w.Write([]byte{0, 0, 0, 0})
+	w.WriteString(typ)
+	fn()
+	binary.BigEndian.PutUint32(w.Bytes()[start:], uint32(w.Len()-start))
+}
+
+// fullBox writes a box with a version and flags.
+func (w *boxWriter) fullBox(typ string, version uint8, flags uint32, fn func()) {
+	w.box(typ, func() {
+		w.u32(uint32(version)<<24 | flags)
+		fn()
+	})
+}
+
+func (w *boxWriter) u8(v uint8)   { w.WriteByte(v) }
+func (w *boxWriter) u16(v uint16) { w.Write(binary.BigEndian.AppendUint16(nil, v)) }
+func (w *boxWriter) u32(v uint32) { w.Write(binary.BigEndian.AppendUint32(nil, v)) }
+func (w *boxWriter) u64(v uint64) { w.Write(binary.BigEndian.AppendUint64(nil, v)) }
+func (w *boxWriter) zeros(n int)  { w.Write(make([]byte, n)) }
+
+// matrix writes the identity transformation matrix.
+func (w *boxWriter) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// initSegment returns the CMAF header of t: ftyp and a moov describing it
+// as the only track, with no samples of its own.
+func initSegment(t *track) []byte {
+	var w boxWriter
+	w.box("ftyp", func() {
+		w.WriteString("iso6")
+		w.u32(0)
+		w.WriteString("iso6cmfcmp41")
+	})
+	w.box("moov", func() {
+		w.fullBox("mvhd", 0, 0, func() {
+			w.u32(0) // Creation time
+			w.u32(0) // Modification time
+			w.u32(timescale)
+			w.u32(0) // Duration: unknown, the stream is live
+			w.u32(0x00010000)
+			w.u16(0x0100)
+			w.zeros(10)
+			w.matrix()
+			w.zeros(24)
+			w.u32(t.id + 1) // Next track ID
+		})
+		w.box("trak", func() {
+			volume := uint16(0)
+			if t.kind == kindAudio {
+				volume = 0x0100
+			}
+			w.fullBox("tkhd", 0, 3, func() { // Enabled, in the movie
+				w.u32(0)
+				w.u32(0)
+				w.u32(t.id)
+				w.u32(0)
+				w.u32(0) // Duration
+				w.zeros(8)
+				w.u16(0) // Layer
+				w.u16(0) // Alternate group
+				w.u16(volume)
+				w.u16(0)
+				w.matrix()
+				w.u32(uint32(t.width) << 16)
+				w.u32(uint32(t.height) << 16)
+			})
+			w.box("mdia", func() {
+				w.fullBox("mdhd", 0, 0, func() {
+					w.u32(0)
+					w.u32(0)
+					w.u32(timescale)
+					w.u32(0)
+					w.u16(0x55c4) // "und"
+					w.u16(0)
+				})
+				handler, name := "vide", "VideoHandler"
+				if t.kind == kindAudio {
+					handler, name = "soun", "SoundHandler"
+				}
+				w.fullBox("hdlr", 0, 0, func() {
+					w.u32(0)
+					w.WriteString(handler)
+					w.zeros(12)
+					w.WriteString(name)
+					w.u8(0)
+				})
+				w.box("minf", func() {
+					if t.kind == kindAudio {
+						w.fullBox("smhd", 0, 0, func() { w.u32(0) })
+					} else {
+						w.fullBox("vmhd", 0, 1, func() { w.zeros(8) })
+					}
+					w.box("dinf", func() {
+						w.fullBox("dref", 0, 0, func() {
+							w.u32(1)
+							w.fullBox("url ", 0, 1, func() {}) // Samples are in this file
+						})
+					})
+					w.box("stbl", func() {
+						w.fullBox("stsd", 0, 0, func() {
+							w.u32(1)
+							t.sampleEntry(&w)
+						})
+						w.fullBox("stts", 0, 0, func() { w.u32(0) })
+						w.fullBox("stsc", 0, 0, func() { w.u32(0) })
+						w.fullBox("stsz", 0, 0, func() { w.zeros(8) })
+						w.fullBox("stco", 0, 0, func() { w.u32(0) })
+					})
+				})
+			})
+		})
+		w.box("mvex", func() {
+			w.fullBox("trex", 0, 0, func() {
+				w.u32(t.id)
+				w.u32(1) // Sample description index
+				w.u32(0)
+				w.u32(0)
+				w.u32(0)
+			})
+		})
+	})
+	return w.Bytes()
+}
+
+// sampleEntry writes the avc1 or mp4a sample entry of t.
+func (t *track) sampleEntry(w *boxWriter) {
+	if t.kind == kindVideo {
+		w.box("avc1", func() {
+			w.zeros(6)
+			w.u16(1) // Data reference index
+			w.zeros(16)
+			w.u16(uint16(t.width))
+			w.u16(uint16(t.height))
+			w.u32(0x00480000) // 72 dpi
+			w.u32(0x00480000)
+			w.u32(0)
+			w.u16(1) // Frame count
+			w.zeros(32)
+			w.u16(0x0018) // Depth
+			w.u16(0xffff)
+			w.box("avcC", func() { w.Write(t.config) })
+		})
+		return
+	}
+	w.box("mp4a", func() {
+		w.zeros(6)
+		w.u16(1)
+		w.zeros(8)
+		w.u16(uint16(t.channels))
+		w.u16(16) // Sample size
+		w.u32(0)
+		w.u32(uint32(t.sampleRate) << 16)
+		w.fullBox("esds", 0, 0, func() {
+			// ES_Descriptor holding the DecoderConfigDescriptor, which holds
+			// the AudioSpecificConfig, then the SLConfigDescriptor.
+			asc := len(t.config)
+			w.u8(0x03)
+			w.u8(uint8(3 + 2 + 13 + 2 + asc + 3))
+			w.u16(0) // ES_ID
+			w.u8(0)
+			w.u8(0x04)
+			w.u8(uint8(13 + 2 + asc))
+			w.u8(0x40) // MPEG-4 audio
+			w.u8(0x15) // Audio stream
+			w.zeros(3) // Buffer size
+			w.u32(0)   // Max bitrate
+			w.u32(0)   // Average bitrate
+			w.u8(0x05)
+			w.u8(uint8(asc))
+			w.Write(t.config)
+			w.u8(0x06)
+			w.u8(1)
+			w.u8(0x02)
+		})
+	})
+}
+
+// fragment returns a CMAF chunk of t: a moof and the mdat holding samples,
+// sequence numbered seq, decoding from base on.
+func fragment(t *track, seq uint32, base uint64, samples []sample) []byte {
+	var w boxWriter
+	var offsetAt int
+	w.box("moof", func() {
+		w.fullBox("mfhd", 0, 0, func() { w.u32(seq) })
+		w.box("traf", func() {
+			w.fullBox("tfhd", 0, 0x020000, func() { w.u32(t.id) }) // Default base is moof
+			w.fullBox("tfdt", 1, 0, func() { w.u64(base) })
+			// Data offset, and each sample's duration, size, flags and
+			// composition offset.
+			w.fullBox("trun", 1, 0x000f01, func() {
+				w.u32(uint32(len(samples)))
+				offsetAt = w.Len()
+				w.u32(0)
+				for _, s := range samples {
+					w.u32(s.duration)
+					w.u32(uint32(len(s.data)))
+					if s.key {
+						w.u32(flagsSync)
+					} else {
+						w.u32(flagsNonSync)
+					}
+					w.u32(uint32(s.cts))
+				}
+			})
+		})
+	})
+	binary.BigEndian.PutUint32(w.Bytes()[offsetAt:], uint32(w.Len()+8))
+	w.box("mdat", func() {
+		for _, s := range samples {
+			w.Write(s.data)
+		}
+	})
+	return w.Bytes()
+}
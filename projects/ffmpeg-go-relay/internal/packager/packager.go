@@ -0,0 +1,202 @@
+// Package packager repackages live H.264/AAC streams as CMAF: fragmented MP4
+// segments, each cut at a keyframe and optionally split into parts, held in
+// memory. The same segments back an HLS playlist (LL-HLS when parts are on)
+// and a DASH manifest, so enabling both costs no second segmenter.
+package packager
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	// DefaultSegmentDuration is the minimum length of a segment: it is cut at
+	// the first keyframe after it.
+	DefaultSegmentDuration = 4 * time.Second
+	// DefaultMaxSegments is how many complete segments a stream keeps.
+	DefaultMaxSegments = 6
+)
+
+// Packager holds the segments of every stream being packaged. A nil
+// Packager ignores every call.
+type Packager struct {
+	hls, dash bool
+	opts      options
+	log       *logger.Logger
+
+	mu      sync.Mutex
+	streams map[string]*Stream
+}
+
+// options are what each stream's segmenter is configured with.
+type options struct {
+	segment     time.Duration
+	part        time.Duration // 0 cuts no parts
+	maxSegments int
+	maxBytes    int64 // 0 is unlimited
+}
+
+// New returns the packager described by cfg, or nil when it is disabled.
+func New(cfg config.PackagerConfig, log *logger.Logger) *Packager {
+	if !cfg.Enabled {
+		return nil
+	}
+	opts := options{
+		segment:     cfg.SegmentDuration.AsDuration(),
+		part:        cfg.PartDuration.AsDuration(),
+		maxSegments: cfg.MaxSegments,
+		maxBytes:    cfg.MaxBytes,
+	}
+	if opts.segment <= 0 {
+		opts.segment = DefaultSegmentDuration
+	}
+	if opts.maxSegments <= 0 {
+		opts.maxSegments = DefaultMaxSegments
+	}
+	return &Packager{
+		hls:     cfg.HLS,
+		dash:    cfg.DASH,
+		opts:    opts,
+		log:     log,
+		streams: make(map[string]*Stream),
+	}
+}
+
+// HLS reports whether HLS playlists are served.
+func (p *Packager) HLS() bool { return p != nil && p.hls }
+
+// DASH reports whether DASH manifests are served.
+func (p *Packager) DASH() bool { return p != nil && p.dash }
+
+// SegmentDuration is the minimum length of a segment.
+func (p *Packager) SegmentDuration() time.Duration { return p.opts.segment }
+
+// Stream returns the live stream called name.
+func (p *Packager) Stream(name string) (*Stream, bool) {
+	if p == nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.streams[name]
+	return s, ok
+}
+
+// StreamStats describes what the packager holds of one stream.
+type StreamStats struct {
+	Name     string   `json:"name"`
+	Tracks   []string `json:"tracks"` // RFC 6381 codec strings
+	Segments int      `json:"segments"`
+	Bytes    int64    `json:"bytes"`
+	LastSeq  uint64   `json:"last_segment"`
+}
+
+// Stats describes every stream the packager holds.
+func (p *Packager) Stats() []StreamStats {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	streams := make([]*Stream, 0, len(p.streams))
+	for _, s := range p.streams {
+		streams = append(streams, s)
+	}
+	p.mu.Unlock()
+	stats := make([]StreamStats, 0, len(streams))
+	for _, s := range streams {
+		stats = append(stats, s.stats())
+	}
+	return stats
+}
+
+// NewTap returns a Tap feeding one session's messages, published to app,
+// into p.
+func (p *Packager) NewTap(app string) *Tap {
+	if p == nil {
+		return nil
+	}
+	app, _, _ = strings.Cut(app, "?")
+	return &Tap{packager: p, app: app}
+}
+
+func (p *Packager) add(name, app string) *Stream {
+	s := newStream(name, app, p.opts, p.log)
+	p.mu.Lock()
+	old := p.streams[name]
+	p.streams[name] = s
+	p.mu.Unlock()
+	old.end()
+	return s
+}
+
+func (p *Packager) remove(s *Stream) {
+	p.mu.Lock()
+	if p.streams[s.name] == s {
+		delete(p.streams, s.name)
+	}
+	p.mu.Unlock()
+	s.end()
+}
+
+// Tap feeds one session's audio and video to a Packager under the stream
+// name the client published. Its methods may be called from the session's
+// reader while another goroutine closes it; a nil Tap ignores every call.
+type Tap struct {
+	packager *Packager
+	app      string
+
+	mu     sync.Mutex
+	stream *Stream
+	closed bool
+}
+
+// SetStream names the stream the session's media belongs to. A session
+// taking over a name replaces the stream packaged under it.
+func (t *Tap) SetStream(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || (t.stream != nil && t.stream.name == name) {
+		return
+	}
+	if t.stream != nil {
+		t.packager.remove(t.stream)
+	}
+	t.stream = t.packager.add(name, t.app)
+}
+
+// Observe looks at one message from the session.
+func (t *Tap) Observe(msg *rtmp.Message) {
+	if t == nil || (msg.Header.TypeID != rtmp.TypeVideo && msg.Header.TypeID != rtmp.TypeAudio) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.stream == nil {
+		return
+	}
+	t.stream.observe(msg)
+}
+
+// Close drops the stream's segments; the stream is no longer live.
+func (t *Tap) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if t.stream != nil {
+		t.packager.remove(t.stream)
+	}
+}
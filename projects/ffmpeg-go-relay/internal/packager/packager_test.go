@@ -0,0 +1,232 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/testpattern"
+)
+
+func newPackager(segment, part time.Duration, maxSegments int) *Packager {
+	return New(config.PackagerConfig{
+		Enabled:         true,
+		HLS:             true,
+		DASH:            true,
+		SegmentDuration: config.Duration(segment),
+		PartDuration:    config.Duration(part),
+		MaxSegments:     maxSegments,
+	}, logger.NewWithWriter(io.Discard))
+}
+
+// publish feeds seconds of a 320x240, 25 fps test pattern with a keyframe
+// every second to a tap on stream.
+func publish(t *testing.T, p *Packager, stream string, opts testpattern.Options, seconds int) *Tap {
+	t.Helper()
+	gen, err := testpattern.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tap := p.NewTap("live?token=x")
+	tap.SetStream(stream)
+	for _, msg := range gen.Headers() {
+		tap.Observe(msg)
+	}
+	for range seconds * 25 {
+		for _, msg := range gen.Next() {
+			tap.Observe(msg)
+		}
+	}
+	return tap
+}
+
+// boxTypes lists the types of the top-level ISO BMFF boxes in b.
+func boxTypes(t *testing.T, b []byte) []string {
+	t.Helper()
+	var types []string
+	for len(b) > 0 {
+		if len(b) < 8 {
+			t.Fatalf("%d trailing bytes", len(b))
+		}
+		size := binary.BigEndian.Uint32(b)
+		if size < 8 || int(size) > len(b) {
+			t.Fatalf("box %q has size %d of %d bytes left", b[4:8], size, len(b))
+		}
+		types = append(types, string(b[4:8]))
+		b = b[size:]
+	}
+	return types
+}
+
+// sampleCount reads the sample count of the trun box of a chunk.
+func sampleCount(chunk []byte) int {
+	i := bytes.Index(chunk, []byte("trun"))
+	return int(binary.BigEndian.Uint32(chunk[i+8:]))
+}
+
+func TestCodecConfig(t *testing.T) {
+	gen, err := testpattern.New(testpattern.Options{Width: 640, Height: 480})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := gen.Headers()
+	codec, width, height, err := avcConfig(headers[1].Payload[5:])
+	if err != nil || !strings.HasPrefix(codec, "avc1.") || width != 640 || height != 480 {
+		t.Fatalf("avcConfig = %q %dx%d, %v", codec, width, height, err)
+	}
+	codec, rate, channels, err := aacConfig(headers[2].Payload[2:])
+	if err != nil || codec != "mp4a.40.2" || rate != 44100 || channels != 1 {
+		t.Fatalf("aacConfig = %q %d Hz %d channels, %v", codec, rate, channels, err)
+	}
+	if _, _, _, err := avcConfig([]byte{1, 0x42, 0, 0x1e, 0xff, 0xe1, 0, 40, 0x67}); err == nil {
+		t.Fatal("expected a cut short record to fail")
+	}
+}
+
+func TestSegmentsAndParts(t *testing.T) {
+	p := newPackager(time.Second, 200*time.Millisecond, 3)
+	tap := publish(t, p, "cam", testpattern.Options{}, 6)
+	defer tap.Close()
+	st, ok := p.Stream("cam")
+	if !ok || st.App() != "live" {
+		t.Fatalf("stream = %v, %v", st, ok)
+	}
+
+	for _, track := range []string{"video", "audio"} {
+		init, ok := st.Init(track)
+		if got := boxTypes(t, init); !ok || strings.Join(got, ",") != "ftyp,moov" {
+			t.Fatalf("%s init segment boxes = %v", track, got)
+		}
+	}
+	// Six 1s GOPs make five complete segments, of which three are kept.
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].Segments != 3 || stats[0].LastSeq != 4 || len(stats[0].Tracks) != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if _, ok := st.Segment("video", 1); ok {
+		t.Fatal("evicted segment 1 is still served")
+	}
+	for seq := uint64(2); seq <= 4; seq++ {
+		chunks, ok := st.Segment("video", seq)
+		if !ok || len(chunks) != 5 {
+			t.Fatalf("segment %d has %d parts, want 5", seq, len(chunks))
+		}
+		frames := 0
+		for _, chunk := range chunks {
+			if got := boxTypes(t, chunk); strings.Join(got, ",") != "moof,mdat" {
+				t.Fatalf("chunk boxes = %v", got)
+			}
+			frames += sampleCount(chunk)
+		}
+		if frames != 25 {
+			t.Fatalf("segment %d has %d video frames, want 25", seq, frames)
+		}
+		part, ok := st.Part("audio", seq, 0)
+		if !ok || sampleCount(part) == 0 {
+			t.Fatalf("segment %d part 0 has no audio", seq)
+		}
+	}
+	if _, ok := st.Segment("video", 5); ok {
+		t.Fatal("open segment is served whole")
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	p := newPackager(time.Second, 500*time.Millisecond, 0)
+	tap := publish(t, p, "cam", testpattern.Options{}, 4)
+	defer tap.Close()
+	st, _ := p.Stream("cam")
+
+	master, ok := st.HLSMaster("")
+	for _, want := range []string{`CODECS="avc1.`, `,mp4a.40.2"`, "RESOLUTION=320x240", `AUDIO="audio"`, `URI="audio.m3u8"`, "\nvideo.m3u8\n"} {
+		if !ok || !strings.Contains(string(master), want) {
+			t.Fatalf("master playlist lacks %q:\n%s", want, master)
+		}
+	}
+	media, _ := st.HLSPlaylist("video", "")
+	for _, want := range []string{
+		"#EXT-X-VERSION:9", "#EXT-X-TARGETDURATION:1", "#EXT-X-MEDIA-SEQUENCE:0", "CAN-BLOCK-RELOAD=YES",
+		`#EXT-X-MAP:URI="../cmaf/video/init.mp4"`, "#EXTINF:1.000,\n../cmaf/video/2.m4s",
+		`#EXT-X-PART:DURATION=0.520,URI="../cmaf/video/2.0.m4s",INDEPENDENT=YES`,
+		`#EXT-X-PRELOAD-HINT:TYPE=PART,URI="../cmaf/video/3.`,
+	} {
+		if !strings.Contains(string(media), want) {
+			t.Fatalf("media playlist lacks %q:\n%s", want, media)
+		}
+	}
+	if _, ok := st.HLSPlaylist("subtitles", ""); ok {
+		t.Fatal("playlist of a missing track")
+	}
+
+	mpd, ok := st.DASHManifest("?token=a")
+	for _, want := range []string{`type="dynamic"`, `codecs="mp4a.40.2"`, `width="320"`, `media="../cmaf/audio/$Number$.m4s?token=a"`, `startNumber="0"`, `<S t="0" d="1000"></S>`} {
+		if !ok || !strings.Contains(string(mpd), want) {
+			t.Fatalf("manifest lacks %q:\n%s", want, mpd)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	p := newPackager(time.Second, 200*time.Millisecond, 0)
+	tap := publish(t, p, "cam", testpattern.Options{NoAudio: true}, 1)
+	st, _ := p.Stream("cam")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if st.Wait(ctx, 1, 0) {
+		t.Fatal("waited for a part not cut yet")
+	}
+	if !st.Wait(context.Background(), 0, 2) {
+		t.Fatal("part already cut was waited for")
+	}
+	if st.Wait(context.Background(), 9, -1) {
+		t.Fatal("waited for a segment too far ahead")
+	}
+	done := make(chan bool)
+	go func() { done <- st.Wait(context.Background(), 1, -1) }()
+	tap.Close()
+	if <-done {
+		t.Fatal("ended stream reported the segment")
+	}
+	if _, ok := p.Stream("cam"); ok {
+		t.Fatal("closed stream is still packaged")
+	}
+}
+
+func TestCodecChangeRestarts(t *testing.T) {
+	p := newPackager(time.Second, 0, 0)
+	tap := publish(t, p, "cam", testpattern.Options{}, 3)
+	defer tap.Close()
+	gen, err := testpattern.New(testpattern.Options{Width: 640, Height: 480})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range gen.Headers() {
+		tap.Observe(msg)
+	}
+	st, _ := p.Stream("cam")
+	if _, ok := st.HLSMaster(""); ok {
+		t.Fatal("playlist served between the codec change and the next keyframe")
+	}
+	for range 50 {
+		for _, msg := range gen.Next() {
+			msg.Header.Timestamp += 3000
+			tap.Observe(msg)
+		}
+	}
+	master, _ := st.HLSMaster("")
+	media, _ := st.HLSPlaylist("video", "")
+	if !strings.Contains(string(master), "RESOLUTION=640x480") || !strings.Contains(string(media), "#EXT-X-DISCONTINUITY-SEQUENCE:1") {
+		t.Fatalf("playlists after the codec change:\n%s\n%s", master, media)
+	}
+	// Numbering goes on, so players never see a segment change under them.
+	if !strings.Contains(string(media), "#EXT-X-MEDIA-SEQUENCE:3") {
+		t.Fatalf("media playlist restarted its numbering:\n%s", media)
+	}
+}
@@ -0,0 +1,416 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	kindVideo = iota
+	kindAudio
+)
+
+// track is one CMAF track of a stream, and the samples it has buffered for
+// the part being cut.
+type track struct {
+	id         uint32
+	kind       int
+	name       string // In URLs: "video" or "audio"
+	codec      string // RFC 6381
+	config     []byte // AVCDecoderConfigurationRecord or AudioSpecificConfig
+	width      int
+	height     int
+	sampleRate int
+	channels   int
+	init       []byte
+
+	pending      []sample
+	seen         bool
+	lastDTS      uint64
+	nextDTS      uint64 // Where the next part starts when it has no samples
+	lastDuration uint32
+}
+
+type sample struct {
+	dts      uint64 // Milliseconds
+	cts      int32  // Composition offset
+	duration uint32
+	key      bool
+	data     []byte
+}
+
+// guessDuration is the duration of a part's last sample, whose successor
+// has not arrived yet: that of the sample before it, or an AAC frame.
+func (t *track) guessDuration() uint32 {
+	if t.lastDuration > 0 {
+		return t.lastDuration
+	}
+	if t.kind == kindAudio {
+		return uint32(1024 * timescale / t.sampleRate)
+	}
+	return 1
+}
+
+// segment starts at a keyframe and is made of parts, each holding a chunk
+// of every track. Only the last segment of a stream is open.
+type segment struct {
+	seq      uint64
+	start    uint64 // Decode time of its first video sample, in ms
+	duration uint64
+	parts    []*part
+	complete bool
+	size     int64
+}
+
+type part struct {
+	duration    uint64
+	independent bool     // Starts with a keyframe
+	data        [][]byte // Per track
+}
+
+// Stream is the packaged form of one live stream.
+type Stream struct {
+	name string
+	app  string
+	opts options
+	log  *logger.Logger
+
+	mu          sync.Mutex
+	changed     chan struct{} // Closed, and replaced, on every new part
+	ended       bool
+	warned      bool
+	videoConfig []byte
+	audioConfig []byte
+	epoch       int // Counts codec changes, each restarting the segments
+	tracks      []*track
+	segments    []*segment // Oldest first
+	nextSeq     uint64
+	fragSeq     uint32
+	bytes       int64
+	started     time.Time // When the first sample of this epoch arrived
+	firstDTS    uint64
+	partStart   uint64
+	independent bool // Whether the part being cut starts with a keyframe
+}
+
+func newStream(name, app string, opts options, log *logger.Logger) *Stream {
+	return &Stream{name: name, app: app, opts: opts, log: log, changed: make(chan struct{})}
+}
+
+// App returns the app the stream was published to.
+func (s *Stream) App() string { return s.app }
+
+func (s *Stream) observe(msg *rtmp.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if msg.Header.TypeID == rtmp.TypeVideo {
+		s.observeVideo(msg)
+	} else {
+		s.observeAudio(msg)
+	}
+}
+
+func (s *Stream) observeVideo(msg *rtmp.Message) {
+	h, err := rtmp.ParseVideoHeader(msg.Payload)
+	if err != nil || len(msg.Payload) < 5 {
+		return
+	}
+	if h.Enhanced || h.CodecID != rtmp.VideoAVC {
+		s.unsupported("video", h.Codec())
+		return
+	}
+	data := msg.Payload[5:]
+	switch h.AVCPacketType {
+	case rtmp.AVCPacketSequenceHeader:
+		s.setConfig(&s.videoConfig, data)
+	case rtmp.AVCPacketNALU:
+		key := h.FrameType == rtmp.FrameKeyframe
+		if len(data) == 0 || (s.tracks == nil && (!key || !s.setup())) {
+			return
+		}
+		s.addVideo(uint64(msg.Header.Timestamp), h.CompositionTime, key, bytes.Clone(data))
+	}
+}
+
+func (s *Stream) observeAudio(msg *rtmp.Message) {
+	h, err := rtmp.ParseAudioHeader(msg.Payload)
+	if err != nil {
+		return
+	}
+	if h.Format != rtmp.AudioAAC {
+		s.unsupported("audio", h.Codec())
+		return
+	}
+	data := msg.Payload[2:]
+	if h.AACPacketType == 0 {
+		s.setConfig(&s.audioConfig, data)
+		return
+	}
+	if len(data) > 0 {
+		s.addAudio(uint64(msg.Header.Timestamp), bytes.Clone(data))
+	}
+}
+
+func (s *Stream) unsupported(kind, codec string) {
+	if !s.warned {
+		s.warned = true
+		s.log.Warn("packager only packages H.264 video and AAC audio", "stream", s.name, kind, codec)
+	}
+}
+
+// setConfig records a sequence header. One that differs from the last
+// restarts the segments at the next keyframe, with new init segments.
+func (s *Stream) setConfig(dst *[]byte, config []byte) {
+	if bytes.Equal(*dst, config) {
+		return
+	}
+	*dst = bytes.Clone(config)
+	if s.tracks != nil {
+		s.tracks, s.segments, s.bytes = nil, nil, 0
+		s.epoch++
+		s.notify()
+	}
+}
+
+// setup creates the tracks from the sequence headers seen so far, which
+// must include the video one.
+func (s *Stream) setup() bool {
+	codec, width, height, err := avcConfig(s.videoConfig)
+	if err != nil {
+		if !s.warned {
+			s.warned = true
+			s.log.Warn("packager cannot read the video sequence header", "stream", s.name, "err", err)
+		}
+		return false
+	}
+	tracks := []*track{{id: 1, kind: kindVideo, name: "video", codec: codec, config: s.videoConfig, width: width, height: height}}
+	if s.audioConfig != nil {
+		if codec, rate, channels, err := aacConfig(s.audioConfig); err == nil {
+			tracks = append(tracks, &track{id: 2, kind: kindAudio, name: "audio", codec: codec, config: s.audioConfig, sampleRate: rate, channels: channels})
+		} else {
+			s.log.Warn("packager cannot read the audio sequence header", "stream", s.name, "err", err)
+		}
+	}
+	for _, t := range tracks {
+		t.init = initSegment(t)
+	}
+	s.tracks = tracks
+	return true
+}
+
+func (s *Stream) addVideo(dts uint64, cts int32, key bool, data []byte) {
+	v := s.tracks[0]
+	if len(s.segments) == 0 {
+		s.segments = []*segment{{seq: s.nextSeq, start: dts}}
+		s.nextSeq++
+		s.started, s.firstDTS = time.Now(), dts
+		s.partStart, s.independent = dts, true
+	}
+	if v.seen && dts < v.lastDTS {
+		dts = v.lastDTS
+	}
+	if n := len(v.pending); n > 0 {
+		v.pending[n-1].duration = uint32(dts - v.pending[n-1].dts)
+		v.lastDuration = v.pending[n-1].duration
+	}
+	open := s.segments[len(s.segments)-1]
+	switch {
+	case key && dts-open.start >= uint64(s.opts.segment.Milliseconds()):
+		s.cut(dts, true, true)
+	case s.opts.part > 0 && dts-s.partStart >= uint64(s.opts.part.Milliseconds()):
+		s.cut(dts, false, key)
+	}
+	v.pending = append(v.pending, sample{dts: dts, cts: cts, key: key, data: data})
+	v.seen, v.lastDTS = true, dts
+}
+
+func (s *Stream) addAudio(dts uint64, data []byte) {
+	if len(s.tracks) < 2 || len(s.segments) == 0 {
+		return
+	}
+	a := s.tracks[1]
+	if a.seen && dts < a.lastDTS {
+		dts = a.lastDTS
+	}
+	if n := len(a.pending); n > 0 {
+		a.pending[n-1].duration = uint32(dts - a.pending[n-1].dts)
+		a.lastDuration = a.pending[n-1].duration
+	}
+	a.pending = append(a.pending, sample{dts: dts, key: true, data: data})
+	a.seen, a.lastDTS = true, dts
+}
+
+// cut ends the part being cut at at, with what every track has buffered,
+// and the open segment too when last is set. independent tells whether the
+// next part starts with a keyframe.
+func (s *Stream) cut(at uint64, last, independent bool) {
+	seg := s.segments[len(s.segments)-1]
+	p := &part{duration: at - s.partStart, independent: s.independent, data: make([][]byte, len(s.tracks))}
+	for i, t := range s.tracks {
+		base := t.nextDTS
+		if n := len(t.pending); n > 0 {
+			if t.pending[n-1].duration == 0 {
+				t.pending[n-1].duration = t.guessDuration()
+			}
+			base = t.pending[0].dts
+			t.nextDTS = t.pending[n-1].dts + uint64(t.pending[n-1].duration)
+		}
+		s.fragSeq++ // Starts at 1
+		p.data[i] = fragment(t, s.fragSeq, base, t.pending)
+		t.pending = nil
+		seg.size += int64(len(p.data[i]))
+		s.bytes += int64(len(p.data[i]))
+	}
+	seg.parts = append(seg.parts, p)
+	seg.duration += p.duration
+	s.partStart, s.independent = at, independent
+	if last {
+		seg.complete = true
+		s.segments = append(s.segments, &segment{seq: s.nextSeq, start: at})
+		s.nextSeq++
+		s.evict()
+	}
+	s.notify()
+}
+
+// evict drops the oldest complete segments past the count and byte limits,
+// always keeping one.
+func (s *Stream) evict() {
+	complete := len(s.segments) - 1
+	for complete > s.opts.maxSegments || (s.opts.maxBytes > 0 && s.bytes > s.opts.maxBytes && complete > 1) {
+		s.bytes -= s.segments[0].size
+		s.segments[0] = nil
+		s.segments = s.segments[1:]
+		complete--
+	}
+}
+
+func (s *Stream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// end wakes every waiting request: the stream will not grow any more.
+func (s *Stream) end() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.ended = true
+		s.notify()
+	}
+}
+
+func (s *Stream) stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := StreamStats{Name: s.name, Tracks: []string{}, Bytes: s.bytes}
+	for _, t := range s.tracks {
+		st.Tracks = append(st.Tracks, t.codec)
+	}
+	if n := len(s.segments); n > 1 {
+		st.Segments = n - 1
+		st.LastSeq = s.segments[n-2].seq
+	}
+	return st
+}
+
+func (s *Stream) trackIndex(name string) int {
+	for i, t := range s.tracks {
+		if t.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Stream) find(seq uint64) *segment {
+	if len(s.segments) == 0 || seq < s.segments[0].seq {
+		return nil
+	}
+	if i := seq - s.segments[0].seq; i < uint64(len(s.segments)) {
+		return s.segments[i]
+	}
+	return nil
+}
+
+// Init returns the initialization segment of the named track.
+func (s *Stream) Init(track string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.trackIndex(track)
+	if i < 0 {
+		return nil, false
+	}
+	return s.tracks[i].init, true
+}
+
+// Segment returns the chunks making up complete segment seq of the named
+// track, in order.
+func (s *Stream) Segment(track string, seq uint64) ([][]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, seg := s.trackIndex(track), s.find(seq)
+	if i < 0 || seg == nil || !seg.complete {
+		return nil, false
+	}
+	chunks := make([][]byte, len(seg.parts))
+	for j, p := range seg.parts {
+		chunks[j] = p.data[i]
+	}
+	return chunks, true
+}
+
+// Part returns part index of segment seq of the named track.
+func (s *Stream) Part(track string, seq uint64, index int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, seg := s.trackIndex(track), s.find(seq)
+	if i < 0 || seg == nil || index < 0 || index >= len(seg.parts) {
+		return nil, false
+	}
+	return seg.parts[index].data[i], true
+}
+
+// maxAhead is how many segments past the open one a blocking request may
+// wait for.
+const maxAhead = 2
+
+// Wait blocks until part index of segment seq is cut, or the whole segment
+// when index is negative. It gives up, returning false, once ctx is done,
+// the stream ends or the segment cannot come soon.
+func (s *Stream) Wait(ctx context.Context, seq uint64, index int) bool {
+	for {
+		s.mu.Lock()
+		if len(s.segments) > 0 {
+			if seq < s.segments[0].seq || seq > s.nextSeq+maxAhead {
+				s.mu.Unlock()
+				return false
+			}
+			if seg := s.find(seq); seg != nil && (seg.complete || (index >= 0 && index < len(seg.parts))) {
+				s.mu.Unlock()
+				return true
+			}
+		}
+		changed, ended := s.changed, s.ended
+		s.mu.Unlock()
+		if ended {
+			return false
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
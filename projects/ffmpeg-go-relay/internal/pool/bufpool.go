@@ -1,37 +1,52 @@
 package pool
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+
+	"ffmpeg-go-relay/internal/metrics"
+)
 
 // BytePool provides a pool of reusable byte buffers
 type BytePool struct {
-	pool sync.Pool
-	size int
+	pool    sync.Pool
+	size    int
+	metrics *metrics.Registry
+
+	gets     atomic.Int64
+	misses   atomic.Int64
+	puts     atomic.Int64
+	discards atomic.Int64
 }
 
 // New creates a new byte pool with buffers of given size
 func New(size int) *BytePool {
+	return NewWithMetrics(size, nil)
+}
+
+// NewWithMetrics creates a byte pool that also reports its gets, misses,
+// puts and buffers in use to reg.
+func NewWithMetrics(size int, reg *metrics.Registry) *BytePool {
 	if size <= 0 {
 		size = 64 * 1024 // Default 64KB
 	}
 
-	return &BytePool{
-		size: size,
-		pool: sync.Pool{
-			New: func() interface{} {
-				return make([]byte, size)
-			},
-		},
-	}
+	return &BytePool{size: size, metrics: reg}
 }
 
 // Get retrieves a buffer from the pool
 func (bp *BytePool) Get() []byte {
+	// The pool has no New func, so an empty pool returns nil and the
+	// allocation below is counted as a miss.
 	v := bp.pool.Get()
 	buf, ok := v.([]byte)
-	if !ok || buf == nil || cap(buf) < bp.size {
-		// Fallback: create new buffer if pool returns invalid value
+	miss := !ok || buf == nil || cap(buf) < bp.size
+	if miss {
 		buf = make([]byte, bp.size)
+		bp.misses.Add(1)
 	}
+	bp.gets.Add(1)
+	bp.metrics.RecordBufferGet(miss)
 	return buf[:bp.size] // Ensure full size
 }
 
@@ -40,12 +55,31 @@ func (bp *BytePool) Put(buf []byte) {
 	// Only put back buffers of correct size
 	if cap(buf) >= bp.size {
 		bp.pool.Put(buf)
+		bp.puts.Add(1)
+		bp.metrics.RecordBufferPut()
+		return
 	}
+	bp.discards.Add(1)
+}
+
+// Size returns the length of the buffers Get returns.
+func (bp *BytePool) Size() int {
+	return bp.size
 }
 
-// Stats returns pool statistics
+// Stats returns pool statistics. in_use counts buffers handed out by Get and
+// not yet given back; a buffer that is never returned stays counted, which
+// is what makes a leak visible.
 func (bp *BytePool) Stats() map[string]interface{} {
+	gets, puts := bp.gets.Load(), bp.puts.Load()
+	inUse := max(gets-puts, 0)
 	return map[string]interface{}{
-		"buffer_size": bp.size,
+		"buffer_size":  bp.size,
+		"gets":         gets,
+		"misses":       bp.misses.Load(),
+		"puts":         puts,
+		"discards":     bp.discards.Load(),
+		"in_use":       inUse,
+		"in_use_bytes": inUse * int64(bp.size),
 	}
 }
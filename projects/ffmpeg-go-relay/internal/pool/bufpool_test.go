@@ -2,6 +2,10 @@ package pool
 
 import (
 	"testing"
+
+	"ffmpeg-go-relay/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestBytePoolNew(t *testing.T) {
@@ -118,3 +122,69 @@ func TestBytePoolMultipleSizes(t *testing.T) {
 		}
 	}
 }
+
+func TestBytePoolStatsCountUse(t *testing.T) {
+	bp := New(1024)
+	a := bp.Get()
+	b := bp.Get()
+	bp.Put(a)
+	bp.Put(make([]byte, 16)) // Too small, discarded
+
+	stats := bp.Stats()
+	if stats["gets"] != int64(2) || stats["puts"] != int64(1) || stats["discards"] != int64(1) {
+		t.Fatalf("stats = %v, want 2 gets, 1 put and 1 discard", stats)
+	}
+	if stats["misses"] != int64(2) {
+		t.Fatalf("misses = %v, want both gets on an empty pool to miss", stats["misses"])
+	}
+	if stats["in_use"] != int64(1) || stats["in_use_bytes"] != int64(1024) {
+		t.Fatalf("in_use = %v (%v bytes), want the one buffer not returned", stats["in_use"], stats["in_use_bytes"])
+	}
+	bp.Put(b)
+	if stats := bp.Stats(); stats["in_use"] != int64(0) {
+		t.Fatalf("in_use = %v after returning every buffer", stats["in_use"])
+	}
+}
+
+func TestBytePoolReportsMetrics(t *testing.T) {
+	promReg := prometheus.NewRegistry()
+	reg, err := metrics.NewRegistry(promReg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := NewWithMetrics(1024, reg)
+	buf := bp.Get()
+	if got := gathered(t, promReg, "test_buffer_pool_in_use"); got != 1 {
+		t.Fatalf("in use gauge = %v after a Get, want 1", got)
+	}
+	bp.Put(buf)
+	if got := gathered(t, promReg, "test_buffer_pool_in_use"); got != 0 {
+		t.Fatalf("in use gauge = %v after the Put, want 0", got)
+	}
+	if got := gathered(t, promReg, "test_buffer_pool_gets_total"); got != 1 {
+		t.Fatalf("gets counter = %v, want 1", got)
+	}
+	if got := gathered(t, promReg, "test_buffer_pool_misses_total"); got != 1 {
+		t.Fatalf("misses counter = %v, want the first Get to miss", got)
+	}
+}
+
+func gathered(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		m := f.GetMetric()[0]
+		if m.GetGauge() != nil {
+			return m.GetGauge().GetValue()
+		}
+		return m.GetCounter().GetValue()
+	}
+	t.Fatalf("metric %s not gathered", name)
+	return 0
+}
@@ -0,0 +1,320 @@
+// Package postprocess runs configured steps on each DVR segment once it is
+// closed: remuxing it to MP4, snapshotting a thumbnail, calling a webhook and
+// moving the results to an archive. Segments queue for a fixed number of
+// workers; each step is retried with backoff, and a job whose step keeps
+// failing is given up and its files deleted.
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/retry"
+)
+
+const (
+	// DefaultConcurrency is how many jobs run at once.
+	DefaultConcurrency = 2
+	// DefaultQueue is how many jobs may wait for a worker.
+	DefaultQueue = 100
+	// DefaultMaxAttempts is how often each step is tried.
+	DefaultMaxAttempts = 3
+	// DefaultRetryBackoff is the wait before a step's second attempt.
+	DefaultRetryBackoff = 5 * time.Second
+	// DefaultStepTimeout bounds each attempt of a step.
+	DefaultStepTimeout = 2 * time.Minute
+)
+
+// Job results, as counted by dvr_postprocess_jobs_total.
+const (
+	resultDone    = "done"
+	resultFailed  = "failed"
+	resultDropped = "dropped" // The queue was full or the runner closed
+)
+
+// errRefused marks a step failure retrying cannot fix, such as a webhook
+// rejecting the job.
+var errRefused = errors.New("refused")
+
+// Job is one closed segment going through the steps. Webhook steps POST it
+// as JSON.
+type Job struct {
+	Stream          string    `json:"stream"`
+	App             string    `json:"app"`
+	Segment         int       `json:"segment"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Files made so far, the segment as a standalone FLV first. Move steps
+	// update them to where the files went.
+	Files []string `json:"files"`
+
+	dir  string // Working directory, deleted with whatever is left when the job ends
+	name string // Base name of the job's files
+	seg  *dvr.ClosedSegment
+}
+
+// step is one configured step.
+type step struct {
+	name string
+	run  func(ctx context.Context, job *Job) error
+}
+
+// Runner post-processes closed segments. A nil Runner ignores every call.
+type Runner struct {
+	dir     string
+	prefix  string // Of this process's job directories
+	steps   []step
+	retry   retry.Config
+	log     *logger.Logger
+	metrics *metrics.Registry
+
+	mu     sync.RWMutex // Held to send on jobs, and to close it
+	jobs   chan *Job
+	closed bool
+
+	pending atomic.Int64
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New starts the runner described by cfg, working in cfg.WorkDir or under
+// dvrDir, or returns nil when post-processing is disabled.
+func New(cfg config.DVRPostProcessConfig, dvrDir string, log *logger.Logger, m *metrics.Registry) (*Runner, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	steps := make([]step, 0, len(cfg.Steps))
+	for _, sc := range cfg.Steps {
+		steps = append(steps, newStep(sc))
+	}
+	dir := cfg.WorkDir
+	if dir == "" {
+		dir = filepath.Join(dvrDir, "postprocess")
+	}
+	return newRunner(dir, steps, cfg, log, m)
+}
+
+func newRunner(dir string, steps []step, cfg config.DVRPostProcessConfig, log *logger.Logger, m *metrics.Registry) (*Runner, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("postprocess: %w", err)
+	}
+	concurrency, queue := cfg.Concurrency, cfg.Queue
+	if concurrency == 0 {
+		concurrency = DefaultConcurrency
+	}
+	if queue == 0 {
+		queue = DefaultQueue
+	}
+	rc := retry.Config{
+		MaxAttempts:       cfg.MaxAttempts,
+		InitialDelay:      cfg.RetryBackoff.AsDuration(),
+		MaxDelay:          10 * time.Minute,
+		Multiplier:        2,
+		PerAttemptTimeout: cfg.StepTimeout.AsDuration(),
+	}
+	if rc.MaxAttempts == 0 {
+		rc.MaxAttempts = DefaultMaxAttempts
+	}
+	if rc.InitialDelay == 0 {
+		rc.InitialDelay = DefaultRetryBackoff
+	}
+	if rc.PerAttemptTimeout == 0 {
+		rc.PerAttemptTimeout = DefaultStepTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Runner{
+		dir:     dir,
+		prefix:  fmt.Sprintf("job-%d-", os.Getpid()),
+		steps:   steps,
+		retry:   rc,
+		log:     log,
+		metrics: m,
+		jobs:    make(chan *Job, queue),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	r.retry.Retryable = func(err error) bool { return !errors.Is(err, errRefused) }
+	for range concurrency {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r, nil
+}
+
+// RemoveStale deletes the jobs a previous run left unfinished in the work
+// directory. Call it once that run has stopped: during an upgrade it is
+// still working on them.
+func (r *Runner) RemoveStale() error {
+	if r == nil {
+		return nil
+	}
+	stale, err := filepath.Glob(filepath.Join(r.dir, "job-*"))
+	if err != nil {
+		return fmt.Errorf("postprocess: %w", err)
+	}
+	for _, path := range stale {
+		if strings.HasPrefix(filepath.Base(path), r.prefix) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("postprocess: %w", err)
+		}
+	}
+	return nil
+}
+
+// Submit queues seg for post-processing; it is the recorder's
+// OnSegmentClosed hook. It links the segment into the job's directory and
+// returns without waiting: a segment that finds the queue full is skipped.
+func (r *Runner) Submit(seg *dvr.ClosedSegment) {
+	if r == nil {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.metrics.RecordPostProcessJob(resultDropped)
+		return
+	}
+	job := &Job{
+		Stream:          seg.Stream,
+		App:             seg.App,
+		Segment:         seg.ID,
+		Started:         seg.Started,
+		DurationSeconds: seg.Duration.Seconds(),
+		name:            fmt.Sprintf("%s-%s-%08d", safeName(seg.Stream), seg.Started.UTC().Format("20060102T150405Z"), seg.ID),
+		seg:             seg,
+	}
+	dir, err := os.MkdirTemp(r.dir, r.prefix+"*")
+	if err == nil {
+		job.dir = dir
+		err = seg.Keep(filepath.Join(dir, "segment"))
+	}
+	if err != nil {
+		r.log.Warn("failed to keep dvr segment for post-processing", "stream", seg.Stream, "segment", seg.ID, "err", err)
+		r.drop(job)
+		return
+	}
+	r.metrics.SetPostProcessPending(r.pending.Add(1))
+	select {
+	case r.jobs <- job:
+	default:
+		r.metrics.SetPostProcessPending(r.pending.Add(-1))
+		r.log.Warn("dvr post-processing queue is full; skipping segment", "stream", seg.Stream, "segment", seg.ID)
+		r.drop(job)
+	}
+}
+
+func (r *Runner) drop(job *Job) {
+	if job.dir != "" {
+		os.RemoveAll(job.dir)
+	}
+	r.metrics.RecordPostProcessJob(resultDropped)
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for job := range r.jobs {
+		r.metrics.SetPostProcessPending(r.pending.Add(-1))
+		if r.ctx.Err() != nil {
+			r.drop(job)
+			continue
+		}
+		r.process(job)
+	}
+}
+
+// process runs every step on job, then deletes what is left of it.
+func (r *Runner) process(job *Job) {
+	defer os.RemoveAll(job.dir)
+	if err := r.export(job); err != nil {
+		r.fail(job, "export", err)
+		return
+	}
+	for _, s := range r.steps {
+		err := retry.DoContext(r.ctx, r.retry, func(ctx context.Context) error {
+			return s.run(ctx, job)
+		})
+		if err != nil {
+			r.fail(job, s.name, err)
+			return
+		}
+	}
+	r.metrics.RecordPostProcessJob(resultDone)
+	r.log.Debug("dvr segment post-processed", "stream", job.Stream, "segment", job.Segment, "files", job.Files)
+}
+
+// export writes the job's segment as a standalone FLV file, its first file.
+func (r *Runner) export(job *Job) error {
+	path := filepath.Join(job.dir, job.name+".flv")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := job.seg.WriteFLV(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	job.Files = []string{path}
+	return os.Remove(filepath.Join(job.dir, "segment"))
+}
+
+func (r *Runner) fail(job *Job, stepName string, err error) {
+	r.metrics.RecordPostProcessJob(resultFailed)
+	r.log.Error("dvr post-processing failed", "stream", job.Stream, "segment", job.Segment, "step", stepName, "err", err)
+}
+
+// Close stops taking segments and waits for the queued jobs until ctx is
+// done, then cancels those still running.
+func (r *Runner) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.jobs)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeName turns a stream name into one usable in file names.
+func safeName(stream string) string {
+	if name := unsafeName.ReplaceAllString(stream, "_"); name != "" && name != "." && name != ".." {
+		return name
+	}
+	return "stream"
+}
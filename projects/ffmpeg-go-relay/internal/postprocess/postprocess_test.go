@@ -0,0 +1,180 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func video(ts uint32, payload ...byte) *rtmp.Message {
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts}, Payload: payload}
+}
+
+// recordSegments records seconds of 1s GOPs of stream with an encrypting
+// recorder, handing each closed segment to r, then ends the stream.
+func recordSegments(t *testing.T, r *Runner, stream string, seconds int) {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	rec, err := dvr.New(config.DVRConfig{
+		Enabled:    true,
+		Dir:        t.TempDir(),
+		Segment:    config.Duration(time.Second),
+		Encryption: config.DVREncryptionConfig{Enabled: true, Key: key},
+	}, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.OnSegmentClosed(r.Submit)
+	tap := rec.NewTap("live")
+	tap.SetStream(stream)
+	tap.Observe(video(0, 0x17, 0x00))
+	for i := range seconds {
+		tap.Observe(video(uint32(i*1000), 0x17, 0x01))
+		tap.Observe(video(uint32(i*1000+500), 0x27, 0x01))
+	}
+	tap.Close()
+}
+
+func testConfig() config.DVRPostProcessConfig {
+	return config.DVRPostProcessConfig{Enabled: true, Concurrency: 2, MaxAttempts: 3, RetryBackoff: config.Duration(time.Millisecond)}
+}
+
+func TestRunnerProcessesClosedSegments(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		hooks []Job
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var job Job
+		if err := json.NewDecoder(req.Body).Decode(&job); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		mu.Lock()
+		hooks = append(hooks, job)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	work, archive := t.TempDir(), t.TempDir()
+	// Stands in for an ffmpeg step: the FLV must be playable on its own.
+	probe := step{name: "probe", run: func(ctx context.Context, job *Job) error {
+		flv, err := os.ReadFile(job.Files[0])
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(flv, []byte("FLV")) || !bytes.Contains(flv, []byte{0x17, 0x00}) {
+			return errors.New("segment FLV lacks its header or sequence header")
+		}
+		out := filepath.Join(job.dir, job.name+".txt")
+		job.Files = append(job.Files, out)
+		return os.WriteFile(out, nil, 0o644)
+	}}
+	steps := []step{probe, newStep(config.DVRPostProcessStep{Type: config.PostProcessMove, Dir: archive}),
+		newStep(config.DVRPostProcessStep{Type: config.PostProcessWebhook, URL: hook.URL})}
+	r, err := newRunner(work, steps, testConfig(), logger.NewWithWriter(io.Discard), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSegments(t, r, "live/cam 1", 3)
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hooks) != 3 {
+		t.Fatalf("webhook got %d jobs, want one per segment", len(hooks))
+	}
+	for _, job := range hooks {
+		if job.Stream != "live/cam 1" || job.App != "live" || len(job.Files) != 2 {
+			t.Fatalf("job = %+v", job)
+		}
+		for _, path := range job.Files {
+			if filepath.Dir(path) != filepath.Join(archive, "live_cam_1") {
+				t.Fatalf("file %s is not in the stream's archive directory", path)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !strings.HasSuffix(job.Files[0], ".flv") {
+			t.Fatalf("first file = %s, want the segment FLV", job.Files[0])
+		}
+	}
+	if left, _ := os.ReadDir(work); len(left) != 0 {
+		t.Fatalf("work directory not cleaned up: %v", left)
+	}
+}
+
+func TestRunnerRetries(t *testing.T) {
+	var refusals int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		refusals++
+		http.Error(w, "no", http.StatusBadRequest)
+	}))
+	defer hook.Close()
+
+	attempts := map[int]int{}
+	flaky := step{name: "flaky", run: func(ctx context.Context, job *Job) error {
+		attempts[job.Segment]++
+		if attempts[job.Segment] < 2 {
+			return errors.New("try again")
+		}
+		return nil
+	}}
+	cfg := testConfig()
+	cfg.Concurrency = 1
+	r, err := newRunner(t.TempDir(), []step{flaky, newStep(config.DVRPostProcessStep{Type: config.PostProcessWebhook, URL: hook.URL})},
+		cfg, logger.NewWithWriter(io.Discard), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSegments(t, r, "cam", 2)
+	r.Close(context.Background())
+
+	for id, n := range attempts {
+		if n != 2 {
+			t.Fatalf("segment %d: flaky step ran %d times, want 2", id, n)
+		}
+	}
+	// A refusal is not retried.
+	if len(attempts) != 2 || refusals != 2 {
+		t.Fatalf("%d segments got %d webhook refusals, want one each", len(attempts), refusals)
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRunner(dir, nil, testConfig(), logger.NewWithWriter(io.Discard), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	stale := filepath.Join(dir, "job-1-123")
+	ours := filepath.Join(dir, r.prefix+"456")
+	os.Mkdir(stale, 0o755)
+	os.Mkdir(ours, 0o755)
+	if err := r.RemoveStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("previous run's job was not removed")
+	}
+	if _, err := os.Stat(ours); err != nil {
+		t.Fatal("running job was removed")
+	}
+}
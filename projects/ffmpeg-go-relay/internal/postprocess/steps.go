@@ -0,0 +1,154 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// thumbnailQuality is the ffmpeg JPEG qscale of thumbnail steps.
+const thumbnailQuality = 5
+
+func newStep(sc config.DVRPostProcessStep) step {
+	switch sc.Type {
+	case config.PostProcessRemuxMP4:
+		return step{name: sc.Type, run: remuxMP4}
+	case config.PostProcessThumbnail:
+		return step{name: sc.Type, run: thumbnail(sc.Width)}
+	case config.PostProcessWebhook:
+		return step{name: sc.Type, run: webhook(&http.Client{}, sc.URL)}
+	default:
+		return step{name: sc.Type, run: move(sc.Dir)}
+	}
+}
+
+// remuxMP4 copies the segment's streams into an MP4 file with its index up
+// front, so it plays while downloading.
+func remuxMP4(ctx context.Context, job *Job) error {
+	out := filepath.Join(job.dir, job.name+".mp4")
+	if err := ffmpeg(ctx, "-i", job.Files[0], "-c", "copy", "-movflags", "+faststart", out); err != nil {
+		return err
+	}
+	job.Files = append(job.Files, out)
+	return nil
+}
+
+// thumbnail snapshots the segment's first frame, which is a keyframe, as a
+// JPEG scaled to width (0 keeps the video's).
+func thumbnail(width int) func(ctx context.Context, job *Job) error {
+	return func(ctx context.Context, job *Job) error {
+		out := filepath.Join(job.dir, job.name+".jpg")
+		args := []string{"-i", job.Files[0], "-frames:v", "1"}
+		if width > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+		}
+		if err := ffmpeg(ctx, append(args, "-q:v", fmt.Sprint(thumbnailQuality), out)...); err != nil {
+			return err
+		}
+		job.Files = append(job.Files, out)
+		return nil
+	}
+}
+
+func ffmpeg(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// webhook POSTs the job as JSON to url. A 4xx answer refuses the job and is
+// not retried.
+func webhook(client *http.Client, url string) func(ctx context.Context, job *Job) error {
+	return func(ctx context.Context, job *Job) error {
+		body, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		switch {
+		case resp.StatusCode/100 == 2:
+			return nil
+		case resp.StatusCode/100 == 4:
+			return fmt.Errorf("webhook: %w with status %s", errRefused, resp.Status)
+		}
+		return fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+}
+
+// move moves the job's files into a directory per stream under dir, copying
+// them across filesystems. Files already moved by an earlier attempt stay.
+func move(dir string) func(ctx context.Context, job *Job) error {
+	return func(ctx context.Context, job *Job) error {
+		dest := filepath.Join(dir, safeName(job.Stream))
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return err
+		}
+		for i, path := range job.Files {
+			if filepath.Dir(path) == dest {
+				continue
+			}
+			to := filepath.Join(dest, filepath.Base(path))
+			if err := moveFile(path, to); err != nil {
+				return err
+			}
+			job.Files[i] = to
+		}
+		return nil
+	}
+}
+
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// Copy under a temporary name, so the archive never shows half a file.
+	tmp := to + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
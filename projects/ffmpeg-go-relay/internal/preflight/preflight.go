@@ -0,0 +1,262 @@
+// Package preflight checks a relay config against the environment it is about
+// to run in: beyond what Config.Validate can see, it resolves and dials every
+// upstream and loads the TLS key pair. It backs "relay -check-config", so CI
+// pipelines can refuse a config before it is rolled out.
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/relay"
+	"ffmpeg-go-relay/internal/tlscert"
+)
+
+// DefaultDialTimeout bounds each upstream's DNS lookup and dial.
+const DefaultDialTimeout = 5 * time.Second
+
+// Status is the outcome of one check.
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn" // Worth a look, but the relay would start
+	StatusFail Status = "fail"
+)
+
+// Check is one line of the report.
+type Check struct {
+	Name   string // e.g. "config", "tls" or the upstream's config field
+	Status Status
+	Detail string
+}
+
+// Report lists the checks in the order they ran.
+type Report struct {
+	Checks []Check
+}
+
+// OK reports whether no check failed.
+func (r *Report) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints the report as an aligned table followed by a summary line.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var warned, failed int
+	for _, c := range r.Checks {
+		switch c.Status {
+		case StatusWarn:
+			warned++
+		case StatusFail:
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Status, c.Name, c.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d checks, %d warnings, %d failures\n", len(r.Checks), warned, failed)
+	return err
+}
+
+// Options tune the checks; the zero value is ready to use.
+type Options struct {
+	DialTimeout time.Duration    // 0 uses DefaultDialTimeout
+	Resolver    *net.Resolver    // nil uses net.DefaultResolver
+	Now         func() time.Time // nil uses time.Now; for certificate validity
+}
+
+// Run checks cfg. Checks after a failed one still run, so one pass shows
+// everything that needs fixing.
+func Run(ctx context.Context, cfg config.Config, opts Options) *Report {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	r := &Report{}
+	if err := cfg.Validate(); err != nil {
+		r.Checks = append(r.Checks, Check{Name: "config", Status: StatusFail, Detail: err.Error()})
+	} else {
+		r.Checks = append(r.Checks, Check{Name: "config", Status: StatusOK, Detail: "valid"})
+	}
+	if cfg.Security.TLSEnabled {
+		r.Checks = append(r.Checks, checkTLS(cfg.Security, opts.Now()))
+	}
+
+	// Upstreams are dialed concurrently; the report keeps config order.
+	upstreams := Upstreams(cfg)
+	checks := make([]Check, len(upstreams))
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[i] = checkUpstream(ctx, u, opts)
+		}()
+	}
+	wg.Wait()
+	r.Checks = append(r.Checks, checks...)
+	return r
+}
+
+// Upstream is an upstream URL and the config field it came from.
+type Upstream struct {
+	Field string // e.g. "routes[0].upstreams[1]"
+	URL   string
+}
+
+// Upstreams lists every upstream cfg names: the global one or pool, route
+// groups and tenant groups. A URL listed in several places is kept once,
+// under its first field.
+func Upstreams(cfg config.Config) []Upstream {
+	var out []Upstream
+	seen := map[string]bool{}
+	add := func(field, url string) {
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		out = append(out, Upstream{Field: field, URL: url})
+	}
+	if len(cfg.Upstreams) == 0 {
+		add("upstream", cfg.Upstream)
+	}
+	for i, e := range cfg.Upstreams {
+		add(fmt.Sprintf("upstreams[%d]", i), e.URL)
+	}
+	for i, route := range cfg.Routes {
+		for j, e := range route.Upstreams {
+			add(fmt.Sprintf("routes[%d].upstreams[%d]", i, j), e.URL)
+		}
+	}
+	for i, tenant := range cfg.Tenants {
+		for j, e := range tenant.Upstreams {
+			add(fmt.Sprintf("tenants[%d].upstreams[%d]", i, j), e.URL)
+		}
+	}
+	return out
+}
+
+// checkUpstream resolves and dials u, completing the TLS handshake for
+// rtmps and rtsps. It does not speak RTMP: a reachable port is a pass. SRT
+// upstreams are only resolved.
+func checkUpstream(ctx context.Context, u Upstream, opts Options) Check {
+	c := Check{Name: u.Field, Status: StatusFail}
+	info, err := relay.ParseUpstream(u.URL)
+	if err != nil {
+		c.Detail = fmt.Sprintf("%s: %v", u.URL, err)
+		return c
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.DialTimeout)
+	defer cancel()
+
+	var details []string
+	if net.ParseIP(info.Host) == nil {
+		addrs, err := opts.Resolver.LookupHost(ctx, info.Host)
+		if err != nil {
+			c.Detail = fmt.Sprintf("%s: resolve: %v", u.URL, err)
+			return c
+		}
+		details = append(details, "resolves to "+strings.Join(addrs, ", "))
+	}
+
+	if info.Datagram() {
+		// SRT runs over UDP, so there is no connection to try.
+		details = append(details, "not dialed over UDP")
+		c.Status = StatusOK
+		c.Detail = u.URL + ": " + strings.Join(details, "; ")
+		return c
+	}
+
+	start := time.Now()
+	dialer := &net.Dialer{Resolver: opts.Resolver}
+	conn, err := dialer.DialContext(ctx, "tcp", info.Address)
+	if err == nil && info.UseTLS {
+		tc := tls.Client(conn, &tls.Config{ServerName: info.Host})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			err = fmt.Errorf("tls: %w", err)
+		}
+		conn = tc
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		c.Detail = fmt.Sprintf("%s: %v", u.URL, err)
+		return c
+	}
+	conn.Close()
+	details = append(details, fmt.Sprintf("connected in %v", time.Since(start).Round(time.Millisecond)))
+	c.Status = StatusOK
+	c.Detail = u.URL + ": " + strings.Join(details, "; ")
+	return c
+}
+
+// checkTLS loads the listener's key pair the way the relay will, and warns
+// about certificates that expire within the configured warning window.
+func checkTLS(sec config.SecurityConfig, now time.Time) Check {
+	c := Check{Name: "tls", Status: StatusFail}
+	for _, file := range []string{sec.TLSCert, sec.TLSKey} {
+		if _, err := os.ReadFile(file); err != nil {
+			c.Detail = err.Error()
+			return c
+		}
+	}
+	pair, err := tls.LoadX509KeyPair(sec.TLSCert, sec.TLSKey)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	chain := make([]*x509.Certificate, 0, len(pair.Certificate))
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			c.Detail = fmt.Sprintf("parse certificate: %v", err)
+			return c
+		}
+		chain = append(chain, cert)
+	}
+	if err := tlscert.CheckChain(chain, now); err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+
+	warning := sec.CertExpiryWarning.AsDuration()
+	if warning <= 0 {
+		warning = tlscert.DefaultExpiryWarning
+	}
+	leaf := chain[0]
+	c.Status = StatusOK
+	c.Detail = fmt.Sprintf("%s, %d certificates, expires %s", leaf.Subject, len(chain), leaf.NotAfter.Format(time.RFC3339))
+	for _, cert := range chain {
+		if cert.NotAfter.Sub(now) < warning {
+			c.Status = StatusWarn
+			c.Detail = fmt.Sprintf("%s expires soon, at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+			break
+		}
+	}
+	return c
+}
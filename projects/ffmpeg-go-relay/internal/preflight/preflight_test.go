@@ -0,0 +1,153 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestRunDialsUpstreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cfg := config.Default()
+	cfg.Upstreams = []config.UpstreamEndpoint{
+		{URL: "rtmp://localhost:" + port + "/live"},
+		{URL: "rtmp://" + closedAddr + "/live"},
+	}
+	r := Run(context.Background(), cfg, Options{DialTimeout: 2 * time.Second})
+
+	if len(r.Checks) != 3 {
+		t.Fatalf("checks = %+v", r.Checks)
+	}
+	// Loopback upstreams do not pass validation, but are still dialed.
+	if c := r.Checks[0]; c.Name != "config" || c.Status != StatusFail {
+		t.Fatalf("config check = %+v", c)
+	}
+	if c := r.Checks[1]; c.Name != "upstreams[0]" || c.Status != StatusOK || !strings.Contains(c.Detail, "resolves to") {
+		t.Fatalf("reachable upstream check = %+v", c)
+	}
+	if c := r.Checks[2]; c.Name != "upstreams[1]" || c.Status != StatusFail || !strings.Contains(c.Detail, "refused") {
+		t.Fatalf("closed upstream check = %+v", c)
+	}
+	if r.OK() {
+		t.Fatal("report with failures is OK")
+	}
+
+	var out bytes.Buffer
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "3 checks, 0 warnings, 2 failures") {
+		t.Fatalf("report:\n%s", out.String())
+	}
+}
+
+func TestUpstreamsListsEachURLOnce(t *testing.T) {
+	cfg := config.Default()
+	cfg.Upstream = "rtmp://ignored.example.com/app"
+	cfg.Upstreams = []config.UpstreamEndpoint{{URL: "rtmp://a.example.com/app"}}
+	cfg.Routes = []config.RouteConfig{{Match: "live/*", Upstreams: []config.UpstreamEndpoint{
+		{URL: "rtmp://a.example.com/app"}, {URL: "rtmp://b.example.com/app"},
+	}}}
+	cfg.Tenants = []config.TenantConfig{{App: "acme", Upstreams: []config.UpstreamEndpoint{{URL: "rtmps://c.example.com/app"}}}}
+
+	got := fmt.Sprint(Upstreams(cfg))
+	want := "[{upstreams[0] rtmp://a.example.com/app} {routes[0].upstreams[1] rtmp://b.example.com/app} {tenants[0].upstreams[0] rtmps://c.example.com/app}]"
+	if got != want {
+		t.Fatalf("Upstreams = %s, want %s", got, want)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	sec := config.SecurityConfig{TLSEnabled: true, TLSCert: certFile, TLSKey: keyFile}
+
+	if c := checkTLS(sec, now); c.Status != StatusOK || !strings.Contains(c.Detail, "relay.example.com") {
+		t.Fatalf("valid pair = %+v", c)
+	}
+	// Within the default 30 day warning window.
+	if c := checkTLS(sec, now.Add(70*24*time.Hour)); c.Status != StatusWarn {
+		t.Fatalf("expiring pair = %+v", c)
+	}
+	if c := checkTLS(sec, now.Add(100*24*time.Hour)); c.Status != StatusFail || !strings.Contains(c.Detail, "expired") {
+		t.Fatalf("expired pair = %+v", c)
+	}
+
+	missing := sec
+	missing.TLSKey = filepath.Join(dir, "missing.pem")
+	if c := checkTLS(missing, now); c.Status != StatusFail || !strings.Contains(c.Detail, "missing.pem") {
+		t.Fatalf("missing key = %+v", c)
+	}
+	swapped := sec
+	swapped.TLSCert, swapped.TLSKey = keyFile, certFile
+	if c := checkTLS(swapped, now); c.Status != StatusFail {
+		t.Fatalf("swapped files = %+v", c)
+	}
+}
+
+func writeKeyPair(t *testing.T, dir string, notBefore, notAfter time.Time) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
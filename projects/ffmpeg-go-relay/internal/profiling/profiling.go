@@ -0,0 +1,146 @@
+package profiling
+
+import (
+	"math/rand"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// Session phases recorded by the relay hot path.
+const (
+	PhaseParse     = "parse"
+	PhaseCopy      = "copy"
+	PhaseTranscode = "transcode"
+)
+
+// heapAllocsMetric is the cumulative heap allocation counter exposed by runtime/metrics.
+const heapAllocsMetric = "/gc/heap/allocs:bytes"
+
+// Observer receives the results of a sampled session profile.
+type Observer interface {
+	ObservePhase(phase string, d time.Duration)
+	ObserveAllocBytes(bytes uint64)
+}
+
+// Sampler decides which sessions are profiled.
+type Sampler struct {
+	mu       sync.Mutex
+	rate     float64
+	rng      *rand.Rand
+	observer Observer
+}
+
+// NewSampler creates a sampler that profiles the given fraction of sessions (0-1).
+func NewSampler(rate float64, observer Observer) *Sampler {
+	if rate < 0 {
+		rate = 0
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	return &Sampler{
+		rate:     rate,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		observer: observer,
+	}
+}
+
+// Rate returns the configured sample rate.
+func (s *Sampler) Rate() float64 {
+	if s == nil {
+		return 0
+	}
+	return s.rate
+}
+
+// Start begins profiling a session if it is selected by the sampler.
+// Returns nil when the session is not sampled; all Session methods are nil-safe.
+func (s *Sampler) Start() *Session {
+	if s == nil || s.rate <= 0 || s.observer == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	sampled := s.rate >= 1 || s.rng.Float64() < s.rate
+	s.mu.Unlock()
+	if !sampled {
+		return nil
+	}
+
+	return &Session{
+		observer:    s.observer,
+		phases:      make(map[string]time.Duration),
+		startAllocs: readHeapAllocs(),
+	}
+}
+
+// Session accumulates per-phase timings for a single relay session.
+type Session struct {
+	mu          sync.Mutex
+	observer    Observer
+	phases      map[string]time.Duration
+	startAllocs uint64
+	ended       bool
+}
+
+// Track starts timing a phase and returns a function that stops it.
+// The stop function is idempotent so it can be both deferred and called early.
+func (p *Session) Track(phase string) func() {
+	if p == nil {
+		return func() {}
+	}
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.Add(phase, time.Since(start))
+		})
+	}
+}
+
+// Add accumulates time spent in a phase.
+func (p *Session) Add(phase string, d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.phases[phase] += d
+	p.mu.Unlock()
+}
+
+// End reports accumulated phase timings and the heap allocation delta.
+// Allocations are read from the process-wide runtime counter, so concurrent
+// sessions inflate each other's figures; treat them as an upper bound.
+func (p *Session) End() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.ended = true
+	phases := make(map[string]time.Duration, len(p.phases))
+	for phase, d := range p.phases {
+		phases[phase] = d
+	}
+	p.mu.Unlock()
+
+	for phase, d := range phases {
+		p.observer.ObservePhase(phase, d)
+	}
+	if end := readHeapAllocs(); end >= p.startAllocs {
+		p.observer.ObserveAllocBytes(end - p.startAllocs)
+	}
+}
+
+func readHeapAllocs() uint64 {
+	sample := []metrics.Sample{{Name: heapAllocsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
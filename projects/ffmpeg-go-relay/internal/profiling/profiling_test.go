@@ -0,0 +1,86 @@
+package profiling
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	phases map[string]time.Duration
+	allocs []uint64
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{phases: make(map[string]time.Duration)}
+}
+
+func (r *recordingObserver) ObservePhase(phase string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phases[phase] += d
+}
+
+func (r *recordingObserver) ObserveAllocBytes(bytes uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allocs = append(r.allocs, bytes)
+}
+
+func TestSamplerZeroRateNeverSamples(t *testing.T) {
+	s := NewSampler(0, newRecordingObserver())
+	for i := 0; i < 100; i++ {
+		if s.Start() != nil {
+			t.Fatal("expected no sampled sessions at rate 0")
+		}
+	}
+}
+
+func TestSamplerFullRateAlwaysSamples(t *testing.T) {
+	s := NewSampler(1, newRecordingObserver())
+	for i := 0; i < 100; i++ {
+		if s.Start() == nil {
+			t.Fatal("expected every session to be sampled at rate 1")
+		}
+	}
+}
+
+func TestSamplerClampsRate(t *testing.T) {
+	if got := NewSampler(5, nil).Rate(); got != 1 {
+		t.Fatalf("rate = %v, want 1", got)
+	}
+	if got := NewSampler(-1, nil).Rate(); got != 0 {
+		t.Fatalf("rate = %v, want 0", got)
+	}
+}
+
+func TestSessionReportsPhasesOnce(t *testing.T) {
+	obs := newRecordingObserver()
+	p := NewSampler(1, obs).Start()
+
+	p.Add(PhaseParse, 10*time.Millisecond)
+	p.Add(PhaseParse, 5*time.Millisecond)
+	stop := p.Track(PhaseCopy)
+	stop()
+
+	p.End()
+	p.End()
+
+	if obs.phases[PhaseParse] != 15*time.Millisecond {
+		t.Fatalf("parse = %v, want 15ms", obs.phases[PhaseParse])
+	}
+	if _, ok := obs.phases[PhaseCopy]; !ok {
+		t.Fatal("expected copy phase to be reported")
+	}
+	if len(obs.allocs) != 1 {
+		t.Fatalf("alloc observations = %d, want 1", len(obs.allocs))
+	}
+}
+
+func TestNilSessionIsSafe(t *testing.T) {
+	var p *Session
+	p.Add(PhaseParse, time.Second)
+	p.Track(PhaseCopy)()
+	p.End()
+}
@@ -0,0 +1,58 @@
+// Package qos marks relay sockets with a DSCP class so network gear can
+// prioritize media traffic.
+package qos
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// MaxDSCP is the largest DSCP class; the field is six bits.
+const MaxDSCP = 63
+
+// SetDSCP marks the TCP socket under conn, looking through TLS, with dscp.
+// The IPv4 TOS byte or IPv6 traffic class carries it in its upper six bits.
+// A dscp of 0 and connections that are not TCP are left alone.
+func SetDSCP(conn net.Conn, dscp int) error {
+	if dscp == 0 {
+		return nil
+	}
+	if dscp < 0 || dscp > MaxDSCP {
+		return fmt.Errorf("dscp %d out of range 0-%d", dscp, MaxDSCP)
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	ipv6 := false
+	if addr, ok := tcp.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+	return setTrafficClass(tcp, ipv6, dscp<<2)
+}
+
+// Listener marks every connection l accepts with dscp. A connection that
+// cannot be marked is still returned, unmarked.
+func Listener(l net.Listener, dscp int) net.Listener {
+	if dscp == 0 {
+		return l
+	}
+	return &listener{Listener: l, dscp: dscp}
+}
+
+type listener struct {
+	net.Listener
+	dscp int
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		_ = SetDSCP(conn, l.dscp)
+	}
+	return conn, err
+}
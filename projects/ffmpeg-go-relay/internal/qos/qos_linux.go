@@ -0,0 +1,30 @@
+//go:build linux
+
+package qos
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported reports whether sockets can be marked on this platform.
+const Supported = true
+
+func setTrafficClass(tcp *net.TCPConn, ipv6 bool, tos int) error {
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
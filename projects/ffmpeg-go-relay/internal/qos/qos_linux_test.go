@@ -0,0 +1,67 @@
+//go:build linux
+
+package qos
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func tos(t *testing.T, conn net.Conn) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		v, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return v
+}
+
+func TestListenerAndSetDSCP(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked := Listener(ln, 46)
+	defer marked.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := marked.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// EF (46) is TOS byte 0xb8.
+	if got := tos(t, server); got != 0xb8 {
+		t.Fatalf("accepted TOS = %#x, want 0xb8", got)
+	}
+	if err := SetDSCP(client, 34); err != nil {
+		t.Fatal(err)
+	}
+	if got := tos(t, client); got != 34<<2 {
+		t.Fatalf("dialed TOS = %#x, want %#x", got, 34<<2)
+	}
+	if err := SetDSCP(client, 64); err == nil {
+		t.Fatal("expected an error for dscp 64")
+	}
+}
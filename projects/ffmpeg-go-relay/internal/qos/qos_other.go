@@ -0,0 +1,15 @@
+//go:build !linux
+
+package qos
+
+import (
+	"errors"
+	"net"
+)
+
+// Supported reports whether sockets can be marked on this platform.
+const Supported = false
+
+func setTrafficClass(tcp *net.TCPConn, ipv6 bool, tos int) error {
+	return errors.New("DSCP marking is only supported on Linux")
+}
@@ -0,0 +1,159 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	defaultDriftThreshold = 500 * time.Millisecond
+	defaultGapThreshold   = time.Second
+
+	// gapLogInterval limits gap warnings per session; gaps in between are
+	// summed into the next warning.
+	gapLogInterval = 10 * time.Second
+)
+
+// avSync watches one session's media timestamps for drift between audio and
+// video and for gaps within each track. Drift is the distance between the
+// latest timestamps of the two tracks, so it carries up to a frame of
+// interleaving jitter. Like the media taps it is fed from the session's
+// reader while the session goroutine closes it. A nil avSync ignores
+// everything.
+type avSync struct {
+	reg            *metrics.Registry
+	log            *logger.Logger
+	driftThreshold int64 // Milliseconds, like RTMP timestamps
+	gapThreshold   int64
+
+	mu           sync.Mutex
+	stream       string
+	audio, video trackClock
+	drifting     bool
+	lastGapLog   time.Time
+	unloggedGaps int
+}
+
+// trackClock is the latest timestamp of one track.
+type trackClock struct {
+	last uint32
+	seen bool
+}
+
+// newAVSync returns a monitor for a session, or nil when av_sync is off.
+func (s *Server) newAVSync(log *logger.Logger) *avSync {
+	if !s.AVSync.Enabled {
+		return nil
+	}
+	drift, gap := s.AVSync.DriftThreshold.AsDuration(), s.AVSync.GapThreshold.AsDuration()
+	if drift <= 0 {
+		drift = defaultDriftThreshold
+	}
+	if gap <= 0 {
+		gap = defaultGapThreshold
+	}
+	return &avSync{
+		reg:            s.Metrics,
+		log:            log,
+		driftThreshold: drift.Milliseconds(),
+		gapThreshold:   gap.Milliseconds(),
+	}
+}
+
+// setStream starts watching a newly published stream from scratch.
+func (a *avSync) setStream(name string) {
+	if a == nil {
+		return
+	}
+	name = stripStreamQuery(name)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if name == a.stream {
+		return
+	}
+	a.resetLocked()
+	a.stream = name
+}
+
+func (a *avSync) observe(msg *rtmp.Message) {
+	if a == nil {
+		return
+	}
+	var track string
+	switch {
+	case msg.Header.TypeID == rtmp.TypeAudio && !msg.IsAACSequenceHeader():
+		track = "audio"
+	case msg.Header.TypeID == rtmp.TypeVideo && !msg.IsVideoSequenceHeader():
+		track = "video"
+	default:
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stream == "" {
+		return
+	}
+	clock := &a.audio
+	if track == "video" {
+		clock = &a.video
+	}
+	ts := msg.Header.Timestamp
+	if clock.seen {
+		// Subtracting as uint32 survives the timestamp wrapping around.
+		if gap := int64(int32(ts - clock.last)); gap > 0 {
+			long := gap > a.gapThreshold
+			a.reg.ObserveFrameGap(a.stream, track, float64(gap)/1000, long)
+			if long {
+				a.logGapLocked(track, gap)
+			}
+		}
+	}
+	clock.last, clock.seen = ts, true
+
+	if !a.audio.seen || !a.video.seen {
+		return
+	}
+	drift := int64(int32(a.video.last - a.audio.last))
+	a.reg.SetAVDrift(a.stream, float64(drift)/1000)
+	over := drift > a.driftThreshold || -drift > a.driftThreshold
+	switch {
+	case over && !a.drifting:
+		a.log.Warn("audio and video drifted apart", "stream", a.stream, "drift_ms", drift, "threshold_ms", a.driftThreshold)
+	case !over && a.drifting:
+		a.log.Info("audio and video back in sync", "stream", a.stream, "drift_ms", drift)
+	}
+	a.drifting = over
+}
+
+func (a *avSync) logGapLocked(track string, gapMS int64) {
+	now := time.Now()
+	if now.Sub(a.lastGapLog) < gapLogInterval {
+		a.unloggedGaps++
+		return
+	}
+	a.log.Warn("gap between frames", "stream", a.stream, "track", track, "gap_ms", gapMS, "threshold_ms", a.gapThreshold, "gaps_since_last_warning", a.unloggedGaps)
+	a.lastGapLog, a.unloggedGaps = now, 0
+}
+
+func (a *avSync) resetLocked() {
+	if a.stream != "" {
+		a.reg.DeleteAVSync(a.stream)
+	}
+	a.stream = ""
+	a.audio, a.video = trackClock{}, trackClock{}
+	a.drifting = false
+}
+
+// close drops the stream's sync series.
+func (a *avSync) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.resetLocked()
+}
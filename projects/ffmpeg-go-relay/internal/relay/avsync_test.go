@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func mediaMessage(typeID uint8, ts uint32) *rtmp.Message {
+	payload := []byte{0x27, 0x01} // Video inter frame
+	if typeID == rtmp.TypeAudio {
+		payload = []byte{0xaf, 0x01} // AAC raw frame
+	}
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: typeID, Timestamp: ts}, Payload: payload}
+}
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) (float64, bool) {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) > 0 {
+			return f.GetMetric()[0].GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func TestAVSyncDriftAndGaps(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewRegistry(reg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	srv := &Server{Metrics: m, AVSync: config.AVSyncConfig{
+		Enabled:        true,
+		DriftThreshold: config.Duration(200 * time.Millisecond),
+		GapThreshold:   config.Duration(500 * time.Millisecond),
+	}}
+	avs := srv.newAVSync(logger.NewWithWriter(&logs))
+
+	// Media before the publish is not attributed to any stream.
+	avs.observe(mediaMessage(rtmp.TypeVideo, 0))
+	avs.setStream("cam1?key=x")
+	for ts := uint32(0); ts <= 1000; ts += 40 {
+		avs.observe(mediaMessage(rtmp.TypeVideo, ts))
+		avs.observe(mediaMessage(rtmp.TypeAudio, ts))
+	}
+	if drift, ok := gaugeValue(t, reg, "rtmp_relay_av_drift_seconds"); !ok || drift != 0 {
+		t.Fatalf("drift = %v (%v), want 0", drift, ok)
+	}
+
+	// Audio stalls for 800ms while video carries on.
+	for ts := uint32(1040); ts <= 1800; ts += 40 {
+		avs.observe(mediaMessage(rtmp.TypeVideo, ts))
+	}
+	avs.observe(mediaMessage(rtmp.TypeAudio, 1800))
+	if !strings.Contains(logs.String(), "audio and video drifted apart") {
+		t.Fatalf("no drift warning in %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), `"track":"audio","gap_ms":800`) {
+		t.Fatalf("no audio gap warning in %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "audio and video back in sync") {
+		t.Fatalf("no recovery in %s", logs.String())
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var longGaps float64
+	for _, f := range families {
+		if f.GetName() == "rtmp_relay_long_frame_gaps_total" {
+			for _, metric := range f.GetMetric() {
+				longGaps += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	if longGaps != 1 {
+		t.Fatalf("long gaps = %v, want 1", longGaps)
+	}
+
+	avs.close()
+	if _, ok := gaugeValue(t, reg, "rtmp_relay_av_drift_seconds"); ok {
+		t.Fatal("drift series left behind after close")
+	}
+}
+
+func TestAVSyncDisabled(t *testing.T) {
+	srv := &Server{}
+	avs := srv.newAVSync(nil)
+	if avs != nil {
+		t.Fatal("expected nil monitor when av_sync is off")
+	}
+	avs.setStream("cam1")
+	avs.observe(mediaMessage(rtmp.TypeVideo, 0))
+	avs.close()
+}
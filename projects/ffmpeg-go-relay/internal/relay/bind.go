@@ -0,0 +1,43 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+)
+
+// SourceBind pins upstream connections to a local address or network
+// interface, for relay hosts with more than one uplink.
+type SourceBind struct {
+	IP        net.IP // Local address dialed from; nil lets the OS choose
+	Interface string // Device the socket is bound to; empty lets routing choose
+}
+
+// NewSourceBind parses a configured source IP and interface, either of
+// which may be empty.
+func NewSourceBind(sourceIP, iface string) (SourceBind, error) {
+	var b SourceBind
+	if sourceIP != "" {
+		if b.IP = net.ParseIP(sourceIP); b.IP == nil {
+			return SourceBind{}, fmt.Errorf("invalid source IP %q", sourceIP)
+		}
+	}
+	if iface != "" {
+		if !bindToDeviceSupported {
+			return SourceBind{}, fmt.Errorf("binding to interface %q is only supported on Linux", iface)
+		}
+		b.Interface = iface
+	}
+	return b, nil
+}
+
+// dialer returns a dialer that connects from b.
+func (b SourceBind) dialer() *net.Dialer {
+	d := &net.Dialer{}
+	if b.IP != nil {
+		d.LocalAddr = &net.TCPAddr{IP: b.IP}
+	}
+	if b.Interface != "" {
+		d.Control = bindToDevice(b.Interface)
+	}
+	return d
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package relay
+
+import (
+	"syscall"
+)
+
+const bindToDeviceSupported = true
+
+// bindToDevice sets SO_BINDTODEVICE so the socket only sends and receives
+// through iface.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package relay
+
+import (
+	"syscall"
+)
+
+// SO_BINDTODEVICE is Linux-only; NewSourceBind refuses interfaces elsewhere.
+const bindToDeviceSupported = false
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
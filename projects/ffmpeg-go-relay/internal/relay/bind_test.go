@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestNewSourceBindRejectsBadIP(t *testing.T) {
+	if _, err := NewSourceBind("not-an-ip", ""); err == nil {
+		t.Fatal("expected an error for an invalid source IP")
+	}
+	b, err := NewSourceBind("", "")
+	if err != nil || b.IP != nil || b.Interface != "" {
+		t.Fatalf("empty bind = %+v, %v", b, err)
+	}
+}
+
+func TestUpstreamDialsFromSourceIP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{{
+		URL:  "rtmp://" + ln.Addr().String() + "/live",
+		Bind: &config.UpstreamBindConfig{SourceIP: "127.0.0.1"},
+	}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := pool.Pick()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := srv.dialUpstreamOnce(ctx, info)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if local := conn.LocalAddr().(*net.TCPAddr); !local.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("dialed from %v", local)
+	}
+	if remote := (<-accepted).(*net.TCPAddr); !remote.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("upstream saw %v", remote)
+	}
+
+	if _, err := NewUpstreamPool([]config.UpstreamEndpoint{{
+		URL:  "rtmp://" + ln.Addr().String() + "/live",
+		Bind: &config.UpstreamBindConfig{SourceIP: "256.0.0.1"},
+	}}, ""); err == nil {
+		t.Fatal("expected an error for an invalid bind source_ip")
+	}
+}
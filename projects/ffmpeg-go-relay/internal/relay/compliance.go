@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"sort"
+	"sync"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// maxFinishedReports bounds how many ended sessions keep their compliance
+// report for the admin API.
+const maxFinishedReports = 100
+
+// SessionCompliance is the strict-mode report for one session.
+type SessionCompliance struct {
+	RequestID string `json:"request_id"`
+	Active    bool   `json:"active"`
+	rtmp.ComplianceReport
+}
+
+var compliance = struct {
+	sync.Mutex
+	active   map[string]*rtmp.Validator
+	finished []SessionCompliance // Oldest first
+}{active: make(map[string]*rtmp.Validator)}
+
+// ComplianceReports returns reports for sessions still running and the most
+// recent finished ones, newest last. Empty unless strict mode is on.
+func ComplianceReports() []SessionCompliance {
+	compliance.Lock()
+	defer compliance.Unlock()
+	out := append([]SessionCompliance(nil), compliance.finished...)
+	ids := make([]string, 0, len(compliance.active))
+	for id := range compliance.active {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		out = append(out, SessionCompliance{RequestID: id, Active: true, ComplianceReport: compliance.active[id].Report()})
+	}
+	return out
+}
+
+// ComplianceReportFor returns the report for one session, if it is known.
+func ComplianceReportFor(requestID string) (SessionCompliance, bool) {
+	compliance.Lock()
+	defer compliance.Unlock()
+	if v, ok := compliance.active[requestID]; ok {
+		return SessionCompliance{RequestID: requestID, Active: true, ComplianceReport: v.Report()}, true
+	}
+	for _, r := range compliance.finished {
+		if r.RequestID == requestID {
+			return r, true
+		}
+	}
+	return SessionCompliance{}, false
+}
+
+func trackCompliance(requestID string, v *rtmp.Validator) {
+	compliance.Lock()
+	defer compliance.Unlock()
+	compliance.active[requestID] = v
+}
+
+// finishCompliance moves a session's report to the finished list and
+// returns it.
+func finishCompliance(requestID string) (rtmp.ComplianceReport, bool) {
+	compliance.Lock()
+	defer compliance.Unlock()
+	v, ok := compliance.active[requestID]
+	if !ok {
+		return rtmp.ComplianceReport{}, false
+	}
+	delete(compliance.active, requestID)
+	report := v.Report()
+	if len(compliance.finished) >= maxFinishedReports {
+		copy(compliance.finished, compliance.finished[1:])
+		compliance.finished = compliance.finished[:len(compliance.finished)-1]
+	}
+	compliance.finished = append(compliance.finished, SessionCompliance{RequestID: requestID, ComplianceReport: report})
+	return report, true
+}
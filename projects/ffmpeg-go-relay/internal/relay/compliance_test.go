@@ -0,0 +1,40 @@
+package relay
+
+import (
+	"fmt"
+	"testing"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestComplianceReportsKeepRecentFinished(t *testing.T) {
+	v := rtmp.NewValidator()
+	v.Observe(&rtmp.Message{Header: rtmp.ChunkHeader{CSID: 3, TypeID: rtmp.TypeVideo}})
+	trackCompliance("live-session", v)
+	defer finishCompliance("live-session")
+
+	report, ok := ComplianceReportFor("live-session")
+	if !ok || !report.Active || report.Compliant {
+		t.Fatalf("report = %+v, %v; want an active non-compliant report", report, ok)
+	}
+
+	for i := 0; i < maxFinishedReports+5; i++ {
+		id := fmt.Sprintf("done-%d", i)
+		trackCompliance(id, rtmp.NewValidator())
+		if _, ok := finishCompliance(id); !ok {
+			t.Fatalf("finishCompliance(%s) found nothing", id)
+		}
+	}
+	if _, ok := ComplianceReportFor("done-0"); ok {
+		t.Fatal("expected the oldest finished report to be evicted")
+	}
+	last, ok := ComplianceReportFor(fmt.Sprintf("done-%d", maxFinishedReports+4))
+	if !ok || last.Active || !last.Compliant {
+		t.Fatalf("newest finished report = %+v, %v", last, ok)
+	}
+
+	all := ComplianceReports()
+	if len(all) != maxFinishedReports+1 || !all[len(all)-1].Active {
+		t.Fatalf("got %d reports, want %d finished then the active one", len(all), maxFinishedReports)
+	}
+}
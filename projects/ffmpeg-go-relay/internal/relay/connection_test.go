@@ -1,8 +1,18 @@
 package relay
 
 import (
+	"context"
+	"io"
+	"net"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/transcoder"
 )
 
 func TestActiveConnectionTracking(t *testing.T) {
@@ -46,9 +56,144 @@ func TestActiveConnectionTracking(t *testing.T) {
 	}
 }
 
+func TestLookupStream(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+
+	trackConnectionStart(ConnectionInfo{RequestID: "req-stream", StartTime: time.Now(), State: "relaying"})
+	if _, ok := LookupStream("live"); ok {
+		t.Fatal("found a stream before publish")
+	}
+	updateConnectionStream("req-stream", "live?token=secret")
+	info, ok := LookupStream("live")
+	if !ok || info.RequestID != "req-stream" {
+		t.Fatalf("LookupStream(live) = %+v, %v", info, ok)
+	}
+	if info.Stream != "live" {
+		t.Fatalf("stream = %q, want the query string stripped", info.Stream)
+	}
+	trackConnectionEnd("req-stream")
+	if _, ok := LookupStream("live"); ok {
+		t.Fatal("stream still found after the session ended")
+	}
+}
+
+// progressSink is a transcoder backend that reports fixed progress.
+type progressSink struct{ io.WriteCloser }
+
+func (progressSink) Progress() (transcoder.Progress, bool) {
+	return transcoder.Progress{Frames: 300, FPS: 30, Speed: 1}, true
+}
+
+func TestTrackProgress(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+	defer func(d time.Duration) { progressInterval = d }(progressInterval)
+	progressInterval = time.Millisecond
+
+	sink := &flvSink{tr: progressSink{}, url: "rtmp://origin/live/out"}
+	transcodeOutputs.Store(sink.url, sink)
+	defer transcodeOutputs.Delete(sink.url)
+
+	trackConnectionStart(ConnectionInfo{RequestID: "req-progress", StartTime: time.Now(), State: "relaying"})
+	srv := &Server{}
+	stop := srv.trackProgress("req-progress", "live?token=secret", sink.url)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, _ := activeConnections.Load("req-progress")
+		if p := info.(ConnectionInfo).Transcode; p != nil {
+			if p.Frames != 300 || p.FPS != 30 {
+				t.Fatalf("progress = %+v", *p)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("progress never reached the session registry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	// Once stopped, the poller must not bring the ended session back.
+	trackConnectionEnd("req-progress")
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := activeConnections.Load("req-progress"); ok {
+		t.Fatal("session re-registered after stop")
+	}
+}
+
 func clearActiveConnections() {
 	activeConnections.Range(func(key, value any) bool {
 		activeConnections.Delete(key)
 		return true
 	})
 }
+
+func TestKillSession(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+
+	if KillSession("missing") {
+		t.Fatal("expected KillSession to report an unknown session")
+	}
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewRegistry(reg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Log: logger.NewWithWriter(io.Discard), Metrics: m}
+	client, conn := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeConn(context.Background(), conn) }()
+
+	var requestID string
+	for deadline := time.Now().Add(2 * time.Second); requestID == "" && time.Now().Before(deadline); {
+		if conns := GetActiveConnectionsList(); len(conns) == 1 {
+			requestID = conns[0].RequestID
+		} else {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	if !KillSession(requestID) {
+		t.Fatalf("KillSession(%q) found no session", requestID)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("session did not end after being killed")
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "rtmp_relay_session_completions_total" {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == ReasonAdminKill && metric.GetCounter().GetValue() == 1 {
+				return
+			}
+		}
+	}
+	t.Fatal("expected the session to end with reason admin_kill")
+}
+
+func TestTrackVideoCodec(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+	trackConnectionStart(ConnectionInfo{RequestID: "req-codec"})
+
+	track := trackVideoCodec("req-codec")
+	track(&rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAudio}, Payload: []byte{0xaf, 0}})
+	track(&rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo}, Payload: []byte{0x90, 'a', 'v', '0', '1'}})
+	track(&rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo}, Payload: []byte{0x17, 0, 0, 0, 0}})
+
+	info, _ := activeConnections.Load("req-codec")
+	if codec := info.(ConnectionInfo).VideoCodec; codec != "av1" {
+		t.Fatalf("VideoCodec = %q, want the first video message's av1", codec)
+	}
+}
@@ -0,0 +1,185 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/events"
+)
+
+// ConnState is where a session is in its life. Sessions move forward
+// through the states in order, and to StateClosing from any of them.
+type ConnState string
+
+const (
+	StateConnecting  ConnState = "connecting"  // Accepted; rate and connection limits
+	StateHandshaking ConnState = "handshaking" // RTMP handshake and connect command
+	StateDialing     ConnState = "dialing"     // Connect accepted; opening the upstream or transcoder
+	StateRelaying    ConnState = "relaying"    // Media flows
+	StateClosing     ConnState = "closing"     // The relay ended; tearing down
+)
+
+// stateTransitions lists the states each state may move to.
+var stateTransitions = map[ConnState][]ConnState{
+	StateConnecting:  {StateHandshaking, StateClosing},
+	StateHandshaking: {StateDialing, StateClosing},
+	StateDialing:     {StateRelaying, StateClosing},
+	StateRelaying:    {StateClosing},
+}
+
+// canEnter reports whether a session in c may move to next.
+func (c ConnState) canEnter(next ConnState) bool {
+	for _, s := range stateTransitions[c] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrStateTimeout is wrapped by the error of a session that stayed in a
+// state past its StateTimeouts entry.
+var ErrStateTimeout = errors.New("session state timed out")
+
+// StateTimeouts bound how long a session may stay in a state, however busy
+// its sockets are. Zero leaves a state unbounded.
+type StateTimeouts struct {
+	Handshaking time.Duration
+	Dialing     time.Duration
+	Relaying    time.Duration // Longest a session may relay
+}
+
+func (t StateTimeouts) of(state ConnState) time.Duration {
+	switch state {
+	case StateHandshaking:
+		return t.Handshaking
+	case StateDialing:
+		return t.Dialing
+	case StateRelaying:
+		return t.Relaying
+	}
+	return 0
+}
+
+// StateEntry is a state a session entered and when.
+type StateEntry struct {
+	State   ConnState `json:"state"`
+	Entered time.Time `json:"entered"`
+}
+
+type sessionStateKey struct{}
+
+// sessionState is the state machine of one session. It records when the
+// session entered each state, ends the session through expire when it
+// overstays one, and reports every transition to metrics and events.
+type sessionState struct {
+	s         *Server
+	requestID string
+	expire    func() // Ends the session; called once, on the first timeout
+
+	mu       sync.Mutex
+	current  ConnState
+	entered  time.Time
+	timer    *time.Timer
+	timedOut error
+	done     bool
+}
+
+// newSessionState starts the state machine of session requestID in
+// StateConnecting.
+func (s *Server) newSessionState(requestID string, expire func()) *sessionState {
+	st := &sessionState{s: s, requestID: requestID, expire: expire, current: StateConnecting, entered: time.Now()}
+	s.Metrics.RecordSessionState("", string(StateConnecting), 0)
+	return st
+}
+
+func contextWithSessionState(ctx context.Context, st *sessionState) context.Context {
+	return context.WithValue(ctx, sessionStateKey{}, st)
+}
+
+// setState moves ctx's session to next. Entering StateRelaying also moves
+// its sockets to the steady-state idle timeouts.
+func (s *Server) setState(ctx context.Context, next ConnState) {
+	if next == StateRelaying {
+		markRelaying(ctx)
+	}
+	st, ok := ctx.Value(sessionStateKey{}).(*sessionState)
+	if !ok {
+		return
+	}
+	if err := st.enter(next); err != nil {
+		s.logger(ctx).Warn("invalid session state transition", "err", err)
+	}
+}
+
+// enter moves the session to next, arming next's timeout.
+func (st *sessionState) enter(next ConnState) error {
+	st.mu.Lock()
+	from := st.current
+	if st.done || !from.canEnter(next) {
+		st.mu.Unlock()
+		return fmt.Errorf("session %s cannot move from %s to %s", st.requestID, from, next)
+	}
+	now := time.Now()
+	spent := now.Sub(st.entered)
+	st.current, st.entered = next, now
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	if timeout := st.s.StateTimeouts.of(next); timeout > 0 {
+		st.timer = time.AfterFunc(timeout, func() { st.overstayed(next, now, timeout) })
+	}
+	st.mu.Unlock()
+
+	st.s.Metrics.RecordSessionState(string(from), string(next), spent)
+	updateConnectionState(st.requestID, next)
+	if st.s.Events != nil {
+		e := sessionEvent(events.TypeSessionState, st.requestID)
+		e.From = string(from)
+		st.s.Events.Publish(e)
+	}
+	return nil
+}
+
+// overstayed ends the session if it is still in the state it entered at
+// entered.
+func (st *sessionState) overstayed(state ConnState, entered time.Time, timeout time.Duration) {
+	st.mu.Lock()
+	if st.done || st.current != state || !st.entered.Equal(entered) {
+		st.mu.Unlock()
+		return
+	}
+	st.timedOut = fmt.Errorf("%w: %s for %v", ErrStateTimeout, state, timeout)
+	st.mu.Unlock()
+
+	st.s.Metrics.RecordSessionStateTimeout(string(state))
+	st.s.Log.Warn("session state timed out", "request_id", st.requestID, "state", string(state), "timeout", timeout)
+	st.expire()
+}
+
+// err returns the timeout that ended the session, if one did.
+func (st *sessionState) err() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.timedOut
+}
+
+// finish stops the machine once the session has ended.
+func (st *sessionState) finish() {
+	st.mu.Lock()
+	if st.done {
+		st.mu.Unlock()
+		return
+	}
+	st.done = true
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	from, spent := st.current, time.Since(st.entered)
+	st.mu.Unlock()
+	st.s.Metrics.RecordSessionState(string(from), "", spent)
+}
@@ -0,0 +1,75 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+func TestSessionStateTransitions(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+	srv := &Server{Log: logger.NewWithWriter(io.Discard)}
+	trackConnectionStart(ConnectionInfo{RequestID: "req-states", State: StateConnecting})
+	st := srv.newSessionState("req-states", func() { t.Error("session expired without a timeout") })
+
+	if err := st.enter(StateRelaying); err == nil {
+		t.Fatal("connecting moved straight to relaying")
+	}
+	for _, next := range []ConnState{StateHandshaking, StateDialing, StateRelaying, StateClosing} {
+		if err := st.enter(next); err != nil {
+			t.Fatalf("enter %s: %v", next, err)
+		}
+	}
+	if err := st.enter(StateRelaying); err == nil {
+		t.Fatal("closing moved back to relaying")
+	}
+	st.finish()
+	if err := st.enter(StateClosing); err == nil {
+		t.Fatal("finished session moved on")
+	}
+
+	var info ConnectionInfo
+	for _, conn := range GetActiveConnectionsList() {
+		if conn.RequestID == "req-states" {
+			info = conn
+		}
+	}
+	var got []ConnState
+	for _, e := range info.States {
+		got = append(got, e.State)
+	}
+	if info.State != StateClosing || len(got) != 4 || got[0] != StateHandshaking || got[3] != StateClosing {
+		t.Fatalf("tracked state %s, history %v", info.State, got)
+	}
+	if st.err() != nil {
+		t.Fatalf("err = %v, want none", st.err())
+	}
+}
+
+func TestStateTimeoutKillsSession(t *testing.T) {
+	srv := &Server{
+		Upstream:      "rtmp://127.0.0.1:1/live",
+		StateTimeouts: StateTimeouts{Handshaking: 20 * time.Millisecond},
+		Log:           logger.NewWithWriter(io.Discard),
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- srv.handle(context.Background(), server) }()
+
+	// The client never handshakes.
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStateTimeout) || terminationReason(err, "") != ReasonStateTimeout {
+			t.Fatalf("session ended with %v, want a %s", err, ReasonStateTimeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("session outlived its handshake timeout")
+	}
+}
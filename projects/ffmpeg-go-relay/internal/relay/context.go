@@ -0,0 +1,42 @@
+package relay
+
+import (
+	"context"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
+
+// ContextWithLogger returns a copy of ctx that carries log. Sessions started
+// with such a context (through Run or ServeConn) log through it instead of
+// Server.Log, so fields an embedder attaches, such as a trace or tenant ID,
+// appear on every log line of those sessions.
+func ContextWithLogger(ctx context.Context, log *logger.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFromContext returns the logger carried by ctx, or nil if there is none.
+// Inside a session it includes the request_id and client fields.
+func LoggerFromContext(ctx context.Context) *logger.Logger {
+	log, _ := ctx.Value(loggerKey{}).(*logger.Logger)
+	return log
+}
+
+// RequestIDFromContext returns the request_id of the session ctx belongs
+// to, or "" outside a session.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logger returns the context's logger, falling back to s.Log.
+func (s *Server) logger(ctx context.Context) *logger.Logger {
+	if log := LoggerFromContext(ctx); log != nil {
+		return log
+	}
+	return s.Log
+}
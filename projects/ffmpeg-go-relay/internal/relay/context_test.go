@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// syncBuffer guards a bytes.Buffer shared between the session and the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	if LoggerFromContext(context.Background()) != nil {
+		t.Fatal("expected no logger in a bare context")
+	}
+	log := logger.New()
+	if got := LoggerFromContext(ContextWithLogger(context.Background(), log)); got != log {
+		t.Fatal("expected the attached logger back")
+	}
+}
+
+func TestSessionLogsUseContextLogger(t *testing.T) {
+	var out syncBuffer
+	embedder := logger.NewWithWriter(&out).With("trace_id", "abc123")
+	srv := &Server{Log: logger.NewWithWriter(&syncBuffer{})}
+
+	client, conn := net.Pipe()
+	client.Close() // The session ends during the handshake
+	_ = srv.ServeConn(ContextWithLogger(context.Background(), embedder), conn)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected session logs through the context logger, got %q", out.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `"trace_id":"abc123"`) || !strings.Contains(line, `"request_id"`) {
+			t.Fatalf("log line missing embedder or session fields: %s", line)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Credential is an upstream credential ready to apply to a connect command.
+type Credential struct {
+	Name  string
+	Query url.Values
+}
+
+// errUpstreamAuthRejected ends sessions whose connect the upstream refused
+// with every configured credential.
+var errUpstreamAuthRejected = errors.New("upstream rejected every configured credential")
+
+func newCredentials(creds []config.UpstreamCredential) ([]Credential, error) {
+	out := make([]Credential, 0, len(creds))
+	for i, c := range creds {
+		query, err := url.ParseQuery(c.Query)
+		if err != nil {
+			return nil, fmt.Errorf("credential %d: %w", i+1, err)
+		}
+		name := c.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		out = append(out, Credential{Name: name, Query: query})
+	}
+	return out, nil
+}
+
+// withCredential returns a copy of a decoded connect command with cred's
+// query parameters set on its app and tcUrl. Parameters the client sent
+// under the same names are replaced.
+func withCredential(connect []interface{}, cred Credential) []interface{} {
+	out := append([]interface{}(nil), connect...)
+	if len(out) < 3 {
+		return out
+	}
+	cmdObj, ok := out[2].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	cmdObj = maps.Clone(cmdObj)
+	if app, ok := cmdObj["app"].(string); ok {
+		cmdObj["app"] = setQuery(app, cred.Query)
+	}
+	if tcURL, ok := cmdObj["tcUrl"].(string); ok {
+		cmdObj["tcUrl"] = setQuery(tcURL, cred.Query)
+	}
+	out[2] = cmdObj
+	return out
+}
+
+func setQuery(s string, params url.Values) string {
+	base, raw, _ := strings.Cut(s, "?")
+	query, err := url.ParseQuery(raw)
+	if err != nil {
+		query = url.Values{}
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+	return base + "?" + query.Encode()
+}
+
+// readConnectReply reads upstream messages up to the answer to the connect
+// with transaction ID tid. It returns every byte read, so the answer and the
+// control messages before it can be replayed to the client verbatim, and
+// whether the answer was _result.
+func readConnectReply(r io.Reader, tid float64) ([]byte, bool, error) {
+	var read bytes.Buffer
+	cs := rtmp.NewChunkStream(io.TeeReader(r, &read))
+	for {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			return nil, false, err
+		}
+		if msg == nil || (msg.Header.TypeID != rtmp.TypeAMF0Command && msg.Header.TypeID != rtmp.TypeAMF20Command) {
+			continue
+		}
+		vals, err := decodeConnectCommand(msg)
+		if err != nil || len(vals) < 2 {
+			continue
+		}
+		if id, _ := vals[1].(float64); id != tid {
+			continue
+		}
+		switch name, _ := vals[0].(string); name {
+		case "_result":
+			return read.Bytes(), true, nil
+		case "_error":
+			return read.Bytes(), false, nil
+		}
+	}
+}
+
+// connectWithCredentials opens the upstream and sends the client's connect
+// with each of info's credentials in turn until one is accepted. The upstream's
+// reply is passed on to the client, which then continues the session as if
+// it had connected directly. Rejections are counted apart from network
+// failures, and never reach the circuit breaker, which only sees dials.
+func (s *Server) connectWithCredentials(ctx context.Context, info UpstreamInfo, downstream net.Conn, header rtmp.ChunkHeader, connect []interface{}) (net.Conn, *rtmp.ChunkWriter, error) {
+	log := s.logger(ctx)
+	tid := transactionID(connect)
+	var refusal []byte
+	for i, cred := range info.Credentials {
+		upstream, err := s.openUpstream(ctx, info)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := encodeCommand(header.TypeID, withCredential(connect, cred))
+		if err != nil {
+			upstream.Close()
+			return nil, nil, withReason(ReasonProtocolError, fmt.Errorf("encode connect: %w", err))
+		}
+		cw := rtmp.NewChunkWriter(metricsWriter{writer: upstream, direction: "upstream", reg: s.Metrics})
+		if err := cw.WriteMessage(&rtmp.Message{Header: header, Payload: payload}); err != nil {
+			upstream.Close()
+			return nil, nil, withReason(ReasonUpstreamError, fmt.Errorf("forward connect: %w", err))
+		}
+		reply, accepted, err := readConnectReply(upstream, tid)
+		if err != nil {
+			upstream.Close()
+			s.Metrics.RecordUpstreamError("connect")
+			return nil, nil, withReason(ReasonUpstreamError, &errclass.UpstreamError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Op: "connect", Err: err})
+		}
+		if !accepted {
+			upstream.Close()
+			s.Metrics.RecordUpstreamAuth(cred.Name, "rejected")
+			s.Metrics.RecordUpstreamError("auth_rejected")
+			log.Warn("upstream rejected credential", "credential", cred.Name, "remaining", len(info.Credentials)-i-1)
+			refusal = reply
+			continue
+		}
+		s.Metrics.RecordUpstreamAuth(cred.Name, "accepted")
+		if i > 0 {
+			log.Warn("upstream accepted a fallback credential", "credential", cred.Name)
+		}
+		if _, err := (metricsWriter{writer: downstream, direction: "downstream", reg: s.Metrics}).Write(reply); err != nil {
+			upstream.Close()
+			return nil, nil, withReason(ReasonClientDisconnect, fmt.Errorf("forward connect reply: %w", err))
+		}
+		return upstream, cw, nil
+	}
+	// Pass the last refusal on so the encoder reports a rejected connect
+	// rather than a dropped connection.
+	if _, err := downstream.Write(refusal); err != nil {
+		log.Debug("failed to forward connect refusal", "err", err)
+	}
+	return nil, nil, withReason(ReasonUpstreamError, errUpstreamAuthRejected)
+}
+
+func transactionID(cmd []interface{}) float64 {
+	if len(cmd) < 2 {
+		return 0
+	}
+	tid, _ := cmd[1].(float64)
+	return tid
+}
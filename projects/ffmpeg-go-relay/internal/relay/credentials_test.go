@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmptest"
+)
+
+func TestWithCredential(t *testing.T) {
+	connect := []interface{}{"connect", 1.0, map[string]interface{}{
+		"app":   "live?token=old&region=eu",
+		"tcUrl": "rtmp://ingest.example.com/live",
+	}}
+	got := withCredential(connect, Credential{Name: "new", Query: url.Values{"token": {"new"}}})
+
+	obj := got[2].(map[string]interface{})
+	if obj["app"] != "live?region=eu&token=new" {
+		t.Fatalf("app = %q", obj["app"])
+	}
+	if obj["tcUrl"] != "rtmp://ingest.example.com/live?token=new" {
+		t.Fatalf("tcUrl = %q", obj["tcUrl"])
+	}
+	if orig := connect[2].(map[string]interface{}); orig["app"] != "live?token=old&region=eu" {
+		t.Fatalf("original connect modified: %v", orig)
+	}
+}
+
+func TestConnectWithCredentials(t *testing.T) {
+	// The upstream only accepts connects whose app carries token=new.
+	upstream := rtmptest.NewServer(t, rtmptest.Script{
+		Connect: func(app string, _ []interface{}) string {
+			_, raw, _ := strings.Cut(app, "?")
+			if query, _ := url.ParseQuery(raw); query.Get("token") != "new" {
+				return "bad token"
+			}
+			return ""
+		},
+	})
+	info, err := ParseUpstream(upstream.URL("live"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Log: logger.NewWithWriter(io.Discard)}
+	header := rtmp.ChunkHeader{CSID: rtmp.CSIDCommand, TypeID: rtmp.TypeAMF0Command}
+	connect := []interface{}{"connect", 1.0, map[string]interface{}{"app": "live", "tcUrl": upstream.URL("live")}}
+
+	run := func(creds ...Credential) (string, error) {
+		info.Credentials = creds
+		client, downstream := net.Pipe()
+		defer client.Close()
+		got := make(chan string, 1)
+		go func() {
+			// The upstream's protocol control messages come first.
+			cs := rtmp.NewChunkStream(client)
+			for {
+				msg, err := cs.ReadMessage()
+				if err != nil {
+					got <- ""
+					return
+				}
+				if msg.Header.TypeID == rtmp.TypeAMF0Command {
+					vals, _ := rtmp.DecodeAMF0(bytes.NewReader(msg.Payload))
+					name, _ := vals[0].(string)
+					got <- name
+					return
+				}
+			}
+		}()
+		upstream, _, err := srv.connectWithCredentials(context.Background(), info, downstream, header, connect)
+		if upstream != nil {
+			upstream.Close()
+		}
+		return <-got, err
+	}
+
+	reply, err := run(
+		Credential{Name: "old", Query: url.Values{"token": {"old"}}},
+		Credential{Name: "new", Query: url.Values{"token": {"new"}}},
+	)
+	if err != nil {
+		t.Fatalf("connect with fallback credential: %v", err)
+	}
+	if reply != "_result" {
+		t.Fatalf("client got %q, want _result", reply)
+	}
+
+	reply, err = run(Credential{Name: "old", Query: url.Values{"token": {"old"}}})
+	if !errors.Is(err, errUpstreamAuthRejected) {
+		t.Fatalf("err = %v, want errUpstreamAuthRejected", err)
+	}
+	if reply != "_error" {
+		t.Fatalf("client got %q, want the upstream's _error", reply)
+	}
+}
@@ -0,0 +1,131 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+const (
+	defaultDrainCode        = "NetConnection.Connect.Rejected"
+	defaultDrainDescription = "server is draining for maintenance"
+)
+
+// Drain turns new sessions away while a node is rotated out of service,
+// leaving the sessions already running to finish. A drain can start at once
+// or be scheduled. A nil Drain never drains.
+type Drain struct {
+	mu          sync.Mutex
+	draining    bool
+	since       time.Time
+	scheduled   time.Time
+	timer       *time.Timer
+	code        string
+	description string
+}
+
+// DrainStatus is a point-in-time view of a Drain.
+type DrainStatus struct {
+	Draining       bool   `json:"draining"`
+	SinceUnix      int64  `json:"since_unix,omitempty"`     // When the current drain began
+	ScheduledUnix  int64  `json:"scheduled_unix,omitempty"` // When a scheduled drain will begin
+	Code           string `json:"code"`
+	Description    string `json:"description"`
+	ActiveSessions int    `json:"active_sessions"`
+}
+
+// NewDrain returns an undrained Drain answering turned-away connects as cfg
+// says.
+func NewDrain(cfg config.DrainConfig) *Drain {
+	d := &Drain{code: cfg.Code, description: cfg.Description}
+	if d.code == "" {
+		d.code = defaultDrainCode
+	}
+	if d.description == "" {
+		d.description = defaultDrainDescription
+	}
+	return d
+}
+
+// Draining reports whether new sessions are being turned away.
+func (d *Drain) Draining() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Reply returns the NetConnection status code and description sent to
+// connects turned away by the drain.
+func (d *Drain) Reply() (code, description string) {
+	return d.code, d.description
+}
+
+// Start begins draining at at, or now when at is zero or past. It replaces
+// any drain already scheduled; a drain already under way keeps its start
+// time.
+func (d *Drain) Start(at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cancelScheduledLocked()
+	if wait := time.Until(at); wait > 0 && !d.draining {
+		var t *time.Timer
+		t = time.AfterFunc(wait, func() { d.begin(t) })
+		d.scheduled, d.timer = at, t
+		return
+	}
+	if !d.draining {
+		d.draining, d.since = true, time.Now()
+	}
+}
+
+// begin starts the drain scheduled with t, unless it was cancelled or
+// replaced meanwhile.
+func (d *Drain) begin(t *time.Timer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != t {
+		return
+	}
+	d.timer, d.scheduled = nil, time.Time{}
+	d.draining, d.since = true, time.Now()
+}
+
+// Stop accepts new sessions again and cancels a scheduled drain.
+func (d *Drain) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cancelScheduledLocked()
+	d.draining, d.since = false, time.Time{}
+}
+
+func (d *Drain) cancelScheduledLocked() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.scheduled = time.Time{}
+}
+
+// Status returns the drain's state along with how many sessions are still
+// running on the relay.
+func (d *Drain) Status() DrainStatus {
+	d.mu.Lock()
+	st := DrainStatus{
+		Draining:    d.draining,
+		Code:        d.code,
+		Description: d.description,
+	}
+	if !d.since.IsZero() {
+		st.SinceUnix = d.since.Unix()
+	}
+	if !d.scheduled.IsZero() {
+		st.ScheduledUnix = d.scheduled.Unix()
+	}
+	d.mu.Unlock()
+	st.ActiveSessions = GetActiveConnectionCount()
+	return st
+}
@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestDrainSchedule(t *testing.T) {
+	d := NewDrain(config.DrainConfig{})
+	d.Start(time.Now().Add(time.Hour))
+	if st := d.Status(); st.Draining || st.ScheduledUnix == 0 {
+		t.Fatalf("status = %+v, want a drain scheduled", st)
+	}
+	d.Stop()
+	if st := d.Status(); st.Draining || st.ScheduledUnix != 0 {
+		t.Fatalf("status after stop = %+v, want the schedule cancelled", st)
+	}
+
+	d.Start(time.Now().Add(10 * time.Millisecond))
+	deadline := time.Now().Add(2 * time.Second)
+	for !d.Draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled drain never began")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if st := d.Status(); st.SinceUnix == 0 || st.ScheduledUnix != 0 {
+		t.Fatalf("status = %+v, want the drain under way", st)
+	}
+}
+
+func TestDrainRejectsConnect(t *testing.T) {
+	d := NewDrain(config.DrainConfig{Code: "NetConnection.Connect.Closed", Description: "rotating"})
+	d.Start(time.Time{})
+	srv := &Server{Upstream: "rtmp://127.0.0.1:1/live", Drain: d, Log: logger.NewWithWriter(io.Discard)}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- srv.handle(context.Background(), server) }()
+
+	if err := rtmp.ClientHandshake(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(client), client)
+	_, err := session.Connect("live", "rtmp://relay/live")
+	var se *rtmp.StatusError
+	if !errors.As(err, &se) || se.Code != "NetConnection.Connect.Closed" || se.Description != "rotating" {
+		t.Fatalf("connect = %v, want the drain reply", err)
+	}
+	if err := <-done; terminationReason(err, "") != ReasonDraining {
+		t.Fatalf("session ended with %v, want reason %s", err, ReasonDraining)
+	}
+}
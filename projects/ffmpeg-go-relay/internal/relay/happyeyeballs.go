@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// DefaultAttemptDelay is the RFC 8305 Connection Attempt Delay: how long a
+// dial gets before the next address is tried alongside it.
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// HappyEyeballsConfig controls RFC 8305 dialing of upstream hostnames. With
+// it off, an upstream is dialed with the standard library's dual-stack
+// fallback, which tries addresses in the resolver's order.
+type HappyEyeballsConfig struct {
+	Enabled      bool
+	AttemptDelay time.Duration                                                // 0 uses DefaultAttemptDelay
+	Lookup       func(ctx context.Context, host string) ([]net.IPAddr, error) // nil uses net.DefaultResolver
+}
+
+// dialTCP connects to address, racing the host's IPv6 and IPv4 addresses
+// per RFC 8305 when enabled and the host is a name.
+func (h HappyEyeballsConfig) dialTCP(ctx context.Context, dialer *net.Dialer, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !h.Enabled || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	lookup := h.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	delay := h.AttemptDelay
+	if delay <= 0 {
+		delay = DefaultAttemptDelay
+	}
+	return raceDials(ctx, dialer, interleaveFamilies(addrs), port, delay)
+}
+
+// interleaveFamilies orders addrs IPv6 first, alternating families, as RFC
+// 8305 section 4 asks, keeping the resolver's order within each family.
+func interleaveFamilies(addrs []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	ordered := make([]net.IPAddr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// raceDials starts a dial to each address in turn, the next one when the
+// previous fails or after delay, and returns the first connection made.
+// Connections that lose the race are closed.
+func raceDials(ctx context.Context, dialer *net.Dialer, addrs []net.IPAddr, port string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	pending := 0
+	var errs []error
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for next := 0; ; {
+		if next < len(addrs) {
+			addr := net.JoinHostPort(addrs[next].String(), port)
+			go func() {
+				conn, err := dialer.DialContext(ctx, "tcp", addr)
+				results <- dialResult{conn: conn, err: err}
+			}()
+			next++
+			pending++
+			timer.Reset(delay)
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			// A failure starts the next address without waiting out the delay.
+			errs = append(errs, r.err)
+			if pending == 0 && next == len(addrs) {
+				return nil, errors.Join(errs...)
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			go closeLosers(results, pending)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// closeLosers waits for the n dials still running and closes any that
+// connected.
+func closeLosers(results <-chan dialResult, n int) {
+	for ; n > 0; n-- {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ip := func(s string) net.IPAddr { return net.IPAddr{IP: net.ParseIP(s)} }
+	got := interleaveFamilies([]net.IPAddr{ip("192.0.2.1"), ip("192.0.2.2"), ip("192.0.2.3"), ip("2001:db8::1")})
+	want := []net.IPAddr{ip("2001:db8::1"), ip("192.0.2.1"), ip("192.0.2.2"), ip("192.0.2.3")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+func TestHappyEyeballsFallsBackFromStalledFamily(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// The IPv6 address stalls like a black-holed route until the dial is
+	// abandoned.
+	dialer := &net.Dialer{ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+		if network == "tcp6" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}}
+	h := HappyEyeballsConfig{
+		Enabled:      true,
+		AttemptDelay: 50 * time.Millisecond,
+		Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("::1")}}, nil
+		},
+	}
+
+	start := time.Now()
+	conn, err := h.dialTCP(context.Background(), dialer, net.JoinHostPort("upstream.example.com", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("dial took %v, want about one attempt delay", took)
+	}
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Fatalf("connected to %s", got)
+	}
+}
+
+func TestHappyEyeballsReportsEveryFailure(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, port, _ := net.SplitHostPort(addr)
+
+	h := HappyEyeballsConfig{
+		Enabled:      true,
+		AttemptDelay: time.Hour, // A refused dial must not wait this out
+		Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = h.dialTCP(ctx, &net.Dialer{}, net.JoinHostPort("upstream.example.com", port))
+	if err == nil || ctx.Err() != nil {
+		t.Fatalf("err = %v, ctx err = %v; want both refusals reported promptly", err, ctx.Err())
+	}
+}
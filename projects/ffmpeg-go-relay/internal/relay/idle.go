@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// IdleTimeouts bound how long a session's sockets may go without a
+// successful read or write. The handshake values apply from accept through
+// the RTMP handshake and the connect and publish exchange, until the
+// session starts relaying; the others apply from then on. A long Read
+// suits publishers that pause during pre-roll, while a short HandshakeRead
+// still drops clients that stall before publishing.
+type IdleTimeouts struct {
+	Read           time.Duration // 0 uses Server.Idle
+	Write          time.Duration // 0 uses Server.Idle
+	HandshakeRead  time.Duration // 0 uses Read
+	HandshakeWrite time.Duration // 0 uses Write
+}
+
+// idleTimeouts returns s.IdleTimeouts with its zero fields filled in.
+func (s *Server) idleTimeouts() IdleTimeouts {
+	t := s.IdleTimeouts
+	if t.Read <= 0 {
+		t.Read = s.Idle
+	}
+	if t.Write <= 0 {
+		t.Write = s.Idle
+	}
+	if t.HandshakeRead <= 0 {
+		t.HandshakeRead = t.Read
+	}
+	if t.HandshakeWrite <= 0 {
+		t.HandshakeWrite = t.Write
+	}
+	return t
+}
+
+type idlePhaseKey struct{}
+
+// idlePhase is shared by the sockets of one session, so marking the session
+// as relaying moves its client and upstream connections to the steady-state
+// timeouts together.
+type idlePhase struct {
+	relaying atomic.Bool
+}
+
+func contextWithIdlePhase(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idlePhaseKey{}, &idlePhase{})
+}
+
+// markRelaying ends the handshake phase of ctx's session.
+func markRelaying(ctx context.Context) {
+	if phase, ok := ctx.Value(idlePhaseKey{}).(*idlePhase); ok {
+		phase.relaying.Store(true)
+	}
+}
+
+// wrapIdle applies the idle timeouts to conn, following the phase of ctx's
+// session. Outside a session the steady-state timeouts apply.
+func (s *Server) wrapIdle(ctx context.Context, conn net.Conn) net.Conn {
+	phase, _ := ctx.Value(idlePhaseKey{}).(*idlePhase)
+	return wrapIdleConn(conn, s.idleTimeouts(), phase)
+}
+
+func wrapIdleConn(conn net.Conn, t IdleTimeouts, phase *idlePhase) net.Conn {
+	if conn == nil || t == (IdleTimeouts{}) {
+		return conn
+	}
+	return &idleConn{Conn: conn, timeouts: t, phase: phase}
+}
+
+type idleConn struct {
+	net.Conn
+	timeouts IdleTimeouts
+	phase    *idlePhase // nil is always relaying
+}
+
+func (c *idleConn) handshaking() bool {
+	return c.phase != nil && !c.phase.relaying.Load()
+}
+
+func (c *idleConn) readIdle() time.Duration {
+	if c.handshaking() {
+		return c.timeouts.HandshakeRead
+	}
+	return c.timeouts.Read
+}
+
+func (c *idleConn) writeIdle() time.Duration {
+	if c.handshaking() {
+		return c.timeouts.HandshakeWrite
+	}
+	return c.timeouts.Write
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if idle := c.readIdle(); idle > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(idle))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if idle := c.writeIdle(); idle > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(idle))
+	}
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeoutsFallBack(t *testing.T) {
+	srv := &Server{Idle: 30 * time.Second, IdleTimeouts: IdleTimeouts{Read: time.Minute, HandshakeWrite: time.Second}}
+	want := IdleTimeouts{Read: time.Minute, Write: 30 * time.Second, HandshakeRead: time.Minute, HandshakeWrite: time.Second}
+	if got := srv.idleTimeouts(); got != want {
+		t.Fatalf("idleTimeouts = %+v, want %+v", got, want)
+	}
+}
+
+func TestIdleConnSwitchesPhase(t *testing.T) {
+	client, peer := net.Pipe()
+	defer client.Close()
+	defer peer.Close()
+
+	srv := &Server{IdleTimeouts: IdleTimeouts{Read: time.Hour, HandshakeRead: 20 * time.Millisecond}}
+	ctx := contextWithIdlePhase(context.Background())
+	conn := srv.wrapIdle(ctx, client)
+
+	// A client silent during the handshake is dropped quickly.
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("handshake read err = %v, want a deadline error", err)
+	}
+
+	// Once relaying, the same silence is a pre-roll pause and is tolerated.
+	markRelaying(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("relaying read ended early: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	peer.Write([]byte{1})
+	if err := <-done; err != nil {
+		t.Fatalf("relaying read: %v", err)
+	}
+}
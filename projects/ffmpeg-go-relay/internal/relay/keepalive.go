@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// TCPKeepalive tunes how quickly a relay socket notices a dead peer. Zero
+// fields keep the operating system's defaults.
+type TCPKeepalive struct {
+	Idle        time.Duration // Quiet time before the first keepalive probe
+	Interval    time.Duration // Time between unanswered probes
+	Count       int           // Unanswered probes before the connection is dropped
+	UserTimeout time.Duration // Longest sent data may go unacknowledged (TCP_USER_TIMEOUT); Linux only
+}
+
+func (k TCPKeepalive) isZero() bool {
+	return k == TCPKeepalive{}
+}
+
+// check reports settings this platform cannot apply.
+func (k TCPKeepalive) check() error {
+	if k.UserTimeout > 0 && !tcpUserTimeoutSupported {
+		return errors.New("TCP user timeout is only supported on Linux")
+	}
+	return nil
+}
+
+// apply sets k on the TCP socket under conn, looking through TLS. Other
+// connections are left alone.
+func (k TCPKeepalive) apply(conn net.Conn) error {
+	if k.isZero() {
+		return nil
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if k.Idle > 0 || k.Interval > 0 || k.Count > 0 {
+		cfg := net.KeepAliveConfig{Enable: true, Idle: k.Idle, Interval: k.Interval, Count: k.Count}
+		if err := tcp.SetKeepAliveConfig(cfg); err != nil {
+			return err
+		}
+	}
+	if k.UserTimeout > 0 {
+		return setUserTimeout(tcp, k.UserTimeout)
+	}
+	return nil
+}
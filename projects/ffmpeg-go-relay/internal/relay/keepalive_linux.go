@@ -0,0 +1,27 @@
+//go:build linux
+
+package relay
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const tcpUserTimeoutSupported = true
+
+// setUserTimeout sets TCP_USER_TIMEOUT, in milliseconds.
+func setUserTimeout(tcp *net.TCPConn, d time.Duration) error {
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(d.Milliseconds()))
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package relay
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func sockopt(t *testing.T, tcp *net.TCPConn, opt int) int {
+	t.Helper()
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		v, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, opt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return v
+}
+
+func TestTCPKeepaliveApply(t *testing.T) {
+	a, b := tcpPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	k := TCPKeepalive{Idle: 20 * time.Second, Interval: 5 * time.Second, Count: 3, UserTimeout: 15 * time.Second}
+	// Through TLS the settings reach the socket underneath.
+	if err := k.apply(tls.Client(a, &tls.Config{})); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	tcp := a.(*net.TCPConn)
+	if got := sockopt(t, tcp, unix.TCP_KEEPIDLE); got != 20 {
+		t.Fatalf("TCP_KEEPIDLE = %d", got)
+	}
+	if got := sockopt(t, tcp, unix.TCP_KEEPINTVL); got != 5 {
+		t.Fatalf("TCP_KEEPINTVL = %d", got)
+	}
+	if got := sockopt(t, tcp, unix.TCP_KEEPCNT); got != 3 {
+		t.Fatalf("TCP_KEEPCNT = %d", got)
+	}
+	if got := sockopt(t, tcp, unix.TCP_USER_TIMEOUT); got != 15000 {
+		t.Fatalf("TCP_USER_TIMEOUT = %d", got)
+	}
+
+	// The zero value leaves the socket alone.
+	before := sockopt(t, b.(*net.TCPConn), unix.TCP_USER_TIMEOUT)
+	if err := (TCPKeepalive{}).apply(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := sockopt(t, b.(*net.TCPConn), unix.TCP_USER_TIMEOUT); got != before {
+		t.Fatalf("TCP_USER_TIMEOUT changed to %d", got)
+	}
+}
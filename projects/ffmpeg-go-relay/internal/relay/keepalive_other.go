@@ -0,0 +1,15 @@
+//go:build !linux
+
+package relay
+
+import (
+	"net"
+	"time"
+)
+
+// TCP_USER_TIMEOUT is Linux-only; Server.Run refuses it elsewhere.
+const tcpUserTimeoutSupported = false
+
+func setUserTimeout(tcp *net.TCPConn, d time.Duration) error {
+	return nil
+}
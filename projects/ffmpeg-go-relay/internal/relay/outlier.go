@@ -0,0 +1,163 @@
+package relay
+
+import (
+	"time"
+)
+
+// Outlier detection defaults.
+const (
+	defaultOutlierWindow       = time.Minute
+	defaultOutlierMinRequests  = 5
+	defaultOutlierFailureRate  = 0.5
+	defaultOutlierBaseEjection = 30 * time.Second
+	defaultOutlierMaxEjection  = 5 * time.Minute
+
+	// rampStartShare is the share of its picks a re-admitted upstream gets
+	// right after its ejection ends.
+	rampStartShare = 0.1
+)
+
+// OutlierConfig controls ejection of pool upstreams whose connects keep
+// failing. Zero fields use the defaults above.
+type OutlierConfig struct {
+	Enabled       bool
+	Window        time.Duration
+	MinRequests   int
+	FailureRate   float64
+	SlowThreshold time.Duration // 0 never counts a connect as failed for being slow
+	BaseEjection  time.Duration
+	MaxEjection   time.Duration
+	Ramp          time.Duration // 0 uses BaseEjection
+}
+
+func normalizeOutlier(cfg OutlierConfig) OutlierConfig {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultOutlierWindow
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultOutlierMinRequests
+	}
+	if cfg.FailureRate <= 0 {
+		cfg.FailureRate = defaultOutlierFailureRate
+	}
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = defaultOutlierBaseEjection
+	}
+	if cfg.MaxEjection <= 0 {
+		cfg.MaxEjection = max(defaultOutlierMaxEjection, cfg.BaseEjection)
+	}
+	if cfg.Ramp <= 0 {
+		cfg.Ramp = cfg.BaseEjection
+	}
+	return cfg
+}
+
+// outlierState is an endpoint's recent connects and ejection history,
+// guarded by the pool's mutex.
+type outlierState struct {
+	outcomes     []connectOutcome // Within the window, oldest first
+	ejections    int              // Consecutive ejections, for the back-off
+	ejectedUntil time.Time
+}
+
+type connectOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// Ejection describes an upstream taken out of rotation.
+type Ejection struct {
+	URL         string
+	Address     string // The record dialed, for expand_dns upstreams
+	FailureRate float64
+	Duration    time.Duration
+}
+
+// EnableOutlierDetection starts judging the pool's upstreams by the connect
+// outcomes reported for them.
+func (p *UpstreamPool) EnableOutlierDetection(cfg OutlierConfig) {
+	if p == nil || !cfg.Enabled {
+		return
+	}
+	cfg = normalizeOutlier(cfg)
+	p.mu.Lock()
+	p.outlier = cfg
+	p.mu.Unlock()
+}
+
+// ReportConnect records how connecting to an upstream returned by Pick went:
+// err is the dial or handshake error, took how long it took. It returns the
+// ejection when this outcome tipped the upstream over the failure rate.
+// Upstreams that did not come from a pool are ignored.
+func ReportConnect(info UpstreamInfo, err error, took time.Duration) (Ejection, bool) {
+	if info.pool == nil {
+		return Ejection{}, false
+	}
+	return info.pool.observe(info.endpoint, err != nil, took, time.Now())
+}
+
+func (p *UpstreamPool) observe(e *upstreamState, failed bool, took time.Duration, now time.Time) (Ejection, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cfg := p.outlier
+	if !cfg.Enabled {
+		return Ejection{}, false
+	}
+	o := &e.outlier
+	if now.Before(o.ejectedUntil) {
+		// A session that picked it before the ejection.
+		return Ejection{}, false
+	}
+	if o.ejections > 0 && now.Sub(o.ejectedUntil) > cfg.MaxEjection {
+		o.ejections = 0
+	}
+	if cfg.SlowThreshold > 0 && took > cfg.SlowThreshold {
+		failed = true
+	}
+
+	o.outcomes = append(o.outcomes, connectOutcome{at: now, failed: failed})
+	cutoff := now.Add(-cfg.Window)
+	drop := 0
+	for drop < len(o.outcomes) && o.outcomes[drop].at.Before(cutoff) {
+		drop++
+	}
+	o.outcomes = o.outcomes[drop:]
+	if len(o.outcomes) < cfg.MinRequests {
+		return Ejection{}, false
+	}
+	failures := 0
+	for _, oc := range o.outcomes {
+		if oc.failed {
+			failures++
+		}
+	}
+	rate := float64(failures) / float64(len(o.outcomes))
+	if rate < cfg.FailureRate {
+		return Ejection{}, false
+	}
+
+	d := min(cfg.BaseEjection<<min(o.ejections, 16), cfg.MaxEjection)
+	o.ejections++
+	o.ejectedUntil = now.Add(d)
+	o.outcomes = nil
+	return Ejection{URL: e.url, Address: e.record, FailureRate: rate, Duration: d}, true
+}
+
+// admitLocked reports whether e may be picked now. An ejected upstream may
+// not; a re-admitted one is let through for a share of picks that grows from
+// rampStartShare to all of them over the ramp.
+func (p *UpstreamPool) admitLocked(e *upstreamState, now time.Time) bool {
+	if !p.outlier.Enabled || e.outlier.ejections == 0 {
+		return true
+	}
+	until := e.outlier.ejectedUntil
+	if now.Before(until) {
+		return false
+	}
+	since := now.Sub(until)
+	if since >= p.outlier.Ramp {
+		return true
+	}
+	share := rampStartShare + (1-rampStartShare)*float64(since)/float64(p.outlier.Ramp)
+	return p.rng.Float64() < share
+}
@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func newOutlierPool(t *testing.T) *UpstreamPool {
+	t.Helper()
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{
+		{URL: "rtmp://flaky.example.com/app"},
+		{URL: "rtmp://steady.example.com/app"},
+	}, "round_robin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.EnableOutlierDetection(OutlierConfig{
+		Enabled:       true,
+		MinRequests:   4,
+		FailureRate:   0.5,
+		SlowThreshold: time.Second,
+		BaseEjection:  10 * time.Second,
+		MaxEjection:   30 * time.Second,
+		Ramp:          time.Hour,
+	})
+	return pool
+}
+
+func TestOutlierEjectionBacksOff(t *testing.T) {
+	pool := newOutlierPool(t)
+	flaky := pool.endpoints[0]
+	now := time.Now()
+
+	// Two failures in four connects, one of them only slow.
+	outcomes := []struct {
+		failed bool
+		took   time.Duration
+	}{{false, 0}, {true, 0}, {false, 0}, {false, 2 * time.Second}}
+	for i, o := range outcomes {
+		ej, ejected := pool.observe(flaky, o.failed, o.took, now)
+		if ejected != (i == 3) {
+			t.Fatalf("outcome %d: ejected = %v", i, ejected)
+		}
+		if ejected && (ej.URL != "rtmp://flaky.example.com/app" || ej.FailureRate != 0.5 || ej.Duration != 10*time.Second) {
+			t.Fatalf("ejection = %+v", ej)
+		}
+	}
+	// Outcomes of sessions that picked it earlier do not count.
+	if _, ejected := pool.observe(flaky, true, 0, now.Add(time.Second)); ejected || len(flaky.outlier.outcomes) != 0 {
+		t.Fatal("outcome during the ejection was counted")
+	}
+
+	wantDurations := []time.Duration{20 * time.Second, 30 * time.Second}
+	for _, want := range wantDurations {
+		now = flaky.outlier.ejectedUntil
+		var ej Ejection
+		var ejected bool
+		for i := 0; i < 4 && !ejected; i++ {
+			ej, ejected = pool.observe(flaky, true, 0, now)
+		}
+		if !ejected || ej.Duration != want {
+			t.Fatalf("repeat ejection = %+v, %v; want %v", ej, ejected, want)
+		}
+	}
+
+	// A quiet spell longer than max_ejection resets the back-off.
+	now = flaky.outlier.ejectedUntil.Add(31 * time.Second)
+	for i := 0; i < 4; i++ {
+		if ej, ejected := pool.observe(flaky, true, 0, now); ejected && ej.Duration != 10*time.Second {
+			t.Fatalf("ejection after a quiet spell = %+v", ej)
+		}
+	}
+}
+
+func TestPickSkipsEjectedAndRampsBack(t *testing.T) {
+	pool := newOutlierPool(t)
+	flaky := pool.endpoints[0]
+
+	// Round robin gives each upstream four of the eight picks.
+	for i := 0; i < 8; i++ {
+		info, raw, err := pool.Pick()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw == "rtmp://flaky.example.com/app" {
+			ReportConnect(info, errors.New("handshake: EOF"), time.Millisecond)
+		} else {
+			ReportConnect(info, nil, time.Millisecond)
+		}
+	}
+	if pool.Stats()[0].EjectedUntilUnix == 0 {
+		t.Fatalf("flaky upstream not ejected: %+v", pool.Stats()[0])
+	}
+	for i := 0; i < 10; i++ {
+		if _, raw, _ := pool.Pick(); raw != "rtmp://steady.example.com/app" {
+			t.Fatalf("picked ejected upstream %s", raw)
+		}
+	}
+
+	// Ejection cannot empty the pool.
+	pool.mu.Lock()
+	pool.endpoints[1].healthy = false
+	pool.mu.Unlock()
+	if _, raw, _ := pool.Pick(); raw != "rtmp://flaky.example.com/app" {
+		t.Fatalf("with the rest unhealthy picked %s", raw)
+	}
+	pool.mu.Lock()
+	pool.endpoints[1].healthy = true
+	// The ejection just ended, so about rampStartShare of its turns are kept.
+	flaky.outlier.ejectedUntil = time.Now()
+	pool.mu.Unlock()
+
+	flakyPicks := 0
+	for i := 0; i < 2000; i++ {
+		if _, raw, _ := pool.Pick(); raw == "rtmp://flaky.example.com/app" {
+			flakyPicks++
+		}
+	}
+	// Round robin alone would give it 1000.
+	if flakyPicks < 40 || flakyPicks > 300 {
+		t.Fatalf("re-admitted upstream got %d of 2000 picks", flakyPicks)
+	}
+}
@@ -0,0 +1,283 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Pre-warming defaults.
+const (
+	defaultPrewarmPerUpstream = 2
+	defaultPrewarmMaxIdle     = 30 * time.Second
+	defaultPrewarmInterval    = 5 * time.Second
+
+	// prewarmTimeout bounds the dial and handshake of one warm connection.
+	prewarmTimeout = 10 * time.Second
+	// prewarmProbe is how long take waits for a sign that the upstream
+	// closed a warm connection.
+	prewarmProbe = time.Millisecond
+)
+
+// PrewarmConfig controls the pool of dialed and handshaked upstream
+// connections kept ready for new sessions. Zero fields use the defaults above.
+type PrewarmConfig struct {
+	Enabled     bool
+	PerUpstream int
+	MaxIdle     time.Duration // Unused connections older than this are replaced
+	Interval    time.Duration // How often the pool is topped up
+}
+
+func normalizePrewarm(cfg PrewarmConfig) PrewarmConfig {
+	if cfg.PerUpstream <= 0 {
+		cfg.PerUpstream = defaultPrewarmPerUpstream
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = defaultPrewarmMaxIdle
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultPrewarmInterval
+	}
+	return cfg
+}
+
+// warmPool holds handshaked upstream connections by where they were dialed.
+// A connection is only handshaked, so it can serve any session to that
+// address whatever app, stream or credential the session connects with.
+type warmPool struct {
+	mu      sync.Mutex
+	idle    map[string][]warmConn // Oldest first
+	maxIdle time.Duration
+	closed  bool
+}
+
+type warmConn struct {
+	conn net.Conn
+	at   time.Time
+}
+
+func newWarmPool(maxIdle time.Duration) *warmPool {
+	return &warmPool{idle: map[string][]warmConn{}, maxIdle: maxIdle}
+}
+
+// warmKey identifies the connections that can serve info. TLS connections
+// also depend on the name they were verified against.
+func warmKey(info UpstreamInfo) string {
+	if info.UseTLS {
+		return "tls|" + info.Host + "|" + info.Address
+	}
+	return "tcp|" + info.Address
+}
+
+// take returns a live warm connection for info, newest first, or nil when
+// there is none. Expired and closed connections found on the way are dropped.
+func (w *warmPool) take(info UpstreamInfo) net.Conn {
+	if w == nil {
+		return nil
+	}
+	key := warmKey(info)
+	for {
+		w.mu.Lock()
+		conns := w.idle[key]
+		if len(conns) == 0 {
+			w.mu.Unlock()
+			return nil
+		}
+		c := conns[len(conns)-1]
+		w.idle[key] = conns[:len(conns)-1]
+		w.mu.Unlock()
+
+		if time.Since(c.at) < w.maxIdle && warmAlive(c.conn) {
+			return c.conn
+		}
+		c.conn.Close()
+	}
+}
+
+// put adds a connection for key, closing it if the pool has shut down.
+func (w *warmPool) put(key string, conn net.Conn, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		conn.Close()
+		return
+	}
+	w.idle[key] = append(w.idle[key], warmConn{conn: conn, at: now})
+}
+
+// prune closes key's expired connections and returns how many remain.
+func (w *warmPool) prune(key string, now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	conns := w.idle[key]
+	drop := 0
+	for drop < len(conns) && now.Sub(conns[drop].at) >= w.maxIdle {
+		conns[drop].conn.Close()
+		drop++
+	}
+	w.idle[key] = conns[drop:]
+	return len(w.idle[key])
+}
+
+// closeAll closes every idle connection and refuses new ones.
+func (w *warmPool) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for key, conns := range w.idle {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+		delete(w.idle, key)
+	}
+}
+
+// warmAlive reports whether the upstream still holds conn open. A handshaked
+// upstream sends nothing before the client's connect, so any data or EOF
+// within prewarmProbe means the connection cannot be used.
+func warmAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(prewarmProbe)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// startPrewarm fills the warm pool now and then every interval until ctx
+// ends, when the idle connections are closed.
+func (s *Server) startPrewarm(ctx context.Context) {
+	if !s.Prewarm.Enabled {
+		return
+	}
+	cfg := normalizePrewarm(s.Prewarm)
+	s.warm = newWarmPool(cfg.MaxIdle)
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		defer s.warm.closeAll()
+
+		s.refillWarm(ctx, cfg)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refillWarm(ctx, cfg)
+			}
+		}
+	}()
+}
+
+// prewarmTargets lists the RTMP upstreams sessions may be sent to: the
+// healthy, non-ejected members of the global pool and of every route, or
+// the single upstream.
+func (s *Server) prewarmTargets() []UpstreamInfo {
+	var targets []UpstreamInfo
+	if s.UpstreamPool != nil {
+		targets = append(targets, s.UpstreamPool.warmTargets()...)
+	} else if info, err := s.getUpstreamInfo(); err == nil && s.Upstream != "" {
+		targets = append(targets, info)
+	}
+	if s.Routes != nil {
+		for _, rt := range s.Routes.routes {
+			targets = append(targets, rt.pool.warmTargets()...)
+		}
+	}
+
+	rtmpTargets := targets[:0]
+	for _, info := range targets {
+		if info.Scheme == "rtmp" || info.Scheme == "rtmps" {
+			rtmpTargets = append(rtmpTargets, info)
+		}
+	}
+	return rtmpTargets
+}
+
+// refillWarm tops every target up to PerUpstream connections, dialing the
+// targets in parallel.
+func (s *Server) refillWarm(ctx context.Context, cfg PrewarmConfig) {
+	var wg sync.WaitGroup
+	seen := map[string]bool{}
+	for _, info := range s.prewarmTargets() {
+		key := warmKey(info)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		missing := cfg.PerUpstream - s.warm.prune(key, time.Now())
+		if missing <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(info UpstreamInfo) {
+			defer wg.Done()
+			for i := 0; i < missing && ctx.Err() == nil; i++ {
+				conn, err := s.dialWarm(ctx, info)
+				if err != nil {
+					s.Log.Debug("upstream pre-warm failed", "upstream", info.Raw, "address", info.Address, "err", err)
+					return
+				}
+				s.warm.put(key, conn, time.Now())
+			}
+		}(info)
+	}
+	wg.Wait()
+}
+
+// dialWarm dials info within its dial rate limit and completes the RTMP
+// handshake, reporting the outcome to outlier detection like a session's
+// connect.
+func (s *Server) dialWarm(ctx context.Context, info UpstreamInfo) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+	defer cancel()
+	if _, err := info.DialLimit.Wait(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	conn, err := s.dialUpstreamOnce(ctx, info)
+	if err != nil {
+		s.reportConnect(ctx, info, err, time.Since(start))
+		return nil, err
+	}
+	s.tuneUpstream(s.logger(ctx), info, conn)
+
+	_ = conn.SetDeadline(time.Now().Add(prewarmTimeout))
+	if err := rtmp.ClientHandshake(conn, s.Handshake); err != nil {
+		conn.Close()
+		s.reportConnect(ctx, info, err, time.Since(start))
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	s.reportConnect(ctx, info, nil, time.Since(start))
+	return conn, nil
+}
+
+// warmTargets returns the healthy endpoints outlier detection has not
+// ejected, for pre-warming.
+func (p *UpstreamPool) warmTargets() []UpstreamInfo {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+	var targets []UpstreamInfo
+	for _, endpoint := range p.endpoints {
+		if !endpoint.healthy || now.Before(endpoint.outlier.ejectedUntil) {
+			continue
+		}
+		targets = append(targets, p.originLocked(endpoint))
+	}
+	return targets
+}
@@ -0,0 +1,84 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmptest"
+)
+
+func TestPrewarmServesSessionsFromWarmConnections(t *testing.T) {
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
+	srv := &Server{
+		Upstream: upstream.URL("live"),
+		Log:      logger.NewWithWriter(io.Discard),
+		Prewarm:  PrewarmConfig{Enabled: true, PerUpstream: 2, Interval: time.Hour},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.startPrewarm(ctx)
+	info, err := srv.getUpstreamInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.warm.prune(warmKey(info), time.Now()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool never filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := srv.openUpstream(ctx, info)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	// Two sessions took warm connections; the third had to dial.
+	if got := upstream.Accepted(); got != 3 {
+		t.Fatalf("upstream accepted %d connections, want 3", got)
+	}
+}
+
+func TestWarmPoolDropsExpiredAndClosedConnections(t *testing.T) {
+	info, err := ParseUpstream("rtmp://127.0.0.1:1935/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := warmKey(info)
+	w := newWarmPool(time.Minute)
+
+	expired, peer1 := net.Pipe()
+	defer peer1.Close()
+	closed, peer2 := net.Pipe()
+	peer2.Close()
+	live, peer3 := net.Pipe()
+	defer peer3.Close()
+
+	now := time.Now()
+	w.put(key, expired, now.Add(-2*time.Minute))
+	w.put(key, live, now)
+	w.put(key, closed, now)
+
+	if got := w.take(info); got != live {
+		t.Fatalf("take = %v, want the live connection", got)
+	}
+	if got := w.take(info); got != nil {
+		t.Fatalf("take = %v, want nil once only expired connections remain", got)
+	}
+
+	w.closeAll()
+	fresh, peer4 := net.Pipe()
+	defer peer4.Close()
+	w.put(key, fresh, now)
+	if w.take(info) != nil {
+		t.Fatal("closed pool kept a connection")
+	}
+}
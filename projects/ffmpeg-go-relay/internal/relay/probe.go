@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// The transcoder is started once the publisher's media is known. Publishers
+// that never send one kind of media, or its decoder configuration, are
+// decided after probeMessages messages or probeMediaTime of media.
+const (
+	probeMessages  = 500
+	probeMediaTime = 3000 // Milliseconds
+)
+
+// Smart mode decisions, used as metric labels.
+const (
+	smartTranscode = "transcode"
+	smartRemux     = "remux"
+)
+
+// mediaProbe learns which tracks a publisher sends, their codecs and
+// whether their decoder configuration arrived.
+type mediaProbe struct {
+	video, audio           string
+	videoReady, audioReady bool // Decoder configuration seen, or none needed
+	dropped                int
+	messages               int
+	first                  uint32
+	started                bool
+}
+
+// observe notes msg and reports whether to keep it. Frames that arrive
+// before their track's decoder configuration cannot be decoded and only
+// make the transcoder's own probing fail, so they are dropped.
+func (p *mediaProbe) observe(msg *rtmp.Message) bool {
+	p.messages++
+	switch msg.Header.TypeID {
+	case rtmp.TypeVideo:
+		h, err := rtmp.ParseVideoHeader(msg.Payload)
+		if err != nil {
+			return true
+		}
+		if p.video == "" {
+			p.video = h.Codec()
+			// Only AVC, HEVC and enhanced codecs carry a configuration record.
+			p.videoReady = !h.Enhanced && h.CodecID != rtmp.VideoAVC && h.CodecID != rtmp.VideoHEVC
+		}
+		if h.IsSequenceHeader() {
+			p.videoReady = true
+		} else if !p.videoReady {
+			p.dropped++
+			return false
+		}
+	case rtmp.TypeAudio:
+		h, err := rtmp.ParseAudioHeader(msg.Payload)
+		if err != nil {
+			return true
+		}
+		if p.audio == "" {
+			p.audio = h.Codec()
+			p.audioReady = h.Format != rtmp.AudioAAC
+		}
+		if h.Format == rtmp.AudioAAC && h.AACPacketType == 0 {
+			p.audioReady = true
+		} else if !p.audioReady {
+			p.dropped++
+			return false
+		}
+	default:
+		return true
+	}
+	if !p.started {
+		p.first, p.started = msg.Header.Timestamp, true
+	}
+	return true
+}
+
+// settled reports whether enough was seen to start the transcoder, given
+// the timestamp of the last message.
+func (p *mediaProbe) settled(ts uint32) bool {
+	if p.video != "" && p.audio != "" && p.videoReady && p.audioReady {
+		return true
+	}
+	// Compared as a signed difference, so a timestamp slightly behind the
+	// first one, as interleaved audio and video often are, does not wrap
+	// around to a huge duration.
+	return p.messages >= probeMessages || (p.started && int32(ts-p.first) >= probeMediaTime)
+}
+
+// hasAudio and hasVideo are the FLV header flags. A publisher that sent no
+// media during the probe is assumed to send both.
+func (p *mediaProbe) hasAudio() bool { return p.audio != "" || p.video == "" }
+func (p *mediaProbe) hasVideo() bool { return p.video != "" || p.audio == "" }
+
+// probeMedia reads from cs until the publisher's media is known, and returns
+// the probe with the messages read that are still to be relayed.
+func probeMedia(cs *rtmp.ChunkStream) (*mediaProbe, []*rtmp.Message, error) {
+	p := &mediaProbe{}
+	var held []*rtmp.Message
+	for {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			return p, held, err
+		}
+		if msg == nil {
+			continue
+		}
+		if p.observe(msg) {
+			held = append(held, msg)
+		}
+		if p.settled(msg.Header.Timestamp) {
+			return p, held, nil
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func sequenceHeader(typeID uint8, ts uint32) *rtmp.Message {
+	payload := []byte{0x17, 0x00} // AVC keyframe, sequence header
+	if typeID == rtmp.TypeAudio {
+		payload = []byte{0xaf, 0x00} // AAC sequence header
+	}
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: typeID, Timestamp: ts}, Payload: payload}
+}
+
+func TestMediaProbe(t *testing.T) {
+	p := &mediaProbe{}
+	metadata := &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAMF0Data}}
+	if !p.observe(metadata) || p.settled(0) {
+		t.Fatal("metadata should be kept without settling the probe")
+	}
+	// A frame ahead of its sequence header is dropped.
+	if p.observe(mediaMessage(rtmp.TypeVideo, 0)) || p.dropped != 1 {
+		t.Fatalf("video frame before the sequence header was kept: %+v", p)
+	}
+	for _, msg := range []*rtmp.Message{sequenceHeader(rtmp.TypeVideo, 0), mediaMessage(rtmp.TypeVideo, 40), sequenceHeader(rtmp.TypeAudio, 0)} {
+		if !p.observe(msg) {
+			t.Fatalf("message %+v dropped", msg.Header)
+		}
+	}
+	if !p.settled(40) || p.video != "h264" || p.audio != "aac" || !p.hasAudio() || !p.hasVideo() {
+		t.Fatalf("probe = %+v, want h264 and aac settled", p)
+	}
+
+	// A video-only publisher is decided once enough media went by.
+	p = &mediaProbe{}
+	p.observe(sequenceHeader(rtmp.TypeVideo, 1000))
+	for ts := uint32(1000); ts < 1000+probeMediaTime; ts += 40 {
+		if p.observe(mediaMessage(rtmp.TypeVideo, ts)); p.settled(ts) {
+			t.Fatalf("probe settled at %dms of video-only media", ts-1000)
+		}
+	}
+	p.observe(mediaMessage(rtmp.TypeVideo, 1000+probeMediaTime))
+	if !p.settled(1000+probeMediaTime) || p.hasAudio() || !p.hasVideo() {
+		t.Fatalf("probe = %+v, want video-only settled", p)
+	}
+}
+
+func TestMediaProbeBackwardsTimestamp(t *testing.T) {
+	p := &mediaProbe{}
+	p.observe(sequenceHeader(rtmp.TypeVideo, 1000))
+	p.observe(mediaMessage(rtmp.TypeVideo, 1000))
+	// Audio interleaved a little behind the first video frame, here dropped
+	// ahead of its sequence header, is not four billion milliseconds on.
+	if p.observe(mediaMessage(rtmp.TypeAudio, 980)) || p.settled(980) {
+		t.Fatalf("probe = %+v settled on an audio frame behind the first video frame", p)
+	}
+	if p.observe(sequenceHeader(rtmp.TypeAudio, 990)); !p.settled(990) {
+		t.Fatalf("probe = %+v, want h264 and aac settled", p)
+	}
+}
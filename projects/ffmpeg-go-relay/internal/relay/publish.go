@@ -0,0 +1,141 @@
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/middleware"
+)
+
+// defaultTakeoverWait bounds how long a newcomer waits for the session it
+// replaced to end.
+const defaultTakeoverWait = 5 * time.Second
+
+// streamOwner is the session publishing a stream name under a duplicate
+// publish policy. done is closed when it gives the name up.
+type streamOwner struct {
+	requestID string
+	done      chan struct{}
+}
+
+// streamOwners maps stream names, without query, to their owner. Unlike
+// activeConnections it is checked and updated under one lock, so two
+// publishers racing for a name cannot both win.
+var streamOwners = struct {
+	mu sync.Mutex
+	m  map[string]*streamOwner
+}{m: make(map[string]*streamOwner)}
+
+// claimStream makes the session requestID the owner of stream according to
+// policy. It returns the request ID of a session it replaced, after waiting
+// for that session to end.
+func claimStream(ctx context.Context, policy config.DuplicatePublishConfig, requestID, stream, takeoverToken string) (*streamOwner, string, error) {
+	if policy.Policy == "" || policy.Policy == config.DuplicatePublishAllow || stream == "" {
+		return nil, "", nil
+	}
+	owner := &streamOwner{requestID: requestID, done: make(chan struct{})}
+
+	streamOwners.mu.Lock()
+	cur := streamOwners.m[stream]
+	if cur != nil {
+		switch policy.Policy {
+		case config.DuplicatePublishReject:
+			streamOwners.mu.Unlock()
+			return nil, "", fmt.Errorf("stream %q is already being published", stream)
+		case config.DuplicatePublishTakeover:
+			if subtle.ConstantTimeCompare([]byte(takeoverToken), []byte(policy.TakeoverToken)) != 1 {
+				streamOwners.mu.Unlock()
+				return nil, "", fmt.Errorf("stream %q is already being published and no valid takeover token was given", stream)
+			}
+		}
+	}
+	streamOwners.m[stream] = owner
+	streamOwners.mu.Unlock()
+	if cur == nil {
+		return owner, "", nil
+	}
+
+	killSession(cur.requestID, ReasonStreamConflict)
+	wait := policy.Wait.AsDuration()
+	if wait <= 0 {
+		wait = defaultTakeoverWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-cur.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return owner, cur.requestID, nil
+}
+
+// releaseStream gives up stream unless another session took it over.
+func releaseStream(stream string, owner *streamOwner) {
+	if owner == nil {
+		return
+	}
+	streamOwners.mu.Lock()
+	if streamOwners.m[stream] == owner {
+		delete(streamOwners.m, stream)
+	}
+	streamOwners.mu.Unlock()
+	close(owner.done)
+}
+
+// publishClaim is a session's hold on the stream it publishes: its place
+// under the publish limit and, with a duplicate publish policy, ownership
+// of the name. The zero claim admits everything.
+type publishClaim struct {
+	mu         sync.Mutex // The passthrough reader claims while the session may be releasing
+	limiter    *middleware.PublishLimiter
+	duplicates config.DuplicatePublishConfig
+	requestID  string
+	ip         string
+	token      string
+	takeover   string // Takeover token the client presented
+	stream     string // Held stream, without its query; empty when none is held
+	owner      *streamOwner
+}
+
+// claim admits a publish of stream, first giving up the stream the session
+// held before, if any. A refusal by the publish limit names the limit; a
+// stream conflict does not. replaced is the session it took stream from.
+func (p *publishClaim) claim(ctx context.Context, stream string) (scope, replaced string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stream = stripStreamQuery(stream)
+	if stream == p.stream {
+		return "", "", nil
+	}
+	p.releaseLocked()
+	if scope, err := p.limiter.Acquire(p.ip, p.token, stream); err != nil {
+		return scope, "", err
+	}
+	owner, replaced, err := claimStream(ctx, p.duplicates, p.requestID, stream, p.takeover)
+	if err != nil {
+		p.limiter.Release(p.ip, p.token, stream)
+		return "", "", err
+	}
+	p.stream, p.owner = stream, owner
+	return "", replaced, nil
+}
+
+func (p *publishClaim) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseLocked()
+}
+
+func (p *publishClaim) releaseLocked() {
+	if p.stream == "" {
+		return
+	}
+	p.limiter.Release(p.ip, p.token, p.stream)
+	releaseStream(p.stream, p.owner)
+	p.stream, p.owner = "", nil
+}
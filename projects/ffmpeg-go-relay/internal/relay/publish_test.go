@@ -0,0 +1,151 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/middleware"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestPublishClaimSwitchesStreams(t *testing.T) {
+	ctx := context.Background()
+	limiter := middleware.NewPublishLimiter(1, 0)
+	first := &publishClaim{limiter: limiter, ip: "10.0.0.1"}
+	if _, _, err := first.claim(ctx, "cam1?key=a"); err != nil {
+		t.Fatalf("claim cam1: %v", err)
+	}
+	// The same stream with another query is the same stream.
+	if _, _, err := first.claim(ctx, "cam1?key=b"); err != nil {
+		t.Fatalf("re-claim cam1: %v", err)
+	}
+	// Publishing another stream gives up the first.
+	if _, _, err := first.claim(ctx, "cam2"); err != nil {
+		t.Fatalf("claim cam2: %v", err)
+	}
+
+	second := &publishClaim{limiter: limiter, ip: "10.0.0.1"}
+	if scope, _, err := second.claim(ctx, "cam1"); err == nil || scope != middleware.PublishLimitIP {
+		t.Fatalf("second session: scope %q, err %v; want ip limit", scope, err)
+	}
+	first.release()
+	if _, _, err := second.claim(ctx, "cam1"); err != nil {
+		t.Fatalf("claim after release: %v", err)
+	}
+	second.release()
+	second.release()
+}
+
+func TestForwardMessagesRefusedPublish(t *testing.T) {
+	limiter := middleware.NewPublishLimiter(1, 0)
+	if _, err := limiter.Acquire("10.0.0.1", "", "other"); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	srv := &Server{Log: logger.NewWithWriter(io.Discard)}
+	pub := &publishClaim{limiter: limiter, ip: "10.0.0.1"}
+
+	publish, err := encodeCommand(rtmp.TypeAMF0Command, []interface{}{"publish", 5.0, nil, "cam1", "live"})
+	if err != nil {
+		t.Fatalf("encode publish: %v", err)
+	}
+	var in bytes.Buffer
+	if err := rtmp.NewChunkWriter(&in).WriteMessage(&rtmp.Message{
+		Header:  rtmp.ChunkHeader{CSID: rtmp.CSIDCommand, TypeID: rtmp.TypeAMF0Command, StreamID: 1},
+		Payload: publish,
+	}); err != nil {
+		t.Fatalf("write publish: %v", err)
+	}
+
+	ctx := ContextWithLogger(context.Background(), srv.Log)
+	var out bytes.Buffer
+	onPublish := func(stream string) error { return srv.claimPublish(ctx, pub, stream) }
+	err = forwardMessages(ctx, rtmp.NewChunkStream(&in), rtmp.NewChunkWriter(&out), newSessionQueue(0, nil), nil, onPublish, nil)
+	if got := terminationReason(err, ReasonClientDisconnect); got != ReasonQuota {
+		t.Fatalf("reason = %q (err %v), want %s", got, err, ReasonQuota)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("refused publish reached the upstream: %d bytes", out.Len())
+	}
+}
+
+// fakeSession registers a live session owning stream under policy, and
+// returns a channel receiving the reason it is killed for.
+func fakeSession(t *testing.T, policy config.DuplicatePublishConfig, requestID, stream string) (*publishClaim, <-chan string) {
+	t.Helper()
+	pub := &publishClaim{duplicates: policy, requestID: requestID}
+	if _, _, err := pub.claim(context.Background(), stream); err != nil {
+		t.Fatalf("claim %s: %v", stream, err)
+	}
+	killed := make(chan string, 1)
+	sessionKills.Store(requestID, func(reason string) {
+		killed <- reason
+		pub.release()
+	})
+	t.Cleanup(func() {
+		sessionKills.Delete(requestID)
+		pub.release()
+	})
+	return pub, killed
+}
+
+func TestDuplicatePublishReject(t *testing.T) {
+	policy := config.DuplicatePublishConfig{Policy: config.DuplicatePublishReject}
+	fakeSession(t, policy, "req-old", "cam-reject")
+
+	pub := &publishClaim{duplicates: policy, requestID: "req-new"}
+	if _, _, err := pub.claim(context.Background(), "cam-reject?key=1"); err == nil {
+		t.Fatal("expected the newcomer to be refused")
+	}
+	// Other names are unaffected.
+	if _, _, err := pub.claim(context.Background(), "cam-other"); err != nil {
+		t.Fatalf("claim of a free stream: %v", err)
+	}
+	pub.release()
+}
+
+func TestDuplicatePublishKick(t *testing.T) {
+	policy := config.DuplicatePublishConfig{Policy: config.DuplicatePublishKick, Wait: config.Duration(time.Second)}
+	_, killed := fakeSession(t, policy, "req-old", "cam-kick")
+
+	pub := &publishClaim{duplicates: policy, requestID: "req-new"}
+	_, replaced, err := pub.claim(context.Background(), "cam-kick")
+	if err != nil || replaced != "req-old" {
+		t.Fatalf("claim = replaced %q, err %v; want req-old replaced", replaced, err)
+	}
+	if reason := <-killed; reason != ReasonStreamConflict {
+		t.Fatalf("old session killed for %q, want %s", reason, ReasonStreamConflict)
+	}
+	streamOwners.mu.Lock()
+	owner := streamOwners.m["cam-kick"]
+	streamOwners.mu.Unlock()
+	if owner == nil || owner.requestID != "req-new" {
+		t.Fatalf("owner = %+v, want req-new", owner)
+	}
+	pub.release()
+}
+
+func TestDuplicatePublishTakeoverToken(t *testing.T) {
+	policy := config.DuplicatePublishConfig{Policy: config.DuplicatePublishTakeover, TakeoverToken: "s3cret", Wait: config.Duration(time.Second)}
+	_, killed := fakeSession(t, policy, "req-old", "cam-takeover")
+
+	wrong := &publishClaim{duplicates: policy, requestID: "req-wrong", takeover: "guess"}
+	if _, _, err := wrong.claim(context.Background(), "cam-takeover"); err == nil {
+		t.Fatal("expected a wrong takeover token to be refused")
+	}
+	select {
+	case reason := <-killed:
+		t.Fatalf("old session killed (%s) by a refused newcomer", reason)
+	default:
+	}
+
+	right := &publishClaim{duplicates: policy, requestID: "req-right", takeover: "s3cret"}
+	if _, replaced, err := right.claim(context.Background(), "cam-takeover"); err != nil || replaced != "req-old" {
+		t.Fatalf("claim = replaced %q, err %v; want req-old replaced", replaced, err)
+	}
+	right.release()
+}
@@ -0,0 +1,263 @@
+package relay
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// SlowConsumerPolicy decides what a subscription does with a message that
+// arrives while its buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropNewest discards the arriving message.
+	DropNewest SlowConsumerPolicy = iota
+	// DropOldest discards the oldest buffered message to make room.
+	DropOldest
+	// Disconnect closes the subscription; Err then returns ErrSlowConsumer.
+	Disconnect
+)
+
+// DefaultSubscriptionBuffer is the channel capacity used when
+// SubscribeOptions.Buffer is zero, about two seconds of 30fps video and audio.
+const DefaultSubscriptionBuffer = 128
+
+// ErrSlowConsumer is reported by subscriptions closed under Disconnect.
+var ErrSlowConsumer = errors.New("relay: subscriber fell behind the stream")
+
+// SubscribeOptions tune a Subscription.
+type SubscribeOptions struct {
+	Buffer int // Channel capacity; 0 uses DefaultSubscriptionBuffer
+	Policy SlowConsumerPolicy
+}
+
+// Subscription receives a live stream's media messages: audio, video and
+// script data, as published. Messages are shared with the relay and other
+// subscribers and must not be modified.
+type Subscription struct {
+	// C delivers messages until the subscription is closed. It stays open
+	// across publisher reconnects; a new publisher's stream starts with its
+	// own metadata and sequence headers.
+	C <-chan *rtmp.Message
+
+	ch      chan *rtmp.Message
+	stream  string
+	ms      *mediaStream
+	policy  SlowConsumerPolicy
+	dropped atomic.Uint64
+	closed  bool  // Guarded by the stream's mutex
+	err     error // Guarded by the stream's mutex
+}
+
+// Subscribe starts receiving the media of stream, published on this relay
+// with or without a query string. The stream need not be live yet. If it is,
+// its latest metadata and sequence headers are delivered first so decoding
+// can start at the next keyframe.
+func Subscribe(stream string, opts SubscribeOptions) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultSubscriptionBuffer
+	}
+	sub := &Subscription{
+		ch:     make(chan *rtmp.Message, opts.Buffer),
+		stream: stripStreamQuery(stream),
+		policy: opts.Policy,
+	}
+	sub.C = sub.ch
+
+	sub.ms = media.join(sub.stream, func(ms *mediaStream) {
+		for _, msg := range []*rtmp.Message{ms.meta, ms.videoSeq, ms.audioSeq} {
+			if msg != nil {
+				sub.deliverLocked(ms, msg)
+			}
+		}
+		ms.subs[sub] = struct{}{}
+	})
+	return sub
+}
+
+// Dropped returns how many messages were discarded because the subscriber
+// was not keeping up.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Err returns ErrSlowConsumer if the subscription was closed for falling
+// behind, and nil otherwise.
+func (s *Subscription) Err() error {
+	s.ms.mu.Lock()
+	defer s.ms.mu.Unlock()
+	return s.err
+}
+
+// Close stops delivery and closes C.
+func (s *Subscription) Close() {
+	s.ms.mu.Lock()
+	s.closeLocked(s.ms, nil)
+	s.ms.mu.Unlock()
+	media.release(s.stream)
+}
+
+func (s *Subscription) closeLocked(ms *mediaStream, err error) {
+	if s.closed {
+		return
+	}
+	s.closed, s.err = true, err
+	delete(ms.subs, s)
+	close(s.ch)
+}
+
+func (s *Subscription) deliverLocked(ms *mediaStream, msg *rtmp.Message) {
+	select {
+	case s.ch <- msg:
+		return
+	default:
+	}
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+		select {
+		case s.ch <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	case Disconnect:
+		s.dropped.Add(1)
+		s.closeLocked(ms, ErrSlowConsumer)
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// media is the process-wide fan-out of published streams, alongside the
+// activeConnections registry.
+var media = &mediaHub{streams: make(map[string]*mediaStream)}
+
+type mediaHub struct {
+	mu      sync.Mutex
+	streams map[string]*mediaStream
+}
+
+// mediaStream holds a stream's subscribers and what a late subscriber needs
+// to start decoding.
+type mediaStream struct {
+	mu         sync.Mutex
+	subs       map[*Subscription]struct{}
+	publishers int
+	meta       *rtmp.Message
+	videoSeq   *rtmp.Message
+	audioSeq   *rtmp.Message
+}
+
+// join returns name's entry, creating it if needed, after running fn on it
+// under both locks so a concurrent release cannot orphan what fn registers.
+func (h *mediaHub) join(name string, fn func(ms *mediaStream)) *mediaStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := h.streams[name]
+	if ms == nil {
+		ms = &mediaStream{subs: make(map[*Subscription]struct{})}
+		h.streams[name] = ms
+	}
+	ms.mu.Lock()
+	fn(ms)
+	ms.mu.Unlock()
+	return ms
+}
+
+// release forgets name once it has neither a publisher nor subscribers.
+func (h *mediaHub) release(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := h.streams[name]
+	if ms == nil {
+		return
+	}
+	ms.mu.Lock()
+	idle := ms.publishers == 0 && len(ms.subs) == 0
+	ms.mu.Unlock()
+	if idle {
+		delete(h.streams, name)
+	}
+}
+
+// mediaFeed publishes one session's media to the hub under the stream name
+// the client published. Like thumbnail.Tap it is fed from the session's
+// reader while the session goroutine closes it.
+type mediaFeed struct {
+	mu     sync.Mutex
+	stream string
+	ms     *mediaStream
+}
+
+func (f *mediaFeed) setStream(name string) {
+	name = stripStreamQuery(name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if name == f.stream {
+		return
+	}
+	f.endLocked()
+	f.stream = name
+	f.ms = media.join(name, func(ms *mediaStream) { ms.publishers++ })
+}
+
+func (f *mediaFeed) observe(msg *rtmp.Message) {
+	switch msg.Header.TypeID {
+	case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+	default:
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ms := f.ms
+	if ms == nil {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	// Subscribers and late joiners read msg after the session has released
+	// it, so they get a copy when its payload is leased.
+	keep := msg.Header.TypeID == rtmp.TypeAMF0Data || msg.IsVideoSequenceHeader() || msg.IsAACSequenceHeader()
+	if !keep && len(ms.subs) == 0 {
+		return
+	}
+	msg = msg.Detach()
+	switch {
+	case msg.Header.TypeID == rtmp.TypeAMF0Data:
+		ms.meta = msg
+	case msg.IsVideoSequenceHeader():
+		ms.videoSeq = msg
+	case msg.IsAACSequenceHeader():
+		ms.audioSeq = msg
+	}
+	for sub := range ms.subs {
+		sub.deliverLocked(ms, msg)
+	}
+}
+
+func (f *mediaFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.endLocked()
+	f.stream, f.ms = "", nil
+}
+
+// endLocked drops the cached headers of the stream being left, which belong
+// to this publisher only.
+func (f *mediaFeed) endLocked() {
+	if f.ms == nil {
+		return
+	}
+	f.ms.mu.Lock()
+	f.ms.publishers--
+	f.ms.meta, f.ms.videoSeq, f.ms.audioSeq = nil, nil, nil
+	f.ms.mu.Unlock()
+	media.release(f.stream)
+}
@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"errors"
+	"testing"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func mediaMsg(typeID uint8, ts uint32, payload ...byte) *rtmp.Message {
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: typeID, Timestamp: ts}, Payload: payload}
+}
+
+func TestSubscribeReceivesLiveMedia(t *testing.T) {
+	feed := &mediaFeed{}
+	feed.setStream("cam1?token=secret")
+	defer feed.close()
+
+	feed.observe(mediaMsg(rtmp.TypeAMF0Data, 0, 0x02))
+	feed.observe(mediaMsg(rtmp.TypeVideo, 0, 0x17, 0, 0, 0, 0)) // AVC sequence header
+	feed.observe(mediaMsg(rtmp.TypeVideo, 0, 0x17, 1, 0, 0, 0))
+	feed.observe(mediaMsg(rtmp.TypeAMF0Command, 0, 0x02)) // not media
+
+	// A late subscriber starts with the metadata and sequence header only.
+	sub := Subscribe("cam1", SubscribeOptions{})
+	defer sub.Close()
+	feed.observe(mediaMsg(rtmp.TypeVideo, 40, 0x27, 1, 0, 0, 0))
+
+	var got []uint8
+	for len(sub.C) > 0 {
+		got = append(got, (<-sub.C).Header.TypeID)
+	}
+	want := []uint8{rtmp.TypeAMF0Data, rtmp.TypeVideo, rtmp.TypeVideo}
+	if len(got) != len(want) {
+		t.Fatalf("received types %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("received types %v, want %v", got, want)
+		}
+	}
+
+	// The subscription outlives the publisher and picks up the next one.
+	feed.close()
+	next := &mediaFeed{}
+	next.setStream("cam1")
+	defer next.close()
+	next.observe(mediaMsg(rtmp.TypeAudio, 80, 0xaf, 1))
+	if msg := <-sub.C; msg.Header.Timestamp != 80 {
+		t.Fatalf("got timestamp %d from the new publisher", msg.Header.Timestamp)
+	}
+}
+
+func TestSubscriptionSlowConsumerPolicies(t *testing.T) {
+	feed := &mediaFeed{}
+	feed.setStream("slow")
+	defer feed.close()
+
+	newest := Subscribe("slow", SubscribeOptions{Buffer: 2, Policy: DropNewest})
+	defer newest.Close()
+	oldest := Subscribe("slow", SubscribeOptions{Buffer: 2, Policy: DropOldest})
+	defer oldest.Close()
+	disconnect := Subscribe("slow", SubscribeOptions{Buffer: 2, Policy: Disconnect})
+
+	for ts := uint32(1); ts <= 3; ts++ {
+		feed.observe(mediaMsg(rtmp.TypeAudio, ts, 0xaf, 1))
+	}
+
+	if (<-newest.C).Header.Timestamp != 1 || newest.Dropped() != 1 {
+		t.Fatalf("DropNewest kept the wrong messages, dropped %d", newest.Dropped())
+	}
+	if (<-oldest.C).Header.Timestamp != 2 || oldest.Dropped() != 1 {
+		t.Fatalf("DropOldest kept the wrong messages, dropped %d", oldest.Dropped())
+	}
+	for range disconnect.C {
+	}
+	if !errors.Is(disconnect.Err(), ErrSlowConsumer) {
+		t.Fatalf("Err = %v, want ErrSlowConsumer", disconnect.Err())
+	}
+	disconnect.Close() // closing twice is harmless
+}
+
+func TestMediaHubForgetsIdleStreams(t *testing.T) {
+	feed := &mediaFeed{}
+	feed.setStream("idle")
+	sub := Subscribe("idle", SubscribeOptions{})
+	feed.close()
+	sub.Close()
+
+	media.mu.Lock()
+	_, ok := media.streams["idle"]
+	media.mu.Unlock()
+	if ok {
+		t.Fatal("stream kept after its publisher and subscribers left")
+	}
+	if _, open := <-sub.C; open {
+		t.Fatal("C still open after Close")
+	}
+}
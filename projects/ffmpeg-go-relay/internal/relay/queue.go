@@ -0,0 +1,255 @@
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/rewrite"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// defaultSessionQueue is the number of client messages held per session
+// while the upstream is slow to accept them.
+const defaultSessionQueue = 512
+
+// sessionQueue is a bounded FIFO of client messages waiting to be written
+// upstream. When it fills, media is shed instead of letting latency grow:
+// inter-frame video first, then the oldest audio. Commands, control
+// messages, keyframes and sequence headers are never dropped; if nothing
+// else is left the reader blocks, pushing back on the client's TCP window.
+type sessionQueue struct {
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	msgs     []*rtmp.Message
+	limit    int
+	closed   bool
+	err      error
+	reg      *metrics.Registry
+
+	// Once video has been shed, later inter frames are dropped too until
+	// the next keyframe, so the upstream never sees a broken GOP.
+	skipVideo bool
+}
+
+func newSessionQueue(limit int, reg *metrics.Registry) *sessionQueue {
+	if limit <= 0 {
+		limit = defaultSessionQueue
+	}
+	q := &sessionQueue{limit: limit, reg: reg}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+	return q
+}
+
+// push queues msg, shedding media if the queue is full. It reports false once
+// the queue is closed.
+func (q *sessionQueue) push(msg *rtmp.Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keyframe := isVideoFrame(msg) && msg.IsVideoKeyframe()
+	if isVideoFrame(msg) && !keyframe && q.skipVideo {
+		q.drop(msg, "video")
+		return !q.closed
+	}
+
+	for len(q.msgs) >= q.limit && !q.closed {
+		if isVideoFrame(msg) && !keyframe {
+			q.skipVideo = true
+			q.drop(msg, "video")
+			return true
+		}
+		if q.shedVideo() || q.shedAudio() {
+			break
+		}
+		if isAudioFrame(msg) {
+			q.drop(msg, "audio")
+			return true
+		}
+		q.notFull.Wait()
+	}
+	if q.closed {
+		msg.Release()
+		return false
+	}
+	q.msgs = append(q.msgs, msg)
+	if keyframe {
+		q.skipVideo = false
+	}
+	q.notEmpty.Signal()
+	return true
+}
+
+// pop returns the next message, blocking until one is queued. After close it
+// drains what is left and then reports false.
+func (q *sessionQueue) pop() (*rtmp.Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.msgs) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.notEmpty.Wait()
+	}
+	msg := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	q.notFull.Signal()
+	return msg, true
+}
+
+// close stops the queue. The first non-nil err is kept for closeErr.
+func (q *sessionQueue) close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// releaseQueued releases the messages left in a closed queue that will not
+// be written.
+func (q *sessionQueue) releaseQueued() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.msgs {
+		m.Release()
+	}
+	clear(q.msgs)
+	q.msgs = nil
+}
+
+func (q *sessionQueue) closeErr() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// shedVideo drops every queued inter frame. Reports whether any were dropped.
+func (q *sessionQueue) shedVideo() bool {
+	kept := q.msgs[:0]
+	for _, m := range q.msgs {
+		if isVideoFrame(m) && !m.IsVideoKeyframe() {
+			q.drop(m, "video")
+			continue
+		}
+		kept = append(kept, m)
+	}
+	shed := len(kept) < len(q.msgs)
+	clear(q.msgs[len(kept):])
+	q.msgs = kept
+	if shed {
+		q.skipVideo = true
+	}
+	return shed
+}
+
+// shedAudio drops the oldest queued audio frame.
+func (q *sessionQueue) shedAudio() bool {
+	for i, m := range q.msgs {
+		if isAudioFrame(m) {
+			q.drop(m, "audio")
+			copy(q.msgs[i:], q.msgs[i+1:])
+			q.msgs[len(q.msgs)-1] = nil
+			q.msgs = q.msgs[:len(q.msgs)-1]
+			return true
+		}
+	}
+	return false
+}
+
+func (q *sessionQueue) drop(msg *rtmp.Message, kind string) {
+	msg.Release()
+	q.reg.RecordDroppedFrame(kind)
+}
+
+// isVideoFrame reports video media other than decoder configuration, for
+// legacy and Enhanced RTMP codecs alike.
+func isVideoFrame(msg *rtmp.Message) bool {
+	return msg.Header.TypeID == rtmp.TypeVideo && !msg.IsVideoSequenceHeader()
+}
+
+// isAudioFrame reports audio media other than decoder configuration.
+func isAudioFrame(msg *rtmp.Message) bool {
+	return msg.Header.TypeID == rtmp.TypeAudio && !msg.IsAACSequenceHeader()
+}
+
+// forwardMessages re-chunks messages from the client to the upstream through
+// q, rewriting stream names along the way. Reading runs in its own goroutine
+// so a slow upstream fills q, where media is shed, instead of stalling the
+// client. onPublish and onMedia, when set, run on the reading goroutine for
+// each publish and each message before it is queued; onMedia must copy
+// anything it keeps, since each message is released once written upstream
+// or shed. An error from either ends the session before the message reaches
+// the upstream. Returns nil when the client closes the connection.
+func forwardMessages(ctx context.Context, cs *rtmp.ChunkStream, cw *rtmp.ChunkWriter, q *sessionQueue, rw *rewrite.Rewriter, onPublish func(stream string) error, onMedia func(*rtmp.Message) error) error {
+	log := LoggerFromContext(ctx)
+	go func() {
+		q.close(readMessages(cs, q, rw, onPublish, onMedia, log))
+	}()
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return q.closeErr()
+		}
+		err := cw.WriteMessage(msg)
+		// The client's chunk size now applies to what we send upstream too.
+		if err == nil && msg.Header.TypeID == rtmp.TypeSetChunkSize && len(msg.Payload) >= 4 {
+			err = cw.SetChunkSize(binary.BigEndian.Uint32(msg.Payload))
+		}
+		msg.Release()
+		if err != nil {
+			q.close(err)
+			q.releaseQueued()
+			return err
+		}
+	}
+}
+
+func readMessages(cs *rtmp.ChunkStream, q *sessionQueue, rw *rewrite.Rewriter, onPublish func(string) error, onMedia func(*rtmp.Message) error, log *logger.Logger) error {
+	for {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg == nil {
+			continue
+		}
+		if onPublish != nil {
+			if stream, ok := publishedStream(msg); ok {
+				if err := onPublish(stream); err != nil {
+					msg.Release()
+					return err
+				}
+			}
+		}
+		if onMedia != nil {
+			if err := onMedia(msg); err != nil {
+				msg.Release()
+				return err
+			}
+		}
+		if rw == nil {
+			// No rules to apply
+		} else if rewritten, from, to, ok := rewriteStreamCommand(msg, rw); ok {
+			// The copy holds msg's lease, which its Release returns.
+			log.Info("rewrote stream name", "from", from, "to", to)
+			msg = rewritten
+		}
+		if !q.push(msg) {
+			return nil
+		}
+	}
+}
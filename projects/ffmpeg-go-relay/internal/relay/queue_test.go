@@ -0,0 +1,143 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+
+	"ffmpeg-go-relay/internal/pool"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func queueVideo(ts uint32, key bool) *rtmp.Message {
+	frame := byte(rtmp.FrameInterframe << 4)
+	if key {
+		frame = rtmp.FrameKeyframe << 4
+	}
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+		Payload: []byte{frame | rtmp.VideoAVC, rtmp.AVCPacketNALU, 0, 0, 0},
+	}
+}
+
+func queueAudio(ts uint32) *rtmp.Message {
+	return &rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeAudio, Timestamp: ts},
+		Payload: []byte{0xaf, 1},
+	}
+}
+
+func drain(q *sessionQueue) []uint32 {
+	q.close(nil)
+	var stamps []uint32
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return stamps
+		}
+		stamps = append(stamps, msg.Header.Timestamp)
+	}
+}
+
+func TestSessionQueueShedsInterFramesFirst(t *testing.T) {
+	q := newSessionQueue(3, nil)
+	q.push(queueVideo(0, true))
+	q.push(queueVideo(40, false))
+	q.push(queueAudio(41))
+
+	// Full: the queued inter frame goes, and video stays off until a keyframe.
+	q.push(queueAudio(60))
+	q.push(queueVideo(80, false))
+	// Nothing left to shed but audio; the keyframe itself is kept.
+	q.push(queueVideo(120, true))
+
+	got := drain(q)
+	want := []uint32{0, 60, 120}
+	if len(got) != len(want) {
+		t.Fatalf("queued = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("queued = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSessionQueueShedsOldestAudio(t *testing.T) {
+	q := newSessionQueue(2, nil)
+	q.push(queueAudio(0))
+	q.push(queueAudio(20))
+	q.push(queueVideo(30, true))
+
+	got := drain(q)
+	if len(got) != 2 || got[0] != 20 || got[1] != 30 {
+		t.Fatalf("queued = %v, want [20 30]", got)
+	}
+}
+
+func TestSessionQueueKeepsEnhancedSequenceStart(t *testing.T) {
+	hevc := func(ts uint32, frameType, packetType byte) *rtmp.Message {
+		return &rtmp.Message{
+			Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+			Payload: []byte{rtmp.VideoExHeader | frameType<<4 | packetType, 'h', 'v', 'c', '1'},
+		}
+	}
+	q := newSessionQueue(3, nil)
+	q.push(hevc(0, rtmp.FrameKeyframe, rtmp.PacketTypeCodedFramesX))
+	q.push(hevc(40, rtmp.FrameInterframe, rtmp.PacketTypeCodedFramesX))
+	q.push(queueAudio(41))
+	// Full: the inter frame is shed and video waits for a keyframe, but a
+	// new sequence start is decoder configuration and must get through.
+	q.push(queueAudio(42))
+	q.push(hevc(50, rtmp.FrameKeyframe, rtmp.PacketTypeSequenceStart))
+	q.push(hevc(60, rtmp.FrameInterframe, rtmp.PacketTypeCodedFramesX))
+
+	got := drain(q)
+	var sawStart bool
+	for _, ts := range got {
+		switch ts {
+		case 50:
+			sawStart = true
+		case 40, 60:
+			t.Fatalf("queued = %v, inter frame kept while shedding video", got)
+		}
+	}
+	if !sawStart {
+		t.Fatalf("queued = %v, sequence start was shed", got)
+	}
+}
+
+func TestSessionQueueReleasesShedMessages(t *testing.T) {
+	// Read the frames through a pooled chunk stream so they hold leases.
+	var wire bytes.Buffer
+	w := rtmp.NewChunkWriter(&wire)
+	for i, key := range []bool{true, false, false, false} {
+		msg := queueVideo(uint32(i*40), key)
+		msg.Header.CSID = rtmp.CSIDVideo
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp := pool.New(1024)
+	cs := rtmp.NewChunkStream(&wire)
+	cs.SetPayloadPool(bp)
+
+	q := newSessionQueue(2, nil)
+	for i := 0; i < 4; i++ {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.push(msg)
+	}
+	q.close(nil)
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			break
+		}
+		msg.Release()
+	}
+	if stats := bp.Stats(); stats["gets"] != int64(4) || stats["in_use"] != int64(0) {
+		t.Fatalf("pool stats = %v, want every leased payload back, shed or written", stats)
+	}
+}
@@ -0,0 +1,109 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"ffmpeg-go-relay/internal/cluster"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// Redirect reasons, used as metric labels.
+const (
+	redirectApp      = "app"
+	redirectOverflow = "overflow"
+)
+
+// errRedirected ends sessions whose publisher was sent to another relay.
+var errRedirected = errors.New("publisher redirected to another relay")
+
+// Redirector picks the relay a publisher is redirected to at connect time:
+// always for apps mapped to another relay, and once this relay runs
+// max_sessions for the rest. A nil Redirector never redirects.
+type Redirector struct {
+	apps        map[string]string
+	maxSessions int
+	targets     []string
+	next        atomic.Uint64
+	cluster     *cluster.Directory // Overflow goes to its least loaded relay when targets is empty
+	log         *logger.Logger
+}
+
+// NewRedirector returns a Redirector for cfg, or nil when cfg redirects
+// nothing. dir supplies the relays overflow can go to when cfg lists no
+// targets.
+func NewRedirector(cfg config.RedirectConfig, dir *cluster.Directory, log *logger.Logger) *Redirector {
+	if len(cfg.Apps) == 0 && cfg.MaxSessions <= 0 {
+		return nil
+	}
+	return &Redirector{
+		apps:        cfg.Apps,
+		maxSessions: cfg.MaxSessions,
+		targets:     cfg.Targets,
+		cluster:     dir,
+		log:         log,
+	}
+}
+
+// Target returns the URL a publisher connecting to app is redirected to and
+// why, or "" to serve it here. The app's query, which may carry a token, is
+// kept on the target.
+func (r *Redirector) Target(ctx context.Context, app string) (target, reason string) {
+	if r == nil {
+		return "", ""
+	}
+	name, query, _ := strings.Cut(app, "?")
+	if to, ok := r.apps[name]; ok {
+		return withAppQuery(to, query), redirectApp
+	}
+
+	// Sessions other than the one asking.
+	if r.maxSessions <= 0 || GetActiveConnectionCount()-1 < r.maxSessions {
+		return "", ""
+	}
+	base := r.overflowTarget(ctx)
+	if base == "" {
+		return "", ""
+	}
+	return strings.TrimRight(base, "/") + "/" + app, redirectOverflow
+}
+
+// overflowTarget takes the configured targets in turn, or else the least
+// loaded relay in the cluster registry with room to spare.
+func (r *Redirector) overflowTarget(ctx context.Context) string {
+	if len(r.targets) > 0 {
+		return r.targets[(r.next.Add(1)-1)%uint64(len(r.targets))]
+	}
+	nodes, err := r.cluster.Nodes(ctx)
+	if err != nil {
+		r.log.Warn("cannot list relays to redirect overflow to", "err", err)
+		return ""
+	}
+	var best *cluster.Node
+	for i, n := range nodes {
+		if n.RTMPURL == "" || n.Draining || n.Sessions >= r.maxSessions {
+			continue
+		}
+		if best == nil || n.Sessions < best.Sessions {
+			best = &nodes[i]
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.RTMPURL
+}
+
+// withAppQuery adds query, from the client's app, to target's.
+func withAppQuery(target, query string) string {
+	switch {
+	case query == "":
+		return target
+	case strings.Contains(target, "?"):
+		return target + "&" + query
+	}
+	return target + "?" + query
+}
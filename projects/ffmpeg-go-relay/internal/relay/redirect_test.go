@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// connectTo runs one session on srv and returns how the session ended and
+// the client's connect error.
+func connectTo(t *testing.T, srv *Server, app string) (string, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- srv.handle(context.Background(), server) }()
+
+	if err := rtmp.ClientHandshake(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(client), client)
+	_, err := session.Connect(app, "rtmp://relay/"+app)
+	return terminationReason(<-done, ""), err
+}
+
+func TestRedirectMappedApp(t *testing.T) {
+	srv := &Server{
+		Upstream: "rtmp://127.0.0.1:1/live",
+		Redirect: NewRedirector(config.RedirectConfig{Apps: map[string]string{"archive": "rtmp://relay-9:1935/archive"}}, nil, nil),
+		Log:      logger.NewWithWriter(io.Discard),
+	}
+	reason, err := connectTo(t, srv, "archive?token=abc")
+	var se *rtmp.StatusError
+	if !errors.As(err, &se) || se.Redirect != "rtmp://relay-9:1935/archive?token=abc" {
+		t.Fatalf("connect = %v, want a redirect to relay-9", err)
+	}
+	if reason != ReasonRedirected {
+		t.Fatalf("session ended with reason %q, want %s", reason, ReasonRedirected)
+	}
+}
+
+func TestRedirectOverflow(t *testing.T) {
+	r := NewRedirector(config.RedirectConfig{MaxSessions: 1, Targets: []string{"rtmp://relay-2:1935", "rtmp://relay-3:1935/"}}, nil, nil)
+	ctx := context.Background()
+
+	// Only the asking session is running.
+	trackConnectionStart(ConnectionInfo{RequestID: "req-asking", StartTime: time.Now()})
+	defer trackConnectionEnd("req-asking")
+	if target, _ := r.Target(ctx, "live"); target != "" {
+		t.Fatalf("target below max_sessions = %q, want none", target)
+	}
+
+	trackConnectionStart(ConnectionInfo{RequestID: "req-busy", StartTime: time.Now()})
+	defer trackConnectionEnd("req-busy")
+	for _, want := range []string{"rtmp://relay-2:1935/live", "rtmp://relay-3:1935/live", "rtmp://relay-2:1935/live"} {
+		if target, reason := r.Target(ctx, "live"); target != want || reason != redirectOverflow {
+			t.Fatalf("overflow target = %q (%s), want %q", target, reason, want)
+		}
+	}
+}
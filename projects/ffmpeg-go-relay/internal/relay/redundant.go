@@ -0,0 +1,233 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"ffmpeg-go-relay/internal/bond"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/profiling"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// legSetupTimeout bounds the RTMP connect and publish of one redundant leg.
+const legSetupTimeout = 10 * time.Second
+
+// redundantPool returns the pool a passthrough session for app is routed to
+// when that pool pushes to all of its upstreams at once, or nil.
+func (s *Server) redundantPool(app string) *UpstreamPool {
+	pool, _, ok := s.Routes.Match(app, "")
+	if !ok {
+		pool = s.UpstreamPool
+	}
+	if pool == nil || !pool.Redundant() {
+		return nil
+	}
+	return pool
+}
+
+// handleRedundant terminates the RTMP session locally and publishes the
+// stream to every upstream in pool at once. The session lasts while at least
+// one leg is up or reconnecting. The connect command has already been read
+// and authorized.
+func (s *Server) handleRedundant(ctx context.Context, downstream net.Conn, cs *rtmp.ChunkStream, connect []interface{}, app string, requestID string, pub *publishClaim, policy *streamPolicy, prof *profiling.Session, pool *UpstreamPool) error {
+	log := s.logger(ctx)
+	stopParse := prof.Track(profiling.PhaseParse)
+	defer stopParse()
+	session := rtmp.NewServerSession(cs, downstream)
+
+	var refused error
+	streamName, err := session.AcceptPublish(connect, func(stream string) string {
+		if refused = s.claimPublish(ctx, pub, stream); refused != nil {
+			return refused.Error()
+		}
+		return ""
+	})
+	if err != nil {
+		return withReason(ReasonProtocolError, &errclass.ProtocolError{Op: "rtmp command handshake", Err: err})
+	}
+	stopParse()
+	updateConnectionStream(requestID, streamName)
+	if refused != nil {
+		return refused
+	}
+
+	stream := stripStreamQuery(streamName)
+	endpoints := pool.Endpoints()
+	legs := make([]bond.Leg, 0, len(endpoints))
+	urls := make([]string, 0, len(endpoints))
+	for _, info := range endpoints {
+		legs = append(legs, bond.Leg{
+			Name: info.Raw,
+			Open: func(ctx context.Context) (bond.Sink, error) {
+				return s.openLeg(ctx, info, streamName)
+			},
+		})
+		urls = append(urls, info.Raw)
+	}
+	updateConnectionUpstream(requestID, strings.Join(urls, ","))
+	log.Info("redundant push started", "stream", streamName, "legs", len(legs))
+
+	queue := s.SessionQueue
+	if queue <= 0 {
+		queue = defaultSessionQueue
+	}
+	b := bond.New(ctx, legs, bond.Config{
+		Queue: queue,
+		OnChange: func(leg bond.LegStatus, all []bond.LegStatus) {
+			updateConnectionLegs(requestID, all)
+			s.Metrics.SetBondLegHealthy(stream, leg.Name, leg.Healthy())
+			switch {
+			case leg.State == bond.StateDown:
+				log.Warn("redundant leg down", "upstream", leg.Name, "err", leg.LastError, "healthy_legs", healthyLegs(all))
+			case leg.State == bond.StateUp && leg.Behind:
+				log.Warn("redundant leg falling behind, shedding media", "upstream", leg.Name)
+			case leg.State == bond.StateUp:
+				log.Info("redundant leg up", "upstream", leg.Name, "connects", leg.Connects, "healthy_legs", healthyLegs(all))
+			}
+		},
+	})
+	defer func() {
+		b.Close()
+		for _, url := range urls {
+			s.Metrics.DeleteBondLeg(stream, url)
+		}
+	}()
+
+	s.setState(ctx, StateRelaying)
+	defer prof.Track(profiling.PhaseCopy)()
+
+	thumbs := s.Thumbnails.NewTap()
+	thumbs.SetStream(stream)
+	defer thumbs.Close()
+	rec := s.DVR.NewTap(app)
+	rec.SetStream(stream)
+	defer rec.Close()
+	pkg := s.Packager.NewTap(app)
+	pkg.SetStream(stream)
+	defer pkg.Close()
+	feed := &mediaFeed{}
+	feed.setStream(streamName)
+	defer feed.close()
+	avs := s.newAVSync(log)
+	avs.setStream(streamName)
+	defer avs.close()
+	policy.setStream(streamName)
+	trackCodec := trackVideoCodec(requestID)
+
+	for {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return withReason(ReasonClientDisconnect, fmt.Errorf("read message: %w", err))
+		}
+		if msg == nil {
+			continue
+		}
+		if err := policy.observe(msg); err != nil {
+			return err
+		}
+
+		trackCodec(msg)
+		thumbs.Observe(msg)
+		rec.Observe(msg)
+		pkg.Observe(msg)
+		feed.observe(msg)
+		avs.observe(msg)
+
+		if err := b.WriteMessage(msg); err != nil {
+			s.Metrics.RecordUpstreamError("redundant")
+			return withReason(ReasonUpstreamError, err)
+		}
+	}
+}
+
+// openLeg dials info and publishes the stream on it. The upstream URL names
+// the app, and the stream too unless it ends there; then the client's
+// (rewritten) stream name is used. Legs connect with the first of the
+// upstream's credentials.
+func (s *Server) openLeg(ctx context.Context, info UpstreamInfo, streamName string) (bond.Sink, error) {
+	target, err := rtmp.ParseURL(info.Raw)
+	if err != nil {
+		return nil, err
+	}
+	name := target.Stream
+	if name == "" {
+		name, _ = s.Rewrite.Stream(streamName)
+	}
+	app, tcURL := target.App, target.TCURL()
+	if len(info.Credentials) > 0 {
+		app, tcURL = setQuery(app, info.Credentials[0].Query), setQuery(tcURL, info.Credentials[0].Query)
+	}
+
+	conn, err := s.openUpstream(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	// Setup runs on blocking reads, bounded by a deadline instead of ctx.
+	_ = conn.SetDeadline(time.Now().Add(legSetupTimeout))
+	client := rtmp.NewClientSession(rtmp.NewChunkStream(conn), metricsWriter{writer: conn, direction: "upstream", reg: s.Metrics})
+	if _, err := client.Connect(app, tcURL); err != nil {
+		conn.Close()
+		s.Metrics.RecordUpstreamError("connect")
+		return nil, &errclass.UpstreamError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Op: "connect", Err: err}
+	}
+	if _, err := client.Publish(name); err != nil {
+		conn.Close()
+		s.Metrics.RecordUpstreamError("publish")
+		return nil, &errclass.UpstreamError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Op: "publish", Err: fmt.Errorf("stream %s: %w", name, err)}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// The upstream's acknowledgements are read and dropped. The read
+	// failing means the upstream closed the leg, and closing the connection
+	// fails the next write.
+	go func() {
+		for {
+			if _, err := client.ReadMessage(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return &legSink{conn: conn, client: client}, nil
+}
+
+// legSink writes one redundant leg's media to its upstream.
+type legSink struct {
+	conn   net.Conn
+	client *rtmp.ClientSession
+}
+
+func (l *legSink) WriteMessage(msg *rtmp.Message) error {
+	if err := l.client.WriteMedia(msg); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return errUpstreamClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Close drops the connection, which also ends the publish upstream. It may
+// run while WriteMessage is blocked on a stalled upstream.
+func (l *legSink) Close() error {
+	return l.conn.Close()
+}
+
+func healthyLegs(legs []bond.LegStatus) int {
+	n := 0
+	for _, l := range legs {
+		if l.Healthy() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmptest"
+)
+
+func TestRedundantPushPublishesToEveryUpstream(t *testing.T) {
+	upA := rtmptest.NewServer(t, rtmptest.Script{})
+	upB := rtmptest.NewServer(t, rtmptest.Script{})
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{
+		{URL: upA.URL("live")},
+		{URL: upB.URL("ingest/backup")},
+	}, "redundant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{UpstreamPool: pool, Log: logger.NewWithWriter(io.Discard)}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			srv.ServeConn(ctx, conn)
+		}
+	}()
+
+	client, err := rtmptest.Dial(ln.Addr().String(), "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Publish("cam1"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			if _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Media sent before the legs are up is skipped; each leg starts at a
+	// keyframe once it has published.
+	upA.Expect(t, "connect live", "publish cam1")
+	upB.Expect(t, "connect ingest", "publish backup")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var legs []string
+		for _, c := range GetActiveConnectionsList() {
+			for _, l := range c.Legs {
+				if l.Healthy() {
+					legs = append(legs, l.Name)
+				}
+			}
+		}
+		if len(legs) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("healthy legs = %v, want both", legs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, ts := range []uint32{0, 40} {
+		msg := &rtmp.Message{
+			Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+			Payload: []byte{rtmp.FrameKeyframe<<4 | rtmp.VideoAVC, rtmp.AVCPacketNALU, 0, 0, 0},
+		}
+		if err := client.WriteMedia(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upA.Expect(t, "video 0", "video 40")
+	upB.Expect(t, "video 0", "video 40")
+}
+
+func TestRedundantPoolNeedsRTMPUpstreams(t *testing.T) {
+	_, err := NewUpstreamPool([]config.UpstreamEndpoint{
+		{URL: "rtmp://a.example.com/live"},
+		{URL: "srt://b.example.com:9000"},
+	}, "redundant")
+	if err == nil {
+		t.Fatal("expected an SRT leg to be refused")
+	}
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"bytes"
+
+	"ffmpeg-go-relay/internal/rewrite"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// streamNameArg is the index of the stream name in publish/play style
+// commands: [name, transactionID, null, streamName, ...].
+const streamNameArg = 3
+
+// streamNameCommands carry a stream name that rewrite rules apply to.
+var streamNameCommands = map[string]bool{
+	"releaseStream": true,
+	"FCPublish":     true,
+	"FCUnpublish":   true,
+	"publish":       true,
+	"play":          true,
+}
+
+// rewriteConnect applies app rules to a decoded connect command, updating the
+// app and tcUrl fields in place. Reports whether anything changed.
+func rewriteConnect(vals []interface{}, rw *rewrite.Rewriter) (string, bool) {
+	if len(vals) < 3 {
+		return "", false
+	}
+	cmdObj, ok := vals[2].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	app, _ := cmdObj["app"].(string)
+	newApp, ok := rw.App(app)
+	if !ok || newApp == app {
+		return app, false
+	}
+	cmdObj["app"] = newApp
+	if tcURL, ok := cmdObj["tcUrl"].(string); ok {
+		cmdObj["tcUrl"] = rewrite.TcURL(tcURL, newApp)
+	}
+	return newApp, true
+}
+
+// rewriteStreamCommand returns msg with its stream name rewritten when msg is
+// a publish/play style command matching a stream rule.
+func rewriteStreamCommand(msg *rtmp.Message, rw *rewrite.Rewriter) (*rtmp.Message, string, string, bool) {
+	if msg.Header.TypeID != rtmp.TypeAMF0Command && msg.Header.TypeID != rtmp.TypeAMF20Command {
+		return msg, "", "", false
+	}
+	vals, err := decodeConnectCommand(msg)
+	if err != nil || len(vals) <= streamNameArg {
+		return msg, "", "", false
+	}
+	name, _ := vals[0].(string)
+	if !streamNameCommands[name] {
+		return msg, "", "", false
+	}
+	from, ok := vals[streamNameArg].(string)
+	if !ok {
+		return msg, "", "", false
+	}
+	to, ok := rw.Stream(from)
+	if !ok || to == from {
+		return msg, "", "", false
+	}
+	vals[streamNameArg] = to
+	payload, err := encodeCommand(msg.Header.TypeID, vals)
+	if err != nil {
+		return msg, "", "", false
+	}
+	out := *msg
+	out.Payload = payload
+	return &out, from, to, true
+}
+
+// publishedStream returns the stream name of a publish command, as sent by
+// the client.
+func publishedStream(msg *rtmp.Message) (string, bool) {
+	return commandStream(msg, "publish")
+}
+
+// playedStream returns the stream name of a play command, as sent by the
+// client.
+func playedStream(msg *rtmp.Message) (string, bool) {
+	return commandStream(msg, "play")
+}
+
+func commandStream(msg *rtmp.Message, command string) (string, bool) {
+	if msg.Header.TypeID != rtmp.TypeAMF0Command && msg.Header.TypeID != rtmp.TypeAMF20Command {
+		return "", false
+	}
+	vals, err := decodeConnectCommand(msg)
+	if err != nil || len(vals) <= streamNameArg {
+		return "", false
+	}
+	if name, _ := vals[0].(string); name != command {
+		return "", false
+	}
+	stream, ok := vals[streamNameArg].(string)
+	return stream, ok && stream != ""
+}
+
+// encodeCommand serializes command values for an AMF0 or AMF3 command message.
+func encodeCommand(typeID uint8, vals []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if typeID == rtmp.TypeAMF20Command {
+		buf.WriteByte(0) // AMF0 payload in an AMF3 command message
+	}
+	if err := rtmp.EncodeAMF0(&buf, vals...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
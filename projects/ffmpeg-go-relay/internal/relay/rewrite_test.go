@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rewrite"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestRewriteConnect(t *testing.T) {
+	rw, err := rewrite.New([]rewrite.Rule{{Field: rewrite.FieldApp, Match: "live", Replace: "ingest"}})
+	if err != nil {
+		t.Fatalf("rewrite.New: %v", err)
+	}
+	vals := []interface{}{"connect", 1.0, map[string]interface{}{
+		"app":   "live",
+		"tcUrl": "rtmp://relay.example.com/live",
+	}}
+	app, ok := rewriteConnect(vals, rw)
+	if !ok || app != "ingest" {
+		t.Fatalf("rewriteConnect = %q, %v", app, ok)
+	}
+	obj := vals[2].(map[string]interface{})
+	if obj["app"] != "ingest" || obj["tcUrl"] != "rtmp://relay.example.com/ingest" {
+		t.Fatalf("command object = %v", obj)
+	}
+}
+
+func TestForwardMessagesRewritesPublish(t *testing.T) {
+	rw, err := rewrite.New([]rewrite.Rule{{Field: rewrite.FieldStream, Match: `vanity-(\w+)`, Replace: "sk_$1"}})
+	if err != nil {
+		t.Fatalf("rewrite.New: %v", err)
+	}
+
+	publish, err := encodeCommand(rtmp.TypeAMF0Command, []interface{}{"publish", 5.0, nil, "vanity-abc", "live"})
+	if err != nil {
+		t.Fatalf("encode publish: %v", err)
+	}
+	var in bytes.Buffer
+	client := rtmp.NewChunkWriter(&in)
+	if err := client.WriteSetChunkSize(4096); err != nil {
+		t.Fatalf("write set chunk size: %v", err)
+	}
+	if err := client.WriteMessage(&rtmp.Message{
+		Header:  rtmp.ChunkHeader{CSID: rtmp.CSIDCommand, TypeID: rtmp.TypeAMF0Command, StreamID: 1},
+		Payload: publish,
+	}); err != nil {
+		t.Fatalf("write publish: %v", err)
+	}
+	video := bytes.Repeat([]byte{0x17}, 3000)
+	if err := client.WriteMessage(&rtmp.Message{
+		Header:  rtmp.ChunkHeader{CSID: rtmp.CSIDVideo, TypeID: rtmp.TypeVideo, StreamID: 1, Timestamp: 40},
+		Payload: video,
+	}); err != nil {
+		t.Fatalf("write video: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := forwardMessages(ContextWithLogger(context.Background(), logger.New()), rtmp.NewChunkStream(&in), rtmp.NewChunkWriter(&out), newSessionQueue(0, nil), rw, nil, nil); err != nil {
+		t.Fatalf("forwardMessages: %v", err)
+	}
+
+	upstream := rtmp.NewChunkStream(&out)
+	if _, err := upstream.ReadMessage(); err != nil {
+		t.Fatalf("read set chunk size: %v", err)
+	}
+	msg, err := upstream.ReadMessage()
+	if err != nil {
+		t.Fatalf("read publish: %v", err)
+	}
+	vals, err := decodeConnectCommand(msg)
+	if err != nil {
+		t.Fatalf("decode publish: %v", err)
+	}
+	if vals[streamNameArg] != "sk_abc" {
+		t.Fatalf("forwarded stream name = %v, want sk_abc", vals[streamNameArg])
+	}
+	msg, err = upstream.ReadMessage()
+	if err != nil {
+		t.Fatalf("read video: %v", err)
+	}
+	if msg.Header.Timestamp != 40 || !bytes.Equal(msg.Payload, video) {
+		t.Fatal("video message altered in forwarding")
+	}
+}
@@ -0,0 +1,117 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// route binds a stream name pattern to its own upstream group.
+type route struct {
+	match string
+	pool  *UpstreamPool
+}
+
+// RouteStatus reports a route and the health of its upstreams.
+type RouteStatus struct {
+	Match     string           `json:"match"`
+	Strategy  string           `json:"strategy"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+// Router picks an upstream group by "app/stream" name, first match wins.
+// A nil Router matches nothing, leaving sessions on the global pool.
+type Router struct {
+	routes []route
+}
+
+// NewRouter builds one upstream pool per route. Routes without a strategy
+// use defaultStrategy. Returns nil when routes is empty.
+func NewRouter(routes []config.RouteConfig, defaultStrategy string) (*Router, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	r := &Router{}
+	for i, rc := range routes {
+		if _, err := path.Match(rc.Match, ""); err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		strategy := rc.Strategy
+		if strategy == "" {
+			strategy = defaultStrategy
+		}
+		pool, err := NewUpstreamPool(rc.Upstreams, strategy)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		r.routes = append(r.routes, route{match: rc.Match, pool: pool})
+	}
+	return r, nil
+}
+
+// Match returns the pool of the first route matching app/stream. Pass an
+// empty stream when the stream name is not known yet. A query string on app,
+// such as a tenant's token, is not part of the name.
+func (r *Router) Match(app, stream string) (*UpstreamPool, string, bool) {
+	if r == nil {
+		return nil, "", false
+	}
+	app, _, _ = strings.Cut(app, "?")
+	name := app + "/" + stream
+	for _, rt := range r.routes {
+		if ok, _ := path.Match(rt.match, name); ok {
+			return rt.pool, rt.match, true
+		}
+	}
+	return nil, "", false
+}
+
+// StartHealthChecks runs health checks on every route's upstreams.
+func (r *Router) StartHealthChecks(ctx context.Context, log *logger.Logger, cfg HealthCheckConfig) {
+	if r == nil {
+		return
+	}
+	for _, rt := range r.routes {
+		rt.pool.StartHealthChecks(ctx, log.With("route", rt.match), cfg)
+	}
+}
+
+// StartDNSRefresh re-resolves the expand_dns upstreams of every route.
+func (r *Router) StartDNSRefresh(ctx context.Context, log *logger.Logger, cfg DNSRefreshConfig) {
+	if r == nil {
+		return
+	}
+	for _, rt := range r.routes {
+		rt.pool.StartDNSRefresh(ctx, log.With("route", rt.match), cfg)
+	}
+}
+
+// EnableOutlierDetection turns on outlier detection in every route's pool.
+func (r *Router) EnableOutlierDetection(cfg OutlierConfig) {
+	if r == nil {
+		return
+	}
+	for _, rt := range r.routes {
+		rt.pool.EnableOutlierDetection(cfg)
+	}
+}
+
+// Stats returns a snapshot of every route in evaluation order.
+func (r *Router) Stats() []RouteStatus {
+	if r == nil {
+		return nil
+	}
+	stats := make([]RouteStatus, 0, len(r.routes))
+	for _, rt := range r.routes {
+		stats = append(stats, RouteStatus{
+			Match:     rt.match,
+			Strategy:  rt.pool.Strategy(),
+			Upstreams: rt.pool.Stats(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,45 @@
+package relay
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestRouterFirstMatchWins(t *testing.T) {
+	r, err := NewRouter([]config.RouteConfig{
+		{Match: "live/*", Upstreams: []config.UpstreamEndpoint{{URL: "rtmp://pool-a.example.com/live/"}}},
+		{Match: "*/*", Upstreams: []config.UpstreamEndpoint{{URL: "rtmp://pool-b.example.com/app/"}}},
+	}, "round_robin")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	cases := []struct {
+		app, stream, want string
+	}{
+		{"live", "cam1", "rtmp://pool-a.example.com/live/"},
+		{"live", "", "rtmp://pool-a.example.com/live/"},
+		{"backup", "cam1", "rtmp://pool-b.example.com/app/"},
+	}
+	for _, c := range cases {
+		pool, _, ok := r.Match(c.app, c.stream)
+		if !ok {
+			t.Fatalf("Match(%q, %q) found no route", c.app, c.stream)
+		}
+		if _, raw, _ := pool.Pick(); raw != c.want {
+			t.Fatalf("Match(%q, %q) picked %q, want %q", c.app, c.stream, raw, c.want)
+		}
+	}
+
+	if _, _, ok := r.Match("live/nested", "cam1"); ok {
+		t.Fatal("expected * not to match across path segments")
+	}
+}
+
+func TestNilRouterMatchesNothing(t *testing.T) {
+	var r *Router
+	if _, _, ok := r.Match("live", "cam1"); ok {
+		t.Fatal("nil router should not match")
+	}
+}
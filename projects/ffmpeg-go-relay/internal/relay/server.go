@@ -6,22 +6,39 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ffmpeg-go-relay/internal/accesslog"
 	"ffmpeg-go-relay/internal/auth"
+	"ffmpeg-go-relay/internal/bond"
 	"ffmpeg-go-relay/internal/circuit"
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/dvr"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/failover"
+	"ffmpeg-go-relay/internal/grace"
+	"ffmpeg-go-relay/internal/handoff"
+	"ffmpeg-go-relay/internal/journal"
 	"ffmpeg-go-relay/internal/logger"
 	"ffmpeg-go-relay/internal/metrics"
 	"ffmpeg-go-relay/internal/middleware"
+	"ffmpeg-go-relay/internal/packager"
 	"ffmpeg-go-relay/internal/pool"
+	"ffmpeg-go-relay/internal/profiling"
+	"ffmpeg-go-relay/internal/qos"
 	"ffmpeg-go-relay/internal/retry"
+	"ffmpeg-go-relay/internal/rewrite"
 	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/state"
+	"ffmpeg-go-relay/internal/thumbnail"
 	"ffmpeg-go-relay/internal/transcoder"
 )
 
@@ -37,11 +54,18 @@ func generateRequestID() string {
 
 // ConnectionInfo holds information about an active connection
 type ConnectionInfo struct {
-	RequestID  string    `json:"request_id"`
-	ClientAddr string    `json:"client_addr"`
-	Upstream   string    `json:"upstream"`
-	StartTime  time.Time `json:"start_time"`
-	State      string    `json:"state"` // "connecting", "handshaking", "relaying", "closing"
+	RequestID  string       `json:"request_id"`
+	ClientAddr string       `json:"client_addr"`
+	Upstream   string       `json:"upstream"`
+	Stream     string       `json:"stream,omitempty"` // Published stream name, once known
+	StartTime  time.Time    `json:"start_time"`
+	State      ConnState    `json:"state"`
+	States     []StateEntry `json:"states,omitempty"`      // Every state entered so far, oldest first
+	VideoCodec string       `json:"video_codec,omitempty"` // e.g. "h264", "hevc", "av1"; from the first video message
+
+	Transcode      *transcoder.Progress `json:"transcode,omitempty"`       // Encoder progress of transcoded sessions
+	TranscodeUsage *transcoder.Usage    `json:"transcode_usage,omitempty"` // CPU and memory of the session's ffmpeg process
+	Legs           []bond.LegStatus     `json:"legs,omitempty"`            // Health of each upstream of a redundant push
 }
 
 // activeConnections tracks all active connections for monitoring
@@ -59,6 +83,20 @@ func GetActiveConnectionsList() []ConnectionInfo {
 	return connections
 }
 
+// ActiveSessions returns the sessions in progress for the state store.
+func ActiveSessions() []state.Session {
+	var sessions []state.Session
+	for _, info := range GetActiveConnectionsList() {
+		sessions = append(sessions, state.Session{
+			RequestID:  info.RequestID,
+			ClientAddr: info.ClientAddr,
+			Stream:     stripStreamQuery(info.Stream),
+			Start:      info.StartTime,
+		})
+	}
+	return sessions
+}
+
 // GetActiveConnectionCount returns the number of active connections
 func GetActiveConnectionCount() int {
 	count := 0
@@ -73,7 +111,7 @@ func trackConnectionStart(info ConnectionInfo) {
 	activeConnections.Store(info.RequestID, info)
 }
 
-func updateConnectionState(requestID, state string) {
+func updateConnectionState(requestID string, state ConnState) {
 	value, ok := activeConnections.Load(requestID)
 	if !ok {
 		return
@@ -83,6 +121,8 @@ func updateConnectionState(requestID, state string) {
 		return
 	}
 	info.State = state
+	// A fresh slice, so copies handed out earlier never see the append.
+	info.States = append(info.States[:len(info.States):len(info.States)], StateEntry{State: state, Entered: time.Now()})
 	activeConnections.Store(requestID, info)
 }
 
@@ -99,86 +139,316 @@ func updateConnectionUpstream(requestID, upstream string) {
 	activeConnections.Store(requestID, info)
 }
 
+// stripStreamQuery drops the query string from a published name, which often
+// carries a publishing token.
+func stripStreamQuery(stream string) string {
+	name, _, _ := strings.Cut(stream, "?")
+	return name
+}
+
+// updateConnectionStream records the stream name without its query string.
+func updateConnectionStream(requestID, stream string) {
+	stream = stripStreamQuery(stream)
+	value, ok := activeConnections.Load(requestID)
+	if !ok {
+		return
+	}
+	info, ok := value.(ConnectionInfo)
+	if !ok {
+		return
+	}
+	info.Stream = stream
+	activeConnections.Store(requestID, info)
+}
+
+// trackVideoCodec returns a message observer that records the session's video
+// codec from the first video message it can parse.
+func trackVideoCodec(requestID string) func(*rtmp.Message) {
+	seen := false
+	return func(msg *rtmp.Message) {
+		if seen || msg.Header.TypeID != rtmp.TypeVideo {
+			return
+		}
+		h, err := rtmp.ParseVideoHeader(msg.Payload)
+		if err != nil {
+			return
+		}
+		seen = true
+		value, ok := activeConnections.Load(requestID)
+		if !ok {
+			return
+		}
+		info, ok := value.(ConnectionInfo)
+		if !ok {
+			return
+		}
+		info.VideoCodec = h.Codec()
+		activeConnections.Store(requestID, info)
+	}
+}
+
+func updateConnectionTranscode(requestID string, progress transcoder.Progress) {
+	value, ok := activeConnections.Load(requestID)
+	if !ok {
+		return
+	}
+	info, ok := value.(ConnectionInfo)
+	if !ok {
+		return
+	}
+	info.Transcode = &progress
+	activeConnections.Store(requestID, info)
+}
+
+func updateConnectionTranscodeUsage(requestID string, usage transcoder.Usage) {
+	value, ok := activeConnections.Load(requestID)
+	if !ok {
+		return
+	}
+	info, ok := value.(ConnectionInfo)
+	if !ok {
+		return
+	}
+	info.TranscodeUsage = &usage
+	activeConnections.Store(requestID, info)
+}
+
+func updateConnectionLegs(requestID string, legs []bond.LegStatus) {
+	value, ok := activeConnections.Load(requestID)
+	if !ok {
+		return
+	}
+	info, ok := value.(ConnectionInfo)
+	if !ok {
+		return
+	}
+	info.Legs = legs
+	activeConnections.Store(requestID, info)
+}
+
+// LookupStream returns the session currently publishing stream on this
+// relay, if any.
+func LookupStream(stream string) (ConnectionInfo, bool) {
+	var found ConnectionInfo
+	var ok bool
+	activeConnections.Range(func(key, value any) bool {
+		if info, isInfo := value.(ConnectionInfo); isInfo && info.Stream == stream && stream != "" {
+			found, ok = info, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
 func trackConnectionEnd(requestID string) {
 	activeConnections.Delete(requestID)
 }
 
+// sessionKills holds a function per live session that closes its client
+// connection, ending the session for the reason it is given.
+var sessionKills sync.Map
+
+// KillSession ends a live session by closing its client connection. It
+// reports whether a session with that request ID was found.
+func KillSession(requestID string) bool {
+	return killSession(requestID, ReasonAdminKill)
+}
+
+func killSession(requestID, reason string) bool {
+	v, ok := sessionKills.Load(requestID)
+	if !ok {
+		return false
+	}
+	v.(func(string))(reason)
+	return true
+}
+
 type Server struct {
 	ListenAddr          string
 	Upstream            string
 	UpstreamPool        *UpstreamPool
 	UpstreamHealthCheck HealthCheckConfig
+	UpstreamDNS         DNSRefreshConfig    // Re-resolution of expand_dns upstreams
+	OutlierDetection    OutlierConfig       // Ejection of pool upstreams whose connects keep failing
+	Prewarm             PrewarmConfig       // Handshaked upstream connections kept ready for new sessions
+	HappyEyeballs       HappyEyeballsConfig // RFC 8305 racing of upstream hostnames' address families
+	DownstreamTCP       TCPKeepalive        // Dead-peer detection on client sockets
+	UpstreamTCP         TCPKeepalive        // Dead-peer detection on upstream sockets
 	Idle                time.Duration
+	IdleTimeouts        IdleTimeouts  // Per direction and phase; zero fields fall back to Idle
+	StateTimeouts       StateTimeouts // Longest a session may stay in each state; zero fields are unbounded
 	ReadBuf             int
 	WriteBuf            int
 	Log                 *logger.Logger
 	Auth                *auth.TokenAuthenticator
 	RateLimit           *middleware.RateLimiter
 	ConnLimit           *middleware.ConnectionLimiter
+	PublishLimit        *middleware.PublishLimiter // nil leaves distinct streams per IP and token unlimited
+	DuplicatePublish    config.DuplicatePublishConfig
+	AVSync              config.AVSyncConfig
+	StreamPolicy        config.StreamPolicyConfig // Overridden per tenant
+	Tenants             *Tenants                  // nil holds every app to the global auth and limits
 	CircuitBreaker      *circuit.Breaker
 	BufPool             *pool.BytePool
 	RetryConfig         retry.Config
 	RetryJitter         float64
 	Transcode           config.TranscodeConfig
+	TranscodeProfiles   *TranscodeProfiles // nil gives every session Transcode
 	TLSConfig           *tls.Config
+	Profiler            *profiling.Sampler
+	ChunkLimits         rtmp.ChunkLimits
+	SessionQueue        int                    // Client messages queued toward the upstream; 0 uses the default
+	Handshake           *rtmp.HandshakeOptions // nil uses wall clock and crypto/rand
+	Failover            *failover.Manager
+	Grace               *grace.Holder // nil closes transcoded outputs as soon as the publisher leaves
+	Rewrite             *rewrite.Rewriter
+	TranscodeSwitch     *transcoder.KillSwitch // nil always transcodes when enabled
+	TranscodeSlots      *transcoder.Slots      // nil never limits concurrent transcodes
+	Drain               *Drain                 // nil never turns sessions away for maintenance
+	Redirect            *Redirector            // nil serves every publisher here
+	Routes              *Router                // nil sends every session to the global pool
+	Thumbnails          *thumbnail.Store       // nil takes no stream snapshots
+	DVR                 *dvr.Recorder          // nil records nothing for time-shifted playback
+	Packager            *packager.Packager     // nil packages no stream for HLS or DASH
+	Viewers             *Viewers               // nil counts no players and never caps them
+	Journal             *journal.Journal       // nil disables the session journal
+	State               *state.Store           // nil keeps no stream history across restarts
+	AccessLog           *accesslog.Log         // nil writes no per-session summaries
+	Events              *events.Bus            // nil announces no session or health events
+	Strict              bool                   // Check client messages against the RTMP spec; see ComplianceReports
+	Metrics             *metrics.Registry      // nil disables Prometheus metrics
+	Listener            net.Listener           // Accept sessions here instead of listening on ListenAddr
 	upstreamOnce        sync.Once
 	upstreamInfo        UpstreamInfo
 	upstreamErr         error
+	warm                *warmPool // nil unless Prewarm is enabled
+
+	readyOnce sync.Once
+	ready     chan struct{}
+	addr      net.Addr
+
+	shutdownMu sync.Mutex
+	shutdown   shutdownState
+	sessionWG  sync.WaitGroup
+}
+
+// Ready returns a channel closed once Run is accepting sessions. Tests wait on
+// it instead of sleeping after starting the server.
+func (s *Server) Ready() <-chan struct{} {
+	s.readyOnce.Do(func() { s.ready = make(chan struct{}) })
+	return s.ready
+}
+
+// Addr returns the address Run is listening on, resolving a ":0" ListenAddr
+// to the bound port. It is nil until Ready is closed.
+func (s *Server) Addr() net.Addr {
+	select {
+	case <-s.Ready():
+		return s.addr
+	default:
+		return nil
+	}
 }
 
 func (s *Server) Run(ctx context.Context) error {
+	if err := s.DownstreamTCP.check(); err != nil {
+		return fmt.Errorf("downstream tcp: %w", err)
+	}
+	if err := s.UpstreamTCP.check(); err != nil {
+		return fmt.Errorf("upstream tcp: %w", err)
+	}
+
 	var l net.Listener
 	var err error
-	if s.TLSConfig != nil {
-		l, err = tls.Listen("tcp", s.ListenAddr, s.TLSConfig)
-	} else {
-		l, err = net.Listen("tcp", s.ListenAddr)
+	switch {
+	case s.Listener != nil:
+		l = s.Listener
+	case s.TLSConfig != nil:
+		if l, err = handoff.Listen(s.ListenAddr, 0); err == nil {
+			l = tls.NewListener(l, s.TLSConfig)
+		}
+	default:
+		l, err = handoff.Listen(s.ListenAddr, 0)
 	}
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
 	defer l.Close()
+	if !s.setListener(l) {
+		return ErrServerClosed
+	}
 
-	s.Log.Infof("listening on %s -> %s", s.ListenAddr, s.Upstream)
+	s.Log.Infof("listening on %s -> %s", l.Addr(), s.Upstream)
 
-	var wg sync.WaitGroup
 	go func() {
 		<-ctx.Done()
 		l.Close()
 	}()
 
-	if s.UpstreamPool != nil && s.UpstreamHealthCheck.Enabled {
-		s.UpstreamPool.StartHealthChecks(ctx, s.Log, s.UpstreamHealthCheck)
+	healthCheck := s.UpstreamHealthCheck
+	healthCheck.Events = s.Events
+	if s.UpstreamPool != nil && healthCheck.Enabled {
+		s.UpstreamPool.StartHealthChecks(ctx, s.Log, healthCheck)
+	}
+	if healthCheck.Enabled {
+		s.Routes.StartHealthChecks(ctx, s.Log, healthCheck)
 	}
+	s.UpstreamPool.StartDNSRefresh(ctx, s.Log, s.UpstreamDNS)
+	s.UpstreamPool.EnableOutlierDetection(s.OutlierDetection)
+	s.Routes.EnableOutlierDetection(s.OutlierDetection)
+	s.Routes.StartDNSRefresh(ctx, s.Log, s.UpstreamDNS)
+	s.startPrewarm(ctx)
+
+	s.Ready()
+	s.addr = l.Addr()
+	close(s.ready)
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			if ctx.Err() != nil {
+			if ctx.Err() != nil || s.shuttingDown() {
 				break
 			}
 			s.Log.Errorf("accept: %v", err)
 			continue
 		}
-		wg.Add(1)
 		go func(c net.Conn) {
-			defer wg.Done()
-			if err := s.handle(ctx, c); err != nil {
+			if err := s.handle(ctx, c); err != nil && !errors.Is(err, ErrServerClosed) {
 				s.Log.Errorf("session error: %v", err)
 			}
 		}(conn)
 	}
 
-	wg.Wait()
-	return ctx.Err()
+	// Sessions run under ctx, so cancelling it ends every one of them;
+	// after Shutdown they finish on their own or at its deadline.
+	s.beginShutdown()
+	s.sessionWG.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return ErrServerClosed
+}
+
+// ServeConn relays a single already-accepted connection, such as an RTMPT tunnel.
+// It blocks until the session ends.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	return s.handle(ctx, conn)
 }
 
 func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 	defer downstream.Close()
+	ctx, untrack, ok := s.trackSession(ctx, downstream)
+	if !ok {
+		return ErrServerClosed
+	}
+	defer untrack()
 
 	// Generate request correlation ID for this session
 	requestID := generateRequestID()
-	log := s.Log.With("request_id", requestID, "client", downstream.RemoteAddr().String())
+	log := s.logger(ctx).With("request_id", requestID, "client", downstream.RemoteAddr().String())
+	ctx = ContextWithLogger(ctx, log)
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
 
 	start := time.Now()
 	connInfo := ConnectionInfo{
@@ -186,21 +456,69 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 		ClientAddr: downstream.RemoteAddr().String(),
 		Upstream:   "",
 		StartTime:  start,
-		State:      "connecting",
+		State:      StateConnecting,
+		States:     []StateEntry{{State: StateConnecting, Entered: start}},
 	}
 	trackConnectionStart(connInfo)
 	defer trackConnectionEnd(requestID)
+	s.publishSession(events.TypeConnectionStart, requestID, "", nil)
 
-	metrics.RecordConnectionStart()
+	var killedFor atomic.Value // Termination reason of a killed session
+	client := downstream
+	kill := func(reason string) {
+		killedFor.Store(reason)
+		client.Close()
+	}
+	sessionKills.Store(requestID, kill)
+	defer sessionKills.Delete(requestID)
+
+	// A session that overstays a state is killed, and its dials abandoned.
+	ctx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+	states := s.newSessionState(requestID, func() {
+		kill(ReasonStateTimeout)
+		cancelSession()
+	})
+	defer states.finish()
+	ctx = contextWithSessionState(ctx, states)
+
+	prof := s.Profiler.Start()
+	defer prof.End()
+
+	// endReason explains sessions that finish without an error, e.g. which
+	// side closed the relay first.
+	endReason := ReasonClientDisconnect
+	var app string
+	var tenant *Tenant
+	var counted *countingConn // Set when the access log is on
+	authResult := accesslog.AuthNone
+	var authToken string // The token the client authenticated with
+	s.Metrics.RecordConnectionStart()
 	defer func() {
-		metrics.ConnectionDuration.Observe(time.Since(start).Seconds())
+		s.setState(ctx, StateClosing)
+		if timedOut := states.err(); timedOut != nil {
+			err = withReason(ReasonStateTimeout, timedOut)
+		}
+		reason := terminationReason(err, endReason)
+		if killed, ok := killedFor.Load().(string); ok {
+			reason = killed
+		}
+		if tenant != nil {
+			s.Metrics.RecordTenantSession(tenant.App, reason)
+		}
+		s.Metrics.ObserveConnectionDuration(time.Since(start))
+		s.Metrics.RecordSessionEnd(reason, time.Since(start))
+		s.recordSession(requestID, app, start, reason, err)
+		s.logAccess(requestID, app, tenant, start, counted, authResult, reason, err)
+		s.publishSession(events.TypeConnectionEnd, requestID, reason, err)
 		if err != nil {
-			metrics.RecordConnectionError()
-			log.Error("session ended with error", "err", err, "duration", time.Since(start))
+			class := errclass.Of(err)
+			s.Metrics.RecordConnectionError(string(class))
+			log.Error("session ended with error", "err", err, "error_class", string(class), "duration", time.Since(start))
 			return
 		}
 		log.Info("session completed successfully", "duration", time.Since(start))
-		metrics.RecordConnectionSuccess()
+		s.Metrics.RecordConnectionSuccess()
 	}()
 
 	clientIP := extractIP(downstream.RemoteAddr().String())
@@ -216,18 +534,18 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 	// Apply rate limiting if configured
 	if s.RateLimit != nil {
 		if err = s.RateLimit.Allow(clientIP); err != nil {
-			metrics.RecordRateLimitRejection()
+			s.Metrics.RecordRateLimitRejection()
 			log.Warn("rate limit denied", "ip", clientIP, "err", err)
-			return err
+			return withReason(ReasonQuota, err)
 		}
 	}
 
 	// Apply connection limiting if configured
 	if s.ConnLimit != nil {
 		if err = s.ConnLimit.Acquire(clientIP); err != nil {
-			metrics.RecordConnectionLimitRejection()
+			s.Metrics.RecordConnectionLimitRejection()
 			log.Warn("connection limit denied", "ip", clientIP, "err", err)
-			return err
+			return withReason(ReasonQuota, err)
 		}
 		defer s.ConnLimit.Release(clientIP)
 	}
@@ -244,93 +562,62 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 			log.Warn("failed to set write buffer on downstream", "err", err)
 		}
 	}
-
-	downstream = wrapIdleConn(downstream, s.Idle)
-
-	info, upstreamRaw, errType, selectErr := s.selectUpstream()
-	if selectErr != nil {
-		metrics.RecordUpstreamError(errType)
-		return fmt.Errorf("%s upstream: %w", errType, selectErr)
+	if err := s.DownstreamTCP.apply(downstream); err != nil {
+		log.Warn("failed to set TCP keepalive on downstream", "err", err)
 	}
-	updateConnectionUpstream(requestID, upstreamRaw)
-	log = log.With("upstream", upstreamRaw)
 
-	if s.Transcode.Enabled {
-		return s.handleTranscode(ctx, downstream, log, requestID, upstreamRaw)
+	downstream = s.wrapIdle(ctx, downstream)
+	if s.AccessLog != nil {
+		counted = &countingConn{Conn: downstream}
+		downstream = counted
 	}
 
-	// Dial upstream with circuit breaker protection
-	dialStart := time.Now()
-	var upstream net.Conn
-
-	dialFn := func() error {
-		conn, dialErr := s.dialUpstream(ctx, info)
-		if dialErr == nil {
-			upstream = conn
-		}
-		return dialErr
-	}
-
-	if s.CircuitBreaker != nil {
-		err = s.CircuitBreaker.Call(dialFn)
-	} else {
-		err = dialFn()
-	}
-
-	if err != nil {
-		metrics.RecordUpstreamError("dial")
-		return fmt.Errorf("dial upstream: %w", err)
-	}
-	defer upstream.Close()
-
-	uTCP, _ := upstream.(*net.TCPConn)
-	if uTCP != nil {
-		if err := uTCP.SetNoDelay(true); err != nil {
-			log.Warn("failed to set TCP_NODELAY on upstream", "err", err)
-		}
-		if err := uTCP.SetReadBuffer(s.ReadBuf); err != nil {
-			log.Warn("failed to set read buffer on upstream", "err", err)
-		}
-		if err := uTCP.SetWriteBuffer(s.WriteBuf); err != nil {
-			log.Warn("failed to set write buffer on upstream", "err", err)
-		}
-	}
-
-	upstream = wrapIdleConn(upstream, s.Idle)
-
-	updateConnectionState(requestID, "handshaking")
-	if err := rtmp.ServerHandshake(downstream, nil); err != nil {
-		return fmt.Errorf("downstream handshake: %w", err)
+	s.setState(ctx, StateHandshaking)
+	stopParse := prof.Track(profiling.PhaseParse)
+	defer stopParse()
+	if err := rtmp.ServerHandshake(downstream, s.Handshake); err != nil {
+		return withReason(ReasonProtocolError, &errclass.ProtocolError{Op: "downstream handshake", Err: err})
 	}
 
 	// 1. Read and inspect the CONNECT command
 	log.Debug("reading connect message")
-	// We use a TeeReader to buffer the exact bytes of the connect command
-	// so we can replay them to the upstream if auth succeeds.
-	var connectBuf bytes.Buffer
-	tee := io.TeeReader(downstream, &connectBuf)
-	cs := rtmp.NewChunkStream(tee)
+	// We record the exact bytes of the connect command so we can replay
+	// them to the upstream if auth succeeds. With rewrite rules every
+	// message is re-encoded instead and the recording goes unused.
+	connectBuf := &connectRecorder{r: downstream}
+	cs := rtmp.NewChunkStreamWithLimits(connectBuf, s.ChunkLimits)
+	if s.Strict {
+		v := rtmp.NewValidator()
+		cs.SetMessageHook(v.Observe)
+		trackCompliance(requestID, v)
+		defer func() {
+			if report, ok := finishCompliance(requestID); ok && !report.Compliant {
+				log.Warn("session broke RTMP compliance", "violations", report.Counts, "messages", report.Messages)
+			}
+		}()
+	}
 
 	msg, err := cs.ReadMessage()
+	connectBuf.stop()
 	if err != nil {
 		log.Error("failed to read connect message", "err", err)
-		return fmt.Errorf("read connect message: %w", err)
+		return withReason(ReasonProtocolError, &errclass.ProtocolError{Op: "read connect message", Err: err})
 	}
 	log.Debug("read connect message", "type_id", msg.Header.TypeID, "length", msg.Header.Length)
 
 	// Decode AMF for AMF0 or AMF3 command messages.
 	amfData, err := decodeConnectCommand(msg)
 	if err != nil {
-		return fmt.Errorf("decode amf: %w", err)
+		return withReason(ReasonProtocolError, &errclass.ProtocolError{Op: "decode amf", Err: err})
 	}
 
 	if len(amfData) < 1 {
-		return fmt.Errorf("empty amf command")
+		return withReason(ReasonProtocolError, errclass.Protocolf("connect", "empty amf command"))
 	}
 
 	cmdName, ok := amfData[0].(string)
 	if !ok || cmdName != "connect" {
-		return fmt.Errorf("expected 'connect' command, got %v", amfData[0])
+		return withReason(ReasonProtocolError, errclass.Protocolf("connect", "expected 'connect' command, got %v", amfData[0]))
 	}
 
 	// Extract Auth Data
@@ -342,71 +629,270 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 
 	if cmdObj != nil {
 		// Example: Extract 'app' or custom 'token'
-		app, _ := cmdObj["app"].(string)
+		app, _ = cmdObj["app"].(string)
 		tcUrl, _ := cmdObj["tcUrl"].(string)
 
 		log.Info("rtmp connect", "app", app, "tcUrl", tcUrl)
+	}
 
-		if s.Auth != nil {
+	if s.Drain.Draining() {
+		code, description := s.Drain.Reply()
+		log.Info("draining, rejecting session", "app", app)
+		if err := rtmp.NewServerSession(cs, downstream).RejectCode(amfData, code, description); err != nil {
+			log.Debug("failed to send connect rejection", "err", err)
+		}
+		return withReason(ReasonDraining, errDraining)
+	}
+
+	// A tenant's own tokens replace the global ones for its app.
+	tenant = s.Tenants.Lookup(app)
+	authenticator := s.Tenants.Authenticator(app, s.Auth)
+
+	if cmdObj != nil {
+		if authenticator != nil {
 			// Simple Auth: Check if 'app' matches a valid token
-			// or if there's a specific 'token' field in the connection params
+			// or if there's a specific 'token' field in the connection params.
+			// Tenant apps are their name, so their token rides in the query.
 			token := app // Default usage
+			if tenant != nil {
+				token = appQueryToken(app)
+			}
 			if t, ok := cmdObj["token"].(string); ok {
 				token = t
 			}
 
-			if err = s.Auth.Authenticate(token); err != nil {
-				metrics.RecordAuthFailure()
+			if err = authenticator.Authenticate(token); err != nil {
+				authResult = accesslog.AuthRejected
+				s.Metrics.RecordAuthFailure()
+				if tenant != nil {
+					s.Metrics.RecordTenantRejection(tenant.App, tenantLimitAuth)
+				}
 				log.Warn("authentication failed", "token", token, "err", err)
-				return fmt.Errorf("authentication failed: %w", err)
+				return withReason(ReasonAuthFailure, &errclass.AuthError{Err: err})
 			}
+			authResult = accesslog.AuthOK
+			authToken = token
 		}
-	} else if s.Auth != nil {
-		metrics.RecordAuthFailure()
+	} else if authenticator != nil {
+		authResult = accesslog.AuthRejected
+		s.Metrics.RecordAuthFailure()
 		log.Warn("authentication failed", "err", "missing command object")
-		return fmt.Errorf("authentication failed: missing command object")
+		return withReason(ReasonAuthFailure, &errclass.AuthError{Err: errors.New("missing command object")})
 	}
 
-	// 2. Connect to Upstream
-	if err = rtmp.ClientHandshake(upstream, nil); err != nil {
-		metrics.RecordUpstreamError("handshake")
-		return fmt.Errorf("upstream handshake: %w", err)
+	if target, why := s.Redirect.Target(ctx, app); target != "" {
+		log.Info("redirecting publisher", "app", app, "target", target, "reason", why)
+		s.Metrics.RecordRedirect(why)
+		if err := rtmp.NewServerSession(cs, downstream).Redirect(amfData, target, "publish to "+target); err != nil {
+			log.Debug("failed to send connect redirect", "err", err)
+		}
+		return withReason(ReasonRedirected, errRedirected)
 	}
-	metrics.LatencyHistogram.Observe(time.Since(dialStart).Seconds())
 
-	log.Info("relaying", "client", connAddr(downstream), "upstream", upstreamRaw)
+	if tenant != nil {
+		if limit, err := tenant.admit(clientIP); err != nil {
+			s.Metrics.RecordTenantRejection(tenant.App, limit)
+			log.Warn("tenant limit denied", "tenant", tenant.App, "limit", limit, "err", err)
+			return withReason(ReasonQuota, err)
+		}
+		defer tenant.release(clientIP)
+		s.Metrics.AddTenantActiveSessions(tenant.App, 1)
+		defer s.Metrics.AddTenantActiveSessions(tenant.App, -1)
+	}
+	pub := &publishClaim{
+		limiter:    s.PublishLimit,
+		duplicates: s.DuplicatePublish,
+		requestID:  requestID,
+		ip:         clientIP,
+		token:      authToken,
+		takeover:   appQueryParam(app, "takeover"),
+	}
+	defer pub.release()
+	policy := s.newStreamPolicy(log, tenant)
+
+	stopParse()
+	s.setState(ctx, StateDialing)
+
+	tc, profile, chosen := s.transcodeFor(app, "", authToken)
+	if profile != "" {
+		log.Debug("transcode profile selected", "app", app, "profile", profile)
+	}
+	if tc.Enabled || !chosen {
+		switch {
+		case !s.TranscodeSwitch.Allowed(app):
+			if s.TranscodeSwitch.Fallback() == transcoder.FallbackReject {
+				log.Warn("transcoding disabled, rejecting session", "app", app)
+				if err := rtmp.NewServerSession(cs, downstream).Reject(amfData, "transcoding disabled"); err != nil {
+					log.Debug("failed to send connect rejection", "err", err)
+				}
+				return withReason(ReasonAdminKill, errTranscodeDisabled)
+			}
+			log.Warn("transcoding disabled, falling back to passthrough", "app", app)
+		case !s.TranscodeSlots.TryAcquire():
+			overflow := s.TranscodeSlots.Overflow()
+			s.Metrics.RecordTranscodeSlotOverflow(overflow)
+			if overflow == transcoder.FallbackReject {
+				log.Warn("transcode slots exhausted, rejecting publish", "app", app)
+				stream, err := rtmp.NewServerSession(cs, downstream).RejectPublish(amfData, "transcoding capacity exhausted")
+				if err != nil {
+					log.Debug("failed to send publish rejection", "err", err)
+				}
+				updateConnectionStream(requestID, stream)
+				return withReason(ReasonTranscodeBusy, errTranscodeBusy)
+			}
+			log.Warn("transcode slots exhausted, falling back to passthrough", "app", app)
+		default:
+			// Held before the publish so a full relay can still refuse it;
+			// handleTranscode gives it back early if the session remuxes.
+			slot := s.heldTranscodeSlot()
+			defer slot.release()
+			return s.handleTranscode(ctx, downstream, cs, amfData, app, requestID, pub, policy, prof, tc, "", slot)
+		}
+	}
+
+	// The stream name is not known before the upstream connect, so
+	// passthrough sessions are routed on the app alone.
+	if pool := s.redundantPool(app); pool != nil {
+		return s.handleRedundant(ctx, downstream, cs, amfData, app, requestID, pub, policy, prof, pool)
+	}
+	info, upstreamRaw, errType, selectErr := s.selectUpstream(ctx, app, "")
+	if selectErr != nil {
+		s.Metrics.RecordUpstreamError(errType)
+		return withReason(ReasonUpstreamError, fmt.Errorf("%s upstream: %w", errType, selectErr))
+	}
+	if info.Remuxed() {
+		// RTSP and SRT upstreams get the media remuxed, codecs untouched.
+		return s.handleTranscode(ctx, downstream, cs, amfData, app, requestID, pub, policy, prof, transcoder.Remux(s.Transcode), upstreamRaw, nil)
+	}
+	updateConnectionUpstream(requestID, upstreamRaw)
+	log = log.With("upstream", upstreamRaw)
+	ctx = ContextWithLogger(ctx, log)
+
+	// 2. Connect to Upstream and replay the connect command.
+	// Everything after connect is forwarded message by message through a
+	// bounded queue, so a slow upstream sheds frames instead of adding delay.
+	if s.Rewrite != nil {
+		if app, ok := rewriteConnect(amfData, s.Rewrite); ok {
+			log.Info("rewrote connect app", "app", app)
+		}
+	}
+	var upstream net.Conn
+	var cw *rtmp.ChunkWriter
+	if len(info.Credentials) > 0 {
+		upstream, cw, err = s.connectWithCredentials(ctx, info, downstream, msg.Header, amfData)
+		if err != nil {
+			return err
+		}
+		defer upstream.Close()
+	} else {
+		upstream, err = s.openUpstream(ctx, info)
+		if err != nil {
+			return err
+		}
+		defer upstream.Close()
 
-	// 3. Replay Connect Command
-	if _, err := upstream.Write(connectBuf.Bytes()); err != nil {
-		return fmt.Errorf("forward connect: %w", err)
+		cw = rtmp.NewChunkWriter(metricsWriter{writer: upstream, direction: "upstream", reg: s.Metrics})
+		if s.Rewrite != nil {
+			payload, err := encodeCommand(msg.Header.TypeID, amfData)
+			if err != nil {
+				return withReason(ReasonProtocolError, fmt.Errorf("encode connect: %w", err))
+			}
+			if err := cw.WriteMessage(&rtmp.Message{Header: msg.Header, Payload: payload}); err != nil {
+				return withReason(ReasonUpstreamError, fmt.Errorf("forward connect: %w", err))
+			}
+		} else if _, err := upstream.Write(connectBuf.buf.Bytes()); err != nil {
+			return withReason(ReasonUpstreamError, fmt.Errorf("forward connect: %w", err))
+		}
 	}
 
-	updateConnectionState(requestID, "relaying")
+	log.Info("relaying", "client", connAddr(downstream), "upstream", upstreamRaw)
+
+	s.setState(ctx, StateRelaying)
+	defer prof.Track(profiling.PhaseCopy)()
 
 	copyCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	thumbs := s.Thumbnails.NewTap()
+	defer thumbs.Close()
+	rec := s.DVR.NewTap(app)
+	defer rec.Close()
+	pkg := s.Packager.NewTap(app)
+	defer pkg.Close()
+	feed := &mediaFeed{}
+	defer feed.close()
+	avs := s.newAVSync(log)
+	defer avs.close()
+	view := &viewerClaim{viewers: s.Viewers}
+	defer view.release()
+
+	// Each copier reports the reason to use if it is the side that ends the relay.
 	errCh := make(chan error, 2)
 	go func() {
-		buf := s.getBuffer()
-		defer s.putBuffer(buf)
-		_, err := io.CopyBuffer(metricsWriter{writer: upstream, direction: "upstream"}, downstream, buf)
-		errCh <- err
+		onPublish := func(stream string) error {
+			if err := s.claimPublish(ctx, pub, stream); err != nil {
+				return err
+			}
+			updateConnectionStream(requestID, stream)
+			thumbs.SetStream(stripStreamQuery(stream))
+			rec.SetStream(stripStreamQuery(stream))
+			pkg.SetStream(stripStreamQuery(stream))
+			feed.setStream(stream)
+			avs.setStream(stream)
+			policy.setStream(stream)
+			return nil
+		}
+		trackCodec := trackVideoCodec(requestID)
+		onMedia := func(msg *rtmp.Message) error {
+			if stream, ok := playedStream(msg); ok {
+				if err := s.claimView(ctx, view, stream); err != nil {
+					return err
+				}
+			}
+			if err := policy.observe(msg); err != nil {
+				return err
+			}
+			trackCodec(msg)
+			thumbs.Observe(msg)
+			rec.Observe(msg)
+			pkg.Observe(msg)
+			feed.observe(msg)
+			avs.observe(msg)
+			return nil
+		}
+		cs.SetPayloadPool(s.BufPool)
+		err := forwardMessages(copyCtx, cs, cw, newSessionQueue(s.SessionQueue, s.Metrics), s.Rewrite, onPublish, onMedia)
+		errCh <- withReason(ReasonClientDisconnect, err)
 		cancel()
 	}()
 	go func() {
-		buf := s.getBuffer()
-		defer s.putBuffer(buf)
-		_, err := io.CopyBuffer(metricsWriter{writer: downstream, direction: "downstream"}, upstream, buf)
-		errCh <- err
+		// Upstream bytes go back to the client unmodified, so this side can
+		// stay in the kernel when both sockets allow it.
+		n, spliced, err := spliceCopy(downstream, upstream, "downstream", s.Metrics)
+		counted.addWritten(n)
+		if !spliced {
+			buf := s.getBuffer()
+			defer s.putBuffer(buf)
+			_, err = io.CopyBuffer(metricsWriter{writer: downstream, direction: "downstream", reg: s.Metrics}, upstream, buf)
+		}
+		if err == nil {
+			err = errUpstreamClosed
+		}
+		errCh <- withReason(ReasonUpstreamError, err)
 		cancel()
 	}()
 
 	// Wait for context cancellation or first error
 	select {
 	case <-copyCtx.Done():
+		if ctx.Err() != nil {
+			endReason = ReasonShutdown
+		}
 	case copyErr := <-errCh:
-		if copyErr != nil {
+		if errors.Is(copyErr, errUpstreamClosed) {
+			log.Info("upstream closed the relay")
+			endReason = ReasonUpstreamError
+		} else if copyErr != nil {
 			log.Error("copy error", "err", copyErr, "direction", "first")
 			err = copyErr
 		}
@@ -416,7 +902,7 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 	// Use a short timeout to avoid blocking forever if goroutine is stuck
 	select {
 	case copyErr := <-errCh:
-		if copyErr != nil && err == nil {
+		if copyErr != nil && err == nil && !errors.Is(copyErr, errUpstreamClosed) {
 			log.Error("copy error", "err", copyErr, "direction", "second")
 			err = copyErr
 		}
@@ -427,66 +913,484 @@ func (s *Server) handle(ctx context.Context, downstream net.Conn) (err error) {
 	return err
 }
 
-func (s *Server) handleTranscode(ctx context.Context, downstream net.Conn, log *logger.Logger, requestID, upstream string) error {
-	// 1. Handshake (Server Side)
-	// We need to act as an RTMP server to the client.
-	updateConnectionState(requestID, "handshaking")
-	if err := rtmp.ServerHandshake(downstream, nil); err != nil {
-		return fmt.Errorf("server handshake: %w", err)
+// transcodeSlot is a TranscodeSlots slot held by one session. A nil
+// transcodeSlot holds nothing.
+type transcodeSlot struct {
+	s    *Server
+	once sync.Once
+}
+
+// heldTranscodeSlot accounts for a slot TranscodeSlots.TryAcquire has just
+// granted.
+func (s *Server) heldTranscodeSlot() *transcodeSlot {
+	s.Metrics.AddTranscodeSlotsInUse(1)
+	return &transcodeSlot{s: s}
+}
+
+// release gives the slot back; later calls do nothing.
+func (t *transcodeSlot) release() {
+	if t == nil {
+		return
 	}
+	t.once.Do(func() {
+		t.s.TranscodeSlots.Release()
+		t.s.Metrics.AddTranscodeSlotsInUse(-1)
+	})
+}
 
-	cs := rtmp.NewChunkStream(downstream)
+// handleTranscode terminates the RTMP session locally and feeds the media to
+// a transcoder run with cfg, which also remuxes for non-RTMP upstreams. A
+// cfg that is not enabled means the transcode profile depends on the stream
+// name and is picked once the publish names it. upstream is the one already
+// picked for the session, or empty to pick it by the stream name too. slot,
+// if any, is released as soon as the session turns out to only remux. The
+// connect command has already been read and authorized.
+func (s *Server) handleTranscode(ctx context.Context, downstream net.Conn, cs *rtmp.ChunkStream, connect []interface{}, app string, requestID string, pub *publishClaim, policy *streamPolicy, prof *profiling.Session, cfg config.TranscodeConfig, upstream string, slot *transcodeSlot) error {
+	log := s.logger(ctx)
+	// 1. Command handshake (Server Side)
+	// We need to act as an RTMP server to the client.
+	stopParse := prof.Track(profiling.PhaseParse)
+	defer stopParse()
 	session := rtmp.NewServerSession(cs, downstream)
 
-	streamName, err := session.Handshake()
+	var refused error
+	streamName, err := session.AcceptPublish(connect, func(stream string) string {
+		if refused = s.claimPublish(ctx, pub, stream); refused != nil {
+			return refused.Error()
+		}
+		return ""
+	})
 	if err != nil {
-		return fmt.Errorf("rtmp command handshake: %w", err)
+		return withReason(ReasonProtocolError, &errclass.ProtocolError{Op: "rtmp command handshake", Err: err})
+	}
+	stopParse()
+	updateConnectionStream(requestID, streamName)
+	if refused != nil {
+		return refused
+	}
+	if !cfg.Enabled {
+		var profile string
+		cfg, profile, _ = s.transcodeFor(app, streamName, pub.token)
+		if !cfg.Enabled {
+			cfg = transcoder.Remux(s.Transcode)
+			slot.release()
+		}
+		log.Debug("transcode profile selected", "stream", streamName, "profile", profile)
 	}
-	log.Info("transcode session started", "stream", streamName)
 
-	// 2. Start FFmpeg
-	// If upstream ends with /, append streamName
-	upstreamURL := upstream
-	if strings.HasSuffix(upstreamURL, "/") {
-		upstreamURL += streamName
+	if upstream == "" {
+		var errType string
+		if _, upstream, errType, err = s.selectUpstream(ctx, app, streamName); err != nil {
+			s.Metrics.RecordUpstreamError(errType)
+			return withReason(ReasonUpstreamError, fmt.Errorf("%s upstream: %w", errType, err))
+		}
 	}
+	updateConnectionUpstream(requestID, upstream)
+	log = log.With("upstream", upstream)
+	ctx = ContextWithLogger(ctx, log)
+	log.Info("transcode session started", "stream", streamName)
 
-	tr, err := transcoder.New(ctx, s.Transcode, upstreamURL, log)
+	// Hold the media back until its tracks and decoder configuration are
+	// known, so the transcoder is fed a correct FLV header and decodable
+	// frames. In smart mode the codecs also decide whether it transcodes.
+	probe, held, err := probeMedia(cs)
+	if err == io.EOF {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("start transcoder: %w", err)
+		return withReason(ReasonClientDisconnect, fmt.Errorf("read message: %w", err))
 	}
-	defer tr.Close()
-
-	// 3. Write FLV Header
-	// We assume Audio+Video presence. In a real system, we might wait for the first A/V packets to decide.
-	if err := rtmp.WriteFLVHeader(tr, true, true); err != nil {
-		return fmt.Errorf("write flv header: %w", err)
+	if probe.dropped > 0 {
+		log.Warn("dropped frames sent ahead of their decoder configuration", "stream", streamName, "frames", probe.dropped)
+	}
+	if probe.video == "" && probe.audio == "" {
+		log.Warn("publisher sent no media while probing", "stream", streamName)
+	}
+	if transcoder.Smart(cfg) {
+		decision := smartTranscode
+		if transcoder.Compatible(cfg, probe.video, probe.audio) {
+			cfg, decision = transcoder.Remux(cfg), smartRemux
+			slot.release()
+		}
+		s.Metrics.RecordTranscodeSmartDecision(decision)
+		log.Info("smart transcode decided", "stream", streamName, "video", probe.video, "audio", probe.audio, "decision", decision)
+	}
+	tracks := flvTracks{audio: probe.hasAudio(), video: probe.hasVideo()}
+
+	// 2. Start FFmpeg, or join the shared output of a primary/backup pair.
+	// Pairs and held outputs are keyed by the stream name alone, so a token
+	// or takeover query does not keep a publisher from its pair or output.
+	// Shared outputs outlive the publisher that opened them, so they run
+	// under a context its disconnect does not cancel; the failover pair or
+	// the grace holder closes them once no publisher is left.
+	var out messageWriter
+	outputURL := s.transcodeURL(upstream, streamName)
+	streamKey := stripStreamQuery(streamName)
+	shared := context.WithoutCancel(ctx)
+	if stream, role, ok := s.Failover.Lookup(streamKey); ok {
+		outputURL = s.transcodeURL(upstream, stream)
+		member, err := s.Failover.Join(streamKey, func(stream string) (failover.Sink, error) {
+			return newFLVSink(shared, cfg, tracks, s.transcodeURL(upstream, stream), s.Log.With("stream", stream))
+		})
+		if errors.Is(err, failover.ErrRoleTaken) {
+			return withReason(ReasonProtocolError, fmt.Errorf("join failover pair: %w", err))
+		}
+		if err != nil {
+			return withReason(ReasonTranscodeError, &errclass.TranscodeError{Op: "join failover pair", Err: err})
+		}
+		defer member.Close()
+		log.Info("publisher joined failover pair", "pair", stream, "role", role.String())
+		out = member
+	} else if s.Grace != nil {
+		holder, resumed, err := s.Grace.Join(streamKey, func() (grace.Sink, error) {
+			return newFLVSink(shared, cfg, tracks, outputURL, s.Log.With("stream", streamKey))
+		})
+		if errors.Is(err, grace.ErrPublisherConnected) {
+			return withReason(ReasonProtocolError, fmt.Errorf("join held output: %w", err))
+		}
+		if err != nil {
+			return withReason(ReasonTranscodeError, &errclass.TranscodeError{Op: "join held output", Err: err})
+		}
+		defer holder.Close()
+		if resumed {
+			log.Info("publisher resumed held output", "stream", streamKey)
+		}
+		out = holder
+	} else {
+		sink, err := newFLVSink(transcoder.ContextWithRequestID(ctx, requestID), cfg, tracks, outputURL, log)
+		if err != nil {
+			return withReason(ReasonTranscodeError, err)
+		}
+		defer sink.Close()
+		out = sink
 	}
 
-	updateConnectionState(requestID, "relaying")
-
-	// 4. Relay Loop
+	s.setState(ctx, StateRelaying)
+	defer prof.Track(profiling.PhaseTranscode)()
+	defer s.trackProgress(requestID, streamName, outputURL)()
+
+	thumbs := s.Thumbnails.NewTap()
+	thumbs.SetStream(stripStreamQuery(streamName))
+	defer thumbs.Close()
+	rec := s.DVR.NewTap(app)
+	rec.SetStream(stripStreamQuery(streamName))
+	defer rec.Close()
+	pkg := s.Packager.NewTap(app)
+	pkg.SetStream(stripStreamQuery(streamName))
+	defer pkg.Close()
+	feed := &mediaFeed{}
+	feed.setStream(streamName)
+	defer feed.close()
+	avs := s.newAVSync(log)
+	avs.setStream(streamName)
+	defer avs.close()
+	policy.setStream(streamName)
+	trackCodec := trackVideoCodec(requestID)
+
+	// 3. Relay Loop, starting with the messages held by the probe
 	for {
-		// Read RTMP Message
-		msg, err := cs.ReadMessage()
-		if err != nil {
+		var msg *rtmp.Message
+		if len(held) > 0 {
+			msg, held = held[0], held[1:]
+		} else if msg, err = cs.ReadMessage(); err != nil {
 			if err == io.EOF {
 				return nil
 			}
-			return fmt.Errorf("read message: %w", err)
+			return withReason(ReasonClientDisconnect, fmt.Errorf("read message: %w", err))
 		}
 		if msg == nil {
 			continue
 		}
+		if err := policy.observe(msg); err != nil {
+			return err
+		}
+
+		trackCodec(msg)
+		thumbs.Observe(msg)
+		rec.Observe(msg)
+		pkg.Observe(msg)
+		feed.observe(msg)
+		avs.observe(msg)
 
 		// Convert to FLV Tag and pipe to FFmpeg
-		if err := rtmp.MessageToFLVTag(tr, msg); err != nil {
+		if err := out.WriteMessage(msg); err != nil {
 			// If pipe closes, ffmpeg might have died
-			return fmt.Errorf("write flv tag: %w", err)
+			return withReason(ReasonTranscodeError, &errclass.TranscodeError{Op: "write flv tag", Err: err})
 		}
 	}
 }
 
+// recordSession appends a finished session to the journal, if one is configured.
+func (s *Server) recordSession(requestID, app string, start time.Time, reason string, sessionErr error) {
+	if s.Journal == nil && s.State == nil {
+		return
+	}
+	entry := journal.Entry{
+		RequestID:  requestID,
+		App:        app,
+		Start:      start,
+		DurationMS: time.Since(start).Milliseconds(),
+		Reason:     reason,
+	}
+	var stream string
+	if value, ok := activeConnections.Load(requestID); ok {
+		if info, ok := value.(ConnectionInfo); ok {
+			entry.ClientAddr = info.ClientAddr
+			entry.Upstream = info.Upstream
+			stream = info.Stream
+		}
+	}
+	s.State.SessionEnded(state.Session{
+		RequestID:  requestID,
+		ClientAddr: entry.ClientAddr,
+		App:        app,
+		Stream:     stripStreamQuery(stream),
+		Start:      start,
+	}, reason, time.Now())
+	if s.Journal == nil {
+		return
+	}
+	if sessionErr != nil {
+		entry.ErrorClass = string(errclass.Of(sessionErr))
+		entry.Error = sessionErr.Error()
+	}
+	if err := s.Journal.Record(entry); err != nil {
+		s.Log.Warn("failed to journal session", "request_id", requestID, "err", err)
+	}
+}
+
+// errTranscodeDisabled ends sessions rejected by the transcoding kill switch.
+var errTranscodeDisabled = errors.New("transcoding disabled")
+
+// errDraining ends sessions turned away while the node drains.
+var errDraining = errors.New("server is draining")
+
+// errTranscodeBusy ends sessions rejected because every transcode slot is taken.
+var errTranscodeBusy = errors.New("transcoding capacity exhausted")
+
+// logAccess writes the session's access log record.
+func (s *Server) logAccess(requestID, app string, tenant *Tenant, start time.Time, counted *countingConn, auth, reason string, sessionErr error) {
+	if s.AccessLog == nil {
+		return
+	}
+	end := time.Now()
+	rec := accesslog.Record{
+		Time:       end,
+		RequestID:  requestID,
+		App:        app,
+		Start:      start,
+		DurationMS: end.Sub(start).Milliseconds(),
+		Auth:       auth,
+		Reason:     reason,
+	}
+	rec.BytesIn, rec.BytesOut = counted.counts()
+	if tenant != nil {
+		rec.Tenant = tenant.App
+	}
+	if value, ok := activeConnections.Load(requestID); ok {
+		if info, ok := value.(ConnectionInfo); ok {
+			rec.ClientAddr = info.ClientAddr
+			rec.Upstream = info.Upstream
+			rec.Stream = stripStreamQuery(info.Stream)
+		}
+	}
+	if sessionErr != nil {
+		rec.ErrorClass = string(errclass.Of(sessionErr))
+		rec.Error = sessionErr.Error()
+	}
+	if err := s.AccessLog.Write(rec); err != nil {
+		s.Log.Warn("failed to write access log", "request_id", requestID, "err", err)
+	}
+}
+
+// publishSession announces a session event carrying the session's tracked
+// client, stream, upstream and state.
+func (s *Server) publishSession(typ, requestID, reason string, sessionErr error) {
+	if s.Events == nil {
+		return
+	}
+	e := sessionEvent(typ, requestID)
+	e.Reason = reason
+	if sessionErr != nil {
+		e.ErrorClass = string(errclass.Of(sessionErr))
+		e.Error = sessionErr.Error()
+	}
+	s.Events.Publish(e)
+}
+
+// sessionEvent returns an event of type typ filled from the tracked session.
+func sessionEvent(typ, requestID string) events.Event {
+	e := events.Event{Type: typ, RequestID: requestID}
+	if value, ok := activeConnections.Load(requestID); ok {
+		if info, ok := value.(ConnectionInfo); ok {
+			e.ClientAddr = info.ClientAddr
+			e.Stream = stripStreamQuery(info.Stream)
+			e.Upstream = info.Upstream
+			e.State = string(info.State)
+		}
+	}
+	return e
+}
+
+// claimPublish holds stream for the session against the publish limit and
+// the duplicate publish policy, or says why it may not publish it.
+func (s *Server) claimPublish(ctx context.Context, pub *publishClaim, stream string) error {
+	log := s.logger(ctx)
+	scope, replaced, err := pub.claim(ctx, stream)
+	switch {
+	case err != nil && scope != "":
+		s.Metrics.RecordPublishLimitRejection(scope)
+		log.Warn("publish limit denied", "stream", stripStreamQuery(stream), "limit", scope, "err", err)
+		return withReason(ReasonQuota, err)
+	case err != nil:
+		s.Metrics.RecordDuplicatePublish(config.DuplicatePublishReject)
+		log.Warn("duplicate publish refused", "stream", stripStreamQuery(stream), "err", err)
+		return withReason(ReasonStreamConflict, err)
+	case replaced != "":
+		s.Metrics.RecordDuplicatePublish(s.DuplicatePublish.Policy)
+		log.Info("took over stream", "stream", stripStreamQuery(stream), "replaced_request_id", replaced)
+	}
+	return nil
+}
+
+// claimView counts the session as a viewer of the stream it asked to play,
+// unless the stream is at its viewer cap.
+func (s *Server) claimView(ctx context.Context, view *viewerClaim, stream string) error {
+	if err := view.play(stream); err != nil {
+		s.logger(ctx).Warn("viewer limit denied", "stream", stripStreamQuery(stream), "err", err)
+		return withReason(ReasonQuota, err)
+	}
+	return nil
+}
+
+// publishDialFailure announces a session that could not reach its upstream,
+// which health checks may not have noticed yet.
+func (s *Server) publishDialFailure(err *errclass.UpstreamDialError) {
+	s.Events.Publish(events.Event{
+		Type:       events.TypeUpstreamHealth,
+		RequestID:  err.RequestID,
+		Upstream:   err.Upstream,
+		State:      "dial_failed",
+		ErrorClass: string(err.Class()),
+		Error:      err.Err.Error(),
+	})
+}
+
+// connectRecorder keeps a copy of the bytes read until stop is called.
+type connectRecorder struct {
+	r       io.Reader
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (c *connectRecorder) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if !c.stopped {
+		c.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *connectRecorder) stop() {
+	c.stopped = true
+}
+
+// messageWriter consumes RTMP messages from a transcoding session.
+type messageWriter interface {
+	WriteMessage(msg *rtmp.Message) error
+}
+
+// flvSink feeds RTMP media messages into a transcoder as an FLV stream.
+type flvSink struct {
+	tr  transcoder.Backend
+	url string
+}
+
+// transcodeOutputs maps each open transcoder output URL to its *flvSink, so
+// every session feeding a shared output can read its progress.
+var transcodeOutputs sync.Map
+
+// progressInterval is how often transcoder progress is copied into the
+// session registry and metrics.
+var progressInterval = 2 * time.Second
+
+// trackProgress polls the progress and process usage of the transcoder
+// writing outputURL. The returned function stops polling and waits for the
+// poller, so it cannot store into the registry after the session is gone.
+func (s *Server) trackProgress(requestID, stream, outputURL string) (stop func()) {
+	stream, _, _ = strings.Cut(stream, "?")
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			value, ok := transcodeOutputs.Load(outputURL)
+			if !ok {
+				continue
+			}
+			tr := value.(*flvSink).tr
+			if p, ok := transcoder.ReadProgress(tr); ok {
+				updateConnectionTranscode(requestID, p)
+				s.Metrics.SetTranscodeProgress(stream, p.FPS, p.Speed, p.BitrateKbps*1000, p.DroppedFrames)
+			}
+			if u, ok := transcoder.ReadUsage(tr); ok {
+				updateConnectionTranscodeUsage(requestID, u)
+				s.Metrics.SetTranscodeUsage(stream, u.CPUPercent/100, u.RSSBytes)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		s.Metrics.DeleteTranscodeProgress(stream)
+		s.Metrics.DeleteTranscodeUsage(stream)
+	}
+}
+
+// flvTracks are the tracks announced in the FLV header fed to a transcoder.
+type flvTracks struct {
+	audio, video bool
+}
+
+func newFLVSink(ctx context.Context, cfg config.TranscodeConfig, tracks flvTracks, upstreamURL string, log *logger.Logger) (*flvSink, error) {
+	tr, err := transcoder.New(ctx, cfg, upstreamURL, log)
+	if err != nil {
+		return nil, err
+	}
+	if err := rtmp.WriteFLVHeader(tr, tracks.audio, tracks.video); err != nil {
+		tr.Close()
+		return nil, &errclass.TranscodeError{Op: "write flv header", Err: err}
+	}
+	sink := &flvSink{tr: tr, url: upstreamURL}
+	transcodeOutputs.Store(upstreamURL, sink)
+	return sink, nil
+}
+
+func (f *flvSink) WriteMessage(msg *rtmp.Message) error {
+	return rtmp.MessageToFLVTag(f.tr, msg)
+}
+
+func (f *flvSink) Close() error {
+	transcodeOutputs.CompareAndDelete(f.url, f)
+	return f.tr.Close()
+}
+
+// transcodeURL appends the (rewritten) stream name when upstream ends with a slash.
+func (s *Server) transcodeURL(upstream, streamName string) string {
+	if strings.HasSuffix(upstream, "/") {
+		name, _ := s.Rewrite.Stream(streamName)
+		return upstream + name
+	}
+	return upstream
+}
+
 func (s *Server) getUpstreamInfo() (UpstreamInfo, error) {
 	s.upstreamOnce.Do(func() {
 		s.upstreamInfo, s.upstreamErr = ParseUpstream(s.Upstream)
@@ -494,7 +1398,18 @@ func (s *Server) getUpstreamInfo() (UpstreamInfo, error) {
 	return s.upstreamInfo, s.upstreamErr
 }
 
-func (s *Server) selectUpstream() (UpstreamInfo, string, string, error) {
+// selectUpstream picks the upstream for app/stream from the first matching
+// route, falling back to the global pool or single upstream.
+func (s *Server) selectUpstream(ctx context.Context, app, stream string) (UpstreamInfo, string, string, error) {
+	log := s.logger(ctx)
+	if pool, match, ok := s.Routes.Match(app, stream); ok {
+		info, raw, err := pool.Pick()
+		if err != nil {
+			return UpstreamInfo{}, "", "route", err
+		}
+		log.Debug("routed session", "route", match, "upstream", raw)
+		return info, raw, "route", nil
+	}
 	if s.UpstreamPool != nil {
 		info, raw, err := s.UpstreamPool.Pick()
 		if err != nil {
@@ -510,6 +1425,108 @@ func (s *Server) selectUpstream() (UpstreamInfo, string, string, error) {
 	return info, s.Upstream, "parse", nil
 }
 
+// openUpstream dials info behind its dial rate limit and the circuit
+// breaker, tunes the socket and completes the RTMP handshake. A pre-warmed
+// connection to info skips all of these.
+func (s *Server) openUpstream(ctx context.Context, info UpstreamInfo) (net.Conn, error) {
+	if s.warm != nil {
+		if conn := s.warm.take(info); conn != nil {
+			s.Metrics.RecordUpstreamPrewarm("hit")
+			return info.Egress.Wrap(ctx, s.wrapIdle(ctx, conn)), nil
+		}
+		s.Metrics.RecordUpstreamPrewarm("miss")
+	}
+
+	log := s.logger(ctx)
+	// Queueing for a dial slot is the relay's own pacing, so it is neither
+	// charged to the circuit breaker nor to outlier detection.
+	if waited, err := info.DialLimit.Wait(ctx); err != nil {
+		s.Metrics.RecordUpstreamError("dial_rate_limited")
+		return nil, withReason(ReasonUpstreamError, &errclass.UpstreamDialError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Err: err})
+	} else if waited > 0 {
+		log.Debug("upstream dial queued by rate limit", "upstream", info.Raw, "waited", waited.String())
+	}
+	dialStart := time.Now()
+	var upstream net.Conn
+	dialed := false // An open breaker fails the call without dialing
+
+	dialFn := func() error {
+		dialed = true
+		conn, dialErr := s.dialUpstream(ctx, info)
+		if dialErr == nil {
+			upstream = conn
+		}
+		return dialErr
+	}
+
+	var err error
+	if s.CircuitBreaker != nil {
+		err = s.CircuitBreaker.Call(dialFn)
+	} else {
+		err = dialFn()
+	}
+	if err != nil {
+		s.Metrics.RecordUpstreamError("dial")
+		dialErr := &errclass.UpstreamDialError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Err: err}
+		if dialed {
+			s.publishDialFailure(dialErr)
+			s.reportConnect(ctx, info, err, time.Since(dialStart))
+		}
+		return nil, withReason(ReasonUpstreamError, dialErr)
+	}
+
+	s.tuneUpstream(log, info, upstream)
+
+	upstream = s.wrapIdle(ctx, upstream)
+	upstream = info.Egress.Wrap(ctx, upstream)
+
+	if err := rtmp.ClientHandshake(upstream, s.Handshake); err != nil {
+		upstream.Close()
+		s.reportConnect(ctx, info, err, time.Since(dialStart))
+		s.Metrics.RecordUpstreamError("handshake")
+		return nil, withReason(ReasonUpstreamError, &errclass.UpstreamError{RequestID: RequestIDFromContext(ctx), Upstream: info.Raw, Op: "handshake", Err: err})
+	}
+	s.Metrics.ObserveLatency(time.Since(dialStart))
+	s.reportConnect(ctx, info, nil, time.Since(dialStart))
+	return upstream, nil
+}
+
+// tuneUpstream sets keepalive and info's DSCP class on an upstream
+// connection, and TCP_NODELAY and the socket buffers on a plain TCP one.
+func (s *Server) tuneUpstream(log *logger.Logger, info UpstreamInfo, upstream net.Conn) {
+	if err := s.UpstreamTCP.apply(upstream); err != nil {
+		log.Warn("failed to set TCP keepalive on upstream", "err", err)
+	}
+	if err := qos.SetDSCP(upstream, info.DSCP); err != nil {
+		log.Warn("failed to set DSCP on upstream", "err", err)
+	}
+	uTCP, ok := upstream.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := uTCP.SetNoDelay(true); err != nil {
+		log.Warn("failed to set TCP_NODELAY on upstream", "err", err)
+	}
+	if err := uTCP.SetReadBuffer(s.ReadBuf); err != nil {
+		log.Warn("failed to set read buffer on upstream", "err", err)
+	}
+	if err := uTCP.SetWriteBuffer(s.WriteBuf); err != nil {
+		log.Warn("failed to set write buffer on upstream", "err", err)
+	}
+}
+
+// reportConnect feeds a connect outcome to outlier detection, logging and
+// counting the ejection it may cause.
+func (s *Server) reportConnect(ctx context.Context, info UpstreamInfo, err error, took time.Duration) {
+	ej, ejected := ReportConnect(info, err, took)
+	if !ejected {
+		return
+	}
+	s.Metrics.RecordUpstreamOutlierEjection(ej.URL)
+	s.logger(ctx).Warn("upstream ejected as an outlier", "upstream", ej.URL, "address", ej.Address,
+		"failure_rate", ej.FailureRate, "ejected_for", ej.Duration.String())
+}
+
 // dialUpstream dials the upstream with retry.
 func (s *Server) dialUpstream(ctx context.Context, info UpstreamInfo) (net.Conn, error) {
 	if s.RetryConfig.MaxAttempts <= 0 {
@@ -517,31 +1534,41 @@ func (s *Server) dialUpstream(ctx context.Context, info UpstreamInfo) (net.Conn,
 	}
 	var conn net.Conn
 	var err error
-	dialOnce := func() error {
+	dialOnce := func(ctx context.Context) error {
 		c, dialErr := s.dialUpstreamOnce(ctx, info)
 		if dialErr == nil {
 			conn = c
 		}
 		return dialErr
 	}
+	cfg := s.RetryConfig
+	log := s.logger(ctx)
+	cfg.OnRetry = func(attempt int, err error, delay time.Duration) {
+		s.Metrics.RecordUpstreamDialRetry()
+		log.Warn("upstream dial failed, retrying", "attempt", attempt, "err", err, "delay", delay)
+		if s.RetryConfig.OnRetry != nil {
+			s.RetryConfig.OnRetry(attempt, err, delay)
+		}
+	}
 	if s.RetryJitter > 0 {
-		err = retry.DoWithJitter(ctx, s.RetryConfig, s.RetryJitter, dialOnce)
+		err = retry.DoWithJitterContext(ctx, cfg, s.RetryJitter, dialOnce)
 	} else {
-		err = retry.Do(ctx, s.RetryConfig, dialOnce)
+		err = retry.DoContext(ctx, cfg, dialOnce)
 	}
 	return conn, err
 }
 
 func (s *Server) dialUpstreamOnce(ctx context.Context, info UpstreamInfo) (net.Conn, error) {
-	if info.UseTLS {
-		dialer := tls.Dialer{
-			NetDialer: &net.Dialer{},
-			Config:    &tls.Config{ServerName: info.Host},
-		}
-		return dialer.DialContext(ctx, "tcp", info.Address)
+	conn, err := s.HappyEyeballs.dialTCP(ctx, info.Bind.dialer(), info.Address)
+	if err != nil || !info.UseTLS {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: info.Host})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
 	}
-	var dialer net.Dialer
-	return dialer.DialContext(ctx, "tcp", info.Address)
+	return tlsConn, nil
 }
 
 // getBuffer gets a buffer from the pool or creates a new one
@@ -561,16 +1588,6 @@ func (s *Server) putBuffer(buf []byte) {
 	}
 }
 
-func wrapIdleConn(conn net.Conn, idle time.Duration) net.Conn {
-	if conn == nil || idle <= 0 {
-		return conn
-	}
-	return &idleConn{
-		Conn: conn,
-		idle: idle,
-	}
-}
-
 // extractIP extracts the IP address from a remote address string
 func extractIP(remoteAddr string) string {
 	if remoteAddr == "" {
@@ -589,6 +1606,7 @@ func extractIP(remoteAddr string) string {
 type metricsWriter struct {
 	writer    io.Writer
 	direction string
+	reg       *metrics.Registry
 }
 
 func (m metricsWriter) Write(p []byte) (int, error) {
@@ -597,28 +1615,41 @@ func (m metricsWriter) Write(p []byte) (int, error) {
 	}
 	n, err := m.writer.Write(p)
 	if n > 0 {
-		metrics.RecordBytesTransferred(m.direction, int64(n))
+		m.reg.RecordBytesTransferred(m.direction, int64(n))
 	}
 	return n, err
 }
 
-type idleConn struct {
+// countingConn counts the bytes of a session's client connection for the
+// access log. Spliced bytes bypass it and are added by the caller.
+type countingConn struct {
 	net.Conn
-	idle time.Duration
+	read, written atomic.Int64
 }
 
-func (c *idleConn) Read(p []byte) (int, error) {
-	if c.idle > 0 {
-		_ = c.Conn.SetReadDeadline(time.Now().Add(c.idle))
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) addWritten(n int64) {
+	if c != nil {
+		c.written.Add(n)
 	}
-	return c.Conn.Read(p)
 }
 
-func (c *idleConn) Write(p []byte) (int, error) {
-	if c.idle > 0 {
-		_ = c.Conn.SetWriteDeadline(time.Now().Add(c.idle))
+func (c *countingConn) counts() (read, written int64) {
+	if c == nil {
+		return 0, 0
 	}
-	return c.Conn.Write(p)
+	return c.read.Load(), c.written.Load()
 }
 
 func decodeConnectCommand(msg *rtmp.Message) ([]interface{}, error) {
@@ -1,6 +1,24 @@
 package relay
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/accesslog"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/transcoder"
+)
 
 func TestParseUpstream(t *testing.T) {
 	cases := []struct {
@@ -14,6 +32,7 @@ func TestParseUpstream(t *testing.T) {
 		{"rtmps://example.com/app/stream", "example.com:1935", true, "rtmps"},
 		{"rtsp://example.com/stream", "example.com:554", false, "rtsp"},
 		{"rtsps://example.com/stream", "example.com:554", true, "rtsps"},
+		{"srt://example.com:9000?streamid=live", "example.com:9000", false, "srt"},
 		{"example.com:1234/app", "example.com:1234", false, "rtmp"},
 		{"rtmp://[2001:db8::1]/app", "[2001:db8::1]:1935", false, "rtmp"},
 	}
@@ -41,6 +60,12 @@ func TestParseUpstreamRejectsUnsupportedScheme(t *testing.T) {
 	}
 }
 
+func TestParseUpstreamSRTNeedsPort(t *testing.T) {
+	if _, err := ParseUpstream("srt://example.com"); err == nil {
+		t.Fatalf("expected error for srt upstream without a port")
+	}
+}
+
 func TestExtractIP(t *testing.T) {
 	cases := []struct {
 		input string
@@ -58,3 +83,167 @@ func TestExtractIP(t *testing.T) {
 		}
 	}
 }
+
+func TestServerReady(t *testing.T) {
+	srv := &Server{ListenAddr: "127.0.0.1:0", Upstream: "127.0.0.1:1", Log: logger.NewWithWriter(io.Discard)}
+	if srv.Addr() != nil {
+		t.Fatal("Addr set before Run")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	select {
+	case <-srv.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready never closed")
+	}
+	addr := srv.Addr()
+	if addr == nil || addr.(*net.TCPAddr).Port == 0 {
+		t.Fatalf("Addr = %v, want the bound port", addr)
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("dial ready server: %v", err)
+	}
+	conn.Close()
+
+	cancel()
+	<-done
+}
+
+func TestLogAccessRecordsSession(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+	path := filepath.Join(t.TempDir(), "access.log")
+	al, err := accesslog.Open(config.AccessLogConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Log: logger.NewWithWriter(io.Discard), AccessLog: al}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	counted := &countingConn{Conn: server}
+	go func() {
+		client.Write([]byte("hello"))
+		io.ReadFull(client, make([]byte, 3))
+	}()
+	io.ReadFull(counted, make([]byte, 5))
+	counted.Write([]byte("abc"))
+	counted.addWritten(100) // Spliced
+
+	trackConnectionStart(ConnectionInfo{RequestID: "req-access", ClientAddr: "10.0.0.1:5000", Upstream: "up:1935"})
+	updateConnectionStream("req-access", "cam?key=secret")
+	srv.logAccess("req-access", "live", nil, time.Now().Add(-time.Second), counted, accesslog.AuthOK, ReasonUpstreamError, &errclass.UpstreamError{Upstream: "up:1935", Op: "publish", Err: errors.New("upstream reset")})
+	al.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec accesslog.Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("access log %q: %v", data, err)
+	}
+	if rec.BytesIn != 5 || rec.BytesOut != 103 {
+		t.Fatalf("bytes in/out = %d/%d, want 5/103", rec.BytesIn, rec.BytesOut)
+	}
+	if rec.Stream != "cam" || rec.Upstream != "up:1935" || rec.ClientAddr != "10.0.0.1:5000" || rec.Auth != accesslog.AuthOK {
+		t.Fatalf("record = %+v", rec)
+	}
+	if rec.ErrorClass != string(errclass.Upstream) || rec.Error != "publish upstream up:1935: upstream reset" || rec.DurationMS < 1000 {
+		t.Fatalf("record = %+v, want the error classified", rec)
+	}
+}
+
+func TestPublishSessionEvents(t *testing.T) {
+	clearActiveConnections()
+	t.Cleanup(clearActiveConnections)
+	bus := events.New()
+	sub := bus.Subscribe(8)
+	defer sub.Close()
+	srv := &Server{Log: logger.NewWithWriter(io.Discard), Events: bus}
+
+	trackConnectionStart(ConnectionInfo{RequestID: "req-events", ClientAddr: "10.0.0.1:5000", State: StateConnecting})
+	srv.publishSession(events.TypeConnectionStart, "req-events", "", nil)
+	updateConnectionStream("req-events", "cam?key=secret")
+	ctx := contextWithSessionState(context.Background(), srv.newSessionState("req-events", func() {}))
+	srv.setState(ctx, StateHandshaking)
+	srv.setState(ctx, StateDialing)
+	srv.setState(ctx, StateRelaying)
+	srv.publishSession(events.TypeConnectionEnd, "req-events", ReasonClientDisconnect, nil)
+
+	want := []struct{ typ, from, state string }{
+		{events.TypeConnectionStart, "", "connecting"},
+		{events.TypeSessionState, "connecting", "handshaking"},
+		{events.TypeSessionState, "handshaking", "dialing"},
+		{events.TypeSessionState, "dialing", "relaying"},
+		{events.TypeConnectionEnd, "", "relaying"},
+	}
+	for _, w := range want {
+		e := <-sub.C
+		if e.Type != w.typ || e.From != w.from || e.State != w.state || e.ClientAddr != "10.0.0.1:5000" {
+			t.Fatalf("event = %+v, want %s from %q to %s", e, w.typ, w.from, w.state)
+		}
+	}
+	if info, _ := LookupStream("cam"); info.State != "relaying" {
+		t.Fatalf("tracked state = %q, want relaying", info.State)
+	}
+}
+
+func TestOpenUpstreamDialErrorCarriesRequestID(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bus := events.New()
+	sub := bus.Subscribe(8)
+	defer sub.Close()
+	srv := &Server{Log: logger.NewWithWriter(io.Discard), Events: bus}
+	info, err := ParseUpstream("rtmp://" + addr + "/live")
+	if err != nil {
+		t.Fatalf("ParseUpstream: %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-dial")
+	_, err = srv.openUpstream(ctx, info)
+	var upErr *errclass.UpstreamDialError
+	if !errors.As(err, &upErr) {
+		t.Fatalf("openUpstream error = %v, want *errclass.UpstreamDialError", err)
+	}
+	if upErr.RequestID != "req-dial" || errclass.Of(err) != errclass.UpstreamDial || !strings.Contains(err.Error(), "req-dial") {
+		t.Fatalf("error = %+v (%v)", upErr, err)
+	}
+
+	e := <-sub.C
+	if e.Type != events.TypeUpstreamHealth || e.State != "dial_failed" || e.RequestID != "req-dial" || e.Upstream != info.Raw {
+		t.Fatalf("event = %+v", e)
+	}
+}
+
+func TestTranscodeSlotReleasesOnce(t *testing.T) {
+	srv := &Server{TranscodeSlots: transcoder.NewSlots(config.TranscodeSlotsConfig{MaxSessions: 2})}
+	var slots []*transcodeSlot
+	for range 2 {
+		if !srv.TranscodeSlots.TryAcquire() {
+			t.Fatal("TryAcquire failed with a slot free")
+		}
+		slots = append(slots, srv.heldTranscodeSlot())
+	}
+	// A session that turns out to remux releases early, then again on exit.
+	slots[0].release()
+	slots[0].release()
+	if st := srv.TranscodeSlots.Status(); st.InUse != 1 {
+		t.Fatalf("in use = %d, want the other session's slot still held", st.InUse)
+	}
+	slots[1].release()
+	var none *transcodeSlot
+	none.release()
+	if st := srv.TranscodeSlots.Status(); st.InUse != 0 {
+		t.Fatalf("in use = %d, want 0", st.InUse)
+	}
+}
@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/grace"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmptest"
+)
+
+// fakeFFmpeg puts an ffmpeg on PATH that notes each start in dir/starts and
+// appends what it is fed to dir/output.flv.
+func fakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho start >> \"$FAKE_FFMPEG_DIR/starts\"\nexec cat >> \"$FAKE_FFMPEG_DIR/output.flv\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_FFMPEG_DIR", dir)
+	return dir
+}
+
+// publishMedia publishes stream through the relay at addr and sends the
+// decoder configuration and a keyframe at ts, so the transcoder starts.
+func publishMedia(t *testing.T, addr, stream string, ts uint32) *rtmptest.Client {
+	t.Helper()
+	client, err := rtmptest.Dial(addr, "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Publish(stream); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*rtmp.Message{
+		{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAudio, Timestamp: ts}, Payload: []byte{0x2f, 0xff, 0xfb}},
+		{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts}, Payload: []byte{0x17, 0x00, 0, 0, 0, 1, 0x42, 0, 0x1e}},
+	} {
+		if err := client.WriteMedia(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.WriteVideo(ts, true); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// waitGrows waits for the file at path to grow past size and returns its
+// new size.
+func waitGrows(t *testing.T, path string, size int64) int64 {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if fi, err := os.Stat(path); err == nil && fi.Size() > size {
+			return fi.Size()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never grew past %d bytes", filepath.Base(path), size)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGraceOutputOutlivesPublisher(t *testing.T) {
+	dir := fakeFFmpeg(t)
+	srv := &Server{
+		ListenAddr: "127.0.0.1:0",
+		Upstream:   "rtmp://127.0.0.1:1/live/",
+		Transcode:  config.TranscodeConfig{Enabled: true, Backend: "ffmpeg", VideoCodec: "copy", AudioCodec: "copy"},
+		Grace:      grace.NewHolder(5 * time.Second),
+		Log:        logger.NewWithWriter(io.Discard),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Run(ctx)
+	select {
+	case <-srv.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready never closed")
+	}
+	addr := srv.Addr().String()
+	output := filepath.Join(dir, "output.flv")
+
+	first := publishMedia(t, addr, "cam1", 0)
+	size := waitGrows(t, output, 0)
+	// The first publisher drops; the output is held for the next one.
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st := srv.Grace.Status(); len(st) == 1 && !st[0].Connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("held outputs = %+v, want cam1 waiting for a publisher", srv.Grace.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second := publishMedia(t, addr, "cam1", 0)
+	waitGrows(t, output, size)
+	second.Close()
+
+	starts, err := os.ReadFile(filepath.Join(dir, "starts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(starts), "start"); n != 1 {
+		t.Fatalf("ffmpeg started %d times, want the second publisher to resume the first's output", n)
+	}
+}
@@ -0,0 +1,154 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrServerClosed is returned by Run and ServeConn once Shutdown was called.
+var ErrServerClosed = errors.New("relay: server closed")
+
+const (
+	// shutdownPollInterval is how often Shutdown logs the sessions it is
+	// still waiting for.
+	shutdownPollInterval = time.Second
+	// forceCloseGrace is how long Shutdown waits for sessions to return once
+	// their connections were closed under them.
+	forceCloseGrace = time.Second
+)
+
+// shutdownState tracks the sessions of a Server so Shutdown can wait for
+// them. Its zero value is ready to use.
+type shutdownState struct {
+	closed   bool
+	listener net.Listener
+	sessions map[net.Conn]trackedSession
+}
+
+type trackedSession struct {
+	cancel context.CancelFunc
+	phase  *idlePhase
+}
+
+// Shutdown stops accepting sessions and waits for the running ones to
+// finish. Sessions still in their handshake are told to end straight away,
+// so their clients can reconnect elsewhere; relaying sessions keep going
+// until they end on their own or ctx is done, when their connections are
+// closed and Shutdown returns ctx.Err(). Run returns ErrServerClosed once
+// Shutdown has been called. Calling Shutdown again waits for the same
+// sessions.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.beginShutdown()
+	done := make(chan struct{})
+	go func() {
+		s.sessionWG.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			s.Log.Info("all sessions drained", "elapsed", time.Since(start))
+			return nil
+		case <-ticker.C:
+			s.Log.Info("waiting for sessions to end", "active", s.activeSessions(), "elapsed", time.Since(start))
+		case <-ctx.Done():
+			n := s.closeSessions()
+			s.Log.Warn("drain deadline reached, closing sessions", "active", n, "elapsed", time.Since(start))
+			select {
+			case <-done:
+			case <-time.After(forceCloseGrace):
+				s.Log.Warn("sessions still running after their connections were closed")
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// beginShutdown stops new sessions, closes the listener and cancels the
+// sessions that have not started relaying.
+func (s *Server) beginShutdown() {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdown.closed {
+		return
+	}
+	s.shutdown.closed = true
+	if s.shutdown.listener != nil {
+		s.shutdown.listener.Close()
+	}
+	for _, sess := range s.shutdown.sessions {
+		if !sess.phase.relaying.Load() {
+			sess.cancel()
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return s.shutdown.closed
+}
+
+// setListener records the listener Run accepts on, refusing it after
+// Shutdown.
+func (s *Server) setListener(l net.Listener) bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdown.closed {
+		return false
+	}
+	s.shutdown.listener = l
+	return true
+}
+
+// trackSession registers a session's connection and returns the context it
+// should run under, carrying the session's idle phase, or false once the
+// server is shutting down. The returned function must be called when the
+// session ends.
+func (s *Server) trackSession(ctx context.Context, conn net.Conn) (context.Context, func(), bool) {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdown.closed {
+		return ctx, nil, false
+	}
+	if s.shutdown.sessions == nil {
+		s.shutdown.sessions = make(map[net.Conn]trackedSession)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ctx = contextWithIdlePhase(ctx)
+	phase := ctx.Value(idlePhaseKey{}).(*idlePhase)
+	s.shutdown.sessions[conn] = trackedSession{cancel: cancel, phase: phase}
+	// Adding under the lock, before closed is set, keeps Add from racing
+	// the Wait in Shutdown.
+	s.sessionWG.Add(1)
+	return ctx, func() {
+		s.shutdownMu.Lock()
+		delete(s.shutdown.sessions, conn)
+		s.shutdownMu.Unlock()
+		cancel()
+		s.sessionWG.Done()
+	}, true
+}
+
+func (s *Server) activeSessions() int {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return len(s.shutdown.sessions)
+}
+
+// closeSessions closes the connection of every session still running and
+// returns how many there were.
+func (s *Server) closeSessions() int {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	for conn := range s.shutdown.sessions {
+		conn.Close()
+	}
+	return len(s.shutdown.sessions)
+}
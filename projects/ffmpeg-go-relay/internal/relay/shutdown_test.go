@@ -0,0 +1,165 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmptest"
+)
+
+func startServer(t *testing.T) (*Server, <-chan error) {
+	t.Helper()
+	srv := &Server{ListenAddr: "127.0.0.1:0", Upstream: "127.0.0.1:1", Log: logger.NewWithWriter(io.Discard)}
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(context.Background()) }()
+	select {
+	case <-srv.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready never closed")
+	}
+	return srv, done
+}
+
+func TestShutdownWithoutSessions(t *testing.T) {
+	srv, done := startServer(t)
+	addr := srv.Addr().String()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown = %v, want nil with no sessions", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Run = %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("server still accepting after Shutdown")
+	}
+}
+
+func TestShutdownClosesSessionsAtDeadline(t *testing.T) {
+	srv, done := startServer(t)
+
+	// A client that never completes the handshake keeps its session blocked
+	// on a read that cancelling the context does not interrupt.
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.activeSessions() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want the deadline error", err)
+	}
+	if n := srv.activeSessions(); n != 0 {
+		t.Fatalf("%d sessions still running after a forced shutdown", n)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("client read = %v, want the connection closed by the relay", err)
+	}
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Run = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestShutdownLetsRelayingSessionsFinish(t *testing.T) {
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
+	srv := &Server{ListenAddr: "127.0.0.1:0", Upstream: upstream.Addr(), Log: logger.NewWithWriter(io.Discard)}
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(context.Background()) }()
+	select {
+	case <-srv.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready never closed")
+	}
+
+	client, err := rtmptest.Dial(srv.Addr().String(), "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Publish("cam1"); err != nil {
+		t.Fatal(err)
+	}
+	upstream.Expect(t, "connect live", "publish cam1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+	for !srv.shuttingDown() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The publisher keeps relaying while the drain deadline is ahead, well
+	// past the time a cancelled session takes to wind down.
+	for _, ts := range []uint32{0, 500} {
+		if ts > 0 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		msg := &rtmp.Message{
+			Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+			Payload: []byte{rtmp.FrameKeyframe<<4 | rtmp.VideoAVC, rtmp.AVCPacketNALU, 0, 0, 0},
+		}
+		if err := client.WriteMedia(msg); err != nil {
+			t.Fatal(err)
+		}
+		upstream.Expect(t, fmt.Sprintf("video %d", ts))
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown = %v before the session ended", err)
+	default:
+	}
+
+	// Once the publisher leaves, Shutdown returns without reaching it.
+	client.Close()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("Shutdown = %v, want nil once the session ended", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the session ended")
+	}
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Run = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	srv := &Server{ListenAddr: "127.0.0.1:0", Upstream: "127.0.0.1:1", Log: logger.NewWithWriter(io.Discard)}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Run(context.Background()); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Run after Shutdown = %v, want ErrServerClosed", err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	if err := srv.ServeConn(context.Background(), server); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("ServeConn after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
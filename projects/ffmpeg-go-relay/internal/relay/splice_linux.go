@@ -0,0 +1,61 @@
+//go:build linux
+
+package relay
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+// spliceChunk bounds each kernel copy so byte counters and idle deadlines
+// are refreshed while a long relay is running.
+const spliceChunk = 256 << 10
+
+// spliceCopy copies src to dst with splice(2) when both ends are plain TCP
+// sockets, so media never passes through a userspace buffer. It reports
+// false without copying anything when either side is wrapped (TLS, shaping)
+// and the caller must fall back to io.CopyBuffer.
+func spliceCopy(dst, src net.Conn, direction string, reg *metrics.Registry) (int64, bool, error) {
+	out, _, writeIdle := tcpConn(dst)
+	in, readIdle, _ := tcpConn(src)
+	if out == nil || in == nil {
+		return 0, false, nil
+	}
+
+	var total int64
+	for {
+		if readIdle > 0 {
+			_ = in.SetReadDeadline(time.Now().Add(readIdle))
+		}
+		if writeIdle > 0 {
+			_ = out.SetWriteDeadline(time.Now().Add(writeIdle))
+		}
+		// TCPConn.ReadFrom splices from a TCP source, including one behind
+		// an io.LimitedReader.
+		n, err := out.ReadFrom(&io.LimitedReader{R: in, N: spliceChunk})
+		if n > 0 {
+			total += n
+			reg.RecordBytesTransferred(direction, n)
+		}
+		if err != nil || n == 0 {
+			return total, true, err
+		}
+	}
+}
+
+// tcpConn unwraps the byte-counting and idle-timeout wrappers, returning nil
+// if c is not TCP, and the read and write idle timeouts to keep applying.
+func tcpConn(c net.Conn) (*net.TCPConn, time.Duration, time.Duration) {
+	var readIdle, writeIdle time.Duration
+	if cc, ok := c.(*countingConn); ok {
+		c = cc.Conn
+	}
+	if ic, ok := c.(*idleConn); ok {
+		c, readIdle, writeIdle = ic.Conn, ic.readIdle(), ic.writeIdle()
+	}
+	tc, _ := c.(*net.TCPConn)
+	return tc, readIdle, writeIdle
+}
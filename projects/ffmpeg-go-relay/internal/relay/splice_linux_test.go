@@ -0,0 +1,77 @@
+//go:build linux
+
+package relay
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server := <-accepted
+	t.Cleanup(func() {
+		dialed.Close()
+		server.Close()
+	})
+	return dialed, server
+}
+
+func TestSpliceCopyBetweenTCPConns(t *testing.T) {
+	srcWriter, src := tcpPair(t)
+	dst, dstReader := tcpPair(t)
+
+	payload := bytes.Repeat([]byte("flv!"), spliceChunk/2) // spans several chunks
+	go func() {
+		srcWriter.Write(payload)
+		srcWriter.Close()
+	}()
+
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(dstReader)
+		received <- data
+	}()
+
+	n, spliced, err := spliceCopy(dst, wrapIdleConn(src, IdleTimeouts{Read: time.Minute, Write: time.Minute}, nil), "downstream", nil)
+	if !spliced {
+		t.Fatal("expected TCP to TCP copy to use splice")
+	}
+	if err != nil {
+		t.Fatalf("spliceCopy: %v", err)
+	}
+	if n != int64(len(payload)) {
+		t.Fatalf("copied %d bytes, want %d", n, len(payload))
+	}
+	dst.Close()
+	if got := <-received; !bytes.Equal(got, payload) {
+		t.Fatalf("received %d bytes, want %d identical bytes", len(got), len(payload))
+	}
+}
+
+func TestSpliceCopyDeclinesWrappedConns(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, spliced, _ := spliceCopy(a, b, "downstream", nil); spliced {
+		t.Fatal("expected non-TCP conns to fall back")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package relay
+
+import (
+	"net"
+
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+// spliceCopy is only implemented on Linux; elsewhere callers use io.CopyBuffer.
+func spliceCopy(dst, src net.Conn, direction string, reg *metrics.Registry) (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,220 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Stream policy rules, as counted by RecordStreamPolicyViolation.
+const (
+	policyVideoCodec       = "video_codec"
+	policyAudioCodec       = "audio_codec"
+	policyKeyframeInterval = "keyframe_interval"
+	policyBFrames          = "bframes"
+	policyResolution       = "resolution"
+	policyBitrate          = "bitrate"
+)
+
+// streamPolicy checks one session's media against a StreamPolicyConfig as
+// it is read from the client. Each rule is reported once per stream; with
+// the reject action the first violation ends the session before the
+// offending message is relayed. A nil streamPolicy allows everything.
+type streamPolicy struct {
+	cfg         config.StreamPolicyConfig
+	action      string
+	maxKeyframe int64 // Milliseconds, like RTMP timestamps
+	videoCodecs map[string]bool
+	audioCodecs map[string]bool
+	reg         *metrics.Registry
+	log         *logger.Logger
+
+	mu       sync.Mutex
+	stream   string
+	reported map[string]bool
+	lastKey  trackClock // Latest keyframe, or the first frame until one arrives
+}
+
+// newStreamPolicy returns the policy for a session of tenant, whose own
+// stream_policy replaces the global one, or nil when neither is enabled.
+func (s *Server) newStreamPolicy(log *logger.Logger, tenant *Tenant) *streamPolicy {
+	cfg := s.StreamPolicy
+	if tenant != nil && tenant.StreamPolicy != nil {
+		cfg = *tenant.StreamPolicy
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	action := cfg.Action
+	if action == "" {
+		action = config.StreamPolicyWarn
+	}
+	return &streamPolicy{
+		cfg:         cfg,
+		action:      action,
+		maxKeyframe: cfg.MaxKeyframeInterval.AsDuration().Milliseconds(),
+		videoCodecs: codecSet(cfg.VideoCodecs),
+		audioCodecs: codecSet(cfg.AudioCodecs),
+		reg:         s.Metrics,
+		log:         log,
+		reported:    make(map[string]bool),
+	}
+}
+
+// codecSet returns the allowed codec names, or nil to allow any.
+func codecSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return set
+}
+
+// setStream starts checking a newly published stream from scratch.
+func (p *streamPolicy) setStream(name string) {
+	if p == nil {
+		return
+	}
+	name = stripStreamQuery(name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == p.stream {
+		return
+	}
+	p.stream = name
+	clear(p.reported)
+	p.lastKey = trackClock{}
+}
+
+// observe checks msg, returning an error tagged ReasonPolicyViolation when
+// it breaks a rule and the action is reject.
+func (p *streamPolicy) observe(msg *rtmp.Message) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stream == "" {
+		return nil
+	}
+	switch msg.Header.TypeID {
+	case rtmp.TypeVideo:
+		return p.checkVideoLocked(msg)
+	case rtmp.TypeAudio:
+		h, err := rtmp.ParseAudioHeader(msg.Payload)
+		if err != nil || p.audioCodecs == nil || p.audioCodecs[h.Codec()] {
+			return nil
+		}
+		return p.violateLocked(policyAudioCodec, "codec", h.Codec())
+	case rtmp.TypeAMF0Data:
+		return p.checkMetadataLocked(msg.Payload)
+	}
+	return nil
+}
+
+func (p *streamPolicy) checkVideoLocked(msg *rtmp.Message) error {
+	h, err := rtmp.ParseVideoHeader(msg.Payload)
+	if err != nil {
+		return nil
+	}
+	if p.videoCodecs != nil && !p.videoCodecs[h.Codec()] {
+		if err := p.violateLocked(policyVideoCodec, "codec", h.Codec()); err != nil {
+			return err
+		}
+	}
+	if h.IsSequenceHeader() || h.FrameType == rtmp.FrameInfoCommand {
+		return nil
+	}
+	// Frames decoded out of order carry a composition time offset.
+	if p.cfg.DisallowBFrames && h.CompositionTime != 0 {
+		if err := p.violateLocked(policyBFrames, "composition_time_ms", h.CompositionTime); err != nil {
+			return err
+		}
+	}
+
+	ts := msg.Header.Timestamp
+	if !p.lastKey.seen {
+		p.lastKey = trackClock{last: ts, seen: true}
+	}
+	// Checked on every frame so a stream that stops sending keyframes is
+	// caught without waiting for the next one.
+	if interval := int64(int32(ts - p.lastKey.last)); p.maxKeyframe > 0 && interval > p.maxKeyframe {
+		if err := p.violateLocked(policyKeyframeInterval, "interval_ms", interval, "max_ms", p.maxKeyframe); err != nil {
+			return err
+		}
+	}
+	if h.FrameType == rtmp.FrameKeyframe {
+		p.lastKey.last = ts
+	}
+	return nil
+}
+
+// checkMetadataLocked applies the resolution and bitrate limits to the
+// values an encoder declares in onMetaData.
+func (p *streamPolicy) checkMetadataLocked(payload []byte) error {
+	if p.cfg.MaxWidth <= 0 && p.cfg.MaxHeight <= 0 && p.cfg.MaxBitrateKbps <= 0 {
+		return nil
+	}
+	meta := parseOnMetaData(payload)
+	if meta == nil {
+		return nil
+	}
+	width, height := amfNumber(meta["width"]), amfNumber(meta["height"])
+	if (p.cfg.MaxWidth > 0 && width > float64(p.cfg.MaxWidth)) || (p.cfg.MaxHeight > 0 && height > float64(p.cfg.MaxHeight)) {
+		if err := p.violateLocked(policyResolution, "width", width, "height", height, "max_width", p.cfg.MaxWidth, "max_height", p.cfg.MaxHeight); err != nil {
+			return err
+		}
+	}
+	kbps := amfNumber(meta["videodatarate"]) + amfNumber(meta["audiodatarate"])
+	if p.cfg.MaxBitrateKbps > 0 && kbps > float64(p.cfg.MaxBitrateKbps) {
+		return p.violateLocked(policyBitrate, "kbps", kbps, "max_kbps", p.cfg.MaxBitrateKbps)
+	}
+	return nil
+}
+
+// violateLocked reports a broken rule the first time the stream breaks it.
+func (p *streamPolicy) violateLocked(rule string, detail ...any) error {
+	if p.reported[rule] {
+		return nil
+	}
+	p.reported[rule] = true
+	p.reg.RecordStreamPolicyViolation(rule, p.action)
+	args := append([]any{"stream", p.stream, "rule", rule, "action", p.action}, detail...)
+	if p.action != config.StreamPolicyReject {
+		p.log.Warn("stream policy violated", args...)
+		return nil
+	}
+	p.log.Warn("stream policy violated, ending session", args...)
+	return withReason(ReasonPolicyViolation, fmt.Errorf("stream %q violates the %s policy", p.stream, rule))
+}
+
+// parseOnMetaData returns the properties of an onMetaData data message, sent
+// by encoders either bare or wrapped in @setDataFrame, or nil for any other
+// message.
+func parseOnMetaData(payload []byte) map[string]interface{} {
+	values, err := rtmp.DecodeAMF0(bytes.NewReader(payload))
+	if err != nil {
+		return nil
+	}
+	for i, v := range values {
+		if name, ok := v.(string); ok && name == "onMetaData" && i+1 < len(values) {
+			meta, _ := values[i+1].(map[string]interface{})
+			return meta
+		}
+	}
+	return nil
+}
+
+func amfNumber(v interface{}) float64 {
+	n, _ := v.(float64)
+	return n
+}
@@ -0,0 +1,128 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func videoFrame(ts uint32, keyframe bool, cts byte) *rtmp.Message {
+	first := byte(0x27)
+	if keyframe {
+		first = 0x17
+	}
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts}, Payload: []byte{first, 0x01, 0, 0, cts}}
+}
+
+func metadataMessage(t *testing.T, meta map[string]interface{}) *rtmp.Message {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := rtmp.EncodeAMF0(&buf, "@setDataFrame", "onMetaData", meta); err != nil {
+		t.Fatal(err)
+	}
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAMF0Data}, Payload: buf.Bytes()}
+}
+
+func TestStreamPolicyWarnsOncePerRule(t *testing.T) {
+	var logs bytes.Buffer
+	srv := &Server{StreamPolicy: config.StreamPolicyConfig{
+		Enabled:             true,
+		MaxKeyframeInterval: config.Duration(2 * time.Second),
+		AudioCodecs:         []string{"AAC"},
+		DisallowBFrames:     true,
+	}}
+	p := srv.newStreamPolicy(logger.NewWithWriter(&logs), nil)
+	p.setStream("cam1")
+
+	for ts := uint32(0); ts <= 5000; ts += 100 {
+		if err := p.observe(videoFrame(ts, ts == 0, 0)); err != nil {
+			t.Fatalf("warn policy returned %v", err)
+		}
+	}
+	mp3 := &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAudio}, Payload: []byte{0x2f, 0}}
+	if err := p.observe(mp3); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.observe(videoFrame(5100, false, 0x21)); err != nil {
+		t.Fatal(err)
+	}
+	out := logs.String()
+	for _, rule := range []string{policyKeyframeInterval, policyAudioCodec, policyBFrames} {
+		if n := strings.Count(out, `"rule":"`+rule+`"`); n != 1 {
+			t.Errorf("%s reported %d times:\n%s", rule, n, out)
+		}
+	}
+
+	// A new stream is checked from scratch.
+	logs.Reset()
+	p.setStream("cam2")
+	p.observe(mp3)
+	if !strings.Contains(logs.String(), `"stream":"cam2"`) {
+		t.Fatalf("expected cam2 to be reported again, got %s", logs.String())
+	}
+}
+
+func TestStreamPolicyRejectsTenantPlanLimits(t *testing.T) {
+	srv := &Server{StreamPolicy: config.StreamPolicyConfig{Enabled: true, MaxWidth: 3840}}
+	tenant := &Tenant{App: "free", StreamPolicy: &config.StreamPolicyConfig{
+		Enabled:        true,
+		Action:         config.StreamPolicyReject,
+		MaxHeight:      720,
+		MaxBitrateKbps: 3000,
+		VideoCodecs:    []string{"h264"},
+	}}
+	p := srv.newStreamPolicy(logger.NewWithWriter(&bytes.Buffer{}), tenant)
+	p.setStream("cam1")
+
+	if err := p.observe(metadataMessage(t, map[string]interface{}{"width": 1280.0, "height": 720.0, "videodatarate": 2500.0, "audiodatarate": 128.0})); err != nil {
+		t.Fatalf("metadata within the plan rejected: %v", err)
+	}
+	if err := p.observe(videoFrame(0, true, 0)); err != nil {
+		t.Fatalf("h264 rejected: %v", err)
+	}
+	err := p.observe(metadataMessage(t, map[string]interface{}{"width": 1920.0, "height": 1080.0}))
+	var te *terminationError
+	if !errors.As(err, &te) || te.reason != ReasonPolicyViolation || !strings.Contains(err.Error(), policyResolution) {
+		t.Fatalf("expected a resolution policy violation, got %v", err)
+	}
+	hevc := &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo}, Payload: []byte{0x90, 'h', 'v', 'c', '1'}}
+	if err := p.observe(hevc); err == nil || !strings.Contains(err.Error(), policyVideoCodec) {
+		t.Fatalf("expected a video codec violation, got %v", err)
+	}
+
+	// Tenants without a policy of their own fall back to the global one.
+	if p := srv.newStreamPolicy(nil, &Tenant{App: "paid"}); p == nil || p.cfg.MaxWidth != 3840 {
+		t.Fatalf("expected the global policy, got %+v", p)
+	}
+	if p := (&Server{}).newStreamPolicy(nil, nil); p != nil {
+		t.Fatal("expected no policy when disabled")
+	}
+}
+
+func TestForwardMessagesStopsAtPolicyViolation(t *testing.T) {
+	srv := &Server{StreamPolicy: config.StreamPolicyConfig{Enabled: true, Action: config.StreamPolicyReject, VideoCodecs: []string{"hevc"}}}
+	p := srv.newStreamPolicy(logger.NewWithWriter(&bytes.Buffer{}), nil)
+	p.setStream("cam1")
+
+	var in, out bytes.Buffer
+	cw := rtmp.NewChunkWriter(&in)
+	frame := videoFrame(0, true, 0)
+	frame.Header.CSID, frame.Header.StreamID = rtmp.CSIDVideo, 1
+	if err := cw.WriteMessage(frame); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithLogger(t.Context(), logger.NewWithWriter(&bytes.Buffer{}))
+	err := forwardMessages(ctx, rtmp.NewChunkStream(&in), rtmp.NewChunkWriter(&out), newSessionQueue(0, nil), nil, nil, p.observe)
+	if terminationReason(err, "") != ReasonPolicyViolation {
+		t.Fatalf("expected a policy violation, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("the violating frame was relayed: %d bytes", out.Len())
+	}
+}
@@ -0,0 +1,183 @@
+package relay
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"ffmpeg-go-relay/internal/auth"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/middleware"
+)
+
+// Limit names reported by RecordTenantRejection.
+const (
+	tenantLimitAuth       = "auth"
+	tenantLimitRate       = "rate_limit"
+	tenantLimitConnection = "connection_limit"
+)
+
+// Tenant is one customer of a shared relay, identified by RTMP app name.
+// Nil fields leave that check to the Server-wide setting alone.
+type Tenant struct {
+	App       string
+	Auth      *auth.TokenAuthenticator // Replaces Server.Auth for the app
+	RateLimit *middleware.RateLimiter
+	ConnLimit *middleware.ConnectionLimiter
+
+	StreamPolicy *config.StreamPolicyConfig // Replaces Server.StreamPolicy for the app
+
+	active atomic.Int64
+}
+
+// TenantStatus reports a tenant's sessions and limiter state.
+type TenantStatus struct {
+	App            string         `json:"app"`
+	ActiveSessions int64          `json:"active_sessions"`
+	Auth           bool           `json:"auth"`
+	Connections    map[string]any `json:"connections,omitempty"`
+	RateLimit      map[string]any `json:"rate_limit,omitempty"`
+}
+
+// Tenants looks tenants up by the app of a connect. A nil Tenants has none,
+// so every session is held to the global settings only.
+type Tenants struct {
+	byApp map[string]*Tenant
+}
+
+// NewTenants builds the auth and limiters of each configured tenant. Their
+// upstreams are routes, built with the Router. Returns nil when cfgs is empty.
+func NewTenants(cfgs []config.TenantConfig) *Tenants {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	t := &Tenants{byApp: make(map[string]*Tenant, len(cfgs))}
+	for _, tc := range cfgs {
+		tenant := &Tenant{App: tc.App, StreamPolicy: tc.StreamPolicy}
+		if len(tc.AuthTokens) > 0 {
+			tenant.Auth = auth.NewTokenAuthenticator(tc.AuthTokens)
+		}
+		if tc.RateLimit.Enabled {
+			tenant.RateLimit = middleware.NewRateLimiter(tc.RateLimit.RequestsPerSec, tc.RateLimit.Burst)
+		}
+		if tc.ConnectionLimit.MaxTotal > 0 || tc.ConnectionLimit.MaxPerIP > 0 {
+			tenant.ConnLimit = middleware.NewConnectionLimiter(tc.ConnectionLimit.MaxTotal, tc.ConnectionLimit.MaxPerIP)
+		}
+		t.byApp[tc.App] = tenant
+	}
+	return t
+}
+
+// TenantRoutes returns a route per tenant with its own upstreams, matching
+// every stream of its app. They belong after the explicit routes so those
+// can still single out streams of a tenant.
+func TenantRoutes(cfgs []config.TenantConfig) []config.RouteConfig {
+	var routes []config.RouteConfig
+	for _, tc := range cfgs {
+		if len(tc.Upstreams) == 0 {
+			continue
+		}
+		routes = append(routes, config.RouteConfig{
+			Match:     tc.App + "/*",
+			Upstreams: tc.Upstreams,
+			Strategy:  tc.Strategy,
+		})
+	}
+	return routes
+}
+
+// Lookup returns the tenant owning app, which may carry a query string.
+func (t *Tenants) Lookup(app string) *Tenant {
+	if t == nil {
+		return nil
+	}
+	app, _, _ = strings.Cut(app, "?")
+	return t.byApp[app]
+}
+
+// Authenticator returns the tokens that admit sessions of app: the tenant's
+// own when it has any, otherwise global. Nil means app needs no token.
+func (t *Tenants) Authenticator(app string, global *auth.TokenAuthenticator) *auth.TokenAuthenticator {
+	if tenant := t.Lookup(app); tenant != nil && tenant.Auth != nil {
+		return tenant.Auth
+	}
+	return global
+}
+
+// Stop releases the tenants' rate limiter cleanup goroutines.
+func (t *Tenants) Stop() {
+	if t == nil {
+		return
+	}
+	for _, tenant := range t.byApp {
+		if tenant.RateLimit != nil {
+			tenant.RateLimit.Stop()
+		}
+	}
+}
+
+// Stats returns every tenant's status, sorted by app.
+func (t *Tenants) Stats() []TenantStatus {
+	if t == nil {
+		return nil
+	}
+	stats := make([]TenantStatus, 0, len(t.byApp))
+	for _, tenant := range t.byApp {
+		st := TenantStatus{
+			App:            tenant.App,
+			ActiveSessions: tenant.active.Load(),
+			Auth:           tenant.Auth != nil,
+		}
+		if tenant.ConnLimit != nil {
+			st.Connections = tenant.ConnLimit.Stats()
+		}
+		if tenant.RateLimit != nil {
+			st.RateLimit = tenant.RateLimit.Stats()
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].App < stats[j].App })
+	return stats
+}
+
+// appQueryToken returns the token query parameter of a connect app such as
+// "acme?token=secret".
+func appQueryToken(app string) string {
+	return appQueryParam(app, "token")
+}
+
+// appQueryParam returns the query parameter key of a connect app.
+func appQueryParam(app, key string) string {
+	_, raw, ok := strings.Cut(app, "?")
+	if !ok {
+		return ""
+	}
+	query, _ := url.ParseQuery(raw)
+	return query.Get(key)
+}
+
+// admit applies the tenant's limits to a new session from ip. On refusal it
+// also returns the name of the limit that refused it.
+func (t *Tenant) admit(ip string) (string, error) {
+	if t.RateLimit != nil {
+		if err := t.RateLimit.Allow(ip); err != nil {
+			return tenantLimitRate, err
+		}
+	}
+	if t.ConnLimit != nil {
+		if err := t.ConnLimit.Acquire(ip); err != nil {
+			return tenantLimitConnection, err
+		}
+	}
+	t.active.Add(1)
+	return "", nil
+}
+
+// release ends a session admitted from ip.
+func (t *Tenant) release(ip string) {
+	t.active.Add(-1)
+	if t.ConnLimit != nil {
+		t.ConnLimit.Release(ip)
+	}
+}
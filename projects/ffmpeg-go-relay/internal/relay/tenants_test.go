@@ -0,0 +1,92 @@
+package relay
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestTenantsLookupAndLimits(t *testing.T) {
+	tenants := NewTenants([]config.TenantConfig{
+		{App: "acme", AuthTokens: []string{"acme-secret"}, ConnectionLimit: config.ConnectionLimitConfig{MaxTotal: 1}},
+		{App: "globex", RateLimit: config.RateLimitConfig{Enabled: true, RequestsPerSec: 0.001, Burst: 1}},
+	})
+	defer tenants.Stop()
+
+	acme := tenants.Lookup("acme?token=acme-secret")
+	if acme == nil || acme.Auth == nil {
+		t.Fatalf("Lookup(acme?token=...) = %+v, want acme with its own auth", acme)
+	}
+	if err := acme.Auth.Authenticate(appQueryToken("acme?token=acme-secret")); err != nil {
+		t.Fatalf("tenant token rejected: %v", err)
+	}
+	if tenants.Lookup("initech") != nil {
+		t.Fatal("unconfigured app resolved to a tenant")
+	}
+
+	if _, err := acme.admit("10.0.0.1"); err != nil {
+		t.Fatalf("first acme session refused: %v", err)
+	}
+	if limit, err := acme.admit("10.0.0.2"); err == nil || limit != tenantLimitConnection {
+		t.Fatalf("second acme session = %q, %v; want the connection limit", limit, err)
+	}
+	if st := tenants.Stats(); len(st) != 2 || st[0].App != "acme" || st[0].ActiveSessions != 1 {
+		t.Fatalf("stats = %+v, want acme first with one session", st)
+	}
+	acme.release("10.0.0.1")
+	if _, err := acme.admit("10.0.0.2"); err != nil {
+		t.Fatalf("acme session refused after release: %v", err)
+	}
+
+	globex := tenants.Lookup("globex")
+	if _, err := globex.admit("10.0.0.1"); err != nil {
+		t.Fatalf("first globex session refused: %v", err)
+	}
+	if limit, err := globex.admit("10.0.0.1"); err == nil || limit != tenantLimitRate {
+		t.Fatalf("second globex session = %q, %v; want the rate limit", limit, err)
+	}
+	if _, err := globex.admit("10.0.0.2"); err != nil {
+		t.Fatalf("rate limit is per client IP, got %v", err)
+	}
+}
+
+func TestTenantRoutes(t *testing.T) {
+	cfgs := []config.TenantConfig{
+		{App: "acme", Upstreams: []config.UpstreamEndpoint{{URL: "rtmp://acme.example.com/live", Weight: 1}}},
+		{App: "globex"},
+	}
+	routes := append([]config.RouteConfig{{
+		Match:     "acme/vip-*",
+		Upstreams: []config.UpstreamEndpoint{{URL: "rtmp://vip.example.com/live", Weight: 1}},
+	}}, TenantRoutes(cfgs)...)
+	router, err := NewRouter(routes, "round_robin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		app, stream, want string
+	}{
+		{"acme", "vip-1", "acme/vip-*"},
+		{"acme", "show", "acme/*"},
+		{"acme?token=acme-secret", "", "acme/*"},
+	} {
+		if _, match, ok := router.Match(tc.app, tc.stream); !ok || match != tc.want {
+			t.Fatalf("Match(%q, %q) = %q, %v; want %q", tc.app, tc.stream, match, ok, tc.want)
+		}
+	}
+	if _, _, ok := router.Match("globex", "show"); ok {
+		t.Fatal("tenant without upstreams got a route")
+	}
+}
+
+func TestNilTenants(t *testing.T) {
+	var tenants *Tenants
+	if tenants.Lookup("acme") != nil || tenants.Stats() != nil {
+		t.Fatal("nil Tenants should have no tenants")
+	}
+	tenants.Stop()
+	if NewTenants(nil) != nil {
+		t.Fatal("NewTenants(nil) should return nil")
+	}
+}
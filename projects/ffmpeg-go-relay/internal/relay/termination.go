@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	"ffmpeg-go-relay/internal/errclass"
+)
+
+// Session termination reasons, used as metric labels.
+const (
+	ReasonClientDisconnect = "client_disconnect"
+	ReasonUpstreamError    = "upstream_error"
+	ReasonAdminKill        = "admin_kill"
+	ReasonQuota            = "quota"
+	ReasonIdle             = "idle"
+	ReasonAuthFailure      = "auth_failure"
+	ReasonProtocolError    = "protocol_error"
+	ReasonTranscodeError   = "transcode_error"
+	ReasonTranscodeBusy    = "transcode_busy"
+	ReasonStreamConflict   = "stream_conflict"
+	ReasonPolicyViolation  = "policy_violation"
+	ReasonShutdown         = "shutdown"
+	ReasonDraining         = "draining"
+	ReasonRedirected       = "redirected"
+	ReasonStateTimeout     = "state_timeout"
+)
+
+// errUpstreamClosed marks the upstream ending the relay with a clean EOF.
+var errUpstreamClosed = errors.New("upstream closed connection")
+
+// terminationError tags a session error with the reason the session ended.
+// It is transparent: Error and Unwrap defer to the wrapped error.
+type terminationError struct {
+	reason string
+	err    error
+}
+
+func (e *terminationError) Error() string { return e.err.Error() }
+func (e *terminationError) Unwrap() error { return e.err }
+
+// withReason tags err with a termination reason. Timeouts, cancellations and
+// errclass errors keep their own classification since they explain the
+// failure better, and an error tagged already keeps its reason.
+func withReason(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var te *terminationError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &terminationError{reason: reasonFor(err, reason), err: err}
+}
+
+// terminationReason classifies how a session ended. fallback is used for
+// sessions that ended without error or with an untagged error.
+func terminationReason(err error, fallback string) string {
+	if err == nil {
+		return fallback
+	}
+	var te *terminationError
+	if errors.As(err, &te) {
+		return te.reason
+	}
+	return reasonFor(err, fallback)
+}
+
+func reasonFor(err error, fallback string) string {
+	if errors.Is(err, context.Canceled) {
+		return ReasonShutdown
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ReasonIdle
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ReasonIdle
+	}
+	if reason, ok := classReasons[errclass.Of(err)]; ok {
+		return reason
+	}
+	return fallback
+}
+
+// classReasons maps the classes of typed errors to the reason they end a
+// session with, so e.g. a malformed chunk read from the client counts as a
+// protocol error rather than a disconnect.
+var classReasons = map[errclass.Class]string{
+	errclass.Auth:         ReasonAuthFailure,
+	errclass.Protocol:     ReasonProtocolError,
+	errclass.UpstreamDial: ReasonUpstreamError,
+	errclass.Upstream:     ReasonUpstreamError,
+	errclass.Transcode:    ReasonTranscodeError,
+}
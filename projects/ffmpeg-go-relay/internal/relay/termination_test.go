@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"ffmpeg-go-relay/internal/errclass"
+)
+
+func TestTerminationReason(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name     string
+		err      error
+		fallback string
+		want     string
+	}{
+		{"nil uses fallback", nil, ReasonClientDisconnect, ReasonClientDisconnect},
+		{"untagged uses fallback", boom, ReasonUpstreamError, ReasonUpstreamError},
+		{"tagged", withReason(ReasonQuota, boom), ReasonClientDisconnect, ReasonQuota},
+		{"tagged and wrapped", fmt.Errorf("outer: %w", withReason(ReasonAuthFailure, boom)), ReasonClientDisconnect, ReasonAuthFailure},
+		{"deadline is idle", withReason(ReasonProtocolError, os.ErrDeadlineExceeded), ReasonClientDisconnect, ReasonIdle},
+		{"cancel is shutdown", fmt.Errorf("dial: %w", context.Canceled), ReasonUpstreamError, ReasonShutdown},
+		{"class beats the tag", withReason(ReasonClientDisconnect, fmt.Errorf("read message: %w", &errclass.ProtocolError{Err: boom})), ReasonClientDisconnect, ReasonProtocolError},
+		{"untagged class", &errclass.UpstreamDialError{Upstream: "up:1935", Err: boom}, ReasonClientDisconnect, ReasonUpstreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := terminationReason(tt.err, tt.fallback); got != tt.want {
+				t.Fatalf("terminationReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithReasonTransparent(t *testing.T) {
+	if withReason(ReasonQuota, nil) != nil {
+		t.Fatal("withReason(nil) should be nil")
+	}
+	base := errors.New("limit exceeded")
+	err := withReason(ReasonQuota, base)
+	if err.Error() != base.Error() {
+		t.Fatalf("Error() = %q, want %q", err.Error(), base.Error())
+	}
+	if !errors.Is(err, base) {
+		t.Fatal("expected wrapped error to match base")
+	}
+}
@@ -0,0 +1,83 @@
+package relay
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// TranscodeProfiles picks each session's transcode settings by the first
+// matching rule. A nil TranscodeProfiles leaves every session on the global
+// transcode settings.
+type TranscodeProfiles struct {
+	base     config.TranscodeConfig
+	profiles map[string]config.TranscodeConfig
+	rules    []config.TranscodeRule
+}
+
+// NewTranscodeProfiles returns the profile selector for cfg, with base for
+// sessions no rule matches, or nil when cfg has no rules.
+func NewTranscodeProfiles(base config.TranscodeConfig, cfg config.TranscodeProfilesConfig) *TranscodeProfiles {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	profiles := make(map[string]config.TranscodeConfig, len(cfg.Profiles))
+	for name, p := range cfg.Profiles {
+		// Picking a profile enables it; the kill switch and slots are shared.
+		p.Enabled = true
+		p.KillSwitch, p.Slots = base.KillSwitch, base.Slots
+		profiles[name] = p
+	}
+	return &TranscodeProfiles{base: base, profiles: profiles, rules: cfg.Rules}
+}
+
+// Select returns the settings for a session publishing stream to app with
+// token, and the name of the profile they come from: "" for the global
+// settings, or passthrough with settings that are not enabled. Before the
+// publish the stream is "", and ok is false when the first rule that could
+// match depends on the stream name.
+func (p *TranscodeProfiles) Select(app, stream, token string) (cfg config.TranscodeConfig, profile string, ok bool) {
+	app, _, _ = strings.Cut(app, "?")
+	for _, rule := range p.rules {
+		if !patternMatch(rule.App, app) {
+			continue
+		}
+		if len(rule.Tokens) > 0 && (token == "" || !slices.Contains(rule.Tokens, token)) {
+			continue
+		}
+		if rule.Stream != "" && rule.Stream != "*" {
+			if stream == "" {
+				return config.TranscodeConfig{}, "", false
+			}
+			if !patternMatch(rule.Stream, stream) {
+				continue
+			}
+		}
+		if rule.Profile == config.ProfilePassthrough {
+			return config.TranscodeConfig{}, config.ProfilePassthrough, true
+		}
+		return p.profiles[rule.Profile], rule.Profile, true
+	}
+	return p.base, "", true
+}
+
+// patternMatch reports whether name matches pattern, which matches anything
+// when empty.
+func patternMatch(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// transcodeFor returns the transcode settings of a session, as Select
+// does, or the global settings without profile rules.
+func (s *Server) transcodeFor(app, stream, token string) (config.TranscodeConfig, string, bool) {
+	if s.TranscodeProfiles == nil {
+		return s.Transcode, "", true
+	}
+	return s.TranscodeProfiles.Select(app, stream, token)
+}
@@ -0,0 +1,52 @@
+package relay
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestTranscodeProfilesSelect(t *testing.T) {
+	p := NewTranscodeProfiles(config.TranscodeConfig{Enabled: true, VideoCodec: "libx264", Slots: config.TranscodeSlotsConfig{MaxSessions: 4}}, config.TranscodeProfilesConfig{
+		Profiles: map[string]config.TranscodeConfig{
+			"hd":     {VideoCodec: "libx264", Height: 720},
+			"mobile": {VideoCodec: "libx264", Height: 360},
+		},
+		Rules: []config.TranscodeRule{
+			{App: "vip", Tokens: []string{"gold"}, Profile: "hd"},
+			{App: "live", Stream: "*_mobile", Profile: "mobile"},
+			{App: "live", Profile: config.ProfilePassthrough},
+		},
+	})
+
+	for _, tc := range []struct {
+		app, stream, token string
+		profile            string
+		ok                 bool
+	}{
+		{"vip?token=gold", "", "gold", "hd", true},
+		{"vip", "", "silver", "", true}, // Falls through to the global settings
+		{"live", "", "", "", false},     // The second rule needs the stream name
+		{"live", "cam_mobile", "", "mobile", true},
+		{"live", "cam", "", config.ProfilePassthrough, true},
+		{"other", "", "", "", true},
+	} {
+		cfg, profile, ok := p.Select(tc.app, tc.stream, tc.token)
+		if profile != tc.profile || ok != tc.ok {
+			t.Fatalf("Select(%q, %q, %q) = %q, %v; want %q, %v", tc.app, tc.stream, tc.token, profile, ok, tc.profile, tc.ok)
+		}
+		if !ok {
+			continue
+		}
+		if wantEnabled := profile != config.ProfilePassthrough; cfg.Enabled != wantEnabled {
+			t.Fatalf("Select(%q, %q) enabled = %v, want %v", tc.app, tc.stream, cfg.Enabled, wantEnabled)
+		}
+		if profile == "mobile" && (cfg.Height != 360 || cfg.Slots.MaxSessions != 4) {
+			t.Fatalf("mobile profile = %+v, want its height and the shared slots", cfg)
+		}
+	}
+
+	if NewTranscodeProfiles(config.TranscodeConfig{}, config.TranscodeProfilesConfig{}) != nil {
+		t.Fatal("expected no selector without rules")
+	}
+}
@@ -1,10 +1,14 @@
 package relay
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/url"
 	"strings"
+
+	"ffmpeg-go-relay/internal/middleware"
 )
 
 const (
@@ -20,6 +24,17 @@ type UpstreamInfo struct {
 	Port    string
 	Address string
 	UseTLS  bool
+	Egress  *middleware.Shaper // Optional egress shaping shared by all sessions to this upstream
+
+	DialLimit *middleware.DialLimiter // Optional pacing of new connections, shared like Egress
+	Bind      SourceBind              // Local address and interface upstream connections are made from
+	DSCP      int                     // DSCP class marked on upstream connections; 0 leaves them unmarked
+
+	Credentials []Credential // Tried in order on connect; empty forwards the client's connect as is
+
+	// Set by UpstreamPool.Pick for ReportConnect.
+	pool     *UpstreamPool
+	endpoint *upstreamState
 }
 
 // ParseUpstream normalizes an upstream string and returns connection info.
@@ -40,7 +55,7 @@ func ParseUpstream(raw string) (UpstreamInfo, error) {
 
 	scheme := strings.ToLower(parsed.Scheme)
 	switch scheme {
-	case "rtmp", "rtmps", "rtsp", "rtsps":
+	case "rtmp", "rtmps", "rtsp", "rtsps", "srt":
 	default:
 		return UpstreamInfo{}, fmt.Errorf("unsupported upstream scheme %q", parsed.Scheme)
 	}
@@ -51,6 +66,9 @@ func ParseUpstream(raw string) (UpstreamInfo, error) {
 	}
 
 	port := parsed.Port()
+	if port == "" && scheme == "srt" {
+		return UpstreamInfo{}, fmt.Errorf("srt upstream needs a port")
+	}
 	if port == "" {
 		port = defaultPortForScheme(scheme)
 	}
@@ -67,6 +85,18 @@ func ParseUpstream(raw string) (UpstreamInfo, error) {
 	}, nil
 }
 
+// Remuxed reports whether sessions to u go through the transcoder instead of
+// being relayed as RTMP, because the upstream speaks RTSP or SRT.
+func (u UpstreamInfo) Remuxed() bool {
+	return u.Scheme != "rtmp" && u.Scheme != "rtmps"
+}
+
+// Datagram reports whether u is reached over UDP, as SRT is. There is no
+// connection to probe, so health checks treat such an upstream as up.
+func (u UpstreamInfo) Datagram() bool {
+	return u.Scheme == "srt"
+}
+
 func defaultPortForScheme(scheme string) string {
 	switch scheme {
 	case "rtsp", "rtsps":
@@ -75,3 +105,39 @@ func defaultPortForScheme(scheme string) string {
 		return defaultRTMPPort
 	}
 }
+
+// UpstreamReady reports whether new sessions have somewhere to go. With a pool
+// that means at least one healthy member; otherwise the single upstream must
+// accept a TCP (or TLS) connection before ctx expires. An empty upstream is
+// treated as ready.
+func UpstreamReady(ctx context.Context, upstream string, pool *UpstreamPool) bool {
+	if pool != nil {
+		return pool.HealthyCount() > 0
+	}
+	if upstream == "" {
+		return true
+	}
+	info, err := ParseUpstream(upstream)
+	if err != nil {
+		return false
+	}
+	if info.Datagram() {
+		return true
+	}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if info.UseTLS {
+		tlsDialer := tls.Dialer{
+			NetDialer: dialer,
+			Config:    &tls.Config{ServerName: info.Host},
+		}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", info.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", info.Address)
+	}
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
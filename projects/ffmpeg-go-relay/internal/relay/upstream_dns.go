@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"slices"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// DefaultDNSRefreshInterval is how often expand_dns upstreams are
+// re-resolved when no interval is configured.
+const DefaultDNSRefreshInterval = 30 * time.Second
+
+// DNSRefreshConfig controls re-resolution of expand_dns upstreams.
+type DNSRefreshConfig struct {
+	Interval time.Duration                                            // 0 uses DefaultDNSRefreshInterval
+	Lookup   func(ctx context.Context, host string) ([]string, error) // nil uses net.DefaultResolver
+}
+
+// expandTarget is an expand_dns upstream as configured. Its records become
+// endpoints of the pool, each a copy of info dialing one address.
+type expandTarget struct {
+	url     string
+	info    UpstreamInfo
+	weight  int
+	records []string // Sorted; nil until the first successful lookup
+}
+
+// StartDNSRefresh resolves the pool's expand_dns upstreams now and then every
+// interval, replacing each one's endpoints with one per record. Records that
+// stay keep their health; a failed or empty lookup keeps the previous set.
+func (p *UpstreamPool) StartDNSRefresh(ctx context.Context, log *logger.Logger, cfg DNSRefreshConfig) {
+	if p == nil || len(p.expand) == 0 {
+		return
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultDNSRefreshInterval
+	}
+	if cfg.Lookup == nil {
+		cfg.Lookup = net.DefaultResolver.LookupHost
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		p.refreshDNS(ctx, log, cfg)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.refreshDNS(ctx, log, cfg)
+			}
+		}
+	}()
+}
+
+func (p *UpstreamPool) refreshDNS(ctx context.Context, log *logger.Logger, cfg DNSRefreshConfig) {
+	for _, t := range p.expand {
+		lookupCtx, cancel := context.WithTimeout(ctx, cfg.Interval)
+		records, err := cfg.Lookup(lookupCtx, t.info.Host)
+		cancel()
+		if err == nil && len(records) == 0 {
+			err = &net.DNSError{Err: "no records", Name: t.info.Host, IsNotFound: true}
+		}
+		if err != nil {
+			if log != nil {
+				log.Warn("upstream DNS lookup failed, keeping previous records", "upstream", t.url, "err", err)
+			}
+			continue
+		}
+		added, removed := p.applyRecords(t, records)
+		if log != nil && (len(added) > 0 || len(removed) > 0) {
+			log.Info("upstream records changed", "upstream", t.url, "added", added, "removed", removed)
+		}
+	}
+}
+
+// applyRecords makes t's endpoints match records, keeping the state of those
+// that remain. It returns the records added and removed.
+func (p *UpstreamPool) applyRecords(t *expandTarget, records []string) (added, removed []string) {
+	records = slices.Clone(records)
+	slices.Sort(records)
+	records = slices.Compact(records)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if slices.Equal(records, t.records) {
+		return nil, nil
+	}
+
+	current := map[string]*upstreamState{}
+	for _, endpoint := range p.endpoints {
+		if endpoint.url == t.url && endpoint.record != "" {
+			current[endpoint.record] = endpoint
+		}
+	}
+	group := make([]*upstreamState, 0, len(records))
+	for _, record := range records {
+		if endpoint, ok := current[record]; ok {
+			group = append(group, endpoint)
+			delete(current, record)
+			continue
+		}
+		info := t.info
+		info.Address = net.JoinHostPort(record, t.info.Port)
+		group = append(group, &upstreamState{url: t.url, info: info, weight: t.weight, healthy: true, record: record})
+		added = append(added, record)
+	}
+	for record := range current {
+		removed = append(removed, record)
+	}
+	slices.Sort(removed)
+
+	// The group takes the place of the old one, so pick order stays stable.
+	endpoints := make([]*upstreamState, 0, len(p.endpoints)+len(group))
+	placed := false
+	for _, endpoint := range p.endpoints {
+		if endpoint.url != t.url {
+			endpoints = append(endpoints, endpoint)
+			continue
+		}
+		if !placed {
+			endpoints = append(endpoints, group...)
+			placed = true
+		}
+	}
+	if !placed {
+		endpoints = append(endpoints, group...)
+	}
+	p.endpoints = endpoints
+	p.rrIndex = 0
+	t.records = records
+	return added, removed
+}
@@ -6,18 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/events"
 	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/middleware"
 )
 
 const (
 	upstreamStrategyRoundRobin = "round_robin"
 	upstreamStrategyRandom     = "random"
+	upstreamStrategyRedundant  = "redundant"
 )
 
 // HealthCheckConfig controls upstream health checks.
@@ -25,8 +27,17 @@ type HealthCheckConfig struct {
 	Enabled  bool
 	Interval time.Duration
 	Timeout  time.Duration
+	LogMode  string        // HealthLogEveryFailure or HealthLogStateChange; empty is the former
+	Reminder time.Duration // How often HealthLogStateChange repeats a down upstream; 0 uses 10m
+	Events   *events.Bus   // nil announces no health changes
 }
 
+// Health check log modes.
+const (
+	HealthLogEveryFailure = "every_failure"
+	HealthLogStateChange  = "state_change"
+)
+
 // UpstreamStatus reports health and configuration for an upstream.
 type UpstreamStatus struct {
 	URL             string `json:"url"`
@@ -34,6 +45,12 @@ type UpstreamStatus struct {
 	Healthy         bool   `json:"healthy"`
 	LastCheckedUnix int64  `json:"last_checked_unix"`
 	LastError       string `json:"last_error,omitempty"`
+	Address         string `json:"address,omitempty"` // The record dialed, for expand_dns upstreams
+
+	EjectedUntilUnix int64 `json:"ejected_until_unix,omitempty"` // Set while outlier detection has it out of rotation
+
+	EgressShaping map[string]interface{} `json:"egress_shaping,omitempty"`
+	DialRate      map[string]interface{} `json:"dial_rate,omitempty"`
 }
 
 type upstreamState struct {
@@ -43,6 +60,13 @@ type upstreamState struct {
 	healthy     bool
 	lastChecked time.Time
 	lastError   string
+	record      string // Resolved address of an expand_dns upstream; empty otherwise
+	outlier     outlierState
+
+	// Current outage, for state-change logging
+	failingSince time.Time
+	failedProbes int
+	lastReminder time.Time
 }
 
 // UpstreamPool manages upstream selection and health.
@@ -53,6 +77,8 @@ type UpstreamPool struct {
 	rrIndex             int
 	rng                 *rand.Rand
 	healthChecksEnabled bool
+	expand              []*expandTarget // expand_dns upstreams, in config order
+	outlier             OutlierConfig
 }
 
 // NewUpstreamPool builds a pool from config endpoints.
@@ -76,22 +102,45 @@ func NewUpstreamPool(endpoints []config.UpstreamEndpoint, strategy string) (*Ups
 		if err != nil {
 			return nil, err
 		}
+		if normalizedStrategy == upstreamStrategyRedundant && info.Remuxed() {
+			return nil, fmt.Errorf("upstream %s: redundant push needs rtmp or rtmps upstreams", endpoint.URL)
+		}
 		weight := endpoint.Weight
 		if weight <= 0 {
 			weight = 1
 		}
+		if endpoint.EgressShaping != nil {
+			info.Egress = middleware.NewShaper(endpoint.EgressShaping.RateBytesPerSec, endpoint.EgressShaping.BurstBytes)
+		}
+		if d := endpoint.DialRate; d != nil {
+			info.DialLimit = middleware.NewDialLimiter(d.PerSecond, d.Burst, d.MaxWait.AsDuration(), d.Jitter)
+		}
+		info.DSCP = endpoint.DSCP
+		if b := endpoint.Bind; b != nil {
+			if info.Bind, err = NewSourceBind(b.SourceIP, b.Interface); err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", endpoint.URL, err)
+			}
+		}
+		if info.Credentials, err = newCredentials(endpoint.Credentials); err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", endpoint.URL, err)
+		}
+		// An expanded upstream dials its hostname until the first lookup.
 		pool.endpoints = append(pool.endpoints, &upstreamState{
 			url:     endpoint.URL,
 			info:    info,
 			weight:  weight,
 			healthy: true,
 		})
+		if endpoint.ExpandDNS {
+			pool.expand = append(pool.expand, &expandTarget{url: endpoint.URL, info: info, weight: weight})
+		}
 	}
 
 	return pool, nil
 }
 
-// Pick selects an upstream based on strategy and health.
+// Pick selects an upstream based on strategy and health. A redundant pool
+// picks round robin for sessions that can only use one upstream.
 func (p *UpstreamPool) Pick() (UpstreamInfo, string, error) {
 	if p == nil {
 		return UpstreamInfo{}, "", errors.New("upstream pool is nil")
@@ -104,7 +153,19 @@ func (p *UpstreamPool) Pick() (UpstreamInfo, string, error) {
 		return UpstreamInfo{}, "", errors.New("no upstreams available")
 	}
 
-	candidates := p.healthyEndpointsLocked()
+	// Ejected upstreams are skipped unless nothing else is healthy, so
+	// outlier detection never takes the whole pool out.
+	healthy := p.healthyEndpointsLocked()
+	now := time.Now()
+	candidates := make([]*upstreamState, 0, len(healthy))
+	for _, endpoint := range healthy {
+		if p.admitLocked(endpoint, now) {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = healthy
+	}
 	if len(candidates) == 0 {
 		candidates = p.endpoints
 	}
@@ -117,6 +178,27 @@ func (p *UpstreamPool) Pick() (UpstreamInfo, string, error) {
 	}
 }
 
+// Redundant reports whether sessions push to every upstream in the pool at
+// once.
+func (p *UpstreamPool) Redundant() bool {
+	return p.Strategy() == upstreamStrategyRedundant
+}
+
+// Endpoints returns every upstream in the pool, healthy or not, in config
+// order. Each info's Raw is the URL the upstream was configured as.
+func (p *UpstreamPool) Endpoints() []UpstreamInfo {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]UpstreamInfo, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		out = append(out, p.originLocked(endpoint))
+	}
+	return out
+}
+
 // StartHealthChecks begins periodic health checks.
 func (p *UpstreamPool) StartHealthChecks(ctx context.Context, log *logger.Logger, cfg HealthCheckConfig) {
 	if p == nil || !cfg.Enabled {
@@ -133,14 +215,14 @@ func (p *UpstreamPool) StartHealthChecks(ctx context.Context, log *logger.Logger
 		ticker := time.NewTicker(cfg.Interval)
 		defer ticker.Stop()
 
-		p.checkAll(ctx, log, cfg.Timeout)
+		p.checkAll(ctx, log, cfg)
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				p.checkAll(ctx, log, cfg.Timeout)
+				p.checkAll(ctx, log, cfg)
 			}
 		}
 	}()
@@ -192,22 +274,40 @@ func (p *UpstreamPool) Stats() []UpstreamStatus {
 	defer p.mu.RUnlock()
 
 	stats := make([]UpstreamStatus, 0, len(p.endpoints))
+	now := time.Now()
 	for _, endpoint := range p.endpoints {
 		lastChecked := int64(0)
 		if !endpoint.lastChecked.IsZero() {
 			lastChecked = endpoint.lastChecked.Unix()
 		}
+		ejectedUntil := int64(0)
+		if until := endpoint.outlier.ejectedUntil; now.Before(until) {
+			ejectedUntil = until.Unix()
+		}
 		stats = append(stats, UpstreamStatus{
 			URL:             endpoint.url,
 			Weight:          endpoint.weight,
 			Healthy:         endpoint.healthy,
 			LastCheckedUnix: lastChecked,
 			LastError:       endpoint.lastError,
+			Address:         endpoint.record,
+			EgressShaping:   endpoint.info.Egress.Stats(),
+			DialRate:        endpoint.info.DialLimit.Stats(),
+
+			EjectedUntilUnix: ejectedUntil,
 		})
 	}
 	return stats
 }
 
+// originLocked returns e's info tagged with where it came from, so the
+// connect outcome can be reported back with ReportConnect.
+func (p *UpstreamPool) originLocked(e *upstreamState) UpstreamInfo {
+	info := e.info
+	info.pool, info.endpoint = p, e
+	return info
+}
+
 func (p *UpstreamPool) healthyEndpointsLocked() []*upstreamState {
 	candidates := make([]*upstreamState, 0, len(p.endpoints))
 	for _, endpoint := range p.endpoints {
@@ -232,7 +332,7 @@ func (p *UpstreamPool) pickRoundRobinLocked(candidates []*upstreamState) (Upstre
 
 	for _, endpoint := range candidates {
 		if pos < endpoint.weight {
-			return endpoint.info, endpoint.url, nil
+			return p.originLocked(endpoint), endpoint.url, nil
 		}
 		pos -= endpoint.weight
 	}
@@ -252,7 +352,7 @@ func (p *UpstreamPool) pickRandomLocked(candidates []*upstreamState) (UpstreamIn
 	pos := p.rng.Intn(totalWeight)
 	for _, endpoint := range candidates {
 		if pos < endpoint.weight {
-			return endpoint.info, endpoint.url, nil
+			return p.originLocked(endpoint), endpoint.url, nil
 		}
 		pos -= endpoint.weight
 	}
@@ -260,32 +360,105 @@ func (p *UpstreamPool) pickRandomLocked(candidates []*upstreamState) (UpstreamIn
 	return UpstreamInfo{}, "", errors.New("no upstream selected")
 }
 
-func (p *UpstreamPool) checkAll(ctx context.Context, log *logger.Logger, timeout time.Duration) {
+func (p *UpstreamPool) checkAll(ctx context.Context, log *logger.Logger, cfg HealthCheckConfig) {
 	p.mu.RLock()
 	endpoints := make([]*upstreamState, len(p.endpoints))
 	copy(endpoints, p.endpoints)
 	p.mu.RUnlock()
 
 	for _, endpoint := range endpoints {
-		healthy, err := probeUpstream(ctx, endpoint.info, timeout)
-		p.updateHealth(endpoint, healthy, err)
-		if log != nil && err != nil {
-			log.Warn("upstream health check failed", "upstream", endpoint.url, "err", err)
+		healthy, err := probeUpstream(ctx, endpoint.info, cfg.Timeout)
+		change := p.updateHealth(endpoint, healthy, err, time.Now(), cfg.Reminder)
+		if log != nil {
+			logHealth(log, cfg.LogMode, endpoint.url, change, err)
 		}
+		publishHealth(cfg.Events, endpoint.url, change, err)
 	}
 }
 
-func (p *UpstreamPool) updateHealth(endpoint *upstreamState, healthy bool, err error) {
+// healthChange is what one probe did to an upstream's health.
+type healthChange struct {
+	healthy      bool
+	failedProbes int           // Failed probes in the outage, including this one
+	failingFor   time.Duration // Length of the outage so far, or in total on recovery
+	remind       bool          // A reminder about a continuing outage is due
+}
+
+func (p *UpstreamPool) updateHealth(endpoint *upstreamState, healthy bool, err error, now time.Time, reminder time.Duration) healthChange {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	change := healthChange{healthy: healthy}
+	if healthy {
+		change.failedProbes = endpoint.failedProbes
+		if endpoint.failedProbes > 0 {
+			change.failingFor = now.Sub(endpoint.failingSince)
+		}
+		endpoint.failedProbes = 0
+	} else {
+		if endpoint.failedProbes == 0 {
+			endpoint.failingSince, endpoint.lastReminder = now, now
+		}
+		endpoint.failedProbes++
+		change.failedProbes = endpoint.failedProbes
+		change.failingFor = now.Sub(endpoint.failingSince)
+		if reminder <= 0 {
+			reminder = 10 * time.Minute
+		}
+		if now.Sub(endpoint.lastReminder) >= reminder {
+			change.remind = true
+			endpoint.lastReminder = now
+		}
+	}
+
 	endpoint.healthy = healthy
-	endpoint.lastChecked = time.Now()
+	endpoint.lastChecked = now
 	if err != nil {
 		endpoint.lastError = err.Error()
 	} else {
 		endpoint.lastError = ""
 	}
+	return change
+}
+
+// publishHealth announces a probe that took an upstream down or brought it
+// back.
+func publishHealth(bus *events.Bus, upstream string, change healthChange, err error) {
+	e := events.Event{Type: events.TypeUpstreamHealth, Upstream: upstream}
+	switch {
+	case change.healthy && change.failedProbes > 0:
+		e.State = "healthy"
+	case !change.healthy && change.failedProbes == 1:
+		e.State = "unhealthy"
+		if err != nil {
+			e.Error = err.Error()
+		}
+	default:
+		return
+	}
+	bus.Publish(e)
+}
+
+// logHealth reports a probe result. In HealthLogStateChange mode a long outage
+// costs two lines plus a reminder per interval rather than one per probe.
+func logHealth(log *logger.Logger, mode, upstream string, change healthChange, err error) {
+	if mode != HealthLogStateChange {
+		if err != nil {
+			log.Warn("upstream health check failed", "upstream", upstream, "err", err)
+		}
+		return
+	}
+	switch {
+	case change.healthy && change.failedProbes > 0:
+		log.Info("upstream recovered", "upstream", upstream,
+			"down_for", change.failingFor.Round(time.Second).String(), "failed_probes", change.failedProbes)
+	case change.healthy:
+	case change.failedProbes == 1:
+		log.Warn("upstream unhealthy", "upstream", upstream, "err", err)
+	case change.remind:
+		log.Warn("upstream still unhealthy", "upstream", upstream,
+			"down_for", change.failingFor.Round(time.Second).String(), "failed_probes", change.failedProbes, "err", err)
+	}
 }
 
 func normalizeUpstreamStrategy(strategy string) (string, error) {
@@ -294,7 +467,7 @@ func normalizeUpstreamStrategy(strategy string) (string, error) {
 		return upstreamStrategyRoundRobin, nil
 	}
 	switch strategy {
-	case upstreamStrategyRoundRobin, upstreamStrategyRandom:
+	case upstreamStrategyRoundRobin, upstreamStrategyRandom, upstreamStrategyRedundant:
 		return strategy, nil
 	default:
 		return "", fmt.Errorf("invalid upstream strategy %q", strategy)
@@ -315,12 +488,15 @@ func probeUpstream(ctx context.Context, info UpstreamInfo, timeout time.Duration
 	if timeout <= 0 {
 		timeout = 2 * time.Second
 	}
+	if info.Datagram() {
+		return true, nil
+	}
 	dialCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	if info.UseTLS {
 		dialer := tls.Dialer{
-			NetDialer: &net.Dialer{},
+			NetDialer: info.Bind.dialer(),
 			Config:    &tls.Config{ServerName: info.Host},
 		}
 		conn, err := dialer.DialContext(dialCtx, "tcp", info.Address)
@@ -333,8 +509,7 @@ func probeUpstream(ctx context.Context, info UpstreamInfo, timeout time.Duration
 		return true, nil
 	}
 
-	var dialer net.Dialer
-	conn, err := dialer.DialContext(dialCtx, "tcp", info.Address)
+	conn, err := info.Bind.dialer().DialContext(dialCtx, "tcp", info.Address)
 	if err != nil {
 		return false, err
 	}
@@ -1,9 +1,17 @@
 package relay
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/logger"
 )
 
 func TestUpstreamPoolRoundRobin(t *testing.T) {
@@ -37,3 +45,166 @@ func TestUpstreamPoolRoundRobin(t *testing.T) {
 		t.Fatalf("pick with unhealthy upstream = %q, err=%v", raw, err)
 	}
 }
+
+func TestUpstreamPoolEgressShaping(t *testing.T) {
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{
+		{URL: "rtmp://example.com/app/stream", EgressShaping: &config.EgressShapingConfig{RateBytesPerSec: 1000}},
+		{URL: "rtmp://example.net/app/stream"},
+	}, "round_robin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, _, _ := pool.Pick()
+	if info.Egress == nil {
+		t.Fatal("expected egress shaper on first upstream")
+	}
+	info, _, _ = pool.Pick()
+	if info.Egress != nil {
+		t.Fatal("expected no egress shaper on second upstream")
+	}
+
+	stats := pool.Stats()
+	if stats[0].EgressShaping["bytes_per_sec"] != 1000.0 {
+		t.Fatalf("unexpected shaping stats: %v", stats[0].EgressShaping)
+	}
+}
+
+func TestHealthLogStateChange(t *testing.T) {
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{{URL: "rtmp://example.com/app/stream"}}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	log := logger.NewWithWriter(&buf)
+	endpoint := pool.endpoints[0]
+	probeErr := errors.New("connection refused")
+	start := time.Now()
+
+	// An hour-long outage probed every 10s, with a reminder every 10 minutes.
+	for i := 0; i < 360; i++ {
+		change := pool.updateHealth(endpoint, false, probeErr, start.Add(time.Duration(i)*10*time.Second), 10*time.Minute)
+		logHealth(log, HealthLogStateChange, endpoint.url, change, probeErr)
+	}
+	change := pool.updateHealth(endpoint, true, nil, start.Add(time.Hour), 10*time.Minute)
+	logHealth(log, HealthLogStateChange, endpoint.url, change, nil)
+
+	out := buf.String()
+	if n := strings.Count(out, `"upstream unhealthy"`); n != 1 {
+		t.Fatalf("logged the outage start %d times:\n%s", n, out)
+	}
+	if n := strings.Count(out, `"upstream still unhealthy"`); n != 5 {
+		t.Fatalf("logged %d reminders, want 5:\n%s", n, out)
+	}
+	if !strings.Contains(out, `"down_for":"50m0s","failed_probes":301`) {
+		t.Fatalf("last reminder lacks the outage summary:\n%s", out)
+	}
+	if !strings.Contains(out, `"upstream recovered"`) || !strings.Contains(out, `"down_for":"1h0m0s","failed_probes":360`) {
+		t.Fatalf("recovery not summarized:\n%s", out)
+	}
+
+	// The default mode keeps warning on every failed probe.
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		logHealth(log, "", endpoint.url, pool.updateHealth(endpoint, false, probeErr, time.Now(), 0), probeErr)
+	}
+	if n := strings.Count(buf.String(), `"upstream health check failed"`); n != 3 {
+		t.Fatalf("every_failure mode logged %d warnings, want 3", n)
+	}
+}
+
+func TestPublishHealthOnTransitions(t *testing.T) {
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{{URL: "rtmp://example.com/app/stream"}}, "round_robin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bus := events.New()
+	sub := bus.Subscribe(8)
+	defer sub.Close()
+
+	endpoint := pool.endpoints[0]
+	now := time.Now()
+	probeErr := errors.New("connection refused")
+	for i, healthy := range []bool{true, false, false, true, true} {
+		var err error
+		if !healthy {
+			err = probeErr
+		}
+		change := pool.updateHealth(endpoint, healthy, err, now.Add(time.Duration(i)*time.Second), 0)
+		publishHealth(bus, endpoint.url, change, err)
+	}
+
+	var got []string
+	for len(sub.C) > 0 {
+		e := <-sub.C
+		got = append(got, e.State)
+		if e.Type != events.TypeUpstreamHealth || e.Upstream != "rtmp://example.com/app/stream" {
+			t.Fatalf("event = %+v", e)
+		}
+	}
+	if strings.Join(got, ",") != "unhealthy,healthy" {
+		t.Fatalf("health events = %v, want one per transition", got)
+	}
+}
+
+func TestUpstreamPoolExpandsDNSRecords(t *testing.T) {
+	pool, err := NewUpstreamPool([]config.UpstreamEndpoint{
+		{URL: "rtmp://static.example.com/app"},
+		{URL: "rtmps://ingest.svc.cluster.local/app", Weight: 2, ExpandDNS: true},
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records := []string{"10.0.0.2", "10.0.0.1"}
+	var lookupErr error
+	cfg := DNSRefreshConfig{Interval: time.Second, Lookup: func(_ context.Context, host string) ([]string, error) {
+		if host != "ingest.svc.cluster.local" {
+			t.Errorf("looked up %q", host)
+		}
+		return records, lookupErr
+	}}
+	var buf bytes.Buffer
+	log := logger.NewWithWriter(&buf)
+
+	addresses := func() []string {
+		var out []string
+		for _, s := range pool.Stats() {
+			out = append(out, s.URL+" "+s.Address)
+		}
+		return out
+	}
+	if got := addresses(); len(got) != 2 {
+		t.Fatalf("before the first lookup = %v", got)
+	}
+
+	pool.refreshDNS(context.Background(), log, cfg)
+	want := "[rtmp://static.example.com/app  rtmps://ingest.svc.cluster.local/app 10.0.0.1 rtmps://ingest.svc.cluster.local/app 10.0.0.2]"
+	if got := fmt.Sprint(addresses()); got != want {
+		t.Fatalf("after lookup = %s, want %s", got, want)
+	}
+	info := pool.endpoints[1].info
+	if info.Address != "10.0.0.1:1935" || info.Host != "ingest.svc.cluster.local" || !info.UseTLS || pool.endpoints[1].weight != 2 {
+		t.Fatalf("expanded endpoint = %+v weight %d", info, pool.endpoints[1].weight)
+	}
+
+	// A record that stays keeps its health; a new one starts healthy.
+	kept := pool.endpoints[2]
+	pool.updateHealth(kept, false, errors.New("refused"), time.Now(), 0)
+	records = []string{"10.0.0.3", "10.0.0.2"}
+	pool.refreshDNS(context.Background(), log, cfg)
+	if pool.Size() != 3 || pool.endpoints[1] != kept || kept.healthy || pool.endpoints[2].record != "10.0.0.3" || !pool.endpoints[2].healthy {
+		t.Fatalf("after change = %v", addresses())
+	}
+	if !strings.Contains(buf.String(), `"added":["10.0.0.3"],"removed":["10.0.0.1"]`) {
+		t.Fatalf("change not logged:\n%s", buf.String())
+	}
+
+	// Failed and empty lookups keep the last good records.
+	lookupErr = errors.New("SERVFAIL")
+	pool.refreshDNS(context.Background(), log, cfg)
+	lookupErr, records = nil, nil
+	pool.refreshDNS(context.Background(), log, cfg)
+	if pool.Size() != 3 || strings.Count(buf.String(), "keeping previous records") != 2 {
+		t.Fatalf("records after failed lookups = %v\n%s", addresses(), buf.String())
+	}
+}
@@ -0,0 +1,286 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+// Viewer protocols, as reported in /status and metric labels.
+const (
+	ViewerRTMP    = "rtmp"     // Players sending play through the relay
+	ViewerHTTPFLV = "http_flv" // DVR playback
+	ViewerHLS     = "hls"      // Packaged HLS and DASH
+)
+
+// DefaultSegmentIdle is how long an HLS or DASH player counts as a viewer
+// after its last request when viewers.segment_idle is unset.
+const DefaultSegmentIdle = 30 * time.Second
+
+// ErrViewerLimit is returned, wrapped with the stream and cap, for a player
+// turned away because its stream has viewers.max_per_stream viewers.
+var ErrViewerLimit = errors.New("stream viewer limit reached")
+
+// Viewers counts the players of each stream across protocols and holds each
+// stream to a cap. RTMP and HTTP-FLV players hold a connection and count
+// until it ends. HLS and DASH players make a request per segment instead, so
+// each client address and token counts until it stops asking for a while.
+// A nil Viewers counts nothing and admits everyone.
+type Viewers struct {
+	max     int
+	idle    time.Duration
+	metrics *metrics.Registry
+	now     func() time.Time
+
+	mu      sync.Mutex
+	streams map[string]*streamViewers
+}
+
+type streamViewers struct {
+	conns    map[string]int       // Protocol -> connected players
+	segments map[string]time.Time // HLS/DASH player -> last request
+}
+
+func (sv *streamViewers) total() int {
+	n := len(sv.segments)
+	for _, c := range sv.conns {
+		n += c
+	}
+	return n
+}
+
+// StreamViewers is the viewer count of one stream.
+type StreamViewers struct {
+	Stream     string         `json:"stream"`
+	Total      int            `json:"total"`
+	ByProtocol map[string]int `json:"by_protocol"`
+}
+
+// NewViewers returns a viewer count using cfg's cap, reporting to m.
+func NewViewers(cfg config.ViewersConfig, m *metrics.Registry) *Viewers {
+	v := &Viewers{
+		max:     cfg.MaxPerStream,
+		idle:    cfg.SegmentIdle.AsDuration(),
+		metrics: m,
+		now:     time.Now,
+		streams: make(map[string]*streamViewers),
+	}
+	if v.idle <= 0 {
+		v.idle = DefaultSegmentIdle
+	}
+	return v
+}
+
+// Join admits a player of stream holding a connection over protocol, and
+// returns the func to call when it leaves. It fails with ErrViewerLimit when
+// the stream is full.
+func (v *Viewers) Join(stream, protocol string) (leave func(), err error) {
+	if v == nil {
+		return func() {}, nil
+	}
+	stream = stripStreamQuery(stream)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sv := v.streamLocked(stream)
+	if v.max > 0 && sv.total() >= v.max {
+		err := v.rejectLocked(stream, protocol)
+		v.dropIfEmptyLocked(stream, sv)
+		return nil, err
+	}
+	sv.conns[protocol]++
+	v.metrics.SetStreamViewers(stream, protocol, sv.conns[protocol])
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			sv.conns[protocol]--
+			v.metrics.SetStreamViewers(stream, protocol, sv.conns[protocol])
+			v.dropIfEmptyLocked(stream, sv)
+		})
+	}, nil
+}
+
+// Touch admits an HLS or DASH request for stream from the player key, or
+// refreshes the player's place if it already has one. A new player fails
+// with ErrViewerLimit when the stream is full.
+func (v *Viewers) Touch(stream, key string) error {
+	if v == nil {
+		return nil
+	}
+	stream = stripStreamQuery(stream)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sv := v.streamLocked(stream)
+	if _, ok := sv.segments[key]; !ok && v.max > 0 && sv.total() >= v.max {
+		err := v.rejectLocked(stream, ViewerHLS)
+		v.dropIfEmptyLocked(stream, sv)
+		return err
+	}
+	sv.segments[key] = v.now()
+	v.metrics.SetStreamViewers(stream, ViewerHLS, len(sv.segments))
+	return nil
+}
+
+// Count returns the number of viewers of stream.
+func (v *Viewers) Count(stream string) int {
+	if v == nil {
+		return 0
+	}
+	stream = stripStreamQuery(stream)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sv, ok := v.streams[stream]
+	if !ok {
+		return 0
+	}
+	v.pruneLocked(stream, sv)
+	return sv.total()
+}
+
+// Total returns the number of viewers of every stream.
+func (v *Viewers) Total() int {
+	n := 0
+	for _, st := range v.Stats() {
+		n += st.Total
+	}
+	return n
+}
+
+// Stats returns the viewers of each watched stream, sorted by name.
+func (v *Viewers) Stats() []StreamViewers {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make([]StreamViewers, 0, len(v.streams))
+	for stream, sv := range v.streams {
+		if !v.pruneLocked(stream, sv) {
+			continue
+		}
+		st := StreamViewers{Stream: stream, Total: sv.total(), ByProtocol: make(map[string]int)}
+		for protocol, n := range sv.conns {
+			if n > 0 {
+				st.ByProtocol[protocol] = n
+			}
+		}
+		if len(sv.segments) > 0 {
+			st.ByProtocol[ViewerHLS] = len(sv.segments)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Stream < out[j].Stream })
+	return out
+}
+
+// Run drops HLS and DASH players that stopped asking, so the gauges fall
+// even when no one looks at the counts, until ctx ends.
+func (v *Viewers) Run(ctx context.Context) {
+	if v == nil {
+		return
+	}
+	ticker := time.NewTicker(v.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.mu.Lock()
+			for stream, sv := range v.streams {
+				v.pruneLocked(stream, sv)
+			}
+			v.mu.Unlock()
+		}
+	}
+}
+
+// streamLocked returns the viewers of stream, less its idle HLS and DASH
+// players, adding the stream if it has none.
+func (v *Viewers) streamLocked(stream string) *streamViewers {
+	sv, ok := v.streams[stream]
+	if !ok || !v.pruneLocked(stream, sv) {
+		sv = &streamViewers{conns: make(map[string]int), segments: make(map[string]time.Time)}
+		v.streams[stream] = sv
+	}
+	return sv
+}
+
+// pruneLocked drops the idle HLS and DASH players of stream, and the stream
+// itself once it has no viewers. It reports whether the stream is kept.
+func (v *Viewers) pruneLocked(stream string, sv *streamViewers) bool {
+	before := len(sv.segments)
+	oldest := v.now().Add(-v.idle)
+	for key, last := range sv.segments {
+		if last.Before(oldest) {
+			delete(sv.segments, key)
+		}
+	}
+	if len(sv.segments) != before {
+		v.metrics.SetStreamViewers(stream, ViewerHLS, len(sv.segments))
+	}
+	return !v.dropIfEmptyLocked(stream, sv)
+}
+
+func (v *Viewers) dropIfEmptyLocked(stream string, sv *streamViewers) bool {
+	if sv.total() > 0 {
+		return false
+	}
+	if v.streams[stream] == sv {
+		delete(v.streams, stream)
+	}
+	return true
+}
+
+func (v *Viewers) rejectLocked(stream, protocol string) error {
+	v.metrics.RecordViewerLimitRejection(protocol)
+	return fmt.Errorf("%w: %q already has %d viewers", ErrViewerLimit, stream, v.max)
+}
+
+// viewerClaim is a session's place among the viewers of the stream it plays
+// through the relay. The zero claim holds nothing.
+type viewerClaim struct {
+	mu      sync.Mutex // The client reader plays while the session may be releasing
+	viewers *Viewers
+	stream  string // Played stream, without its query; empty when none
+	leave   func()
+}
+
+// play admits the session as an RTMP viewer of stream, first leaving the
+// stream it played before, if any.
+func (c *viewerClaim) play(stream string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stream = stripStreamQuery(stream)
+	if stream == c.stream {
+		return nil
+	}
+	c.releaseLocked()
+	leave, err := c.viewers.Join(stream, ViewerRTMP)
+	if err != nil {
+		return err
+	}
+	c.stream, c.leave = stream, leave
+	return nil
+}
+
+func (c *viewerClaim) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+}
+
+func (c *viewerClaim) releaseLocked() {
+	if c.leave != nil {
+		c.leave()
+	}
+	c.stream, c.leave = "", nil
+}
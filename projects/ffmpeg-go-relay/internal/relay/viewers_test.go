@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestViewersCap(t *testing.T) {
+	v := NewViewers(config.ViewersConfig{MaxPerStream: 3}, nil)
+	now := time.Unix(1000, 0)
+	v.now = func() time.Time { return now }
+
+	leaveRTMP, err := v.Join("cam?token=x", ViewerRTMP)
+	if err != nil {
+		t.Fatalf("Join rtmp: %v", err)
+	}
+	leaveFLV, err := v.Join("cam", ViewerHTTPFLV)
+	if err != nil {
+		t.Fatalf("Join http_flv: %v", err)
+	}
+	if err := v.Touch("cam", "10.0.0.1 a"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	// A segment player already counted keeps its place at the cap.
+	if err := v.Touch("cam", "10.0.0.1 a"); err != nil {
+		t.Fatalf("Touch again: %v", err)
+	}
+	if err := v.Touch("cam", "10.0.0.2 a"); !errors.Is(err, ErrViewerLimit) {
+		t.Fatalf("fourth viewer: %v, want ErrViewerLimit", err)
+	}
+	if _, err := v.Join("cam", ViewerRTMP); !errors.Is(err, ErrViewerLimit) {
+		t.Fatalf("fourth rtmp viewer: %v, want ErrViewerLimit", err)
+	}
+	// Other streams have caps of their own.
+	if _, err := v.Join("other", ViewerRTMP); err != nil {
+		t.Fatalf("Join other: %v", err)
+	}
+
+	stats := v.Stats()
+	if len(stats) != 2 || stats[0].Stream != "cam" || stats[0].Total != 3 ||
+		stats[0].ByProtocol[ViewerRTMP] != 1 || stats[0].ByProtocol[ViewerHTTPFLV] != 1 || stats[0].ByProtocol[ViewerHLS] != 1 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+
+	leaveFLV()
+	leaveFLV()
+	if n := v.Count("cam"); n != 2 {
+		t.Fatalf("Count after a player left = %d, want 2", n)
+	}
+	// Segment players that stop asking stop counting.
+	now = now.Add(DefaultSegmentIdle + time.Second)
+	if n := v.Count("cam"); n != 1 {
+		t.Fatalf("Count after the segment player went idle = %d, want 1", n)
+	}
+	leaveRTMP()
+	if n := v.Total(); n != 1 {
+		t.Fatalf("Total = %d, want 1", n)
+	}
+}
+
+func TestViewerClaimSwitchesStreams(t *testing.T) {
+	v := NewViewers(config.ViewersConfig{MaxPerStream: 1}, nil)
+	first := &viewerClaim{viewers: v}
+	if err := first.play("cam1?token=a"); err != nil {
+		t.Fatalf("play cam1: %v", err)
+	}
+	if err := first.play("cam1?token=b"); err != nil {
+		t.Fatalf("play cam1 again: %v", err)
+	}
+	second := &viewerClaim{viewers: v}
+	if err := second.play("cam1"); !errors.Is(err, ErrViewerLimit) {
+		t.Fatalf("second viewer of cam1: %v, want ErrViewerLimit", err)
+	}
+	// Playing another stream gives up the first.
+	if err := first.play("cam2"); err != nil {
+		t.Fatalf("play cam2: %v", err)
+	}
+	if err := second.play("cam1"); err != nil {
+		t.Fatalf("play cam1 after the first left: %v", err)
+	}
+	first.release()
+	second.release()
+	second.release()
+	if n := v.Total(); n != 0 {
+		t.Fatalf("Total after release = %d, want 0", n)
+	}
+}
+
+func TestPlayedStream(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		want    string
+	}{
+		{"play", "cam1?token=a"},
+		{"publish", ""},
+	} {
+		payload, err := encodeCommand(rtmp.TypeAMF0Command, []interface{}{tc.command, 4.0, nil, "cam1?token=a", -2000.0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := playedStream(&rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAMF0Command}, Payload: payload})
+		if got != tc.want {
+			t.Errorf("playedStream(%s) = %q, want %q", tc.command, got, tc.want)
+		}
+	}
+}
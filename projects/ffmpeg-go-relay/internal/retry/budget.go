@@ -0,0 +1,112 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned when a retry was refused because the budget
+// had no retries left.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// DefaultBudgetWindow is the period a Budget counts over when none is given.
+const DefaultBudgetWindow = 10 * time.Second
+
+// budgetBuckets is how many slices the window is split into, so old requests
+// age out gradually instead of all at once.
+const budgetBuckets = 10
+
+type budgetBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// Budget caps retries across every caller sharing it: within the window,
+// retries may not exceed minRetries plus ratio times the requests made.
+// When an upstream is down every request fails, the budget runs out after
+// a few retries and further failures are returned at once instead of
+// multiplying the load. A nil Budget allows every retry.
+type Budget struct {
+	ratio      float64
+	minRetries int
+	bucket     time.Duration
+
+	mu      sync.Mutex
+	buckets [budgetBuckets]budgetBucket
+	now     func() time.Time
+}
+
+// NewBudget returns a budget allowing ratio retries per request, plus
+// minRetries, within each window. It returns nil when ratio is not positive.
+func NewBudget(ratio float64, minRetries int, window time.Duration) *Budget {
+	if ratio <= 0 {
+		return nil
+	}
+	if window <= 0 {
+		window = DefaultBudgetWindow
+	}
+	if minRetries < 0 {
+		minRetries = 0
+	}
+	bucket := window / budgetBuckets
+	if bucket <= 0 {
+		bucket = time.Nanosecond
+	}
+	return &Budget{
+		ratio:      ratio,
+		minRetries: minRetries,
+		bucket:     bucket,
+		now:        time.Now,
+	}
+}
+
+// Request records a first attempt, which earns the budget ratio retries.
+func (b *Budget) Request() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current().requests++
+}
+
+// Withdraw records a retry and reports whether the budget allowed it.
+func (b *Budget) Withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := b.current()
+	requests, retries := b.totals()
+	if float64(retries) >= float64(b.minRetries)+b.ratio*float64(requests) {
+		return false
+	}
+	cur.retries++
+	return true
+}
+
+// current returns the bucket for now, clearing it if it last held an
+// earlier slice of time.
+func (b *Budget) current() *budgetBucket {
+	start := b.now().Truncate(b.bucket)
+	cur := &b.buckets[(start.UnixNano()/int64(b.bucket))%budgetBuckets]
+	if !cur.start.Equal(start) {
+		*cur = budgetBucket{start: start}
+	}
+	return cur
+}
+
+func (b *Budget) totals() (requests, retries int) {
+	oldest := b.now().Truncate(b.bucket).Add(-b.bucket * (budgetBuckets - 1))
+	for _, bk := range b.buckets {
+		if bk.start.Before(oldest) {
+			continue
+		}
+		requests += bk.requests
+		retries += bk.retries
+	}
+	return requests, retries
+}
@@ -0,0 +1,45 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetWindowSlides(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBudget(0.1, 0, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		b.Request()
+	}
+	if !b.Withdraw() || !b.Withdraw() {
+		t.Fatal("20 requests at 10% should allow 2 retries")
+	}
+	if b.Withdraw() {
+		t.Fatal("a third retry was allowed")
+	}
+
+	// Once the requests age out of the window, so does the allowance.
+	now = now.Add(11 * time.Second)
+	if b.Withdraw() {
+		t.Fatal("retry allowed with no requests in the window")
+	}
+	for i := 0; i < 10; i++ {
+		b.Request()
+	}
+	if !b.Withdraw() {
+		t.Fatal("retries made outside the window still counted")
+	}
+}
+
+func TestNilBudget(t *testing.T) {
+	if b := NewBudget(0, 5, time.Second); b != nil {
+		t.Fatal("NewBudget with no ratio returned a budget")
+	}
+	var b *Budget
+	b.Request()
+	if !b.Withdraw() {
+		t.Fatal("nil Budget refused a retry")
+	}
+}
@@ -2,8 +2,12 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
+	"syscall"
 	"time"
 )
 
@@ -13,6 +17,123 @@ type Config struct {
 	InitialDelay time.Duration
 	MaxDelay     time.Duration
 	Multiplier   float64
+	Retryable    func(error) bool // nil retries every error; see IsRetryable
+	Budget       *Budget          // nil never refuses a retry
+
+	// PerAttemptTimeout bounds each attempt through the context passed to
+	// it; Deadline bounds all attempts and the waits between them. Zero
+	// leaves either unbounded.
+	PerAttemptTimeout time.Duration
+	Deadline          time.Duration
+
+	// OnRetry, if set, is called after a failed attempt that will be
+	// retried, with its 1-based number, its error and the wait before the
+	// next attempt.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// ErrDeadlineExceeded is returned when Config.Deadline passed before an
+// attempt succeeded.
+var ErrDeadlineExceeded = errors.New("retry deadline exceeded")
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so IsRetryable reports false for it, e.g. for a
+// rejected credential or a protocol violation.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsRetryable reports whether err looks transient: a refused, reset or
+// aborted connection, an unreachable host, a timeout, a temporary DNS
+// failure or a connection closed mid-exchange. Everything else, including
+// errors wrapped with Permanent, TLS verification failures and unknown
+// hosts, is not worth retrying.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var perm permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.ETIMEDOUT),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// start applies the overall deadline to ctx and records the first attempt
+// against the budget.
+func (cfg Config) start(ctx context.Context) (context.Context, context.CancelFunc) {
+	cfg.Budget.Request()
+	if cfg.Deadline <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, cfg.Deadline, ErrDeadlineExceeded)
+}
+
+// attempt runs fn once, bounded by PerAttemptTimeout.
+func (cfg Config) attempt(ctx context.Context, fn func(context.Context) error) error {
+	if cfg.PerAttemptTimeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.PerAttemptTimeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// cancelled returns the error for a loop ended by ctx after attempts tries.
+func (cfg Config) cancelled(ctx context.Context, attempts int, lastErr error) error {
+	if !errors.Is(context.Cause(ctx), ErrDeadlineExceeded) {
+		return fmt.Errorf("retry cancelled: %w", ctx.Err())
+	}
+	if lastErr == nil {
+		return fmt.Errorf("%w (%s)", ErrDeadlineExceeded, cfg.Deadline)
+	}
+	return fmt.Errorf("%w (%s) after %d attempts: %w", ErrDeadlineExceeded, cfg.Deadline, attempts, lastErr)
+}
+
+func (cfg Config) retrying(attempt int, err error, delay time.Duration) {
+	if cfg.OnRetry != nil {
+		cfg.OnRetry(attempt+1, err, delay)
+	}
+}
+
+// stop returns the error to give up with after a failed attempt, or nil to
+// retry.
+func (cfg Config) stop(err error, attempt int) error {
+	if cfg.Retryable != nil && !cfg.Retryable(err) {
+		return err
+	}
+	if !cfg.Budget.Withdraw() {
+		return fmt.Errorf("%w after %d attempts: %w", ErrBudgetExhausted, attempt+1, err)
+	}
+	return nil
 }
 
 // DefaultConfig returns a sensible default configuration
@@ -27,6 +148,12 @@ func DefaultConfig() Config {
 
 // Do retries a function with exponential backoff
 func Do(ctx context.Context, cfg Config, fn func() error) error {
+	return DoContext(ctx, cfg, func(context.Context) error { return fn() })
+}
+
+// DoContext is Do for functions that take the attempt's context, which is
+// cancelled after Config.PerAttemptTimeout.
+func DoContext(ctx context.Context, cfg Config, fn func(context.Context) error) error {
 	if cfg.MaxAttempts <= 0 {
 		cfg.MaxAttempts = 3
 	}
@@ -42,17 +169,19 @@ func Do(ctx context.Context, cfg Config, fn func() error) error {
 
 	var lastErr error
 	delay := cfg.InitialDelay
+	ctx, cancel := cfg.start(ctx)
+	defer cancel()
 
 	for attempt := 0; attempt < cfg.MaxAttempts; attempt++ {
 		// Check context before attempting
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+			return cfg.cancelled(ctx, attempt, lastErr)
 		default:
 		}
 
 		// Try the function
-		err := fn()
+		err := cfg.attempt(ctx, fn)
 		if err == nil {
 			return nil
 		}
@@ -63,13 +192,17 @@ func Do(ctx context.Context, cfg Config, fn func() error) error {
 		if attempt == cfg.MaxAttempts-1 {
 			break
 		}
+		if stopErr := cfg.stop(err, attempt); stopErr != nil {
+			return stopErr
+		}
 
 		// Wait before retry
+		cfg.retrying(attempt, err, delay)
 		select {
 		case <-time.After(delay):
 			// Continue
 		case <-ctx.Done():
-			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+			return cfg.cancelled(ctx, attempt+1, lastErr)
 		}
 
 		// Calculate next delay with exponential backoff
@@ -85,21 +218,29 @@ func Do(ctx context.Context, cfg Config, fn func() error) error {
 
 // DoWithJitter retries with exponential backoff and jitter
 func DoWithJitter(ctx context.Context, cfg Config, jitterFraction float64, fn func() error) error {
+	return DoWithJitterContext(ctx, cfg, jitterFraction, func(context.Context) error { return fn() })
+}
+
+// DoWithJitterContext is DoWithJitter for functions that take the attempt's
+// context.
+func DoWithJitterContext(ctx context.Context, cfg Config, jitterFraction float64, fn func(context.Context) error) error {
 	if jitterFraction < 0 || jitterFraction > 1 {
 		jitterFraction = 0.1
 	}
 
 	var lastErr error
 	delay := cfg.InitialDelay
+	ctx, cancel := cfg.start(ctx)
+	defer cancel()
 
 	for attempt := 0; attempt < cfg.MaxAttempts; attempt++ {
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+			return cfg.cancelled(ctx, attempt, lastErr)
 		default:
 		}
 
-		err := fn()
+		err := cfg.attempt(ctx, fn)
 		if err == nil {
 			return nil
 		}
@@ -109,6 +250,9 @@ func DoWithJitter(ctx context.Context, cfg Config, jitterFraction float64, fn fu
 		if attempt == cfg.MaxAttempts-1 {
 			break
 		}
+		if stopErr := cfg.stop(err, attempt); stopErr != nil {
+			return stopErr
+		}
 
 		// Add jitter
 		jitter := time.Duration(float64(delay) * jitterFraction)
@@ -118,10 +262,11 @@ func DoWithJitter(ctx context.Context, cfg Config, jitterFraction float64, fn fu
 			actualDelay = delay
 		}
 
+		cfg.retrying(attempt, err, actualDelay)
 		select {
 		case <-time.After(actualDelay):
 		case <-ctx.Done():
-			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+			return cfg.cancelled(ctx, attempt+1, lastErr)
 		}
 
 		nextDelay := time.Duration(float64(delay) * cfg.Multiplier)
@@ -2,7 +2,12 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -268,3 +273,129 @@ func TestRetryJitterPreventsNegativeDelay(t *testing.T) {
 		t.Errorf("expected some delay even with extreme jitter, got %v", elapsed)
 	}
 }
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"reset", fmt.Errorf("handshake: %w", syscall.ECONNRESET), true},
+		{"timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, true},
+		{"closed mid-exchange", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"temporary dns", &net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{"unknown host", &net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{"auth", Permanent(errors.New("upstream rejected the stream key")), false},
+		{"permanent wrapping a transient error", Permanent(syscall.ECONNRESET), false},
+		{"cancelled", context.Canceled, false},
+		{"unknown", errors.New("bad certificate"), false},
+		{"nil", nil, false},
+	}
+	for _, tc := range cases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("%s: IsRetryable(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryStopsOnPermanentError(t *testing.T) {
+	cfg := Config{MaxAttempts: 5, InitialDelay: time.Millisecond, Retryable: IsRetryable}
+	authErr := Permanent(errors.New("unauthorized"))
+	for _, do := range []func(context.Context, Config, func() error) error{
+		Do,
+		func(ctx context.Context, cfg Config, fn func() error) error { return DoWithJitter(ctx, cfg, 0.1, fn) },
+	} {
+		attempts := 0
+		err := do(context.Background(), cfg, func() error {
+			attempts++
+			return authErr
+		})
+		if attempts != 1 || !errors.Is(err, authErr) {
+			t.Fatalf("attempts = %d, err = %v; want one attempt returning the permanent error", attempts, err)
+		}
+	}
+}
+
+func TestRetryBudgetLimitsRetries(t *testing.T) {
+	budget := NewBudget(0.5, 1, time.Minute)
+	cfg := Config{MaxAttempts: 3, InitialDelay: time.Millisecond, Retryable: IsRetryable, Budget: budget}
+
+	attempts := 0
+	failing := func() error {
+		attempts++
+		return syscall.ECONNREFUSED
+	}
+	// The first request may use the one free retry and the half it earned.
+	Do(context.Background(), cfg, failing)
+	if attempts != 3 {
+		t.Fatalf("first request made %d attempts, want 3", attempts)
+	}
+	attempts = 0
+	err := Do(context.Background(), cfg, failing)
+	if attempts != 1 || !errors.Is(err, ErrBudgetExhausted) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("attempts = %d, err = %v; want one attempt refused by the budget", attempts, err)
+	}
+}
+
+func TestRetryPerAttemptTimeout(t *testing.T) {
+	cfg := Config{MaxAttempts: 3, InitialDelay: time.Millisecond, PerAttemptTimeout: 20 * time.Millisecond}
+
+	attempts := 0
+	start := time.Now()
+	err := DoContext(context.Background(), cfg, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if attempts != 3 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("attempts = %d, err = %v; want 3 timed-out attempts", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v, want each attempt cut off after 20ms", elapsed)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	cfg := Config{MaxAttempts: 10, InitialDelay: 40 * time.Millisecond, MaxDelay: 40 * time.Millisecond, Multiplier: 1, Deadline: 100 * time.Millisecond}
+	refused := errors.New("connection refused")
+
+	for _, do := range []func(context.Context, Config, func(context.Context) error) error{
+		DoContext,
+		func(ctx context.Context, cfg Config, fn func(context.Context) error) error {
+			return DoWithJitterContext(ctx, cfg, 0.1, fn)
+		},
+	} {
+		attempts := 0
+		err := do(context.Background(), cfg, func(context.Context) error {
+			attempts++
+			return refused
+		})
+		if !errors.Is(err, ErrDeadlineExceeded) || !errors.Is(err, refused) {
+			t.Fatalf("err = %v, want the deadline error wrapping the last attempt's", err)
+		}
+		if attempts < 2 || attempts > 4 {
+			t.Fatalf("made %d attempts in 100ms with 40ms waits", attempts)
+		}
+	}
+}
+
+func TestRetryOnRetry(t *testing.T) {
+	var calls []int
+	cfg := Config{
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     10 * time.Millisecond,
+		Multiplier:   2,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			if err == nil || delay <= 0 {
+				t.Errorf("OnRetry(%d, %v, %v): want the error and a wait", attempt, err, delay)
+			}
+			calls = append(calls, attempt)
+		},
+	}
+	Do(context.Background(), cfg, func() error { return errors.New("down") })
+	if len(calls) != 2 || calls[0] != 1 || calls[1] != 2 {
+		t.Fatalf("OnRetry called for attempts %v, want [1 2]", calls)
+	}
+}
@@ -0,0 +1,100 @@
+// Package rewrite maps inbound RTMP app and stream names to the names used
+// upstream, enabling vanity stream keys and per-tenant app mapping.
+package rewrite
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Fields a rule can apply to.
+const (
+	FieldApp    = "app"
+	FieldStream = "stream"
+)
+
+// Rule rewrites one field. Match is a regular expression that must match the
+// whole value; Replace is an expansion template that may reference capture
+// groups ($1, ${name}).
+type Rule struct {
+	Field   string
+	Match   string
+	Replace string
+}
+
+type compiledRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// Rewriter applies the first matching rule per field.
+type Rewriter struct {
+	app    []compiledRule
+	stream []compiledRule
+}
+
+// New compiles rules in order. Returns nil when rules is empty.
+func New(rules []Rule) (*Rewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Rewriter{}
+	for i, rule := range rules {
+		re, err := regexp.Compile("^(?:" + rule.Match + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rewrite rule %d: %w", i, err)
+		}
+		c := compiledRule{re: re, replace: rule.Replace}
+		switch rule.Field {
+		case FieldApp:
+			r.app = append(r.app, c)
+		case FieldStream:
+			r.stream = append(r.stream, c)
+		default:
+			return nil, fmt.Errorf("rewrite rule %d: unknown field %q", i, rule.Field)
+		}
+	}
+	return r, nil
+}
+
+// App returns the upstream app name for app and whether a rule matched.
+func (r *Rewriter) App(app string) (string, bool) {
+	if r == nil {
+		return app, false
+	}
+	return apply(r.app, app)
+}
+
+// Stream returns the upstream stream name for name and whether a rule matched.
+func (r *Rewriter) Stream(name string) (string, bool) {
+	if r == nil {
+		return name, false
+	}
+	return apply(r.stream, name)
+}
+
+func apply(rules []compiledRule, value string) (string, bool) {
+	for _, rule := range rules {
+		m := rule.re.FindStringSubmatchIndex(value)
+		if m == nil {
+			continue
+		}
+		return string(rule.re.ExpandString(nil, rule.replace, value, m)), true
+	}
+	return value, false
+}
+
+// TcURL replaces the app portion of a tcUrl ("rtmp://host/app") with app,
+// keeping the scheme, host and any query string. Unparseable values are
+// returned unchanged.
+func TcURL(tcURL, app string) string {
+	u, err := url.Parse(tcURL)
+	if err != nil || u.Host == "" {
+		return tcURL
+	}
+	u.Path = "/" + strings.TrimPrefix(app, "/")
+	u.RawPath = ""
+	return u.String()
+}
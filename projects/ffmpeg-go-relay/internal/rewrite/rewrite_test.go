@@ -0,0 +1,62 @@
+package rewrite
+
+import "testing"
+
+func TestRewriterFirstMatchWins(t *testing.T) {
+	r, err := New([]Rule{
+		{Field: FieldStream, Match: `vanity-(\w+)`, Replace: "sk_live_$1"},
+		{Field: FieldStream, Match: `.*`, Replace: "fallback"},
+		{Field: FieldApp, Match: `(?P<tenant>[a-z]+)/live`, Replace: "ingest-${tenant}"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if got, ok := r.Stream("vanity-abc"); !ok || got != "sk_live_abc" {
+		t.Fatalf("Stream(vanity-abc) = %q, %v", got, ok)
+	}
+	if got, ok := r.Stream("other"); !ok || got != "fallback" {
+		t.Fatalf("Stream(other) = %q, %v", got, ok)
+	}
+	if got, ok := r.App("acme/live"); !ok || got != "ingest-acme" {
+		t.Fatalf("App(acme/live) = %q, %v", got, ok)
+	}
+	// Matches are anchored to the whole value.
+	if got, ok := r.App("x/acme/live"); ok {
+		t.Fatalf("App(x/acme/live) = %q, want no match", got)
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	if _, err := New([]Rule{{Field: "host", Match: ".*"}}); err == nil {
+		t.Fatal("expected unknown field to fail")
+	}
+	if _, err := New([]Rule{{Field: FieldApp, Match: "("}}); err == nil {
+		t.Fatal("expected invalid regex to fail")
+	}
+	if r, err := New(nil); err != nil || r != nil {
+		t.Fatalf("New(nil) = %v, %v; want nil, nil", r, err)
+	}
+}
+
+func TestNilRewriterPassesThrough(t *testing.T) {
+	var r *Rewriter
+	if got, ok := r.Stream("key"); ok || got != "key" {
+		t.Fatalf("nil Stream = %q, %v", got, ok)
+	}
+}
+
+func TestTcURL(t *testing.T) {
+	cases := []struct {
+		in, app, want string
+	}{
+		{"rtmp://relay.example.com/live", "ingest", "rtmp://relay.example.com/ingest"},
+		{"rtmp://relay.example.com:1935/live?token=x", "tenant/live", "rtmp://relay.example.com:1935/tenant/live?token=x"},
+		{"not a url", "ingest", "not a url"},
+	}
+	for _, c := range cases {
+		if got := TcURL(c.in, c.app); got != c.want {
+			t.Fatalf("TcURL(%q, %q) = %q, want %q", c.in, c.app, got, c.want)
+		}
+	}
+}
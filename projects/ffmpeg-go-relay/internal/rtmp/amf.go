@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"math"
+
+	"ffmpeg-go-relay/internal/errclass"
 )
 
 // AMF0 Markers
@@ -26,6 +28,7 @@ const (
 	maxAMFValues    = 1000  // Max number of AMF values in a single decode
 	maxAMFStringLen = 65535 // Max string length (AMF0 spec limit)
 	maxObjectKeys   = 500   // Max keys in a single object
+	maxAMFDepth     = 32    // Max nesting of objects and arrays
 )
 
 var (
@@ -34,6 +37,7 @@ var (
 	ErrValueLimit      = errors.New("amf: value limit exceeded")
 	ErrStringTooLong   = errors.New("amf: string too long")
 	ErrObjectKeyLimit  = errors.New("amf: object key limit exceeded")
+	ErrDepthLimit      = errors.New("amf: nesting depth limit exceeded")
 )
 
 // DecodeAMF0 decodes a sequence of AMF0 values from the reader
@@ -41,28 +45,41 @@ func DecodeAMF0(r io.Reader) ([]interface{}, error) {
 	var values []interface{}
 	for {
 		if len(values) >= maxAMFValues {
-			return nil, ErrValueLimit
+			return nil, &errclass.ProtocolError{Err: ErrValueLimit}
 		}
 		v, err := DecodeAMF0Value(r)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, &errclass.ProtocolError{Err: err}
 		}
 		values = append(values, v)
 	}
 	return values, nil
 }
 
-// DecodeAMF0Value decodes a single AMF0 value
+// DecodeAMF0Value decodes a single AMF0 value. It returns io.EOF only when r
+// ends before the value starts; a value cut short is io.ErrUnexpectedEOF.
 func DecodeAMF0Value(r io.Reader) (interface{}, error) {
+	return decodeValue(r, 0)
+}
+
+// decodeValue decodes a value nested in depth objects.
+func decodeValue(r io.Reader, depth int) (interface{}, error) {
 	var marker [1]byte
 	if _, err := io.ReadFull(r, marker[:]); err != nil {
 		return nil, err
 	}
+	v, err := decodeMarked(r, marker[0], depth)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
 
-	switch marker[0] {
+func decodeMarked(r io.Reader, marker byte, depth int) (interface{}, error) {
+	switch marker {
 	case MarkerNumber:
 		return decodeNumber(r)
 	case MarkerBoolean:
@@ -70,15 +87,15 @@ func DecodeAMF0Value(r io.Reader) (interface{}, error) {
 	case MarkerString:
 		return decodeString(r)
 	case MarkerObject:
-		return decodeObject(r)
+		return decodeObject(r, depth+1)
 	case MarkerNull:
 		return nil, nil
 	case MarkerECMAArray:
-		return decodeECMAArray(r)
+		return decodeECMAArray(r, depth+1)
 	case MarkerObjectEnd:
 		return nil, ErrEndObject
 	default:
-		return nil, createInvalidMarkerError(marker[0])
+		return nil, createInvalidMarkerError(marker)
 	}
 }
 
@@ -121,7 +138,10 @@ func decodeString(r io.Reader) (string, error) {
 	return string(buf), nil
 }
 
-func decodeObject(r io.Reader) (map[string]interface{}, error) {
+func decodeObject(r io.Reader, depth int) (map[string]interface{}, error) {
+	if depth > maxAMFDepth {
+		return nil, ErrDepthLimit
+	}
 	obj := make(map[string]interface{})
 	for {
 		if len(obj) >= maxObjectKeys {
@@ -136,7 +156,7 @@ func decodeObject(r io.Reader) (map[string]interface{}, error) {
 		// Empty key can signify end of object in some cases,
 		// but usually followed by MarkerObjectEnd (0x09)
 
-		val, err := DecodeAMF0Value(r)
+		val, err := decodeValue(r, depth)
 		if err == ErrEndObject {
 			break
 		}
@@ -149,13 +169,13 @@ func decodeObject(r io.Reader) (map[string]interface{}, error) {
 	return obj, nil
 }
 
-func decodeECMAArray(r io.Reader) (map[string]interface{}, error) {
+func decodeECMAArray(r io.Reader, depth int) (map[string]interface{}, error) {
 	var countBuf [4]byte
 	if _, err := io.ReadFull(r, countBuf[:]); err != nil {
 		return nil, err
 	}
 	// We largely ignore the count in loose parsing and read until ObjectEnd
-	return decodeObject(r)
+	return decodeObject(r, depth)
 }
 
 func createInvalidMarkerError(marker byte) error {
@@ -0,0 +1,25 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecodeAMF0RejectsTruncatedObject(t *testing.T) {
+	data := []byte{MarkerObject, 0, 1, 'a', MarkerObject, 0, 1, 'b', MarkerNumber}
+	if _, err := DecodeAMF0(bytes.NewReader(data)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecodeAMF0LimitsNesting(t *testing.T) {
+	var data []byte
+	for i := 0; i <= maxAMFDepth; i++ {
+		data = append(data, MarkerObject, 0, 1, 'a')
+	}
+	if _, err := DecodeAMF0(bytes.NewReader(data)); !errors.Is(err, ErrDepthLimit) {
+		t.Fatalf("err = %v, want ErrDepthLimit", err)
+	}
+}
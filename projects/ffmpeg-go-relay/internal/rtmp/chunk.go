@@ -2,7 +2,12 @@ package rtmp
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
+	"slices"
+
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/pool"
 )
 
 // Chunk Stream Constants
@@ -17,21 +22,79 @@ const (
 	TypeVideo = 9
 
 	TypeAMF20Command = 17
+	TypeAMF0Data     = 18
 	TypeAMF0Command  = 20
 )
 
 const DefaultChunkSize = 128
 
+// Default limits applied to peer-controlled allocations.
+const (
+	DefaultMaxMessageSize   = 8 * 1024 * 1024  // 8 MB per message
+	DefaultMaxChunkStreams  = 64               // Chunk streams with a message in progress
+	DefaultMaxBufferedBytes = 16 * 1024 * 1024 // 16 MB across partial messages
+)
+
+// initialPayloadCap bounds the payload allocated for a message before any of
+// its bytes arrived.
+const initialPayloadCap = 64 * 1024
+
+var (
+	ErrMessageTooLarge     = errors.New("rtmp: message exceeds maximum size")
+	ErrTooManyChunkStreams = errors.New("rtmp: too many chunk streams")
+	ErrBufferLimit         = errors.New("rtmp: buffered bytes limit exceeded")
+	ErrInvalidChunkSize    = errors.New("rtmp: invalid chunk size")
+)
+
+// ChunkLimits bounds the memory a peer can make a ChunkStream allocate.
+// Zero fields fall back to the package defaults.
+type ChunkLimits struct {
+	MaxMessageSize   uint32
+	MaxChunkStreams  int
+	MaxBufferedBytes int64
+}
+
+// DefaultChunkLimits returns the limits used by NewChunkStream.
+func DefaultChunkLimits() ChunkLimits {
+	return ChunkLimits{
+		MaxMessageSize:   DefaultMaxMessageSize,
+		MaxChunkStreams:  DefaultMaxChunkStreams,
+		MaxBufferedBytes: DefaultMaxBufferedBytes,
+	}
+}
+
+func (l ChunkLimits) normalize() ChunkLimits {
+	def := DefaultChunkLimits()
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = def.MaxMessageSize
+	}
+	if l.MaxChunkStreams <= 0 {
+		l.MaxChunkStreams = def.MaxChunkStreams
+	}
+	if l.MaxBufferedBytes <= 0 {
+		l.MaxBufferedBytes = def.MaxBufferedBytes
+	}
+	return l
+}
+
 type ChunkStream struct {
 	r           io.Reader
 	rxChunkSize uint32 // Chunk size for receiving (peer sends this)
 	txChunkSize uint32 // Chunk size for sending (we send this)
 	streams     map[uint32]*StreamState
+	limits      ChunkLimits
+	buffered    int64          // Bytes allocated for partially received messages
+	partials    int            // Chunk streams with a partially received message
+	onMessage   func(*Message) // Optional observer, see SetMessageHook
+	payloads    *pool.BytePool // Optional, see SetPayloadPool
+	scratch     [11]byte       // Basic and message header bytes of the chunk being read
 }
 
 type StreamState struct {
 	LastHeader ChunkHeader
 	Partial    *Message // Currently assembling message
+
+	extended bool // Last full header used an extended timestamp
 }
 
 type ChunkHeader struct {
@@ -52,17 +115,66 @@ type Message struct {
 
 	// Internal
 	bytesRead uint32
+	lease     []byte         // Full pool buffer backing Payload, if any
+	pool      *pool.BytePool // Where lease goes back to on Release
+}
+
+// Release hands a payload leased from the chunk stream's pool back to it;
+// see ChunkStream.SetPayloadPool. Payload must not be used afterwards and is
+// set to nil. Release does nothing for payloads that were not leased and is
+// safe to call more than once.
+func (m *Message) Release() {
+	if m == nil || m.lease == nil {
+		return
+	}
+	m.pool.Put(m.lease)
+	m.lease, m.pool, m.Payload = nil, nil, nil
 }
 
 func NewChunkStream(r io.Reader) *ChunkStream {
+	return NewChunkStreamWithLimits(r, DefaultChunkLimits())
+}
+
+// NewChunkStreamWithLimits creates a chunk stream reader enforcing the given limits.
+func NewChunkStreamWithLimits(r io.Reader, limits ChunkLimits) *ChunkStream {
 	return &ChunkStream{
 		r:           r,
 		rxChunkSize: DefaultChunkSize,
 		txChunkSize: DefaultChunkSize,
 		streams:     make(map[uint32]*StreamState),
+		limits:      limits.normalize(),
 	}
 }
 
+// Limits returns the limits enforced by this chunk stream.
+func (c *ChunkStream) Limits() ChunkLimits {
+	return c.limits
+}
+
+// SetMessageHook installs fn to see every complete message ReadMessage
+// returns, including those consumed by a ServerSession. fn must not modify
+// the message.
+func (c *ChunkStream) SetMessageHook(fn func(*Message)) {
+	c.onMessage = fn
+}
+
+// SetPayloadPool makes later messages that fit in one of p's buffers lease
+// their payload from it instead of allocating. Whoever ends up holding such
+// a message must call Release once done with it, and anything that keeps
+// the payload past that point must copy it. A nil p allocates every payload.
+func (c *ChunkStream) SetPayloadPool(p *pool.BytePool) {
+	c.payloads = p
+}
+
+// Detach returns a message that stays valid after m is released: m itself
+// when its payload is not leased, otherwise a copy with its own payload.
+func (m *Message) Detach() *Message {
+	if m.lease == nil {
+		return m
+	}
+	return &Message{Header: m.Header, Payload: append([]byte(nil), m.Payload...), bytesRead: m.bytesRead}
+}
+
 // ReadMessage reads the next full message from the stream.
 // It handles interleaving and protocol control messages automatically.
 func (c *ChunkStream) ReadMessage() (*Message, error) {
@@ -77,13 +189,22 @@ func (c *ChunkStream) ReadMessage() (*Message, error) {
 		if msg != nil {
 			// Intercept protocol control messages that affect stream state
 			if msg.Header.TypeID == TypeSetChunkSize {
-				if len(msg.Payload) >= 4 {
-					newSize := binary.BigEndian.Uint32(msg.Payload)
-					// FFmpeg and others limit this to valid ranges
-					if newSize > 0 && newSize < 0x7FFFFFFF {
-						c.rxChunkSize = newSize
-					}
+				// Every later chunk is cut to this size, so a size the
+				// stream cannot be read with ends it.
+				if len(msg.Payload) < 4 {
+					return nil, errclass.Protocolf("", "%w: %d byte payload", ErrInvalidChunkSize, len(msg.Payload))
+				}
+				newSize := binary.BigEndian.Uint32(msg.Payload)
+				if newSize == 0 || newSize > maxChunkSize {
+					return nil, errclass.Protocolf("", "%w %d", ErrInvalidChunkSize, newSize)
 				}
+				c.rxChunkSize = newSize
+			}
+			if msg.Header.TypeID == TypeAbortMessage && len(msg.Payload) >= 4 {
+				c.abort(binary.BigEndian.Uint32(msg.Payload))
+			}
+			if c.onMessage != nil {
+				c.onMessage(msg)
 			}
 			return msg, nil
 		}
@@ -95,7 +216,7 @@ func (c *ChunkStream) ReadMessage() (*Message, error) {
 // Returns a Message if one was completed, or nil if more chunks are needed.
 func (c *ChunkStream) readChunk() (*Message, error) {
 	// 1. Read Basic Header
-	h1, err := readByte(c.r)
+	h1, err := c.readByte()
 	if err != nil {
 		return nil, err
 	}
@@ -104,20 +225,21 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 	csID := uint32(h1 & 0x3f)
 
 	if csID == 0 {
-		h2, err := readByte(c.r)
+		h2, err := c.readByte()
 		if err != nil {
 			return nil, err
 		}
 		csID = 64 + uint32(h2)
 	} else if csID == 1 {
-		b := make([]byte, 2)
+		b := c.scratch[:2]
 		if _, err := io.ReadFull(c.r, b); err != nil {
 			return nil, err
 		}
 		csID = 64 + uint32(b[0]) + uint32(b[1])*256
 	}
 
-	// Get stream state
+	// Get stream state. Idle states are kept for the headers that follow;
+	// there are at most 65599 chunk stream IDs, so the map stays bounded.
 	state, exists := c.streams[csID]
 	if !exists {
 		state = &StreamState{}
@@ -130,7 +252,7 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 
 	// 2. Read Message Header based on Fmt
 	if fmtID == 0 {
-		buf := make([]byte, 11)
+		buf := c.scratch[:11]
 		if _, err := io.ReadFull(c.r, buf); err != nil {
 			return nil, err
 		}
@@ -140,7 +262,7 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 		header.StreamID = binary.LittleEndian.Uint32(buf[7:11])
 		header.TimeDelta = 0 // Absolute timestamp
 	} else if fmtID == 1 {
-		buf := make([]byte, 7)
+		buf := c.scratch[:7]
 		if _, err := io.ReadFull(c.r, buf); err != nil {
 			return nil, err
 		}
@@ -149,7 +271,7 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 		header.TypeID = buf[6]
 		header.Timestamp = state.LastHeader.Timestamp + header.TimeDelta
 	} else if fmtID == 2 {
-		buf := make([]byte, 3)
+		buf := c.scratch[:3]
 		if _, err := io.ReadFull(c.r, buf); err != nil {
 			return nil, err
 		}
@@ -173,39 +295,72 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 	}
 
 	// 3. Extended Timestamp
-	// Logic: If the timestamp field was 0xFFFFFF, we read 4 bytes.
-	// NOTE: This applies to Timestamp (fmt 0) or TimeDelta (fmt 1/2).
-	tsField := header.Timestamp
-	if fmtID == 1 || fmtID == 2 {
-		tsField = header.TimeDelta
+	// Present when the 24-bit field of a fmt 0/1/2 header is 0xFFFFFF; fmt 3
+	// chunks repeat it whenever the last full header on this stream used it.
+	var extended bool
+	switch fmtID {
+	case 0:
+		extended = header.Timestamp >= 0xFFFFFF
+	case 1, 2:
+		extended = header.TimeDelta >= 0xFFFFFF
+	default:
+		extended = state.extended
 	}
 
-	if tsField >= 0xFFFFFF {
-		var b [4]byte
-		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+	if extended {
+		b := c.scratch[:4]
+		if _, err := io.ReadFull(c.r, b); err != nil {
 			return nil, err
 		}
-		ext := binary.BigEndian.Uint32(b[:])
-		if fmtID == 0 {
+		ext := binary.BigEndian.Uint32(b)
+		switch {
+		case fmtID == 0:
 			header.Timestamp = ext
-		} else {
+		case fmtID != 3 || state.Partial == nil:
 			header.TimeDelta = ext
 			header.Timestamp = state.LastHeader.Timestamp + header.TimeDelta
 		}
 	}
+	if fmtID != 3 {
+		state.extended = extended
+	}
 
 	// Update LastHeader for next time (except for fmt 3 partials which don't update timestamp yet)
 	state.LastHeader = header
 
+	// A new message header on a chunk stream abandons any partial message.
+	if fmtID != 3 && state.Partial != nil {
+		state.Partial.Release()
+		c.dropPartial(state)
+	}
+
 	// 4. Payload Reading
 	var msg *Message
 	if state.Partial != nil {
 		msg = state.Partial
 	} else {
-		msg = &Message{
-			Header:    header,
-			Payload:   make([]byte, header.Length),
-			bytesRead: 0,
+		if header.Length > c.limits.MaxMessageSize {
+			return nil, errclass.Protocolf("", "%w (%d > %d)", ErrMessageTooLarge, header.Length, c.limits.MaxMessageSize)
+		}
+		if c.buffered+int64(header.Length) > c.limits.MaxBufferedBytes {
+			return nil, errclass.Protocolf("", "%w (%d)", ErrBufferLimit, c.limits.MaxBufferedBytes)
+		}
+		// Only streams interleaving messages count; a peer may move on to
+		// new chunk stream IDs for as long as it likes.
+		if c.partials >= c.limits.MaxChunkStreams {
+			return nil, errclass.Protocolf("", "%w (%d)", ErrTooManyChunkStreams, c.limits.MaxChunkStreams)
+		}
+		c.buffered += int64(header.Length)
+		c.partials++
+		msg = &Message{Header: header}
+		if c.payloads != nil && int(header.Length) <= c.payloads.Size() {
+			msg.lease = c.payloads.Get()
+			msg.pool = c.payloads
+			msg.Payload = msg.lease[:0]
+		} else {
+			// The length is only the peer's word, so the payload grows
+			// as its bytes arrive instead of being allocated up front.
+			msg.Payload = make([]byte, 0, min(header.Length, c.rxChunkSize, initialPayloadCap))
 		}
 		state.Partial = msg
 	}
@@ -218,14 +373,16 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 		toRead = chunkLimit
 	}
 
-	if _, err := io.ReadFull(c.r, msg.Payload[msg.bytesRead:msg.bytesRead+toRead]); err != nil {
+	end := int(msg.bytesRead + toRead)
+	msg.Payload = slices.Grow(msg.Payload, end-len(msg.Payload))[:end]
+	if _, err := io.ReadFull(c.r, msg.Payload[msg.bytesRead:end]); err != nil {
 		return nil, err
 	}
 	msg.bytesRead += toRead
 
 	// Check if complete
 	if msg.bytesRead >= msg.Header.Length {
-		state.Partial = nil // Clear partial
+		c.dropPartial(state)
 		return msg, nil
 	}
 
@@ -233,9 +390,32 @@ func (c *ChunkStream) readChunk() (*Message, error) {
 	return nil, nil
 }
 
-func readByte(r io.Reader) (byte, error) {
-	var b [1]byte
-	_, err := io.ReadFull(r, b[:])
+// dropPartial clears the in-progress message on a chunk stream and releases its accounting.
+func (c *ChunkStream) dropPartial(state *StreamState) {
+	if state.Partial == nil {
+		return
+	}
+	c.buffered -= int64(state.Partial.Header.Length)
+	if c.buffered < 0 {
+		c.buffered = 0
+	}
+	c.partials--
+	state.Partial = nil
+}
+
+// abort discards a partially received message as requested by an Abort Message.
+func (c *ChunkStream) abort(csID uint32) {
+	if state, ok := c.streams[csID]; ok {
+		state.Partial.Release()
+		c.dropPartial(state)
+	}
+}
+
+// readByte reads through the scratch array, which unlike a local array
+// does not escape to the heap on every call.
+func (c *ChunkStream) readByte() (byte, error) {
+	b := c.scratch[:1]
+	_, err := io.ReadFull(c.r, b)
 	return b[0], err
 }
 
@@ -0,0 +1,194 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"ffmpeg-go-relay/internal/pool"
+)
+
+// fmt0Chunk builds a single fmt 0 chunk header for the given chunk stream.
+func fmt0Chunk(csid uint8, length uint32, typeID uint8, payload []byte) []byte {
+	buf := []byte{csid & 0x3f, 0, 0, 0, byte(length >> 16), byte(length >> 8), byte(length), typeID, 0, 0, 0, 0}
+	return append(buf, payload...)
+}
+
+func TestChunkStreamReadsMessage(t *testing.T) {
+	payload := []byte("hello")
+	cs := NewChunkStream(bytes.NewReader(fmt0Chunk(3, uint32(len(payload)), TypeAMF0Command, payload)))
+
+	msg, err := cs.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Fatalf("payload = %q, want %q", msg.Payload, payload)
+	}
+}
+
+func TestChunkStreamRejectsOversizedMessage(t *testing.T) {
+	data := fmt0Chunk(3, 1024, TypeVideo, nil)
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxMessageSize: 512})
+
+	if _, err := cs.ReadMessage(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestChunkStreamRejectsTooManyChunkStreams(t *testing.T) {
+	// Three interleaved messages, each left after its first chunk, then a
+	// fourth chunk that is never read.
+	var data []byte
+	for csid := uint8(3); csid < 6; csid++ {
+		data = append(data, fmt0Chunk(csid, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	}
+	data = append(data, fmt0Chunk(6, 1, TypeAudio, []byte{0})...)
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxChunkStreams: 2})
+
+	if _, err := cs.ReadMessage(); !errors.Is(err, ErrTooManyChunkStreams) {
+		t.Fatalf("err = %v, want ErrTooManyChunkStreams", err)
+	}
+}
+
+func TestChunkStreamAllowsCyclingChunkStreams(t *testing.T) {
+	// Only two streams are ever mid-message, however many IDs are used.
+	var data []byte
+	for csid := uint8(3); csid < 63; csid++ {
+		data = append(data, fmt0Chunk(csid, 1, TypeAudio, []byte{csid})...)
+	}
+	data = append(data, fmt0Chunk(3, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	data = append(data, fmt0Chunk(4, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	data = append(data, 0xC0|3)
+	data = append(data, make([]byte, 200-DefaultChunkSize)...)
+	data = append(data, fmt0Chunk(5, 1, TypeAudio, []byte{5})...)
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxChunkStreams: 2})
+
+	for i := 0; i < 62; i++ {
+		if _, err := cs.ReadMessage(); err != nil {
+			t.Fatalf("message %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestChunkStreamRejectsBufferedBytesOverLimit(t *testing.T) {
+	// Two interleaved partial messages of 200 bytes each; only the first chunk
+	// (128 bytes) of each is sent, so both stay buffered.
+	var data []byte
+	data = append(data, fmt0Chunk(3, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	data = append(data, fmt0Chunk(4, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxBufferedBytes: 300})
+
+	if _, err := cs.ReadMessage(); !errors.Is(err, ErrBufferLimit) {
+		t.Fatalf("err = %v, want ErrBufferLimit", err)
+	}
+}
+
+func TestChunkStreamAbortReleasesBufferedBytes(t *testing.T) {
+	var abort [4]byte
+	binary.BigEndian.PutUint32(abort[:], 4)
+
+	var data []byte
+	data = append(data, fmt0Chunk(4, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	data = append(data, fmt0Chunk(2, 4, TypeAbortMessage, abort[:])...)
+	data = append(data, fmt0Chunk(5, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	data = append(data, 0xC0|5)
+	data = append(data, make([]byte, 200-DefaultChunkSize)...)
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxBufferedBytes: 300})
+
+	msg, err := cs.ReadMessage()
+	if err != nil || msg.Header.TypeID != TypeAbortMessage {
+		t.Fatalf("expected abort message, got %v (err=%v)", msg, err)
+	}
+	msg, err = cs.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error after abort: %v", err)
+	}
+	if msg.Header.CSID != 5 || len(msg.Payload) != 200 {
+		t.Fatalf("unexpected message csid=%d len=%d", msg.Header.CSID, len(msg.Payload))
+	}
+}
+
+func TestChunkStreamLeasesPayloadsFromPool(t *testing.T) {
+	bp := pool.New(256)
+	var data []byte
+	data = append(data, fmt0Chunk(4, 100, TypeVideo, make([]byte, 100))...)
+	data = append(data, fmt0Chunk(4, 1000, TypeVideo, make([]byte, DefaultChunkSize))...)
+	for n := 1000 - DefaultChunkSize; n > 0; n -= DefaultChunkSize {
+		data = append(data, 0xC0|4)
+		data = append(data, make([]byte, min(n, DefaultChunkSize))...)
+	}
+	cs := NewChunkStream(bytes.NewReader(data))
+	cs.SetPayloadPool(bp)
+
+	small, err := cs.ReadMessage()
+	if err != nil || len(small.Payload) != 100 {
+		t.Fatalf("small message: %v (err=%v)", small, err)
+	}
+	if bp.Stats()["in_use"] != int64(1) {
+		t.Fatalf("pool stats = %v, want the small payload leased", bp.Stats())
+	}
+	detached := small.Detach()
+	small.Release()
+	small.Release()
+	if small.Payload != nil || len(detached.Payload) != 100 {
+		t.Fatal("Release left the payload set, or Detach shared it")
+	}
+	if bp.Stats()["in_use"] != int64(0) {
+		t.Fatalf("pool stats = %v after Release", bp.Stats())
+	}
+
+	// Messages larger than a pool buffer are allocated as before.
+	large, err := cs.ReadMessage()
+	if err != nil || len(large.Payload) != 1000 {
+		t.Fatalf("large message: %v (err=%v)", large, err)
+	}
+	if bp.Stats()["gets"] != int64(1) || large.Detach() != large {
+		t.Fatalf("pool stats = %v, want the large payload allocated", bp.Stats())
+	}
+}
+
+func TestChunkStreamReleasesAbandonedPartial(t *testing.T) {
+	bp := pool.New(256)
+	var data []byte
+	data = append(data, fmt0Chunk(4, 200, TypeVideo, make([]byte, DefaultChunkSize))...)
+	// A new full header on the same chunk stream abandons the partial message.
+	data = append(data, fmt0Chunk(4, 10, TypeVideo, make([]byte, 10))...)
+	cs := NewChunkStream(bytes.NewReader(data))
+	cs.SetPayloadPool(bp)
+
+	msg, err := cs.ReadMessage()
+	if err != nil || len(msg.Payload) != 10 {
+		t.Fatalf("message = %v (err=%v), want the 10-byte one", msg, err)
+	}
+	msg.Release()
+	if stats := bp.Stats(); stats["gets"] != int64(2) || stats["in_use"] != int64(0) {
+		t.Fatalf("pool stats = %v, want the abandoned payload returned", stats)
+	}
+}
+
+func TestChunkStreamRejectsInvalidChunkSize(t *testing.T) {
+	for _, payload := range [][]byte{{0, 0, 0, 0}, {0x80, 0, 0, 0}, {0, 1}} {
+		cs := NewChunkStream(bytes.NewReader(fmt0Chunk(2, uint32(len(payload)), TypeSetChunkSize, payload)))
+		if _, err := cs.ReadMessage(); !errors.Is(err, ErrInvalidChunkSize) {
+			t.Fatalf("set chunk size %x: err = %v, want ErrInvalidChunkSize", payload, err)
+		}
+	}
+}
+
+func TestChunkStreamDoesNotTrustDeclaredLength(t *testing.T) {
+	// A header declaring a 16 MB message followed by a single chunk must not
+	// cost 16 MB before the rest of the message arrives.
+	data := fmt0Chunk(3, 0xFFFFFF, TypeVideo, make([]byte, DefaultChunkSize))
+	cs := NewChunkStreamWithLimits(bytes.NewReader(data), ChunkLimits{MaxMessageSize: 32 << 20, MaxBufferedBytes: 32 << 20})
+
+	if _, err := cs.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+	partial := cs.streams[3].Partial
+	if partial == nil || len(partial.Payload) != DefaultChunkSize || cap(partial.Payload) > initialPayloadCap {
+		t.Fatalf("partial payload len %d cap %d after one chunk", len(partial.Payload), cap(partial.Payload))
+	}
+}
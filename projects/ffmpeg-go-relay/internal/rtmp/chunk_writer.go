@@ -0,0 +1,184 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Well-known chunk stream IDs used when writing.
+const (
+	CSIDProtocolControl = 2
+	CSIDCommand         = 3
+	CSIDAudio           = 4
+	CSIDVideo           = 6
+
+	maxChunkStreamID = 65599
+	maxChunkSize     = 0x7FFFFFFF
+	extendedTSMarker = 0xFFFFFF
+)
+
+type writerState struct {
+	header   ChunkHeader
+	extended bool
+}
+
+// ChunkWriter serializes messages into RTMP chunks, compressing headers
+// (fmt 0-3) against the previous message on the same chunk stream.
+type ChunkWriter struct {
+	w         io.Writer
+	chunkSize uint32
+	streams   map[uint32]*writerState
+	scratch   []byte
+}
+
+// NewChunkWriter creates a chunk writer using the default 128-byte chunk size.
+func NewChunkWriter(w io.Writer) *ChunkWriter {
+	return &ChunkWriter{
+		w:         w,
+		chunkSize: DefaultChunkSize,
+		streams:   make(map[uint32]*writerState),
+		scratch:   make([]byte, 0, 18),
+	}
+}
+
+// ChunkSize returns the current outgoing chunk size.
+func (cw *ChunkWriter) ChunkSize() uint32 {
+	return cw.chunkSize
+}
+
+// SetChunkSize changes the outgoing chunk size without notifying the peer.
+// Use WriteSetChunkSize unless the peer has already been told.
+func (cw *ChunkWriter) SetChunkSize(size uint32) error {
+	if size == 0 || size > maxChunkSize {
+		return fmt.Errorf("rtmp: invalid chunk size %d", size)
+	}
+	cw.chunkSize = size
+	return nil
+}
+
+// WriteSetChunkSize sends a Set Chunk Size message and applies the new size
+// to all subsequent chunks.
+func (cw *ChunkWriter) WriteSetChunkSize(size uint32) error {
+	if size == 0 || size > maxChunkSize {
+		return fmt.Errorf("rtmp: invalid chunk size %d", size)
+	}
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], size)
+	if err := cw.WriteMessage(&Message{
+		Header:  ChunkHeader{CSID: CSIDProtocolControl, TypeID: TypeSetChunkSize},
+		Payload: payload[:],
+	}); err != nil {
+		return err
+	}
+	return cw.SetChunkSize(size)
+}
+
+// WriteMessage writes msg on msg.Header.CSID. Timestamp, TypeID and StreamID
+// are taken from the header; Length is derived from the payload.
+func (cw *ChunkWriter) WriteMessage(msg *Message) error {
+	if msg == nil {
+		return fmt.Errorf("rtmp: nil message")
+	}
+	csid := msg.Header.CSID
+	if csid < 2 || csid > maxChunkStreamID {
+		return fmt.Errorf("rtmp: invalid chunk stream id %d", csid)
+	}
+	if len(msg.Payload) > 0xFFFFFF {
+		return ErrMessageTooLarge
+	}
+
+	h := msg.Header
+	h.Length = uint32(len(msg.Payload))
+
+	prev, hasPrev := cw.streams[csid]
+	var fmtID uint8
+	var tsField uint32
+	var extended bool
+
+	switch {
+	case !hasPrev || h.StreamID != prev.header.StreamID || h.Timestamp < prev.header.Timestamp:
+		fmtID = 0
+		tsField = h.Timestamp
+		h.TimeDelta = 0
+	default:
+		h.TimeDelta = h.Timestamp - prev.header.Timestamp
+		tsField = h.TimeDelta
+		switch {
+		case h.Length != prev.header.Length || h.TypeID != prev.header.TypeID:
+			fmtID = 1
+		case h.TimeDelta != prev.header.TimeDelta:
+			fmtID = 2
+		default:
+			fmtID = 3
+		}
+	}
+
+	if fmtID == 3 {
+		extended = prev.extended
+	} else {
+		extended = tsField >= extendedTSMarker
+	}
+
+	if err := cw.writeChunkHeader(fmtID, csid, h, tsField, extended); err != nil {
+		return err
+	}
+
+	payload := msg.Payload
+	for written := uint32(0); ; {
+		n := h.Length - written
+		if n > cw.chunkSize {
+			n = cw.chunkSize
+		}
+		if err := writeAll(cw.w, payload[written:written+n]); err != nil {
+			return err
+		}
+		written += n
+		if written >= h.Length {
+			break
+		}
+		if err := cw.writeChunkHeader(3, csid, h, tsField, extended); err != nil {
+			return err
+		}
+	}
+
+	cw.streams[csid] = &writerState{header: h, extended: extended}
+	return nil
+}
+
+func (cw *ChunkWriter) writeChunkHeader(fmtID uint8, csid uint32, h ChunkHeader, tsField uint32, extended bool) error {
+	buf := cw.scratch[:0]
+
+	// Basic header
+	switch {
+	case csid < 64:
+		buf = append(buf, fmtID<<6|byte(csid))
+	case csid < 320:
+		buf = append(buf, fmtID<<6, byte(csid-64))
+	default:
+		buf = append(buf, fmtID<<6|1, byte(csid-64), byte((csid-64)>>8))
+	}
+
+	// Message header
+	ts := tsField
+	if extended {
+		ts = extendedTSMarker
+	}
+	if fmtID <= 2 {
+		buf = append(buf, byte(ts>>16), byte(ts>>8), byte(ts))
+	}
+	if fmtID <= 1 {
+		buf = append(buf, byte(h.Length>>16), byte(h.Length>>8), byte(h.Length), h.TypeID)
+	}
+	if fmtID == 0 {
+		buf = binary.LittleEndian.AppendUint32(buf, h.StreamID)
+	}
+
+	// Extended timestamp
+	if extended {
+		buf = binary.BigEndian.AppendUint32(buf, tsField)
+	}
+
+	cw.scratch = buf
+	return writeAll(cw.w, buf)
+}
@@ -0,0 +1,93 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkWriterRoundTrip(t *testing.T) {
+	msgs := []*Message{
+		{Header: ChunkHeader{CSID: CSIDVideo, Timestamp: 0, TypeID: TypeVideo, StreamID: 1}, Payload: bytes.Repeat([]byte{1}, 300)},
+		{Header: ChunkHeader{CSID: CSIDVideo, Timestamp: 40, TypeID: TypeVideo, StreamID: 1}, Payload: bytes.Repeat([]byte{2}, 300)},
+		{Header: ChunkHeader{CSID: CSIDVideo, Timestamp: 80, TypeID: TypeVideo, StreamID: 1}, Payload: bytes.Repeat([]byte{3}, 300)},
+		{Header: ChunkHeader{CSID: CSIDVideo, Timestamp: 100, TypeID: TypeVideo, StreamID: 1}, Payload: bytes.Repeat([]byte{4}, 50)},
+		{Header: ChunkHeader{CSID: CSIDAudio, Timestamp: 0x1000000, TypeID: TypeAudio, StreamID: 1}, Payload: bytes.Repeat([]byte{5}, 200)},
+		{Header: ChunkHeader{CSID: CSIDAudio, Timestamp: 0x1000000 + 23, TypeID: TypeAudio, StreamID: 1}, Payload: bytes.Repeat([]byte{6}, 200)},
+		{Header: ChunkHeader{CSID: 400, Timestamp: 5, TypeID: TypeAMF0Command}, Payload: []byte("cmd")},
+	}
+
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf)
+	for _, m := range msgs {
+		if err := cw.WriteMessage(m); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	cs := NewChunkStream(&buf)
+	for i, want := range msgs {
+		got, err := cs.ReadMessage()
+		if err != nil {
+			t.Fatalf("message %d: read: %v", i, err)
+		}
+		if got.Header.CSID != want.Header.CSID || got.Header.Timestamp != want.Header.Timestamp ||
+			got.Header.TypeID != want.Header.TypeID || got.Header.StreamID != want.Header.StreamID {
+			t.Fatalf("message %d: header = %+v, want %+v", i, got.Header, want.Header)
+		}
+		if !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("message %d: payload mismatch", i)
+		}
+	}
+}
+
+func TestChunkWriterCompressesHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf)
+
+	first := &Message{Header: ChunkHeader{CSID: CSIDAudio, Timestamp: 0, TypeID: TypeAudio, StreamID: 1}, Payload: []byte{1, 2}}
+	if err := cw.WriteMessage(first); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := buf.Bytes()[0] >> 6; got != 0 {
+		t.Fatalf("first message fmt = %d, want 0", got)
+	}
+
+	buf.Reset()
+	second := &Message{Header: ChunkHeader{CSID: CSIDAudio, Timestamp: 0, TypeID: TypeAudio, StreamID: 1}, Payload: []byte{3, 4}}
+	if err := cw.WriteMessage(second); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := buf.Bytes()[0] >> 6; got != 3 {
+		t.Fatalf("identical message fmt = %d, want 3", got)
+	}
+	if buf.Len() != 3 {
+		t.Fatalf("fmt 3 message length = %d, want 3", buf.Len())
+	}
+}
+
+func TestChunkWriterSetChunkSize(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf)
+	if err := cw.WriteSetChunkSize(4096); err != nil {
+		t.Fatalf("set chunk size: %v", err)
+	}
+	payload := bytes.Repeat([]byte{7}, 4000)
+	if err := cw.WriteMessage(&Message{Header: ChunkHeader{CSID: CSIDVideo, TypeID: TypeVideo}, Payload: payload}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cs := NewChunkStream(&buf)
+	if _, err := cs.ReadMessage(); err != nil {
+		t.Fatalf("read set chunk size: %v", err)
+	}
+	got, err := cs.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got.Payload, payload) {
+		t.Fatal("payload mismatch after chunk size change")
+	}
+	if err := cw.SetChunkSize(0); err == nil {
+		t.Fatal("expected error for zero chunk size")
+	}
+}
@@ -0,0 +1,295 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"ffmpeg-go-relay/internal/errclass"
+)
+
+// clientChunkSize is the chunk size a ClientSession announces after connect.
+const clientChunkSize = 4096
+
+// ClientSession handles the client-side RTMP commands: connect, then publish
+// or play. The handshake must already have been done on the connection.
+type ClientSession struct {
+	cs       *ChunkStream
+	cw       *ChunkWriter
+	tid      float64
+	streamID uint32
+
+	// WindowAckSize and PeerBandwidth are the last values the server sent.
+	WindowAckSize uint32
+	PeerBandwidth uint32
+}
+
+// ConnectResult is the server's answer to connect.
+type ConnectResult struct {
+	Properties map[string]interface{} // e.g. fmsVer and capabilities
+	Info       map[string]interface{} // level, code and description
+}
+
+// StatusError is an onStatus or _error answer that refused a command.
+type StatusError struct {
+	Code        string
+	Description string
+	Redirect    string // URL a rejected connect was redirected to, if any
+}
+
+func (e *StatusError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func NewClientSession(cs *ChunkStream, w io.Writer) *ClientSession {
+	return &ClientSession{
+		cs: cs,
+		cw: NewChunkWriter(w),
+	}
+}
+
+// ChunkSizes returns the chunk sizes in use for reading and writing.
+func (c *ClientSession) ChunkSizes() (in, out uint32) {
+	return c.cs.rxChunkSize, c.cw.ChunkSize()
+}
+
+// Connect sends connect for app and waits for the result. A refusal is
+// returned as a *StatusError. After a successful connect the client's chunk
+// size is raised to 4096.
+func (c *ClientSession) Connect(app, tcURL string) (*ConnectResult, error) {
+	obj := map[string]interface{}{
+		"app":           app,
+		"type":          "nonprivate",
+		"flashVer":      "FMLE/3.0 (compatible; ffmpeg-go-relay)",
+		"tcUrl":         tcURL,
+		"fpad":          false,
+		"capabilities":  15.0,
+		"audioCodecs":   3191.0,
+		"videoCodecs":   252.0,
+		"videoFunction": 1.0,
+	}
+	vals, err := c.call("connect", obj)
+	if err != nil {
+		return nil, err
+	}
+	res := &ConnectResult{}
+	if len(vals) > 2 {
+		res.Properties, _ = vals[2].(map[string]interface{})
+	}
+	if len(vals) > 3 {
+		res.Info, _ = vals[3].(map[string]interface{})
+	}
+	if err := c.cw.WriteSetChunkSize(clientChunkSize); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Publish creates a stream and publishes name on it as a live stream,
+// returning the server's onStatus info once it answers
+// NetStream.Publish.Start.
+func (c *ClientSession) Publish(name string) (map[string]interface{}, error) {
+	// Sent without waiting for answers, like most encoders.
+	for _, cmd := range []string{"releaseStream", "FCPublish"} {
+		c.tid++
+		if err := c.writeCommand(0, cmd, c.tid, nil, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.createStream(); err != nil {
+		return nil, err
+	}
+	if err := c.writeCommand(c.streamID, "publish", 0, nil, name, "live"); err != nil {
+		return nil, err
+	}
+	return c.waitStatus("NetStream.Publish.Start")
+}
+
+// Unpublish tells the server a publish of name is over.
+func (c *ClientSession) Unpublish(name string) error {
+	c.tid++
+	if err := c.writeCommand(0, "FCUnpublish", c.tid, nil, name); err != nil {
+		return err
+	}
+	c.tid++
+	return c.writeCommand(0, "deleteStream", c.tid, nil, float64(c.streamID))
+}
+
+// Play creates a stream and plays name on it, returning the server's
+// onStatus info once it answers NetStream.Play.Start.
+func (c *ClientSession) Play(name string) (map[string]interface{}, error) {
+	if err := c.createStream(); err != nil {
+		return nil, err
+	}
+	if err := c.writeCommand(c.streamID, "play", 0, nil, name, -2000.0); err != nil {
+		return nil, err
+	}
+	return c.waitStatus("NetStream.Play.Start")
+}
+
+// WriteMedia sends an audio, video or data message on the published stream.
+// Data messages such as @setDataFrame go out as AMF0 data.
+func (c *ClientSession) WriteMedia(msg *Message) error {
+	h := msg.Header
+	h.StreamID = c.streamID
+	switch h.TypeID {
+	case TypeAudio:
+		h.CSID = CSIDAudio
+	case TypeVideo:
+		h.CSID = CSIDVideo
+	default:
+		h.TypeID = TypeAMF0Data
+		h.CSID = CSIDAudio
+	}
+	return c.cw.WriteMessage(&Message{Header: h, Payload: msg.Payload})
+}
+
+// ReadMessage returns the next message from the server, for callers that
+// keep reading once publishing or playing has started. It may run
+// concurrently with WriteMedia, but not with the other methods.
+func (c *ClientSession) ReadMessage() (*Message, error) {
+	msg, err := c.cs.ReadMessage()
+	if err == nil {
+		c.noteControl(msg)
+	}
+	return msg, err
+}
+
+func (c *ClientSession) createStream() error {
+	vals, err := c.call("createStream", nil)
+	if err != nil {
+		return err
+	}
+	id, ok := 0.0, len(vals) > 3
+	if ok {
+		id, ok = vals[3].(float64)
+	}
+	if !ok {
+		return fmt.Errorf("rtmp: createStream result has no stream id")
+	}
+	c.streamID = uint32(id)
+	return nil
+}
+
+// call sends a command with a new transaction ID and waits for its _result
+// or _error.
+func (c *ClientSession) call(name string, args ...interface{}) ([]interface{}, error) {
+	c.tid++
+	tid := c.tid
+	if err := c.writeCommand(0, name, tid, args...); err != nil {
+		return nil, err
+	}
+	for {
+		vals, err := c.readCommand()
+		if err != nil {
+			return nil, fmt.Errorf("rtmp: wait %s result: %w", name, err)
+		}
+		if len(vals) < 2 || transactionID(vals) != tid {
+			continue
+		}
+		switch vals[0] {
+		case "_result":
+			return vals, nil
+		case "_error":
+			return nil, statusError(vals, name+" failed")
+		}
+	}
+}
+
+// waitStatus reads until an onStatus arrives with want or with level error.
+func (c *ClientSession) waitStatus(want string) (map[string]interface{}, error) {
+	for {
+		vals, err := c.readCommand()
+		if err != nil {
+			return nil, fmt.Errorf("rtmp: wait %s: %w", want, err)
+		}
+		if len(vals) < 4 || vals[0] != "onStatus" {
+			continue
+		}
+		info, _ := vals[3].(map[string]interface{})
+		code, _ := info["code"].(string)
+		if code == want {
+			return info, nil
+		}
+		if level, _ := info["level"].(string); level == "error" {
+			return nil, statusError(vals, want+" refused")
+		}
+	}
+}
+
+// readCommand returns the next AMF0 or AMF3-wrapped command, handling the
+// protocol control messages that arrive in between.
+func (c *ClientSession) readCommand() ([]interface{}, error) {
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		payload := msg.Payload
+		switch msg.Header.TypeID {
+		case TypeAMF0Command:
+		case TypeAMF20Command:
+			if len(payload) == 0 || payload[0] != 0 {
+				return nil, errclass.Protocolf("", "unsupported AMF3 payload")
+			}
+			payload = payload[1:]
+		default:
+			continue
+		}
+		vals, err := DecodeAMF0(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) > 0 {
+			return vals, nil
+		}
+	}
+}
+
+// noteControl records the flow control settings the server announces.
+func (c *ClientSession) noteControl(msg *Message) {
+	if len(msg.Payload) < 4 {
+		return
+	}
+	switch msg.Header.TypeID {
+	case TypeWindowAck:
+		c.WindowAckSize = binary.BigEndian.Uint32(msg.Payload)
+	case TypeSetPeerBW:
+		c.PeerBandwidth = binary.BigEndian.Uint32(msg.Payload)
+	}
+}
+
+func (c *ClientSession) writeCommand(streamID uint32, name string, tid float64, args ...interface{}) error {
+	buf := new(bytes.Buffer)
+	EncodeAMF0(buf, name, tid)
+	EncodeAMF0(buf, args...)
+	return c.cw.WriteMessage(&Message{
+		Header:  ChunkHeader{CSID: CSIDCommand, TypeID: TypeAMF0Command, StreamID: streamID},
+		Payload: buf.Bytes(),
+	})
+}
+
+// statusError builds a StatusError from the info object of an _error or
+// onStatus command, falling back to fallback when it has no code.
+func statusError(vals []interface{}, fallback string) *StatusError {
+	var info map[string]interface{}
+	if len(vals) > 3 {
+		info, _ = vals[3].(map[string]interface{})
+	}
+	e := &StatusError{Code: fallback}
+	if code, ok := info["code"].(string); ok && code != "" {
+		e.Code = code
+	}
+	e.Description, _ = info["description"].(string)
+	if ex, ok := info["ex"].(map[string]interface{}); ok {
+		e.Redirect, _ = ex["redirect"].(string)
+	}
+	if e.Redirect == "" {
+		e.Redirect, _ = info["redirect"].(string)
+	}
+	return e
+}
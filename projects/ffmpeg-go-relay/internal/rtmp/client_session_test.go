@@ -0,0 +1,144 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// serveClient runs a ServerSession against a ClientSession over a pipe and
+// returns what the server saw publishing.
+func serveClient(t *testing.T, decide func(string) string) (*ClientSession, <-chan string, func() *Message) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	names := make(chan string, 1)
+	cs := NewChunkStream(server)
+	go func() {
+		ss := NewServerSession(cs, server)
+		connect, err := ss.expectCommand("connect")
+		if err != nil {
+			close(names)
+			return
+		}
+		name, err := ss.AcceptPublish(connect, decide)
+		if err != nil {
+			close(names)
+			return
+		}
+		names <- name
+	}()
+	next := func() *Message {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			t.Fatalf("server read: %v", err)
+		}
+		return msg
+	}
+	return NewClientSession(NewChunkStream(client), client), names, next
+}
+
+func TestClientSessionPublish(t *testing.T) {
+	c, names, next := serveClient(t, nil)
+
+	res, err := c.Connect("live", "rtmp://relay/live")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if res.Info["code"] != "NetConnection.Connect.Success" || res.Properties["fmsVer"] == nil {
+		t.Fatalf("connect result = %+v", res)
+	}
+	if _, err := c.Publish("cam1"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if name := <-names; name != "cam1" {
+		t.Fatalf("server saw publish of %q", name)
+	}
+	if in, out := c.ChunkSizes(); in != serverChunkSize || out != clientChunkSize {
+		t.Fatalf("chunk sizes = %d/%d", in, out)
+	}
+	if c.WindowAckSize != 2500000 {
+		t.Fatalf("window ack size = %d", c.WindowAckSize)
+	}
+
+	frame := &Message{Header: ChunkHeader{TypeID: TypeVideo, Timestamp: 40}, Payload: bytes.Repeat([]byte{0x17}, 5000)}
+	go c.WriteMedia(frame)
+	msg := next()
+	if msg.Header.TypeID != TypeVideo || msg.Header.StreamID != 1 || msg.Header.Timestamp != 40 || len(msg.Payload) != 5000 {
+		t.Fatalf("server got %+v with %d bytes", msg.Header, len(msg.Payload))
+	}
+}
+
+func TestClientSessionPublishRejected(t *testing.T) {
+	c, _, _ := serveClient(t, func(string) string { return "stream limit reached" })
+	if _, err := c.Connect("live", "rtmp://relay/live"); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	_, err := c.Publish("cam1")
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != "NetStream.Publish.Rejected" || se.Description != "stream limit reached" {
+		t.Fatalf("Publish error = %v", err)
+	}
+}
+
+func TestFLVReaderReadsWrittenTags(t *testing.T) {
+	var file bytes.Buffer
+	if err := WriteFLVHeader(&file, true, true); err != nil {
+		t.Fatal(err)
+	}
+	in := []*Message{
+		{Header: ChunkHeader{TypeID: TypeAMF0Data}, Payload: []byte{MarkerString, 0, 1, 'x'}},
+		{Header: ChunkHeader{TypeID: TypeVideo, Timestamp: 0x01020304}, Payload: []byte{0x17, 0, 0, 0, 0}},
+		{Header: ChunkHeader{TypeID: TypeAudio, Timestamp: 23}, Payload: []byte{0xaf, 1, 2}},
+	}
+	for _, msg := range in {
+		if err := MessageToFLVTag(&file, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewFLVReader(&file)
+	for i, want := range in {
+		got, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("tag %d: %v", i, err)
+		}
+		if got.Header.TypeID != want.Header.TypeID || got.Header.Timestamp != want.Header.Timestamp || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("tag %d = %+v %x, want %+v %x", i, got.Header, got.Payload, want.Header, want.Payload)
+		}
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if _, err := NewFLVReader(bytes.NewReader([]byte("GIF89a..."))).ReadMessage(); err == nil {
+		t.Fatal("expected a non-FLV file to fail")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	cases := []struct {
+		raw                   string
+		addr, app, stream, tc string
+	}{
+		{"rtmp://relay/live/cam1", "relay:1935", "live", "cam1", "rtmp://relay:1935/live"},
+		{"rtmps://relay:8443/live?token=x/cam1?key=y", "relay:8443", "live?token=x", "cam1?key=y", "rtmps://relay:8443/live?token=x"},
+		{"RTMP://[::1]/app", "[::1]:1935", "app", "", "rtmp://[::1]:1935/app"},
+	}
+	for _, c := range cases {
+		u, err := ParseURL(c.raw)
+		if err != nil {
+			t.Fatalf("ParseURL(%q): %v", c.raw, err)
+		}
+		if u.Address != c.addr || u.App != c.app || u.Stream != c.stream || u.TCURL() != c.tc {
+			t.Fatalf("ParseURL(%q) = %+v, tcUrl %q", c.raw, u, u.TCURL())
+		}
+	}
+	for _, raw := range []string{"relay/live/cam1", "http://relay/live", "rtmp:///live", "rtmp://relay/"} {
+		if _, err := ParseURL(raw); err == nil {
+			t.Errorf("ParseURL(%q) succeeded", raw)
+		}
+	}
+}
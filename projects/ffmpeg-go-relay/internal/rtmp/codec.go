@@ -26,6 +26,16 @@ const (
 	AVCPacketNALU           = 1
 	AVCPacketEOS            = 2
 
+	// Enhanced RTMP video packet types, in the low nibble of the first byte
+	// when VideoExHeader is set
+	VideoExHeader                  = 0x80
+	PacketTypeSequenceStart        = 0
+	PacketTypeCodedFrames          = 1
+	PacketTypeSequenceEnd          = 2
+	PacketTypeCodedFramesX         = 3 // Coded frames without composition time
+	PacketTypeMetadata             = 4
+	PacketTypeMPEG2TSSequenceStart = 5
+
 	// Audio Formats
 	AudioLinearPCMPlatform = 0
 	AudioADPCM            = 1
@@ -39,12 +49,62 @@ const (
 	AudioMP38k            = 14
 )
 
+// Enhanced RTMP video FourCCs
+const (
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP9  = "vp09"
+)
+
 // VideoHeader represents the parsed FLV Video Tag Header
 type VideoHeader struct {
 	FrameType       uint8
-	CodecID         uint8
-	AVCPacketType   uint8 // Only if CodecID == VideoAVC
-	CompositionTime int32 // Only if CodecID == VideoAVC
+	CodecID         uint8 // Legacy codec ID; 0 for enhanced headers
+	AVCPacketType   uint8 // Only if CodecID == VideoAVC or VideoHEVC
+	CompositionTime int32 // Only for AVC NALUs and HEVC coded frames
+
+	// Enhanced RTMP extended header
+	Enhanced   bool
+	FourCC     string
+	PacketType uint8
+}
+
+// Codec names the video codec, e.g. "h264", "hevc" or "av1".
+func (h *VideoHeader) Codec() string {
+	if h.Enhanced {
+		switch h.FourCC {
+		case FourCCHEVC:
+			return "hevc"
+		case FourCCAV1:
+			return "av1"
+		case FourCCVP9:
+			return "vp9"
+		}
+		return h.FourCC
+	}
+	switch h.CodecID {
+	case VideoAVC:
+		return "h264"
+	case VideoHEVC:
+		return "hevc"
+	case VideoSorenson:
+		return "h263"
+	case VideoOn2VP6, VideoOn2VP6Alpha:
+		return "vp6"
+	case VideoJPEG:
+		return "jpeg"
+	case VideoScreen, VideoScreenV2:
+		return "screen"
+	}
+	return fmt.Sprintf("codec-%d", h.CodecID)
+}
+
+// IsSequenceHeader reports decoder configuration rather than a frame.
+func (h *VideoHeader) IsSequenceHeader() bool {
+	if h.Enhanced {
+		return h.PacketType == PacketTypeSequenceStart || h.PacketType == PacketTypeMPEG2TSSequenceStart
+	}
+	return (h.CodecID == VideoAVC || h.CodecID == VideoHEVC) && h.AVCPacketType == AVCPacketSequenceHeader
 }
 
 // AudioHeader represents the parsed FLV Audio Tag Header
@@ -56,6 +116,25 @@ type AudioHeader struct {
 	AACPacketType uint8 // Only if Format == AudioAAC
 }
 
+// Codec names the audio codec, e.g. "aac" or "mp3".
+func (h *AudioHeader) Codec() string {
+	switch h.Format {
+	case AudioAAC:
+		return "aac"
+	case AudioMP3, AudioMP38k:
+		return "mp3"
+	case AudioLinearPCMPlatform, AudioLinearPCMLittle:
+		return "pcm"
+	case AudioADPCM:
+		return "adpcm"
+	case AudioNellymoser16k, AudioNellymoser8k, AudioNellymoser:
+		return "nellymoser"
+	case AudioSpeex:
+		return "speex"
+	}
+	return fmt.Sprintf("codec-%d", h.Format)
+}
+
 // ParseVideoHeader parses the first 1-5 bytes of a video payload
 func ParseVideoHeader(payload []byte) (*VideoHeader, error) {
 	if len(payload) < 1 {
@@ -63,6 +142,9 @@ func ParseVideoHeader(payload []byte) (*VideoHeader, error) {
 	}
 
 	b := payload[0]
+	if b&VideoExHeader != 0 {
+		return parseExVideoHeader(payload)
+	}
 	frameType := (b >> 4) & 0x0F
 	codecID := b & 0x0F
 
@@ -71,7 +153,7 @@ func ParseVideoHeader(payload []byte) (*VideoHeader, error) {
 		CodecID:   codecID,
 	}
 
-	if codecID == VideoAVC {
+	if codecID == VideoAVC || codecID == VideoHEVC {
 		if len(payload) < 2 {
 			return nil, fmt.Errorf("short avc payload")
 		}
@@ -91,6 +173,30 @@ func ParseVideoHeader(payload []byte) (*VideoHeader, error) {
 	return h, nil
 }
 
+// parseExVideoHeader parses an Enhanced RTMP video header: the frame type and
+// packet type share the first byte with the VideoExHeader bit, followed by
+// the codec FourCC.
+func parseExVideoHeader(payload []byte) (*VideoHeader, error) {
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("short enhanced video payload")
+	}
+	h := &VideoHeader{
+		FrameType:  (payload[0] >> 4) & 0x07,
+		Enhanced:   true,
+		PacketType: payload[0] & 0x0F,
+		FourCC:     string(payload[1:5]),
+	}
+	// Only HEVC coded frames carry a composition time offset.
+	if h.FourCC == FourCCHEVC && h.PacketType == PacketTypeCodedFrames && len(payload) >= 8 {
+		cts := int32(uint32(payload[5])<<16 | uint32(payload[6])<<8 | uint32(payload[7]))
+		if cts&0x800000 != 0 {
+			cts |= ^0xFFFFFF
+		}
+		h.CompositionTime = cts
+	}
+	return h, nil
+}
+
 // ParseAudioHeader parses the first 1-2 bytes of an audio payload
 func ParseAudioHeader(payload []byte) (*AudioHeader, error) {
 	if len(payload) < 1 {
@@ -147,6 +253,19 @@ func (msg *Message) IsAVCSequenceHeader() bool {
 	return h.CodecID == VideoAVC && h.AVCPacketType == AVCPacketSequenceHeader
 }
 
+// IsVideoSequenceHeader reports decoder configuration for any codec that
+// sends one: AVC, legacy HEVC, and Enhanced RTMP sequence starts.
+func (msg *Message) IsVideoSequenceHeader() bool {
+	if msg.Header.TypeID != TypeVideo {
+		return false
+	}
+	h, err := ParseVideoHeader(msg.Payload)
+	if err != nil {
+		return false
+	}
+	return h.IsSequenceHeader()
+}
+
 func (msg *Message) IsAACSequenceHeader() bool {
 	if msg.Header.TypeID != TypeAudio {
 		return false
@@ -0,0 +1,54 @@
+package rtmp
+
+import "testing"
+
+func TestParseVideoHeader(t *testing.T) {
+	cases := []struct {
+		name     string
+		payload  []byte
+		codec    string
+		keyframe bool
+		seq      bool
+		cts      int32
+	}{
+		{"avc sequence header", []byte{0x17, 0, 0, 0, 0}, "h264", true, true, 0},
+		{"avc inter frame", []byte{0x27, 1, 0, 0, 0x21}, "h264", false, false, 33},
+		{"legacy hevc", []byte{0x1C, 0, 0, 0, 0}, "hevc", true, true, 0},
+		{"ertmp hevc sequence start", []byte{0x90, 'h', 'v', 'c', '1', 0x01}, "hevc", true, true, 0},
+		{"ertmp hevc coded frames", []byte{0x91, 'h', 'v', 'c', '1', 0xFF, 0xFF, 0xFE}, "hevc", true, false, -2},
+		{"ertmp av1 inter frame", []byte{0xA3, 'a', 'v', '0', '1', 0x12}, "av1", false, false, 0},
+		{"ertmp vp9 sequence start", []byte{0x90, 'v', 'p', '0', '9'}, "vp9", true, true, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, err := ParseVideoHeader(c.payload)
+			if err != nil {
+				t.Fatalf("ParseVideoHeader: %v", err)
+			}
+			if h.Codec() != c.codec || h.IsSequenceHeader() != c.seq || h.CompositionTime != c.cts {
+				t.Fatalf("header = %+v (codec %q, sequence header %v)", *h, h.Codec(), h.IsSequenceHeader())
+			}
+			msg := &Message{Header: ChunkHeader{TypeID: TypeVideo}, Payload: c.payload}
+			if msg.IsVideoKeyframe() != c.keyframe || msg.IsVideoSequenceHeader() != c.seq {
+				t.Fatalf("keyframe = %v, sequence header = %v", msg.IsVideoKeyframe(), msg.IsVideoSequenceHeader())
+			}
+		})
+	}
+
+	if _, err := ParseVideoHeader([]byte{0x90, 'h', 'v'}); err == nil {
+		t.Fatal("expected a truncated FourCC to fail")
+	}
+}
+
+func TestAudioHeaderCodec(t *testing.T) {
+	cases := map[byte]string{0xAF: "aac", 0x2F: "mp3", 0xEF: "mp3", 0x3F: "pcm", 0xBF: "speex", 0x7F: "codec-7"}
+	for b, want := range cases {
+		h, err := ParseAudioHeader([]byte{b, 1})
+		if err != nil {
+			t.Fatalf("ParseAudioHeader(%#x): %v", b, err)
+		}
+		if got := h.Codec(); got != want {
+			t.Errorf("Codec(%#x) = %q, want %q", b, got, want)
+		}
+	}
+}
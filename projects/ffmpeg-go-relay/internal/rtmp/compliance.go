@@ -0,0 +1,227 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// typeUserControl is the user control message type (RTMP spec 6.2).
+const typeUserControl = 4
+
+// protocolControlCSID is the chunk stream reserved for protocol control and
+// user control messages.
+const protocolControlCSID = 2
+
+// maxViolations caps how many violations a report keeps; Counts keeps
+// counting past it.
+const maxViolations = 100
+
+// Compliance rules reported by a Validator.
+const (
+	RuleLength    = "length"    // Payload size does not fit the message type
+	RuleChunk     = "chunk"     // Wrong chunk stream or message stream for the type
+	RuleTimestamp = "timestamp" // Timestamps went backwards within a stream
+	RuleCommand   = "command"   // Commands or media out of the expected sequence
+)
+
+// Violation is one departure from the RTMP specification.
+type Violation struct {
+	Rule      string `json:"rule"`
+	Detail    string `json:"detail"`
+	Message   int    `json:"message"` // 1-based index of the offending message
+	TypeID    uint8  `json:"type_id"`
+	CSID      uint32 `json:"csid"`
+	Timestamp uint32 `json:"timestamp"`
+}
+
+// ComplianceReport summarizes what a Validator has seen so far.
+type ComplianceReport struct {
+	Messages   int            `json:"messages"`
+	Compliant  bool           `json:"compliant"`
+	Counts     map[string]int `json:"counts,omitempty"` // Violations per rule
+	Violations []Violation    `json:"violations,omitempty"`
+}
+
+// Validator checks the messages a peer sends against the RTMP specification.
+// It only observes; the session carries on regardless, so encoders can be
+// tested against a real upstream. It is safe for concurrent use.
+type Validator struct {
+	mu         sync.Mutex
+	messages   int
+	counts     map[string]int
+	violations []Violation
+
+	connected  bool
+	createSent bool
+	publishing map[uint32]bool // Message streams with publish or play
+	lastTS     map[streamKey]uint32
+}
+
+type streamKey struct {
+	streamID uint32
+	typeID   uint8
+}
+
+// NewValidator returns a Validator for one session.
+func NewValidator() *Validator {
+	return &Validator{
+		counts:     make(map[string]int),
+		publishing: make(map[uint32]bool),
+		lastTS:     make(map[streamKey]uint32),
+	}
+}
+
+// Observe checks one message. It is meant to be installed with
+// ChunkStream.SetMessageHook.
+func (v *Validator) Observe(msg *Message) {
+	if msg == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.messages++
+
+	h := msg.Header
+	if int(h.Length) != len(msg.Payload) {
+		v.report(RuleLength, msg, "header length %d, payload %d bytes", h.Length, len(msg.Payload))
+	}
+
+	switch h.TypeID {
+	case TypeSetChunkSize, TypeAbortMessage, TypeAck, TypeWindowAck, TypeSetPeerBW, typeUserControl:
+		v.checkControl(msg)
+		return
+	}
+	if h.CSID == protocolControlCSID {
+		v.report(RuleChunk, msg, "type %d sent on the protocol control chunk stream", h.TypeID)
+	}
+
+	switch h.TypeID {
+	case TypeAMF0Command, TypeAMF20Command:
+		v.checkCommand(msg)
+	case TypeAudio, TypeVideo, TypeAMF0Data:
+		if h.StreamID == 0 || !v.publishing[h.StreamID] {
+			v.report(RuleCommand, msg, "media on message stream %d before publish", h.StreamID)
+		}
+		key := streamKey{h.StreamID, h.TypeID}
+		if last, ok := v.lastTS[key]; ok && h.Timestamp < last {
+			v.report(RuleTimestamp, msg, "timestamp %d after %d", h.Timestamp, last)
+		}
+		v.lastTS[key] = h.Timestamp
+	}
+}
+
+// Report returns a snapshot of the findings so far.
+func (v *Validator) Report() ComplianceReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := ComplianceReport{
+		Messages:   v.messages,
+		Compliant:  len(v.counts) == 0,
+		Violations: append([]Violation(nil), v.violations...),
+	}
+	if len(v.counts) > 0 {
+		r.Counts = make(map[string]int, len(v.counts))
+		for rule, n := range v.counts {
+			r.Counts[rule] = n
+		}
+	}
+	return r
+}
+
+// checkControl validates protocol and user control messages: fixed sizes,
+// chunk stream 2 and message stream 0.
+func (v *Validator) checkControl(msg *Message) {
+	h := msg.Header
+	if h.CSID != protocolControlCSID || h.StreamID != 0 {
+		v.report(RuleChunk, msg, "control type %d on chunk stream %d, message stream %d; want 2 and 0", h.TypeID, h.CSID, h.StreamID)
+	}
+
+	want := 4
+	switch h.TypeID {
+	case TypeSetPeerBW:
+		want = 5
+	case typeUserControl:
+		if len(msg.Payload) < 6 {
+			v.report(RuleLength, msg, "user control payload %d bytes, want at least 6", len(msg.Payload))
+		}
+		return
+	}
+	if len(msg.Payload) != want {
+		v.report(RuleLength, msg, "control type %d payload %d bytes, want %d", h.TypeID, len(msg.Payload), want)
+		return
+	}
+	if h.TypeID == TypeSetChunkSize {
+		if size := binary.BigEndian.Uint32(msg.Payload); size == 0 || size&0x80000000 != 0 {
+			v.report(RuleLength, msg, "chunk size %d out of range", size)
+		}
+	}
+}
+
+// checkCommand follows the NetConnection/NetStream sequence a publisher or
+// player is expected to send.
+func (v *Validator) checkCommand(msg *Message) {
+	payload := msg.Payload
+	if msg.Header.TypeID == TypeAMF20Command {
+		if len(payload) == 0 || payload[0] != 0 {
+			v.report(RuleCommand, msg, "AMF3 command without the AMF0 marker byte")
+			return
+		}
+		payload = payload[1:]
+	}
+	vals, err := DecodeAMF0(bytes.NewReader(payload))
+	if err != nil || len(vals) < 2 {
+		v.report(RuleCommand, msg, "command is not an AMF0 name and transaction ID")
+		return
+	}
+	name, ok := vals[0].(string)
+	if _, isNum := vals[1].(float64); !ok || !isNum {
+		v.report(RuleCommand, msg, "command is not an AMF0 name and transaction ID")
+		return
+	}
+
+	streamID := msg.Header.StreamID
+	switch name {
+	case "connect":
+		if v.connected {
+			v.report(RuleCommand, msg, "repeated connect")
+		}
+		if streamID != 0 {
+			v.report(RuleCommand, msg, "connect on message stream %d", streamID)
+		}
+		v.connected = true
+		return
+	case "publish", "play":
+		if !v.createSent {
+			v.report(RuleCommand, msg, "%s before createStream", name)
+		}
+		if streamID == 0 {
+			v.report(RuleCommand, msg, "%s on message stream 0", name)
+		} else {
+			v.publishing[streamID] = true
+		}
+	case "createStream":
+		v.createSent = true
+	case "deleteStream", "closeStream":
+		delete(v.publishing, streamID)
+	}
+	if !v.connected {
+		v.report(RuleCommand, msg, "%s before connect", name)
+	}
+}
+
+func (v *Validator) report(rule string, msg *Message, format string, args ...any) {
+	v.counts[rule]++
+	if len(v.violations) >= maxViolations {
+		return
+	}
+	v.violations = append(v.violations, Violation{
+		Rule:      rule,
+		Detail:    fmt.Sprintf(format, args...),
+		Message:   v.messages,
+		TypeID:    msg.Header.TypeID,
+		CSID:      msg.Header.CSID,
+		Timestamp: msg.Header.Timestamp,
+	})
+}
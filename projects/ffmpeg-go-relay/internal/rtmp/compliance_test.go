@@ -0,0 +1,84 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func commandMsg(t *testing.T, streamID uint32, values ...interface{}) *Message {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeAMF0(&buf, values...); err != nil {
+		t.Fatalf("EncodeAMF0: %v", err)
+	}
+	return &Message{
+		Header:  ChunkHeader{CSID: 3, TypeID: TypeAMF0Command, StreamID: streamID, Length: uint32(buf.Len())},
+		Payload: buf.Bytes(),
+	}
+}
+
+func mediaMsg(typeID uint8, streamID, ts uint32) *Message {
+	return &Message{
+		Header:  ChunkHeader{CSID: 6, TypeID: typeID, StreamID: streamID, Timestamp: ts, Length: 2},
+		Payload: []byte{0xaf, 0x01},
+	}
+}
+
+func TestValidatorCompliantPublish(t *testing.T) {
+	v := NewValidator()
+	v.Observe(&Message{Header: ChunkHeader{CSID: 2, TypeID: TypeSetChunkSize, Length: 4}, Payload: []byte{0, 0, 0x10, 0}})
+	v.Observe(commandMsg(t, 0, "connect", 1.0, map[string]interface{}{"app": "live"}))
+	v.Observe(commandMsg(t, 0, "createStream", 2.0, nil))
+	v.Observe(commandMsg(t, 1, "publish", 0.0, nil, "key", "live"))
+	v.Observe(mediaMsg(TypeVideo, 1, 0))
+	v.Observe(mediaMsg(TypeAudio, 1, 0))
+	v.Observe(mediaMsg(TypeVideo, 1, 40))
+
+	r := v.Report()
+	if !r.Compliant || r.Messages != 7 {
+		t.Fatalf("report = %+v, want 7 compliant messages", r)
+	}
+}
+
+func TestValidatorFindsViolations(t *testing.T) {
+	v := NewValidator()
+	// Window ack size on the wrong chunk stream with a short payload.
+	v.Observe(&Message{Header: ChunkHeader{CSID: 3, TypeID: TypeWindowAck, Length: 2}, Payload: []byte{0, 1}})
+	v.Observe(commandMsg(t, 0, "publish", 0.0, nil, "key"))
+	v.Observe(commandMsg(t, 0, "connect", 1.0, nil))
+	v.Observe(mediaMsg(TypeVideo, 1, 100))
+	v.Observe(mediaMsg(TypeVideo, 1, 50))
+
+	r := v.Report()
+	if r.Compliant {
+		t.Fatal("expected violations")
+	}
+	want := map[string]int{RuleChunk: 1, RuleLength: 1, RuleCommand: 5, RuleTimestamp: 1}
+	for rule, n := range want {
+		if r.Counts[rule] != n {
+			t.Fatalf("counts = %v, want %v", r.Counts, want)
+		}
+	}
+	if r.Violations[0].Message != 1 || r.Violations[0].TypeID != TypeWindowAck {
+		t.Fatalf("first violation = %+v", r.Violations[0])
+	}
+}
+
+func TestChunkStreamMessageHook(t *testing.T) {
+	var wire bytes.Buffer
+	w := NewChunkWriter(&wire)
+	if err := w.WriteMessage(commandMsg(t, 0, "connect", 1.0, nil)); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	var seen []*Message
+	cs := NewChunkStream(&wire)
+	cs.SetMessageHook(func(m *Message) { seen = append(seen, m) })
+	msg, err := cs.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != msg {
+		t.Fatalf("hook saw %d messages, want the one returned", len(seen))
+	}
+}
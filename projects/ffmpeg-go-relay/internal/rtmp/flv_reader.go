@@ -0,0 +1,78 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FLVReader reads the tags of an FLV file as RTMP messages, the reverse of
+// MessageToFLVTag.
+type FLVReader struct {
+	r      *bufio.Reader
+	header bool
+}
+
+func NewFLVReader(r io.Reader) *FLVReader {
+	return &FLVReader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage returns the next audio, video or script tag. Script tags come
+// back as AMF0 data messages. It returns io.EOF at the end of the file.
+func (f *FLVReader) ReadMessage() (*Message, error) {
+	if !f.header {
+		if err := f.readHeader(); err != nil {
+			return nil, err
+		}
+		f.header = true
+	}
+	for {
+		var hdr [11]byte
+		if _, err := io.ReadFull(f.r, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("flv: truncated tag header")
+			}
+			return nil, err
+		}
+		tagType := hdr[0] & 0x1F // The upper bits flag filtered (encrypted) tags
+		size := bigUint24(hdr[1:4])
+		ts := bigUint24(hdr[4:7]) | uint32(hdr[7])<<24
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(f.r, payload); err != nil {
+			return nil, fmt.Errorf("flv: truncated tag: %w", err)
+		}
+		// PreviousTagSize; files cut off right after the last tag still play.
+		if _, err := io.CopyN(io.Discard, f.r, 4); err != nil && err != io.EOF {
+			return nil, err
+		}
+		switch tagType {
+		case TagTypeAudio, TagTypeVideo:
+		case TagTypeScript:
+			tagType = TypeAMF0Data
+		default:
+			continue
+		}
+		return &Message{
+			Header:  ChunkHeader{Timestamp: ts, Length: size, TypeID: tagType},
+			Payload: payload,
+		}, nil
+	}
+}
+
+func (f *FLVReader) readHeader() error {
+	var hdr [9]byte
+	if _, err := io.ReadFull(f.r, hdr[:]); err != nil {
+		return fmt.Errorf("flv: read header: %w", err)
+	}
+	if hdr[0] != 'F' || hdr[1] != 'L' || hdr[2] != 'V' {
+		return fmt.Errorf("flv: not an FLV file")
+	}
+	// The header may be followed by extensions before PreviousTagSize0.
+	skip := int64(binary.BigEndian.Uint32(hdr[5:9])) - 9 + 4
+	if skip < 4 {
+		return fmt.Errorf("flv: bad header size")
+	}
+	_, err := io.CopyN(io.Discard, f.r, skip)
+	return err
+}
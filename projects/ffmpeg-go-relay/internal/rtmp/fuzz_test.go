@@ -0,0 +1,154 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"ffmpeg-go-relay/internal/pool"
+)
+
+// amfSeeds returns AMF0 payloads of the commands a relay sees, plus a few
+// malformed ones, to seed FuzzDecodeAMF0.
+func amfSeeds(t testing.TB) [][]byte {
+	t.Helper()
+	commands := [][]interface{}{
+		{"connect", 1.0, map[string]interface{}{"app": "live", "tcUrl": "rtmp://relay/live", "flashVer": "FMLE/3.0", "fpad": false, "objectEncoding": 0.0}},
+		{"createStream", 2.0, nil},
+		{"publish", 4.0, nil, "cam1?token=a", "live"},
+		{"play", 4.0, nil, "cam1", -2000.0},
+		{"_result", 1.0, map[string]interface{}{"fmsVer": "FMS/3,0,1,123"}, map[string]interface{}{"level": "status", "code": "NetConnection.Connect.Success"}},
+		{"onMetaData", map[string]interface{}{"width": 1920.0, "height": 1080.0, "videocodecid": 7.0}},
+	}
+	var seeds [][]byte
+	for _, values := range commands {
+		var buf bytes.Buffer
+		if err := EncodeAMF0(&buf, values...); err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, buf.Bytes())
+	}
+	return append(seeds,
+		nil,
+		[]byte{MarkerString, 0xff, 0xff, 'x'}, // String longer than its payload
+		[]byte{MarkerECMAArray, 0xff, 0xff, 0xff, 0xff, 0, 0, MarkerObjectEnd}, // Count far off
+		[]byte{MarkerObject, 0, 1, 'a', MarkerObject, 0, 1, 'b', MarkerObject}, // Nested, unterminated
+		[]byte{MarkerStrictArray, 0, 0, 0, 2, MarkerNull, MarkerNull},
+		[]byte{MarkerObjectEnd},
+		[]byte{0x7f},
+	)
+}
+
+// chunkSeeds returns chunk streams to seed FuzzChunkStream: messages split
+// across chunks on interleaved chunk streams, a chunk size change, an abort,
+// extended timestamps and every header format.
+func chunkSeeds(t testing.TB) [][]byte {
+	t.Helper()
+	var connect bytes.Buffer
+	if err := EncodeAMF0(&connect, "connect", 1.0, map[string]interface{}{"app": "live"}); err != nil {
+		t.Fatal(err)
+	}
+	video := bytes.Repeat([]byte{0x17, 0x01, 0, 0, 0}, 80)
+
+	var seeds [][]byte
+	write := func(fn func(cw *ChunkWriter) error) {
+		var buf bytes.Buffer
+		if err := fn(NewChunkWriter(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, buf.Bytes())
+	}
+	write(func(cw *ChunkWriter) error {
+		return cw.WriteMessage(&Message{Header: ChunkHeader{CSID: 3, TypeID: TypeAMF0Command}, Payload: connect.Bytes()})
+	})
+	write(func(cw *ChunkWriter) error {
+		for i, ts := range []uint32{0, 40, 80, 120} {
+			msg := &Message{Header: ChunkHeader{CSID: 6, TypeID: TypeVideo, StreamID: 1, Timestamp: ts}, Payload: video[:len(video)-i]}
+			if err := cw.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	write(func(cw *ChunkWriter) error {
+		if err := cw.WriteSetChunkSize(4096); err != nil {
+			return err
+		}
+		return cw.WriteMessage(&Message{Header: ChunkHeader{CSID: 4, TypeID: TypeAudio, StreamID: 1, Timestamp: 0xFFFFFF + 10}, Payload: video})
+	})
+
+	// Two messages interleaved chunk by chunk, then an abort of a third.
+	interleaved := append(fmt0Chunk(4, 200, TypeVideo, video[:DefaultChunkSize]), fmt0Chunk(5, 3, TypeAudio, []byte{0xaf, 1, 0})...)
+	interleaved = append(interleaved, 0xc4) // fmt 3 on chunk stream 4
+	interleaved = append(interleaved, video[:200-DefaultChunkSize]...)
+	interleaved = append(interleaved, fmt0Chunk(7, 1000, TypeVideo, video[:DefaultChunkSize])...)
+	interleaved = append(interleaved, fmt0Chunk(2, 4, TypeAbortMessage, []byte{0, 0, 0, 7})...)
+	seeds = append(seeds, interleaved)
+
+	return append(seeds,
+		nil,
+		fmt0Chunk(3, 0xFFFFFF, TypeVideo, nil), // Length far past the data
+		fmt0Chunk(2, 4, TypeSetChunkSize, []byte{0, 0, 0, 1}), // One-byte chunks
+		fmt0Chunk(2, 4, TypeSetChunkSize, []byte{0x7f, 0xff, 0xff, 0xff}),
+		[]byte{0xc3, 0x43, 0, 0, 1, 0x83},                                 // fmt 3 and fmt 2 on unknown streams
+		[]byte{0x40, 0, 0, 0, 0, 0, 0x10, TypeVideo, 0x80, 0x80},          // fmt 1 cut short
+		[]byte{0x01, 0xff, 0xff, 0, 0, 0, 0, 0, 0, TypeVideo, 0, 0, 0, 0}, // Three-byte basic header
+	)
+}
+
+func FuzzDecodeAMF0(f *testing.F) {
+	for _, seed := range amfSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, err := DecodeAMF0(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// Whatever decodes re-encodes and decodes the same way again.
+		var buf bytes.Buffer
+		if err := EncodeAMF0(&buf, values...); err != nil {
+			t.Fatalf("re-encode %v: %v", values, err)
+		}
+		if _, err := DecodeAMF0(&buf); err != nil {
+			t.Fatalf("decode re-encoded %v: %v", values, err)
+		}
+	})
+}
+
+func FuzzChunkStream(f *testing.F) {
+	for _, seed := range chunkSeeds(f) {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	limits := ChunkLimits{MaxMessageSize: 64 << 10, MaxChunkStreams: 8, MaxBufferedBytes: 128 << 10}
+	f.Fuzz(func(t *testing.T, data []byte, pooled bool) {
+		cs := NewChunkStreamWithLimits(bytes.NewReader(data), limits)
+		if pooled {
+			cs.SetPayloadPool(pool.New(256))
+		}
+		v := NewValidator()
+		cs.SetMessageHook(v.Observe)
+		for {
+			msg, err := cs.ReadMessage()
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrMessageTooLarge) &&
+					!errors.Is(err, ErrTooManyChunkStreams) && !errors.Is(err, ErrBufferLimit) && !errors.Is(err, ErrInvalidChunkSize) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if uint32(len(msg.Payload)) != msg.Header.Length {
+				t.Fatalf("payload of %d bytes, header says %d", len(msg.Payload), msg.Header.Length)
+			}
+			if cs.buffered < 0 || cs.buffered > limits.MaxBufferedBytes {
+				t.Fatalf("buffered %d bytes, limit %d", cs.buffered, limits.MaxBufferedBytes)
+			}
+			msg.IsVideoKeyframe()
+			msg.IsVideoSequenceHeader()
+			msg.IsAACSequenceHeader()
+			msg.Release()
+		}
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"io"
 	"time"
+
+	"ffmpeg-go-relay/internal/errclass"
 )
 
 const (
@@ -14,11 +16,32 @@ const (
 	handshakeSize = 1536
 )
 
+// HandshakeOptions overrides the time and randomness sources used to build
+// handshake packets. Options may be shared by concurrent handshakes, so Rand
+// must be safe for concurrent use. Nil fields fall back to the wall clock and
+// crypto/rand.
 type HandshakeOptions struct {
 	Now  func() uint32
 	Rand io.Reader
 }
 
+// DeterministicHandshake returns options that make every handshake
+// byte-identical: a zero epoch and an all-zero random section. Only for
+// tests and record/replay tooling; it offers no protection against replay.
+func DeterministicHandshake() *HandshakeOptions {
+	return &HandshakeOptions{
+		Now:  func() uint32 { return 0 },
+		Rand: zeroReader{},
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 // ClientHandshake performs Client side handshake (Simple or Complex)
 // Currently defaults to Simple.
 func ClientHandshake(rw io.ReadWriter, opts *HandshakeOptions) error {
@@ -35,7 +58,7 @@ func ServerHandshake(rw io.ReadWriter, opts *HandshakeOptions) error {
 		return err
 	}
 	if c0[0] != versionByte {
-		return errors.New("rtmp: invalid client version")
+		return &errclass.ProtocolError{Err: errors.New("rtmp: invalid client version")}
 	}
 
 	// Read C1 (1536 bytes)
@@ -221,7 +244,7 @@ func simpleClientHandshake(rw io.ReadWriter, opts *HandshakeOptions) error {
 		return err
 	}
 	if s0[0] != versionByte {
-		return errors.New("rtmp: invalid server version")
+		return &errclass.ProtocolError{Err: errors.New("rtmp: invalid server version")}
 	}
 
 	s1 := make([]byte, handshakeSize)
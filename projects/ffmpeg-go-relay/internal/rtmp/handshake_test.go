@@ -2,6 +2,7 @@ package rtmp
 
 import (
 	"bytes"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -48,3 +49,38 @@ func TestHandshake(t *testing.T) {
 		}
 	}
 }
+
+func TestDeterministicHandshakeIsByteIdentical(t *testing.T) {
+	run := func() []byte {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		serverErr := make(chan error, 1)
+		go func() {
+			serverErr <- ServerHandshake(serverConn, DeterministicHandshake())
+		}()
+
+		// Drive the client side by hand so the server's S0/S1/S2 can be captured.
+		c1 := make([]byte, 1+handshakeSize)
+		c1[0] = versionByte
+		if _, err := clientConn.Write(c1); err != nil {
+			t.Fatalf("write c0c1: %v", err)
+		}
+		resp := make([]byte, 1+2*handshakeSize)
+		if _, err := io.ReadFull(clientConn, resp); err != nil {
+			t.Fatalf("read s0s1s2: %v", err)
+		}
+		if _, err := clientConn.Write(make([]byte, handshakeSize)); err != nil {
+			t.Fatalf("write c2: %v", err)
+		}
+		if err := <-serverErr; err != nil {
+			t.Fatalf("server handshake: %v", err)
+		}
+		return resp
+	}
+
+	if first, second := run(), run(); !bytes.Equal(first, second) {
+		t.Fatal("deterministic handshakes produced different bytes")
+	}
+}
@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+
+	"ffmpeg-go-relay/internal/errclass"
 )
 
+// serverChunkSize is the chunk size announced to publishers after connect.
+const serverChunkSize = 4096
+
 // ServerSession handles the server-side RTMP handshake commands.
 type ServerSession struct {
 	cs *ChunkStream
-	w  io.Writer
+	cw *ChunkWriter
 }
 
 func NewServerSession(cs *ChunkStream, w io.Writer) *ServerSession {
 	return &ServerSession{
 		cs: cs,
-		w:  w,
+		cw: NewChunkWriter(w),
 	}
 }
 
@@ -27,9 +32,33 @@ func (s *ServerSession) Handshake() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("wait connect: %w", err)
 	}
+	return s.Accept(cmd)
+}
 
-	// Extract transaction ID
-	tid, _ := cmd[1].(float64)
+// Accept answers a connect command the caller has already read and decoded,
+// then continues the handshake up to 'publish' like Handshake.
+func (s *ServerSession) Accept(connect []interface{}) (string, error) {
+	return s.negotiate(connect, nil)
+}
+
+// AcceptPublish is Accept with a say over the publish: decide gets the
+// requested stream name and returns why it is refused, or "" to allow it.
+// A refusal is answered like RejectPublish.
+func (s *ServerSession) AcceptPublish(connect []interface{}, decide func(stream string) string) (string, error) {
+	return s.negotiate(connect, decide)
+}
+
+// RejectPublish accepts the connection like Accept but answers 'publish' with
+// NetStream.Publish.Rejected, so the encoder is told why it cannot publish
+// rather than seeing the connection drop. It returns the requested stream name.
+func (s *ServerSession) RejectPublish(connect []interface{}, description string) (string, error) {
+	return s.negotiate(connect, func(string) string { return description })
+}
+
+// negotiate runs the command handshake up to 'publish' and answers it with
+// Publish.Start, or with Publish.Rejected when decide returns a reason.
+func (s *ServerSession) negotiate(connect []interface{}, decide func(stream string) string) (string, error) {
+	tid := transactionID(connect)
 
 	// Send Window Ack Size (2.5MB)
 	if err := s.writeProtocolControl(TypeWindowAck, 2500000); err != nil {
@@ -40,7 +69,7 @@ func (s *ServerSession) Handshake() (string, error) {
 		return "", err
 	}
 	// Send Set Chunk Size (4096)
-	if err := s.writeProtocolControl(TypeSetChunkSize, 4096); err != nil {
+	if err := s.cw.WriteSetChunkSize(serverChunkSize); err != nil {
 		return "", err
 	}
 
@@ -78,10 +107,10 @@ func (s *ServerSession) Handshake() (string, error) {
 		payload := msg.Payload
 		if msg.Header.TypeID == TypeAMF20Command {
 			if len(payload) == 0 {
-				return "", fmt.Errorf("empty AMF3 payload")
+				return "", errclass.Protocolf("", "empty AMF3 payload")
 			}
 			if payload[0] != 0 {
-				return "", fmt.Errorf("unsupported AMF3 payload")
+				return "", errclass.Protocolf("", "unsupported AMF3 payload")
 			}
 			payload = payload[1:]
 		}
@@ -95,7 +124,7 @@ func (s *ServerSession) Handshake() (string, error) {
 		}
 
 		name, _ := vals[0].(string)
-		tid, _ := vals[1].(float64)
+		tid := transactionID(vals)
 
 		switch name {
 		case "releaseStream":
@@ -117,6 +146,17 @@ func (s *ServerSession) Handshake() (string, error) {
 				"code":        "NetStream.Publish.Start",
 				"description": "Start publishing",
 			}
+			reject := ""
+			if decide != nil {
+				reject = decide(streamName)
+			}
+			if reject != "" {
+				status = map[string]interface{}{
+					"level":       "error",
+					"code":        "NetStream.Publish.Rejected",
+					"description": reject,
+				}
+			}
 			// onStatus transaction ID is usually 0
 			if err := s.writeCommand("onStatus", 0, nil, status); err != nil {
 				return "", err
@@ -126,6 +166,48 @@ func (s *ServerSession) Handshake() (string, error) {
 	}
 }
 
+// Reject answers a connect command with NetConnection.Connect.Rejected.
+func (s *ServerSession) Reject(connect []interface{}, description string) error {
+	return s.RejectCode(connect, "NetConnection.Connect.Rejected", description)
+}
+
+// RejectCode answers a connect command with an error carrying the given
+// NetConnection status code.
+func (s *ServerSession) RejectCode(connect []interface{}, code, description string) error {
+	info := map[string]interface{}{
+		"level":       "error",
+		"code":        code,
+		"description": description,
+	}
+	return s.writeCommand("_error", transactionID(connect), nil, info)
+}
+
+// Redirect answers a connect command with a rejection pointing the client
+// at url, in the form Adobe Media Server and Wowza use: code 302 and the
+// target under "ex", with the target also at the top level for clients that
+// only look there.
+func (s *ServerSession) Redirect(connect []interface{}, url, description string) error {
+	info := map[string]interface{}{
+		"level":       "error",
+		"code":        "NetConnection.Connect.Rejected",
+		"description": description,
+		"redirect":    url,
+		"ex": map[string]interface{}{
+			"code":     302.0,
+			"redirect": url,
+		},
+	}
+	return s.writeCommand("_error", transactionID(connect), nil, info)
+}
+
+func transactionID(cmd []interface{}) float64 {
+	if len(cmd) < 2 {
+		return 0
+	}
+	tid, _ := cmd[1].(float64)
+	return tid
+}
+
 func (s *ServerSession) expectCommand(name string) ([]interface{}, error) {
 	for {
 		msg, err := s.cs.ReadMessage()
@@ -136,10 +218,10 @@ func (s *ServerSession) expectCommand(name string) ([]interface{}, error) {
 			payload := msg.Payload
 			if msg.Header.TypeID == TypeAMF20Command {
 				if len(payload) == 0 {
-					return nil, fmt.Errorf("empty AMF3 payload")
+					return nil, errclass.Protocolf("", "empty AMF3 payload")
 				}
 				if payload[0] != 0 {
-					return nil, fmt.Errorf("unsupported AMF3 payload")
+					return nil, errclass.Protocolf("", "unsupported AMF3 payload")
 				}
 				payload = payload[1:]
 			}
@@ -178,69 +260,13 @@ func (s *ServerSession) writeProtocolControl(typeID uint8, val uint32, extra ...
 }
 
 func (s *ServerSession) sendMessage(typeID uint8, payload []byte) error {
-	// Simple Chunk Writer (Fmt 0, CSID 3 for commands)
-	// Chunk Size is assumed 128 (default) unless we changed it.
-	// But since we are the server, we use 128 for sending unless we sent SetChunkSize.
-	// We sent SetChunkSize=4096 earlier, so we can use larger chunks.
-	chunkSize := 4096
-
-	header := make([]byte, 12)
-	// Fmt 0, CSID 3 (Command) -> 00 000011 -> 0x03
-	// Or CSID 2 for Protocol Control? Usually 2.
-
-	csid := 3
-	if typeID < 17 { // Protocol Control
-		csid = 2
+	// Protocol control messages travel on CSID 2, commands on CSID 3.
+	csid := uint32(CSIDCommand)
+	if typeID < TypeAMF20Command {
+		csid = CSIDProtocolControl
 	}
-
-	header[0] = byte(csid & 0x3f) // Fmt 0
-
-	// Timestamp 0 (3 bytes)
-	header[1] = 0
-	header[2] = 0
-	header[3] = 0
-
-	// Length (3 bytes)
-	l := len(payload)
-	header[4] = byte(l >> 16)
-	header[5] = byte(l >> 8)
-	header[6] = byte(l)
-
-	// TypeID
-	header[7] = typeID
-
-	// StreamID (4 bytes LE) -> 0
-	header[8] = 0
-	header[9] = 0
-	header[10] = 0
-	header[11] = 0
-
-	// Write Header
-	if _, err := s.w.Write(header); err != nil {
-		return err
-	}
-
-	// Write Payload (Chunking if needed)
-	written := 0
-	for written < l {
-		end := written + chunkSize
-		if end > l {
-			end = l
-		}
-
-		if written > 0 {
-			// Write continuation header (Fmt 3, CSID)
-			h := byte(0xC0 | byte(csid)) // Fmt 3 = 11xxxxxx
-			if _, err := s.w.Write([]byte{h}); err != nil {
-				return err
-			}
-		}
-
-		if _, err := s.w.Write(payload[written:end]); err != nil {
-			return err
-		}
-		written = end
-	}
-
-	return nil
+	return s.cw.WriteMessage(&Message{
+		Header:  ChunkHeader{CSID: csid, TypeID: typeID},
+		Payload: payload,
+	})
 }
@@ -0,0 +1,84 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func writeTestCommand(t *testing.T, cw *ChunkWriter, args ...interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeAMF0(&buf, args...); err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Header: ChunkHeader{CSID: CSIDCommand, TypeID: TypeAMF0Command}, Payload: buf.Bytes()}
+	if err := cw.WriteMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejectPublishAnswersWithNetStreamStatus(t *testing.T) {
+	var in bytes.Buffer
+	cw := NewChunkWriter(&in)
+	writeTestCommand(t, cw, "createStream", 2.0, nil)
+	writeTestCommand(t, cw, "publish", 3.0, nil, "live-key", "live")
+
+	var out bytes.Buffer
+	connect := []interface{}{"connect", 1.0, map[string]interface{}{"app": "live"}}
+	name, err := NewServerSession(NewChunkStream(&in), &out).RejectPublish(connect, "transcoding capacity exhausted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "live-key" {
+		t.Fatalf("stream name = %q, want live-key", name)
+	}
+
+	replies := NewChunkStream(&out)
+	var last map[string]interface{}
+	for {
+		msg, err := replies.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msg.Header.TypeID != TypeAMF0Command {
+			continue
+		}
+		vals, err := DecodeAMF0(bytes.NewReader(msg.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vals[0] == "onStatus" && len(vals) >= 4 {
+			last, _ = vals[3].(map[string]interface{})
+		}
+	}
+	if last == nil {
+		t.Fatal("no onStatus sent")
+	}
+	if last["code"] != "NetStream.Publish.Rejected" || last["level"] != "error" || last["description"] != "transcoding capacity exhausted" {
+		t.Fatalf("onStatus = %v", last)
+	}
+}
+
+func TestAcceptPublishAsksBeforeAnswering(t *testing.T) {
+	var in bytes.Buffer
+	cw := NewChunkWriter(&in)
+	writeTestCommand(t, cw, "createStream", 2.0, nil)
+	writeTestCommand(t, cw, "publish", 3.0, nil, "cam1?key=x", "live")
+
+	var out bytes.Buffer
+	var asked string
+	connect := []interface{}{"connect", 1.0, map[string]interface{}{"app": "live"}}
+	name, err := NewServerSession(NewChunkStream(&in), &out).AcceptPublish(connect, func(stream string) string {
+		asked = stream
+		return "too many streams"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "cam1?key=x" || asked != name {
+		t.Fatalf("stream name = %q, decide saw %q", name, asked)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("NetStream.Publish.Rejected")) || !bytes.Contains(out.Bytes(), []byte("too many streams")) {
+		t.Fatal("refusal was not answered with Publish.Rejected")
+	}
+}
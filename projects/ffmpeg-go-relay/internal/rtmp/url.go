@@ -0,0 +1,65 @@
+package rtmp
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// URL is an rtmp:// or rtmps:// address of a stream, split the way clients
+// send it: the app in connect and the rest as the stream name.
+type URL struct {
+	Scheme  string
+	Address string // host:port, with the scheme's default port filled in
+	App     string // First path segment, with any query it carries
+	Stream  string // The rest of the path, with any query
+}
+
+// ParseURL splits raw, e.g. "rtmp://relay:1935/live?token=x/cam1". Stream is
+// empty when the URL names an app only.
+func ParseURL(raw string) (*URL, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, fmt.Errorf("rtmp: %q has no scheme", raw)
+	}
+	scheme = strings.ToLower(scheme)
+	port := "1935"
+	switch scheme {
+	case "rtmp":
+	case "rtmps":
+		port = "443"
+	default:
+		return nil, fmt.Errorf("rtmp: unsupported scheme %q", scheme)
+	}
+	host, path, _ := strings.Cut(rest, "/")
+	// The host is parsed alone, since queries on the app would otherwise be
+	// read as the URL's query.
+	u, err := url.Parse(scheme + "://" + host)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("rtmp: bad host in %q", raw)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	app, stream, _ := strings.Cut(path, "/")
+	if app == "" {
+		return nil, fmt.Errorf("rtmp: %q has no app", raw)
+	}
+	return &URL{
+		Scheme:  scheme,
+		Address: net.JoinHostPort(u.Hostname(), port),
+		App:     app,
+		Stream:  stream,
+	}, nil
+}
+
+// TLS reports whether the connection must be TLS wrapped.
+func (u *URL) TLS() bool {
+	return u.Scheme == "rtmps"
+}
+
+// TCURL returns the tcUrl sent in connect: the URL up to the app.
+func (u *URL) TCURL() string {
+	return u.Scheme + "://" + u.Address + "/" + u.App
+}
@@ -0,0 +1,469 @@
+package rtmpt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+)
+
+const (
+	contentType = "application/x-fcs"
+
+	// Polling interval hints sent as the first byte of send/idle responses.
+	minPollInterval = 0x01
+	maxPollInterval = 0x21
+
+	defaultIdleTimeout = 30 * time.Second
+	maxPendingOutput   = 8 * 1024 * 1024
+	maxPendingInput    = 8 * 1024 * 1024
+)
+
+// errInputOverflow means the client sent more than maxPendingInput bytes the
+// relay has not read yet.
+var errInputOverflow = errors.New("rtmpt: pending input limit exceeded")
+
+// ServeFunc handles a tunneled RTMP connection as if it were a raw TCP socket.
+type ServeFunc func(ctx context.Context, conn net.Conn) error
+
+// SessionStats reports polling activity for a tunnel session.
+type SessionStats struct {
+	ID           string `json:"id"`
+	ClientAddr   string `json:"client_addr"`
+	OpenedUnix   int64  `json:"opened_unix"`
+	LastPollUnix int64  `json:"last_poll_unix"`
+	Sends        int64  `json:"sends"`
+	Idles        int64  `json:"idles"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	Closed       bool   `json:"closed"`
+}
+
+type session struct {
+	conn     *tunnelConn
+	interval byte
+	stats    SessionStats
+	lastSeq  uint64 // Sequence number of the last command taken
+	started  bool   // lastSeq is set
+}
+
+// Handler implements RTMPT (RTMP tunneled over HTTP POST requests).
+// It serves /fcs/ident2, /open/1, /send/<id>/<seq>, /idle/<id>/<seq> and /close/<id>/<seq>.
+type Handler struct {
+	ctx         context.Context
+	serve       ServeFunc
+	log         *logger.Logger
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewHandler creates an RTMPT handler that passes each tunnel to serve.
+// Sessions that are not polled for idleTimeout are closed, checked until ctx
+// ends.
+func NewHandler(ctx context.Context, serve ServeFunc, log *logger.Logger, idleTimeout time.Duration) *Handler {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	h := &Handler{
+		ctx:         ctx,
+		serve:       serve,
+		log:         log,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*session),
+	}
+	go h.reapLoop()
+	return h
+}
+
+// reapLoop closes idle sessions every half idle timeout until the handler's
+// context ends, so abandoned tunnels go away without further requests.
+func (h *Handler) reapLoop() {
+	ticker := time.NewTicker(h.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.reapIdle()
+		}
+	}
+}
+
+// Paths returns the URL prefixes the handler must be mounted on.
+func (h *Handler) Paths() []string {
+	return []string{"/fcs/", "/open/", "/send/", "/idle/", "/close/"}
+}
+
+// ServeHTTP dispatches RTMPT commands.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "fcs":
+		// ident2 is a capability probe; Flash expects a 404 from non-FMS servers.
+		w.WriteHeader(http.StatusNotFound)
+	case "open":
+		h.handleOpen(w, r)
+	case "send", "idle", "close":
+		if len(parts) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		seq, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		h.handleCommand(w, r, parts[0], parts[1], seq)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Stats returns a snapshot of all tunnel sessions.
+func (h *Handler) Stats() []SessionStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]SessionStats, 0, len(h.sessions))
+	for _, sess := range h.sessions {
+		st := sess.stats
+		st.Closed = sess.conn.isClosed()
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func (h *Handler) handleOpen(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	id, err := newSessionID()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	conn := newTunnelConn(tunnelAddr(r.RemoteAddr), tunnelAddr(r.Host))
+	sess := &session{
+		conn:     conn,
+		interval: minPollInterval,
+		stats: SessionStats{
+			ID:           id,
+			ClientAddr:   r.RemoteAddr,
+			OpenedUnix:   now.Unix(),
+			LastPollUnix: now.Unix(),
+		},
+	}
+
+	h.mu.Lock()
+	h.sessions[id] = sess
+	h.mu.Unlock()
+
+	go func() {
+		defer conn.Close()
+		if err := h.serve(h.ctx, conn); err != nil && h.log != nil {
+			h.log.Warn("rtmpt session ended with error", "session", id, "err", err)
+		}
+	}()
+
+	if h.log != nil {
+		h.log.Info("rtmpt session opened", "session", id, "client", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, id+"\n")
+}
+
+func (h *Handler) handleCommand(w http.ResponseWriter, r *http.Request, cmd, id string, seq uint64) {
+	h.mu.Lock()
+	sess, ok := h.sessions[id]
+	h.mu.Unlock()
+	if !ok {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// A body cut short would corrupt the chunk stream, so one the session
+	// could never take is refused whole.
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPendingInput))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Commands are numbered in the order the client sent them; a repeated
+	// or overtaken one would feed its bytes twice or out of order. The
+	// check and the feed happen under one lock so concurrent requests
+	// cannot swap places in between.
+	h.mu.Lock()
+	if sess.started && seq <= sess.lastSeq {
+		h.mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sess.lastSeq, sess.started = seq, true
+	var fed error
+	if cmd != "close" && len(body) > 0 {
+		_, fed = sess.conn.feed(body)
+	}
+	h.mu.Unlock()
+
+	if cmd == "close" {
+		h.remove(id)
+		sess.conn.Close()
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte{0x00})
+		return
+	}
+
+	if fed != nil {
+		h.remove(id)
+		if errors.Is(fed, errInputOverflow) {
+			// The relay stopped reading; drop the tunnel rather than buffer more.
+			sess.conn.Close()
+			if h.log != nil {
+				h.log.Warn("rtmpt session closed, input not consumed", "session", id)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	out := sess.conn.drain()
+	if len(out) == 0 && sess.conn.isClosed() {
+		h.remove(id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.mu.Lock()
+	sess.stats.LastPollUnix = time.Now().Unix()
+	sess.stats.BytesIn += int64(len(body))
+	sess.stats.BytesOut += int64(len(out))
+	if cmd == "send" {
+		sess.stats.Sends++
+	} else {
+		sess.stats.Idles++
+	}
+	// Back off polling while the tunnel is quiet, snap back when data flows.
+	if len(out) > 0 || len(body) > 0 {
+		sess.interval = minPollInterval
+	} else if sess.interval < maxPollInterval {
+		sess.interval++
+	}
+	interval := sess.interval
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append([]byte{interval}, out...))
+}
+
+func (h *Handler) remove(id string) {
+	h.mu.Lock()
+	delete(h.sessions, id)
+	h.mu.Unlock()
+}
+
+// reapIdle closes sessions that have not been polled within the idle timeout.
+func (h *Handler) reapIdle() {
+	cutoff := time.Now().Add(-h.idleTimeout).Unix()
+
+	h.mu.Lock()
+	var expired []*session
+	for id, sess := range h.sessions {
+		if sess.stats.LastPollUnix < cutoff {
+			expired = append(expired, sess)
+			delete(h.sessions, id)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sess := range expired {
+		sess.conn.Close()
+		if h.log != nil {
+			h.log.Info("rtmpt session expired", "session", sess.stats.ID)
+		}
+	}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "rtmpt" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+// tunnelConn is a net.Conn whose bytes are carried by RTMPT HTTP requests.
+type tunnelConn struct {
+	local  net.Addr
+	remote net.Addr
+
+	mu           sync.Mutex
+	in           []byte
+	out          []byte
+	closed       bool
+	readDeadline time.Time
+	dataCh       chan struct{}
+	done         chan struct{}
+}
+
+func newTunnelConn(remote, local net.Addr) *tunnelConn {
+	return &tunnelConn{
+		local:  local,
+		remote: remote,
+		dataCh: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.in) > 0 {
+			n := copy(p, c.in)
+			c.in = c.in[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-c.dataCh:
+		case <-c.done:
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if len(c.out)+len(p) > maxPendingOutput {
+		return 0, errors.New("rtmpt: pending output limit exceeded")
+	}
+	c.out = append(c.out, p...)
+	return len(p), nil
+}
+
+func (c *tunnelConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return c.local }
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is a no-op: writes are buffered until the client polls.
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *tunnelConn) feed(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if len(c.in)+len(p) > maxPendingInput {
+		c.mu.Unlock()
+		return 0, errInputOverflow
+	}
+	c.in = append(c.in, p...)
+	c.mu.Unlock()
+
+	select {
+	case c.dataCh <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (c *tunnelConn) drain() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := c.out
+	c.out = nil
+	return out
+}
+
+func (c *tunnelConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
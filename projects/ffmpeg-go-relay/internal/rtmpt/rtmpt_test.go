@@ -0,0 +1,189 @@
+package rtmpt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, h http.Handler, path string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTunnelRoundTrip(t *testing.T) {
+	echo := func(ctx context.Context, conn net.Conn) error {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		_, err := conn.Write(bytes.ToUpper(buf))
+		return err
+	}
+	h := NewHandler(context.Background(), echo, nil, time.Minute)
+
+	rec := post(t, h, "/open/1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("open status = %d", rec.Code)
+	}
+	id := strings.TrimSpace(rec.Body.String())
+	if id == "" {
+		t.Fatal("expected session id")
+	}
+
+	rec = post(t, h, "/send/"+id+"/1", []byte("ping"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("send status = %d", rec.Code)
+	}
+
+	var got []byte
+	seq := 2
+	deadline := time.Now().Add(2 * time.Second)
+	for ; len(got) < 4 && time.Now().Before(deadline); seq++ {
+		rec = post(t, h, "/idle/"+id+"/"+strconv.Itoa(seq), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("idle status = %d", rec.Code)
+		}
+		body := rec.Body.Bytes()
+		if len(body) < 1 || body[0] < minPollInterval || body[0] > maxPollInterval {
+			t.Fatalf("invalid poll interval in response %v", body)
+		}
+		got = append(got, body[1:]...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(got) != "PING" {
+		t.Fatalf("tunnel output = %q, want PING", got)
+	}
+
+	stats := h.Stats()
+	if len(stats) != 1 || stats[0].BytesIn != 4 || stats[0].Sends != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if rec := post(t, h, "/close/"+id+"/"+strconv.Itoa(seq), nil); rec.Code != http.StatusOK {
+		t.Fatalf("close status = %d", rec.Code)
+	}
+	if rec := post(t, h, "/idle/"+id+"/"+strconv.Itoa(seq+1), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("idle after close status = %d, want 404", rec.Code)
+	}
+}
+
+func TestTunnelUnknownSession(t *testing.T) {
+	h := NewHandler(context.Background(), func(context.Context, net.Conn) error { return nil }, nil, time.Minute)
+	if rec := post(t, h, "/send/missing/1", []byte{1}); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestTunnelInputLimit(t *testing.T) {
+	// The relay side never reads.
+	h := NewHandler(context.Background(), func(ctx context.Context, conn net.Conn) error {
+		<-ctx.Done()
+		return nil
+	}, nil, time.Minute)
+	id := strings.TrimSpace(post(t, h, "/open/1", nil).Body.String())
+
+	chunk := make([]byte, maxPendingInput/2)
+	for seq := 1; seq <= 2; seq++ {
+		if rec := post(t, h, "/send/"+id+"/"+strconv.Itoa(seq), chunk); rec.Code != http.StatusOK {
+			t.Fatalf("send %d status = %d", seq, rec.Code)
+		}
+	}
+	if rec := post(t, h, "/send/"+id+"/3", []byte{1}); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("send past the input limit status = %d, want 503", rec.Code)
+	}
+	if len(h.Stats()) != 0 {
+		t.Fatal("expected the overflowing session to be removed")
+	}
+}
+
+func TestTunnelRejectsOversizedSend(t *testing.T) {
+	fed := make(chan []byte, 1)
+	h := NewHandler(context.Background(), func(ctx context.Context, conn net.Conn) error {
+		buf := make([]byte, 1)
+		_, err := io.ReadFull(conn, buf)
+		fed <- buf
+		return err
+	}, nil, time.Minute)
+	id := strings.TrimSpace(post(t, h, "/open/1", nil).Body.String())
+
+	if rec := post(t, h, "/send/"+id+"/1", make([]byte, maxPendingInput+1)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized send status = %d, want 413", rec.Code)
+	}
+	if rec := post(t, h, "/send/"+id+"/2", []byte{7}); rec.Code != http.StatusOK {
+		t.Fatalf("send after the oversized one status = %d", rec.Code)
+	}
+	// None of the oversized body reached the relay.
+	if got := <-fed; got[0] != 7 {
+		t.Fatalf("relay read %v first, want the byte of the next send", got)
+	}
+}
+
+func TestTunnelRejectsOutOfOrderSeq(t *testing.T) {
+	h := NewHandler(context.Background(), func(ctx context.Context, conn net.Conn) error {
+		<-ctx.Done()
+		return nil
+	}, nil, time.Minute)
+	id := strings.TrimSpace(post(t, h, "/open/1", nil).Body.String())
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/send/" + id + "/1", http.StatusOK},
+		{"/send/" + id + "/1", http.StatusBadRequest}, // Repeated
+		{"/send/" + id + "/3", http.StatusOK},
+		{"/send/" + id + "/2", http.StatusBadRequest}, // Overtaken
+		{"/idle/" + id + "/x", http.StatusBadRequest},
+		{"/idle/" + id, http.StatusBadRequest},
+		{"/idle/" + id + "/4", http.StatusOK},
+	} {
+		if rec := post(t, h, tc.path, []byte{1}); rec.Code != tc.want {
+			t.Fatalf("%s status = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+	if st := h.Stats(); len(st) != 1 || st[0].BytesIn != 3 {
+		t.Fatalf("stats = %+v, want the three accepted sends counted", st)
+	}
+}
+
+func TestTunnelReapsWithoutRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closed := make(chan struct{})
+	h := NewHandler(ctx, func(ctx context.Context, conn net.Conn) error {
+		_, err := conn.Read(make([]byte, 1))
+		close(closed)
+		return err
+	}, nil, 20*time.Millisecond)
+	post(t, h, "/open/1", nil)
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session was not reaped")
+	}
+	if len(h.Stats()) != 0 {
+		t.Fatal("expected the idle session to be removed")
+	}
+}
+
+func TestTunnelConnReadDeadline(t *testing.T) {
+	conn := newTunnelConn(tunnelAddr("client"), tunnelAddr("server"))
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
@@ -0,0 +1,45 @@
+package rtmptest
+
+import (
+	"net"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Client is a publisher or player connection, connected to an app.
+type Client struct {
+	net.Conn
+	*rtmp.ClientSession
+}
+
+// Dial connects to the RTMP server at addr, completes the handshake and
+// connects to app. A refused connect is returned as a *rtmp.StatusError.
+func Dial(addr, app string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if err := rtmp.ClientHandshake(conn, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	session := rtmp.NewClientSession(rtmp.NewChunkStream(conn), conn)
+	if _, err := session.Connect(app, "rtmp://"+addr+"/"+app); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Client{Conn: conn, ClientSession: session}, nil
+}
+
+// WriteVideo publishes an AVC keyframe or inter frame at ts.
+func (c *Client) WriteVideo(ts uint32, keyframe bool) error {
+	frame := byte(0x27)
+	if keyframe {
+		frame = 0x17
+	}
+	return c.WriteMedia(&rtmp.Message{
+		Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts},
+		Payload: []byte{frame, 0x01, 0, 0, 0, 0, 0, 0, 1, 0x65},
+	})
+}
@@ -0,0 +1,388 @@
+// Package rtmptest provides an in-process RTMP server for tests that need a
+// real upstream: it completes the handshake, answers connect and publish the
+// way an ingest server does, reports what each session did, and can be
+// scripted to refuse or fail a session at any step. Dial is the matching
+// client, for driving a relay from the publisher's side.
+package rtmptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Step is a point in a session, for Script failures and Events.
+type Step string
+
+const (
+	StepHandshake Step = "handshake" // The RTMP handshake
+	StepConnect   Step = "connect"   // connect, once read
+	StepPublish   Step = "publish"   // publish, once read
+	StepMedia     Step = "media"     // An audio, video or data message of the published stream
+	StepClosed    Step = "closed"    // The session ended
+)
+
+// Script decides how the server answers a session. The zero Script accepts
+// every connect and publish and reads media until the client leaves.
+type Script struct {
+	// Connect answers a connect for app: "" accepts it, anything else
+	// rejects it with NetConnection.Connect.Rejected and that description.
+	Connect func(app string, connect []interface{}) string
+	// Publish answers a publish of stream the same way, with
+	// NetStream.Publish.Rejected.
+	Publish func(stream string) string
+
+	// FailAt ends sessions at a step without answering it: the handshake
+	// is never completed, connect or publish never answered, or media
+	// stops being read after FailAfter messages. Stall holds the
+	// connection open there instead of closing it, until the server
+	// closes.
+	FailAt    Step
+	FailAfter int
+	Stall     bool
+}
+
+// Event is a step a session reached.
+type Event struct {
+	Conn    int           // The session's connection, from 1 in accept order
+	Step    Step          // What the session did
+	App     string        // StepConnect: the requested app, with any query
+	Stream  string        // StepPublish: the requested stream name, with any query
+	Message *rtmp.Message // StepMedia
+	Err     error         // StepClosed: why the session ended, nil when the client left
+}
+
+// String describes e the way tests compare events, e.g. "connect live",
+// "publish cam1" or "video 40".
+func (e Event) String() string {
+	switch e.Step {
+	case StepConnect:
+		return "connect " + e.App
+	case StepPublish:
+		return "publish " + e.Stream
+	case StepMedia:
+		kind := "data"
+		switch e.Message.Header.TypeID {
+		case rtmp.TypeAudio:
+			kind = "audio"
+		case rtmp.TypeVideo:
+			kind = "video"
+		}
+		return fmt.Sprintf("%s %d", kind, e.Message.Header.Timestamp)
+	}
+	return string(e.Step)
+}
+
+// Server is a scriptable RTMP server listening on a loopback port.
+type Server struct {
+	ln   net.Listener
+	wg   sync.WaitGroup
+	quit chan struct{}
+
+	mu       sync.Mutex
+	script   Script
+	accepted int
+	conns    map[net.Conn]struct{}
+	events   []Event
+	read     int           // Events already returned by Next
+	changed  chan struct{} // Closed and replaced when events grow
+	closed   bool
+}
+
+// NewServer starts a server answering sessions with script. It closes when
+// t's test ends.
+func NewServer(t testing.TB, script Script) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ln:      ln,
+		quit:    make(chan struct{}),
+		script:  script,
+		conns:   make(map[net.Conn]struct{}),
+		changed: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the server's host:port.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// URL returns the server's rtmp:// URL for app.
+func (s *Server) URL(app string) string {
+	return "rtmp://" + s.Addr() + "/" + app
+}
+
+// SetScript changes how sessions accepted from now on are answered.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	s.script = script
+	s.mu.Unlock()
+}
+
+// Accepted returns the number of connections accepted so far.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Events returns every event so far, in order.
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Next returns the first event Next has not returned yet, waiting up to
+// timeout for one. It reports false if none came.
+func (s *Server) Next(timeout time.Duration) (Event, bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if s.read < len(s.events) {
+			e := s.events[s.read]
+			s.read++
+			s.mu.Unlock()
+			return e, true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return Event{}, false
+		}
+	}
+}
+
+// Expect fails t unless the next events, as strings, are want.
+func (s *Server) Expect(t testing.TB, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		e, ok := s.Next(5 * time.Second)
+		if !ok {
+			t.Fatalf("upstream never saw %q", w)
+		}
+		if e.String() != w {
+			t.Fatalf("upstream saw %q, want %q", e, w)
+		}
+	}
+}
+
+// WaitFor returns the first event after those Next returned for which match
+// holds, skipping the others, and fails t if none comes within 5 seconds.
+func (s *Server) WaitFor(t testing.TB, match func(Event) bool) Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e, ok := s.Next(time.Until(deadline))
+		if !ok {
+			t.Fatal("upstream never saw the expected event")
+		}
+		if match(e) {
+			return e
+		}
+	}
+}
+
+// Close stops the server and closes every session.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.quit)
+	s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.accepted++
+		id, script := s.accepted, s.script
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			err := s.session(conn, id, script)
+			conn.Close()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			s.record(Event{Conn: id, Step: StepClosed, Err: err})
+		}()
+	}
+}
+
+// errFailed ends a session the script failed.
+var errFailed = errors.New("rtmptest: failed by script")
+
+// session answers one connection with script.
+func (s *Server) session(conn net.Conn, id int, script Script) error {
+	fail := func(step Step) error {
+		if script.Stall {
+			<-s.quit
+		}
+		return fmt.Errorf("%w at %s", errFailed, step)
+	}
+
+	if script.FailAt == StepHandshake {
+		return fail(StepHandshake)
+	}
+	if err := rtmp.ServerHandshake(conn, nil); err != nil {
+		return err
+	}
+
+	cs := rtmp.NewChunkStream(conn)
+	session := rtmp.NewServerSession(cs, conn)
+	connect, err := readConnect(cs)
+	if err != nil {
+		return err
+	}
+	app := connectApp(connect)
+	s.record(Event{Conn: id, Step: StepConnect, App: app})
+	if script.FailAt == StepConnect {
+		return fail(StepConnect)
+	}
+	if script.Connect != nil {
+		if reason := script.Connect(app, connect); reason != "" {
+			if err := session.Reject(connect, reason); err != nil {
+				return err
+			}
+			// Let the client read the rejection and hang up first.
+			io.Copy(io.Discard, conn)
+			return nil
+		}
+	}
+
+	var failed error
+	rejected := false
+	_, err = session.AcceptPublish(connect, func(stream string) string {
+		s.record(Event{Conn: id, Step: StepPublish, Stream: stream})
+		if script.FailAt == StepPublish {
+			failed = fail(StepPublish)
+			conn.Close()
+			return ""
+		}
+		if script.Publish == nil {
+			return ""
+		}
+		reason := script.Publish(stream)
+		rejected = reason != ""
+		return reason
+	})
+	if failed != nil {
+		return failed
+	}
+	if err != nil {
+		return err
+	}
+	if rejected {
+		io.Copy(io.Discard, conn)
+		return nil
+	}
+
+	for media := 0; ; {
+		if script.FailAt == StepMedia && media >= script.FailAfter {
+			return fail(StepMedia)
+		}
+		msg, err := cs.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch msg.Header.TypeID {
+		case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeAMF0Data:
+			// The payload is only valid until the next read.
+			msg.Payload = bytes.Clone(msg.Payload)
+			s.record(Event{Conn: id, Step: StepMedia, Message: msg})
+			media++
+		}
+	}
+}
+
+func (s *Server) record(e Event) {
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// readConnect reads messages until the connect command and decodes it.
+func readConnect(cs *rtmp.ChunkStream) ([]interface{}, error) {
+	for {
+		msg, err := cs.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		payload := msg.Payload
+		switch msg.Header.TypeID {
+		case rtmp.TypeAMF0Command:
+		case rtmp.TypeAMF20Command:
+			if len(payload) == 0 {
+				continue
+			}
+			payload = payload[1:]
+		default:
+			continue
+		}
+		vals, err := rtmp.DecodeAMF0(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) == 0 {
+			continue
+		}
+		if name, _ := vals[0].(string); name == "connect" {
+			return vals, nil
+		}
+	}
+}
+
+// connectApp returns the app of a connect command.
+func connectApp(connect []interface{}) string {
+	if len(connect) < 3 {
+		return ""
+	}
+	obj, _ := connect[2].(map[string]interface{})
+	app, _ := obj["app"].(string)
+	return app
+}
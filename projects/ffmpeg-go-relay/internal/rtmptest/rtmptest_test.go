@@ -0,0 +1,93 @@
+package rtmptest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func TestServerAcceptsPublish(t *testing.T) {
+	srv := NewServer(t, Script{})
+	c, err := Dial(srv.Addr(), "live")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := c.Publish("cam1"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for _, ts := range []uint32{0, 40} {
+		if err := c.WriteVideo(ts, ts == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.Expect(t, "connect live", "publish cam1", "video 0", "video 40")
+
+	c.Close()
+	if e := srv.WaitFor(t, func(e Event) bool { return e.Step == StepClosed }); e.Err != nil {
+		t.Fatalf("session ended with %v, want nil", e.Err)
+	}
+	if n := srv.Accepted(); n != 1 {
+		t.Fatalf("Accepted = %d, want 1", n)
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	srv := NewServer(t, Script{
+		Connect: func(app string, _ []interface{}) string {
+			if app != "live" {
+				return "no such app"
+			}
+			return ""
+		},
+		Publish: func(stream string) string { return "stream " + stream + " is taken" },
+	})
+
+	var se *rtmp.StatusError
+	if _, err := Dial(srv.Addr(), "vod"); !errors.As(err, &se) || se.Code != "NetConnection.Connect.Rejected" || se.Description != "no such app" {
+		t.Fatalf("Dial vod = %v, want the connect rejected", err)
+	}
+	c, err := Dial(srv.Addr(), "live")
+	if err != nil {
+		t.Fatalf("Dial live: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Publish("cam1"); !errors.As(err, &se) || se.Code != "NetStream.Publish.Rejected" {
+		t.Fatalf("Publish = %v, want the publish rejected", err)
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	srv := NewServer(t, Script{FailAt: StepHandshake})
+	if _, err := Dial(srv.Addr(), "live"); err == nil {
+		t.Fatal("handshake succeeded with FailAt handshake")
+	}
+
+	srv.SetScript(Script{FailAt: StepPublish})
+	c, err := Dial(srv.Addr(), "live")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Publish("cam1"); err == nil {
+		t.Fatal("publish answered with FailAt publish")
+	}
+
+	srv.SetScript(Script{FailAt: StepConnect, Stall: true})
+	done := make(chan error, 1)
+	go func() {
+		_, err := Dial(srv.Addr(), "live")
+		done <- err
+	}()
+	srv.WaitFor(t, func(e Event) bool { return e.Conn == 3 && e.Step == StepConnect })
+	select {
+	case err := <-done:
+		t.Fatalf("stalled connect answered: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	srv.Close()
+	if err := <-done; err == nil {
+		t.Fatal("connect succeeded once the server closed")
+	}
+}
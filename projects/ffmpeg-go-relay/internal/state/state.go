@@ -0,0 +1,545 @@
+// Package state keeps a small record of the relay's streams, session
+// accounting and DVR recordings in a bbolt database, so that a restarted
+// relay can report what the previous run was doing, keep counting where it
+// left off and resume the recordings of publishers that come back. Finished
+// sessions are counted in the transaction that ends them; the sessions in
+// progress are saved on an interval and on Close.
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultSaveInterval bounds how stale the list of running sessions can be
+// after a crash.
+const DefaultSaveInterval = 10 * time.Second
+
+// ReasonRelayRestart counts sessions that were still running when the
+// previous run stopped.
+const ReasonRelayRestart = "relay_restart"
+
+// openTimeout bounds the wait for another process holding the database.
+const openTimeout = 5 * time.Second
+
+var (
+	bucketRun        = []byte("run")        // keyRun: the current run
+	bucketTotals     = []byte("totals")     // Termination reason: finished sessions
+	bucketStreams    = []byte("streams")    // Stream name: Stream
+	bucketActive     = []byte("active")     // Request ID: Session in progress at the last save
+	bucketRecordings = []byte("recordings") // Stream name: DVR recording manifest
+
+	keyRun = []byte("run")
+)
+
+// Session is a session in progress.
+type Session struct {
+	RequestID  string    `json:"request_id"`
+	ClientAddr string    `json:"client_addr"`
+	App        string    `json:"app,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Start      time.Time `json:"start"`
+}
+
+// Stream is the history of one published stream name.
+type Stream struct {
+	Name          string    `json:"name"`
+	Sessions      uint64    `json:"sessions"`
+	LastPublished time.Time `json:"last_published"`
+	LastEnded     time.Time `json:"last_ended,omitempty"`
+	Live          bool      `json:"live"`
+}
+
+// Totals counts finished sessions across every run sharing the database.
+type Totals struct {
+	Sessions uint64            `json:"sessions"`
+	ByReason map[string]uint64 `json:"by_reason"`
+}
+
+// PreviousRun describes the run that last wrote the database.
+type PreviousRun struct {
+	StartedAt   time.Time `json:"started_at"`
+	StoppedAt   time.Time `json:"stopped_at"` // When it last saved
+	Clean       bool      `json:"clean"`      // False after a crash or kill
+	Interrupted []Session `json:"interrupted,omitempty"`
+}
+
+// Status is the record as reported by /status.
+type Status struct {
+	Totals   Totals       `json:"totals"`
+	Streams  []Stream     `json:"streams"`
+	Previous *PreviousRun `json:"previous_run,omitempty"`
+}
+
+// runRecord is what a run says about itself.
+type runRecord struct {
+	StartedAt time.Time `json:"started_at"`
+	SavedAt   time.Time `json:"saved_at"`
+	Clean     bool      `json:"clean"`
+}
+
+// Store is safe for concurrent use. A nil Store records nothing.
+type Store struct {
+	active   func() []Session
+	interval time.Duration
+
+	mu       sync.Mutex
+	db       *bolt.DB // nil until Attach
+	previous *PreviousRun
+	carried  map[string]uint64 // Totals by reason before this run
+	pending  []endedSession    // Finished before Attach
+	run      runRecord
+	ended    map[string]bool // Counted sessions active may still list
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type endedSession struct {
+	sess   Session
+	reason string
+	end    time.Time
+}
+
+// Open loads the database at path, creating it if needed, and starts saving
+// the sessions in progress every interval. active lists them at each save.
+// Sessions the previous run left running are counted once, as
+// ReasonRelayRestart, in the transaction that starts this run, so a second
+// restart does not count them again.
+func Open(path string, interval time.Duration, active func() []Session) (*Store, error) {
+	s := New(interval, active)
+	if err := s.Attach(path); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a Store without its database, which Attach opens. Sessions
+// that finish before then are counted when it does. A relay started by an
+// upgrade uses it while the old process still has the database open.
+func New(interval time.Duration, active func() []Session) *Store {
+	if interval <= 0 {
+		interval = DefaultSaveInterval
+	}
+	return &Store{
+		active:   active,
+		interval: interval,
+		run:      runRecord{StartedAt: time.Now()},
+		ended:    make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Attach opens the database at path as Open does, and counts the sessions
+// that finished since New. It can be called once.
+func (s *Store) Attach(path string) error {
+	if s == nil {
+		return nil
+	}
+	db, err := bolt.Open(path, 0o640, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf("state: open %s: %w", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+		err = errors.New("state: closed")
+	case s.db != nil:
+		err = errors.New("state: already attached")
+	default:
+		err = db.Update(func(tx *bolt.Tx) error {
+			if err := s.resume(tx); err != nil {
+				return err
+			}
+			for _, e := range s.pending {
+				if err := endSession(tx, e.sess, e.reason, e.end); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	s.pending = nil
+
+	s.wg.Add(1)
+	go s.saveLoop(s.interval)
+	return nil
+}
+
+func (s *Store) resume(tx *bolt.Tx) error {
+	for _, name := range [][]byte{bucketRun, bucketTotals, bucketStreams, bucketActive, bucketRecordings} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return fmt.Errorf("state: %w", err)
+		}
+	}
+	if data := tx.Bucket(bucketRun).Get(keyRun); data != nil {
+		var prev runRecord
+		if err := json.Unmarshal(data, &prev); err != nil {
+			return fmt.Errorf("state: decode run: %w", err)
+		}
+		var interrupted []Session
+		err := tx.Bucket(bucketActive).ForEach(func(_, v []byte) error {
+			var sess Session
+			if err := json.Unmarshal(v, &sess); err != nil {
+				return fmt.Errorf("state: decode session: %w", err)
+			}
+			interrupted = append(interrupted, sess)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, sess := range interrupted {
+			if err := endSession(tx, sess, ReasonRelayRestart, prev.SavedAt); err != nil {
+				return err
+			}
+		}
+		s.previous = &PreviousRun{
+			StartedAt:   prev.StartedAt,
+			StoppedAt:   prev.SavedAt,
+			Clean:       prev.Clean,
+			Interrupted: interrupted,
+		}
+	}
+	s.carried = make(map[string]uint64)
+	_ = tx.Bucket(bucketTotals).ForEach(func(k, v []byte) error {
+		s.carried[string(k)] = decodeCount(v)
+		return nil
+	})
+	s.run.SavedAt = s.run.StartedAt
+	return putJSON(tx.Bucket(bucketRun), keyRun, s.run)
+}
+
+// Previous returns the run that wrote the database before this one.
+func (s *Store) Previous() (PreviousRun, bool) {
+	if s == nil {
+		return PreviousRun{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previous == nil {
+		return PreviousRun{}, false
+	}
+	return *s.previous, true
+}
+
+// Carried returns the sessions earlier runs finished, by termination
+// reason, as Attach found them.
+func (s *Store) Carried() map[string]uint64 {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.carried
+}
+
+// SessionEnded counts a finished session. The count is committed before it
+// returns, so a crash afterwards neither loses it nor counts the session
+// again as interrupted.
+func (s *Store) SessionEnded(sess Session, reason string, end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended[sess.RequestID] = true
+	if s.db == nil {
+		s.pending = append(s.pending, endedSession{sess, reason, end})
+		return
+	}
+	_ = s.db.Update(func(tx *bolt.Tx) error {
+		return endSession(tx, sess, reason, end)
+	})
+}
+
+func endSession(tx *bolt.Tx, sess Session, reason string, end time.Time) error {
+	totals := tx.Bucket(bucketTotals)
+	if err := totals.Put([]byte(reason), encodeCount(decodeCount(totals.Get([]byte(reason)))+1)); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if err := tx.Bucket(bucketActive).Delete([]byte(sess.RequestID)); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if sess.Stream == "" {
+		return nil
+	}
+	return updateStream(tx, sess.Stream, func(st *Stream) {
+		st.Sessions++
+		if sess.Start.After(st.LastPublished) {
+			st.LastPublished = sess.Start
+		}
+		if end.After(st.LastEnded) {
+			st.LastEnded = end
+		}
+	})
+}
+
+func updateStream(tx *bolt.Tx, name string, update func(*Stream)) error {
+	b := tx.Bucket(bucketStreams)
+	st := Stream{Name: name}
+	if data := b.Get([]byte(name)); data != nil {
+		if err := json.Unmarshal(data, &st); err != nil {
+			return fmt.Errorf("state: decode stream %s: %w", name, err)
+		}
+	}
+	update(&st)
+	st.Live = false // Only known while running
+	return putJSON(b, []byte(name), st)
+}
+
+// Status returns the totals, every known stream sorted by name and the
+// previous run.
+func (s *Store) Status() Status {
+	if s == nil {
+		return Status{}
+	}
+	active := s.sessions()
+	s.mu.Lock()
+	db, previous := s.db, s.previous
+	s.mu.Unlock()
+	st := Status{
+		Totals:   Totals{ByReason: make(map[string]uint64)},
+		Previous: previous,
+	}
+	streams := make(map[string]*Stream)
+	if db == nil {
+		return s.overlay(st, streams, active)
+	}
+	_ = db.View(func(tx *bolt.Tx) error {
+		_ = tx.Bucket(bucketTotals).ForEach(func(k, v []byte) error {
+			n := decodeCount(v)
+			st.Totals.ByReason[string(k)] = n
+			st.Totals.Sessions += n
+			return nil
+		})
+		return tx.Bucket(bucketStreams).ForEach(func(k, v []byte) error {
+			stream := &Stream{}
+			_ = json.Unmarshal(v, stream) // Listed with its name alone if unreadable
+			stream.Name = string(k)
+			streams[stream.Name] = stream
+			return nil
+		})
+	})
+	return s.overlay(st, streams, active)
+}
+
+// overlay adds the sessions in progress to streams and lists them in st.
+func (s *Store) overlay(st Status, streams map[string]*Stream, active []Session) Status {
+	for _, sess := range active {
+		if sess.Stream == "" {
+			continue
+		}
+		stream := streams[sess.Stream]
+		if stream == nil {
+			stream = &Stream{Name: sess.Stream}
+			streams[sess.Stream] = stream
+		}
+		stream.Live = true
+		if sess.Start.After(stream.LastPublished) {
+			stream.LastPublished = sess.Start
+		}
+	}
+	st.Streams = make([]Stream, 0, len(streams))
+	for _, stream := range streams {
+		st.Streams = append(st.Streams, *stream)
+	}
+	sort.Slice(st.Streams, func(i, j int) bool { return st.Streams[i].Name < st.Streams[j].Name })
+	return st
+}
+
+func (s *Store) sessions() []Session {
+	if s.active == nil {
+		return nil
+	}
+	return s.active()
+}
+
+// errDetached is returned by the recording methods before Attach.
+var errDetached = errors.New("state: database not attached")
+
+func (s *Store) database() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil, errDetached
+	}
+	return s.db, nil
+}
+
+// SaveRecording stores the manifest of stream's DVR recording.
+func (s *Store) SaveRecording(stream string, manifest []byte) error {
+	if s == nil {
+		return nil
+	}
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRecordings).Put([]byte(stream), manifest)
+	})
+}
+
+// DeleteRecording forgets stream's DVR recording.
+func (s *Store) DeleteRecording(stream string) error {
+	if s == nil {
+		return nil
+	}
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRecordings).Delete([]byte(stream))
+	})
+}
+
+// Recordings returns the stored DVR recording manifests by stream name.
+func (s *Store) Recordings() (map[string][]byte, error) {
+	if s == nil {
+		return nil, nil
+	}
+	db, err := s.database()
+	if err != nil {
+		return nil, err
+	}
+	manifests := make(map[string][]byte)
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRecordings).ForEach(func(k, v []byte) error {
+			manifests[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return manifests, err
+}
+
+// Close stops the save loop, saves the sessions in progress one last time
+// with the run marked as a clean shutdown, and closes the database. Call it
+// once sessions have ended so their accounting is in. Sessions held by a
+// Store that was never attached are dropped.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	db := s.db
+	s.mu.Unlock()
+
+	close(s.stop)
+	s.wg.Wait()
+	if db == nil {
+		return nil
+	}
+	err := s.save(true)
+	if cerr := db.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("state: close: %w", cerr)
+	}
+	return err
+}
+
+func (s *Store) saveLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.save(false)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// save replaces the sessions in progress and notes when this run last
+// saved. Sessions already counted by SessionEnded are left out even while
+// active still lists them.
+func (s *Store) save(clean bool) error {
+	active := s.sessions()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listed := make(map[string]bool, len(active))
+	for _, sess := range active {
+		listed[sess.RequestID] = true
+	}
+	for id := range s.ended {
+		if !listed[id] {
+			delete(s.ended, id)
+		}
+	}
+	s.run.SavedAt = time.Now()
+	s.run.Clean = clean
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucketActive); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(bucketActive)
+		if err != nil {
+			return err
+		}
+		for _, sess := range active {
+			if s.ended[sess.RequestID] {
+				continue
+			}
+			if err := putJSON(b, []byte(sess.RequestID), sess); err != nil {
+				return err
+			}
+			if sess.Stream == "" {
+				continue
+			}
+			err := updateStream(tx, sess.Stream, func(st *Stream) {
+				if sess.Start.After(st.LastPublished) {
+					st.LastPublished = sess.Start
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return putJSON(tx.Bucket(bucketRun), keyRun, s.run)
+	})
+	if err != nil {
+		return fmt.Errorf("state: save: %w", err)
+	}
+	return nil
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("state: encode: %w", err)
+	}
+	return b.Put(key, data)
+}
+
+func encodeCount(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func decodeCount(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
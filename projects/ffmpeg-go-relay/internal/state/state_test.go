@@ -0,0 +1,233 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type activeSet struct {
+	mu       sync.Mutex
+	sessions []Session
+}
+
+func (a *activeSet) list() []Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Session(nil), a.sessions...)
+}
+
+func (a *activeSet) set(sessions ...Session) {
+	a.mu.Lock()
+	a.sessions = sessions
+	a.mu.Unlock()
+}
+
+func TestStoreSurvivesCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	start := time.Now().Add(-time.Minute)
+	cam := Session{RequestID: "a", ClientAddr: "10.0.0.1:5000", Stream: "cam", Start: start}
+
+	active := &activeSet{}
+	s, err := Open(path, time.Hour, active.list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Previous(); ok {
+		t.Fatal("first run reported a previous run")
+	}
+	s.SessionEnded(Session{RequestID: "b", Stream: "lobby", Start: start}, "client_disconnect", time.Now())
+	active.set(cam)
+	// Simulate a crash: the last save happened, Close never ran.
+	if err := s.save(false); err != nil {
+		t.Fatal(err)
+	}
+	crash(s)
+
+	s, err = Open(path, time.Hour, (&activeSet{}).list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, ok := s.Previous()
+	if !ok || prev.Clean || len(prev.Interrupted) != 1 || prev.Interrupted[0].RequestID != "a" {
+		t.Fatalf("previous run = %+v, want one interrupted session after an unclean stop", prev)
+	}
+	st := s.Status()
+	if st.Totals.Sessions != 2 || st.Totals.ByReason[ReasonRelayRestart] != 1 || st.Totals.ByReason["client_disconnect"] != 1 {
+		t.Fatalf("totals = %+v, want the interrupted session counted as relay_restart", st.Totals)
+	}
+	if len(st.Streams) != 2 || st.Streams[0].Name != "cam" || st.Streams[0].Live || st.Streams[0].Sessions != 1 {
+		t.Fatalf("streams = %+v, want cam kept with one session and not live", st.Streams)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interrupted session was counted once, not again on the next start.
+	s, err = Open(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	prev, _ = s.Previous()
+	if !prev.Clean || len(prev.Interrupted) != 0 {
+		t.Fatalf("previous run = %+v, want a clean stop", prev)
+	}
+	if st := s.Status(); st.Totals.Sessions != 2 {
+		t.Fatalf("totals = %+v, want 2 sessions after a clean restart", st.Totals)
+	}
+}
+
+// crash stops s without the final save, as a kill would.
+func crash(s *Store) {
+	close(s.stop)
+	s.wg.Wait()
+	s.db.Close()
+}
+
+func TestSessionEndedIsCountedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	cam := Session{RequestID: "a", Stream: "cam", Start: time.Now()}
+	active := &activeSet{}
+	s, err := Open(path, time.Hour, active.list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.set(cam)
+	if err := s.save(false); err != nil {
+		t.Fatal(err)
+	}
+	// The session ends while the relay still lists it, and a save in that
+	// window must not bring it back.
+	s.SessionEnded(cam, "client_disconnect", time.Now())
+	if err := s.save(false); err != nil {
+		t.Fatal(err)
+	}
+	crash(s)
+
+	s, err = Open(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if prev, _ := s.Previous(); len(prev.Interrupted) != 0 {
+		t.Fatalf("interrupted = %+v, want none: the session was counted before the crash", prev.Interrupted)
+	}
+	if st := s.Status(); st.Totals.Sessions != 1 || st.Totals.ByReason["client_disconnect"] != 1 {
+		t.Fatalf("totals = %+v, want one client_disconnect", st.Totals)
+	}
+}
+
+func TestAttachAfterAnotherProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	old, err := Open(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.SessionEnded(Session{RequestID: "a", Stream: "cam"}, "client_disconnect", time.Now())
+
+	// The new process counts while the old one still holds the database.
+	s := New(time.Hour, nil)
+	defer s.Close()
+	s.SessionEnded(Session{RequestID: "b", Stream: "cam"}, "upstream_error", time.Now())
+	if st := s.Status(); st.Totals.Sessions != 0 {
+		t.Fatalf("totals before Attach = %+v, want none", st.Totals)
+	}
+	if _, err := s.Recordings(); err == nil {
+		t.Fatal("Recordings before Attach succeeded")
+	}
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Attach(path); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if prev, ok := s.Previous(); !ok || !prev.Clean {
+		t.Fatalf("previous = %+v, %v; want the clean run before", prev, ok)
+	}
+	if got := s.Carried(); len(got) != 1 || got["client_disconnect"] != 1 {
+		t.Fatalf("carried = %v, want the old process's session alone", got)
+	}
+	st := s.Status()
+	if st.Totals.Sessions != 2 || st.Totals.ByReason["upstream_error"] != 1 {
+		t.Fatalf("totals = %+v, want both sessions", st.Totals)
+	}
+	if len(st.Streams) != 1 || st.Streams[0].Sessions != 2 {
+		t.Fatalf("streams = %+v, want cam with two sessions", st.Streams)
+	}
+}
+
+func TestStoreRecordings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRecording("cam", []byte(`{"dir":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRecording("lobby", []byte(`{"dir":"b"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRecording("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	crash(s)
+
+	s, err = Open(path, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, err := s.Recordings()
+	if err != nil || len(got) != 1 || string(got["cam"]) != `{"dir":"a"}` {
+		t.Fatalf("Recordings = %q, %v, want cam's manifest only", got, err)
+	}
+}
+
+func TestStoreMarksLiveStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	active := &activeSet{}
+	s, err := Open(path, time.Hour, active.list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	active.set(Session{RequestID: "a", Stream: "cam", Start: start})
+	st := s.Status()
+	if len(st.Streams) != 1 || !st.Streams[0].Live || !st.Streams[0].LastPublished.Equal(start) {
+		t.Fatalf("streams = %+v, want cam live since %v", st.Streams, start)
+	}
+
+	active.set()
+	s.SessionEnded(Session{RequestID: "a", Stream: "cam", Start: start}, "client_disconnect", time.Now())
+	if st := s.Status(); st.Streams[0].Live || st.Streams[0].Sessions != 1 {
+		t.Fatalf("streams = %+v, want cam ended after one session", st.Streams)
+	}
+}
+
+func TestOpenRejectsCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, time.Hour, nil); err == nil {
+		t.Fatal("expected a corrupt state file to be reported")
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	s.SessionEnded(Session{Stream: "cam"}, "client_disconnect", time.Now())
+	if _, ok := s.Previous(); ok {
+		t.Fatal("nil Store reported a previous run")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+package testpattern
+
+// A minimal H.264 Baseline encoder: keyframes are coded as I_PCM macroblocks,
+// which carry raw samples, and the frames between them as P frames made only
+// of skipped macroblocks, repeating the keyframe. Any decoder plays it, at
+// the cost of keyframes as large as the raw picture.
+
+const (
+	nalSlice  = 0x41 // nal_ref_idc 2, coded slice of a non-IDR picture
+	nalIDR    = 0x65 // nal_ref_idc 3, coded slice of an IDR picture
+	nalSPS    = 0x67
+	nalPPS    = 0x68
+	nalFiller = 0x0C
+
+	profileBaseline = 66
+	mbTypeIPCM      = 25 // In I slices
+	log2MaxFrameNum = 4
+)
+
+// sps returns the sequence parameter set for a picture of mbW x mbH
+// macroblocks.
+func sps(mbW, mbH int) []byte {
+	var b bitWriter
+	b.u(8, profileBaseline)
+	b.u(8, 0xC0) // constraint_set0 and 1: Baseline and Main decoders can play it
+	b.u(8, uint32(level(mbW*mbH)))
+	b.ue(0)                   // seq_parameter_set_id
+	b.ue(log2MaxFrameNum - 4) // log2_max_frame_num_minus4
+	b.ue(2)                   // pic_order_cnt_type: output order is decoding order
+	b.ue(1)                   // max_num_ref_frames
+	b.u(1, 0)                 // gaps_in_frame_num_value_allowed_flag
+	b.ue(uint32(mbW - 1))     // pic_width_in_mbs_minus1
+	b.ue(uint32(mbH - 1))     // pic_height_in_map_units_minus1
+	b.u(1, 1)                 // frame_mbs_only_flag
+	b.u(1, 1)                 // direct_8x8_inference_flag
+	b.u(1, 0)                 // frame_cropping_flag
+	b.u(1, 0)                 // vui_parameters_present_flag
+	return nal(nalSPS, b.trailing())
+}
+
+func pps() []byte {
+	var b bitWriter
+	b.ue(0)   // pic_parameter_set_id
+	b.ue(0)   // seq_parameter_set_id
+	b.u(1, 0) // entropy_coding_mode_flag: CAVLC
+	b.u(1, 0) // bottom_field_pic_order_in_frame_present_flag
+	b.ue(0)   // num_slice_groups_minus1
+	b.ue(0)   // num_ref_idx_l0_default_active_minus1
+	b.ue(0)   // num_ref_idx_l1_default_active_minus1
+	b.u(1, 0) // weighted_pred_flag
+	b.u(2, 0) // weighted_bipred_idc
+	b.se(0)   // pic_init_qp_minus26
+	b.se(0)   // pic_init_qs_minus26
+	b.se(0)   // chroma_qp_index_offset
+	b.u(1, 1) // deblocking_filter_control_present_flag
+	b.u(1, 0) // constrained_intra_pred_flag
+	b.u(1, 0) // redundant_pic_cnt_present_flag
+	return nal(nalPPS, b.trailing())
+}
+
+// level picks the lowest level whose frame size limit fits the picture.
+func level(mbs int) int {
+	switch {
+	case mbs <= 396:
+		return 21
+	case mbs <= 1620:
+		return 30
+	case mbs <= 3600:
+		return 31
+	case mbs <= 8192:
+		return 40
+	}
+	return 51
+}
+
+// idrSlice codes pic, one 384-byte macroblock of 4:2:0 samples after the
+// other, as an IDR picture.
+func idrSlice(idrID uint32, mbs [][]byte) []byte {
+	var b bitWriter
+	b.ue(0)                 // first_mb_in_slice
+	b.ue(7)                 // slice_type: I, as are all slices of the picture
+	b.ue(0)                 // pic_parameter_set_id
+	b.u(log2MaxFrameNum, 0) // frame_num
+	b.ue(idrID & 0xFFFF)    // idr_pic_id, which differs between consecutive IDRs
+	b.u(1, 0)               // no_output_of_prior_pics_flag
+	b.u(1, 0)               // long_term_reference_flag
+	b.se(0)                 // slice_qp_delta
+	b.ue(1)                 // disable_deblocking_filter_idc
+	for _, mb := range mbs {
+		b.ue(mbTypeIPCM)
+		b.align()
+		b.bytes(mb)
+	}
+	return nal(nalIDR, b.trailing())
+}
+
+// skipSlice codes a P picture repeating its reference.
+func skipSlice(frameNum uint32, mbCount int) []byte {
+	var b bitWriter
+	b.ue(0)                                             // first_mb_in_slice
+	b.ue(5)                                             // slice_type: P, as are all slices of the picture
+	b.ue(0)                                             // pic_parameter_set_id
+	b.u(log2MaxFrameNum, frameNum%(1<<log2MaxFrameNum)) // frame_num
+	b.u(1, 0)                                           // num_ref_idx_active_override_flag
+	b.u(1, 0)                                           // ref_pic_list_modification_flag_l0
+	b.u(1, 0)                                           // adaptive_ref_pic_marking_mode_flag
+	b.se(0)                                             // slice_qp_delta
+	b.ue(1)                                             // disable_deblocking_filter_idc
+	b.ue(uint32(mbCount))                               // mb_skip_run
+	return nal(nalSlice, b.trailing())
+}
+
+// filler returns a filler data NAL unit of n bytes in total, n >= 2.
+func filler(n int) []byte {
+	out := make([]byte, n)
+	out[0] = nalFiller
+	for i := 1; i < n-1; i++ {
+		out[i] = 0xFF
+	}
+	out[n-1] = 0x80
+	return out
+}
+
+// nal prefixes rbsp with its header byte and inserts emulation prevention
+// bytes so no start code appears inside it.
+func nal(header byte, rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+len(rbsp)/64+1)
+	out = append(out, header)
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros == 2 && c <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// bitWriter writes the fixed and Exp-Golomb coded fields of H.264 syntax.
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	bits uint
+}
+
+func (b *bitWriter) u(n uint, v uint32) {
+	for i := int(n) - 1; i >= 0; i-- {
+		b.cur = b.cur<<1 | byte(v>>uint(i)&1)
+		b.bits++
+		if b.bits == 8 {
+			b.buf = append(b.buf, b.cur)
+			b.cur, b.bits = 0, 0
+		}
+	}
+}
+
+func (b *bitWriter) ue(v uint32) {
+	v++
+	n := uint(0)
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	b.u(n, 0)
+	b.u(n+1, v)
+}
+
+func (b *bitWriter) se(v int32) {
+	if v > 0 {
+		b.ue(uint32(2*v - 1))
+	} else {
+		b.ue(uint32(-2 * v))
+	}
+}
+
+func (b *bitWriter) align() {
+	for b.bits != 0 {
+		b.u(1, 0)
+	}
+}
+
+// bytes writes p at a byte boundary.
+func (b *bitWriter) bytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+// trailing ends the RBSP with its stop bit and returns it.
+func (b *bitWriter) trailing() []byte {
+	b.u(1, 1)
+	b.align()
+	return b.buf
+}
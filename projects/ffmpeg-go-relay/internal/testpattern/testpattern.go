@@ -0,0 +1,230 @@
+// Package testpattern generates a synthetic live stream, colour bars with a
+// moving box and silent audio, as the RTMP messages an encoder would publish.
+// It needs no codec libraries, so tools can smoke-test and load-test relays
+// from a bare binary.
+package testpattern
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// Options shape the generated stream. Zero values pick the defaults.
+type Options struct {
+	Width, Height    int           // Multiples of 16; 0 = 320x240
+	FPS              int           // 0 = 25
+	KeyframeInterval time.Duration // 0 = 1s
+	Bitrate          int           // Video bits per second to pad frames up to; 0 pads nothing
+	NoAudio          bool
+}
+
+// Stream parameters of the silent audio track.
+const (
+	audioSampleRate = 44100
+	aacFrameSamples = 1024
+)
+
+var (
+	// AudioSpecificConfig for AAC-LC, 44.1 kHz, mono.
+	aacConfig = []byte{0x12, 0x08}
+	// One AAC-LC frame of silence for that configuration.
+	aacSilence = []byte{0x01, 0x40, 0x20, 0x07}
+)
+
+// BT.601 YCbCr of the colour bars: white, yellow, cyan, green, magenta, red,
+// blue and black.
+var bars = [][3]byte{
+	{235, 128, 128}, {210, 16, 146}, {170, 166, 16}, {145, 54, 34},
+	{106, 202, 222}, {81, 90, 240}, {41, 240, 110}, {16, 128, 128},
+}
+
+// Generator produces the stream frame by frame. It is not safe for
+// concurrent use.
+type Generator struct {
+	opts     Options
+	mbW, mbH int
+	gop      int // Frames per keyframe
+
+	frame      int // Next video frame
+	audioFrame int // Next audio frame
+	idrs       uint32
+	sent       int64 // Video payload bytes so far, for padding
+}
+
+// New returns a generator, or an error for a picture size H.264 cannot code
+// without cropping.
+func New(opts Options) (*Generator, error) {
+	if opts.Width == 0 && opts.Height == 0 {
+		opts.Width, opts.Height = 320, 240
+	}
+	if opts.Width <= 0 || opts.Height <= 0 || opts.Width%16 != 0 || opts.Height%16 != 0 {
+		return nil, fmt.Errorf("testpattern: size %dx%d is not a multiple of 16", opts.Width, opts.Height)
+	}
+	if opts.FPS <= 0 {
+		opts.FPS = 25
+	}
+	if opts.KeyframeInterval <= 0 {
+		opts.KeyframeInterval = time.Second
+	}
+	gop := int(opts.KeyframeInterval * time.Duration(opts.FPS) / time.Second)
+	if gop < 1 {
+		gop = 1
+	}
+	return &Generator{opts: opts, mbW: opts.Width / 16, mbH: opts.Height / 16, gop: gop}, nil
+}
+
+// FrameDuration is the time between video frames.
+func (g *Generator) FrameDuration() time.Duration {
+	return time.Second / time.Duration(g.opts.FPS)
+}
+
+// Headers returns what a publisher sends before any frame: onMetaData and
+// the video and audio sequence headers.
+func (g *Generator) Headers() []*rtmp.Message {
+	meta := map[string]interface{}{
+		"width":         float64(g.opts.Width),
+		"height":        float64(g.opts.Height),
+		"framerate":     float64(g.opts.FPS),
+		"videocodecid":  float64(rtmp.VideoAVC),
+		"videodatarate": float64(g.opts.Bitrate) / 1000,
+		"encoder":       "ffmpeg-go-relay testpattern",
+	}
+	if !g.opts.NoAudio {
+		meta["audiocodecid"] = float64(rtmp.AudioAAC)
+		meta["audiosamplerate"] = float64(audioSampleRate)
+		meta["stereo"] = false
+	}
+	var data bytes.Buffer
+	rtmp.EncodeAMF0(&data, "@setDataFrame", "onMetaData", meta)
+	msgs := []*rtmp.Message{
+		{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeAMF0Data}, Payload: data.Bytes()},
+		{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo}, Payload: g.avcConfig()},
+	}
+	if !g.opts.NoAudio {
+		msgs = append(msgs, &rtmp.Message{
+			Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeAudio},
+			Payload: append([]byte{0xAF, 0}, aacConfig...),
+		})
+	}
+	return msgs
+}
+
+// Next returns the next video frame, preceded by the audio frames due
+// before it, each stamped with its presentation time in milliseconds.
+func (g *Generator) Next() []*rtmp.Message {
+	ts := g.timestamp(g.frame)
+	var msgs []*rtmp.Message
+	if !g.opts.NoAudio {
+		for {
+			ats := uint32(int64(g.audioFrame) * aacFrameSamples * 1000 / audioSampleRate)
+			if ats > ts {
+				break
+			}
+			msgs = append(msgs, &rtmp.Message{
+				Header:  rtmp.ChunkHeader{TypeID: rtmp.TypeAudio, Timestamp: ats},
+				Payload: append([]byte{0xAF, 1}, aacSilence...),
+			})
+			g.audioFrame++
+		}
+	}
+
+	keyframe := g.frame%g.gop == 0
+	var nalu []byte
+	if keyframe {
+		nalu = idrSlice(g.idrs, g.picture(g.idrs))
+		g.idrs++
+	} else {
+		nalu = skipSlice(uint32(g.frame%g.gop), g.mbW*g.mbH)
+	}
+	payload := make([]byte, 5, 9+len(nalu))
+	payload[0] = 0x27
+	if keyframe {
+		payload[0] = 0x17
+	}
+	payload[1] = rtmp.AVCPacketNALU
+	payload = appendNALU(payload, nalu)
+	if pad := g.padding(len(payload)); pad > 0 {
+		payload = appendNALU(payload, filler(pad))
+	}
+	g.sent += int64(len(payload))
+	msgs = append(msgs, &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: ts}, Payload: payload})
+	g.frame++
+	return msgs
+}
+
+func (g *Generator) timestamp(frame int) uint32 {
+	return uint32(int64(frame) * 1000 / int64(g.opts.FPS))
+}
+
+// padding returns the size of the filler NAL unit that brings the video up
+// to the target bitrate, or 0 when it is on target already.
+func (g *Generator) padding(size int) int {
+	if g.opts.Bitrate <= 0 {
+		return 0
+	}
+	due := int64(g.opts.Bitrate) / 8 * int64(g.frame+1) / int64(g.opts.FPS)
+	pad := due - g.sent - int64(size) - 4 // Less the NAL length prefix
+	if pad < 2 {
+		return 0
+	}
+	return int(pad)
+}
+
+// avcConfig returns the AVC sequence header: an AVCDecoderConfigurationRecord
+// with 4-byte NAL lengths.
+func (g *Generator) avcConfig() []byte {
+	s, p := sps(g.mbW, g.mbH), pps()
+	out := []byte{0x17, rtmp.AVCPacketSequenceHeader, 0, 0, 0, 1, s[1], s[2], s[3], 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(s)))
+	out = append(out, s...)
+	out = append(out, 1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(p)))
+	return append(out, p...)
+}
+
+// picture draws the bars and a box that moves one step per keyframe, as
+// macroblocks in raster order.
+func (g *Generator) picture(step uint32) [][]byte {
+	box := min(4, g.mbW, g.mbH) // Box size in macroblocks
+	boxX := int(step) % (g.mbW - box + 1)
+	boxY := (g.mbH - box) / 2
+
+	mbs := make([][]byte, 0, g.mbW*g.mbH)
+	for y := 0; y < g.mbH; y++ {
+		for x := 0; x < g.mbW; x++ {
+			c := bars[x*len(bars)/g.mbW]
+			if x >= boxX && x < boxX+box && y >= boxY && y < boxY+box {
+				c = [3]byte{235, 128, 128}
+				if (x+y)%2 == 0 {
+					c = [3]byte{16, 128, 128}
+				}
+			}
+			mbs = append(mbs, macroblock(c))
+		}
+	}
+	return mbs
+}
+
+// macroblock returns the I_PCM samples of a macroblock of one colour.
+func macroblock(c [3]byte) []byte {
+	mb := make([]byte, 384)
+	for i := 0; i < 256; i++ {
+		mb[i] = c[0]
+	}
+	for i := 256; i < 320; i++ {
+		mb[i] = c[1]
+	}
+	for i := 320; i < 384; i++ {
+		mb[i] = c[2]
+	}
+	return mb
+}
+
+func appendNALU(dst, nalu []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(nalu)))
+	return append(dst, nalu...)
+}
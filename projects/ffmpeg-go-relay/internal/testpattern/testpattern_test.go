@@ -0,0 +1,157 @@
+package testpattern
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// bitReader reads back what bitWriter wrote, from an RBSP with emulation
+// prevention bytes removed.
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) u(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint32(r.buf[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.u(1) == 0 {
+		zeros++
+	}
+	return 1<<zeros - 1 + r.u(zeros)
+}
+
+func unescape(nalu []byte) []byte {
+	var out []byte
+	zeros := 0
+	for _, c := range nalu[1:] {
+		if zeros == 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+func TestExpGolombRoundTrip(t *testing.T) {
+	var w bitWriter
+	values := []uint32{0, 1, 2, 7, 25, 299, 65535}
+	for _, v := range values {
+		w.ue(v)
+	}
+	w.se(-3)
+	w.se(3)
+	r := bitReader{buf: w.trailing()}
+	for _, v := range values {
+		if got := r.ue(); got != v {
+			t.Fatalf("ue = %d, want %d", got, v)
+		}
+	}
+	if got := r.ue(); got != 6 { // se(-3)
+		t.Fatalf("se(-3) coded as %d", got)
+	}
+	if got := r.ue(); got != 5 { // se(3)
+		t.Fatalf("se(3) coded as %d", got)
+	}
+}
+
+func TestNALHasNoStartCodes(t *testing.T) {
+	rbsp := []byte{0, 0, 0, 0, 0, 1, 0, 0, 2, 0, 0, 3, 0, 0, 4}
+	got := nal(0x65, rbsp)
+	for i := 0; i+2 < len(got); i++ {
+		if got[i] == 0 && got[i+1] == 0 && got[i+2] <= 2 {
+			t.Fatalf("start code prefix at %d in %x", i, got)
+		}
+	}
+	if !bytes.Equal(unescape(got), rbsp) {
+		t.Fatalf("unescaped %x, want %x", unescape(got), rbsp)
+	}
+}
+
+func TestSPSDescribesPicture(t *testing.T) {
+	s := sps(40, 30)
+	r := bitReader{buf: unescape(s)}
+	if profile := r.u(8); profile != profileBaseline {
+		t.Fatalf("profile = %d", profile)
+	}
+	r.u(16) // Constraint flags and level
+	r.ue()  // seq_parameter_set_id
+	r.ue()  // log2_max_frame_num_minus4
+	r.ue()  // pic_order_cnt_type
+	r.ue()  // max_num_ref_frames
+	r.u(1)
+	if w, h := r.ue()+1, r.ue()+1; w != 40 || h != 30 {
+		t.Fatalf("picture = %dx%d macroblocks, want 40x30", w, h)
+	}
+}
+
+func TestGeneratorStream(t *testing.T) {
+	if _, err := New(Options{Width: 100, Height: 100}); err == nil {
+		t.Fatal("expected a size that is not a multiple of 16 to fail")
+	}
+	g, err := New(Options{FPS: 10, KeyframeInterval: 500 * time.Millisecond, Bitrate: 2_000_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := g.Headers()
+	if len(headers) != 3 || !headers[1].IsAVCSequenceHeader() || !headers[2].IsAACSequenceHeader() {
+		t.Fatalf("unexpected headers %+v", headers)
+	}
+	if avcC := headers[1].Payload[5:]; avcC[0] != 1 || avcC[1] != profileBaseline || avcC[4] != 0xFF {
+		t.Fatalf("avcC = %x", avcC)
+	}
+
+	var videoBytes, audioFrames, keyframes int
+	var lastVideo uint32
+	for i := 0; i < 30; i++ {
+		for _, msg := range g.Next() {
+			if msg.Header.TypeID == rtmp.TypeAudio {
+				audioFrames++
+				continue
+			}
+			if i > 0 && msg.Header.Timestamp != lastVideo+100 {
+				t.Fatalf("frame %d at %dms after %dms", i, msg.Header.Timestamp, lastVideo)
+			}
+			lastVideo = msg.Header.Timestamp
+			if msg.IsVideoKeyframe() != (i%5 == 0) {
+				t.Fatalf("frame %d keyframe = %v", i, msg.IsVideoKeyframe())
+			}
+			if msg.IsVideoKeyframe() {
+				keyframes++
+			}
+			n := binary.BigEndian.Uint32(msg.Payload[5:])
+			if nalType := msg.Payload[9] & 0x1F; (nalType == 5) != msg.IsVideoKeyframe() {
+				t.Fatalf("frame %d starts with NAL type %d", i, nalType)
+			}
+			if int(n) > len(msg.Payload)-9 {
+				t.Fatalf("frame %d NAL length %d overruns the payload", i, n)
+			}
+			videoBytes += len(msg.Payload)
+		}
+	}
+	// 3 seconds of video at 2 Mbit/s; audio at 44.1 kHz covers the same span.
+	if want := 750_000; videoBytes < want*95/100 || videoBytes > want*105/100 {
+		t.Fatalf("video bytes = %d, want about %d", videoBytes, want)
+	}
+	if keyframes != 6 || audioFrames < 125 || audioFrames > 127 {
+		t.Fatalf("keyframes = %d, audio frames = %d", keyframes, audioFrames)
+	}
+}
@@ -0,0 +1,247 @@
+// Package thumbnail keeps a recent JPEG snapshot of each live stream. Sessions
+// feed it the video they carry; at most once per interval the next keyframe,
+// behind the stream's video sequence header, is handed to a short-lived ffmpeg
+// process that decodes it to a JPEG.
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	// DefaultInterval is the minimum time between snapshots of one stream.
+	DefaultInterval = 10 * time.Second
+	// DefaultQuality is the ffmpeg JPEG qscale used when none is configured.
+	DefaultQuality = 5
+
+	captureTimeout = 10 * time.Second
+)
+
+// Encoder decodes a short FLV holding one keyframe into a JPEG.
+type Encoder func(ctx context.Context, flv []byte) ([]byte, error)
+
+// Snapshot is a stream's latest thumbnail.
+type Snapshot struct {
+	JPEG  []byte
+	Taken time.Time
+}
+
+// Store holds the latest snapshot per stream. A nil Store ignores every call.
+type Store struct {
+	interval time.Duration
+	encode   Encoder
+	log      *logger.Logger
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+type stream struct {
+	seqHeader []byte // FLV tag of the last video sequence header
+	last      time.Time
+	capturing bool
+	snap      *Snapshot
+}
+
+// New returns the store described by cfg, or nil when thumbnails are disabled.
+func New(cfg config.ThumbnailConfig, log *logger.Logger) *Store {
+	if !cfg.Enabled {
+		return nil
+	}
+	quality := cfg.Quality
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+	return newStore(cfg.Interval.AsDuration(), ffmpegEncoder(cfg.Width, quality), log)
+}
+
+func newStore(interval time.Duration, encode Encoder, log *logger.Logger) *Store {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Store{
+		interval: interval,
+		encode:   encode,
+		log:      log,
+		streams:  make(map[string]*stream),
+	}
+}
+
+// Latest returns the newest snapshot of name.
+func (s *Store) Latest(name string) (Snapshot, bool) {
+	if s == nil {
+		return Snapshot{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[name]
+	if st == nil || st.snap == nil {
+		return Snapshot{}, false
+	}
+	return *st.snap, true
+}
+
+// NewTap returns a Tap feeding one session's messages into s.
+func (s *Store) NewTap() *Tap {
+	if s == nil {
+		return nil
+	}
+	return &Tap{store: s}
+}
+
+func (s *Store) observe(name string, msg *rtmp.Message) {
+	seq := msg.IsVideoSequenceHeader()
+	if !seq && !msg.IsVideoKeyframe() {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	st := s.streams[name]
+	if st == nil {
+		st = &stream{}
+		s.streams[name] = st
+	}
+	if seq {
+		st.seqHeader = flvTag(msg)
+		s.mu.Unlock()
+		return
+	}
+	if st.capturing || now.Sub(st.last) < s.interval {
+		s.mu.Unlock()
+		return
+	}
+	st.capturing, st.last = true, now
+	// Copy the frame now: the session may reuse msg once this returns.
+	var flv bytes.Buffer
+	rtmp.WriteFLVHeader(&flv, false, true)
+	flv.Write(st.seqHeader)
+	flv.Write(flvTag(msg))
+	s.mu.Unlock()
+
+	go s.capture(name, st, flv.Bytes())
+}
+
+func (s *Store) capture(name string, st *stream, flv []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), captureTimeout)
+	defer cancel()
+	jpeg, err := s.encode(ctx, flv)
+
+	s.mu.Lock()
+	st.capturing = false
+	if err == nil {
+		st.snap = &Snapshot{JPEG: jpeg, Taken: time.Now()}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.log.Warn("thumbnail capture failed", "stream", name, "err", err)
+	}
+}
+
+func (s *Store) remove(name string) {
+	s.mu.Lock()
+	delete(s.streams, name)
+	s.mu.Unlock()
+}
+
+// flvTag encodes msg as an FLV tag at timestamp zero, so the snapshot FLV
+// starts at the keyframe whatever the stream's clock.
+func flvTag(msg *rtmp.Message) []byte {
+	m := *msg
+	m.Header.Timestamp = 0
+	var buf bytes.Buffer
+	rtmp.MessageToFLVTag(&buf, &m)
+	return buf.Bytes()
+}
+
+// Tap feeds one session's video to a Store under the stream name the client
+// published. Its methods may be called from the session's reader while
+// another goroutine closes it; a nil Tap ignores every call.
+type Tap struct {
+	store *Store
+
+	mu     sync.Mutex
+	stream string
+	closed bool
+}
+
+// SetStream names the stream the session's video belongs to.
+func (t *Tap) SetStream(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || name == t.stream {
+		return
+	}
+	if t.stream != "" {
+		t.store.remove(t.stream)
+	}
+	t.stream = name
+}
+
+// Observe looks at one message from the session.
+func (t *Tap) Observe(msg *rtmp.Message) {
+	if t == nil || msg.Header.TypeID != rtmp.TypeVideo {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.stream == "" {
+		return
+	}
+	t.store.observe(t.stream, msg)
+}
+
+// Close drops the stream's snapshot; the stream is no longer live.
+func (t *Tap) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if t.stream != "" {
+		t.store.remove(t.stream)
+	}
+}
+
+// ffmpegEncoder decodes the first video frame of its input to a JPEG scaled to
+// width (0 keeps the source size) at the given qscale.
+func ffmpegEncoder(width, quality int) Encoder {
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "flv", "-i", "pipe:0", "-frames:v", "1"}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+	args = append(args, "-q:v", strconv.Itoa(quality), "-f", "image2pipe", "-c:v", "mjpeg", "pipe:1")
+
+	return func(ctx context.Context, flv []byte) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+		cmd.Stdin = bytes.NewReader(flv)
+		var out, stderr bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		if out.Len() == 0 {
+			return nil, errors.New("ffmpeg produced no image")
+		}
+		return out.Bytes(), nil
+	}
+}
@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+func video(payload ...byte) *rtmp.Message {
+	return &rtmp.Message{Header: rtmp.ChunkHeader{TypeID: rtmp.TypeVideo, Timestamp: 5000}, Payload: payload}
+}
+
+func waitSnapshot(t *testing.T, s *Store, name string) Snapshot {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if snap, ok := s.Latest(name); ok {
+			return snap
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot taken")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTapCapturesKeyframes(t *testing.T) {
+	inputs := make(chan []byte, 4)
+	s := newStore(time.Hour, func(ctx context.Context, flv []byte) ([]byte, error) {
+		inputs <- flv
+		return []byte("jpeg"), nil
+	}, logger.NewWithWriter(io.Discard))
+
+	tap := s.NewTap()
+	tap.Observe(video(0x17, 1, 0, 0, 0, 0xAA)) // before publish: ignored
+	tap.SetStream("cam1")
+	tap.Observe(video(0x17, 0, 0, 0, 0, 0x01)) // AVC sequence header
+	tap.Observe(video(0x27, 1, 0, 0, 0, 0xBB)) // inter frame
+	tap.Observe(video(0x17, 1, 0, 0, 0, 0xCC)) // keyframe
+
+	if snap := waitSnapshot(t, s, "cam1"); string(snap.JPEG) != "jpeg" {
+		t.Fatalf("snapshot = %q", snap.JPEG)
+	}
+	flv := <-inputs
+	if !bytes.HasPrefix(flv, []byte("FLV")) {
+		t.Fatal("encoder input is not an FLV")
+	}
+	seq := bytes.Index(flv, []byte{0x17, 0, 0, 0, 0, 0x01})
+	key := bytes.Index(flv, []byte{0x17, 1, 0, 0, 0, 0xCC})
+	if seq < 0 || key < seq || bytes.Contains(flv, []byte{0xBB}) || bytes.Contains(flv, []byte{0xAA}) {
+		t.Fatalf("encoder input % x, want the sequence header then the keyframe only", flv)
+	}
+
+	// Within the interval no further capture starts.
+	tap.Observe(video(0x17, 1, 0, 0, 0, 0xDD))
+	select {
+	case <-inputs:
+		t.Fatal("captured again within the interval")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	tap.Close()
+	if _, ok := s.Latest("cam1"); ok {
+		t.Fatal("snapshot kept after the session closed")
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	tap := s.NewTap()
+	tap.SetStream("cam1")
+	tap.Observe(video(0x17, 1))
+	tap.Close()
+	if _, ok := s.Latest("cam1"); ok {
+		t.Fatal("nil store returned a snapshot")
+	}
+}
@@ -0,0 +1,249 @@
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	_ "crypto/sha1" // OCSP CertIDs hash with SHA-1
+)
+
+// The structures below follow RFC 6960, trimmed to what a stapling server
+// sends and reads.
+
+type ocspRequest struct {
+	TBSRequest tbsRequest
+}
+
+type tbsRequest struct {
+	RequestList []request
+}
+
+type request struct {
+	Cert certID
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []singleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID           certID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          revokedInfo      `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+const maxOCSPResponseSize = 1 << 20
+
+var (
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	errOCSPRevoked = errors.New("tlscert: OCSP responder reports the certificate revoked")
+)
+
+// signatureAlgorithms maps the OCSP signature OIDs CAs use in practice.
+var signatureAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	algo x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+// ocspResponse is a checked response for the leaf.
+type ocspResponse struct {
+	raw        []byte
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+func newCertID(leaf, issuer *x509.Certificate) (certID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, fmt.Errorf("tlscert: parse issuer public key: %w", err)
+	}
+	h := crypto.SHA1.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	return certID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash,
+		IssuerKeyHash: h.Sum(nil),
+		SerialNumber:  leaf.SerialNumber,
+	}, nil
+}
+
+func newOCSPRequest(leaf, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{tbsRequest{[]request{{id}}}})
+}
+
+func postOCSP(ctx context.Context, client *http.Client, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tlscert: OCSP request to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tlscert: OCSP responder %s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+}
+
+// parseOCSPResponse checks that raw is a successful, signed, current "good"
+// answer about leaf. The signer is the issuer or a responder certificate the
+// issuer delegated OCSP signing to.
+func parseOCSPResponse(raw []byte, leaf, issuer *x509.Certificate, now time.Time) (*ocspResponse, error) {
+	var outer responseASN1
+	if rest, err := asn1.Unmarshal(raw, &outer); err != nil {
+		return nil, fmt.Errorf("tlscert: parse OCSP response: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("tlscert: trailing data after OCSP response")
+	}
+	if outer.Status != 0 {
+		return nil, fmt.Errorf("tlscert: OCSP responder returned status %d", outer.Status)
+	}
+	if !outer.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("tlscert: unsupported OCSP response type %s", outer.Response.ResponseType)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(outer.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("tlscert: parse basic OCSP response: %w", err)
+	}
+	if err := checkOCSPSignature(&basic, issuer); err != nil {
+		return nil, err
+	}
+
+	want, err := newCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber == nil || r.CertID.SerialNumber.Cmp(want.SerialNumber) != 0 ||
+			!bytes.Equal(r.CertID.NameHash, want.NameHash) {
+			continue
+		}
+		switch {
+		case !r.Revoked.RevocationTime.IsZero():
+			return nil, errOCSPRevoked
+		case bool(r.Unknown):
+			return nil, errors.New("tlscert: OCSP responder does not know the certificate")
+		}
+		if r.ThisUpdate.After(now.Add(time.Minute)) {
+			return nil, errors.New("tlscert: OCSP response is not yet valid")
+		}
+		if !r.NextUpdate.IsZero() && now.After(r.NextUpdate) {
+			return nil, errors.New("tlscert: OCSP response is stale")
+		}
+		return &ocspResponse{raw: raw, thisUpdate: r.ThisUpdate, nextUpdate: r.NextUpdate}, nil
+	}
+	return nil, errors.New("tlscert: OCSP response does not cover the certificate")
+}
+
+func checkOCSPSignature(basic *basicResponse, issuer *x509.Certificate) error {
+	algo := x509.UnknownSignatureAlgorithm
+	for _, a := range signatureAlgorithms {
+		if a.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = a.algo
+			break
+		}
+	}
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("tlscert: unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	signed, sig := basic.TBSResponseData.Raw, basic.Signature.RightAlign()
+
+	if issuer.CheckSignature(algo, signed, sig) == nil {
+		return nil
+	}
+	for _, rawCert := range basic.Certificates {
+		responder, err := x509.ParseCertificate(rawCert.FullBytes)
+		if err != nil {
+			continue
+		}
+		if !hasOCSPSigning(responder) || responder.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		if responder.CheckSignature(algo, signed, sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("tlscert: OCSP response is not signed by the issuer or its responder")
+}
+
+func hasOCSPSigning(c *x509.Certificate) bool {
+	for _, u := range c.ExtKeyUsage {
+		if u == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
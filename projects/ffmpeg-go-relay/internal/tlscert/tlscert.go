@@ -0,0 +1,251 @@
+// Package tlscert serves the relay's TLS certificate. It checks the chain
+// when loading it, exports how long each certificate has left, and keeps an
+// OCSP response stapled to the certificate so clients that insist on
+// revocation checks do not have to reach the CA themselves.
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+const (
+	// DefaultRefresh is the longest wait between OCSP fetches.
+	DefaultRefresh = time.Hour
+
+	// DefaultExpiryWarning matches the renewal window of most ACME clients.
+	DefaultExpiryWarning = 30 * 24 * time.Hour
+
+	// minRefresh keeps a failing responder from being hammered.
+	minRefresh = time.Minute
+
+	fetchTimeout = 10 * time.Second
+)
+
+// Options configures a Manager.
+type Options struct {
+	OCSP          bool
+	Refresh       time.Duration // 0 uses DefaultRefresh
+	ExpiryWarning time.Duration // 0 uses DefaultExpiryWarning
+	Metrics       *metrics.Registry
+	Log           *logger.Logger
+	Client        *http.Client // nil uses a client with a short timeout
+}
+
+// Manager hands out the current certificate to TLS listeners.
+type Manager struct {
+	opts   Options
+	chain  []*x509.Certificate
+	client *http.Client
+	cert   atomic.Pointer[tls.Certificate]
+
+	mu         sync.Mutex // Guards the OCSP schedule
+	nextUpdate time.Time
+	lastErr    error
+}
+
+// Load reads a PEM key pair whose certificate file holds the leaf followed by
+// its intermediates. A chain that is out of order or not currently valid is
+// an error; one that does not verify against the system roots is only logged,
+// since private CAs are common for ingest endpoints.
+func Load(certFile, keyFile string, opts Options) (*Manager, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("tlscert: parse certificate: %w", err)
+		}
+		chain = append(chain, c)
+	}
+	now := time.Now()
+	if err := CheckChain(chain, now); err != nil {
+		return nil, err
+	}
+	cert.Leaf = chain[0]
+
+	if opts.Refresh <= 0 {
+		opts.Refresh = DefaultRefresh
+	}
+	if opts.ExpiryWarning <= 0 {
+		opts.ExpiryWarning = DefaultExpiryWarning
+	}
+	m := &Manager{opts: opts, chain: chain, client: opts.Client}
+	if m.client == nil {
+		m.client = &http.Client{Timeout: fetchTimeout}
+	}
+	m.cert.Store(&cert)
+
+	if err := verifySystemRoots(chain, now); err != nil {
+		m.warn("TLS chain does not verify against the system roots", "err", err)
+	}
+	if opts.OCSP {
+		switch {
+		case len(chain) < 2:
+			m.warn("OCSP stapling disabled: certificate file has no issuer certificate")
+			m.opts.OCSP = false
+		case len(chain[0].OCSPServer) == 0:
+			m.warn("OCSP stapling disabled: certificate names no OCSP responder")
+			m.opts.OCSP = false
+		}
+	}
+	m.checkExpiry(now)
+	return m, nil
+}
+
+// CheckChain reports whether every certificate is valid at now and signed by
+// the one after it.
+func CheckChain(chain []*x509.Certificate, now time.Time) error {
+	if len(chain) == 0 {
+		return errors.New("tlscert: empty certificate chain")
+	}
+	for i, c := range chain {
+		if now.Before(c.NotBefore) {
+			return fmt.Errorf("tlscert: %s is not valid before %s", c.Subject, c.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(c.NotAfter) {
+			return fmt.Errorf("tlscert: %s expired at %s", c.Subject, c.NotAfter.Format(time.RFC3339))
+		}
+		if i+1 < len(chain) {
+			if err := c.CheckSignatureFrom(chain[i+1]); err != nil {
+				return fmt.Errorf("tlscert: %s is not signed by the next certificate %s: %w", c.Subject, chain[i+1].Subject, err)
+			}
+		}
+	}
+	return nil
+}
+
+func verifySystemRoots(chain []*x509.Certificate, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: now})
+	return err
+}
+
+// GetCertificate is a tls.Config callback returning the current certificate
+// with its latest staple.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.cert.Load(), nil
+}
+
+// TLSConfig returns a server configuration serving m's certificate.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// Staple fetches an OCSP response for the leaf and attaches it. It is a no-op
+// when stapling is off. A revoked or unparseable response leaves the previous
+// staple in place.
+func (m *Manager) Staple(ctx context.Context) error {
+	if !m.opts.OCSP {
+		return nil
+	}
+	resp, err := m.fetchStaple(ctx)
+
+	m.mu.Lock()
+	m.lastErr = err
+	if err == nil {
+		m.nextUpdate = resp.nextUpdate
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.opts.Metrics.RecordOCSPRefresh("error")
+		return err
+	}
+	next := *m.cert.Load()
+	next.OCSPStaple = resp.raw
+	m.cert.Store(&next)
+	m.opts.Metrics.RecordOCSPRefresh("ok")
+	return nil
+}
+
+func (m *Manager) fetchStaple(ctx context.Context) (*ocspResponse, error) {
+	leaf, issuer := m.chain[0], m.chain[1]
+	req, err := newOCSPRequest(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	raw, err := postOCSP(ctx, m.client, leaf.OCSPServer[0], req)
+	if err != nil {
+		return nil, err
+	}
+	return parseOCSPResponse(raw, leaf, issuer, time.Now())
+}
+
+// Run re-checks expiry and refreshes the staple until ctx is done. Staples
+// are renewed halfway to their nextUpdate, and at least every Refresh.
+func (m *Manager) Run(ctx context.Context) {
+	timer := time.NewTimer(m.nextRefresh(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		now := time.Now()
+		m.checkExpiry(now)
+		if err := m.Staple(ctx); err != nil && ctx.Err() == nil {
+			m.warn("OCSP staple refresh failed", "err", err)
+		}
+		timer.Reset(m.nextRefresh(now))
+	}
+}
+
+func (m *Manager) nextRefresh(now time.Time) time.Duration {
+	if !m.opts.OCSP {
+		return m.opts.Refresh
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lastErr != nil {
+		return minRefresh
+	}
+	wait := m.opts.Refresh
+	if !m.nextUpdate.IsZero() {
+		if half := m.nextUpdate.Sub(now) / 2; half < wait {
+			wait = half
+		}
+	}
+	return max(wait, minRefresh)
+}
+
+// checkExpiry exports the remaining lifetime of every certificate in the
+// chain and warns about those inside the warning window.
+func (m *Manager) checkExpiry(now time.Time) {
+	for _, c := range m.chain {
+		remaining := c.NotAfter.Sub(now)
+		expiring := remaining < m.opts.ExpiryWarning
+		m.opts.Metrics.SetTLSCertExpiry(c.Subject.String(), remaining, expiring)
+		if expiring {
+			m.warn("TLS certificate expires soon", "subject", c.Subject.String(), "not_after", c.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+func (m *Manager) warn(msg string, kv ...any) {
+	if m.opts.Log != nil {
+		m.opts.Log.Warn(msg, kv...)
+	}
+}
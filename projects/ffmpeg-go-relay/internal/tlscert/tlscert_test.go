@@ -0,0 +1,252 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"ffmpeg-go-relay/internal/metrics"
+)
+
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	leaf   *x509.Certificate
+	leafPK *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T, ocspURL string, leafLifetime time.Duration) *testPKI {
+	t.Helper()
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "relay.example"},
+		DNSNames:     []string{"relay.example"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ocspURL != "" {
+		leafTmpl.OCSPServer = []string{ocspURL}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	return &testPKI{ca: ca, caKey: caKey, leaf: leaf, leafPK: leafKey}
+}
+
+// writeFiles stores the leaf and CA as one chain file plus the leaf key.
+func (p *testPKI) writeFiles(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "chain.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	var chain []byte
+	for _, c := range []*x509.Certificate{p.leaf, p.ca} {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(p.leafPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, chain, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// ocspResponse signs a response about the leaf with the CA key.
+func (p *testPKI) ocspResponse(t *testing.T, revoked bool, nextUpdate time.Time) []byte {
+	t.Helper()
+	id, err := newCertID(p.leaf, p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	single := singleResponse{CertID: id, Good: asn1.Flag(!revoked), ThisUpdate: now, NextUpdate: nextUpdate.UTC().Truncate(time.Second)}
+	if revoked {
+		single.Revoked = revokedInfo{RevocationTime: now.Add(-time.Minute)}
+	}
+	tbs, err := asn1.Marshal(responseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: p.ca.RawSubject},
+		ProducedAt:     now,
+		Responses:      []singleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, p.caKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := asn1.Marshal(basicResponse{
+		TBSResponseData:    responseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := asn1.Marshal(responseASN1{Response: responseBytes{ResponseType: oidOCSPBasic, Response: basic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCheckChain(t *testing.T) {
+	p := newTestPKI(t, "", 90*24*time.Hour)
+	now := time.Now()
+	if err := CheckChain([]*x509.Certificate{p.leaf, p.ca}, now); err != nil {
+		t.Fatalf("valid chain: %v", err)
+	}
+	if err := CheckChain([]*x509.Certificate{p.ca, p.leaf}, now); err == nil {
+		t.Fatal("expected an out-of-order chain to be rejected")
+	}
+	if err := CheckChain([]*x509.Certificate{p.leaf, p.ca}, now.Add(100*24*time.Hour)); err == nil {
+		t.Fatal("expected an expired leaf to be rejected")
+	}
+}
+
+func TestLoadStaplesOCSPAndExportsExpiry(t *testing.T) {
+	var revoked atomic.Bool
+	var p *testPKI
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ocspRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.TBSRequest.RequestList[0].Cert.SerialNumber.Cmp(p.leaf.SerialNumber) != 0 {
+			http.Error(w, "wrong serial", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(p.ocspResponse(t, revoked.Load(), time.Now().Add(4*time.Hour)))
+	}))
+	defer srv.Close()
+	p = newTestPKI(t, srv.URL, 10*24*time.Hour)
+	certFile, keyFile := p.writeFiles(t)
+
+	reg := prometheus.NewRegistry()
+	mr, err := metrics.NewRegistry(reg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(certFile, keyFile, Options{OCSP: true, Metrics: mr})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := m.Staple(context.Background()); err != nil {
+		t.Fatalf("Staple: %v", err)
+	}
+	cert, _ := m.GetCertificate(nil)
+	staple := cert.OCSPStaple
+	if len(staple) == 0 {
+		t.Fatal("expected an OCSP staple after a successful fetch")
+	}
+	if wait := m.nextRefresh(time.Now()); wait < time.Hour || wait > 2*time.Hour {
+		t.Fatalf("next refresh in %v, want about half of the 4h validity", wait)
+	}
+
+	revoked.Store(true)
+	if err := m.Staple(context.Background()); !errors.Is(err, errOCSPRevoked) {
+		t.Fatalf("Staple with revoked response = %v, want errOCSPRevoked", err)
+	}
+	if cert, _ := m.GetCertificate(nil); string(cert.OCSPStaple) != string(staple) {
+		t.Fatal("a revoked response must not replace the previous staple")
+	}
+	if wait := m.nextRefresh(time.Now()); wait != minRefresh {
+		t.Fatalf("next refresh after failure = %v, want %v", wait, minRefresh)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expiring, refreshes float64
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			switch f.GetName() {
+			case "rtmp_relay_tls_cert_expiring":
+				for _, l := range metric.GetLabel() {
+					if l.GetValue() == "CN=relay.example" {
+						expiring = metric.GetGauge().GetValue()
+					}
+				}
+			case "rtmp_relay_ocsp_refreshes_total":
+				refreshes += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	if expiring != 1 {
+		t.Fatalf("tls_cert_expiring for a 10 day leaf = %v, want 1", expiring)
+	}
+	if refreshes != 2 {
+		t.Fatalf("ocsp refreshes = %v, want 2", refreshes)
+	}
+}
+
+func TestLoadWithoutResponderDisablesStapling(t *testing.T) {
+	p := newTestPKI(t, "", 90*24*time.Hour)
+	certFile, keyFile := p.writeFiles(t)
+	m, err := Load(certFile, keyFile, Options{OCSP: true})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := m.Staple(context.Background()); err != nil {
+		t.Fatalf("Staple should be a no-op without a responder, got %v", err)
+	}
+	if wait := m.nextRefresh(time.Now()); wait != DefaultRefresh {
+		t.Fatalf("refresh = %v, want %v", wait, DefaultRefresh)
+	}
+}
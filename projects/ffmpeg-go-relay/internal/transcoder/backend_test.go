@@ -1,9 +1,14 @@
 package transcoder
 
 import (
+	"context"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
 )
 
 func TestResolveBackendDefault(t *testing.T) {
@@ -11,8 +16,8 @@ func TestResolveBackendDefault(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
-	if backend != backendFFmpeg {
-		t.Fatalf("expected %s, got %s", backendFFmpeg, backend)
+	if backend != backendAuto {
+		t.Fatalf("expected %s, got %s", backendAuto, backend)
 	}
 }
 
@@ -31,3 +36,76 @@ func TestResolveBackendUnknown(t *testing.T) {
 		t.Fatal("expected error for unknown backend")
 	}
 }
+
+type namedBackend string
+
+func (namedBackend) Write(p []byte) (int, error) { return len(p), nil }
+func (namedBackend) Close() error                { return nil }
+
+func TestAutoBackendFallback(t *testing.T) {
+	log := logger.NewWithWriter(io.Discard)
+	started := func(name string, err error) func(context.Context) (Backend, error) {
+		return func(context.Context) (Backend, error) {
+			if err != nil {
+				return nil, err
+			}
+			return namedBackend(name), nil
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		cfg      config.TranscodeConfig
+		unusable error
+		libavErr error
+		want     Backend
+	}{
+		{"libav usable", config.TranscodeConfig{}, nil, nil, namedBackend("libav")},
+		{"extra ffmpeg arguments", config.TranscodeConfig{ExtraOutputArgs: []string{"-tune", "zerolatency"}}, nil, nil, namedBackend("ffmpeg")},
+		{"libav not built", config.TranscodeConfig{}, errors.New("libav backend not enabled; build with -tags libav"), nil, namedBackend("ffmpeg")},
+		{"encoder missing", config.TranscodeConfig{}, errors.New("encoder libx265 not found"), nil, namedBackend("ffmpeg")},
+		{"libav fails to start", config.TranscodeConfig{}, nil, errors.New("open output"), namedBackend("ffmpeg")},
+	} {
+		b, err := startAuto(context.Background(), tc.cfg, tc.unusable, log, started("libav", tc.libavErr), started("ffmpeg", nil))
+		if err != nil || b != tc.want {
+			t.Errorf("%s: started %v, %v; want %v", tc.name, b, err, tc.want)
+		}
+	}
+
+	// When ffmpeg cannot start either, its error is the one returned.
+	if _, err := startAuto(context.Background(), config.TranscodeConfig{}, nil, log, started("libav", errors.New("libav")), started("", errors.New("ffmpeg binary not found"))); err == nil || err.Error() != "ffmpeg binary not found" {
+		t.Fatalf("both failing = %v, want the ffmpeg error", err)
+	}
+}
+
+func TestLibAVUsableWithoutLibAV(t *testing.T) {
+	if libavBuilt {
+		t.Skip("built with libav")
+	}
+	if err := libavUsable(config.TranscodeConfig{}, "rtmp://upstream/live/"); err == nil {
+		t.Fatal("libav reported usable in a build without it")
+	}
+}
+
+func TestLinePrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := &linePrefixWriter{w: &out, prefix: []byte("[id] ")}
+	for _, chunk := range []string{"Input #0", ", flv\nframe=1\r", "frame=2\r\nerr", "or\n"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	want := "[id] Input #0, flv\n[id] frame=1\r[id] frame=2\r\n[id] error\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	if id := requestIDFromContext(context.Background()); id != "" {
+		t.Fatalf("empty context id = %q", id)
+	}
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	if id := requestIDFromContext(ctx); id != "req-1" {
+		t.Fatalf("id = %q, want req-1", id)
+	}
+}
@@ -0,0 +1,169 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Capabilities describes what this host can transcode with, so orchestration
+// can schedule a job only where its encoder exists.
+type Capabilities struct {
+	ProbedAt time.Time           `json:"probed_at"`
+	FFmpeg   BackendCapabilities `json:"ffmpeg"`
+	LibAV    BackendCapabilities `json:"libav"`
+	Devices  []string            `json:"devices"` // GPU device nodes, e.g. /dev/dri/renderD128
+}
+
+// BackendCapabilities describes one transcode backend.
+type BackendCapabilities struct {
+	Available bool              `json:"available"`
+	Error     string            `json:"error,omitempty"` // Why the backend is not available
+	Version   string            `json:"version,omitempty"`
+	Libraries map[string]string `json:"libraries,omitempty"` // e.g. "libavcodec": "61.19.100"
+	Encoders  []Encoder         `json:"encoders,omitempty"`
+	// HWAccels are the hardware acceleration methods: for ffmpeg those it was
+	// built with, for libav those a device could be opened for.
+	HWAccels []string `json:"hwaccels,omitempty"`
+}
+
+// Encoder is one encoder a backend offers.
+type Encoder struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // video, audio or subtitle
+	Hardware bool   `json:"hardware"`
+}
+
+// hardwareEncoderSuffixes mark the encoders that run on a GPU or other
+// dedicated hardware, such as h264_nvenc and hevc_vaapi.
+var hardwareEncoderSuffixes = []string{
+	"_amf", "_d3d12va", "_mediacodec", "_mf", "_nvenc", "_omx", "_qsv",
+	"_rkmpp", "_v4l2m2m", "_vaapi", "_videotoolbox", "_vulkan",
+}
+
+// deviceGlobs match the device nodes hardware encoders open.
+var deviceGlobs = []string{"/dev/dri/renderD*", "/dev/nvidia[0-9]*"}
+
+// ProbeCapabilities asks the ffmpeg binary and the linked libav libraries
+// which encoders and hardware acceleration methods they offer. A backend
+// that cannot be probed is reported unavailable, with the reason.
+func ProbeCapabilities(ctx context.Context) *Capabilities {
+	c := &Capabilities{
+		ProbedAt: time.Now().UTC(),
+		FFmpeg:   probeFFmpeg(ctx),
+		LibAV:    probeLibAV(),
+		Devices:  []string{},
+	}
+	for _, pattern := range deviceGlobs {
+		matches, _ := filepath.Glob(pattern)
+		c.Devices = append(c.Devices, matches...)
+	}
+	return c
+}
+
+func probeFFmpeg(ctx context.Context) BackendCapabilities {
+	run := func(arg string) ([]byte, error) {
+		return exec.CommandContext(ctx, "ffmpeg", "-hide_banner", arg).Output()
+	}
+	out, err := run("-version")
+	if err != nil {
+		return BackendCapabilities{Error: err.Error()}
+	}
+	caps := BackendCapabilities{Available: true}
+	caps.Version, caps.Libraries = parseFFmpegVersion(out)
+	if out, err = run("-encoders"); err != nil {
+		caps.Error = "list encoders: " + err.Error()
+		return caps
+	}
+	caps.Encoders = parseFFmpegEncoders(out)
+	if out, err = run("-hwaccels"); err != nil {
+		caps.Error = "list hwaccels: " + err.Error()
+		return caps
+	}
+	caps.HWAccels = parseFFmpegHWAccels(out)
+	return caps
+}
+
+// parseFFmpegVersion reads "ffmpeg -version": the release from its first
+// line and the library versions from the "libavcodec 61. 19.100 / ..." lines.
+func parseFFmpegVersion(out []byte) (string, map[string]string) {
+	var version string
+	libraries := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "ffmpeg version "); ok {
+			version, _, _ = strings.Cut(rest, " ")
+			continue
+		}
+		name, rest, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(name, "lib") {
+			continue
+		}
+		// The runtime version, after the slash, is the one in use.
+		if _, runtime, ok := strings.Cut(rest, "/"); ok {
+			rest = runtime
+		}
+		libraries[name] = strings.ReplaceAll(strings.TrimSpace(rest), " ", "")
+	}
+	return version, libraries
+}
+
+// parseFFmpegEncoders reads "ffmpeg -encoders": a legend, a "------" line,
+// then one "V....D libx264  description" line per encoder.
+func parseFFmpegEncoders(out []byte) []Encoder {
+	var encoders []Encoder
+	listed := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !listed {
+			listed = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		var kind string
+		switch fields[0][0] {
+		case 'V':
+			kind = "video"
+		case 'A':
+			kind = "audio"
+		case 'S':
+			kind = "subtitle"
+		default:
+			continue
+		}
+		encoders = append(encoders, Encoder{Name: fields[1], Type: kind, Hardware: isHardwareEncoder(fields[1])})
+	}
+	return encoders
+}
+
+// parseFFmpegHWAccels reads "ffmpeg -hwaccels": a heading, then one method
+// per line.
+func parseFFmpegHWAccels(out []byte) []string {
+	var methods []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		methods = append(methods, line)
+	}
+	return methods
+}
+
+func isHardwareEncoder(name string) bool {
+	for _, suffix := range hardwareEncoderSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
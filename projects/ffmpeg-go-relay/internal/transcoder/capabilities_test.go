@@ -0,0 +1,56 @@
+package transcoder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFFmpegVersion(t *testing.T) {
+	out := []byte(`ffmpeg version 7.1-1ubuntu1 Copyright (c) 2000-2024 the FFmpeg developers
+built with gcc 14 (Ubuntu 14.2.0-8ubuntu1)
+configuration: --prefix=/usr --enable-libx264 --enable-nvenc
+libavutil      59. 39.100 / 59. 39.100
+libavcodec     61. 19.100 / 61. 19.101
+libavformat    61.  7.100 / 61.  7.100
+`)
+	version, libraries := parseFFmpegVersion(out)
+	if version != "7.1-1ubuntu1" {
+		t.Errorf("version = %q", version)
+	}
+	want := map[string]string{"libavutil": "59.39.100", "libavcodec": "61.19.101", "libavformat": "61.7.100"}
+	if !reflect.DeepEqual(libraries, want) {
+		t.Errorf("libraries = %v, want %v", libraries, want)
+	}
+}
+
+func TestParseFFmpegEncoders(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)
+ V....D h264_nvenc           NVIDIA NVENC H.264 encoder (codec h264)
+ V....D hevc_vaapi           H.265/HEVC (VAAPI) (codec hevc)
+ A....D aac                  AAC (Advanced Audio Coding)
+ S..... mov_text             3GPP Timed Text subtitle
+`)
+	want := []Encoder{
+		{Name: "libx264", Type: "video"},
+		{Name: "h264_nvenc", Type: "video", Hardware: true},
+		{Name: "hevc_vaapi", Type: "video", Hardware: true},
+		{Name: "aac", Type: "audio"},
+		{Name: "mov_text", Type: "subtitle"},
+	}
+	if got := parseFFmpegEncoders(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("encoders = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseFFmpegHWAccels(t *testing.T) {
+	out := []byte("Hardware acceleration methods:\nvdpau\ncuda\nvaapi\n\n")
+	if got, want := parseFFmpegHWAccels(out), []string{"vdpau", "cuda", "vaapi"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hwaccels = %q, want %q", got, want)
+	}
+}
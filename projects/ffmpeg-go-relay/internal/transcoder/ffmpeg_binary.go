@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"ffmpeg-go-relay/internal/config"
@@ -15,6 +16,11 @@ import (
 type ffmpegBackend struct {
 	cmd   *exec.Cmd
 	stdin io.WriteCloser
+
+	progress     progressState
+	progressDone chan struct{}
+	usage        usageState
+	release      func() // Removes the process's cgroup, if it has one
 }
 
 func newFFmpegBackend(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
@@ -22,6 +28,151 @@ func newFFmpegBackend(ctx context.Context, cfg config.TranscodeConfig, upstream
 		return nil, fmt.Errorf("ffmpeg binary not found: %w", err)
 	}
 
+	args, err := ffmpegArgs(cfg, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("starting ffmpeg", "args", strings.Join(args, " "))
+
+	// Progress blocks go to stdout; the output itself goes to the upstream URL.
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-progress", "pipe:1"}, args...)...)
+	cmd.Stderr = os.Stderr
+	if id := requestIDFromContext(ctx); id != "" {
+		cmd.Stderr = &linePrefixWriter{w: os.Stderr, prefix: []byte("[request_id=" + id + "] ")}
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	b := &ffmpegBackend{
+		cmd:          cmd,
+		stdin:        stdin,
+		progressDone: make(chan struct{}),
+		release:      limitProcess(cmd.Process.Pid, cfg.Limits, log),
+	}
+	go func() {
+		defer close(b.progressDone)
+		readFFmpegProgress(stdout, b.progress.store)
+	}()
+	return b, nil
+}
+
+func (t *ffmpegBackend) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+func (t *ffmpegBackend) Close() error {
+	_ = t.stdin.Close()
+	// Wait closes stdout, so the progress reader has to drain it first.
+	<-t.progressDone
+	err := t.cmd.Wait()
+	t.release()
+	return err
+}
+
+func (t *ffmpegBackend) Progress() (Progress, bool) {
+	return t.progress.Progress()
+}
+
+func (t *ffmpegBackend) Usage() (Usage, bool) {
+	return t.usage.sample(t.cmd.Process.Pid)
+}
+
+// linePrefixWriter writes prefix at the start of every line. ffmpeg ends its
+// status lines with a carriage return, so that counts as a line end too.
+type linePrefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+	afterCR bool // The last byte was '\r', so a '\n' only completes that line end
+	buf     []byte
+}
+
+func (l *linePrefixWriter) Write(p []byte) (int, error) {
+	l.buf = l.buf[:0]
+	for _, c := range p {
+		if !l.midLine && !(c == '\n' && l.afterCR) {
+			l.buf = append(l.buf, l.prefix...)
+			l.midLine = true
+		}
+		l.buf = append(l.buf, c)
+		l.afterCR = c == '\r'
+		if c == '\n' || c == '\r' {
+			l.midLine = false
+		}
+	}
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ffmpegArgs builds the command line. With renditions the video is split in
+// one filter graph, so the input is decoded once and each rendition gets its
+// own encoder and output, muxed for its URL's scheme.
+func ffmpegArgs(cfg config.TranscodeConfig, upstream string) ([]string, error) {
+	args := append([]string{"-re"}, cfg.ExtraInputArgs...)
+	args = append(args, "-i", "pipe:0")
+
+	encoder, err := ffmpegEncoderArgs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := videoOutputs(cfg, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Renditions) == 0 {
+		out := outputs[0]
+		args = append(args, encoder...)
+		if vf := joinFilters(cfg.VideoFilter, out.filter); vf != "" {
+			args = append(args, "-vf", vf)
+		}
+		args = append(args, out.rateControlArgs()...)
+		args = append(args, cfg.ExtraOutputArgs...)
+		args = append(args, muxerArgs(out.url)...)
+		return append(args, out.url), nil
+	}
+
+	// The passthrough filter runs once, ahead of the split.
+	graph := "[0:v]"
+	if cfg.VideoFilter != "" {
+		graph += cfg.VideoFilter + ","
+	}
+	graph += fmt.Sprintf("split=%d", len(outputs))
+	for i := range outputs {
+		graph += fmt.Sprintf("[v%d]", i)
+	}
+	for i, out := range outputs {
+		graph += fmt.Sprintf(";[v%d]%s[out%d]", i, out.filter, i)
+	}
+	args = append(args, "-filter_complex", graph)
+
+	for i, out := range outputs {
+		args = append(args, "-map", fmt.Sprintf("[out%d]", i), "-map", "0:a?")
+		args = append(args, encoder...)
+		args = append(args, out.rateControlArgs()...)
+		args = append(args, cfg.ExtraOutputArgs...)
+		args = append(args, muxerArgs(out.url)...)
+		args = append(args, out.url)
+	}
+	return args, nil
+}
+
+// ffmpegEncoderArgs are the codec settings shared by every output.
+func ffmpegEncoderArgs(cfg config.TranscodeConfig) ([]string, error) {
 	vCodec := "libx264"
 	if cfg.VideoCodec != "" {
 		vCodec = cfg.VideoCodec
@@ -32,8 +183,6 @@ func newFFmpegBackend(ctx context.Context, cfg config.TranscodeConfig, upstream
 	}
 
 	args := []string{
-		"-re",
-		"-i", "pipe:0",
 		"-c:v", vCodec,
 		"-c:a", aCodec,
 	}
@@ -51,34 +200,16 @@ func newFFmpegBackend(ctx context.Context, cfg config.TranscodeConfig, upstream
 		}
 		args = append(args, gopFlags...)
 	}
-
-	args = append(args, "-f", "flv", upstream)
-
-	log.Info("starting ffmpeg", "args", strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stderr = os.Stderr
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, fmt.Errorf("stdin pipe: %w", err)
+	if cfg.AudioBitrate != "" {
+		args = append(args, "-b:a", strings.TrimSpace(cfg.AudioBitrate))
 	}
-
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	if cfg.AudioSampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(cfg.AudioSampleRate))
 	}
-
-	return &ffmpegBackend{
-		cmd:   cmd,
-		stdin: stdin,
-	}, nil
-}
-
-func (t *ffmpegBackend) Write(p []byte) (int, error) {
-	return t.stdin.Write(p)
-}
-
-func (t *ffmpegBackend) Close() error {
-	_ = t.stdin.Close()
-	return t.cmd.Wait()
+	if af := audioFilter(cfg); af != "" {
+		args = append(args, "-af", af)
+	}
+	args = append(args, codecOptionArgs(cfg.VideoOpts, "v")...)
+	args = append(args, codecOptionArgs(cfg.AudioOpts, "a")...)
+	return args, nil
 }
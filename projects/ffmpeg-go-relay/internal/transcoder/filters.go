@@ -0,0 +1,25 @@
+package transcoder
+
+import (
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// audioFilter is the audio chain shared by every output: the configured
+// passthrough filter, then loudness normalization.
+func audioFilter(cfg config.TranscodeConfig) string {
+	return joinFilters(cfg.AudioFilter, loudnessFilter(cfg))
+}
+
+// joinFilters chains the non-empty parts, dropping "null" placeholders. It
+// returns "" when nothing is left.
+func joinFilters(parts ...string) string {
+	kept := parts[:0:0]
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" && p != "null" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, ",")
+}
@@ -0,0 +1,57 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestJoinFilters(t *testing.T) {
+	if got := joinFilters("", "null", " "); got != "" {
+		t.Fatalf("joinFilters of nothing = %q", got)
+	}
+	if got := joinFilters("yadif", "null", "scale=-2:720"); got != "yadif,scale=-2:720" {
+		t.Fatalf("joinFilters = %q", got)
+	}
+}
+
+func TestFFmpegArgsPassthrough(t *testing.T) {
+	cfg := config.TranscodeConfig{
+		Height:          720,
+		ExtraInputArgs:  []string{"-thread_queue_size", "1024"},
+		ExtraOutputArgs: []string{"-flvflags", "no_duration_filesize"},
+		VideoFilter:     "yadif",
+		AudioFilter:     "highpass=f=80",
+		Loudness:        config.LoudnessConfig{Filter: "dynaudnorm"},
+	}
+	args, err := ffmpegArgs(cfg, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	line := strings.Join(args, " ")
+	for _, want := range []string{
+		"-re -thread_queue_size 1024 -i pipe:0 ",
+		"-af highpass=f=80,dynaudnorm=",
+		"-vf yadif,scale=-2:720 ",
+		"-flvflags no_duration_filesize -f flv rtmp://cdn/live/show",
+	} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("missing %q in %q", want, line)
+		}
+	}
+
+	cfg.Height = 0
+	cfg.Renditions = []config.TranscodeRendition{{Name: "720p", Height: 720}, {Name: "src"}}
+	args, err = ffmpegArgs(cfg, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	line = strings.Join(args, " ")
+	if !strings.Contains(line, "-filter_complex [0:v]yadif,split=2[v0][v1];") {
+		t.Fatalf("passthrough filter should run once before the split: %q", line)
+	}
+	if strings.Count(line, "-flvflags no_duration_filesize -f flv") != 2 {
+		t.Fatalf("extra output args should precede every output: %q", line)
+	}
+}
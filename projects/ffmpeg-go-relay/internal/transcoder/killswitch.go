@@ -0,0 +1,100 @@
+package transcoder
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// What happens to new sessions while transcoding is switched off.
+const (
+	FallbackPassthrough = "passthrough"
+	FallbackReject      = "reject"
+)
+
+// KillSwitch disables transcoding globally or per tenant at runtime.
+// Sessions already transcoding are unaffected; only new sessions consult it.
+// A nil KillSwitch allows everything.
+type KillSwitch struct {
+	mu       sync.RWMutex
+	global   bool
+	tenants  map[string]bool
+	fallback string
+}
+
+// KillSwitchStatus is a point-in-time view of the switch.
+type KillSwitchStatus struct {
+	Disabled        bool     `json:"disabled"`
+	DisabledTenants []string `json:"disabled_tenants"`
+	Fallback        string   `json:"fallback"`
+}
+
+// NewKillSwitch creates a switch in the state described by cfg.
+func NewKillSwitch(cfg config.TranscodeKillSwitchConfig) *KillSwitch {
+	k := &KillSwitch{
+		global:   cfg.Disabled,
+		tenants:  make(map[string]bool, len(cfg.DisabledTenants)),
+		fallback: cfg.Fallback,
+	}
+	if k.fallback == "" {
+		k.fallback = FallbackPassthrough
+	}
+	for _, tenant := range cfg.DisabledTenants {
+		k.tenants[strings.TrimSpace(tenant)] = true
+	}
+	return k
+}
+
+// Allowed reports whether a new session for tenant may be transcoded.
+func (k *KillSwitch) Allowed(tenant string) bool {
+	if k == nil {
+		return true
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !k.global && !k.tenants[tenant]
+}
+
+// Fallback returns FallbackPassthrough or FallbackReject.
+func (k *KillSwitch) Fallback() string {
+	if k == nil {
+		return FallbackPassthrough
+	}
+	return k.fallback
+}
+
+// SetGlobal disables or re-enables transcoding for every tenant. Per-tenant
+// settings are kept and apply again once the global switch is cleared.
+func (k *KillSwitch) SetGlobal(disabled bool) {
+	k.mu.Lock()
+	k.global = disabled
+	k.mu.Unlock()
+}
+
+// SetTenant disables or re-enables transcoding for one tenant.
+func (k *KillSwitch) SetTenant(tenant string, disabled bool) {
+	k.mu.Lock()
+	if disabled {
+		k.tenants[tenant] = true
+	} else {
+		delete(k.tenants, tenant)
+	}
+	k.mu.Unlock()
+}
+
+// Status returns the current state with tenants sorted by name.
+func (k *KillSwitch) Status() KillSwitchStatus {
+	if k == nil {
+		return KillSwitchStatus{DisabledTenants: []string{}, Fallback: FallbackPassthrough}
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	tenants := make([]string, 0, len(k.tenants))
+	for tenant := range k.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return KillSwitchStatus{Disabled: k.global, DisabledTenants: tenants, Fallback: k.fallback}
+}
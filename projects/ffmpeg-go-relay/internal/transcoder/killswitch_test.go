@@ -0,0 +1,41 @@
+package transcoder
+
+import (
+	"reflect"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestKillSwitchTenantAndGlobal(t *testing.T) {
+	k := NewKillSwitch(config.TranscodeKillSwitchConfig{DisabledTenants: []string{"acme"}})
+	if k.Fallback() != FallbackPassthrough {
+		t.Fatalf("Fallback() = %q, want %q", k.Fallback(), FallbackPassthrough)
+	}
+	if k.Allowed("acme") || !k.Allowed("other") {
+		t.Fatal("expected only acme to be disabled")
+	}
+
+	k.SetGlobal(true)
+	if k.Allowed("other") {
+		t.Fatal("expected global switch to disable every tenant")
+	}
+	k.SetGlobal(false)
+	k.SetTenant("acme", false)
+	k.SetTenant("beta", true)
+	if !k.Allowed("acme") || k.Allowed("beta") {
+		t.Fatal("expected tenant toggles to apply")
+	}
+
+	want := KillSwitchStatus{DisabledTenants: []string{"beta"}, Fallback: FallbackPassthrough}
+	if got := k.Status(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Status() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNilKillSwitchAllows(t *testing.T) {
+	var k *KillSwitch
+	if !k.Allowed("acme") {
+		t.Fatal("nil switch should allow transcoding")
+	}
+}
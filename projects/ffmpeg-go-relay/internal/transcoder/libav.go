@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,29 +20,107 @@ import (
 	"ffmpeg-go-relay/internal/logger"
 )
 
-const libavIOBufferSize = 4096
+const (
+	libavIOBufferSize = 4096
+	libavBuilt        = true
+)
 
 var libavLogOnce sync.Once
 
+// libavSessionLogs maps the libav contexts a session owns to its logger.
+// libav has one process-wide log callback, which uses this to put each line
+// under the session it came from.
+var libavSessionLogs struct {
+	mu       sync.Mutex
+	byClass  map[astiav.Classer]*logger.Logger
+	sessions map[*logger.Logger]int // Registered contexts per session
+}
+
 type libavBackend struct {
-	writer *io.PipeWriter
-	done   chan error
+	writer  *io.PipeWriter
+	done    chan error
+	counter *frameCounter
 }
 
 func newLibAVBackend(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
 	reader, writer := io.Pipe()
 	backend := &libavBackend{
-		writer: writer,
-		done:   make(chan error, 1),
+		writer:  writer,
+		done:    make(chan error, 1),
+		counter: newFrameCounter(time.Now()),
 	}
 
 	go func() {
-		backend.done <- runLibAV(ctx, cfg, upstream, reader, log)
+		backend.done <- runLibAV(ctx, cfg, upstream, reader, backend.counter, log)
 	}()
 
 	return backend, nil
 }
 
+// libavUsable reports why the libav backend cannot run cfg for upstream: an
+// encoder or muxer missing from the linked libraries. The auto backend then
+// uses the ffmpeg binary, which may have been built with more.
+func libavUsable(cfg config.TranscodeConfig, upstream string) error {
+	for _, name := range []string{normalizeCodecName(cfg.VideoCodec, "libx264"), normalizeCodecName(cfg.AudioCodec, "aac")} {
+		if !isCopyCodec(name) && astiav.FindEncoderByName(name) == nil {
+			return fmt.Errorf("encoder %s not found", name)
+		}
+	}
+	if muxer := outputMuxer(upstream); astiav.FindOutputFormat(muxer) == nil {
+		return fmt.Errorf("muxer %s not found", muxer)
+	}
+	return nil
+}
+
+// libavHardwareTypes are the device types probeLibAV tries to open.
+var libavHardwareTypes = []astiav.HardwareDeviceType{
+	astiav.HardwareDeviceTypeCUDA,
+	astiav.HardwareDeviceTypeD3D11VA,
+	astiav.HardwareDeviceTypeDRM,
+	astiav.HardwareDeviceTypeDXVA2,
+	astiav.HardwareDeviceTypeMediaCodec,
+	astiav.HardwareDeviceTypeOpenCL,
+	astiav.HardwareDeviceTypeQSV,
+	astiav.HardwareDeviceTypeVAAPI,
+	astiav.HardwareDeviceTypeVDPAU,
+	astiav.HardwareDeviceTypeVideoToolbox,
+	astiav.HardwareDeviceTypeVulkan,
+}
+
+// probeLibAV lists the encoders in the linked libraries and the hardware
+// device types a default device can be opened for.
+func probeLibAV() BackendCapabilities {
+	caps := BackendCapabilities{Available: true}
+	caps.Version, caps.Libraries = libavVersions()
+	for _, c := range astiav.Codecs() {
+		if !c.IsEncoder() {
+			continue
+		}
+		var kind string
+		switch c.ID().MediaType() {
+		case astiav.MediaTypeVideo:
+			kind = "video"
+		case astiav.MediaTypeAudio:
+			kind = "audio"
+		case astiav.MediaTypeSubtitle:
+			kind = "subtitle"
+		default:
+			continue
+		}
+		caps.Encoders = append(caps.Encoders, Encoder{Name: c.Name(), Type: kind, Hardware: isHardwareEncoder(c.Name())})
+	}
+	slices.SortFunc(caps.Encoders, func(a, b Encoder) int { return strings.Compare(a.Name, b.Name) })
+	for _, t := range libavHardwareTypes {
+		device, err := astiav.CreateHardwareDeviceContext(t, "", nil, 0)
+		if err != nil {
+			continue
+		}
+		device.Free()
+		caps.HWAccels = append(caps.HWAccels, t.Name())
+	}
+	return caps
+}
+
 func (b *libavBackend) Write(p []byte) (int, error) {
 	return b.writer.Write(p)
 }
@@ -51,8 +130,13 @@ func (b *libavBackend) Close() error {
 	return <-b.done
 }
 
+func (b *libavBackend) Progress() (Progress, bool) {
+	return b.counter.progress(time.Now())
+}
+
 type libavCleanup struct {
 	fns []func()
+	log *logger.Logger // The session's logger, for libav log attribution
 }
 
 func (c *libavCleanup) Add(fn func()) {
@@ -63,6 +147,33 @@ func (c *libavCleanup) AddWithError(fn func() error) {
 	c.Add(func() { _ = fn() })
 }
 
+// attribute routes libav log lines about ctx to the session's logger until
+// cleanup. It has to be called after ctx's Free is added, so the context
+// is forgotten before it is freed.
+func (c *libavCleanup) attribute(ctx astiav.Classer) {
+	if c.log == nil {
+		return
+	}
+	log := c.log
+	l := &libavSessionLogs
+	l.mu.Lock()
+	if l.byClass == nil {
+		l.byClass = make(map[astiav.Classer]*logger.Logger)
+		l.sessions = make(map[*logger.Logger]int)
+	}
+	l.byClass[ctx] = log
+	l.sessions[log]++
+	l.mu.Unlock()
+	c.Add(func() {
+		l.mu.Lock()
+		delete(l.byClass, ctx)
+		if l.sessions[log]--; l.sessions[log] == 0 {
+			delete(l.sessions, log)
+		}
+		l.mu.Unlock()
+	})
+}
+
 func (c *libavCleanup) Close() {
 	for i := len(c.fns) - 1; i >= 0; i-- {
 		c.fns[i]()
@@ -76,25 +187,44 @@ const (
 	streamModeTranscode
 )
 
+// libavStream is one input stream, decoded once when transcoding, and the
+// pipelines that carry it to each output.
 type libavStream struct {
-	mode              streamMode
-	inputStream       *astiav.Stream
+	mode            streamMode
+	inputStream     *astiav.Stream
+	decCodecContext *astiav.CodecContext
+	decFrame        *astiav.Frame
+	decLastPTS      *int64
+	encoders        []*libavEncoder // One per output, in output order
+	progress        *frameCounter   // Set for video, to count dropped frames
+}
+
+// libavEncoder carries one input stream into one output, either as a stream
+// copy or through its own filter graph and encoder.
+type libavEncoder struct {
+	output            *astiav.FormatContext
 	outputStream      *astiav.Stream
-	decCodecContext   *astiav.CodecContext
 	encCodecContext   *astiav.CodecContext
 	buffersrcContext  *astiav.BuffersrcFilterContext
 	buffersinkContext *astiav.BuffersinkFilterContext
 	filterGraph       *astiav.FilterGraph
-	decFrame          *astiav.Frame
 	filterFrame       *astiav.Frame
-	encPkt            *astiav.Packet
-	decLastPTS        *int64
+	encPkt            *astiav.Packet // Encoded output, or the per-output reference in copy mode
+	progress          *frameCounter  // Set on the first output only
+}
+
+// libavOutput is one FLV destination: the session's upstream, or one
+// rendition of the ladder.
+type libavOutput struct {
+	url   string
+	video videoOutput
+	fc    *astiav.FormatContext
 }
 
-func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string, reader *io.PipeReader, log *logger.Logger) error {
+func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string, reader *io.PipeReader, counter *frameCounter, log *logger.Logger) error {
 	setupLibAVLogger(log)
 
-	cleanup := &libavCleanup{}
+	cleanup := &libavCleanup{log: log}
 	defer cleanup.Close()
 	defer func() { _ = reader.Close() }()
 
@@ -110,6 +240,7 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		return errors.New("input format context is nil")
 	}
 	cleanup.Add(inputFormatContext.Free)
+	cleanup.attribute(inputFormatContext)
 
 	inputIOContext, err := astiav.AllocIOContext(libavIOBufferSize, false, reader.Read, nil, nil)
 	if err != nil {
@@ -129,23 +260,9 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		return fmt.Errorf("find stream info: %w", err)
 	}
 
-	outputFormatContext, err := astiav.AllocOutputFormatContext(nil, "flv", upstream)
+	outputs, err := openLibAVOutputs(cfg, upstream, interrupter, cleanup)
 	if err != nil {
-		return fmt.Errorf("allocate output format context: %w", err)
-	}
-	if outputFormatContext == nil {
-		return errors.New("output format context is nil")
-	}
-	cleanup.Add(outputFormatContext.Free)
-	outputFormatContext.SetIOInterrupter(interrupter)
-
-	if !outputFormatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
-		outputIOContext, err := astiav.OpenIOContext(upstream, astiav.NewIOContextFlags(astiav.IOContextFlagWrite), interrupter, nil)
-		if err != nil {
-			return fmt.Errorf("open output io context: %w", err)
-		}
-		cleanup.AddWithError(outputIOContext.Close)
-		outputFormatContext.SetPb(outputIOContext)
+		return err
 	}
 
 	streams := map[int]*libavStream{}
@@ -164,26 +281,35 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		}
 
 		s := &libavStream{inputStream: is}
+		if mediaType == astiav.MediaTypeVideo {
+			s.progress = counter
+		}
 		if isCopyCodec(codecName) {
 			s.mode = streamModeCopy
-			outputStream := outputFormatContext.NewStream(nil)
-			if outputStream == nil {
-				return errors.New("output stream is nil")
-			}
-			if err := is.CodecParameters().Copy(outputStream.CodecParameters()); err != nil {
-				return fmt.Errorf("copy codec parameters: %w", err)
+			for _, out := range outputs {
+				enc, err := initCopyStream(is, out, cleanup)
+				if err != nil {
+					return err
+				}
+				s.encoders = append(s.encoders, enc)
 			}
-			outputStream.CodecParameters().SetCodecTag(0)
-			outputStream.SetTimeBase(is.TimeBase())
-			s.outputStream = outputStream
+			s.encoders[0].progress = counter
 			streams[is.Index()] = s
 			continue
 		}
 
 		s.mode = streamModeTranscode
-		if err := initTranscodeStream(s, inputFormatContext, outputFormatContext, codecName, cfg, log, cleanup); err != nil {
+		if err := initDecoder(s, inputFormatContext, cleanup); err != nil {
 			return err
 		}
+		for _, out := range outputs {
+			enc, err := initTranscodeStream(s, out, codecName, cfg, log, cleanup)
+			if err != nil {
+				return err
+			}
+			s.encoders = append(s.encoders, enc)
+		}
+		s.encoders[0].progress = counter
 		streams[is.Index()] = s
 	}
 
@@ -191,8 +317,15 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		return errors.New("no audio or video streams found")
 	}
 
-	if err := outputFormatContext.WriteHeader(nil); err != nil {
-		return fmt.Errorf("write header: %w", err)
+	for _, out := range outputs {
+		options := muxerDictionary(out.url)
+		err := out.fc.WriteHeader(options)
+		if options != nil {
+			options.Free()
+		}
+		if err != nil {
+			return fmt.Errorf("write header %s: %w", out.url, err)
+		}
 	}
 
 	pkt := astiav.AllocPacket()
@@ -219,14 +352,16 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		}
 
 		if s.mode == streamModeCopy {
-			if err := writeCopyPacket(pkt, s, outputFormatContext); err != nil {
-				return err
+			for _, enc := range s.encoders {
+				if err := writeCopyPacket(pkt, s, enc); err != nil {
+					return err
+				}
 			}
 			pkt.Unref()
 			continue
 		}
 
-		if err := transcodePacket(pkt, s, outputFormatContext); err != nil {
+		if err := transcodePacket(pkt, s); err != nil {
 			return err
 		}
 		pkt.Unref()
@@ -236,24 +371,67 @@ func runLibAV(ctx context.Context, cfg config.TranscodeConfig, upstream string,
 		if s.mode != streamModeTranscode {
 			continue
 		}
-		if err := flushDecoder(s, outputFormatContext); err != nil {
-			return err
-		}
-		if err := filterEncodeWriteFrame(nil, s, outputFormatContext); err != nil {
+		if err := flushDecoder(s); err != nil {
 			return err
 		}
-		if err := encodeWriteFrame(nil, s, outputFormatContext); err != nil {
-			return err
+		for _, enc := range s.encoders {
+			if err := filterEncodeWriteFrame(nil, enc); err != nil {
+				return err
+			}
+			if err := encodeWriteFrame(nil, enc); err != nil {
+				return err
+			}
 		}
 	}
 
-	if err := outputFormatContext.WriteTrailer(); err != nil {
-		return fmt.Errorf("write trailer: %w", err)
+	for _, out := range outputs {
+		if err := out.fc.WriteTrailer(); err != nil {
+			return fmt.Errorf("write trailer %s: %w", out.url, err)
+		}
 	}
 
 	return nil
 }
 
+// openLibAVOutputs opens the session's upstream, or one output per rendition
+// when a ladder is configured, each with the muxer for its URL's scheme.
+func openLibAVOutputs(cfg config.TranscodeConfig, upstream string, interrupter *astiav.IOInterrupter, cleanup *libavCleanup) ([]*libavOutput, error) {
+	videos, err := videoOutputs(cfg, upstream)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]*libavOutput, 0, len(videos))
+	for _, v := range videos {
+		outputs = append(outputs, &libavOutput{url: v.url, video: v})
+	}
+
+	for _, out := range outputs {
+		fc, err := astiav.AllocOutputFormatContext(nil, outputMuxer(out.url), out.url)
+		if err != nil {
+			return nil, fmt.Errorf("allocate output format context: %w", err)
+		}
+		if fc == nil {
+			return nil, errors.New("output format context is nil")
+		}
+		cleanup.Add(fc.Free)
+		cleanup.attribute(fc)
+		fc.SetIOInterrupter(interrupter)
+
+		if !fc.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
+			outputIOContext, err := astiav.OpenIOContext(out.url, astiav.NewIOContextFlags(astiav.IOContextFlagWrite), interrupter, nil)
+			if err != nil {
+				return nil, fmt.Errorf("open output io context %s: %w", out.url, err)
+			}
+			cleanup.AddWithError(outputIOContext.Close)
+			fc.SetPb(outputIOContext)
+		}
+		out.fc = fc
+	}
+	return outputs, nil
+}
+
+// setupLibAVLogger installs the process-wide libav log callback; log is the
+// fallback for lines that cannot be attributed to a session.
 func setupLibAVLogger(log *logger.Logger) {
 	if log == nil {
 		return
@@ -265,11 +443,29 @@ func setupLibAVLogger(log *logger.Logger) {
 			if message == "" {
 				return
 			}
-			log.Debug("libav log", "message", message, "level", int(l))
+			libavLogFor(c, log).Debug("libav log", "message", message, "level", int(l))
 		})
 	})
 }
 
+// libavLogFor returns the logger of the session owning c. Contexts no
+// session registered, such as I/O contexts, are attributed only while a
+// single session is running.
+func libavLogFor(c astiav.Classer, fallback *logger.Logger) *logger.Logger {
+	l := &libavSessionLogs
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if log, ok := l.byClass[c]; ok {
+		return log
+	}
+	if len(l.sessions) == 1 {
+		for log := range l.sessions {
+			return log
+		}
+	}
+	return fallback
+}
+
 func normalizeCodecName(value, fallback string) string {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
@@ -282,15 +478,8 @@ func isCopyCodec(value string) bool {
 	return strings.EqualFold(strings.TrimSpace(value), "copy")
 }
 
-func initTranscodeStream(
-	s *libavStream,
-	inputFormatContext *astiav.FormatContext,
-	outputFormatContext *astiav.FormatContext,
-	codecName string,
-	cfg config.TranscodeConfig,
-	log *logger.Logger,
-	cleanup *libavCleanup,
-) error {
+// initDecoder opens the decoder shared by every output of a transcoded stream.
+func initDecoder(s *libavStream, inputFormatContext *astiav.FormatContext, cleanup *libavCleanup) error {
 	if s.inputStream == nil {
 		return errors.New("input stream is nil")
 	}
@@ -305,6 +494,7 @@ func initTranscodeStream(
 		return errors.New("decoder codec context is nil")
 	}
 	cleanup.Add(s.decCodecContext.Free)
+	cleanup.attribute(s.decCodecContext)
 
 	if err := s.inputStream.CodecParameters().ToCodecContext(s.decCodecContext); err != nil {
 		return fmt.Errorf("update decoder context: %w", err)
@@ -325,93 +515,177 @@ func initTranscodeStream(
 		return errors.New("decoder frame is nil")
 	}
 	cleanup.Add(s.decFrame.Free)
+	return nil
+}
+
+// initCopyStream adds a stream-copied output stream for is to out.
+func initCopyStream(is *astiav.Stream, out *libavOutput, cleanup *libavCleanup) (*libavEncoder, error) {
+	outputStream := out.fc.NewStream(nil)
+	if outputStream == nil {
+		return nil, errors.New("output stream is nil")
+	}
+	if err := is.CodecParameters().Copy(outputStream.CodecParameters()); err != nil {
+		return nil, fmt.Errorf("copy codec parameters: %w", err)
+	}
+	outputStream.CodecParameters().SetCodecTag(0)
+	outputStream.SetTimeBase(is.TimeBase())
+
+	enc := &libavEncoder{output: out.fc, outputStream: outputStream}
+	enc.encPkt = astiav.AllocPacket()
+	if enc.encPkt == nil {
+		return nil, errors.New("copy packet is nil")
+	}
+	cleanup.Add(enc.encPkt.Free)
+	return enc, nil
+}
+
+// initTranscodeStream opens an encoder and filter graph taking the decoded
+// frames of s to out, scaled, rate-converted and resampled as configured.
+func initTranscodeStream(
+	s *libavStream,
+	out *libavOutput,
+	codecName string,
+	cfg config.TranscodeConfig,
+	log *logger.Logger,
+	cleanup *libavCleanup,
+) (*libavEncoder, error) {
+	enc := &libavEncoder{output: out.fc}
 
 	encCodec := astiav.FindEncoderByName(codecName)
 	if encCodec == nil {
-		return fmt.Errorf("encoder codec %q is nil", codecName)
+		return nil, fmt.Errorf("encoder codec %q is nil", codecName)
 	}
 
-	s.encCodecContext = astiav.AllocCodecContext(encCodec)
-	if s.encCodecContext == nil {
-		return errors.New("encoder codec context is nil")
+	enc.encCodecContext = astiav.AllocCodecContext(encCodec)
+	if enc.encCodecContext == nil {
+		return nil, errors.New("encoder codec context is nil")
 	}
-	cleanup.Add(s.encCodecContext.Free)
+	cleanup.Add(enc.encCodecContext.Free)
+	cleanup.attribute(enc.encCodecContext)
 
+	// The filter graph is built first: its sink reports the size, aspect
+	// ratio and time base the encoder has to match after scaling and fps.
 	if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeAudio {
 		if layouts := encCodec.SupportedChannelLayouts(); len(layouts) > 0 {
-			s.encCodecContext.SetChannelLayout(layouts[0])
+			enc.encCodecContext.SetChannelLayout(layouts[0])
 		} else {
-			s.encCodecContext.SetChannelLayout(s.decCodecContext.ChannelLayout())
+			enc.encCodecContext.SetChannelLayout(s.decCodecContext.ChannelLayout())
 		}
-		s.encCodecContext.SetSampleRate(s.decCodecContext.SampleRate())
+		// A configured rate is forced in the graph; otherwise the encoder
+		// takes whatever the loudness filters, if any, produce.
+		enc.encCodecContext.SetSampleRate(cfg.AudioSampleRate)
 		if formats := encCodec.SupportedSampleFormats(); len(formats) > 0 {
-			s.encCodecContext.SetSampleFormat(formats[0])
+			enc.encCodecContext.SetSampleFormat(formats[0])
 		} else {
-			s.encCodecContext.SetSampleFormat(s.decCodecContext.SampleFormat())
+			enc.encCodecContext.SetSampleFormat(s.decCodecContext.SampleFormat())
+		}
+
+		if err := initFilters(s, enc, audioFilter(cfg), cleanup); err != nil {
+			return nil, err
+		}
+		sampleRate := enc.buffersinkContext.SampleRate()
+		enc.encCodecContext.SetSampleRate(sampleRate)
+		enc.encCodecContext.SetTimeBase(astiav.NewRational(1, sampleRate))
+		if cfg.AudioBitrate != "" {
+			bps, err := config.ParseBitrate(cfg.AudioBitrate)
+			if err != nil {
+				return nil, fmt.Errorf("audio_bitrate: %w", err)
+			}
+			enc.encCodecContext.SetBitRate(bps)
 		}
-		s.encCodecContext.SetTimeBase(astiav.NewRational(1, s.encCodecContext.SampleRate()))
 	} else {
-		s.encCodecContext.SetHeight(s.decCodecContext.Height())
-		s.encCodecContext.SetWidth(s.decCodecContext.Width())
 		if formats := encCodec.SupportedPixelFormats(); len(formats) > 0 {
-			s.encCodecContext.SetPixelFormat(formats[0])
+			enc.encCodecContext.SetPixelFormat(formats[0])
 		} else {
-			s.encCodecContext.SetPixelFormat(s.decCodecContext.PixelFormat())
+			enc.encCodecContext.SetPixelFormat(s.decCodecContext.PixelFormat())
 		}
-		s.encCodecContext.SetSampleAspectRatio(s.decCodecContext.SampleAspectRatio())
-		s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
-		s.encCodecContext.SetFramerate(s.decCodecContext.Framerate())
 
-		if gopSize := parseGop(cfg.GOP, s.decCodecContext.Framerate(), log); gopSize > 0 {
-			s.encCodecContext.SetGopSize(gopSize)
+		if err := initFilters(s, enc, joinFilters(cfg.VideoFilter, out.video.filter), cleanup); err != nil {
+			return nil, err
+		}
+		sink := enc.buffersinkContext
+		enc.encCodecContext.SetWidth(sink.Width())
+		enc.encCodecContext.SetHeight(sink.Height())
+		enc.encCodecContext.SetSampleAspectRatio(sink.SampleAspectRatio())
+		enc.encCodecContext.SetTimeBase(sink.TimeBase())
+
+		frameRate := s.decCodecContext.Framerate()
+		if out.video.fps > 0 {
+			frameRate = astiav.NewRational(out.video.fps, 1)
+		} else if r := sink.FrameRate(); r.Num() > 0 && r.Den() > 0 {
+			frameRate = r
+		}
+		enc.encCodecContext.SetFramerate(frameRate)
+
+		if out.video.bitrate > 0 {
+			enc.encCodecContext.SetBitRate(out.video.bitrate)
+		}
+		if out.video.maxrate > 0 {
+			enc.encCodecContext.SetRateControlMaxRate(out.video.maxrate)
+		}
+		if out.video.bufsize > 0 {
+			enc.encCodecContext.SetRateControlBufferSize(int(out.video.bufsize))
+		}
+
+		if gopSize := parseGop(cfg.GOP, frameRate, log); gopSize > 0 {
+			enc.encCodecContext.SetGopSize(gopSize)
 		}
 	}
 
-	if outputFormatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagGlobalheader) {
-		s.encCodecContext.SetFlags(s.encCodecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
+	if out.fc.OutputFormat().Flags().Has(astiav.IOFormatFlagGlobalheader) {
+		enc.encCodecContext.SetFlags(enc.encCodecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
 	}
 
 	options := encoderOptions(cfg, s.inputStream.CodecParameters().MediaType())
-	if err := s.encCodecContext.Open(encCodec, options); err != nil {
+	if err := enc.encCodecContext.Open(encCodec, options); err != nil {
 		if options != nil {
 			options.Free()
 		}
-		return fmt.Errorf("open encoder: %w", err)
+		return nil, fmt.Errorf("open encoder: %w", err)
 	}
 	if options != nil {
 		options.Free()
 	}
 
-	s.outputStream = outputFormatContext.NewStream(nil)
-	if s.outputStream == nil {
-		return errors.New("output stream is nil")
-	}
-
-	if err := s.outputStream.CodecParameters().FromCodecContext(s.encCodecContext); err != nil {
-		return fmt.Errorf("update output codec parameters: %w", err)
+	enc.outputStream = out.fc.NewStream(nil)
+	if enc.outputStream == nil {
+		return nil, errors.New("output stream is nil")
 	}
-	s.outputStream.SetTimeBase(s.encCodecContext.TimeBase())
 
-	if err := initFilters(s, cleanup); err != nil {
-		return err
+	if err := enc.outputStream.CodecParameters().FromCodecContext(enc.encCodecContext); err != nil {
+		return nil, fmt.Errorf("update output codec parameters: %w", err)
 	}
+	enc.outputStream.SetTimeBase(enc.encCodecContext.TimeBase())
 
-	return nil
+	return enc, nil
 }
 
 func encoderOptions(cfg config.TranscodeConfig, mediaType astiav.MediaType) *astiav.Dictionary {
-	if mediaType != astiav.MediaTypeVideo {
+	var extra map[string]string
+	switch mediaType {
+	case astiav.MediaTypeVideo:
+		extra = cfg.VideoOpts
+	case astiav.MediaTypeAudio:
+		extra = cfg.AudioOpts
+	default:
 		return nil
 	}
 
 	var hasOptions bool
 	options := astiav.NewDictionary()
-	if cfg.Preset != "" {
-		_ = options.Set("preset", cfg.Preset, astiav.NewDictionaryFlags())
-		hasOptions = true
+	if mediaType == astiav.MediaTypeVideo {
+		if cfg.Preset != "" {
+			_ = options.Set("preset", cfg.Preset, astiav.NewDictionaryFlags())
+			hasOptions = true
+		}
+		if cfg.CRF > 0 {
+			_ = options.Set("crf", strconv.Itoa(cfg.CRF), astiav.NewDictionaryFlags())
+			hasOptions = true
+		}
 	}
-	if cfg.CRF > 0 {
-		_ = options.Set("crf", strconv.Itoa(cfg.CRF), astiav.NewDictionaryFlags())
+	// Explicit options are applied last so they override preset/crf.
+	for _, k := range sortedOptionKeys(extra) {
+		_ = options.Set(k, extra[k], astiav.NewDictionaryFlags())
 		hasOptions = true
 	}
 
@@ -423,6 +697,19 @@ func encoderOptions(cfg config.TranscodeConfig, mediaType astiav.MediaType) *ast
 	return options
 }
 
+// muxerDictionary holds muxerOptions for url, or is nil when there are none.
+func muxerDictionary(url string) *astiav.Dictionary {
+	opts := muxerOptions(url)
+	if len(opts) == 0 {
+		return nil
+	}
+	options := astiav.NewDictionary()
+	for _, k := range sortedOptionKeys(opts) {
+		_ = options.Set(k, opts[k], astiav.NewDictionaryFlags())
+	}
+	return options
+}
+
 func parseGop(value string, frameRate astiav.Rational, log *logger.Logger) int {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
@@ -447,12 +734,15 @@ func parseGop(value string, frameRate astiav.Rational, log *logger.Logger) int {
 	return 0
 }
 
-func initFilters(s *libavStream, cleanup *libavCleanup) error {
-	s.filterGraph = astiav.AllocFilterGraph()
-	if s.filterGraph == nil {
+// initFilters builds the graph converting decoded frames to the encoder's
+// formats; filter, when not empty, runs first.
+func initFilters(s *libavStream, enc *libavEncoder, filter string, cleanup *libavCleanup) error {
+	enc.filterGraph = astiav.AllocFilterGraph()
+	if enc.filterGraph == nil {
 		return errors.New("filter graph is nil")
 	}
-	cleanup.Add(s.filterGraph.Free)
+	cleanup.Add(enc.filterGraph.Free)
+	cleanup.attribute(enc.filterGraph)
 
 	outputs := astiav.AllocFilterInOut()
 	if outputs == nil {
@@ -484,9 +774,12 @@ func initFilters(s *libavStream, cleanup *libavCleanup) error {
 		buffersink = astiav.FindFilterByName("abuffersink")
 		content = fmt.Sprintf(
 			"aformat=sample_fmts=%s:channel_layouts=%s",
-			s.encCodecContext.SampleFormat().Name(),
-			s.encCodecContext.ChannelLayout().String(),
+			enc.encCodecContext.SampleFormat().Name(),
+			enc.encCodecContext.ChannelLayout().String(),
 		)
+		if rate := enc.encCodecContext.SampleRate(); rate > 0 {
+			content += ":sample_rates=" + strconv.Itoa(rate)
+		}
 	} else {
 		buffersrc = astiav.FindFilterByName("buffer")
 		buffersrcContextParameters.SetHeight(s.decCodecContext.Height())
@@ -495,7 +788,10 @@ func initFilters(s *libavStream, cleanup *libavCleanup) error {
 		buffersrcContextParameters.SetTimeBase(s.inputStream.TimeBase())
 		buffersrcContextParameters.SetWidth(s.decCodecContext.Width())
 		buffersink = astiav.FindFilterByName("buffersink")
-		content = fmt.Sprintf("format=pix_fmts=%s", s.encCodecContext.PixelFormat().Name())
+		content = fmt.Sprintf("format=pix_fmts=%s", enc.encCodecContext.PixelFormat().Name())
+	}
+	if filter != "" {
+		content = filter + "," + content
 	}
 
 	if buffersrc == nil || buffersink == nil {
@@ -503,63 +799,70 @@ func initFilters(s *libavStream, cleanup *libavCleanup) error {
 	}
 
 	var err error
-	if s.buffersrcContext, err = s.filterGraph.NewBuffersrcFilterContext(buffersrc, "in"); err != nil {
+	if enc.buffersrcContext, err = enc.filterGraph.NewBuffersrcFilterContext(buffersrc, "in"); err != nil {
 		return fmt.Errorf("create buffersrc context: %w", err)
 	}
-	if s.buffersinkContext, err = s.filterGraph.NewBuffersinkFilterContext(buffersink, "out"); err != nil {
+	if enc.buffersinkContext, err = enc.filterGraph.NewBuffersinkFilterContext(buffersink, "out"); err != nil {
 		return fmt.Errorf("create buffersink context: %w", err)
 	}
 
-	if err = s.buffersrcContext.SetParameters(buffersrcContextParameters); err != nil {
+	if err = enc.buffersrcContext.SetParameters(buffersrcContextParameters); err != nil {
 		return fmt.Errorf("set buffersrc parameters: %w", err)
 	}
-	if err = s.buffersrcContext.Initialize(nil); err != nil {
+	if err = enc.buffersrcContext.Initialize(nil); err != nil {
 		return fmt.Errorf("initialize buffersrc context: %w", err)
 	}
 
 	outputs.SetName("in")
-	outputs.SetFilterContext(s.buffersrcContext.FilterContext())
+	outputs.SetFilterContext(enc.buffersrcContext.FilterContext())
 	outputs.SetPadIdx(0)
 	outputs.SetNext(nil)
 
 	inputs.SetName("out")
-	inputs.SetFilterContext(s.buffersinkContext.FilterContext())
+	inputs.SetFilterContext(enc.buffersinkContext.FilterContext())
 	inputs.SetPadIdx(0)
 	inputs.SetNext(nil)
 
-	if err = s.filterGraph.Parse(content, inputs, outputs); err != nil {
+	if err = enc.filterGraph.Parse(content, inputs, outputs); err != nil {
 		return fmt.Errorf("parse filter graph: %w", err)
 	}
-	if err = s.filterGraph.Configure(); err != nil {
+	if err = enc.filterGraph.Configure(); err != nil {
 		return fmt.Errorf("configure filter graph: %w", err)
 	}
 
-	s.filterFrame = astiav.AllocFrame()
-	if s.filterFrame == nil {
+	enc.filterFrame = astiav.AllocFrame()
+	if enc.filterFrame == nil {
 		return errors.New("filter frame is nil")
 	}
-	cleanup.Add(s.filterFrame.Free)
+	cleanup.Add(enc.filterFrame.Free)
 
-	s.encPkt = astiav.AllocPacket()
-	if s.encPkt == nil {
+	enc.encPkt = astiav.AllocPacket()
+	if enc.encPkt == nil {
 		return errors.New("encoder packet is nil")
 	}
-	cleanup.Add(s.encPkt.Free)
+	cleanup.Add(enc.encPkt.Free)
 
 	return nil
 }
 
-func writeCopyPacket(pkt *astiav.Packet, s *libavStream, outputFormatContext *astiav.FormatContext) error {
-	pkt.SetStreamIndex(s.outputStream.Index())
-	pkt.RescaleTs(s.inputStream.TimeBase(), s.outputStream.TimeBase())
-	pkt.SetPos(-1)
-	if err := outputFormatContext.WriteInterleavedFrame(pkt); err != nil {
+// writeCopyPacket writes a new reference to pkt, since muxing consumes it and
+// the same input packet may go to several outputs.
+func writeCopyPacket(pkt *astiav.Packet, s *libavStream, enc *libavEncoder) error {
+	if err := enc.encPkt.Ref(pkt); err != nil {
+		return fmt.Errorf("reference packet: %w", err)
+	}
+	enc.encPkt.SetStreamIndex(enc.outputStream.Index())
+	enc.encPkt.RescaleTs(s.inputStream.TimeBase(), enc.outputStream.TimeBase())
+	enc.encPkt.SetPos(-1)
+	countPacket(enc)
+	if err := enc.output.WriteInterleavedFrame(enc.encPkt); err != nil {
+		enc.encPkt.Unref()
 		return fmt.Errorf("write packet: %w", err)
 	}
 	return nil
 }
 
-func transcodePacket(pkt *astiav.Packet, s *libavStream, outputFormatContext *astiav.FormatContext) error {
+func transcodePacket(pkt *astiav.Packet, s *libavStream) error {
 	pkt.RescaleTs(s.inputStream.TimeBase(), s.decCodecContext.TimeBase())
 	if err := s.decCodecContext.SendPacket(pkt); err != nil {
 		return fmt.Errorf("send packet: %w", err)
@@ -574,13 +877,16 @@ func transcodePacket(pkt *astiav.Packet, s *libavStream, outputFormatContext *as
 		}
 
 		if s.decLastPTS != nil && *s.decLastPTS >= s.decFrame.Pts() {
+			if s.progress != nil {
+				s.progress.drop()
+			}
 			s.decFrame.Unref()
 			continue
 		}
 		pts := s.decFrame.Pts()
 		s.decLastPTS = &pts
 
-		if err := filterEncodeWriteFrame(s.decFrame, s, outputFormatContext); err != nil {
+		if err := encodeDecodedFrame(s); err != nil {
 			s.decFrame.Unref()
 			return err
 		}
@@ -588,7 +894,7 @@ func transcodePacket(pkt *astiav.Packet, s *libavStream, outputFormatContext *as
 	}
 }
 
-func flushDecoder(s *libavStream, outputFormatContext *astiav.FormatContext) error {
+func flushDecoder(s *libavStream) error {
 	if err := s.decCodecContext.SendPacket(nil); err != nil {
 		if !errors.Is(err, astiav.ErrEof) {
 			return fmt.Errorf("flush decoder: %w", err)
@@ -603,7 +909,7 @@ func flushDecoder(s *libavStream, outputFormatContext *astiav.FormatContext) err
 			}
 			return fmt.Errorf("flush decoder frame: %w", err)
 		}
-		if err := filterEncodeWriteFrame(s.decFrame, s, outputFormatContext); err != nil {
+		if err := encodeDecodedFrame(s); err != nil {
 			s.decFrame.Unref()
 			return err
 		}
@@ -611,45 +917,68 @@ func flushDecoder(s *libavStream, outputFormatContext *astiav.FormatContext) err
 	}
 }
 
-func filterEncodeWriteFrame(f *astiav.Frame, s *libavStream, outputFormatContext *astiav.FormatContext) error {
-	if err := s.buffersrcContext.AddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+// encodeDecodedFrame feeds the current decoded frame to every output. The
+// filter graphs keep their own references, so the frame is reused as is.
+func encodeDecodedFrame(s *libavStream) error {
+	for _, enc := range s.encoders {
+		if err := filterEncodeWriteFrame(s.decFrame, enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func filterEncodeWriteFrame(f *astiav.Frame, enc *libavEncoder) error {
+	if err := enc.buffersrcContext.AddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
 		return fmt.Errorf("add frame to filter: %w", err)
 	}
 
 	for {
-		if err := s.buffersinkContext.GetFrame(s.filterFrame, astiav.NewBuffersinkFlags()); err != nil {
+		if err := enc.buffersinkContext.GetFrame(enc.filterFrame, astiav.NewBuffersinkFlags()); err != nil {
 			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
 				return nil
 			}
 			return fmt.Errorf("get filter frame: %w", err)
 		}
-		s.filterFrame.SetPictureType(astiav.PictureTypeNone)
-		if err := encodeWriteFrame(s.filterFrame, s, outputFormatContext); err != nil {
-			s.filterFrame.Unref()
+		enc.filterFrame.SetPictureType(astiav.PictureTypeNone)
+		if err := encodeWriteFrame(enc.filterFrame, enc); err != nil {
+			enc.filterFrame.Unref()
 			return err
 		}
-		s.filterFrame.Unref()
+		enc.filterFrame.Unref()
 	}
 }
 
-func encodeWriteFrame(f *astiav.Frame, s *libavStream, outputFormatContext *astiav.FormatContext) error {
-	if err := s.encCodecContext.SendFrame(f); err != nil {
+func encodeWriteFrame(f *astiav.Frame, enc *libavEncoder) error {
+	if err := enc.encCodecContext.SendFrame(f); err != nil {
 		return fmt.Errorf("send frame: %w", err)
 	}
 
 	for {
-		if err := s.encCodecContext.ReceivePacket(s.encPkt); err != nil {
+		if err := enc.encCodecContext.ReceivePacket(enc.encPkt); err != nil {
 			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
 				return nil
 			}
 			return fmt.Errorf("receive packet: %w", err)
 		}
-		s.encPkt.SetStreamIndex(s.outputStream.Index())
-		s.encPkt.RescaleTs(s.encCodecContext.TimeBase(), s.outputStream.TimeBase())
-		if err := outputFormatContext.WriteInterleavedFrame(s.encPkt); err != nil {
-			s.encPkt.Unref()
+		enc.encPkt.SetStreamIndex(enc.outputStream.Index())
+		enc.encPkt.RescaleTs(enc.encCodecContext.TimeBase(), enc.outputStream.TimeBase())
+		countPacket(enc)
+		if err := enc.output.WriteInterleavedFrame(enc.encPkt); err != nil {
+			enc.encPkt.Unref()
 			return fmt.Errorf("write packet: %w", err)
 		}
-		s.encPkt.Unref()
+		enc.encPkt.Unref()
+	}
+}
+
+// countPacket records enc's packet in its progress counter, if it has one.
+// Muxing takes the packet, so this runs before the write.
+func countPacket(enc *libavEncoder) {
+	if enc.progress == nil {
+		return
 	}
+	video := enc.outputStream.CodecParameters().MediaType() == astiav.MediaTypeVideo
+	pts := time.Duration(float64(enc.encPkt.Pts()) * enc.outputStream.TimeBase().Float64() * float64(time.Second))
+	enc.progress.packet(video, enc.encPkt.Size(), pts, time.Now())
 }
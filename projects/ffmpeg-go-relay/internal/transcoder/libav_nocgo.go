@@ -4,12 +4,24 @@ package transcoder
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
 	"ffmpeg-go-relay/internal/config"
 	"ffmpeg-go-relay/internal/logger"
 )
 
+const libavBuilt = false
+
+var errLibAVNoCgo = errors.New("libav backend requires cgo")
+
 func newLibAVBackend(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
-	return nil, fmt.Errorf("libav backend requires cgo")
+	return nil, errLibAVNoCgo
+}
+
+func libavUsable(cfg config.TranscodeConfig, upstream string) error {
+	return errLibAVNoCgo
+}
+
+func probeLibAV() BackendCapabilities {
+	return BackendCapabilities{Error: errLibAVNoCgo.Error()}
 }
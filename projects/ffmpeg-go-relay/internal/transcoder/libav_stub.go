@@ -4,12 +4,24 @@ package transcoder
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
 	"ffmpeg-go-relay/internal/config"
 	"ffmpeg-go-relay/internal/logger"
 )
 
+const libavBuilt = false
+
+var errLibAVNotBuilt = errors.New("libav backend not enabled; build with -tags libav")
+
 func newLibAVBackend(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
-	return nil, fmt.Errorf("libav backend not enabled; build with -tags libav")
+	return nil, errLibAVNotBuilt
+}
+
+func libavUsable(cfg config.TranscodeConfig, upstream string) error {
+	return errLibAVNotBuilt
+}
+
+func probeLibAV() BackendCapabilities {
+	return BackendCapabilities{Error: errLibAVNotBuilt.Error()}
 }
@@ -16,3 +16,9 @@ func TestLibAVBackendUnavailable(t *testing.T) {
 		t.Fatal("expected error when libav backend is unavailable")
 	}
 }
+
+func TestProbeLibAVUnavailable(t *testing.T) {
+	if caps := probeLibAV(); caps.Available || caps.Error == "" {
+		t.Fatalf("probeLibAV = %+v, want unavailable with a reason", caps)
+	}
+}
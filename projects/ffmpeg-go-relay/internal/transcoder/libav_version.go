@@ -0,0 +1,24 @@
+//go:build libav && cgo
+
+package transcoder
+
+//#cgo pkg-config: libavcodec libavformat libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavformat/avformat.h>
+//#include <libavutil/avutil.h>
+import "C"
+
+import "fmt"
+
+// libavVersions reports the FFmpeg release the libav backend is linked
+// against and the versions of the libraries it uses.
+func libavVersions() (string, map[string]string) {
+	format := func(v C.uint) string {
+		return fmt.Sprintf("%d.%d.%d", v>>16, (v>>8)&0xff, v&0xff)
+	}
+	return C.GoString(C.av_version_info()), map[string]string{
+		"libavcodec":  format(C.avcodec_version()),
+		"libavformat": format(C.avformat_version()),
+		"libavutil":   format(C.avutil_version()),
+	}
+}
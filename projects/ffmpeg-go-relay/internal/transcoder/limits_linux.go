@@ -0,0 +1,106 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+const (
+	// fallbackNice is the priority of processes that were to be capped by a
+	// cgroup which could not be used, when limits.nice is unset.
+	fallbackNice = 10
+
+	cpuPeriod = 100000 // cpu.max period in microseconds
+
+	ioprioWhoProcess    = 1
+	ioprioClassBE       = 2
+	ioprioClassShift    = 13
+	ioprioLowestBELevel = 7
+)
+
+// limitProcess confines the started process pid as limits asks: into a
+// cgroup of its own under limits.Cgroup for CPU and memory caps, and at a
+// lower CPU and IO priority when limits.Nice is set or the cgroup cannot be
+// used. The returned function removes the cgroup once the process is gone.
+func limitProcess(pid int, limits config.TranscodeLimitsConfig, log *logger.Logger) (release func()) {
+	release = func() {}
+	nice := limits.Nice
+	if limits.CPU > 0 || limits.MemoryBytes > 0 {
+		dir, err := joinCgroup(pid, limits)
+		if err == nil {
+			release = func() {
+				if err := os.Remove(dir); err != nil {
+					log.Warn("failed to remove ffmpeg cgroup", "dir", dir, "err", err)
+				}
+			}
+		} else {
+			log.Warn("ffmpeg cgroup limits unavailable, lowering its priority instead", "err", err)
+			if nice == 0 {
+				nice = fallbackNice
+			}
+		}
+	}
+	if nice > 0 {
+		if err := lowerPriority(pid, nice); err != nil {
+			log.Warn("failed to lower ffmpeg priority", "nice", nice, "err", err)
+		}
+	}
+	return release
+}
+
+// joinCgroup creates a cgroup for pid under limits.Cgroup, sets its caps
+// and moves pid into it.
+func joinCgroup(pid int, limits config.TranscodeLimitsConfig) (string, error) {
+	if limits.Cgroup == "" {
+		return "", errors.New("transcode.limits.cgroup is not set")
+	}
+	// Children only get the controllers their parent enables; either may
+	// already be on, or be left to the operator.
+	for _, controller := range []string{"+cpu", "+memory"} {
+		_ = os.WriteFile(filepath.Join(limits.Cgroup, "cgroup.subtree_control"), []byte(controller), 0o644)
+	}
+	dir, err := os.MkdirTemp(limits.Cgroup, "ffmpeg-")
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{}
+	if limits.CPU > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", max(int64(limits.CPU*cpuPeriod), 1000), cpuPeriod)
+	}
+	if limits.MemoryBytes > 0 {
+		files["memory.max"] = strconv.FormatInt(limits.MemoryBytes, 10)
+	}
+	files["cgroup.procs"] = strconv.Itoa(pid)
+	for _, name := range []string{"cpu.max", "memory.max", "cgroup.procs"} {
+		value, ok := files[name]
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// lowerPriority renices pid and drops it to the lowest best-effort IO
+// priority, so it yields the CPU and disk to the relay.
+func lowerPriority(pid, nice int) error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, pid, nice); err != nil {
+		return fmt.Errorf("setpriority: %w", err)
+	}
+	prio := ioprioClassBE<<ioprioClassShift | ioprioLowestBELevel
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+		return fmt.Errorf("ioprio_set: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestJoinCgroup(t *testing.T) {
+	// A plain directory stands in for the delegated cgroup; the kernel would
+	// provide the interface files joinCgroup writes.
+	parent := t.TempDir()
+	dir, err := joinCgroup(4242, config.TranscodeLimitsConfig{CPU: 1.5, MemoryBytes: 512 << 20, Cgroup: parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != parent {
+		t.Fatalf("cgroup %s is not under %s", dir, parent)
+	}
+	for name, want := range map[string]string{
+		"cpu.max":      "150000 100000",
+		"memory.max":   strconv.Itoa(512 << 20),
+		"cgroup.procs": "4242",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	dir, err = joinCgroup(4242, config.TranscodeLimitsConfig{MemoryBytes: 1 << 30, Cgroup: parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cpu.max")); !os.IsNotExist(err) {
+		t.Errorf("cpu.max written without a CPU limit: %v", err)
+	}
+
+	for _, cgroup := range []string{"", filepath.Join(parent, "missing")} {
+		if _, err := joinCgroup(4242, config.TranscodeLimitsConfig{CPU: 1, Cgroup: cgroup}); err == nil {
+			t.Errorf("joinCgroup under %q succeeded", cgroup)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package transcoder
+
+import (
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/logger"
+)
+
+// limitProcess is only implemented on Linux; elsewhere configured limits
+// are logged and ignored.
+func limitProcess(pid int, limits config.TranscodeLimitsConfig, log *logger.Logger) (release func()) {
+	if limits != (config.TranscodeLimitsConfig{}) {
+		log.Warn("transcode.limits are only supported on Linux")
+	}
+	return func() {}
+}
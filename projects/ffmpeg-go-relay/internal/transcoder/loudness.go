@@ -0,0 +1,54 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// EBU R128 defaults, used where the config leaves a target at 0.
+const (
+	defaultTargetLUFS = -23.0
+	defaultTruePeak   = -1.0
+	defaultLRA        = 7.0
+
+	// loudnorm works at 192kHz internally, so its output is brought back to a
+	// rate every common encoder accepts unless audio_sample_rate says otherwise.
+	loudnormOutputRate = 48000
+)
+
+// loudnessFilter returns the loudness filter chain for cfg, or "" when
+// loudness normalization is off.
+func loudnessFilter(cfg config.TranscodeConfig) string {
+	l := cfg.Loudness
+	target := l.TargetLUFS
+	if target == 0 {
+		target = defaultTargetLUFS
+	}
+	switch l.Filter {
+	case "loudnorm":
+		tp, lra := l.TruePeak, l.LRA
+		if tp == 0 {
+			tp = defaultTruePeak
+		}
+		if lra == 0 {
+			lra = defaultLRA
+		}
+		f := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", formatFloat(target), formatFloat(tp), formatFloat(lra))
+		if cfg.AudioSampleRate == 0 {
+			f += ",aresample=" + strconv.Itoa(loudnormOutputRate)
+		}
+		return f
+	case "dynaudnorm":
+		// dynaudnorm aims at an RMS level rather than integrated loudness;
+		// the equivalent linear level is close enough for speech and music.
+		return "dynaudnorm=r=" + strconv.FormatFloat(math.Pow(10, target/20), 'f', 4, 64)
+	}
+	return ""
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,48 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestLoudnessFilter(t *testing.T) {
+	tests := []struct {
+		cfg  config.TranscodeConfig
+		want string
+	}{
+		{config.TranscodeConfig{}, ""},
+		{
+			config.TranscodeConfig{Loudness: config.LoudnessConfig{Filter: "loudnorm"}},
+			"loudnorm=I=-23:TP=-1:LRA=7,aresample=48000",
+		},
+		{
+			config.TranscodeConfig{
+				AudioSampleRate: 44100,
+				Loudness:        config.LoudnessConfig{Filter: "loudnorm", TargetLUFS: -14, TruePeak: -1.5, LRA: 11},
+			},
+			"loudnorm=I=-14:TP=-1.5:LRA=11",
+		},
+		{
+			config.TranscodeConfig{Loudness: config.LoudnessConfig{Filter: "dynaudnorm", TargetLUFS: -20}},
+			"dynaudnorm=r=0.1000",
+		},
+	}
+	for _, tt := range tests {
+		if got := loudnessFilter(tt.cfg); got != tt.want {
+			t.Fatalf("loudnessFilter(%+v) = %q, want %q", tt.cfg.Loudness, got, tt.want)
+		}
+	}
+}
+
+func TestFFmpegArgsLoudness(t *testing.T) {
+	cfg := config.TranscodeConfig{Loudness: config.LoudnessConfig{Filter: "loudnorm"}}
+	args, err := ffmpegArgs(cfg, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	if line := strings.Join(args, " "); !strings.Contains(line, "-af loudnorm=I=-23:TP=-1:LRA=7,aresample=48000") {
+		t.Fatalf("missing loudnorm filter in %q", line)
+	}
+}
@@ -0,0 +1,24 @@
+package transcoder
+
+import "sort"
+
+// codecOptionArgs renders encoder options as stream-specific ffmpeg flags,
+// e.g. {"tune": "zerolatency"} with stream "v" becomes "-tune:v zerolatency".
+// Keys are sorted so the command line is stable.
+func codecOptionArgs(opts map[string]string, stream string) []string {
+	keys := sortedOptionKeys(opts)
+	args := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		args = append(args, "-"+k+":"+stream, opts[k])
+	}
+	return args
+}
+
+func sortedOptionKeys(opts map[string]string) []string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
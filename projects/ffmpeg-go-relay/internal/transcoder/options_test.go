@@ -0,0 +1,26 @@
+package transcoder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodecOptionArgs(t *testing.T) {
+	got := codecOptionArgs(map[string]string{
+		"tune":         "zerolatency",
+		"rc-lookahead": "20",
+		"x264-params":  "keyint=60:scenecut=0",
+	}, "v")
+	want := []string{
+		"-rc-lookahead:v", "20",
+		"-tune:v", "zerolatency",
+		"-x264-params:v", "keyint=60:scenecut=0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("codecOptionArgs = %v, want %v", got, want)
+	}
+
+	if got := codecOptionArgs(nil, "a"); len(got) != 0 {
+		t.Fatalf("codecOptionArgs(nil) = %v, want empty", got)
+	}
+}
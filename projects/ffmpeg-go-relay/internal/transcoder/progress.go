@@ -0,0 +1,147 @@
+package transcoder
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress is a transcoder's running encode statistics, counted from the
+// start of the current process.
+type Progress struct {
+	Frames          int64     `json:"frames"`
+	FPS             float64   `json:"fps"`
+	Speed           float64   `json:"speed"` // Media seconds encoded per wall-clock second; below 1 is falling behind
+	BitrateKbps     float64   `json:"bitrate_kbps"`
+	DroppedFrames   int64     `json:"dropped_frames"`
+	DuplicateFrames int64     `json:"duplicate_frames"`
+	Updated         time.Time `json:"updated"`
+}
+
+// ProgressReporter is implemented by backends that report encode progress.
+type ProgressReporter interface {
+	// Progress returns the latest figures; false until the first report.
+	Progress() (Progress, bool)
+}
+
+// ReadProgress returns b's latest progress, if it reports any.
+func ReadProgress(b Backend) (Progress, bool) {
+	if r, ok := b.(ProgressReporter); ok {
+		return r.Progress()
+	}
+	return Progress{}, false
+}
+
+// progressState holds the last report for concurrent readers.
+type progressState struct {
+	mu  sync.Mutex
+	p   Progress
+	set bool
+}
+
+func (s *progressState) store(p Progress) {
+	s.mu.Lock()
+	s.p, s.set = p, true
+	s.mu.Unlock()
+}
+
+func (s *progressState) Progress() (Progress, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p, s.set
+}
+
+// readFFmpegProgress parses the key=value blocks ffmpeg writes with
+// -progress, calling report at the "progress=" line closing each block. It
+// returns when r ends.
+func readFFmpegProgress(r io.Reader, report func(Progress)) {
+	var p Progress
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			p.Frames, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			// "1234.5kbits/s", or "N/A" before the first packet
+			p.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "drop_frames":
+			p.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "dup_frames":
+			p.DuplicateFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			// "1.01x", or "N/A"
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p.Updated = time.Now()
+			report(p)
+			p = Progress{}
+		}
+	}
+}
+
+// frameCounter derives Progress for the libav backend from the packets it
+// writes to its first output.
+type frameCounter struct {
+	start time.Time
+
+	mu      sync.Mutex
+	frames  int64
+	dropped int64
+	bytes   int64
+	first   time.Duration // Timestamp of the first packet
+	media   time.Duration // Media time written since the first packet
+	updated time.Time
+}
+
+func newFrameCounter(now time.Time) *frameCounter {
+	return &frameCounter{start: now}
+}
+
+// packet counts one muxed packet; only video packets count as frames.
+func (c *frameCounter) packet(video bool, size int, pts time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if video {
+		c.frames++
+	}
+	c.bytes += int64(size)
+	if c.updated.IsZero() {
+		c.first = pts
+	}
+	c.media = max(c.media, pts-c.first)
+	c.updated = now
+}
+
+// drop counts a decoded video frame discarded before encoding.
+func (c *frameCounter) drop() {
+	c.mu.Lock()
+	c.dropped++
+	c.mu.Unlock()
+}
+
+func (c *frameCounter) progress(now time.Time) (Progress, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updated.IsZero() {
+		return Progress{}, false
+	}
+	p := Progress{Frames: c.frames, DroppedFrames: c.dropped, Updated: c.updated}
+	if elapsed := now.Sub(c.start).Seconds(); elapsed > 0 {
+		p.FPS = float64(c.frames) / elapsed
+		p.Speed = c.media.Seconds() / elapsed
+	}
+	if media := c.media.Seconds(); media > 0 {
+		p.BitrateKbps = float64(c.bytes) * 8 / 1000 / media
+	}
+	return p, true
+}
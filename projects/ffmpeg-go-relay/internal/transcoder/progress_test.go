@@ -0,0 +1,56 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFFmpegProgress(t *testing.T) {
+	out := strings.Join([]string{
+		"frame=0", "fps=0.00", "bitrate=N/A", "drop_frames=0", "speed=N/A", "progress=continue",
+		"frame=150", "fps=29.97", "stream_0_0_q=23.0", "bitrate=2500.4kbits/s", "total_size=1024",
+		"dup_frames=2", "drop_frames=3", "speed=1.01x", "progress=end",
+	}, "\n")
+	var reports []Progress
+	readFFmpegProgress(strings.NewReader(out), func(p Progress) { reports = append(reports, p) })
+
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reports))
+	}
+	if reports[0].Speed != 0 || reports[0].BitrateKbps != 0 {
+		t.Fatalf("N/A values should read as zero, got %+v", reports[0])
+	}
+	got := reports[1]
+	if got.Frames != 150 || got.FPS != 29.97 || got.BitrateKbps != 2500.4 || got.Speed != 1.01 ||
+		got.DroppedFrames != 3 || got.DuplicateFrames != 2 || got.Updated.IsZero() {
+		t.Fatalf("report = %+v", got)
+	}
+}
+
+func TestFrameCounterProgress(t *testing.T) {
+	start := time.Now()
+	c := newFrameCounter(start)
+	if _, ok := c.progress(start); ok {
+		t.Fatal("progress reported before the first packet")
+	}
+	// Two seconds of 25fps video at 10s into the source, written in one second.
+	for i := range 50 {
+		pts := 10*time.Second + time.Duration(i)*40*time.Millisecond
+		c.packet(true, 1250, pts, start)
+	}
+	c.packet(false, 100, 10*time.Second+2*time.Second, start)
+	c.drop()
+
+	p, ok := c.progress(start.Add(time.Second))
+	if !ok {
+		t.Fatal("no progress after packets")
+	}
+	if p.Frames != 50 || p.DroppedFrames != 1 || p.FPS != 50 || p.Speed != 2 {
+		t.Fatalf("progress = %+v", p)
+	}
+	// 62600 bytes over two media seconds.
+	if want := 250.4; p.BitrateKbps != want {
+		t.Fatalf("bitrate = %v kbps, want %v", p.BitrateKbps, want)
+	}
+}
@@ -0,0 +1,55 @@
+package transcoder
+
+import (
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// Remux returns the settings for forwarding a publisher's media unchanged
+// to an upstream of another protocol: both codecs are copied, so only the
+// container changes. The backend and restart policy of cfg are kept.
+func Remux(cfg config.TranscodeConfig) config.TranscodeConfig {
+	return config.TranscodeConfig{
+		Enabled:    true,
+		Backend:    cfg.Backend,
+		VideoCodec: "copy",
+		AudioCodec: "copy",
+		Restart:    cfg.Restart,
+	}
+}
+
+// outputMuxer names the muxer for an output URL by its scheme: RTSP
+// announces the streams to an RTSP server, SRT carries MPEG-TS, and
+// anything else is pushed as FLV over RTMP.
+func outputMuxer(url string) string {
+	scheme, _, _ := strings.Cut(url, "://")
+	switch strings.ToLower(scheme) {
+	case "rtsp", "rtsps":
+		return "rtsp"
+	case "srt":
+		return "mpegts"
+	default:
+		return "flv"
+	}
+}
+
+// muxerOptions are the muxer's private options for url. RTSP is pushed
+// interleaved over its TCP control connection, which crosses NAT and
+// firewalls the way RTMP does; libav's default is UDP.
+func muxerOptions(url string) map[string]string {
+	if outputMuxer(url) == "rtsp" {
+		return map[string]string{"rtsp_transport": "tcp"}
+	}
+	return nil
+}
+
+// muxerArgs renders the muxer for url as ffmpeg output flags.
+func muxerArgs(url string) []string {
+	args := []string{"-f", outputMuxer(url)}
+	opts := muxerOptions(url)
+	for _, k := range sortedOptionKeys(opts) {
+		args = append(args, "-"+k, opts[k])
+	}
+	return args
+}
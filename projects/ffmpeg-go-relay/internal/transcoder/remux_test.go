@@ -0,0 +1,47 @@
+package transcoder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestMuxerArgs(t *testing.T) {
+	cases := map[string][]string{
+		"rtmp://cdn/live/show":          {"-f", "flv"},
+		"rtmps://cdn/live/show":         {"-f", "flv"},
+		"rtsp://media/live/show":        {"-f", "rtsp", "-rtsp_transport", "tcp"},
+		"RTSPS://media/live/show":       {"-f", "rtsp", "-rtsp_transport", "tcp"},
+		"srt://ingest:9000?streamid=ab": {"-f", "mpegts"},
+	}
+	for url, want := range cases {
+		if got := muxerArgs(url); !reflect.DeepEqual(got, want) {
+			t.Errorf("muxerArgs(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestFFmpegArgsRemux(t *testing.T) {
+	cfg := Remux(config.TranscodeConfig{
+		Backend:     "ffmpeg",
+		VideoCodec:  "libx264",
+		Preset:      "veryfast",
+		Width:       1280,
+		AudioFilter: "volume=2",
+		Restart:     config.TranscodeRestartConfig{MaxRestarts: 3},
+	})
+	if cfg.Backend != "ffmpeg" || cfg.Restart.MaxRestarts != 3 {
+		t.Fatalf("Remux dropped the backend or restart policy: %+v", cfg)
+	}
+	args, err := ffmpegArgs(cfg, "srt://ingest:9000?streamid=show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args, " ")
+	want := "-re -i pipe:0 -c:v copy -c:a copy -f mpegts srt://ingest:9000?streamid=show"
+	if got != want {
+		t.Fatalf("args = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package transcoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+const defaultRenditionURL = "{upstream}_{name}"
+
+// videoOutput describes one encoded output: where it is pushed, the filter
+// chain that shapes its video and its rate control.
+type videoOutput struct {
+	url    string
+	filter string // ffmpeg filtergraph syntax; "null" leaves frames untouched
+	fps    int    // 0 keeps the source rate
+
+	// Rate control in bits per second; 0 leaves it to the encoder (or crf).
+	bitrate int64
+	maxrate int64
+	bufsize int64
+}
+
+// videoOutputs returns the session's outputs: one per rendition, or the
+// single upstream shaped by the top-level settings.
+func videoOutputs(cfg config.TranscodeConfig, upstream string) ([]videoOutput, error) {
+	if len(cfg.Renditions) == 0 {
+		out := videoOutput{
+			url:    upstream,
+			filter: videoFilter(cfg.Width, cfg.Height, cfg.Scale, cfg.FPS),
+			fps:    cfg.FPS,
+		}
+		var err error
+		if out.bitrate, err = optionalBitrate(cfg.VideoBitrate); err != nil {
+			return nil, fmt.Errorf("video_bitrate: %w", err)
+		}
+		if out.maxrate, err = optionalBitrate(cfg.VideoMaxRate); err != nil {
+			return nil, fmt.Errorf("video_maxrate: %w", err)
+		}
+		if out.bufsize, err = optionalBitrate(cfg.VideoBufSize); err != nil {
+			return nil, fmt.Errorf("video_bufsize: %w", err)
+		}
+		return []videoOutput{out}, nil
+	}
+
+	outputs := make([]videoOutput, 0, len(cfg.Renditions))
+	for _, r := range cfg.Renditions {
+		bps, err := optionalBitrate(r.VideoBitrate)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s video_bitrate: %w", r.Name, err)
+		}
+		// A ladder rung is capped at its bitrate with a two second buffer,
+		// so players can rely on the advertised rate.
+		outputs = append(outputs, videoOutput{
+			url:     renditionURL(upstream, r),
+			filter:  videoFilter(r.Width, r.Height, "", r.FPS),
+			fps:     r.FPS,
+			bitrate: bps,
+			maxrate: bps,
+			bufsize: 2 * bps,
+		})
+	}
+	return outputs, nil
+}
+
+// renditionURL expands a rendition's URL template. With the default template
+// rtmp://host/app/stream becomes rtmp://host/app/stream_720p.
+func renditionURL(upstream string, r config.TranscodeRendition) string {
+	tmpl := r.URL
+	if tmpl == "" {
+		tmpl = defaultRenditionURL
+	}
+	return strings.NewReplacer("{upstream}", upstream, "{name}", r.Name).Replace(tmpl)
+}
+
+// videoFilter builds the scale/fps chain. A missing width or height is -2,
+// which follows the source aspect ratio and rounds to even, as most encoders
+// reject odd dimensions for 4:2:0 video.
+func videoFilter(width, height int, scale string, fps int) string {
+	var parts []string
+	switch {
+	case scale != "":
+		parts = append(parts, "scale="+scale)
+	case width > 0 || height > 0:
+		if width == 0 {
+			width = -2
+		}
+		if height == 0 {
+			height = -2
+		}
+		parts = append(parts, fmt.Sprintf("scale=%d:%d", width, height))
+	}
+	if fps > 0 {
+		parts = append(parts, "fps="+strconv.Itoa(fps))
+	}
+	if len(parts) == 0 {
+		return "null"
+	}
+	return strings.Join(parts, ",")
+}
+
+func optionalBitrate(rate string) (int64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	return config.ParseBitrate(rate)
+}
+
+// rateControlArgs renders an output's rate control as ffmpeg flags.
+func (o videoOutput) rateControlArgs() []string {
+	var args []string
+	for _, f := range []struct {
+		flag string
+		bps  int64
+	}{{"-b:v", o.bitrate}, {"-maxrate", o.maxrate}, {"-bufsize", o.bufsize}} {
+		if f.bps > 0 {
+			args = append(args, f.flag, strconv.FormatInt(f.bps, 10))
+		}
+	}
+	return args
+}
@@ -0,0 +1,97 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestRenditionURL(t *testing.T) {
+	r := config.TranscodeRendition{Name: "720p"}
+	if got := renditionURL("rtmp://cdn/live/show", r); got != "rtmp://cdn/live/show_720p" {
+		t.Fatalf("default template = %s", got)
+	}
+	r.URL = "rtmp://abr/{name}/{upstream}"
+	if got := renditionURL("x", r); got != "rtmp://abr/720p/x" {
+		t.Fatalf("custom template = %s", got)
+	}
+}
+
+func TestVideoFilter(t *testing.T) {
+	tests := []struct {
+		width, height int
+		scale         string
+		fps           int
+		want          string
+	}{
+		{0, 720, "", 0, "scale=-2:720"},
+		{640, 0, "", 25, "scale=640:-2,fps=25"},
+		{480, 360, "", 0, "scale=480:360"},
+		{0, 0, "iw/2:-2", 0, "scale=iw/2:-2"},
+		{0, 0, "", 30, "fps=30"},
+		{0, 0, "", 0, "null"},
+	}
+	for _, tt := range tests {
+		if got := videoFilter(tt.width, tt.height, tt.scale, tt.fps); got != tt.want {
+			t.Fatalf("videoFilter(%d, %d, %q, %d) = %q, want %q", tt.width, tt.height, tt.scale, tt.fps, got, tt.want)
+		}
+	}
+}
+
+func TestFFmpegArgsLadder(t *testing.T) {
+	cfg := config.TranscodeConfig{
+		Preset: "veryfast",
+		Renditions: []config.TranscodeRendition{
+			{Name: "720p", Height: 720, VideoBitrate: "2500k", FPS: 30},
+			{Name: "src"},
+		},
+	}
+	args, err := ffmpegArgs(cfg, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	line := strings.Join(args, " ")
+
+	wantGraph := "[0:v]split=2[v0][v1];[v0]scale=-2:720,fps=30[out0];[v1]null[out1]"
+	if !strings.Contains(line, "-filter_complex "+wantGraph) {
+		t.Fatalf("missing filter graph in %q", line)
+	}
+	if !strings.Contains(line, "-map [out0] -map 0:a? -c:v libx264 -c:a aac -preset veryfast -b:v 2500000 -maxrate 2500000 -bufsize 5000000 -f flv rtmp://cdn/live/show_720p") {
+		t.Fatalf("missing 720p output in %q", line)
+	}
+	if !strings.HasSuffix(line, "-map [out1] -map 0:a? -c:v libx264 -c:a aac -preset veryfast -f flv rtmp://cdn/live/show_src") {
+		t.Fatalf("missing src output in %q", line)
+	}
+}
+
+func TestFFmpegArgsSingleOutput(t *testing.T) {
+	args, err := ffmpegArgs(config.TranscodeConfig{}, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	if got := strings.Join(args, " "); got != "-re -i pipe:0 -c:v libx264 -c:a aac -f flv rtmp://cdn/live/show" {
+		t.Fatalf("args = %q", got)
+	}
+}
+
+func TestFFmpegArgsShaping(t *testing.T) {
+	cfg := config.TranscodeConfig{
+		Height:          480,
+		FPS:             30,
+		VideoBitrate:    "1M",
+		VideoMaxRate:    "1500k",
+		VideoBufSize:    "3M",
+		AudioBitrate:    "128k",
+		AudioSampleRate: 44100,
+	}
+	args, err := ffmpegArgs(cfg, "rtmp://cdn/live/show")
+	if err != nil {
+		t.Fatalf("ffmpegArgs: %v", err)
+	}
+	want := "-re -i pipe:0 -c:v libx264 -c:a aac -b:a 128k -ar 44100 -vf scale=-2:480,fps=30 " +
+		"-b:v 1000000 -maxrate 1500000 -bufsize 3000000 -f flv rtmp://cdn/live/show"
+	if got := strings.Join(args, " "); got != want {
+		t.Fatalf("args = %q\nwant   %q", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package transcoder
+
+import (
+	"sync"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// Slots caps the number of sessions transcoding at once. Each transcode runs
+// its own encoder, so without a cap a burst of publishers can starve the host
+// of CPU. A nil Slots, or one with no maximum, never runs out.
+type Slots struct {
+	max      int
+	overflow string
+
+	mu    sync.Mutex
+	inUse int
+}
+
+// SlotsStatus is a point-in-time view of the slots.
+type SlotsStatus struct {
+	MaxSessions int    `json:"max_sessions"`
+	InUse       int    `json:"in_use"`
+	Overflow    string `json:"overflow"`
+}
+
+// NewSlots returns the limiter described by cfg, or nil when it is unlimited.
+func NewSlots(cfg config.TranscodeSlotsConfig) *Slots {
+	if cfg.MaxSessions <= 0 {
+		return nil
+	}
+	overflow := cfg.Overflow
+	if overflow == "" {
+		overflow = FallbackPassthrough
+	}
+	return &Slots{max: cfg.MaxSessions, overflow: overflow}
+}
+
+// TryAcquire takes a slot if one is free. Every successful call must be paired
+// with Release.
+func (s *Slots) TryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inUse >= s.max {
+		return false
+	}
+	s.inUse++
+	return true
+}
+
+// Release returns a slot taken by TryAcquire.
+func (s *Slots) Release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.inUse > 0 {
+		s.inUse--
+	}
+	s.mu.Unlock()
+}
+
+// Overflow returns FallbackPassthrough or FallbackReject: what happens to a
+// session that finds every slot taken.
+func (s *Slots) Overflow() string {
+	if s == nil {
+		return FallbackPassthrough
+	}
+	return s.overflow
+}
+
+// Status reports the cap and current usage.
+func (s *Slots) Status() SlotsStatus {
+	if s == nil {
+		return SlotsStatus{Overflow: FallbackPassthrough}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SlotsStatus{MaxSessions: s.max, InUse: s.inUse, Overflow: s.overflow}
+}
@@ -0,0 +1,40 @@
+package transcoder
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestSlotsCapConcurrentSessions(t *testing.T) {
+	s := NewSlots(config.TranscodeSlotsConfig{MaxSessions: 2, Overflow: FallbackReject})
+	if !s.TryAcquire() || !s.TryAcquire() {
+		t.Fatal("expected two free slots")
+	}
+	if s.TryAcquire() {
+		t.Fatal("third session took a slot past the cap")
+	}
+	if got := s.Status(); got.InUse != 2 || got.MaxSessions != 2 || got.Overflow != FallbackReject {
+		t.Fatalf("Status = %+v", got)
+	}
+	s.Release()
+	if !s.TryAcquire() {
+		t.Fatal("released slot was not reusable")
+	}
+}
+
+func TestSlotsUnlimited(t *testing.T) {
+	s := NewSlots(config.TranscodeSlotsConfig{})
+	if s != nil {
+		t.Fatal("max_sessions 0 should not build a limiter")
+	}
+	for range 100 {
+		if !s.TryAcquire() {
+			t.Fatal("nil Slots ran out")
+		}
+	}
+	s.Release()
+	if s.Overflow() != FallbackPassthrough {
+		t.Fatalf("Overflow = %q, want passthrough", s.Overflow())
+	}
+}
@@ -0,0 +1,36 @@
+package transcoder
+
+import (
+	"slices"
+	"strings"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+// Smart reports whether cfg transcodes only publishers whose codecs the
+// upstream does not take as they are.
+func Smart(cfg config.TranscodeConfig) bool {
+	return cfg.Mode == config.TranscodeModeSmart
+}
+
+// Compatible reports whether a publisher sending video and audio, named as
+// rtmp.VideoHeader.Codec and rtmp.AudioHeader.Codec name them, can be
+// relayed unchanged under cfg in smart mode. An empty name means the
+// publisher sends no such track, which needs no encoder.
+func Compatible(cfg config.TranscodeConfig, video, audio string) bool {
+	return accepts(cfg.AcceptVideo, cfg.VideoCodec, "libx264", video) &&
+		accepts(cfg.AcceptAudio, cfg.AudioCodec, "aac", audio)
+}
+
+func accepts(accepted []string, encoder, def, codec string) bool {
+	if codec == "" {
+		return true
+	}
+	if len(accepted) > 0 {
+		return slices.Contains(accepted, codec)
+	}
+	if strings.EqualFold(strings.TrimSpace(encoder), "copy") {
+		return true
+	}
+	return config.EncoderCodec(encoder, def) == codec
+}
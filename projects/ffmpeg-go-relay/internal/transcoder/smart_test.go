@@ -0,0 +1,32 @@
+package transcoder
+
+import (
+	"testing"
+
+	"ffmpeg-go-relay/internal/config"
+)
+
+func TestCompatible(t *testing.T) {
+	defaults := config.TranscodeConfig{Mode: config.TranscodeModeSmart}
+	nvenc := config.TranscodeConfig{Mode: config.TranscodeModeSmart, VideoCodec: "h264_nvenc", AudioCodec: "copy"}
+	listed := config.TranscodeConfig{Mode: config.TranscodeModeSmart, AcceptVideo: []string{"h264", "hevc"}, AcceptAudio: []string{"aac", "mp3"}}
+	for _, tc := range []struct {
+		name         string
+		cfg          config.TranscodeConfig
+		video, audio string
+		want         bool
+	}{
+		{"defaults take h264 and aac", defaults, "h264", "aac", true},
+		{"defaults encode hevc", defaults, "hevc", "aac", false},
+		{"defaults encode mp3", defaults, "h264", "mp3", false},
+		{"a missing track needs no encoder", defaults, "h264", "", true},
+		{"hardware encoder", nvenc, "h264", "mp3", true},
+		{"hardware encoder converts av1", nvenc, "av1", "aac", false},
+		{"accepted lists", listed, "hevc", "mp3", true},
+		{"outside the lists", listed, "vp9", "aac", false},
+	} {
+		if got := Compatible(tc.cfg, tc.video, tc.audio); got != tc.want {
+			t.Errorf("%s: Compatible(%s, %s) = %v, want %v", tc.name, tc.video, tc.audio, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = 30 * time.Second
+	defaultRestartResetAfter = time.Minute
+
+	flvHeaderSize    = 13 // file header plus the first PreviousTagSize
+	flvTagHeaderSize = 11
+)
+
+// supervisor restarts a backend that fails mid-stream. It splits the FLV
+// stream it is fed into whole tags, remembering the file header, the last
+// metadata tag and the codec sequence headers. After a restart those are
+// replayed so the new process can decode, and video inter frames are dropped
+// until the next keyframe.
+type supervisor struct {
+	ctx   context.Context
+	cfg   config.TranscodeRestartConfig
+	start func(ctx context.Context) (Backend, error)
+	log   *logger.Logger
+
+	curMu    sync.Mutex // Guards cur changes against Progress; Write is the only writer
+	cur      Backend
+	started  time.Time
+	restarts int
+	backoff  time.Duration
+
+	pending      []byte
+	header       []byte
+	metadata     []byte
+	videoSeq     []byte
+	audioSeq     []byte
+	waitKeyframe bool
+}
+
+// supervise starts a backend and returns it wrapped in a supervisor. An error
+// from the first start is returned as is; only later failures are retried.
+func supervise(ctx context.Context, cfg config.TranscodeRestartConfig, start func(context.Context) (Backend, error), log *logger.Logger) (Backend, error) {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = config.Duration(defaultRestartBackoff)
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = config.Duration(defaultRestartMaxBackoff)
+	}
+	if cfg.ResetAfter <= 0 {
+		cfg.ResetAfter = config.Duration(defaultRestartResetAfter)
+	}
+	b, err := start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &supervisor{
+		ctx:     ctx,
+		cfg:     cfg,
+		start:   start,
+		log:     log,
+		cur:     b,
+		started: time.Now(),
+		backoff: time.Duration(cfg.Backoff),
+	}, nil
+}
+
+// Write buffers p and forwards every complete FLV tag in it.
+func (s *supervisor) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	for {
+		unit, isTag := s.nextUnit()
+		if unit == nil {
+			return len(p), nil
+		}
+		if isTag {
+			s.remember(unit)
+		}
+		if err := s.forward(unit, isTag); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// nextUnit removes the file header or the next complete tag from pending.
+func (s *supervisor) nextUnit() (unit []byte, isTag bool) {
+	size := flvHeaderSize
+	if s.header != nil {
+		if len(s.pending) < flvTagHeaderSize {
+			return nil, false
+		}
+		dataSize := int(s.pending[1])<<16 | int(s.pending[2])<<8 | int(s.pending[3])
+		size = flvTagHeaderSize + dataSize + 4
+	}
+	if len(s.pending) < size {
+		return nil, false
+	}
+	unit = make([]byte, size)
+	copy(unit, s.pending)
+	s.pending = s.pending[size:]
+	if len(s.pending) == 0 {
+		s.pending = nil
+	}
+	if s.header == nil {
+		s.header = unit
+		return unit, false
+	}
+	return unit, true
+}
+
+func (s *supervisor) forward(unit []byte, isTag bool) error {
+	for {
+		if isTag && s.skip(unit) {
+			return nil
+		}
+		_, err := s.cur.Write(unit)
+		if err == nil {
+			return nil
+		}
+		if err := s.restart(err); err != nil {
+			return err
+		}
+		if !isTag || s.replayed(unit) {
+			return nil
+		}
+	}
+}
+
+// replayed reports whether a restart already sent unit as a cached header.
+func (s *supervisor) replayed(unit []byte) bool {
+	for _, cached := range [][]byte{s.metadata, s.videoSeq, s.audioSeq} {
+		if bytes.Equal(cached, unit) {
+			return true
+		}
+	}
+	return false
+}
+
+// remember caches the tags a restarted backend needs before any media.
+func (s *supervisor) remember(tag []byte) {
+	switch {
+	case tag[0] == rtmp.TagTypeScript:
+		s.metadata = tag
+	case isVideoSequenceHeader(tag):
+		s.videoSeq = tag
+	case isAACSequenceHeader(tag):
+		s.audioSeq = tag
+	}
+}
+
+// skip reports whether tag is a video frame that cannot be decoded yet
+// because no keyframe has followed a restart.
+func (s *supervisor) skip(tag []byte) bool {
+	if !s.waitKeyframe || tag[0] != rtmp.TagTypeVideo || isVideoSequenceHeader(tag) {
+		return false
+	}
+	if isVideoKeyframe(tag) {
+		s.waitKeyframe = false
+		return false
+	}
+	return true
+}
+
+// restart replaces the failed backend, waiting out the backoff first, and
+// replays the cached headers into the new one. It gives up once the restart
+// budget is spent; a backend that ran for ResetAfter earns a fresh budget.
+func (s *supervisor) restart(cause error) error {
+	s.closeCurrent()
+	for {
+		if time.Since(s.started) >= time.Duration(s.cfg.ResetAfter) {
+			s.restarts = 0
+			s.backoff = time.Duration(s.cfg.Backoff)
+		}
+		if s.restarts >= s.cfg.MaxRestarts {
+			return &errclass.TranscodeError{Op: "restart", Err: fmt.Errorf("gave up after %d restarts: %w", s.restarts, cause)}
+		}
+		s.restarts++
+		s.log.Warn("transcoder exited, restarting", "err", cause, "attempt", s.restarts, "max_restarts", s.cfg.MaxRestarts, "backoff", s.backoff)
+
+		timer := time.NewTimer(s.backoff)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("transcoder restart cancelled: %w", s.ctx.Err())
+		case <-timer.C:
+		}
+		s.backoff = min(2*s.backoff, time.Duration(s.cfg.MaxBackoff))
+
+		b, err := s.start(s.ctx)
+		s.started = time.Now()
+		if err != nil {
+			cause = err
+			continue
+		}
+		s.setCurrent(b)
+		if err := s.replay(); err != nil {
+			cause = err
+			s.closeCurrent()
+			continue
+		}
+		s.waitKeyframe = true
+		return nil
+	}
+}
+
+func (s *supervisor) replay() error {
+	for _, unit := range [][]byte{s.header, s.metadata, s.videoSeq, s.audioSeq} {
+		if unit == nil {
+			continue
+		}
+		if _, err := s.cur.Write(unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *supervisor) closeCurrent() {
+	if s.cur == nil {
+		return
+	}
+	if err := s.cur.Close(); err != nil {
+		s.log.Debug("failed transcoder exited", "err", err)
+	}
+	s.setCurrent(nil)
+}
+
+func (s *supervisor) setCurrent(b Backend) {
+	s.curMu.Lock()
+	s.cur = b
+	s.curMu.Unlock()
+}
+
+// Progress reports the running backend's progress. Counters start over with
+// each restarted process.
+func (s *supervisor) Progress() (Progress, bool) {
+	s.curMu.Lock()
+	defer s.curMu.Unlock()
+	if s.cur == nil {
+		return Progress{}, false
+	}
+	return ReadProgress(s.cur)
+}
+
+// Usage samples the running backend's process.
+func (s *supervisor) Usage() (Usage, bool) {
+	s.curMu.Lock()
+	defer s.curMu.Unlock()
+	if s.cur == nil {
+		return Usage{}, false
+	}
+	return ReadUsage(s.cur)
+}
+
+// Close ends the current backend. Tag bytes still buffered are incomplete
+// and dropped.
+func (s *supervisor) Close() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	return err
+}
+
+func tagPayload(tag []byte) []byte {
+	return tag[flvTagHeaderSize : len(tag)-4]
+}
+
+// isVideoSequenceHeader covers AVC and HEVC decoder configuration records in
+// both the legacy and the enhanced RTMP tag layouts.
+func isVideoSequenceHeader(tag []byte) bool {
+	p := tagPayload(tag)
+	if tag[0] != rtmp.TagTypeVideo || len(p) < 2 {
+		return false
+	}
+	if p[0]&0x80 != 0 { // enhanced RTMP: the low nibble is the packet type
+		return p[0]&0x0f == 0
+	}
+	codec := p[0] & 0x0f
+	return (codec == rtmp.VideoAVC || codec == 12) && p[1] == 0
+}
+
+// isVideoKeyframe masks the enhanced RTMP flag out of the frame type.
+func isVideoKeyframe(tag []byte) bool {
+	p := tagPayload(tag)
+	return tag[0] == rtmp.TagTypeVideo && len(p) > 0 && (p[0]>>4)&0x07 == rtmp.FrameKeyframe
+}
+
+func isAACSequenceHeader(tag []byte) bool {
+	p := tagPayload(tag)
+	return tag[0] == rtmp.TagTypeAudio && len(p) > 1 && p[0]>>4 == rtmp.AudioAAC && p[1] == 0
+}
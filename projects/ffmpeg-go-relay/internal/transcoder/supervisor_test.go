@@ -0,0 +1,192 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/errclass"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/rtmp"
+)
+
+// fakeBackend records complete writes and starts failing after failAfter
+// of them, like an ffmpeg whose stdin pipe broke.
+type fakeBackend struct {
+	writes    [][]byte
+	failAfter int
+	closed    bool
+}
+
+func (f *fakeBackend) Write(p []byte) (int, error) {
+	if f.failAfter >= 0 && len(f.writes) >= f.failAfter {
+		return 0, io.ErrClosedPipe
+	}
+	f.writes = append(f.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (f *fakeBackend) Close() error {
+	f.closed = true
+	return nil
+}
+
+func flvTag(t *testing.T, typeID uint8, payload ...byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	msg := &rtmp.Message{Payload: payload}
+	msg.Header.TypeID = typeID
+	if err := rtmp.MessageToFLVTag(&b, msg); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func testRestartConfig(max int) config.TranscodeRestartConfig {
+	return config.TranscodeRestartConfig{MaxRestarts: max, Backoff: config.Duration(time.Millisecond)}
+}
+
+func TestSupervisorReplaysHeadersAfterRestart(t *testing.T) {
+	var started []*fakeBackend
+	start := func(context.Context) (Backend, error) {
+		// The first process dies after the header, metadata, both sequence
+		// headers and one keyframe.
+		f := &fakeBackend{failAfter: -1}
+		if len(started) == 0 {
+			f.failAfter = 5
+		}
+		started = append(started, f)
+		return f, nil
+	}
+	b, err := supervise(context.Background(), testRestartConfig(2), start, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header bytes.Buffer
+	if err := rtmp.WriteFLVHeader(&header, true, true); err != nil {
+		t.Fatal(err)
+	}
+	metadata := flvTag(t, rtmp.TagTypeScript, 0x02, 0x00, 0x0a)
+	videoSeq := flvTag(t, rtmp.TagTypeVideo, 0x17, 0x00, 0, 0, 0, 0x01)
+	audioSeq := flvTag(t, rtmp.TagTypeAudio, 0xaf, 0x00, 0x12, 0x10)
+	keyframe := flvTag(t, rtmp.TagTypeVideo, 0x17, 0x01, 0, 0, 0, 0xaa)
+	inter := flvTag(t, rtmp.TagTypeVideo, 0x27, 0x01, 0, 0, 0, 0xbb)
+	audio := flvTag(t, rtmp.TagTypeAudio, 0xaf, 0x01, 0xcc)
+	nextKeyframe := flvTag(t, rtmp.TagTypeVideo, 0x17, 0x01, 0, 0, 0, 0xdd)
+
+	// Feed the stream in awkward pieces so tags straddle writes.
+	var stream []byte
+	for _, unit := range [][]byte{header.Bytes(), metadata, videoSeq, audioSeq, keyframe, inter, audio, nextKeyframe} {
+		stream = append(stream, unit...)
+	}
+	for len(stream) > 0 {
+		n := min(7, len(stream))
+		if _, err := b.Write(stream[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		stream = stream[n:]
+	}
+
+	if len(started) != 2 {
+		t.Fatalf("started %d backends, want 2", len(started))
+	}
+	if !started[0].closed {
+		t.Fatal("failed backend was not closed")
+	}
+	want := [][]byte{header.Bytes(), metadata, videoSeq, audioSeq, audio, nextKeyframe}
+	got := started[1].writes
+	if len(got) != len(want) {
+		t.Fatalf("restarted backend got %d writes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("write %d = % x, want % x", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSupervisorGivesUpAfterMaxRestarts(t *testing.T) {
+	starts := 0
+	start := func(context.Context) (Backend, error) {
+		starts++
+		if starts > 1 {
+			return nil, errors.New("ffmpeg binary not found")
+		}
+		return &fakeBackend{failAfter: 1}, nil
+	}
+	b, err := supervise(context.Background(), testRestartConfig(2), start, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header bytes.Buffer
+	rtmp.WriteFLVHeader(&header, true, true)
+	if _, err := b.Write(header.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Write(flvTag(t, rtmp.TagTypeVideo, 0x17, 0x01, 0, 0, 0, 0xaa))
+	if errclass.Of(err) != errclass.Transcode || !strings.Contains(err.Error(), "after 2 restarts") {
+		t.Fatalf("Write error = %v, want the restart budget to run out", err)
+	}
+	if starts != 3 {
+		t.Fatalf("start called %d times, want 3", starts)
+	}
+}
+
+func TestSupervisorStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := func(context.Context) (Backend, error) { return &fakeBackend{failAfter: 0}, nil }
+	cfg := config.TranscodeRestartConfig{MaxRestarts: 5, Backoff: config.Duration(time.Hour)}
+	b, err := supervise(ctx, cfg, start, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	var header bytes.Buffer
+	rtmp.WriteFLVHeader(&header, true, true)
+	if _, err := b.Write(header.Bytes()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Write error = %v, want context.Canceled", err)
+	}
+}
+
+// progressBackend is a fakeBackend that reports a fixed frame count.
+type progressBackend struct {
+	fakeBackend
+	frames int64
+}
+
+func (p *progressBackend) Progress() (Progress, bool) {
+	return Progress{Frames: p.frames}, true
+}
+
+func TestSupervisorForwardsProgress(t *testing.T) {
+	frames := int64(0)
+	start := func(context.Context) (Backend, error) {
+		// The first process dies after the FLV header.
+		failAfter := -1
+		if frames == 0 {
+			failAfter = 1
+		}
+		frames += 100
+		return &progressBackend{fakeBackend: fakeBackend{failAfter: failAfter}, frames: frames}, nil
+	}
+	b, err := supervise(context.Background(), testRestartConfig(1), start, logger.NewWithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := ReadProgress(b); !ok || p.Frames != 100 {
+		t.Fatalf("progress = %+v, %v; want the first backend's", p, ok)
+	}
+	var header bytes.Buffer
+	rtmp.WriteFLVHeader(&header, true, true)
+	b.Write(header.Bytes())
+	b.Write(flvTag(t, rtmp.TagTypeVideo, 0x17, 0x01, 0, 0, 0, 0xaa))
+	if p, ok := ReadProgress(b); !ok || p.Frames != 200 {
+		t.Fatalf("progress = %+v, %v; want the restarted backend's", p, ok)
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"strings"
 
 	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/errclass"
 	"ffmpeg-go-relay/internal/logger"
 )
 
 const (
+	backendAuto   = "auto"
 	backendFFmpeg = "ffmpeg"
 	backendLibAV  = "libav"
 )
@@ -19,29 +21,90 @@ type Backend interface {
 	io.WriteCloser
 }
 
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx naming the session a transcoder
+// started with it works for. The ffmpeg backend prefixes its stderr with the
+// ID, so encoder output can be joined with the session's other log lines.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// New starts a transcoder for cfg publishing to upstream. Failures are
+// returned as an *errclass.TranscodeError.
 func New(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
 	backend, err := resolveBackend(cfg)
 	if err != nil {
-		return nil, err
+		return nil, &errclass.TranscodeError{Op: "start", Err: err}
 	}
 
+	var start func(context.Context) (Backend, error)
 	switch backend {
+	case backendAuto:
+		start = func(ctx context.Context) (Backend, error) { return newAutoBackend(ctx, cfg, upstream, log) }
 	case backendFFmpeg:
-		return newFFmpegBackend(ctx, cfg, upstream, log)
+		start = func(ctx context.Context) (Backend, error) { return newFFmpegBackend(ctx, cfg, upstream, log) }
 	case backendLibAV:
-		return newLibAVBackend(ctx, cfg, upstream, log)
+		start = func(ctx context.Context) (Backend, error) { return newLibAVBackend(ctx, cfg, upstream, log) }
 	default:
-		return nil, fmt.Errorf("unknown transcode backend: %s", backend)
+		return nil, &errclass.TranscodeError{Op: "start", Err: fmt.Errorf("unknown transcode backend: %s", backend)}
+	}
+	var b Backend
+	if cfg.Restart.MaxRestarts > 0 {
+		b, err = supervise(ctx, cfg.Restart, start, log)
+	} else {
+		b, err = start(ctx)
+	}
+	if err != nil {
+		return nil, &errclass.TranscodeError{Op: "start", Err: err}
 	}
+	return b, nil
 }
 
 func resolveBackend(cfg config.TranscodeConfig) (string, error) {
 	backend := strings.TrimSpace(strings.ToLower(cfg.Backend))
 	if backend == "" {
-		return backendFFmpeg, nil
+		return backendAuto, nil
 	}
-	if backend != backendFFmpeg && backend != backendLibAV {
+	if backend != backendAuto && backend != backendFFmpeg && backend != backendLibAV {
 		return "", fmt.Errorf("unknown transcode backend: %s", cfg.Backend)
 	}
 	return backend, nil
 }
+
+// newAutoBackend starts the libav backend when it is built in and can run
+// cfg, and the ffmpeg binary otherwise, logging why libav was passed over.
+func newAutoBackend(ctx context.Context, cfg config.TranscodeConfig, upstream string, log *logger.Logger) (Backend, error) {
+	return startAuto(ctx, cfg, libavUsable(cfg, upstream), log,
+		func(ctx context.Context) (Backend, error) { return newLibAVBackend(ctx, cfg, upstream, log) },
+		func(ctx context.Context) (Backend, error) { return newFFmpegBackend(ctx, cfg, upstream, log) })
+}
+
+// startAuto picks between the two backends: ffmpeg when cfg has extra ffmpeg
+// arguments or unusable says why libav cannot run, and when libav fails to
+// start.
+func startAuto(ctx context.Context, cfg config.TranscodeConfig, unusable error, log *logger.Logger, libav, ffmpeg func(context.Context) (Backend, error)) (Backend, error) {
+	if len(cfg.ExtraInputArgs) > 0 || len(cfg.ExtraOutputArgs) > 0 {
+		log.Debug("using the ffmpeg backend for extra ffmpeg arguments")
+		return ffmpeg(ctx)
+	}
+	if unusable != nil {
+		if libavBuilt {
+			log.Warn("libav backend unusable, falling back to ffmpeg", "err", unusable)
+		} else {
+			log.Debug("using the ffmpeg backend", "reason", unusable)
+		}
+		return ffmpeg(ctx)
+	}
+	b, err := libav(ctx)
+	if err != nil {
+		log.Warn("libav backend failed to start, falling back to ffmpeg", "err", err)
+		return ffmpeg(ctx)
+	}
+	return b, nil
+}
@@ -0,0 +1,64 @@
+package transcoder
+
+import (
+	"sync"
+	"time"
+)
+
+// usageWindow is the shortest interval CPUPercent is measured over, so
+// several sessions polling one shared transcoder do not each see a sliver.
+const usageWindow = time.Second
+
+// Usage is the host CPU and memory a transcoder process uses.
+type Usage struct {
+	PID        int       `json:"pid"`
+	CPUSeconds float64   `json:"cpu_seconds"` // User plus system time since the process started
+	CPUPercent float64   `json:"cpu_percent"` // Over the last sample interval; 100 is one core
+	RSSBytes   int64     `json:"rss_bytes"`
+	Updated    time.Time `json:"updated"`
+}
+
+// UsageReporter is implemented by backends that run in a process of their
+// own, whose resources can be told apart from the relay's.
+type UsageReporter interface {
+	// Usage samples the process; false once it has exited or where
+	// process accounting is unsupported.
+	Usage() (Usage, bool)
+}
+
+// ReadUsage samples b's process, if it runs in one of its own.
+func ReadUsage(b Backend) (Usage, bool) {
+	if r, ok := b.(UsageReporter); ok {
+		return r.Usage()
+	}
+	return Usage{}, false
+}
+
+// usageState turns CPU time samples of one process into a CPU percentage.
+type usageState struct {
+	mu      sync.Mutex
+	base    Usage // Sample CPUPercent is measured from
+	percent float64
+}
+
+func (s *usageState) sample(pid int) (Usage, bool) {
+	cpu, rss, err := processUsage(pid)
+	if err != nil {
+		return Usage{}, false
+	}
+	return s.update(Usage{PID: pid, CPUSeconds: cpu, RSSBytes: rss, Updated: time.Now()}), true
+}
+
+func (s *usageState) update(u Usage) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch elapsed := u.Updated.Sub(s.base.Updated); {
+	case s.base.PID != u.PID:
+		s.base, s.percent = u, 0
+	case elapsed >= usageWindow:
+		s.percent = (u.CPUSeconds - s.base.CPUSeconds) / elapsed.Seconds() * 100
+		s.base = u
+	}
+	u.CPUPercent = s.percent
+	return u
+}
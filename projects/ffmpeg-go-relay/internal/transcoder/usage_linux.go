@@ -0,0 +1,49 @@
+package transcoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat. It
+// is 100 on every Linux architecture Go supports.
+const clockTicks = 100
+
+// processUsage reads pid's CPU time and resident set size from /proc.
+func processUsage(pid int) (cpuSeconds float64, rssBytes int64, err error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseProcStat(stat, os.Getpagesize())
+}
+
+// parseProcStat reads utime, stime and rss, fields 14, 15 and 24 of
+// /proc/<pid>/stat. The command name in field 2 may hold spaces and
+// parentheses, so fields are counted from its closing parenthesis.
+func parseProcStat(stat []byte, pageSize int) (float64, int64, error) {
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, 0, errors.New("malformed /proc stat")
+	}
+	fields := bytes.Fields(stat[i+1:]) // From field 3, state
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("short /proc stat: %d fields", len(fields)+2)
+	}
+	utime, err := strconv.ParseInt(string(fields[14-3]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("/proc stat utime: %w", err)
+	}
+	stime, err := strconv.ParseInt(string(fields[15-3]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("/proc stat stime: %w", err)
+	}
+	pages, err := strconv.ParseInt(string(fields[24-3]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("/proc stat rss: %w", err)
+	}
+	return float64(utime+stime) / clockTicks, pages * int64(pageSize), nil
+}
@@ -0,0 +1,27 @@
+package transcoder
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// pid, a command name with a space and parentheses, then fields 3 to 24
+	stat := []byte("4242 (ffmpeg (x) y) S 1 4242 4242 0 -1 4194560 3028 0 0 0 250 75 0 0 20 0 9 0 12345 512000000 2048 18446744073709551615\n")
+	cpu, rss, err := parseProcStat(stat, 4096)
+	if err != nil || cpu != 3.25 || rss != 2048*4096 {
+		t.Fatalf("parseProcStat = %v, %v, %v; want 3.25s and 8 MiB", cpu, rss, err)
+	}
+	for _, bad := range []string{"", "4242 (ffmpeg) S 1 2 3", "4242 (ffmpeg) S 1 4242 4242 0 -1 4194560 3028 0 0 0 x 75 0 0 20 0 9 0 12345 512000000 2048"} {
+		if _, _, err := parseProcStat([]byte(bad), 4096); err == nil {
+			t.Errorf("parseProcStat(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestProcessUsageSelf(t *testing.T) {
+	cpu, rss, err := processUsage(os.Getpid())
+	if err != nil || cpu < 0 || rss <= 0 {
+		t.Fatalf("processUsage(self) = %v, %v, %v", cpu, rss, err)
+	}
+}
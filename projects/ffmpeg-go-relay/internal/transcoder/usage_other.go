@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transcoder
+
+import "errors"
+
+// processUsage is only implemented on Linux.
+func processUsage(pid int) (float64, int64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
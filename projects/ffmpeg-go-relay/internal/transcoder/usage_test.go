@@ -0,0 +1,29 @@
+package transcoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsagePercent(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var s usageState
+	for _, tc := range []struct {
+		pid     int
+		after   time.Duration
+		cpu     float64
+		percent float64
+	}{
+		{1, 0, 10, 0},                           // First sample: nothing to measure from
+		{1, 500 * time.Millisecond, 10.4, 0},    // Inside the window: kept
+		{1, 2 * time.Second, 13, 150},           // 3s of CPU over 2s
+		{1, 2500 * time.Millisecond, 13.1, 150}, // Inside the window again
+		{2, 3 * time.Second, 0.5, 0},            // A restarted process starts over
+		{2, 5 * time.Second, 1.5, 50},
+	} {
+		u := s.update(Usage{PID: tc.pid, CPUSeconds: tc.cpu, Updated: start.Add(tc.after)})
+		if u.CPUPercent != tc.percent {
+			t.Errorf("pid %d at %v: CPUPercent = %v, want %v", tc.pid, tc.after, u.CPUPercent, tc.percent)
+		}
+	}
+}
@@ -28,8 +28,8 @@ func ValidateUpstreamURL(upstream string) error {
 	}
 
 	// Validate scheme
-	if parsed.Scheme != "rtmp" && parsed.Scheme != "rtmps" && parsed.Scheme != "rtsps" && parsed.Scheme != "rtsp" {
-		return fmt.Errorf("unsupported scheme %q (must be rtmp, rtmps, rtsp, or rtsps)", parsed.Scheme)
+	if parsed.Scheme != "rtmp" && parsed.Scheme != "rtmps" && parsed.Scheme != "rtsps" && parsed.Scheme != "rtsp" && parsed.Scheme != "srt" {
+		return fmt.Errorf("unsupported scheme %q (must be rtmp, rtmps, rtsp, rtsps, or srt)", parsed.Scheme)
 	}
 
 	// Extract host and port
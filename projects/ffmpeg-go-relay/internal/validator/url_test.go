@@ -37,6 +37,11 @@ func TestValidateUpstreamURL(t *testing.T) {
 			url:     "rtsps://example.com/stream",
 			wantErr: false,
 		},
+		{
+			name:    "valid SRT URL",
+			url:     "srt://example.com:9000?streamid=live",
+			wantErr: false,
+		},
 		{
 			name:    "public IPv4 address",
 			url:     "rtmp://8.8.8.8:1935/app",
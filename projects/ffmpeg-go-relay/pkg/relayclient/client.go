@@ -0,0 +1,487 @@
+// Package relayclient is a typed Go client for the relay's HTTP API, the one
+// described by the OpenAPI document the relay serves at /admin/openapi.json.
+// Types mirror the JSON the relay sends; fields a relay version does not
+// report are left zero.
+package relayclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls one relay. It is safe for concurrent use.
+type Client struct {
+	base  string
+	http  *http.Client
+	token string
+}
+
+// New returns a client for the relay at baseURL, e.g. "http://relay:8080";
+// a bare "host:port" is taken as http. A nil httpClient uses
+// http.DefaultClient.
+func New(baseURL string, httpClient *http.Client) *Client {
+	base := strings.TrimRight(baseURL, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{base: base, http: httpClient}
+}
+
+// WithToken returns a copy of c that sends token as a bearer credential,
+// as minting playback tokens requires a publish token of the stream's app.
+func (c *Client) WithToken(token string) *Client {
+	cc := *c
+	cc.token = token
+	return &cc
+}
+
+// authorize adds c's bearer credential to req, if it has one.
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// APIError is returned for responses with a status of 300 or above.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string // The "error" field of the body, when there is one
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %s (%s)", e.Method, e.Path, e.Message, e.Status)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
+
+// Do sends body as JSON when it is non-nil and decodes the response into
+// out, which may be nil to discard it or a *json.RawMessage to keep it
+// verbatim. It is the escape hatch for endpoints and fields the typed
+// methods do not cover.
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+	data, status, err := c.roundTrip(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if status.code >= 300 {
+		return apiError(method, path, status, data)
+	}
+	return decode(data, out)
+}
+
+type responseStatus struct {
+	code int
+	text string
+}
+
+func (c *Client) roundTrip(ctx context.Context, method, path string, body any) ([]byte, responseStatus, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, responseStatus{}, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reqBody)
+	if err != nil {
+		return nil, responseStatus{}, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, responseStatus{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, responseStatus{}, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return data, responseStatus{resp.StatusCode, resp.Status}, nil
+}
+
+func apiError(method, path string, status responseStatus, data []byte) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(data, &body)
+	return &APIError{Method: method, Path: path, StatusCode: status.code, Status: status.text, Message: body.Error}
+}
+
+func decode(data []byte, out any) error {
+	if out == nil {
+		return nil
+	}
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Readiness is the answer of /ready.
+type Readiness struct {
+	Ready        bool                      `json:"ready"`
+	Time         int64                     `json:"time"`
+	CheckedAt    int64                     `json:"checked_at"` // Unix time of the probe the answer comes from
+	Cached       bool                      `json:"cached"`
+	Upstream     string                    `json:"upstream"`
+	Reachable    bool                      `json:"reachable"`
+	Draining     bool                      `json:"draining,omitempty"` // The node is draining for maintenance
+	Dependencies map[string]map[string]any `json:"dependencies"`
+}
+
+// Ready returns the relay's readiness. A relay that is not ready answers
+// 503, which is reported through Readiness.Ready rather than as an error.
+func (c *Client) Ready(ctx context.Context) (Readiness, error) {
+	var r Readiness
+	data, status, err := c.roundTrip(ctx, http.MethodGet, "/ready", nil)
+	if err != nil {
+		return r, err
+	}
+	if status.code >= 300 && status.code != http.StatusServiceUnavailable {
+		return r, apiError(http.MethodGet, "/ready", status, data)
+	}
+	return r, decode(data, &r)
+}
+
+// UpstreamStatus is one member of the upstream pool.
+type UpstreamStatus struct {
+	URL             string         `json:"url"`
+	Weight          int            `json:"weight"`
+	Healthy         bool           `json:"healthy"`
+	LastCheckedUnix int64          `json:"last_checked_unix"`
+	LastError       string         `json:"last_error,omitempty"`
+	EgressShaping   map[string]any `json:"egress_shaping,omitempty"`
+}
+
+// Status holds the commonly used parts of /status. Use Do with a
+// *json.RawMessage for the whole document.
+type Status struct {
+	Time             int64            `json:"time"`
+	StartedAt        int64            `json:"started_at"`
+	UptimeSeconds    float64          `json:"uptime_seconds"`
+	Upstream         string           `json:"upstream"`
+	UpstreamStrategy string           `json:"upstream_strategy,omitempty"`
+	Upstreams        []UpstreamStatus `json:"upstreams,omitempty"`
+	CircuitBreaker   *CircuitBreaker  `json:"circuit_breaker,omitempty"`
+	TranscodeSwitch  *TranscodeSwitch `json:"transcode_kill_switch,omitempty"`
+	Viewers          []StreamViewers  `json:"viewers,omitempty"`
+}
+
+// StreamViewers is the number of players of one stream, in total and by
+// protocol: "rtmp", "http_flv" or "hls" (HLS and DASH).
+type StreamViewers struct {
+	Stream     string         `json:"stream"`
+	Total      int            `json:"total"`
+	ByProtocol map[string]int `json:"by_protocol"`
+}
+
+// Status returns the relay's status.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var s Status
+	err := c.Do(ctx, http.MethodGet, "/status", nil, &s)
+	return s, err
+}
+
+// Version is the relay's build information.
+type Version struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Version returns the relay's build information.
+func (c *Client) Version(ctx context.Context) (Version, error) {
+	var v Version
+	err := c.Do(ctx, http.MethodGet, "/version", nil, &v)
+	return v, err
+}
+
+// RouteOwner is the relay instance publishing a stream.
+type RouteOwner struct {
+	Stream     string `json:"stream"`
+	InstanceID string `json:"instance_id"`
+	URL        string `json:"url,omitempty"`
+	Local      bool   `json:"local"`
+}
+
+// Route returns the relay instance publishing stream. A stream that is not
+// live anywhere is an *APIError with StatusCode 404.
+func (c *Client) Route(ctx context.Context, stream string) (RouteOwner, error) {
+	var o RouteOwner
+	err := c.Do(ctx, http.MethodGet, "/api/route?stream="+url.QueryEscape(stream), nil, &o)
+	return o, err
+}
+
+// TranscodeProgress is the encoder progress of a transcoded session.
+type TranscodeProgress struct {
+	Frames          int64     `json:"frames"`
+	FPS             float64   `json:"fps"`
+	Speed           float64   `json:"speed"`
+	BitrateKbps     float64   `json:"bitrate_kbps"`
+	DroppedFrames   int64     `json:"dropped_frames"`
+	DuplicateFrames int64     `json:"duplicate_frames"`
+	Updated         time.Time `json:"updated"`
+}
+
+// TranscodeUsage is the CPU and memory of a transcoded session's ffmpeg
+// process.
+type TranscodeUsage struct {
+	PID        int       `json:"pid"`
+	CPUSeconds float64   `json:"cpu_seconds"`
+	CPUPercent float64   `json:"cpu_percent"` // 100 is one core
+	RSSBytes   int64     `json:"rss_bytes"`
+	Updated    time.Time `json:"updated"`
+}
+
+// StateEntry is a state a session entered and when.
+type StateEntry struct {
+	State   string    `json:"state"`
+	Entered time.Time `json:"entered"`
+}
+
+// BondLeg is the health of one upstream of a redundant push.
+type BondLeg struct {
+	Name      string `json:"name"`
+	State     string `json:"state"` // "connecting", "up" or "down"
+	SinceUnix int64  `json:"since_unix"`
+	Behind    bool   `json:"behind,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Connects  int    `json:"connects"`
+	Dropped   int64  `json:"dropped"`
+}
+
+// Connection is one active session.
+type Connection struct {
+	RequestID      string             `json:"request_id"`
+	ClientAddr     string             `json:"client_addr"`
+	Upstream       string             `json:"upstream"`
+	Stream         string             `json:"stream,omitempty"`
+	StartTime      time.Time          `json:"start_time"`
+	State          string             `json:"state"`
+	States         []StateEntry       `json:"states,omitempty"`
+	VideoCodec     string             `json:"video_codec,omitempty"`
+	Transcode      *TranscodeProgress `json:"transcode,omitempty"`
+	TranscodeUsage *TranscodeUsage    `json:"transcode_usage,omitempty"`
+	Legs           []BondLeg          `json:"legs,omitempty"`
+}
+
+// Connections returns the active sessions.
+func (c *Client) Connections(ctx context.Context) ([]Connection, error) {
+	var resp struct {
+		Connections []Connection `json:"connections"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/admin/connections", nil, &resp)
+	return resp.Connections, err
+}
+
+// KillSession ends the session with requestID. An unknown session is an
+// *APIError with StatusCode 404.
+func (c *Client) KillSession(ctx context.Context, requestID string) error {
+	return c.Do(ctx, http.MethodDelete, "/admin/connections?request_id="+url.QueryEscape(requestID), nil, nil)
+}
+
+// CircuitBreaker is the upstream circuit breaker's state.
+type CircuitBreaker struct {
+	State     string `json:"state"` // "closed", "open" or "half-open"
+	Failures  int    `json:"failures"`
+	Successes int    `json:"successes"`
+	LastFail  int64  `json:"last_fail"`
+}
+
+// CircuitBreaker returns the breaker's state, or nil when the relay runs
+// without one.
+func (c *Client) CircuitBreaker(ctx context.Context) (*CircuitBreaker, error) {
+	var resp struct {
+		CircuitBreaker *CircuitBreaker `json:"circuit_breaker"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/admin/circuit-breaker", nil, &resp)
+	return resp.CircuitBreaker, err
+}
+
+// ResetCircuitBreaker closes the breaker.
+func (c *Client) ResetCircuitBreaker(ctx context.Context) error {
+	return c.Do(ctx, http.MethodPost, "/admin/circuit-breaker/reset", nil, nil)
+}
+
+// TranscodeSwitch is the transcoding kill switch's state.
+type TranscodeSwitch struct {
+	Disabled        bool     `json:"disabled"`
+	DisabledTenants []string `json:"disabled_tenants"`
+	Fallback        string   `json:"fallback"` // "passthrough" or "reject"
+}
+
+// TranscodeSwitch returns the kill switch's state.
+func (c *Client) TranscodeSwitch(ctx context.Context) (TranscodeSwitch, error) {
+	var resp struct {
+		KillSwitch TranscodeSwitch `json:"kill_switch"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/admin/transcode", nil, &resp)
+	return resp.KillSwitch, err
+}
+
+// SetTranscode switches transcoding off or back on for tenant, or for every
+// session when tenant is empty, and returns the resulting state.
+func (c *Client) SetTranscode(ctx context.Context, tenant string, disabled bool) (TranscodeSwitch, error) {
+	var resp struct {
+		KillSwitch TranscodeSwitch `json:"kill_switch"`
+	}
+	body := map[string]any{"disabled": disabled}
+	if tenant != "" {
+		body["tenant"] = tenant
+	}
+	err := c.Do(ctx, http.MethodPost, "/admin/transcode", body, &resp)
+	return resp.KillSwitch, err
+}
+
+// Capabilities is what the relay can transcode with, probed at startup.
+type Capabilities struct {
+	ProbedAt time.Time           `json:"probed_at"`
+	FFmpeg   BackendCapabilities `json:"ffmpeg"`
+	LibAV    BackendCapabilities `json:"libav"`
+	Devices  []string            `json:"devices"` // GPU device nodes, e.g. /dev/dri/renderD128
+}
+
+// BackendCapabilities describes one transcode backend, ffmpeg or libav.
+type BackendCapabilities struct {
+	Available bool              `json:"available"`
+	Error     string            `json:"error,omitempty"` // Why the backend is not available
+	Version   string            `json:"version,omitempty"`
+	Libraries map[string]string `json:"libraries,omitempty"`
+	Encoders  []Encoder         `json:"encoders,omitempty"`
+	HWAccels  []string          `json:"hwaccels,omitempty"`
+}
+
+// Encoder is one encoder a backend offers.
+type Encoder struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // "video", "audio" or "subtitle"
+	Hardware bool   `json:"hardware"`
+}
+
+// Capabilities returns the encoders, hardware acceleration methods and
+// ffmpeg/libav versions the relay found at startup. Relays with
+// transcoding disabled answer 404.
+func (c *Client) Capabilities(ctx context.Context) (Capabilities, error) {
+	var caps Capabilities
+	err := c.Do(ctx, http.MethodGet, "/admin/capabilities", nil, &caps)
+	return caps, err
+}
+
+// DrainStatus is the state of the maintenance drain.
+type DrainStatus struct {
+	Draining       bool   `json:"draining"`
+	SinceUnix      int64  `json:"since_unix,omitempty"`     // When the current drain began
+	ScheduledUnix  int64  `json:"scheduled_unix,omitempty"` // When a scheduled drain will begin
+	Code           string `json:"code"`
+	Description    string `json:"description"`
+	ActiveSessions int    `json:"active_sessions"`
+}
+
+// Drain returns the maintenance drain's state.
+func (c *Client) Drain(ctx context.Context) (DrainStatus, error) {
+	var resp struct {
+		Drain DrainStatus `json:"drain"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/admin/drain", nil, &resp)
+	return resp.Drain, err
+}
+
+// StartDrain makes the relay turn new sessions away from at, or at once
+// when at is zero, and returns the resulting state.
+func (c *Client) StartDrain(ctx context.Context, at time.Time) (DrainStatus, error) {
+	var resp struct {
+		Drain DrainStatus `json:"drain"`
+	}
+	var body any
+	if !at.IsZero() {
+		body = map[string]any{"at": at.Unix()}
+	}
+	err := c.Do(ctx, http.MethodPost, "/admin/drain", body, &resp)
+	return resp.Drain, err
+}
+
+// StopDrain ends or cancels the maintenance drain.
+func (c *Client) StopDrain(ctx context.Context) (DrainStatus, error) {
+	var resp struct {
+		Drain DrainStatus `json:"drain"`
+	}
+	err := c.Do(ctx, http.MethodPost, "/admin/undrain", nil, &resp)
+	return resp.Drain, err
+}
+
+// PlaybackToken is a signed token letting players fetch one stream.
+type PlaybackToken struct {
+	Stream      string `json:"stream"`
+	Token       string `json:"token"`
+	ExpiresUnix int64  `json:"expires"`
+}
+
+// PlaybackToken mints a playback token for stream valid for ttl, or for the
+// relay's configured lifetime when ttl is zero. The client needs a publish
+// token of the stream's app; see WithToken.
+func (c *Client) PlaybackToken(ctx context.Context, stream string, ttl time.Duration) (PlaybackToken, error) {
+	body := map[string]any{"stream": stream}
+	if ttl > 0 {
+		body["ttl"] = ttl.String()
+	}
+	var resp PlaybackToken
+	err := c.Do(ctx, http.MethodPost, "/admin/playback-tokens", body, &resp)
+	return resp, err
+}
+
+// Violation is one breach of the RTMP specification.
+type Violation struct {
+	Rule      string `json:"rule"`
+	Detail    string `json:"detail"`
+	Message   int    `json:"message"` // 1-based index of the offending message
+	TypeID    uint8  `json:"type_id"`
+	CSID      uint32 `json:"csid"`
+	Timestamp uint32 `json:"timestamp"`
+}
+
+// SessionCompliance is a strict-mode compliance report of one session.
+type SessionCompliance struct {
+	RequestID  string         `json:"request_id"`
+	Active     bool           `json:"active"`
+	Messages   int            `json:"messages"`
+	Compliant  bool           `json:"compliant"`
+	Counts     map[string]int `json:"counts,omitempty"`
+	Violations []Violation    `json:"violations,omitempty"`
+}
+
+// Compliance returns the reports of running sessions and of the most
+// recent finished ones.
+func (c *Client) Compliance(ctx context.Context) ([]SessionCompliance, error) {
+	var resp struct {
+		Reports []SessionCompliance `json:"reports"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/admin/compliance", nil, &resp)
+	return resp.Reports, err
+}
+
+// ComplianceFor returns the report of one session.
+func (c *Client) ComplianceFor(ctx context.Context, requestID string) (SessionCompliance, error) {
+	var r SessionCompliance
+	err := c.Do(ctx, http.MethodGet, "/admin/compliance?request_id="+url.QueryEscape(requestID), nil, &r)
+	return r, err
+}
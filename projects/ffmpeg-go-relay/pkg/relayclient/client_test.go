@@ -0,0 +1,157 @@
+package relayclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"ffmpeg-go-relay/internal/auth"
+	"ffmpeg-go-relay/internal/circuit"
+	"ffmpeg-go-relay/internal/config"
+	"ffmpeg-go-relay/internal/events"
+	"ffmpeg-go-relay/internal/httpserver"
+	"ffmpeg-go-relay/internal/logger"
+	"ffmpeg-go-relay/internal/relay"
+	"ffmpeg-go-relay/internal/transcoder"
+)
+
+// startRelayAPI serves the relay's real HTTP API on a local port.
+func startRelayAPI(t *testing.T, stats *httpserver.RelayStats) *Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httpserver.New("", logger.NewWithWriter(io.Discard), stats, nil)
+	done := make(chan struct{})
+	go func() {
+		srv.Serve(ctx, ln)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return New(ln.Addr().String(), nil)
+}
+
+func TestClientAdminCalls(t *testing.T) {
+	breaker := circuit.New(1, time.Minute, 1)
+	breaker.Call(func() error { return errors.New("fail") })
+	c := startRelayAPI(t, &httpserver.RelayStats{
+		CircuitBreaker: breaker,
+		Transcode:      transcoder.NewKillSwitch(config.TranscodeKillSwitchConfig{}),
+		Drain:          relay.NewDrain(config.DrainConfig{}),
+		Capabilities: &transcoder.Capabilities{FFmpeg: transcoder.BackendCapabilities{
+			Available: true,
+			Encoders:  []transcoder.Encoder{{Name: "hevc_vaapi", Type: "video", Hardware: true}},
+		}},
+	})
+	ctx := context.Background()
+
+	cb, err := c.CircuitBreaker(ctx)
+	if err != nil || cb == nil || cb.State != "open" {
+		t.Fatalf("CircuitBreaker = %+v, %v; want open", cb, err)
+	}
+	if err := c.ResetCircuitBreaker(ctx); err != nil {
+		t.Fatalf("ResetCircuitBreaker: %v", err)
+	}
+	if breaker.State() != circuit.Closed {
+		t.Fatal("breaker still open after reset")
+	}
+
+	sw, err := c.SetTranscode(ctx, "acme", true)
+	if err != nil || len(sw.DisabledTenants) != 1 || sw.DisabledTenants[0] != "acme" {
+		t.Fatalf("SetTranscode = %+v, %v", sw, err)
+	}
+
+	caps, err := c.Capabilities(ctx)
+	if err != nil || !caps.FFmpeg.Available || len(caps.FFmpeg.Encoders) != 1 || caps.FFmpeg.Encoders[0].Name != "hevc_vaapi" {
+		t.Fatalf("Capabilities = %+v, %v", caps, err)
+	}
+
+	ready, err := c.Ready(ctx)
+	if err != nil || !ready.Ready {
+		t.Fatalf("Ready = %+v, %v", ready, err)
+	}
+	if v, err := c.Version(ctx); err != nil || v.GoVersion == "" {
+		t.Fatalf("Version = %+v, %v", v, err)
+	}
+	if conns, err := c.Connections(ctx); err != nil || len(conns) != 0 {
+		t.Fatalf("Connections = %v, %v", conns, err)
+	}
+
+	err = c.KillSession(ctx, "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message == "" {
+		t.Fatalf("KillSession(missing) = %v, want a 404 APIError", err)
+	}
+
+	if d, err := c.StartDrain(ctx, time.Now().Add(time.Hour)); err != nil || d.Draining || d.ScheduledUnix == 0 {
+		t.Fatalf("StartDrain(later) = %+v, %v; want a drain scheduled", d, err)
+	}
+	if d, err := c.StartDrain(ctx, time.Time{}); err != nil || !d.Draining {
+		t.Fatalf("StartDrain = %+v, %v", d, err)
+	}
+	if ready, err := c.Ready(ctx); err != nil || ready.Ready || !ready.Draining {
+		t.Fatalf("Ready while draining = %+v, %v", ready, err)
+	}
+	if d, err := c.StopDrain(ctx); err != nil || d.Draining {
+		t.Fatalf("StopDrain = %+v, %v", d, err)
+	}
+}
+
+func TestClientPlaybackToken(t *testing.T) {
+	signer, err := auth.NewPlaybackSigner([]string{base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startRelayAPI(t, &httpserver.RelayStats{PlaybackTokens: signer, Auth: auth.NewTokenAuthenticator([]string{"secret"})})
+	var apiErr *APIError
+	if _, err := c.PlaybackToken(context.Background(), "cam", time.Minute); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("PlaybackToken without a token = %v, want 401", err)
+	}
+	c = c.WithToken("secret")
+	tok, err := c.PlaybackToken(context.Background(), "cam", time.Minute)
+	if err != nil || tok.Stream != "cam" {
+		t.Fatalf("PlaybackToken = %+v, %v", tok, err)
+	}
+	if left := time.Until(time.Unix(tok.ExpiresUnix, 0)); left > time.Minute || left < 58*time.Second {
+		t.Fatalf("token expires in %v, want a minute", left)
+	}
+	if err := signer.Verify(tok.Token, "cam", time.Now()); err != nil {
+		t.Fatalf("minted token does not verify: %v", err)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	bus := events.New()
+	c := startRelayAPI(t, &httpserver.RelayStats{Events: bus})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := c.Events(ctx, events.TypeCircuitBreaker)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	defer stream.Close()
+	for bus.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(events.Event{Type: events.TypeConnectionStart})
+	bus.Publish(events.Event{Type: events.TypeCircuitBreaker, From: "closed", State: "open"})
+
+	e, err := stream.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if e.Type != events.TypeCircuitBreaker || e.From != "closed" || e.State != "open" || e.ID != 2 {
+		t.Fatalf("event = %+v", e)
+	}
+}
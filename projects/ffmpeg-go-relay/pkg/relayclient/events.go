@@ -0,0 +1,103 @@
+package relayclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EventDropped is the Type of the event a relay sends to a reader that fell
+// behind; Dropped says how many events it missed.
+const EventDropped = "dropped"
+
+// Event is one entry of the /admin/events stream.
+type Event struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	From       string    `json:"from,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Dropped    int64     `json:"dropped,omitempty"`
+}
+
+// EventStream reads events as the relay sends them.
+type EventStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// Events subscribes to the relay's live events, limited to types when any
+// are given. The stream ends when ctx is done or Close is called. The
+// client's http.Client must not have a Timeout, which would cut the stream
+// off.
+func (c *Client) Events(ctx context.Context, types ...string) (*EventStream, error) {
+	path := "/admin/events"
+	if len(types) > 0 {
+		path += "?type=" + url.QueryEscape(strings.Join(types, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, apiError(http.MethodGet, path, responseStatus{resp.StatusCode, resp.Status}, data)
+	}
+	return &EventStream{body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next blocks until the next event arrives. Keepalive comments are skipped.
+func (s *EventStream) Next() (Event, error) {
+	var typ, data string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return Event{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data == "" {
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return Event{}, fmt.Errorf("decode event: %w", err)
+			}
+			if e.Type == "" {
+				e.Type = typ
+			}
+			return e, nil
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			typ = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+// Close ends the stream.
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -11,31 +11,30 @@ import (
 	"ffmpeg-go-relay/internal/pool"
 	"ffmpeg-go-relay/internal/relay"
 	"ffmpeg-go-relay/internal/retry"
+	"ffmpeg-go-relay/internal/rtmptest"
 )
 
 // BenchmarkRelayThroughput measures bytes/sec throughput
 func BenchmarkRelayThroughput(b *testing.B) {
-	upstream, _ := newMockUpstreamServer("127.0.0.1:0")
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(b, rtmptest.Script{})
 
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
 	defer listener.Close()
 
 	log := logger.New()
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    64 * 1024,
-		WriteBuf:   64 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  64 * 1024,
+		WriteBuf: 64 * 1024,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	go server.Run(ctx)
-	time.Sleep(100 * time.Millisecond)
+	waitReady(b, server)
 
 	b.ResetTimer()
 
@@ -107,9 +106,7 @@ func BenchmarkRetryLogic(b *testing.B) {
 
 // BenchmarkRelayWithPool measures relay performance with buffer pooling
 func BenchmarkRelayWithPool(b *testing.B) {
-	upstream, _ := newMockUpstreamServer("127.0.0.1:0")
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(b, rtmptest.Script{})
 
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
 	defer listener.Close()
@@ -118,19 +115,19 @@ func BenchmarkRelayWithPool(b *testing.B) {
 	bufPool := pool.New(64 * 1024)
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    64 * 1024,
-		WriteBuf:   64 * 1024,
-		BufPool:    bufPool,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  64 * 1024,
+		WriteBuf: 64 * 1024,
+		BufPool:  bufPool,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	go server.Run(ctx)
-	time.Sleep(100 * time.Millisecond)
+	waitReady(b, server)
 
 	b.ResetTimer()
 
@@ -146,9 +143,7 @@ func BenchmarkRelayWithPool(b *testing.B) {
 
 // BenchmarkRelayWithCircuitBreaker measures circuit breaker overhead
 func BenchmarkRelayWithCircuitBreaker(b *testing.B) {
-	upstream, _ := newMockUpstreamServer("127.0.0.1:0")
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(b, rtmptest.Script{})
 
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
 	defer listener.Close()
@@ -157,8 +152,8 @@ func BenchmarkRelayWithCircuitBreaker(b *testing.B) {
 	breaker := circuit.New(5, 30*time.Second, 1)
 
 	server := &relay.Server{
-		ListenAddr:     listener.Addr().String(),
-		Upstream:       upstream.addr,
+		Listener:       listener,
+		Upstream:       upstream.Addr(),
 		Log:            log,
 		ReadBuf:        64 * 1024,
 		WriteBuf:       64 * 1024,
@@ -169,7 +164,7 @@ func BenchmarkRelayWithCircuitBreaker(b *testing.B) {
 	defer cancel()
 
 	go server.Run(ctx)
-	time.Sleep(100 * time.Millisecond)
+	waitReady(b, server)
 
 	b.ResetTimer()
 
@@ -185,9 +180,7 @@ func BenchmarkRelayWithCircuitBreaker(b *testing.B) {
 
 // BenchmarkConnectionSetup measures connection setup time
 func BenchmarkConnectionSetup(b *testing.B) {
-	upstream, _ := newMockUpstreamServer("127.0.0.1:0")
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(b, rtmptest.Script{})
 
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
 	defer listener.Close()
@@ -195,18 +188,18 @@ func BenchmarkConnectionSetup(b *testing.B) {
 	log := logger.New()
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    64 * 1024,
-		WriteBuf:   64 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  64 * 1024,
+		WriteBuf: 64 * 1024,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	go server.Run(ctx)
-	time.Sleep(100 * time.Millisecond)
+	waitReady(b, server)
 
 	b.ResetTimer()
 
@@ -222,9 +215,7 @@ func BenchmarkConnectionSetup(b *testing.B) {
 
 // BenchmarkMemoryAllocation measures total memory allocations
 func BenchmarkMemoryAllocation(b *testing.B) {
-	upstream, _ := newMockUpstreamServer("127.0.0.1:0")
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(b, rtmptest.Script{})
 
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
 	defer listener.Close()
@@ -232,18 +223,18 @@ func BenchmarkMemoryAllocation(b *testing.B) {
 	log := logger.New()
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    64 * 1024,
-		WriteBuf:   64 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  64 * 1024,
+		WriteBuf: 64 * 1024,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	go server.Run(ctx)
-	time.Sleep(100 * time.Millisecond)
+	waitReady(b, server)
 
 	b.ReportAllocs()
 	b.ResetTimer()
@@ -2,8 +2,8 @@ package test
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"sync"
 	"testing"
@@ -16,65 +16,41 @@ import (
 	"ffmpeg-go-relay/internal/pool"
 	"ffmpeg-go-relay/internal/relay"
 	"ffmpeg-go-relay/internal/retry"
+	"ffmpeg-go-relay/internal/rtmp"
+	"ffmpeg-go-relay/internal/rtmptest"
 )
 
-// Note: We use io.Copy in mockUpstreamServer to test echo behavior
-
-// mockUpstreamServer simulates an RTMP upstream server
-type mockUpstreamServer struct {
-	addr     string
-	listener net.Listener
-	done     chan struct{}
-}
-
-func newMockUpstreamServer(addr string) (*mockUpstreamServer, error) {
-	listener, err := net.Listen("tcp", addr)
+// publish connects to the relay at addr, publishes stream on app and sends
+// a keyframe. The connection closes when the test ends.
+func publish(t *testing.T, addr, app, stream string) *rtmptest.Client {
+	t.Helper()
+	client, err := rtmptest.Dial(addr, app)
 	if err != nil {
-		return nil, err
+		t.Fatalf("connect through relay: %v", err)
 	}
-	return &mockUpstreamServer{
-		addr:     listener.Addr().String(),
-		listener: listener,
-		done:     make(chan struct{}),
-	}, nil
-}
-
-func (m *mockUpstreamServer) start() {
-	go func() {
-		for {
-			select {
-			case <-m.done:
-				return
-			default:
-			}
-			conn, err := m.listener.Accept()
-			if err != nil {
-				return
-			}
-			go m.handleConn(conn)
-		}
-	}()
-}
-
-func (m *mockUpstreamServer) handleConn(conn net.Conn) {
-	defer conn.Close()
-	// Echo any data received back to client
-	io.Copy(conn, conn)
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Publish(stream); err != nil {
+		t.Fatalf("publish through relay: %v", err)
+	}
+	if err := client.WriteVideo(0, true); err != nil {
+		t.Fatalf("write video: %v", err)
+	}
+	return client
 }
 
-func (m *mockUpstreamServer) close() {
-	close(m.done)
-	m.listener.Close()
+// waitReady blocks until server is accepting sessions.
+func waitReady(tb testing.TB, server *relay.Server) {
+	tb.Helper()
+	select {
+	case <-server.Ready():
+	case <-time.After(5 * time.Second):
+		tb.Fatal("relay did not start listening")
+	}
 }
 
 func TestRelayBasicConnection(t *testing.T) {
-	// Start mock upstream
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	// Start fake upstream
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	// Start relay server
 	relayAddr := "127.0.0.1:0"
@@ -86,11 +62,11 @@ func TestRelayBasicConnection(t *testing.T) {
 
 	log := logger.New()
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
 	}
 
 	// Run relay in background
@@ -102,31 +78,11 @@ func TestRelayBasicConnection(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	// Give relay time to start
-	time.Sleep(100 * time.Millisecond)
-
-	// Connect to relay and send data
-	client, err := net.Dial("tcp", listener.Addr().String())
-	if err != nil {
-		t.Fatalf("failed to connect to relay: %v", err)
-	}
-	defer client.Close()
-
-	// Send test data
-	testData := []byte("hello world")
-	_, err = client.Write(testData)
-	if err != nil {
-		t.Fatalf("failed to write: %v", err)
-	}
+	waitReady(t, server)
 
-	// Read echo back
-	buf := make([]byte, len(testData))
-	client.SetReadDeadline(time.Now().Add(1 * time.Second))
-	n, err := client.Read(buf)
-	if err != nil && err != io.EOF {
-		// Connection might close during read, but that's acceptable in this test
-		t.Logf("read result: bytes=%d, err=%v", n, err)
-	}
+	// Publish through the relay
+	publish(t, listener.Addr().String(), "live", "cam1")
+	upstream.Expect(t, "connect live", "publish cam1", "video 0")
 
 	// Cancel context to shutdown
 	cancel()
@@ -134,12 +90,7 @@ func TestRelayBasicConnection(t *testing.T) {
 }
 
 func TestRelayWithBufferPool(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -151,12 +102,12 @@ func TestRelayWithBufferPool(t *testing.T) {
 	bufPool := pool.New(8192)
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    8192,
-		WriteBuf:   8192,
-		BufPool:    bufPool,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  8192,
+		WriteBuf: 8192,
+		BufPool:  bufPool,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -167,26 +118,18 @@ func TestRelayWithBufferPool(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
-	// Quick connection to verify pool works
-	client, err := net.Dial("tcp", listener.Addr().String())
-	if err != nil {
-		t.Fatalf("failed to connect: %v", err)
-	}
-	client.Close()
+	// Relay a publish through pooled buffers
+	publish(t, listener.Addr().String(), "live", "cam1")
+	upstream.Expect(t, "connect live", "publish cam1", "video 0")
 
 	cancel()
 	<-done
 }
 
 func TestRelayWithRateLimiting(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -198,12 +141,12 @@ func TestRelayWithRateLimiting(t *testing.T) {
 	rateLimiter := middleware.NewRateLimiter(2.0, 2) // 2 req/sec with burst of 2
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
-		RateLimit:  rateLimiter,
+		Listener:  listener,
+		Upstream:  upstream.Addr(),
+		Log:       log,
+		ReadBuf:   4 * 1024,
+		WriteBuf:  4 * 1024,
+		RateLimit: rateLimiter,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -214,7 +157,7 @@ func TestRelayWithRateLimiting(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
 	// First 2 connections should succeed (burst)
 	for i := 0; i < 2; i++ {
@@ -237,12 +180,7 @@ func TestRelayWithRateLimiting(t *testing.T) {
 }
 
 func TestRelayWithConnectionLimiting(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -254,12 +192,12 @@ func TestRelayWithConnectionLimiting(t *testing.T) {
 	connLimiter := middleware.NewConnectionLimiter(2, 2) // Max 2 total, 2 per IP
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
-		ConnLimit:  connLimiter,
+		Listener:  listener,
+		Upstream:  upstream.Addr(),
+		Log:       log,
+		ReadBuf:   4 * 1024,
+		WriteBuf:  4 * 1024,
+		ConnLimit: connLimiter,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -270,7 +208,7 @@ func TestRelayWithConnectionLimiting(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
 	// Keep 2 connections open
 	clients := make([]net.Conn, 0)
@@ -299,12 +237,7 @@ func TestRelayWithConnectionLimiting(t *testing.T) {
 }
 
 func TestRelayWithAuthentication(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -316,12 +249,12 @@ func TestRelayWithAuthentication(t *testing.T) {
 	authenticator := auth.NewTokenAuthenticator([]string{"valid-token-123"})
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
-		Auth:       authenticator,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
+		Auth:     authenticator,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -332,18 +265,16 @@ func TestRelayWithAuthentication(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
-	// Connection without auth should fail or need auth
-	client, err := net.Dial("tcp", listener.Addr().String())
-	if err != nil {
-		t.Fatalf("failed to connect: %v", err)
+	// A connect without a valid token never reaches the upstream
+	if client, err := rtmptest.Dial(listener.Addr().String(), "live"); err == nil {
+		client.Close()
+		t.Fatal("connect without a token succeeded")
 	}
-
-	// Try to write - might be rejected
-	client.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
-	client.Write([]byte("test"))
-	client.Close()
+	// The app carries the token
+	publish(t, listener.Addr().String(), "valid-token-123", "cam1")
+	upstream.Expect(t, "connect valid-token-123", "publish cam1", "video 0")
 
 	cancel()
 	<-done
@@ -363,7 +294,7 @@ func TestRelayWithCircuitBreaker(t *testing.T) {
 	breaker := circuit.New(2, 100*time.Millisecond, 1)
 
 	server := &relay.Server{
-		ListenAddr:     listener.Addr().String(),
+		Listener:       listener,
 		Upstream:       upstreamAddr,
 		Log:            log,
 		ReadBuf:        4 * 1024,
@@ -379,7 +310,7 @@ func TestRelayWithCircuitBreaker(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
 	// Make connections - should fail trying to connect to upstream
 	for i := 0; i < 3; i++ {
@@ -401,20 +332,7 @@ func TestRelayWithCircuitBreaker(t *testing.T) {
 }
 
 func TestRelayWithRetry(t *testing.T) {
-	// Create upstream that only starts after a delay
-	upstreamReady := make(chan string, 1)
-
-	go func() {
-		time.Sleep(200 * time.Millisecond)
-		upstream, err := newMockUpstreamServer("127.0.0.1:0")
-		if err != nil {
-			return
-		}
-		upstream.start()
-		upstreamReady <- upstream.addr
-		time.Sleep(5 * time.Second)
-		upstream.close()
-	}()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -425,11 +343,11 @@ func TestRelayWithRetry(t *testing.T) {
 	log := logger.New()
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   <-upstreamReady, // Wait for upstream to be ready
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
 		RetryConfig: retry.Config{
 			MaxAttempts:  3,
 			InitialDelay: 100 * time.Millisecond,
@@ -446,27 +364,17 @@ func TestRelayWithRetry(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
-	// Connection should work after retry
-	client, err := net.Dial("tcp", listener.Addr().String())
-	if err != nil {
-		t.Logf("connection failed (may be timing issue): %v", err)
-	} else {
-		client.Close()
-	}
+	publish(t, listener.Addr().String(), "live", "cam1")
+	upstream.Expect(t, "connect live", "publish cam1", "video 0")
 
 	cancel()
 	<-done
 }
 
 func TestRelayGracefulShutdown(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -477,11 +385,11 @@ func TestRelayGracefulShutdown(t *testing.T) {
 	log := logger.New()
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -492,16 +400,11 @@ func TestRelayGracefulShutdown(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
-
-	// Create a connection
-	client, err := net.Dial("tcp", listener.Addr().String())
-	if err != nil {
-		t.Fatalf("failed to connect: %v", err)
-	}
+	waitReady(t, server)
 
-	// Send some data
-	client.Write([]byte("test"))
+	// Start a publish
+	client := publish(t, listener.Addr().String(), "live", "cam1")
+	upstream.Expect(t, "connect live", "publish cam1", "video 0")
 
 	// Cancel context - should trigger graceful shutdown
 	cancel()
@@ -516,12 +419,7 @@ func TestRelayGracefulShutdown(t *testing.T) {
 }
 
 func TestMultipleRelayConnections(t *testing.T) {
-	upstream, err := newMockUpstreamServer("127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to create upstream: %v", err)
-	}
-	upstream.start()
-	defer upstream.close()
+	upstream := rtmptest.NewServer(t, rtmptest.Script{})
 
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -532,11 +430,11 @@ func TestMultipleRelayConnections(t *testing.T) {
 	log := logger.New()
 
 	server := &relay.Server{
-		ListenAddr: listener.Addr().String(),
-		Upstream:   upstream.addr,
-		Log:        log,
-		ReadBuf:    4 * 1024,
-		WriteBuf:   4 * 1024,
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      log,
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -547,34 +445,105 @@ func TestMultipleRelayConnections(t *testing.T) {
 		done <- server.Run(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, server)
 
-	// Create multiple concurrent connections
+	// Publish several streams at once
 	var wg sync.WaitGroup
 	numConns := 10
+	errs := make(chan error, numConns)
 
 	for i := 0; i < numConns; i++ {
 		wg.Add(1)
 		go func(idx int) {
 			defer wg.Done()
-			client, err := net.Dial("tcp", listener.Addr().String())
+			client, err := rtmptest.Dial(listener.Addr().String(), "live")
 			if err != nil {
+				errs <- err
 				return
 			}
-			defer client.Close()
-
-			// Send data
-			data := fmt.Sprintf("test-%d", idx)
-			client.Write([]byte(data))
-
-			// Try to read echo
-			buf := make([]byte, len(data))
-			client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-			client.Read(buf)
+			t.Cleanup(func() { client.Close() })
+			if _, err := client.Publish(fmt.Sprintf("cam%d", idx)); err != nil {
+				errs <- err
+			}
 		}(i)
 	}
 
 	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("publish through relay: %v", err)
+	}
+	published := map[string]bool{}
+	for len(published) < numConns {
+		e := upstream.WaitFor(t, func(e rtmptest.Event) bool { return e.Step == rtmptest.StepPublish })
+		published[e.Stream] = true
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRelayUpstreamFailures(t *testing.T) {
+	upstream := rtmptest.NewServer(t, rtmptest.Script{
+		Publish: func(stream string) string { return stream + " is already live" },
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	server := &relay.Server{
+		Listener: listener,
+		Upstream: upstream.Addr(),
+		Log:      logger.New(),
+		ReadBuf:  4 * 1024,
+		WriteBuf: 4 * 1024,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	waitReady(t, server)
+
+	// The upstream's refusal reaches the publisher
+	client, err := rtmptest.Dial(listener.Addr().String(), "live")
+	if err != nil {
+		t.Fatalf("connect through relay: %v", err)
+	}
+	var se *rtmp.StatusError
+	if _, err := client.Publish("cam1"); !errors.As(err, &se) || se.Code != "NetStream.Publish.Rejected" {
+		t.Fatalf("publish = %v, want the upstream's rejection", err)
+	}
+	client.Close()
+
+	// An upstream that drops the handshake ends the session
+	upstream.SetScript(rtmptest.Script{FailAt: rtmptest.StepHandshake})
+	if client, err := rtmptest.Dial(listener.Addr().String(), "live"); err == nil {
+		client.Close()
+		t.Fatal("connect succeeded with the upstream failing its handshake")
+	}
+
+	// So does one that stops reading mid-stream
+	upstream.SetScript(rtmptest.Script{FailAt: rtmptest.StepMedia, FailAfter: 1})
+	client = publish(t, listener.Addr().String(), "live", "cam2")
+	upstream.WaitFor(t, func(e rtmptest.Event) bool { return e.Step == rtmptest.StepClosed && e.Err != nil })
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := client.ReadMessage(); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("relay kept the publisher after the upstream dropped")
+			}
+			break
+		}
+	}
 
 	cancel()
 	<-done